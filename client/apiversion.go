@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// APIVersion is the version of the internal API this client speaks, sent
+// with every request. GitLab answers with the range of versions it supports,
+// so that a gitlab-shell too old or too new for it fails with a clear error.
+const APIVersion = 1
+
+const (
+	apiVersionHeader    = "Gitlab-Shell-Api-Version"
+	apiMinVersionHeader = "Gitlab-Shell-Api-Min-Version"
+	apiMaxVersionHeader = "Gitlab-Shell-Api-Max-Version"
+)
+
+// ErrAPIVersionMismatch is returned when GitLab doesn't support APIVersion
+var ErrAPIVersionMismatch = errors.New("internal API version mismatch")
+
+// checkAPIVersion fails if response advertises a range of versions of the
+// internal API that excludes APIVersion. Versions of GitLab that advertise
+// none are assumed to be compatible.
+func checkAPIVersion(response *http.Response) error {
+	minVersion, err := versionHeader(response, apiMinVersionHeader, 0)
+	if err != nil {
+		return err
+	}
+
+	maxVersion, err := versionHeader(response, apiMaxVersionHeader, APIVersion)
+	if err != nil {
+		return err
+	}
+
+	if APIVersion < minVersion || APIVersion > maxVersion {
+		return fmt.Errorf("%w: gitlab-shell speaks version %d, GitLab supports versions %d to %d, upgrade the older of the two",
+			ErrAPIVersionMismatch, APIVersion, minVersion, maxVersion)
+	}
+
+	return nil
+}
+
+func versionHeader(response *http.Response, name string, fallback int) (int, error) {
+	value := response.Header.Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid %s %q", ErrAPIVersionMismatch, name, value)
+	}
+
+	return version, nil
+}
+
+type strictResponsesKey struct{}
+
+// WithStrictResponses makes StrictResponses report the responses of the
+// client, so that their fields unknown to gitlab-shell are errors rather than
+// ignored
+func WithStrictResponses() HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.strictResponses = true
+	}
+}
+
+// StrictResponses reports whether response was received by a client created
+// WithStrictResponses
+func StrictResponses(response *http.Response) bool {
+	if response == nil || response.Request == nil {
+		return false
+	}
+
+	strict, _ := response.Request.Context().Value(strictResponsesKey{}).(bool)
+
+	return strict
+}
+
+func withStrictResponses(ctx context.Context) context.Context {
+	return context.WithValue(ctx, strictResponsesKey{}, true)
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIVersionNegotiation(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		min, max      string
+		expectedError string
+	}{
+		{
+			desc: "a GitLab that doesn't advertise versions",
+		},
+		{
+			desc: "a supported version",
+			min:  strconv.Itoa(APIVersion),
+			max:  strconv.Itoa(APIVersion + 1),
+		},
+		{
+			desc:          "a too old gitlab-shell",
+			min:           strconv.Itoa(APIVersion + 1),
+			max:           strconv.Itoa(APIVersion + 2),
+			expectedError: "internal API version mismatch: gitlab-shell speaks version 1, GitLab supports versions 2 to 3, upgrade the older of the two",
+		},
+		{
+			desc:          "a too new gitlab-shell",
+			max:           strconv.Itoa(APIVersion - 1),
+			expectedError: "internal API version mismatch: gitlab-shell speaks version 1, GitLab supports versions 0 to 0, upgrade the older of the two",
+		},
+		{
+			desc:          "an invalid version",
+			max:           "v4",
+			expectedError: `internal API version mismatch: invalid Gitlab-Shell-Api-Max-Version "v4"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, strconv.Itoa(APIVersion), r.Header.Get(apiVersionHeader))
+
+				if tc.min != "" {
+					w.Header().Set(apiMinVersionHeader, tc.min)
+				}
+				if tc.max != "" {
+					w.Header().Set(apiMaxVersionHeader, tc.max)
+				}
+				// A mismatch is reported rather than the failure it causes
+				if tc.expectedError != "" {
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			t.Cleanup(server.Close)
+
+			httpClient, err := NewHTTPClientWithOpts(server.URL, "", "", "", 1, nil)
+			require.NoError(t, err)
+
+			client, err := NewGitlabNetClient("", "", "", httpClient)
+			require.NoError(t, err)
+
+			response, err := client.Get(context.Background(), "/check")
+			if tc.expectedError != "" {
+				require.EqualError(t, err, tc.expectedError)
+				require.ErrorIs(t, err, ErrAPIVersionMismatch)
+				return
+			}
+
+			require.NoError(t, err)
+			require.NoError(t, response.Body.Close())
+		})
+	}
+}
+
+func TestStrictResponses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	for _, strict := range []bool{false, true} {
+		var opts []HTTPClientOpt
+		if strict {
+			opts = append(opts, WithStrictResponses())
+		}
+
+		httpClient, err := NewHTTPClientWithOpts(server.URL, "", "", "", 1, opts)
+		require.NoError(t, err)
+
+		client, err := NewGitlabNetClient("", "", "", httpClient)
+		require.NoError(t, err)
+
+		response, err := client.Get(context.Background(), "/check")
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+
+		require.Equal(t, strict, StrictResponses(response))
+	}
+
+	require.False(t, StrictResponses(nil))
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// AttemptObserver is called once a request made through GitlabNetClient has
+// finished, with the total number of attempts it took including the final
+// one, whether that succeeded or not
+type AttemptObserver func(request *http.Request, attempts int)
+
+// WithAttemptObserver reports the number of attempts each request took to
+// observer. Requests that fail before reaching the transport, for example
+// because their context was canceled, are reported with zero attempts.
+func WithAttemptObserver(observer AttemptObserver) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.attemptObserver = observer
+	}
+}
+
+type attemptCounterContextKey struct{}
+
+type attemptCountingTransport struct {
+	next http.RoundTripper
+}
+
+func newAttemptCountingTransport(next http.RoundTripper, enabled bool) http.RoundTripper {
+	if !enabled {
+		return next
+	}
+
+	return &attemptCountingTransport{next: next}
+}
+
+func (rt *attemptCountingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if counter, ok := request.Context().Value(attemptCounterContextKey{}).(*atomic.Int32); ok {
+		counter.Add(1)
+	}
+
+	return rt.next.RoundTrip(request)
+}
+
+// do performs request with the retries of its endpoint, reporting the attempts
+// it took to the configured AttemptObserver and Metrics, and logging it if
+// it's slow
+func (c *HTTPClient) do(request *retryablehttp.Request) (*http.Response, error) {
+	if c.endpointPolicies != nil {
+		request = request.WithContext(c.endpointPolicies.withRetries(request.Context(), request.URL.Path))
+	} else if hasRetryBudget(request.Context()) {
+		request = request.WithContext(withRetries(request.Context(), c.RetryableHTTP.RetryMax))
+	}
+
+	if c.attemptObserver == nil && c.metrics == nil && c.slowRequestThreshold <= 0 {
+		return c.RetryableHTTP.Do(request)
+	}
+
+	start := time.Now()
+	counter := &atomic.Int32{}
+	request = request.WithContext(context.WithValue(request.Context(), attemptCounterContextKey{}, counter))
+
+	response, err := c.RetryableHTTP.Do(request)
+
+	attempts := int(counter.Load())
+	if c.attemptObserver != nil {
+		c.attemptObserver(request.Request, attempts)
+	}
+	if c.metrics != nil {
+		c.metrics.observeRequest(request.Request, attempts, start)
+	}
+	c.logSlowRequest(request.Request, response, attempts, time.Since(start))
+
+	return response, err
+}
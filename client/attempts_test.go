@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithAttemptObserver(t *testing.T) {
+	tests := []struct {
+		desc         string
+		failures     int32
+		wantAttempts int
+		wantErr      bool
+	}{
+		{desc: "first attempt succeeds", failures: 0, wantAttempts: 1},
+		{desc: "succeeds after two failures", failures: 2, wantAttempts: 3},
+		{desc: "retries are exhausted", failures: 5, wantAttempts: 3, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				if requests.Add(1) <= tc.failures {
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			}))
+			t.Cleanup(server.Close)
+
+			var observed []int
+			var observedPath string
+			observer := func(request *http.Request, attempts int) {
+				observed = append(observed, attempts)
+				observedPath = request.URL.Path
+			}
+
+			httpClient, err := NewHTTPClientWithOpts(server.URL, "", "", "", 1, []HTTPClientOpt{
+				WithHTTPRetryOpts(0, 0, 2), WithAttemptObserver(observer),
+			})
+			require.NoError(t, err)
+
+			client, err := NewGitlabNetClient("", "", "", httpClient)
+			require.NoError(t, err)
+
+			resp, err := client.Get(context.Background(), "/hello")
+			if tc.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.NoError(t, resp.Body.Close())
+			}
+
+			require.Equal(t, []int{tc.wantAttempts}, observed)
+			require.Equal(t, "/api/v4/internal/hello", observedPath)
+		})
+	}
+}
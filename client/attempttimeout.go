@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrAttemptTimeout is returned when a single attempt at a request exceeds
+// the timeout set with WithPerAttemptTimeout
+var ErrAttemptTimeout = errors.New("request attempt timed out")
+
+// WithPerAttemptTimeout bounds each attempt at a request, including reading
+// the response body, to d. A timed out attempt is retried like any other
+// failed one.
+//
+// Note that retryablehttp applies the read timeout of the client to each
+// attempt too, so the stricter of the two wins. The total time a request may
+// take is roughly (retries + 1) × min(readTimeout, d) plus the backoff
+// between attempts, unless the request context has an earlier deadline.
+func WithPerAttemptTimeout(d time.Duration) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.perAttemptTimeout = d
+	}
+}
+
+type attemptTimeoutTransport struct {
+	next    http.RoundTripper
+	timeout time.Duration
+}
+
+func newAttemptTimeoutTransport(next http.RoundTripper, timeout time.Duration) http.RoundTripper {
+	if timeout <= 0 {
+		return next
+	}
+
+	return &attemptTimeoutTransport{next: next, timeout: timeout}
+}
+
+func (rt *attemptTimeoutTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancelCause(request.Context())
+	timer := time.AfterFunc(rt.timeout, func() { cancel(ErrAttemptTimeout) })
+	release := func(error) {
+		timer.Stop()
+		cancel(nil)
+	}
+
+	response, err := rt.next.RoundTrip(request.WithContext(ctx))
+	if err != nil {
+		if errors.Is(context.Cause(ctx), ErrAttemptTimeout) {
+			err = fmt.Errorf("%w after %v: %w", ErrAttemptTimeout, rt.timeout, err)
+		}
+		release(nil)
+
+		return response, err
+	}
+
+	// The body is read using ctx, so it may only be released once the caller
+	// is done with the response
+	response.Body = &cancelOnCloseBody{ReadCloser: response.Body, cancel: release}
+
+	return response, nil
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithPerAttemptTimeout(t *testing.T) {
+	var attempts atomic.Int32
+	var slowAttempts int32
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if attempts.Add(1) <= atomic.LoadInt32(&slowAttempts) {
+			select {
+			case <-release:
+			case <-time.After(time.Second):
+			}
+		}
+		w.Write([]byte("Hello"))
+	}))
+	t.Cleanup(server.Close)
+	// Unblock slow handlers first so that closing the server doesn't wait on them
+	t.Cleanup(func() { close(release) })
+
+	client, err := NewHTTPClientWithOpts(server.URL, "", "", "", 1, []HTTPClientOpt{
+		WithHTTPRetryOpts(time.Millisecond, time.Millisecond, 2),
+		WithPerAttemptTimeout(50 * time.Millisecond),
+	})
+	require.NoError(t, err)
+
+	do := func() error {
+		req, err := retryablehttp.NewRequest(http.MethodGet, server.URL, nil)
+		require.NoError(t, err)
+
+		resp, err := client.RetryableHTTP.Do(req)
+		if err != nil {
+			return err
+		}
+
+		return resp.Body.Close()
+	}
+
+	t.Run("a slow attempt is aborted and retried", func(t *testing.T) {
+		attempts.Store(0)
+		atomic.StoreInt32(&slowAttempts, 1)
+
+		start := time.Now()
+		require.NoError(t, do())
+		require.Equal(t, int32(2), attempts.Load())
+		require.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+
+	t.Run("every attempt is slow", func(t *testing.T) {
+		attempts.Store(0)
+		atomic.StoreInt32(&slowAttempts, 3)
+
+		require.ErrorIs(t, do(), ErrAttemptTimeout)
+		require.Equal(t, int32(3), attempts.Load())
+	})
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type basicAuth struct {
+	user, password string
+}
+
+// WithBasicAuth makes the HttpClient send HTTP basic auth credentials with
+// every request that doesn't set its own Authorization header. This is an
+// alternative to embedding the credentials in the GitLab URL.
+func WithBasicAuth(user, password string) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.basicAuth = &basicAuth{user: user, password: password}
+	}
+}
+
+// stripURLCredentials removes the userinfo component from an http(s)
+// gitlabURL so it never ends up in Host or logged request URLs, and returns
+// the credentials it held, if any
+func stripURLCredentials(gitlabURL string) (string, *basicAuth, error) {
+	if !strings.HasPrefix(gitlabURL, httpProtocol) && !strings.HasPrefix(gitlabURL, httpsProtocol) {
+		return gitlabURL, nil, nil
+	}
+
+	parsed, err := url.Parse(gitlabURL)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if parsed.User == nil {
+		return gitlabURL, nil, nil
+	}
+
+	password, _ := parsed.User.Password()
+	credentials := &basicAuth{user: parsed.User.Username(), password: password}
+	parsed.User = nil
+
+	return parsed.String(), credentials, nil
+}
+
+type basicAuthTransport struct {
+	next http.RoundTripper
+	auth *basicAuth
+}
+
+func newBasicAuthTransport(next http.RoundTripper, auth *basicAuth) http.RoundTripper {
+	if auth == nil {
+		return next
+	}
+
+	return &basicAuthTransport{next: next, auth: auth}
+}
+
+func (rt *basicAuthTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Header.Get("Authorization") == "" {
+		request = request.Clone(request.Context())
+		request.SetBasicAuth(rt.auth.user, rt.auth.password)
+	}
+
+	return rt.next.RoundTrip(request)
+}
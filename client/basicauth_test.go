@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+)
+
+func TestBasicAuthCredentials(t *testing.T) {
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/auth",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				user, password, _ := r.BasicAuth()
+				fmt.Fprintf(w, "%s:%s", user, password)
+			},
+		},
+	}
+	serverURL := testserver.StartHttpServer(t, requests)
+	urlWithCredentials := strings.Replace(serverURL, "http://", "http://proxy-user:proxy%20pass@", 1)
+
+	tests := []struct {
+		desc       string
+		url        string
+		opts       []HTTPClientOpt
+		clientUser string
+		want       string
+	}{
+		{
+			desc: "credentials embedded in the URL",
+			url:  urlWithCredentials,
+			want: "proxy-user:proxy pass",
+		},
+		{
+			desc: "WithBasicAuth",
+			url:  serverURL,
+			opts: []HTTPClientOpt{WithBasicAuth("opt-user", "opt-pass")},
+			want: "opt-user:opt-pass",
+		},
+		{
+			desc: "WithBasicAuth takes precedence over the URL",
+			url:  urlWithCredentials,
+			opts: []HTTPClientOpt{WithBasicAuth("opt-user", "opt-pass")},
+			want: "opt-user:opt-pass",
+		},
+		{
+			desc:       "request credentials take precedence",
+			url:        urlWithCredentials,
+			clientUser: "request-user",
+			want:       "request-user:request-pass",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			httpClient, err := NewHTTPClientWithOpts(tc.url, "", "", "", 1, tc.opts)
+			require.NoError(t, err)
+			require.Equal(t, serverURL, httpClient.Host)
+
+			password := ""
+			if tc.clientUser != "" {
+				password = "request-pass"
+			}
+			client, err := NewGitlabNetClient(tc.clientUser, password, "", httpClient)
+			require.NoError(t, err)
+
+			resp, err := client.Get(context.Background(), "/auth")
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, string(body))
+			require.NotContains(t, resp.Request.URL.String(), "proxy-user")
+		})
+	}
+}
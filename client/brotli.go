@@ -0,0 +1,65 @@
+package client
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+const brotliEncoding = "br"
+
+// WithBrotli advertises Brotli support to the internal API and transparently
+// decompresses responses with a "Content-Encoding: br" header. Responses in
+// any other encoding are passed through untouched.
+func WithBrotli() HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.brotli = true
+	}
+}
+
+type brotliTransport struct {
+	next http.RoundTripper
+}
+
+func newBrotliTransport(next http.RoundTripper, enabled bool) http.RoundTripper {
+	if !enabled {
+		return next
+	}
+
+	return &brotliTransport{next: next}
+}
+
+func (rt *brotliTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Header.Get("Accept-Encoding") == "" {
+		request = request.Clone(request.Context())
+		request.Header.Set("Accept-Encoding", brotliEncoding)
+	}
+
+	response, err := rt.next.RoundTrip(request)
+	if err != nil {
+		return response, err
+	}
+
+	if !strings.EqualFold(response.Header.Get("Content-Encoding"), brotliEncoding) {
+		return response, nil
+	}
+
+	response.Body = &brotliBody{reader: brotli.NewReader(response.Body), body: response.Body}
+	response.Header.Del("Content-Encoding")
+	response.Header.Del("Content-Length")
+	response.ContentLength = -1
+	response.Uncompressed = true
+
+	return response, nil
+}
+
+type brotliBody struct {
+	reader io.Reader
+	body   io.Closer
+}
+
+func (b *brotliBody) Read(p []byte) (int, error) { return b.reader.Read(p) }
+
+func (b *brotliBody) Close() error { return b.body.Close() }
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBrotli(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/brotli" && r.Header.Get("Accept-Encoding") == "br" {
+			w.Header().Set("Content-Encoding", "br")
+			bw := brotli.NewWriter(w)
+			_, err := bw.Write([]byte("Hello, Brotli"))
+			require.NoError(t, err)
+			require.NoError(t, bw.Close())
+			return
+		}
+
+		w.Write([]byte("Hello, identity"))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewHTTPClientWithOpts(server.URL, "", "", "", 1, []HTTPClientOpt{WithBrotli()})
+	require.NoError(t, err)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/brotli", want: "Hello, Brotli"},
+		{path: "/identity", want: "Hello, identity"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL+tc.path, nil)
+			require.NoError(t, err)
+
+			resp, err := client.RetryableHTTP.HTTPClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, string(body))
+			require.Empty(t, resp.Header.Get("Content-Encoding"))
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+// ErrCircuitOpen is returned without contacting the internal API while the
+// circuit breaker enabled by WithCircuitBreaker is open
+var ErrCircuitOpen = &APIError{Msg: "GitLab is currently unreachable. Please try again later."}
+
+// WithCircuitBreaker makes requests fail fast with ErrCircuitOpen, without
+// being retried, once failureThreshold attempts in a row have failed with an
+// error or a 5xx response. After probeInterval a single request is let
+// through to probe the internal API: the circuit closes again if it
+// succeeds, and stays open for another probeInterval otherwise.
+func WithCircuitBreaker(failureThreshold int, probeInterval time.Duration) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.circuitBreaker = &circuitBreaker{
+			threshold:     failureThreshold,
+			probeInterval: probeInterval,
+			now:           time.Now,
+		}
+	}
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+type circuitBreaker struct {
+	threshold     int
+	probeInterval time.Duration
+	now           func() time.Time
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+}
+
+// allow reports whether a request may be attempted, moving an open circuit
+// to half-open once the probe interval has passed
+func (cb *circuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case circuitClosed:
+		return true
+	case circuitOpen:
+		if cb.now().Sub(cb.openedAt) < cb.probeInterval {
+			return false
+		}
+		cb.state = circuitHalfOpen
+
+		return true
+	default:
+		// A probe is already in flight
+		return false
+	}
+}
+
+func (cb *circuitBreaker) succeeded() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state != circuitClosed {
+		log.Info("Internal API reachable again, closing circuit breaker")
+	}
+
+	cb.state = circuitClosed
+	cb.failures = 0
+}
+
+func (cb *circuitBreaker) failed() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures++
+	if cb.state != circuitHalfOpen && cb.failures < cb.threshold {
+		return
+	}
+
+	if cb.state == circuitClosed {
+		log.WithFields(log.Fields{"failures": cb.failures, "probe_interval_s": cb.probeInterval.Seconds()}).Warn("Internal API unreachable, opening circuit breaker")
+	}
+
+	cb.state = circuitOpen
+	cb.openedAt = cb.now()
+}
+
+// aborted releases the probe of a half-open circuit whose request was
+// canceled by the caller, letting the next request probe instead
+func (cb *circuitBreaker) aborted() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == circuitHalfOpen {
+		cb.state = circuitOpen
+	}
+}
+
+type circuitBreakerTransport struct {
+	next    http.RoundTripper
+	breaker *circuitBreaker
+}
+
+func newCircuitBreakerTransport(next http.RoundTripper, breaker *circuitBreaker) http.RoundTripper {
+	if breaker == nil {
+		return next
+	}
+
+	return &circuitBreakerTransport{next: next, breaker: breaker}
+}
+
+func (rt *circuitBreakerTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if !rt.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	response, err := rt.next.RoundTrip(request)
+
+	switch {
+	case err != nil && request.Context().Err() != nil:
+		rt.breaker.aborted()
+	case err != nil, response.StatusCode >= http.StatusInternalServerError && !isMaintenancePage(response):
+		rt.breaker.failed()
+	default:
+		rt.breaker.succeeded()
+	}
+
+	return response, err
+}
+
+// circuitBreakerRetryPolicy wraps next so that requests rejected by an open
+// circuit aren't retried
+func circuitBreakerRetryPolicy(next retryablehttp.CheckRetry) retryablehttp.CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if errors.Is(err, ErrCircuitOpen) {
+			return false, nil
+		}
+
+		return next(ctx, resp, err)
+	}
+}
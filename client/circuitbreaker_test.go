@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var attempts atomic.Int32
+	var healthy atomic.Bool
+
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/check",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				attempts.Add(1)
+				if !healthy.Load() {
+					w.WriteHeader(http.StatusBadGateway)
+				}
+			},
+		},
+	}
+
+	url := testserver.StartHttpServer(t, requests)
+	opts := append([]HTTPClientOpt{WithCircuitBreaker(3, 50*time.Millisecond)}, defaultHttpOpts...)
+	httpClient, err := NewHTTPClientWithOpts(url, "", "", "", 1, opts)
+	require.NoError(t, err)
+
+	client, err := NewGitlabNetClient("", "", "", httpClient)
+	require.NoError(t, err)
+
+	_, err = client.Get(context.Background(), "/check")
+	require.NotErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, int32(3), attempts.Load(), "the circuit opens once the retries are exhausted")
+
+	_, err = client.Get(context.Background(), "/check")
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, int32(3), attempts.Load(), "an open circuit fails fast without retries")
+
+	healthy.Store(true)
+	time.Sleep(100 * time.Millisecond)
+
+	_, err = client.Get(context.Background(), "/check")
+	require.NoError(t, err, "a successful probe closes the circuit")
+
+	_, err = client.Get(context.Background(), "/check")
+	require.NoError(t, err)
+	require.Equal(t, int32(5), attempts.Load())
+}
+
+func TestCircuitBreakerStates(t *testing.T) {
+	now := time.Now()
+	cb := &circuitBreaker{threshold: 2, probeInterval: time.Minute, now: func() time.Time { return now }}
+
+	require.True(t, cb.allow())
+	cb.failed()
+	require.True(t, cb.allow(), "the circuit stays closed below the threshold")
+	cb.succeeded()
+	cb.failed()
+	require.True(t, cb.allow(), "a success resets the failure count")
+	cb.failed()
+	require.False(t, cb.allow(), "the circuit opens at the threshold")
+
+	now = now.Add(time.Minute)
+	require.True(t, cb.allow(), "a probe is let through after the probe interval")
+	require.False(t, cb.allow(), "only one probe is let through at a time")
+
+	cb.failed()
+	require.False(t, cb.allow(), "a failed probe reopens the circuit")
+
+	now = now.Add(time.Minute)
+	require.True(t, cb.allow())
+	cb.aborted()
+	require.True(t, cb.allow(), "an aborted probe lets the next request probe")
+
+	cb.succeeded()
+	require.True(t, cb.allow())
+	require.True(t, cb.allow())
+}
+
+func TestCircuitBreakerIgnoresMaintenancePages(t *testing.T) {
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/check",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+		},
+	}
+
+	url := testserver.StartHttpServer(t, requests)
+	opts := append([]HTTPClientOpt{WithCircuitBreaker(1, time.Hour)}, defaultHttpOpts...)
+	httpClient, err := NewHTTPClientWithOpts(url, "", "", "", 1, opts)
+	require.NoError(t, err)
+
+	client, err := NewGitlabNetClient("", "", "", httpClient)
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err = client.Get(context.Background(), "/check")
+		require.ErrorIs(t, err, ErrMaintenanceMode)
+	}
+}
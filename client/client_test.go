@@ -56,6 +56,21 @@ func TestClients(t *testing.T) {
 			server: testserver.StartHttpServer,
 			secret: secret,
 		},
+		{
+			desc:            "Http client with relative URL at /gitlab",
+			relativeURLRoot: "/gitlab",
+			server:          testserver.StartHttpServer,
+			secret:          secret,
+		},
+		{
+			desc:            "Https client with relative URL at gitlab/",
+			relativeURLRoot: "gitlab/",
+			caFile:          path.Join(testRoot, "certs/valid/server.crt"),
+			server: func(t *testing.T, handlers []testserver.TestRequestHandler) string {
+				return testserver.StartHttpsServer(t, handlers, "")
+			},
+			secret: secret,
+		},
 		{
 			desc:   "Https client",
 			caFile: path.Join(testRoot, "certs/valid/server.crt"),
@@ -317,3 +332,79 @@ func TestRetryOnFailure(t *testing.T) {
 	require.EqualError(t, err, "Internal API unreachable")
 	require.Equal(t, 3, reqAttempts)
 }
+
+func TestDoStream(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v4/internal/stream", r.URL.Path)
+		require.Equal(t, "application/octet-stream", r.Header.Get("Content-Type"))
+		require.Equal(t, int64(len("streamed body")), r.ContentLength)
+		require.NotEmpty(t, r.Header.Get(apiSecretHeaderName))
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		bodies = append(bodies, string(body))
+
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Write([]byte("streamed response"))
+	}))
+	defer srv.Close()
+
+	httpClient, err := NewHTTPClientWithOpts(srv.URL, "/", "", "", 1, defaultHttpOpts)
+	require.NoError(t, err)
+	client, err := NewGitlabNetClient("", "", secret, httpClient)
+	require.NoError(t, err)
+
+	file := strings.NewReader("streamed body")
+	response, err := client.PostStream(context.Background(), "/stream", "application/octet-stream", ReadSeekerBody(file))
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	require.Equal(t, []string{"streamed body", "streamed body"}, bodies, "the body should be sent again on retries")
+
+	responseBody, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.Equal(t, "streamed response", string(responseBody))
+}
+
+func TestPreviousSecrets(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		bodies = append(bodies, string(body))
+
+		_, err = jwt.Parse(r.Header.Get(apiSecretHeaderName), func(*jwt.Token) (interface{}, error) {
+			return []byte("previous"), nil
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Write([]byte("accepted"))
+	}))
+	defer srv.Close()
+
+	httpClient, err := NewHTTPClientWithOpts(srv.URL, "/", "", "", 1, defaultHttpOpts)
+	require.NoError(t, err)
+	client, err := NewGitlabNetClient("", "", "current", httpClient)
+	require.NoError(t, err)
+
+	_, err = client.Post(context.Background(), "/check", map[string]string{"key": "value"})
+	require.EqualError(t, err, "Internal API error (401)")
+	require.Len(t, bodies, 1)
+
+	client.SetPreviousSecrets([]string{"older", "previous"})
+	bodies = nil
+
+	response, err := client.Post(context.Background(), "/check", map[string]string{"key": "value"})
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	require.Equal(t, []string{`{"key":"value"}`, `{"key":"value"}`, `{"key":"value"}`}, bodies, "the body should be sent again with every secret")
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveHeaders are the headers whose values are hidden from curl
+// commands and logs
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Private-Token":       true,
+	"Job-Token":           true,
+	http.CanonicalHeaderKey(apiSecretHeaderName): true,
+}
+
+// CurlString renders req as an equivalent curl command, for support tickets
+// and reproducing failures by hand. Credentials in headers and the URL are
+// redacted, and the body is left out.
+func (c *HTTPClient) CurlString(req *http.Request) string {
+	args := []string{"curl", "-X", req.Method}
+
+	if abstractSocket, ok := strings.CutPrefix(c.socketPath, "@"); ok {
+		args = append(args, "--abstract-unix-socket", shellQuote(abstractSocket))
+	} else if c.socketPath != "" {
+		args = append(args, "--unix-socket", shellQuote(c.socketPath))
+	}
+
+	header := redactHeaders(req.Header)
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, value := range header[name] {
+			args = append(args, "-H", shellQuote(name+": "+value))
+		}
+	}
+
+	return strings.Join(append(args, shellQuote(redactURL(req.URL))), " ")
+}
+
+func redactURL(u *url.URL) string {
+	if u.User == nil {
+		return u.String()
+	}
+
+	redactedURL := *u
+	redactedURL.User = nil
+
+	// url.User would percent-encode the placeholder
+	return strings.Replace(redactedURL.String(), "://", "://"+redacted+"@", 1)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
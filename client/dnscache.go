@@ -0,0 +1,154 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+type hostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// WithDNSCache makes the HttpClient cache the addresses the GitLab host
+// resolves to for ttl. Expired entries keep being used while they are
+// refreshed in the background, connections rotate through all the resolved
+// addresses, and a failed dial falls back to a fresh lookup. It only applies
+// to http and https URLs.
+func WithDNSCache(ttl time.Duration) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.dnsCacheTTL = ttl
+	}
+}
+
+type dnsCache struct {
+	ttl      time.Duration
+	resolver hostResolver
+	dial     dialFunc
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+}
+
+type dnsEntry struct {
+	addrs      []string
+	expires    time.Time
+	next       int
+	refreshing bool
+}
+
+func newDNSCache(ttl time.Duration, resolver hostResolver, dial dialFunc) *dnsCache {
+	return &dnsCache{
+		ttl:      ttl,
+		resolver: resolver,
+		dial:     dial,
+		entries:  make(map[string]*dnsEntry),
+	}
+}
+
+// DialContext is suitable for use as http.Transport.DialContext
+func (c *dnsCache) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return c.dial(ctx, network, addr)
+	}
+
+	ip, err := c.pick(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := c.dial(ctx, network, net.JoinHostPort(ip, port))
+	if err == nil {
+		return conn, nil
+	}
+
+	// The cached address may be stale, so retry with a live lookup
+	addrs, lookupErr := c.resolve(ctx, host)
+	if lookupErr != nil {
+		return nil, err
+	}
+
+	for _, ip := range addrs {
+		conn, err = c.dial(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
+
+// pick returns the next cached address for host, resolving it if needed
+func (c *dnsCache) pick(ctx context.Context, host string) (string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	if ok {
+		if time.Now().After(entry.expires) && !entry.refreshing {
+			entry.refreshing = true
+			go c.refresh(host)
+		}
+
+		ip := entry.addrs[entry.next%len(entry.addrs)]
+		entry.next++
+		c.mu.Unlock()
+
+		return ip, nil
+	}
+	c.mu.Unlock()
+
+	addrs, err := c.resolve(ctx, host)
+	if err != nil {
+		return "", err
+	}
+
+	return addrs[0], nil
+}
+
+func (c *dnsCache) refresh(host string) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.ttl)
+	defer cancel()
+
+	if _, err := c.resolve(ctx, host); err != nil {
+		// Keep serving the stale addresses, and try again on the next dial
+		c.mu.Lock()
+		if entry, ok := c.entries[host]; ok {
+			entry.refreshing = false
+		}
+		c.mu.Unlock()
+	}
+}
+
+// resolve looks up host and replaces its cache entry
+func (c *dnsCache) resolve(ctx context.Context, host string) ([]string, error) {
+	addrs, err := c.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no addresses found", Name: host, IsNotFound: true}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	next := 0
+	if entry, ok := c.entries[host]; ok {
+		next = entry.next
+	}
+	c.entries[host] = &dnsEntry{addrs: addrs, expires: time.Now().Add(c.ttl), next: next + 1}
+
+	return addrs, nil
+}
+
+// withDNSCache wraps the dialer of transport, or a default one, in a DNS cache
+func withDNSCache(ttl time.Duration, dial dialFunc) dialFunc {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	return newDNSCache(ttl, net.DefaultResolver, dial).DialContext
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+)
+
+type fakeResolver struct {
+	mu      sync.Mutex
+	addrs   []string
+	lookups int
+}
+
+func (r *fakeResolver) LookupHost(_ context.Context, _ string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lookups++
+	return r.addrs, nil
+}
+
+func (r *fakeResolver) set(addrs ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.addrs = addrs
+}
+
+func (r *fakeResolver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.lookups
+}
+
+type fakeDialer struct {
+	failing map[string]bool
+	dialed  []string
+}
+
+func (d *fakeDialer) dial(_ context.Context, _, addr string) (net.Conn, error) {
+	d.dialed = append(d.dialed, addr)
+	if d.failing[addr] {
+		return nil, errors.New("connection refused")
+	}
+
+	client, server := net.Pipe()
+	server.Close()
+
+	return client, nil
+}
+
+func TestDNSCache(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("caches and rotates through addresses", func(t *testing.T) {
+		resolver := &fakeResolver{addrs: []string{"192.0.2.1", "192.0.2.2"}}
+		dialer := &fakeDialer{}
+		cache := newDNSCache(time.Hour, resolver, dialer.dial)
+
+		for i := 0; i < 3; i++ {
+			conn, err := cache.DialContext(ctx, "tcp", "gitlab.example.com:443")
+			require.NoError(t, err)
+			conn.Close()
+		}
+
+		require.Equal(t, 1, resolver.count())
+		require.Equal(t, []string{"192.0.2.1:443", "192.0.2.2:443", "192.0.2.1:443"}, dialer.dialed)
+	})
+
+	t.Run("falls back to a live lookup when the dial fails", func(t *testing.T) {
+		resolver := &fakeResolver{addrs: []string{"192.0.2.1"}}
+		dialer := &fakeDialer{failing: map[string]bool{"192.0.2.1:443": true}}
+		cache := newDNSCache(time.Hour, resolver, dialer.dial)
+
+		_, err := cache.DialContext(ctx, "tcp", "gitlab.example.com:443")
+		require.Error(t, err)
+
+		resolver.set("192.0.2.9")
+		conn, err := cache.DialContext(ctx, "tcp", "gitlab.example.com:443")
+		require.NoError(t, err)
+		conn.Close()
+
+		require.Equal(t, "192.0.2.9:443", dialer.dialed[len(dialer.dialed)-1])
+	})
+
+	t.Run("refreshes expired entries in the background", func(t *testing.T) {
+		resolver := &fakeResolver{addrs: []string{"192.0.2.1"}}
+		dialer := &fakeDialer{}
+		cache := newDNSCache(10*time.Millisecond, resolver, dialer.dial)
+
+		conn, err := cache.DialContext(ctx, "tcp", "gitlab.example.com:443")
+		require.NoError(t, err)
+		conn.Close()
+
+		time.Sleep(20 * time.Millisecond)
+		resolver.set("192.0.2.2")
+
+		// The stale address is used while the entry is refreshed
+		conn, err = cache.DialContext(ctx, "tcp", "gitlab.example.com:443")
+		require.NoError(t, err)
+		conn.Close()
+		require.Equal(t, "192.0.2.1:443", dialer.dialed[1])
+
+		require.Eventually(t, func() bool { return resolver.count() == 2 }, time.Second, time.Millisecond)
+	})
+
+	t.Run("IP addresses are dialed directly", func(t *testing.T) {
+		resolver := &fakeResolver{}
+		dialer := &fakeDialer{}
+		cache := newDNSCache(time.Hour, resolver, dialer.dial)
+
+		conn, err := cache.DialContext(ctx, "tcp", "[2001:db8::1]:443")
+		require.NoError(t, err)
+		conn.Close()
+
+		require.Zero(t, resolver.count())
+		require.Equal(t, []string{"[2001:db8::1]:443"}, dialer.dialed)
+	})
+}
+
+func TestWithDNSCache(t *testing.T) {
+	url := testserver.StartHttpServer(t, []testserver.TestRequestHandler{{
+		Path:    "/api/v4/internal/hello",
+		Handler: func(w http.ResponseWriter, _ *http.Request) { fmt.Fprint(w, "Hello") },
+	}})
+
+	httpClient, err := NewHTTPClientWithOpts(strings.Replace(url, "127.0.0.1", "localhost", 1), "", "", "", 1, []HTTPClientOpt{
+		WithDNSCache(time.Minute),
+	})
+	require.NoError(t, err)
+
+	client, err := NewGitlabNetClient("", "", "", httpClient)
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(context.Background(), "/hello")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+}
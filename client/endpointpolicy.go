@@ -0,0 +1,157 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// EndpointPolicy overrides the timeout and retries of the client for the
+// requests to an internal API endpoint, so that e.g. access checks fail fast
+// while slower endpoints are given longer
+type EndpointPolicy struct {
+	// Timeout bounds each attempt at a request, including reading the
+	// response body, instead of the read timeout of the client. Zero keeps
+	// the read timeout.
+	Timeout time.Duration
+	// RetryMax is the number of retries after the first attempt, instead of
+	// the one set with WithHTTPRetryOpts. Nil keeps the client's.
+	RetryMax *int
+}
+
+// WithEndpointPolicies applies policies to the requests by path below
+// /api/v4/internal, such as "allowed" or "lfs_authenticate". Requests to
+// other endpoints keep the read timeout and retries of the client.
+//
+// The retries of an endpoint are only limited for the requests made through
+// GitlabNetClient; those sent with RetryableHTTP directly may be retried as
+// often as the policy allowing the most retries.
+func WithEndpointPolicies(policies map[string]EndpointPolicy) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.endpointPolicies = policies
+	}
+}
+
+// endpointPolicies resolve the timeout and retries of each request, falling
+// back on those of the client
+type endpointPolicies struct {
+	endpoints map[string]EndpointPolicy
+	timeout   time.Duration
+	retryMax  int
+}
+
+func newEndpointPolicies(policies map[string]EndpointPolicy, timeout time.Duration, retryMax int) *endpointPolicies {
+	if len(policies) == 0 {
+		return nil
+	}
+
+	p := &endpointPolicies{
+		endpoints: make(map[string]EndpointPolicy, len(policies)),
+		timeout:   timeout,
+		retryMax:  retryMax,
+	}
+	for endpoint, policy := range policies {
+		p.endpoints[strings.Trim(endpoint, "/")] = policy
+	}
+
+	return p
+}
+
+// policy returns the timeout and retries of a request to path
+func (p *endpointPolicies) policy(path string) (time.Duration, int) {
+	timeout, retryMax := p.timeout, p.retryMax
+
+	policy, ok := p.endpoints[endpoint(path)]
+	if !ok {
+		return timeout, retryMax
+	}
+
+	if policy.Timeout > 0 {
+		timeout = policy.Timeout
+	}
+	if policy.RetryMax != nil {
+		retryMax = *policy.RetryMax
+	}
+
+	return timeout, retryMax
+}
+
+// maxRetries is the most retries any request may be given, which bounds the
+// retries of the underlying retryablehttp.Client
+func (p *endpointPolicies) maxRetries() int {
+	retryMax := p.retryMax
+	for _, policy := range p.endpoints {
+		if policy.RetryMax != nil {
+			retryMax = max(retryMax, *policy.RetryMax)
+		}
+	}
+
+	return retryMax
+}
+
+// endpointRetries counts the attempts at a request against the retries its
+// endpoint allows
+type endpointRetries struct {
+	retryMax int
+	attempts atomic.Int32
+}
+
+type endpointRetriesContextKey struct{}
+
+// withRetries returns ctx carrying the retries allowed for a request to path
+func (p *endpointPolicies) withRetries(ctx context.Context, path string) context.Context {
+	_, retryMax := p.policy(path)
+
+	return withRetries(ctx, retryMax)
+}
+
+// withRetries returns ctx carrying the retries allowed for a request
+func withRetries(ctx context.Context, retryMax int) context.Context {
+	return context.WithValue(ctx, endpointRetriesContextKey{}, &endpointRetries{retryMax: retryMax})
+}
+
+// endpointRetryPolicy wraps next so that a request is given up on once it
+// used the retries of its endpoint. retryablehttp checks every attempt, so
+// each check counts one.
+func endpointRetryPolicy(next retryablehttp.CheckRetry) retryablehttp.CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		shouldRetry, checkErr := next(ctx, resp, err)
+
+		retries, ok := ctx.Value(endpointRetriesContextKey{}).(*endpointRetries)
+		if !ok {
+			return shouldRetry, checkErr
+		}
+
+		if attempts := int(retries.attempts.Add(1)); shouldRetry && attempts > retries.retryMax {
+			return false, checkErr
+		}
+
+		return shouldRetry, checkErr
+	}
+}
+
+type endpointTimeoutTransport struct {
+	next     http.RoundTripper
+	policies *endpointPolicies
+}
+
+// newEndpointTimeoutTransport bounds each attempt by the timeout of its
+// endpoint. It stands in for the timeout of the http.Client, which can't
+// vary by request.
+func newEndpointTimeoutTransport(next http.RoundTripper, policies *endpointPolicies) http.RoundTripper {
+	if policies == nil {
+		return next
+	}
+
+	return &endpointTimeoutTransport{next: next, policies: policies}
+}
+
+func (rt *endpointTimeoutTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	timeout, _ := rt.policies.policy(request.URL.Path)
+
+	return newAttemptTimeoutTransport(rt.next, timeout).RoundTrip(request)
+}
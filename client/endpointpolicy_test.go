@@ -0,0 +1,73 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+)
+
+func TestWithEndpointPolicies(t *testing.T) {
+	var attempts [4]atomic.Int32
+	unavailable := func(counter *atomic.Int32) func(http.ResponseWriter, *http.Request) {
+		return func(w http.ResponseWriter, _ *http.Request) {
+			counter.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}
+	slow := func(counter *atomic.Int32, delay time.Duration) func(http.ResponseWriter, *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			counter.Add(1)
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+			}
+		}
+	}
+
+	url := testserver.StartHttpServer(t, []testserver.TestRequestHandler{
+		{Path: "/api/v4/internal/allowed", Handler: slow(&attempts[0], time.Second)},
+		{Path: "/api/v4/internal/lfs_authenticate", Handler: slow(&attempts[1], 1100*time.Millisecond)},
+		{Path: "/api/v4/internal/discover", Handler: unavailable(&attempts[2])},
+		{Path: "/api/v4/internal/check", Handler: unavailable(&attempts[3])},
+	})
+
+	noRetries, moreRetries := 0, 4
+	opts := append([]HTTPClientOpt{WithEndpointPolicies(map[string]EndpointPolicy{
+		"allowed":          {Timeout: 50 * time.Millisecond, RetryMax: &noRetries},
+		"lfs_authenticate": {Timeout: 2 * time.Second},
+		"/discover/":       {RetryMax: &moreRetries},
+	})}, defaultHttpOpts...)
+	httpClient, err := NewHTTPClientWithOpts(url, "", "", "", 1, opts)
+	require.NoError(t, err)
+
+	client, err := NewGitlabNetClient("", "", "", httpClient)
+	require.NoError(t, err)
+
+	get := func(path string) error {
+		resp, err := client.Get(context.Background(), path)
+		if err == nil {
+			require.NoError(t, resp.Body.Close())
+		}
+		return err
+	}
+
+	start := time.Now()
+	require.Error(t, get("/allowed"))
+	require.Less(t, time.Since(start), 500*time.Millisecond, "the endpoint timeout applies")
+	require.Equal(t, int32(1), attempts[0].Load(), "the endpoint isn't retried")
+
+	require.NoError(t, get("/lfs_authenticate"), "the endpoint timeout replaces the read timeout")
+	require.Equal(t, int32(1), attempts[1].Load())
+
+	require.Error(t, get("/discover"))
+	require.Equal(t, int32(5), attempts[2].Load(), "the endpoint is retried more than the client default")
+
+	require.Error(t, get("/check"))
+	require.Equal(t, int32(3), attempts[3].Load(), "other endpoints keep the client retries")
+}
@@ -0,0 +1,202 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+// backendCooldown is how long a backend that couldn't be connected to is
+// tried after the others
+const backendCooldown = 10 * time.Second
+
+// WithLoadBalancing makes a client built with several URLs spread requests
+// across them in turn, rather than sending them to the first URL that can be
+// connected to. Either way, requests fail over to the next URL when a
+// backend can't be connected to.
+func WithLoadBalancing() HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.loadBalancing = true
+	}
+}
+
+// backend is one of the URLs of a client with its transport
+type backend struct {
+	host       string
+	socketPath string
+	transport  http.RoundTripper
+	health     *backendHealth
+}
+
+// backendHealth tracks whether a backend can be connected to
+type backendHealth struct {
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+func (h *backendHealth) healthy(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return !now.Before(h.downUntil)
+}
+
+// failed marks the backend down until the cooldown has passed, and reports
+// whether it was healthy before
+func (h *backendHealth) failed(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	wasHealthy := !now.Before(h.downUntil)
+	h.downUntil = now.Add(backendCooldown)
+
+	return wasHealthy
+}
+
+// succeeded marks the backend up, and reports whether it was down before
+func (h *backendHealth) succeeded() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	wasDown := !h.downUntil.IsZero()
+	h.downUntil = time.Time{}
+
+	return wasDown
+}
+
+// failoverTransport sends requests, which are built against the host of the
+// first backend, to the first backend that can be connected to. Backends
+// that couldn't be connected to recently are tried last.
+type failoverTransport struct {
+	backends []backend
+	balance  bool
+	next     atomic.Uint64
+	now      func() time.Time
+}
+
+func newFailoverTransport(backends []backend, balance bool) http.RoundTripper {
+	if len(backends) == 1 {
+		return backends[0].transport
+	}
+
+	for i := range backends {
+		backends[i].health = &backendHealth{}
+	}
+
+	return &failoverTransport{backends: backends, balance: balance, now: time.Now}
+}
+
+func (rt *failoverTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	primary := &rt.backends[0]
+	sent := false
+	defer func() {
+		// The transports close the bodies of the requests they are given
+		if !sent && request.Body != nil {
+			_ = request.Body.Close()
+		}
+	}()
+
+	var response *http.Response
+	var err error
+
+	for _, b := range rt.order() {
+		attempt := request
+		if b == primary {
+			sent = true
+		} else {
+			var rewriteErr error
+			if attempt, rewriteErr = rewriteRequest(request, primary.host, b.host); rewriteErr != nil {
+				if response == nil && err == nil {
+					err = rewriteErr
+				}
+				break
+			}
+		}
+
+		response, err = b.transport.RoundTrip(attempt)
+		if !isDialError(err) {
+			if err == nil && b.health.succeeded() {
+				log.WithFields(log.Fields{"backend": b.host}).Info("GitLab backend reachable again")
+			}
+			break
+		}
+
+		if b.health.failed(rt.now()) {
+			log.WithFields(log.Fields{"backend": b.host}).WithError(err).Warn("GitLab backend unreachable, trying the others first")
+		}
+	}
+
+	return response, err
+}
+
+// order returns the backends to try a request with: all of them, starting
+// with the next in turn when balancing, and the unhealthy ones last
+func (rt *failoverTransport) order() []*backend {
+	start := 0
+	if rt.balance {
+		start = int((rt.next.Add(1) - 1) % uint64(len(rt.backends)))
+	}
+
+	now := rt.now()
+	healthy := make([]*backend, 0, len(rt.backends))
+	var unhealthy []*backend
+
+	for i := range rt.backends {
+		b := &rt.backends[(start+i)%len(rt.backends)]
+		if b.health.healthy(now) {
+			healthy = append(healthy, b)
+		} else {
+			unhealthy = append(unhealthy, b)
+		}
+	}
+
+	return append(healthy, unhealthy...)
+}
+
+// isDialError reports whether err happened while connecting, that is before
+// anything was sent and so the request can safely be sent elsewhere
+func isDialError(err error) bool {
+	var opErr *net.OpError
+
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// rewriteRequest returns a copy of request sent to toHost instead of fromHost
+func rewriteRequest(request *http.Request, fromHost, toHost string) (*http.Request, error) {
+	rawURL, ok := strings.CutPrefix(request.URL.String(), fromHost)
+	if !ok {
+		return nil, errors.New("request URL isn't relative to the GitLab URL")
+	}
+
+	u, err := url.Parse(toHost + rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	attempt := request.Clone(request.Context())
+	attempt.URL = u
+	attempt.Host = ""
+
+	if request.Body != nil && request.Body != http.NoBody {
+		if request.GetBody == nil {
+			return nil, errors.New("request body can't be replayed")
+		}
+
+		var body io.ReadCloser
+		body, err = request.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		attempt.Body = body
+	}
+
+	return attempt, nil
+}
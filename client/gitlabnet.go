@@ -5,14 +5,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/gitlab-org/labkit/log"
 )
 
 const (
@@ -21,6 +23,7 @@ const (
 	defaultUserAgent    = "GitLab-Shell"
 	jwtTTL              = time.Minute
 	jwtIssuer           = "gitlab-shell"
+	unreachableMsg      = "Internal API unreachable"
 )
 
 // ErrorResponse represents an error response from the API
@@ -35,11 +38,18 @@ type GitlabNetClient struct {
 	password   string
 	secret     string
 	userAgent  string
+
+	// previousSecrets are tried when GitLab refuses secret, in order
+	previousSecrets []string
+
+	middlewares []Middleware
 }
 
 // APIError represents an API error
 type APIError struct {
 	Msg string
+	// StatusCode is the status of the response, or 0 if there was none
+	StatusCode int
 }
 
 // OriginalRemoteIPContextKey is used as the key in a Context to set an X-Forwarded-For header in a request
@@ -49,6 +59,22 @@ func (e *APIError) Error() string {
 	return e.Msg
 }
 
+// IsUnavailable reports whether err is the internal API failing to serve a
+// request, rather than refusing it: it couldn't be reached, was down for
+// maintenance or too busy, or answered with a server error
+func IsUnavailable(err error) bool {
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrServerBusy) || errors.Is(err, ErrMaintenanceMode) {
+		return true
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	return apiErr.Msg == unreachableMsg || apiErr.StatusCode >= http.StatusInternalServerError
+}
+
 // NewGitlabNetClient creates a new GitlabNetClient instance
 func NewGitlabNetClient(
 	user,
@@ -75,6 +101,13 @@ func (c *GitlabNetClient) SetUserAgent(ua string) {
 	c.userAgent = ua
 }
 
+// SetPreviousSecrets sets the secrets to try, in order, when GitLab refuses
+// the secret of the client, so that the secret can be rotated on gitlab-shell
+// before GitLab knows it
+func (c *GitlabNetClient) SetPreviousSecrets(secrets []string) {
+	c.previousSecrets = secrets
+}
+
 func normalizePath(path string) string {
 	if !strings.HasPrefix(path, "/") {
 		path = "/" + path
@@ -110,20 +143,33 @@ func newRequest(ctx context.Context, method, host, path string, data interface{}
 }
 
 func parseError(resp *http.Response, respErr error) error {
+	if errors.Is(respErr, ErrCircuitOpen) {
+		return ErrCircuitOpen
+	}
+
+	if errors.Is(respErr, ErrServerBusy) {
+		return ErrServerBusy
+	}
+
 	if resp == nil || respErr != nil {
-		return &APIError{"Internal API unreachable"}
+		return &APIError{Msg: unreachableMsg}
 	}
 
 	if resp.StatusCode >= 200 && resp.StatusCode <= 399 {
 		return nil
 	}
 	defer func() { _ = resp.Body.Close() }()
+
+	if isMaintenancePage(resp) {
+		return ErrMaintenanceMode
+	}
+
 	parsedResponse := &ErrorResponse{}
 
 	if err := json.NewDecoder(resp.Body).Decode(parsedResponse); err != nil {
-		return &APIError{fmt.Sprintf("Internal API error (%v)", resp.StatusCode)}
+		return &APIError{Msg: fmt.Sprintf("Internal API error (%v)", resp.StatusCode), StatusCode: resp.StatusCode}
 	}
-	return &APIError{parsedResponse.Message}
+	return &APIError{Msg: parsedResponse.Message, StatusCode: resp.StatusCode}
 }
 
 // Get makes a GET request
@@ -138,7 +184,7 @@ func (c *GitlabNetClient) Post(ctx context.Context, path string, data interface{
 
 // Do executes a request
 func (c *GitlabNetClient) Do(request *http.Request) (*http.Response, error) {
-	response, respErr := c.httpClient.RetryableHTTP.HTTPClient.Do(request)
+	response, respErr := c.intercept(request, c.httpClient.RetryableHTTP.HTTPClient.Do)
 	if err := parseError(response, respErr); err != nil {
 		return nil, err
 	}
@@ -153,27 +199,125 @@ func (c *GitlabNetClient) DoRequest(ctx context.Context, method, path string, da
 		return nil, err
 	}
 
-	user, password := c.user, c.password
-	if user != "" && password != "" {
-		request.SetBasicAuth(user, password)
+	return c.send(ctx, request, "application/json")
+}
+
+// Body returns the body of a streamed request for each attempt at it, so that
+// the request can be retried without buffering the body. The readers it
+// returns aren't closed: the caller stays in charge of what they read from.
+type Body func() (io.Reader, error)
+
+// ReadSeekerBody streams the body of a request from r, seeking back to its
+// start for each attempt
+func ReadSeekerBody(r io.ReadSeeker) Body {
+	return func() (io.Reader, error) {
+		size, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		return &sizedReader{Reader: r, size: int(size)}, nil
 	}
+}
 
-	claims := jwt.RegisteredClaims{
-		Issuer:    jwtIssuer,
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(jwtTTL)),
+// sizedReader lets retryablehttp set the Content-Length of the request
+type sizedReader struct {
+	io.Reader
+	size int
+}
+
+func (r *sizedReader) Len() int {
+	return r.size
+}
+
+// PostStream makes a POST request whose body is streamed from body
+func (c *GitlabNetClient) PostStream(ctx context.Context, path, contentType string, body Body) (*http.Response, error) {
+	return c.DoStream(ctx, http.MethodPost, normalizePath(path), contentType, body)
+}
+
+// DoStream executes a request with the given method and path, whose body is
+// streamed from body. As with the other requests, the body of the response is
+// read from the connection as the caller reads it, unless it's validated with
+// WithResponseSchema.
+func (c *GitlabNetClient) DoStream(ctx context.Context, method, path, contentType string, body Body) (*http.Response, error) {
+	var bodyReader interface{}
+	if body != nil {
+		bodyReader = retryablehttp.ReaderFunc(func() (io.Reader, error) {
+			r, err := body()
+			if err != nil {
+				return nil, err
+			}
+
+			// Hide any Close method from the transport, but not the length
+			if lr, ok := r.(interface{ Len() int }); ok {
+				return &sizedReader{Reader: r, size: lr.Len()}, nil
+			}
+			return struct{ io.Reader }{r}, nil
+		})
 	}
-	secretBytes := []byte(strings.TrimSpace(c.secret))
-	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secretBytes)
+
+	request, err := retryablehttp.NewRequestWithContext(ctx, method, appendPath(c.httpClient.Host, path), bodyReader)
 	if err != nil {
 		return nil, err
 	}
-	request.Header.Set(apiSecretHeaderName, tokenString)
 
-	request.Header.Add("Content-Type", "application/json")
+	return c.send(ctx, request, contentType)
+}
+
+func (c *GitlabNetClient) send(ctx context.Context, request *retryablehttp.Request, contentType string) (*http.Response, error) {
+	user, password := c.user, c.password
+	if user != "" && password != "" {
+		request.SetBasicAuth(user, password)
+	}
+
+	request.Header.Add("Content-Type", contentType)
 	request.Header.Add("User-Agent", c.userAgent)
+	request.Header.Set(apiVersionHeader, strconv.Itoa(APIVersion))
 
-	response, respErr := c.httpClient.RetryableHTTP.Do(request)
+	if c.httpClient.strictResponses {
+		request = request.WithContext(withStrictResponses(request.Context()))
+	}
+
+	var response *http.Response
+	var respErr error
+
+	secrets := append([]string{c.secret}, c.previousSecrets...)
+	for i, secret := range secrets {
+		tokenString, err := signJWT(ctx, secret)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set(apiSecretHeaderName, tokenString)
+
+		response, respErr = c.intercept(request.Request, func(r *http.Request) (*http.Response, error) {
+			request.Request = r
+			return c.httpClient.do(request)
+		})
+		if respErr != nil || response.StatusCode != http.StatusUnauthorized {
+			if i > 0 && respErr == nil {
+				log.WithContextFields(ctx, log.Fields{"path": request.URL.Path, "previous_secret": i}).Warn("GitLab refused the secret but accepted a previous one")
+			}
+
+			break
+		}
+
+		// GitLab may not know the secret yet while it's rotated
+		if i < len(secrets)-1 {
+			_ = response.Body.Close()
+		}
+	}
+	// A GitLab that doesn't support this version may fail the request in
+	// any way, so its versions are checked first
+	if respErr == nil && response != nil {
+		if err := checkAPIVersion(response); err != nil {
+			_ = response.Body.Close()
+			return nil, err
+		}
+	}
 	if err := parseError(response, respErr); err != nil {
 		return nil, err
 	}
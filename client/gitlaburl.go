@@ -0,0 +1,88 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ErrInvalidGitLabURL is returned by NormalizeGitLabURL for URLs that can't
+// be used as a GitLab URL
+var ErrInvalidGitLabURL = errors.New("invalid GitLab URL")
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// NormalizeGitLabURL canonicalizes a GitLab URL so that equivalent forms
+// compare equal: the scheme and host are lowercased, default ports are
+// dropped and trailing slashes are removed from the path. Named pipes are
+// given by their full path. Only http, https, http+unix, https+unix,
+// http+npipe and https+npipe URLs are accepted.
+func NormalizeGitLabURL(u string) (string, error) {
+	if scheme, socket, ok := strings.Cut(strings.TrimSpace(u), "://"); ok {
+		switch scheme = strings.ToLower(scheme); scheme {
+		case "http+npipe", "https+npipe":
+			if strings.Trim(socket, `/\`) == "" {
+				return "", fmt.Errorf("%w: %q has no pipe name", ErrInvalidGitLabURL, u)
+			}
+
+			return scheme + "://" + namedPipePath(socket), nil
+		case "http+unix", "https+unix":
+			// Abstract socket names and Windows paths aren't URL paths, and
+			// are kept as they are
+			if strings.HasPrefix(socket, "@") || isWindowsPath(socket) {
+				return scheme + "://" + socket, nil
+			}
+		}
+	}
+
+	parsed, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidGitLabURL, err)
+	}
+
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	switch parsed.Scheme {
+	case "http", "https":
+		if parsed.Hostname() == "" {
+			return "", fmt.Errorf("%w: %q has no host", ErrInvalidGitLabURL, u)
+		}
+
+		host := strings.ToLower(parsed.Hostname())
+		port := parsed.Port()
+		if port == "" || port == defaultPorts[parsed.Scheme] {
+			// Hostname strips the brackets of IPv6 addresses
+			if strings.Contains(host, ":") {
+				host = "[" + host + "]"
+			}
+			parsed.Host = host
+		} else {
+			parsed.Host = net.JoinHostPort(host, port)
+		}
+	case "http+unix", "https+unix":
+		if parsed.Path == "" {
+			return "", fmt.Errorf("%w: %q has no socket path", ErrInvalidGitLabURL, u)
+		}
+	default:
+		return "", fmt.Errorf("%w: unsupported scheme in %q", ErrInvalidGitLabURL, u)
+	}
+
+	parsed.Path = strings.TrimRight(parsed.Path, "/")
+	parsed.RawPath = ""
+
+	return parsed.String(), nil
+}
+
+// isWindowsPath tells whether path is a Windows path, such as
+// C:\ProgramData\gitlab\gitlab.socket or C:/ProgramData/gitlab/gitlab.socket
+func isWindowsPath(path string) bool {
+	if strings.Contains(path, `\`) {
+		return true
+	}
+
+	return len(path) >= 2 && path[1] == ':' && ('a' <= path[0]|0x20 && path[0]|0x20 <= 'z')
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeGitLabURL(t *testing.T) {
+	tests := []struct {
+		desc string
+		url  string
+		want string
+	}{
+		{desc: "already normalized", url: "https://gitlab.example.com", want: "https://gitlab.example.com"},
+		{desc: "scheme case", url: "HTTPS://gitlab.example.com", want: "https://gitlab.example.com"},
+		{desc: "host case", url: "https://GitLab.Example.com", want: "https://gitlab.example.com"},
+		{desc: "default HTTPS port", url: "https://gitlab.example.com:443", want: "https://gitlab.example.com"},
+		{desc: "default HTTP port", url: "http://gitlab.example.com:80", want: "http://gitlab.example.com"},
+		{desc: "non-default port", url: "https://gitlab.example.com:8443", want: "https://gitlab.example.com:8443"},
+		{desc: "HTTP port on HTTPS", url: "https://gitlab.example.com:80", want: "https://gitlab.example.com:80"},
+		{desc: "IPv6 with default port", url: "https://[2001:DB8::1]:443", want: "https://[2001:db8::1]"},
+		{desc: "IPv6 with port", url: "http://[2001:db8::1]:8080", want: "http://[2001:db8::1]:8080"},
+		{desc: "trailing slash", url: "https://gitlab.example.com/", want: "https://gitlab.example.com"},
+		{desc: "trailing slashes after a path", url: "https://gitlab.example.com/gitlab//", want: "https://gitlab.example.com/gitlab"},
+		{desc: "unix socket", url: "http+unix:///var/opt/gitlab/gitlab-workhorse/sockets/socket", want: "http+unix:///var/opt/gitlab/gitlab-workhorse/sockets/socket"},
+		{desc: "unix socket scheme case", url: "HTTP+UNIX:///tmp/gitlab.socket/", want: "http+unix:///tmp/gitlab.socket"},
+		{desc: "TLS over a unix socket", url: "https+unix:///tmp/gitlab.socket", want: "https+unix:///tmp/gitlab.socket"},
+		{desc: "abstract unix socket", url: "HTTP+UNIX://@gitlab-workhorse", want: "http+unix://@gitlab-workhorse"},
+		{desc: "unix socket at a Windows path", url: `http+unix://C:\ProgramData\gitlab\gitlab.socket`, want: `http+unix://C:\ProgramData\gitlab\gitlab.socket`},
+		{desc: "named pipe", url: "HTTP+NPIPE:////./pipe/gitlab-workhorse", want: `http+npipe://\\.\pipe\gitlab-workhorse`},
+		{desc: "named pipe by name", url: "https+npipe://gitlab-workhorse", want: `https+npipe://\\.\pipe\gitlab-workhorse`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := NormalizeGitLabURL(tc.url)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestNormalizeGitLabURLInvalid(t *testing.T) {
+	for _, u := range []string{
+		"",
+		"gitlab.example.com",
+		"ftp://gitlab.example.com",
+		"https://",
+		"https://gitlab.example.com:port",
+		"http+unix://",
+		"http+npipe://",
+		"http+npipe:////",
+	} {
+		t.Run(u, func(t *testing.T) {
+			_, err := NormalizeGitLabURL(u)
+			require.ErrorIs(t, err, ErrInvalidGitLabURL)
+		})
+	}
+}
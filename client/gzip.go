@@ -0,0 +1,152 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	gzipEncoding = "gzip"
+
+	// DefaultGzipMinSize is the size of the smallest request body compressed
+	// when WithGzip is given no size
+	DefaultGzipMinSize = 1024
+)
+
+// WithGzip compresses the JSON request bodies of at least minSize bytes with
+// gzip, sent with a "Content-Encoding: gzip" header, and advertises gzip
+// support to the internal API, transparently decompressing the responses
+// with a "Content-Encoding: gzip" header. Request bodies that compress to no
+// smaller are sent as they are. A minSize below 1 selects
+// DefaultGzipMinSize.
+func WithGzip(minSize int) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		if minSize < 1 {
+			minSize = DefaultGzipMinSize
+		}
+
+		hcc.gzipMinSize = minSize
+	}
+}
+
+type gzipTransport struct {
+	next    http.RoundTripper
+	minSize int
+}
+
+func newGzipTransport(next http.RoundTripper, minSize int) http.RoundTripper {
+	if minSize <= 0 {
+		return next
+	}
+
+	return &gzipTransport{next: next, minSize: minSize}
+}
+
+func (rt *gzipTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request, err := rt.compressBody(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// WithBrotli advertises its encoding first
+	if accepted := request.Header.Get("Accept-Encoding"); !strings.Contains(accepted, gzipEncoding) {
+		if accepted != "" {
+			accepted += ", "
+		}
+
+		request = request.Clone(request.Context())
+		request.Header.Set("Accept-Encoding", accepted+gzipEncoding)
+	}
+
+	response, err := rt.next.RoundTrip(request)
+	if err != nil {
+		return response, err
+	}
+
+	if !strings.EqualFold(response.Header.Get("Content-Encoding"), gzipEncoding) {
+		return response, nil
+	}
+
+	response.Body = &gzipBody{body: response.Body}
+	response.Header.Del("Content-Encoding")
+	response.Header.Del("Content-Length")
+	response.ContentLength = -1
+	response.Uncompressed = true
+
+	return response, nil
+}
+
+// compressBody returns request with its body compressed, when it's JSON of
+// at least minSize bytes. The streamed bodies, of unknown or other content,
+// aren't buffered to be compressed.
+func (rt *gzipTransport) compressBody(request *http.Request) (*http.Request, error) {
+	if request.Body == nil || request.Body == http.NoBody || request.ContentLength < int64(rt.minSize) {
+		return request, nil
+	}
+
+	if request.Header.Get("Content-Encoding") != "" {
+		return request, nil
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type")); mediaType != "application/json" {
+		return request, nil
+	}
+
+	body, err := io.ReadAll(request.Body)
+	_ = request.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	compressed := &bytes.Buffer{}
+	writer := gzip.NewWriter(compressed)
+	if _, err := writer.Write(body); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	request = request.Clone(request.Context())
+
+	// The body is sent as it is when compressing doesn't pay off
+	data := body
+	if compressed.Len() < len(body) {
+		data = compressed.Bytes()
+		request.Header.Set("Content-Encoding", gzipEncoding)
+	}
+
+	request.Body = io.NopCloser(bytes.NewReader(data))
+	request.ContentLength = int64(len(data))
+	request.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	return request, nil
+}
+
+// gzipBody decompresses a response body, whose gzip header is read on the
+// first read: an empty body reads as empty
+type gzipBody struct {
+	body   io.ReadCloser
+	reader *gzip.Reader
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.reader == nil {
+		reader, err := gzip.NewReader(b.body)
+		if err != nil {
+			return 0, err
+		}
+
+		b.reader = reader
+	}
+
+	return b.reader.Read(p)
+}
+
+func (b *gzipBody) Close() error { return b.body.Close() }
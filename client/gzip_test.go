@@ -0,0 +1,105 @@
+package client
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGzip(t *testing.T) {
+	var received struct {
+		encoding       string
+		acceptEncoding string
+		body           string
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.encoding = r.Header.Get("Content-Encoding")
+		received.acceptEncoding = r.Header.Get("Accept-Encoding")
+
+		var body io.Reader = r.Body
+		if received.encoding == "gzip" {
+			reader, err := gzip.NewReader(r.Body)
+			require.NoError(t, err)
+			body = reader
+		}
+
+		data, err := io.ReadAll(body)
+		require.NoError(t, err)
+		received.body = string(data)
+
+		if strings.HasSuffix(r.URL.Path, "/identity") {
+			w.Write([]byte(`{"message": "Hello, identity"}`))
+			return
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(w)
+		_, err = gw.Write([]byte(`{"message": "Hello, gzip"}`))
+		require.NoError(t, err)
+		require.NoError(t, gw.Close())
+	}))
+	t.Cleanup(server.Close)
+
+	httpClient, err := NewHTTPClientWithOpts(server.URL, "", "", "", 1, []HTTPClientOpt{WithGzip(64), WithBrotli()})
+	require.NoError(t, err)
+
+	client, err := NewGitlabNetClient("", "", "secret", httpClient)
+	require.NoError(t, err)
+
+	tests := []struct {
+		desc             string
+		path             string
+		data             interface{}
+		expectedEncoding string
+		expectedMessage  string
+	}{
+		{
+			desc:             "large body",
+			path:             "/gzip",
+			data:             map[string]string{"keys": strings.Repeat("ssh-ed25519 AAAA ", 10)},
+			expectedEncoding: "gzip",
+			expectedMessage:  "Hello, gzip",
+		},
+		{
+			desc:            "small body",
+			path:            "/gzip",
+			data:            map[string]string{"key": "ssh-ed25519 AAAA"},
+			expectedMessage: "Hello, gzip",
+		},
+		{
+			desc:             "identity response",
+			path:             "/identity",
+			data:             map[string]string{"keys": strings.Repeat("ssh-ed25519 AAAA ", 10)},
+			expectedEncoding: "gzip",
+			expectedMessage:  "Hello, identity",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			resp, err := client.Post(context.Background(), tc.path, tc.data)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			expectedBody, err := json.Marshal(tc.data)
+			require.NoError(t, err)
+
+			require.Equal(t, tc.expectedEncoding, received.encoding)
+			require.Equal(t, string(expectedBody), received.body)
+			require.Equal(t, "br, gzip", received.acceptEncoding)
+
+			var response ErrorResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+			require.Equal(t, tc.expectedMessage, response.Message)
+			require.Empty(t, resp.Header.Get("Content-Encoding"))
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// ErrMissingResponseHeader is returned when a response lacks the header
+// configured with WithRequireResponseHeader, which suggests the request
+// reached something other than the internal API
+var ErrMissingResponseHeader = errors.New("response is missing the expected header")
+
+type requiredHeader struct {
+	name, value string
+}
+
+// WithRequireResponseHeader fails requests whose response doesn't carry the
+// named header. When value is non-empty the header must also have that value.
+func WithRequireResponseHeader(name, value string) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.requiredHeaders = append(hcc.requiredHeaders, requiredHeader{name: name, value: value})
+	}
+}
+
+type requiredHeaderTransport struct {
+	next    http.RoundTripper
+	headers []requiredHeader
+}
+
+func newRequiredHeaderTransport(next http.RoundTripper, headers []requiredHeader) http.RoundTripper {
+	if len(headers) == 0 {
+		return next
+	}
+
+	return &requiredHeaderTransport{next: next, headers: headers}
+}
+
+func (rt *requiredHeaderTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := rt.next.RoundTrip(request)
+	if err != nil {
+		return response, err
+	}
+
+	for _, h := range rt.headers {
+		values, ok := response.Header[http.CanonicalHeaderKey(h.name)]
+		if !ok {
+			_ = response.Body.Close()
+			return nil, fmt.Errorf("%w: %s", ErrMissingResponseHeader, h.name)
+		}
+
+		if h.value != "" && !slices.Contains(values, h.value) {
+			_ = response.Body.Close()
+			return nil, fmt.Errorf("%w: %s is not %q", ErrMissingResponseHeader, h.name, h.value)
+		}
+	}
+
+	return response, nil
+}
+
+// WithDefaultHeaders adds h to every request, e.g. to identify the deployment
+// for tracing. Headers already set on a request are left alone.
+func WithDefaultHeaders(h http.Header) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		if hcc.defaultHeaders == nil {
+			hcc.defaultHeaders = http.Header{}
+		}
+
+		for name, values := range h {
+			hcc.defaultHeaders[http.CanonicalHeaderKey(name)] = append(hcc.defaultHeaders[http.CanonicalHeaderKey(name)], values...)
+		}
+	}
+}
+
+type defaultHeaderTransport struct {
+	next    http.RoundTripper
+	headers http.Header
+}
+
+func newDefaultHeaderTransport(next http.RoundTripper, headers http.Header) http.RoundTripper {
+	if len(headers) == 0 {
+		return next
+	}
+
+	return &defaultHeaderTransport{next: next, headers: headers}
+}
+
+func (rt *defaultHeaderTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request it's given
+	request = request.Clone(request.Context())
+
+	for name, values := range rt.headers {
+		if _, ok := request.Header[name]; !ok {
+			request.Header[name] = slices.Clone(values)
+		}
+	}
+
+	return rt.next.RoundTrip(request)
+}
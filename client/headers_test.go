@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequireResponseHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/present":
+			w.Header().Set("X-Gitlab-Internal-Api", "true")
+		case "/mismatched":
+			w.Header().Set("X-Gitlab-Internal-Api", "false")
+		}
+		w.Write([]byte("Hello"))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewHTTPClientWithOpts(server.URL, "", "", "", 1, []HTTPClientOpt{
+		WithHTTPRetryOpts(0, 0, 0),
+		WithRequireResponseHeader("X-Gitlab-Internal-Api", "true"),
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		desc      string
+		path      string
+		wantError string
+	}{
+		{desc: "present", path: "/present"},
+		{desc: "absent", path: "/absent", wantError: "response is missing the expected header: X-Gitlab-Internal-Api"},
+		{desc: "mismatched value", path: "/mismatched", wantError: `response is missing the expected header: X-Gitlab-Internal-Api is not "true"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL+tc.path, nil)
+			require.NoError(t, err)
+
+			resp, err := client.RetryableHTTP.HTTPClient.Do(req)
+			if tc.wantError == "" {
+				require.NoError(t, err)
+				require.NoError(t, resp.Body.Close())
+				return
+			}
+
+			require.ErrorIs(t, err, ErrMissingResponseHeader)
+			require.ErrorContains(t, err, tc.wantError)
+		})
+	}
+}
+
+func TestRequireResponseHeaderAnyValue(t *testing.T) {
+	next := roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"X-Gitlab-Internal-Api": {"anything"}}, Body: http.NoBody}, nil
+	})
+	rt := newRequiredHeaderTransport(next, []requiredHeader{{name: "x-gitlab-internal-api"}})
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost", nil)
+	require.NoError(t, err)
+
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestWithDefaultHeaders(t *testing.T) {
+	var got http.Header
+	next := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		got = r.Header
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+
+	hcc := &httpClientCfg{}
+	WithDefaultHeaders(http.Header{
+		"X-Gitlab-Shell-Version": {"14.0.0"},
+		"x-multi":                {"a", "b"},
+		"X-Overridden":           {"default"},
+	})(hcc)
+	rt := newDefaultHeaderTransport(next, hcc.defaultHeaders)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Overridden", "request")
+
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	require.Equal(t, "14.0.0", got.Get("X-Gitlab-Shell-Version"))
+	require.Equal(t, []string{"a", "b"}, got.Values("X-Multi"))
+	require.Equal(t, []string{"request"}, got.Values("X-Overridden"))
+	require.Equal(t, http.Header{"X-Overridden": {"request"}}, req.Header, "the original request is left alone")
+}
+
+func TestWithDefaultHeadersEmpty(t *testing.T) {
+	next := roundTripperFunc(func(*http.Request) (*http.Response, error) { return nil, nil })
+
+	hcc := &httpClientCfg{}
+	WithDefaultHeaders(http.Header{})(hcc)
+
+	_, isDefault := newDefaultHeaderTransport(next, hcc.defaultHeaders).(*defaultHeaderTransport)
+	require.False(t, isDefault)
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// HTTP2Settings enable HTTP/2 to GitLab, so that concurrent requests share a
+// connection as streams rather than each opening its own. HTTPS URLs
+// negotiate it, falling back to HTTP/1.1 with servers that don't support it.
+type HTTP2Settings struct {
+	// Cleartext speaks HTTP/2 without TLS (h2c) to http:// and http+unix://
+	// URLs. The server must support it: there is no fallback to HTTP/1.1,
+	// and no proxy is used.
+	Cleartext bool
+	// StrictMaxConcurrentStreams makes the requests beyond the limit of
+	// concurrent streams advertised by the server wait for a stream of an
+	// open connection, rather than opening another connection
+	StrictMaxConcurrentStreams bool
+	// ReadIdleTimeout is how long a connection may receive no frame before a
+	// ping checks its health. Pings are disabled by default.
+	ReadIdleTimeout time.Duration
+	// PingTimeout is how long a ping may go unanswered before the connection
+	// is closed, 15 seconds by default
+	PingTimeout time.Duration
+}
+
+// WithHTTP2 enables HTTP/2 with settings. Connections are kept open for
+// reuse, as with TransportSettings.IdleConnTimeout.
+func WithHTTP2(settings HTTP2Settings) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.http2 = &settings
+	}
+}
+
+// roundTripper returns the round tripper speaking HTTP/2 over transport, or
+// transport itself when HTTP/2 isn't enabled for it
+func (s *HTTP2Settings) roundTripper(transport *http.Transport) (http.RoundTripper, error) {
+	if s == nil {
+		return transport, nil
+	}
+
+	if transport.TLSClientConfig != nil {
+		h2, err := http2.ConfigureTransports(transport)
+		if err != nil {
+			return nil, err
+		}
+		s.apply(h2)
+
+		return transport, nil
+	}
+
+	if !s.Cleartext {
+		return transport, nil
+	}
+
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	h2 := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dial(ctx, network, addr)
+		},
+		IdleConnTimeout: transport.IdleConnTimeout,
+	}
+	s.apply(h2)
+
+	return h2, nil
+}
+
+func (s *HTTP2Settings) apply(h2 *http2.Transport) {
+	h2.StrictMaxConcurrentStreams = s.StrictMaxConcurrentStreams
+	h2.ReadIdleTimeout = s.ReadIdleTimeout
+	h2.PingTimeout = s.PingTimeout
+}
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// protoServer records the protocol and the client address of the requests
+type protoServer struct {
+	mu     sync.Mutex
+	protos []string
+	addrs  map[string]bool
+}
+
+func (s *protoServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.protos = append(s.protos, r.Proto)
+	s.addrs[r.RemoteAddr] = true
+}
+
+func newProtoServer() *protoServer {
+	return &protoServer{addrs: make(map[string]bool)}
+}
+
+func TestHTTP2(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		settings       *HTTP2Settings
+		tls, cleartext bool
+		expectedProto  string
+	}{
+		{desc: "https", settings: &HTTP2Settings{}, tls: true, expectedProto: "HTTP/2.0"},
+		{desc: "https by default", tls: true, expectedProto: "HTTP/1.1"},
+		{desc: "h2c", settings: &HTTP2Settings{Cleartext: true}, cleartext: true, expectedProto: "HTTP/2.0"},
+		{desc: "http without cleartext", settings: &HTTP2Settings{}, expectedProto: "HTTP/1.1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := newProtoServer()
+
+			var handler http.Handler = s
+			if tc.cleartext {
+				handler = h2c.NewHandler(s, &http2.Server{})
+			}
+
+			server := httptest.NewUnstartedServer(handler)
+			var opts []HTTPClientOpt
+			if tc.tls {
+				server.EnableHTTP2 = true
+				server.StartTLS()
+				opts = append(opts, WithCACertPEM(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})))
+			} else {
+				server.Start()
+			}
+			t.Cleanup(server.Close)
+
+			if tc.settings != nil {
+				opts = append(opts, WithHTTP2(*tc.settings))
+			}
+
+			client, err := NewHTTPClientWithOpts(server.URL, "", "", "", 0, opts)
+			require.NoError(t, err)
+
+			for i := 0; i < 3; i++ {
+				require.NoError(t, get(t, client, server.URL))
+			}
+
+			require.Equal(t, []string{tc.expectedProto, tc.expectedProto, tc.expectedProto}, s.protos)
+			if tc.settings != nil && tc.expectedProto == "HTTP/2.0" {
+				require.Len(t, s.addrs, 1, "the requests share a connection")
+			}
+		})
+	}
+}
+
+func TestHTTP2ConcurrentRequests(t *testing.T) {
+	s := newProtoServer()
+	release := make(chan struct{})
+
+	server := httptest.NewUnstartedServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		s.ServeHTTP(w, r)
+	}), &http2.Server{}))
+	server.Start()
+	t.Cleanup(server.Close)
+
+	settings := HTTP2Settings{Cleartext: true, StrictMaxConcurrentStreams: true, ReadIdleTimeout: time.Minute}
+	client, err := NewHTTPClientWithOpts(server.URL, "", "", "", 0, []HTTPClientOpt{WithHTTP2(settings)})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, get(t, client, server.URL))
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	require.Len(t, s.protos, 5)
+	require.Len(t, s.addrs, 1, "concurrent requests are streams of a connection")
+}
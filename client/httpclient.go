@@ -9,17 +9,23 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/gitlab-org/labkit/log"
 )
 
 const (
 	socketBaseURL             = "http://unix"
+	socketTLSBaseURL          = "https://localhost"
 	unixSocketProtocol        = "http+unix://"
+	unixSocketTLSProtocol     = "https+unix://"
+	namedPipeProtocol         = "http+npipe://"
+	namedPipeTLSProtocol      = "https+npipe://"
 	httpProtocol              = "http://"
 	httpsProtocol             = "https://"
 	defaultReadTimeoutSeconds = 300
@@ -31,17 +37,90 @@ const (
 // ErrCafileNotFound indicates that the specified CA file was not found
 var ErrCafileNotFound = errors.New("cafile not found")
 
+// ErrNoGitLabURL is returned by NewHTTPClientWithFailover when it isn't
+// given any URL
+var ErrNoGitLabURL = errors.New("no GitLab URL given")
+
+// ErrInvalidRelativeURLRoot is returned when the relative URL root is a URL
+// rather than a path
+var ErrInvalidRelativeURLRoot = errors.New("relative URL root must be a path")
+
+// ErrSocketNotFound indicates that the unix socket of the GitLab URL was not
+// found
+var ErrSocketNotFound = errors.New("unix socket not found")
+
+// ErrNamedPipesUnsupported is returned when connecting to http+npipe and
+// https+npipe URLs outside of Windows
+var ErrNamedPipesUnsupported = errors.New("named pipes are only supported on Windows")
+
+// ErrNoCertsInCAFile indicates that no PEM encoded certificates could be
+// parsed from a CA file, e.g. because it is DER encoded
+var ErrNoCertsInCAFile = errors.New("no PEM encoded certificates found in CA file")
+
+// ErrNoCertsInPEM indicates that no certificates could be parsed from the
+// bytes given to WithCACertPEM
+var ErrNoCertsInPEM = errors.New("no CA certificates found in PEM data")
+
 // HTTPClient provides an HTTP client with retry capabilities
 type HTTPClient struct {
 	RetryableHTTP *retryablehttp.Client
 	Host          string
+	healthPath    string
+	socketPath    string
+	trustedCAs    *trustedCAs
+
+	attemptObserver      AttemptObserver
+	metrics              *Metrics
+	slowRequestThreshold time.Duration
+	strictResponses      bool
+	endpointPolicies     *endpointPolicies
 }
 
 type httpClientCfg struct {
 	keyPath, certPath          string
 	caFile, caPath             string
+	caPEMs                     [][]byte
 	retryWaitMin, retryWaitMax time.Duration
 	retryMax                   int
+	tlsVerificationCache       bool
+	phaseTimeouts              PhaseTimeouts
+	requiredHeaders            []requiredHeader
+	brotli                     bool
+	gzipMinSize                int
+	transport                  *http.Transport
+	basicAuth                  *basicAuth
+	dnsCacheTTL                time.Duration
+	withoutSNI                 bool
+	tlsServerName              string
+	healthPath                 string
+	operationRateLimits        map[string]RateLimit
+	rateLimits                 RateLimits
+	validateSocket             bool
+	defaultHeaders             http.Header
+	responseSchemas            []responseSchema
+	pinnedCerts                []string
+	trustedCAs                 *trustedCAs
+	checkRetry                 retryablehttp.CheckRetry
+	retryStatusCodes           []int
+	retryAfterMax              time.Duration
+	fullJitter                 bool
+	retryIdempotentOnly        bool
+	perAttemptTimeout          time.Duration
+	attemptObserver            AttemptObserver
+	ocspStapleCheck            bool
+	ocspStapleRequired         bool
+	logger                     retryablehttp.LeveledLogger
+	proxyURL                   string
+	transportSettings          TransportSettings
+	http2                      *HTTP2Settings
+	circuitBreaker             *circuitBreaker
+	jwtSecretFile              string
+	loadBalancing              bool
+	metrics                    *Metrics
+	slowRequestThreshold       time.Duration
+	strictResponses            bool
+	endpointPolicies           map[string]EndpointPolicy
+	endpoints                  *endpointPolicies
 }
 
 func (hcc httpClientCfg) HaveCertAndKey() bool { return hcc.keyPath != "" && hcc.certPath != "" }
@@ -67,6 +146,117 @@ func WithHTTPRetryOpts(waitMin, waitMax time.Duration, maxAttempts int) HTTPClie
 	}
 }
 
+// WithCACertPEM adds the PEM encoded certificates in pem to the trusted CAs,
+// alongside those from caFile and caPath. It may be given more than once.
+func WithCACertPEM(pem []byte) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.caPEMs = append(hcc.caPEMs, pem)
+	}
+}
+
+// WithValidateSocket makes NewHTTPClientWithOpts check that the unix socket
+// of a http+unix URL exists, rather than failing on the first request. Leave
+// it unset when the socket may be created after the client. Named pipes
+// aren't checked.
+func WithValidateSocket() HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.validateSocket = true
+	}
+}
+
+// WithRetryPolicy replaces retryablehttp.DefaultRetryPolicy as the policy
+// deciding whether a request is retried. The restrictions of other options,
+// such as WithRetryIdempotentOnly, still apply on top of it.
+func WithRetryPolicy(policy retryablehttp.CheckRetry) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.checkRetry = policy
+	}
+}
+
+// WithTransport makes the HttpClient use a copy of t as its base transport.
+// The TLS settings derived from the other options are still applied where t
+// doesn't set them. Since t can't be made to dial a unix socket, it must
+// provide its own DialContext when used with a unix socket URL.
+func WithTransport(t *http.Transport) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.transport = t
+	}
+}
+
+// WithTLSVerificationCache enables TLS session resumption and caches the
+// result of verifying the server's certificate chain, keyed on the leaf
+// certificate fingerprint. A changed certificate is always verified in full.
+func WithTLSVerificationCache() HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.tlsVerificationCache = true
+	}
+}
+
+// socketAddress is the unix socket or Windows named pipe of a GitLab URL
+type socketAddress struct {
+	// network is "unix" or "npipe"
+	network string
+	path    string
+}
+
+// parseSocketURL returns the socket of an http+unix, https+unix, http+npipe
+// or https+npipe gitlabURL, and whether it is one. A unix socket path
+// starting with @ names an abstract socket, on Linux.
+func parseSocketURL(gitlabURL string) (socket socketAddress, isTLS bool, ok bool) {
+	for _, protocol := range []struct {
+		prefix  string
+		network string
+		isTLS   bool
+	}{
+		{unixSocketProtocol, "unix", false},
+		{unixSocketTLSProtocol, "unix", true},
+		{namedPipeProtocol, "npipe", false},
+		{namedPipeTLSProtocol, "npipe", true},
+	} {
+		if path, ok := strings.CutPrefix(gitlabURL, protocol.prefix); ok {
+			if protocol.network == "npipe" {
+				path = namedPipePath(path)
+			}
+
+			return socketAddress{network: protocol.network, path: path}, protocol.isTLS, true
+		}
+	}
+
+	return socketAddress{}, false, false
+}
+
+// namedPipePath returns the path of a named pipe given as in
+// http+npipe:////./pipe/gitlab, with slashes or backslashes, or by its name
+// alone for a pipe of the local machine, as in http+npipe://gitlab
+func namedPipePath(path string) string {
+	path = strings.ReplaceAll(path, "/", `\`)
+	if strings.HasPrefix(path, `\\`) {
+		return path
+	}
+
+	return `\\.\pipe\` + strings.TrimLeft(path, `\`)
+}
+
+func validateSocket(socket socketAddress) error {
+	// Named pipes can't be checked without connecting to them, and abstract
+	// sockets have no file
+	if socket.network != "unix" || strings.HasPrefix(socket.path, "@") {
+		return nil
+	}
+
+	socketPath := socket.path
+
+	if _, err := os.Stat(socketPath); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("unix socket not found at '%s': %w", socketPath, ErrSocketNotFound)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
 func validateCaFile(filename string) error {
 	if filename == "" {
 		return nil
@@ -85,116 +275,495 @@ func validateCaFile(filename string) error {
 
 // NewHTTPClientWithOpts builds an HTTP client using the provided options
 func NewHTTPClientWithOpts(gitlabURL, gitlabRelativeURLRoot, caFile, caPath string, readTimeoutSeconds uint64, opts []HTTPClientOpt) (*HTTPClient, error) {
+	return NewHTTPClientWithFailover([]string{gitlabURL}, gitlabRelativeURLRoot, caFile, caPath, readTimeoutSeconds, opts)
+}
+
+// NewHTTPClientWithFailover builds an HTTP client that sends requests to the
+// first of gitlabURLs that can be connected to, in order, or to each in turn
+// with WithLoadBalancing. The URLs may use different schemes, e.g. a unix
+// socket with an HTTPS fallback, and srv+http and srv+https URLs stand for
+// all the targets of their DNS SRV name. Credentials in the URLs are used as
+// with NewHTTPClientWithOpts; the first found apply to all of them.
+func NewHTTPClientWithFailover(gitlabURLs []string, gitlabRelativeURLRoot, caFile, caPath string, readTimeoutSeconds uint64, opts []HTTPClientOpt) (*HTTPClient, error) {
+	if len(gitlabURLs) == 0 {
+		return nil, ErrNoGitLabURL
+	}
+
+	if err := validateRelativeURLRoot(gitlabRelativeURLRoot); err != nil {
+		return nil, err
+	}
+
 	hcc := &httpClientCfg{
 		caFile:       caFile,
 		caPath:       caPath,
 		retryWaitMin: defaultRetryWaitMinimum,
 		retryWaitMax: defaultRetryWaitMaximum,
 		retryMax:     defaultRetryMax,
+		healthPath:   defaultHealthPath,
 	}
 
 	for _, opt := range opts {
 		opt(hcc)
 	}
+	hcc.endpoints = newEndpointPolicies(hcc.endpointPolicies, readTimeout(readTimeoutSeconds), hcc.retryMax)
 
+	gitlabURLs, err := expandSRVURLs(defaultSRVResolver, gitlabURLs)
+	if err != nil {
+		return nil, err
+	}
+
+	backends, err := buildBackends(hcc, gitlabURLs, gitlabRelativeURLRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	rt, err := wrapTransport(*hcc, newFailoverTransport(backends, hcc.loadBalancing))
+	if err != nil {
+		return nil, err
+	}
+
+	c := retryablehttp.NewClient()
+	c.RetryMax = hcc.retryMax
+	c.RetryWaitMax = hcc.retryWaitMax
+	c.RetryWaitMin = hcc.retryWaitMin
+	configureLogger(c, hcc.logger)
+	c.CheckRetry = retryPolicy(*hcc)
+	c.Backoff = backoffPolicy(*hcc)
+	c.HTTPClient.Transport = newTransport(rt, hcc.transportSettings.reusesConnections() || hcc.http2 != nil)
+	c.HTTPClient.Timeout = readTimeout(readTimeoutSeconds)
+	if hcc.endpoints != nil {
+		// The transport applies the timeout of each endpoint instead
+		c.HTTPClient.Timeout = 0
+		c.RetryMax = hcc.endpoints.maxRetries()
+	}
+
+	client := &HTTPClient{
+		RetryableHTTP: c,
+		Host:          backends[0].host,
+		healthPath:    hcc.healthPath,
+		socketPath:    backends[0].socketPath,
+		trustedCAs:    hcc.trustedCAs,
+
+		attemptObserver:      hcc.attemptObserver,
+		metrics:              hcc.metrics,
+		slowRequestThreshold: hcc.slowRequestThreshold,
+		strictResponses:      hcc.strictResponses,
+		endpointPolicies:     hcc.endpoints,
+	}
+
+	return client, nil
+}
+
+// buildBackends builds a transport for each of gitlabURLs, taking the basic
+// auth credentials from the first URL holding any unless WithBasicAuth is set
+func buildBackends(hcc *httpClientCfg, gitlabURLs []string, gitlabRelativeURLRoot string) ([]backend, error) {
+	var urlCredentials *basicAuth
+	backends := make([]backend, 0, len(gitlabURLs))
+
+	// Load the CAs once for all HTTPS URLs
+	for _, gitlabURL := range gitlabURLs {
+		if _, isTLSSocket, _ := parseSocketURL(gitlabURL); isTLSSocket || strings.HasPrefix(gitlabURL, httpsProtocol) {
+			trust, err := buildCertPool(*hcc)
+			if err != nil {
+				return nil, err
+			}
+			hcc.trustedCAs = trust
+			break
+		}
+	}
+
+	for _, gitlabURL := range gitlabURLs {
+		gitlabURL, credentials, err := stripURLCredentials(gitlabURL)
+		if err != nil {
+			return nil, err
+		}
+		if urlCredentials == nil {
+			urlCredentials = credentials
+		}
+
+		transport, host, err := buildTransport(*hcc, gitlabURL, gitlabRelativeURLRoot)
+		if err != nil {
+			return nil, err
+		}
+
+		rt, err := hcc.http2.roundTripper(transport)
+		if err != nil {
+			return nil, err
+		}
+
+		b := backend{host: host, transport: rt}
+		// curl can't connect to named pipes
+		if socket, _, _ := parseSocketURL(gitlabURL); socket.network == "unix" {
+			b.socketPath = socket.path
+		}
+		backends = append(backends, b)
+	}
+
+	if hcc.basicAuth == nil {
+		hcc.basicAuth = urlCredentials
+	}
+
+	return backends, nil
+}
+
+func buildTransport(hcc httpClientCfg, gitlabURL, gitlabRelativeURLRoot string) (*http.Transport, string, error) {
 	var transport *http.Transport
 	var host string
 	var err error
+
+	socket, isTLSSocket, isSocket := parseSocketURL(gitlabURL)
 	switch {
-	case strings.HasPrefix(gitlabURL, unixSocketProtocol):
-		transport, host = buildSocketTransport(gitlabURL, gitlabRelativeURLRoot)
+	case isSocket:
+		if hcc.validateSocket {
+			if err = validateSocket(socket); err != nil {
+				return nil, "", err
+			}
+		}
+		transport, host = buildSocketTransport(socket, hcc.transportSettings.dialer())
+		if isTLSSocket {
+			host = socketTLSBaseURL
+			if transport.TLSClientConfig, err = buildTLSConfig(hcc, "localhost"); err != nil {
+				return nil, "", err
+			}
+		}
 	case strings.HasPrefix(gitlabURL, httpProtocol):
 		transport, host = buildHTTPTransport(gitlabURL)
 	case strings.HasPrefix(gitlabURL, httpsProtocol):
-		err = validateCaFile(caFile)
+		transport, host, err = buildHTTPSTransport(hcc, gitlabURL)
 		if err != nil {
-			return nil, err
+			return nil, "", err
+		}
+	default:
+		return nil, "", errors.New("unknown GitLab URL prefix")
+	}
+	host = joinRelativeURLRoot(host, gitlabRelativeURLRoot)
+
+	if !isSocket {
+		if transport.Proxy, err = buildProxy(hcc.proxyURL); err != nil {
+			return nil, "", err
 		}
-		transport, host, err = buildHTTPSTransport(*hcc, gitlabURL)
+	}
+
+	if hcc.transport != nil {
+		transport, err = mergeTransport(hcc.transport, transport, isSocket)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
-	default:
-		return nil, errors.New("unknown GitLab URL prefix")
 	}
 
-	c := retryablehttp.NewClient()
-	c.RetryMax = hcc.retryMax
-	c.RetryWaitMax = hcc.retryWaitMax
-	c.RetryWaitMin = hcc.retryWaitMin
-	c.Logger = nil
-	c.HTTPClient.Transport = NewTransport(transport)
-	c.HTTPClient.Timeout = readTimeout(readTimeoutSeconds)
+	hcc.transportSettings.apply(transport)
+
+	if hcc.dnsCacheTTL > 0 && !isSocket {
+		transport.DialContext = withDNSCache(hcc.dnsCacheTTL, transport.DialContext)
+	}
 
-	client := &HTTPClient{RetryableHTTP: c, Host: host}
+	if hcc.withoutSNI && transport.TLSClientConfig != nil {
+		transport.DialTLSContext = dialTLSWithoutSNI(transport)
+	}
 
-	return client, nil
+	return transport, host, nil
+}
+
+// CanCompleteRetries reports whether the time remaining before the deadline
+// of ctx can accommodate an attempt followed by the first retry backoff. It is
+// a cheap pre-check for callers deciding whether to attempt a request at all.
+func (c *HTTPClient) CanCompleteRetries(ctx context.Context) bool {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return ctx.Err() == nil
+	}
+
+	remaining := time.Until(deadline)
+	if c.RetryableHTTP.RetryMax <= 0 {
+		return remaining > 0
+	}
+
+	return remaining > c.RetryableHTTP.RetryWaitMin
+}
+
+// wrapTransport layers the round trippers enabled by the options on top of
+// the base transport
+func wrapTransport(hcc httpClientCfg, base http.RoundTripper) (http.RoundTripper, error) {
+	rt := newMetricsTransport(base, hcc.metrics)
+	rt = newAttemptCountingTransport(rt, hcc.attemptObserver != nil || hcc.metrics != nil || hcc.slowRequestThreshold > 0)
+	rt = newPhaseTimeoutTransport(rt, hcc.phaseTimeouts)
+	rt = newAttemptTimeoutTransport(rt, hcc.perAttemptTimeout)
+	rt = newEndpointTimeoutTransport(rt, hcc.endpoints)
+	rt = newCircuitBreakerTransport(rt, hcc.circuitBreaker)
+	rt = newRequiredHeaderTransport(rt, hcc.requiredHeaders)
+	rt = newDefaultHeaderTransport(rt, hcc.defaultHeaders)
+	rt = newGzipTransport(rt, hcc.gzipMinSize)
+	rt = newBrotliTransport(rt, hcc.brotli)
+	rt = newBasicAuthTransport(rt, hcc.basicAuth)
+
+	rt, err := newJWTAuthTransport(rt, hcc.jwtSecretFile)
+	if err != nil {
+		return nil, err
+	}
+
+	rt = newRateLimitTransport(rt, hcc.operationRateLimits)
+	rt = newEndpointRateLimitTransport(rt, hcc.rateLimits)
+
+	rt, err = newSchemaTransport(rt, hcc.responseSchemas)
+	if err != nil {
+		return nil, err
+	}
+
+	return newIdempotencyTransport(rt, hcc.retryIdempotentOnly), nil
+}
+
+// retryPolicy layers the retry restrictions enabled by the options on top of
+// the base policy
+func retryPolicy(hcc httpClientCfg) retryablehttp.CheckRetry {
+	policy := hcc.checkRetry
+	if policy == nil {
+		policy = retryablehttp.DefaultRetryPolicy
+	}
+
+	if hcc.retryStatusCodes != nil {
+		policy = statusCodeRetryPolicy(hcc.retryStatusCodes, policy)
+	}
+
+	if hcc.retryAfterMax > 0 {
+		policy = retryAfterPolicy(hcc.retryAfterMax, policy)
+	}
+
+	if hcc.retryIdempotentOnly {
+		policy = idempotentRetryPolicy(policy)
+	}
+
+	// The retries of each request are counted for their endpoint, and for
+	// their budget to only be drawn from by the retries that happen
+	policy = retryBudgetPolicy(endpointRetryPolicy(policy))
+
+	return rateLimitRetryPolicy(circuitBreakerRetryPolicy(maintenanceRetryPolicy(policy)))
 }
 
-func buildSocketTransport(gitlabURL, gitlabRelativeURLRoot string) (*http.Transport, string) {
-	socketPath := strings.TrimPrefix(gitlabURL, unixSocketProtocol)
+// ErrTransportCannotDialSocket is returned when a custom transport without a
+// DialContext is combined with a unix socket URL
+var ErrTransportCannotDialSocket = errors.New("custom transport must set DialContext to be used with a unix socket")
+
+// mergeTransport returns a copy of custom with the settings of built applied
+// where custom leaves them unset
+func mergeTransport(custom, built *http.Transport, isSocket bool) (*http.Transport, error) {
+	if isSocket && custom.DialContext == nil {
+		return nil, ErrTransportCannotDialSocket
+	}
+
+	transport := custom.Clone()
+	if transport.DialContext == nil {
+		transport.DialContext = built.DialContext
+	}
+	if transport.Proxy == nil {
+		transport.Proxy = built.Proxy
+	}
+
+	if built.TLSClientConfig == nil {
+		return transport, nil
+	}
+
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = built.TLSClientConfig
+		return transport, nil
+	}
+
+	tlsConfig := transport.TLSClientConfig
+	if tlsConfig.RootCAs == nil {
+		tlsConfig.RootCAs = built.TLSClientConfig.RootCAs
+	}
+	if len(tlsConfig.Certificates) == 0 {
+		tlsConfig.Certificates = built.TLSClientConfig.Certificates
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = built.TLSClientConfig.ServerName
+	}
+	if tlsConfig.MinVersion == 0 {
+		tlsConfig.MinVersion = built.TLSClientConfig.MinVersion
+	}
+	if tlsConfig.ClientSessionCache == nil {
+		tlsConfig.ClientSessionCache = built.TLSClientConfig.ClientSessionCache
+	}
+	if tlsConfig.VerifyPeerCertificate == nil {
+		tlsConfig.VerifyPeerCertificate = built.TLSClientConfig.VerifyPeerCertificate
+	}
+	if tlsConfig.VerifyConnection == nil && built.TLSClientConfig.VerifyConnection != nil {
+		// InsecureSkipVerify is only ever set alongside VerifyConnection
+		tlsConfig.VerifyConnection = built.TLSClientConfig.VerifyConnection
+		tlsConfig.InsecureSkipVerify = built.TLSClientConfig.InsecureSkipVerify // #nosec G402
+	}
+
+	return transport, nil
+}
 
+func buildSocketTransport(socket socketAddress, dialer *net.Dialer) (*http.Transport, string) {
 	transport := &http.Transport{
 		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
-			dialer := net.Dialer{}
-			return dialer.DialContext(ctx, "unix", socketPath)
+			if socket.network == "npipe" {
+				return dialNamedPipe(ctx, socket.path, dialer.Timeout)
+			}
+
+			return dialer.DialContext(ctx, "unix", socket.path)
 		},
 	}
 
-	host := socketBaseURL
+	return transport, socketBaseURL
+}
+
+func validateRelativeURLRoot(gitlabRelativeURLRoot string) error {
+	if strings.Contains(gitlabRelativeURLRoot, "://") {
+		return fmt.Errorf("%w: %q", ErrInvalidRelativeURLRoot, gitlabRelativeURLRoot)
+	}
+
+	parsed, err := url.Parse(gitlabRelativeURLRoot)
+	if err != nil || parsed.Scheme != "" || parsed.Host != "" {
+		return fmt.Errorf("%w: %q", ErrInvalidRelativeURLRoot, gitlabRelativeURLRoot)
+	}
+
+	return nil
+}
+
+// joinRelativeURLRoot appends the relative URL root to host. Hosts already
+// ending with the root are returned as they are, since HTTP URLs commonly
+// include it.
+func joinRelativeURLRoot(host, gitlabRelativeURLRoot string) string {
 	gitlabRelativeURLRoot = strings.Trim(gitlabRelativeURLRoot, "/")
-	if gitlabRelativeURLRoot != "" {
-		host = host + "/" + gitlabRelativeURLRoot
+	if gitlabRelativeURLRoot == "" {
+		return host
+	}
+
+	host = strings.TrimRight(host, "/")
+	if strings.HasSuffix(host, "/"+gitlabRelativeURLRoot) {
+		return host
 	}
 
-	return transport, host
+	return host + "/" + gitlabRelativeURLRoot
 }
 
 func buildHTTPSTransport(hcc httpClientCfg, gitlabURL string) (*http.Transport, string, error) {
-	certPool, err := x509.SystemCertPool()
+	parsedURL, err := url.Parse(gitlabURL)
 	if err != nil {
-		certPool = x509.NewCertPool()
+		return nil, "", err
 	}
 
-	if hcc.caFile != "" {
-		addCertToPool(certPool, hcc.caFile)
+	tlsConfig, err := buildTLSConfig(hcc, parsedURL.Hostname())
+	if err != nil {
+		return nil, "", err
 	}
 
-	if hcc.caPath != "" {
-		fis, _ := os.ReadDir(hcc.caPath)
-		for _, fi := range fis {
-			if fi.IsDir() {
-				continue
-			}
+	transport := &http.Transport{
+		TLSClientConfig: tlsConfig,
+	}
+
+	return transport, gitlabURL, nil
+}
 
-			addCertToPool(certPool, filepath.Join(hcc.caPath, fi.Name()))
+// buildTLSConfig builds the TLS settings of connections to hostname, which
+// its certificate is verified against unless WithTLSServerName is set
+func buildTLSConfig(hcc httpClientCfg, hostname string) (*tls.Config, error) {
+	trust := hcc.trustedCAs
+	if trust == nil {
+		var err error
+		if trust, err = buildCertPool(hcc); err != nil {
+			return nil, err
 		}
 	}
+
 	tlsConfig := &tls.Config{
-		RootCAs:    certPool,
+		RootCAs:    trust.pool,
 		MinVersion: tls.VersionTLS12,
 	}
 
 	if hcc.HaveCertAndKey() {
 		cert, loadErr := tls.LoadX509KeyPair(hcc.certPath, hcc.keyPath)
 		if loadErr != nil {
-			return nil, "", loadErr
+			return nil, loadErr
 		}
 		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
 
-	transport := &http.Transport{
-		TLSClientConfig: tlsConfig,
+	configureVerification(hcc, tlsConfig, hostname)
+	configureOCSPStapleCheck(hcc, tlsConfig)
+
+	if len(hcc.pinnedCerts) > 0 {
+		var err error
+		tlsConfig.VerifyPeerCertificate, err = verifyPinnedCerts(hcc.pinnedCerts)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return transport, gitlabURL, err
+	return tlsConfig, nil
 }
 
-func addCertToPool(certPool *x509.CertPool, fileName string) {
+// trustedCAs holds the pool of CAs trusted for HTTPS connections, along with
+// a pool of only the CAs added on top of the system roots
+type trustedCAs struct {
+	pool, custom *x509.CertPool
+}
+
+func (t *trustedCAs) appendCertsFromPEM(pem []byte) bool {
+	t.custom.AppendCertsFromPEM(pem)
+
+	return t.pool.AppendCertsFromPEM(pem)
+}
+
+func buildCertPool(hcc httpClientCfg) (*trustedCAs, error) {
+	if err := validateCaFile(hcc.caFile); err != nil {
+		return nil, err
+	}
+
+	certPool, err := x509.SystemCertPool()
+	if err != nil {
+		certPool = x509.NewCertPool()
+	}
+	trust := &trustedCAs{pool: certPool, custom: x509.NewCertPool()}
+
+	if hcc.caFile != "" {
+		if err := addCertToPool(trust, hcc.caFile); err != nil {
+			return nil, err
+		}
+	}
+
+	// caPath may list directories as PATH does, with ; on Windows and : elsewhere
+	for _, caDir := range filepath.SplitList(hcc.caPath) {
+		addCertsFromDir(trust, filepath.FromSlash(caDir))
+	}
+
+	for _, pem := range hcc.caPEMs {
+		if !trust.appendCertsFromPEM(pem) {
+			return nil, ErrNoCertsInPEM
+		}
+	}
+
+	return trust, nil
+}
+
+func addCertsFromDir(trust *trustedCAs, caDir string) {
+	fis, _ := os.ReadDir(caDir)
+	for _, fi := range fis {
+		if fi.IsDir() {
+			continue
+		}
+
+		// A directory may hold unrelated files, so they don't fail the client
+		fileName := filepath.Join(caDir, fi.Name())
+		if err := addCertToPool(trust, fileName); err != nil {
+			log.WithFields(log.Fields{"ca_path": caDir, "file": fileName}).WithError(err).Warn("Skipping CA file")
+		}
+	}
+}
+
+func addCertToPool(trust *trustedCAs, fileName string) error {
 	cert, err := os.ReadFile(filepath.Clean(fileName))
-	if err == nil {
-		certPool.AppendCertsFromPEM(cert)
+	if err != nil {
+		return err
 	}
+
+	if !trust.appendCertsFromPEM(cert) {
+		return fmt.Errorf("%w: %s", ErrNoCertsInCAFile, fileName)
+	}
+
+	return nil
 }
 
 func buildHTTPTransport(gitlabURL string) (*http.Transport, string) {
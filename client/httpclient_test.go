@@ -2,16 +2,21 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/base64"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"path"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/testhelper"
 )
 
 func TestReadTimeout(t *testing.T) {
@@ -24,6 +29,50 @@ func TestReadTimeout(t *testing.T) {
 	require.Equal(t, time.Duration(expectedSeconds)*time.Second, client.RetryableHTTP.HTTPClient.Timeout)
 }
 
+func TestCanCompleteRetries(t *testing.T) {
+	client, err := NewHTTPClientWithOpts("http://localhost:3000", "", "", "", 1, []HTTPClientOpt{
+		WithHTTPRetryOpts(100*time.Millisecond, time.Second, 2),
+	})
+	require.NoError(t, err)
+
+	t.Run("no deadline", func(t *testing.T) {
+		require.True(t, client.CanCompleteRetries(context.Background()))
+	})
+
+	t.Run("generous deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		require.True(t, client.CanCompleteRetries(ctx))
+	})
+
+	t.Run("tight deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		require.False(t, client.CanCompleteRetries(ctx))
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		require.False(t, client.CanCompleteRetries(ctx))
+	})
+
+	t.Run("retries disabled", func(t *testing.T) {
+		noRetries, err := NewHTTPClientWithOpts("http://localhost:3000", "", "", "", 1, []HTTPClientOpt{
+			WithHTTPRetryOpts(100*time.Millisecond, time.Second, 0),
+		})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		require.True(t, noRetries.CanCompleteRetries(ctx))
+	})
+}
+
 const (
 	username = "basic_auth_user"
 	password = "basic_auth_password"
@@ -135,3 +184,239 @@ func setup(t *testing.T, username, password string, requests []testserver.TestRe
 
 	return client
 }
+
+func TestWithTransport(t *testing.T) {
+	t.Run("uses the custom transport", func(t *testing.T) {
+		var dialed bool
+		custom := &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				dialed = true
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		}
+
+		url := testserver.StartHttpServer(t, []testserver.TestRequestHandler{{
+			Path:    "/api/v4/internal/hello",
+			Handler: func(w http.ResponseWriter, _ *http.Request) { fmt.Fprint(w, "Hello") },
+		}})
+
+		httpClient, err := NewHTTPClientWithOpts(url, "", "", "", 1, []HTTPClientOpt{WithTransport(custom)})
+		require.NoError(t, err)
+
+		client, err := NewGitlabNetClient("", "", "", httpClient)
+		require.NoError(t, err)
+
+		resp, err := client.Get(context.Background(), "/hello")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.True(t, dialed)
+	})
+
+	t.Run("TLS settings are applied on top", func(t *testing.T) {
+		testRoot := testhelper.PrepareTestRootDir(t)
+		custom := &http.Transport{TLSClientConfig: &tls.Config{ServerName: "gitlab.example.com"}}
+
+		built, _, err := buildTransport(httpClientCfg{caFile: path.Join(testRoot, "certs/valid/server.crt")}, "https://localhost", "")
+		require.NoError(t, err)
+
+		merged, err := mergeTransport(custom, built, false)
+		require.NoError(t, err)
+		require.Equal(t, "gitlab.example.com", merged.TLSClientConfig.ServerName)
+		require.Equal(t, built.TLSClientConfig.RootCAs, merged.TLSClientConfig.RootCAs)
+		require.Equal(t, uint16(tls.VersionTLS12), merged.TLSClientConfig.MinVersion)
+	})
+
+	t.Run("unix socket requires a DialContext", func(t *testing.T) {
+		_, err := NewHTTPClientWithOpts("http+unix:///tmp/gitlab.socket", "", "", "", 1, []HTTPClientOpt{WithTransport(&http.Transport{})})
+		require.ErrorIs(t, err, ErrTransportCannotDialSocket)
+	})
+
+	t.Run("unix socket with a DialContext", func(t *testing.T) {
+		custom := &http.Transport{DialContext: (&net.Dialer{}).DialContext}
+
+		_, err := NewHTTPClientWithOpts("http+unix:///tmp/gitlab.socket", "", "", "", 1, []HTTPClientOpt{WithTransport(custom)})
+		require.NoError(t, err)
+	})
+}
+
+func TestWithCACertPEM(t *testing.T) {
+	cert := newTestCert(t, "localhost")
+	url, _ := startTLSServer(t, cert)
+
+	t.Run("trusts the given certificates", func(t *testing.T) {
+		client, err := NewHTTPClientWithOpts(url, "", "", "", 1, []HTTPClientOpt{WithCACertPEM(cert.pem)})
+		require.NoError(t, err)
+
+		require.NoError(t, get(t, client, url))
+	})
+
+	t.Run("composes with caFile", func(t *testing.T) {
+		other := newTestCert(t, "other")
+		caFile := writeTempFile(t, other.pem)
+
+		client, err := NewHTTPClientWithOpts(url, "", caFile, "", 1, []HTTPClientOpt{WithCACertPEM(cert.pem)})
+		require.NoError(t, err)
+
+		require.NoError(t, get(t, client, url))
+	})
+
+	t.Run("rejects data without certificates", func(t *testing.T) {
+		_, err := NewHTTPClientWithOpts(url, "", "", "", 1, []HTTPClientOpt{WithCACertPEM([]byte("garbage"))})
+		require.ErrorIs(t, err, ErrNoCertsInPEM)
+	})
+}
+
+func TestWithValidateSocket(t *testing.T) {
+	socketPath := path.Join(t.TempDir(), "gitlab.socket")
+
+	_, err := NewHTTPClientWithOpts("http+unix://"+socketPath, "", "", "", 1, []HTTPClientOpt{WithValidateSocket()})
+	require.ErrorIs(t, err, ErrSocketNotFound)
+	require.ErrorContains(t, err, socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	_, err = NewHTTPClientWithOpts("http+unix://"+socketPath, "", "", "", 1, []HTTPClientOpt{WithValidateSocket()})
+	require.NoError(t, err)
+}
+
+// serveSocket serves requests to /api/v4/internal/check on a unix socket,
+// over TLS if tlsConfig is set
+func serveSocket(t *testing.T, socketPath string, tlsConfig *tls.Config) {
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, r.TLS != nil)
+		}),
+		ReadHeaderTimeout: time.Second,
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+}
+
+func requireSocketCheck(t *testing.T, client *HTTPClient, expectedTLS bool) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, client.Host+"/api/v4/internal/check", nil)
+	require.NoError(t, err)
+
+	resp, err := client.RetryableHTTP.HTTPClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprint(expectedTLS), string(body))
+}
+
+func TestTLSOverUnixSocket(t *testing.T) {
+	socketPath := path.Join(t.TempDir(), "gitlab.socket")
+	cert := newTestCert(t, "gitlab.internal")
+	serveSocket(t, socketPath, &tls.Config{Certificates: []tls.Certificate{cert.keyPair}, MinVersion: tls.VersionTLS12})
+
+	client, err := NewHTTPClientWithOpts("https+unix://"+socketPath, "", "", "", 1, []HTTPClientOpt{
+		WithCACertPEM(cert.pem), WithValidateSocket(), WithHTTPRetryOpts(0, 0, 0),
+	})
+	require.NoError(t, err)
+	require.Equal(t, socketTLSBaseURL, client.Host)
+	requireSocketCheck(t, client, true)
+
+	t.Run("with a server name", func(t *testing.T) {
+		client, err := NewHTTPClientWithOpts("https+unix://"+socketPath, "", "", "", 1, []HTTPClientOpt{
+			WithCACertPEM(cert.pem), WithTLSServerName("gitlab.internal"), WithHTTPRetryOpts(0, 0, 0),
+		})
+		require.NoError(t, err)
+		requireSocketCheck(t, client, true)
+	})
+
+	t.Run("with an untrusted certificate", func(t *testing.T) {
+		client, err := NewHTTPClientWithOpts("https+unix://"+socketPath, "", "", "", 1, []HTTPClientOpt{WithHTTPRetryOpts(0, 0, 0)})
+		require.NoError(t, err)
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, client.Host+"/api/v4/internal/check", nil)
+		require.NoError(t, err)
+
+		_, err = client.RetryableHTTP.HTTPClient.Do(req)
+		require.ErrorContains(t, err, "certificate")
+	})
+}
+
+func TestAbstractUnixSocket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract sockets are only supported on Linux")
+	}
+
+	socketName := fmt.Sprintf("@gitlab-shell-test-%d", time.Now().UnixNano())
+	serveSocket(t, socketName, nil)
+
+	client, err := NewHTTPClientWithOpts("http+unix://"+socketName, "", "", "", 1, []HTTPClientOpt{WithValidateSocket()})
+	require.NoError(t, err)
+	requireSocketCheck(t, client, false)
+}
+
+func TestParseSocketURL(t *testing.T) {
+	tests := []struct {
+		url    string
+		socket socketAddress
+		isTLS  bool
+	}{
+		{url: "http+unix:///tmp/gitlab.socket", socket: socketAddress{network: "unix", path: "/tmp/gitlab.socket"}},
+		{url: "https+unix://@gitlab", socket: socketAddress{network: "unix", path: "@gitlab"}, isTLS: true},
+		{url: "http+npipe:////./pipe/gitlab", socket: socketAddress{network: "npipe", path: `\\.\pipe\gitlab`}},
+		{url: `http+npipe://\\server\pipe\gitlab`, socket: socketAddress{network: "npipe", path: `\\server\pipe\gitlab`}},
+		{url: "https+npipe://gitlab", socket: socketAddress{network: "npipe", path: `\\.\pipe\gitlab`}, isTLS: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.url, func(t *testing.T) {
+			socket, isTLS, ok := parseSocketURL(tc.url)
+			require.True(t, ok)
+			require.Equal(t, tc.socket, socket)
+			require.Equal(t, tc.isTLS, isTLS)
+		})
+	}
+
+	_, _, ok := parseSocketURL("http://localhost")
+	require.False(t, ok)
+}
+
+func TestSocketNotValidatedByDefault(t *testing.T) {
+	_, err := NewHTTPClientWithOpts("http+unix://"+path.Join(t.TempDir(), "gitlab.socket"), "", "", "", 1, nil)
+	require.NoError(t, err)
+}
+
+func TestRelativeURLRoot(t *testing.T) {
+	tests := []struct {
+		desc            string
+		url             string
+		relativeURLRoot string
+		wantHost        string
+	}{
+		{desc: "http without a root", url: "http://localhost:3000", wantHost: "http://localhost:3000"},
+		{desc: "http with a root", url: "http://localhost:3000", relativeURLRoot: "/gitlab", wantHost: "http://localhost:3000/gitlab"},
+		{desc: "http with slashes around the root", url: "http://localhost:3000/", relativeURLRoot: "gitlab/", wantHost: "http://localhost:3000/gitlab"},
+		{desc: "http URL including the root", url: "http://localhost:3000/gitlab", relativeURLRoot: "/gitlab", wantHost: "http://localhost:3000/gitlab"},
+		{desc: "https with a root", url: "https://localhost", relativeURLRoot: "/gitlab", wantHost: "https://localhost/gitlab"},
+		{desc: "socket with a root", url: "http+unix:///tmp/gitlab.socket", relativeURLRoot: "/gitlab/", wantHost: "http://unix/gitlab"},
+		{desc: "socket with a root of /", url: "http+unix:///tmp/gitlab.socket", relativeURLRoot: "/", wantHost: "http://unix"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			client, err := NewHTTPClientWithOpts(tc.url, tc.relativeURLRoot, "", "", 1, nil)
+			require.NoError(t, err)
+			require.Equal(t, tc.wantHost, client.Host)
+		})
+	}
+}
+
+func TestInvalidRelativeURLRoot(t *testing.T) {
+	for _, relativeURLRoot := range []string{"https://gitlab.example.com/gitlab", "//gitlab.example.com", "http:gitlab", "/gitlab://x"} {
+		_, err := NewHTTPClientWithOpts("http://localhost:3000", relativeURLRoot, "", "", 1, nil)
+		require.ErrorIs(t, err, ErrInvalidRelativeURLRoot, relativeURLRoot)
+	}
+}
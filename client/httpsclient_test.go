@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"path"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -68,21 +70,21 @@ func TestFailedRequests(t *testing.T) {
 	testRoot := testhelper.PrepareTestRootDir(t)
 
 	testCases := []struct {
-		desc                   string
-		caFile                 string
-		caPath                 string
-		expectedCaFileNotFound bool
-		expectedError          string
+		desc               string
+		caFile             string
+		caPath             string
+		expectedBuildError error
+		expectedError      string
 	}{
 		{
-			desc:          "Invalid CaFile",
-			caFile:        path.Join(testRoot, "certs/invalid/server.crt"),
-			expectedError: "Internal API unreachable",
+			desc:               "Invalid CaFile",
+			caFile:             path.Join(testRoot, "certs/invalid/server.crt"),
+			expectedBuildError: ErrNoCertsInCAFile,
 		},
 		{
-			desc:                   "Missing CaFile",
-			caFile:                 path.Join(testRoot, "certs/invalid/missing.crt"),
-			expectedCaFileNotFound: true,
+			desc:               "Missing CaFile",
+			caFile:             path.Join(testRoot, "certs/invalid/missing.crt"),
+			expectedBuildError: ErrCafileNotFound,
 		},
 		{
 			desc:          "Invalid CaPath",
@@ -98,9 +100,8 @@ func TestFailedRequests(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.desc, func(t *testing.T) {
 			client, err := setupWithRequests(t, tc.caFile, tc.caPath, "", "", "")
-			if tc.expectedCaFileNotFound {
-				require.Error(t, err)
-				require.ErrorIs(t, err, ErrCafileNotFound)
+			if tc.expectedBuildError != nil {
+				require.ErrorIs(t, err, tc.expectedBuildError)
 			} else {
 				_, err = client.Get(context.Background(), "/hello")
 				require.Error(t, err)
@@ -139,3 +140,52 @@ func setupWithRequests(t *testing.T, caFile, caPath, clientCAPath, clientCertPat
 
 	return client, err
 }
+
+func TestCaFileContents(t *testing.T) {
+	cert := newTestCert(t, "localhost")
+
+	testCases := []struct {
+		desc          string
+		contents      []byte
+		expectedError error
+	}{
+		{desc: "PEM", contents: cert.pem},
+		{desc: "empty", contents: []byte{}, expectedError: ErrNoCertsInCAFile},
+		{desc: "DER", contents: cert.cert.Raw, expectedError: ErrNoCertsInCAFile},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			caFile := writeTempFile(t, tc.contents)
+
+			_, err := NewHTTPClientWithOpts("https://localhost", "", caFile, "", 1, nil)
+			require.ErrorIs(t, err, tc.expectedError)
+			if tc.expectedError != nil {
+				require.ErrorContains(t, err, caFile)
+			}
+		})
+	}
+}
+
+func TestCaPathSkipsInvalidFiles(t *testing.T) {
+	cert := newTestCert(t, "localhost")
+	caPath := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(caPath, "valid.pem"), cert.pem, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(caPath, "der.crt"), cert.cert.Raw, 0o600))
+
+	client, err := NewHTTPClientWithOpts("https://localhost", "", "", caPath, 1, nil)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{cert.cert.RawSubject}, client.TrustedCertSubjects())
+}
+
+func TestCaPathList(t *testing.T) {
+	first, second := newTestCert(t, "localhost"), newTestCert(t, "gitlab.example.com")
+	firstDir, secondDir := t.TempDir(), t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(firstDir, "first.pem"), first.pem, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(secondDir, "second.pem"), second.pem, 0o600))
+
+	caPath := firstDir + string(os.PathListSeparator) + secondDir
+	client, err := NewHTTPClientWithOpts("https://localhost", "", "", caPath, 1, nil)
+	require.NoError(t, err)
+	require.ElementsMatch(t, [][]byte{first.cert.RawSubject, second.cert.RawSubject}, client.TrustedCertSubjects())
+}
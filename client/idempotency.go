@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// IdempotencyKeyHeader marks a POST or PATCH request as safe to retry under
+// WithRetryIdempotentOnly
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// WithRetryIdempotentOnly stops POST and PATCH requests from being retried,
+// since a retry after e.g. a timeout could apply them twice, unless they carry
+// an Idempotency-Key header. Other methods are retried as usual, subject to
+// WithRetryPolicy and WithHTTPRetryOpts.
+func WithRetryIdempotentOnly() HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.retryIdempotentOnly = true
+	}
+}
+
+func isRetrySafe(request *http.Request) bool {
+	switch request.Method {
+	case http.MethodPost, http.MethodPatch:
+		return request.Header.Get(IdempotencyKeyHeader) != ""
+	default:
+		return true
+	}
+}
+
+// unsafeRetryError marks the error of a request that mustn't be retried. The
+// retry policy isn't given the request when there's no response, so the
+// error has to carry that information.
+type unsafeRetryError struct {
+	err error
+}
+
+func (e *unsafeRetryError) Error() string { return e.err.Error() }
+
+func (e *unsafeRetryError) Unwrap() error { return e.err }
+
+type idempotencyTransport struct {
+	next http.RoundTripper
+}
+
+func newIdempotencyTransport(next http.RoundTripper, enabled bool) http.RoundTripper {
+	if !enabled {
+		return next
+	}
+
+	return &idempotencyTransport{next: next}
+}
+
+func (rt *idempotencyTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := rt.next.RoundTrip(request)
+	if err != nil && !isRetrySafe(request) {
+		err = &unsafeRetryError{err: err}
+	}
+
+	return response, err
+}
+
+// idempotentRetryPolicy wraps next so that requests which aren't safe to
+// retry are given up on after the first attempt
+func idempotentRetryPolicy(next retryablehttp.CheckRetry) retryablehttp.CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		shouldRetry, checkErr := next(ctx, resp, err)
+		if !shouldRetry {
+			return false, checkErr
+		}
+
+		var unsafeErr *unsafeRetryError
+		if errors.As(err, &unsafeErr) || (resp != nil && resp.Request != nil && !isRetrySafe(resp.Request)) {
+			return false, checkErr
+		}
+
+		return true, checkErr
+	}
+}
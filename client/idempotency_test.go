@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithRetryIdempotentOnly(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(server.Close)
+
+	tests := []struct {
+		desc           string
+		method         string
+		idempotencyKey string
+		policy         retryablehttp.CheckRetry
+		wantAttempts   int32
+	}{
+		{desc: "GET is retried", method: http.MethodGet, wantAttempts: 3},
+		{desc: "PUT is retried", method: http.MethodPut, wantAttempts: 3},
+		{desc: "POST isn't retried", method: http.MethodPost, wantAttempts: 1},
+		{desc: "PATCH isn't retried", method: http.MethodPatch, wantAttempts: 1},
+		{desc: "POST with an idempotency key is retried", method: http.MethodPost, idempotencyKey: "abc", wantAttempts: 3},
+		{
+			desc:   "composes with a custom policy",
+			method: http.MethodGet,
+			policy: func(context.Context, *http.Response, error) (bool, error) {
+				return attempts.Load() < 2, nil
+			},
+			wantAttempts: 2,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := []HTTPClientOpt{WithHTTPRetryOpts(0, 0, 2), WithRetryIdempotentOnly()}
+			if tc.policy != nil {
+				opts = append(opts, WithRetryPolicy(tc.policy))
+			}
+
+			client, err := NewHTTPClientWithOpts(server.URL, "", "", "", 1, opts)
+			require.NoError(t, err)
+
+			req, err := retryablehttp.NewRequest(tc.method, server.URL, nil)
+			require.NoError(t, err)
+			if tc.idempotencyKey != "" {
+				req.Header.Set(IdempotencyKeyHeader, tc.idempotencyKey)
+			}
+
+			attempts.Store(0)
+			resp, err := client.RetryableHTTP.Do(req)
+			if err == nil {
+				require.NoError(t, resp.Body.Close())
+			}
+
+			require.Equal(t, tc.wantAttempts, attempts.Load())
+		})
+	}
+}
+
+func TestWithRetryIdempotentOnlyConnectionError(t *testing.T) {
+	var dials atomic.Int32
+	transport := &http.Transport{
+		DialContext: func(context.Context, string, string) (net.Conn, error) {
+			dials.Add(1)
+			return nil, errors.New("connection reset")
+		},
+	}
+
+	client, err := NewHTTPClientWithOpts("http://localhost", "", "", "", 1, []HTTPClientOpt{
+		WithHTTPRetryOpts(0, 0, 2), WithRetryIdempotentOnly(), WithTransport(transport),
+	})
+	require.NoError(t, err)
+
+	for method, wantDials := range map[string]int32{http.MethodPost: 1, http.MethodGet: 3} {
+		req, err := retryablehttp.NewRequest(method, "http://localhost", nil)
+		require.NoError(t, err)
+
+		dials.Store(0)
+		_, err = client.RetryableHTTP.Do(req)
+		require.ErrorContains(t, err, "connection reset")
+		require.Equal(t, wantDials, dials.Load(), method)
+	}
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gitlab.com/gitlab-org/labkit/correlation"
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+// ErrEmptyJWTSecret is returned when the secret file given to WithJWTAuth is
+// empty
+var ErrEmptyJWTSecret = errors.New("JWT secret file is empty")
+
+// WithJWTAuth signs every attempt at a request with a short-lived JWT in the
+// Gitlab-Shell-Api-Request header, including requests that aren't made
+// through GitlabNetClient. The token is signed with the secret held in
+// secretFile, which is read again whenever the file changes, so that the
+// secret can be rotated without a restart while GitLab accepts both the old
+// and the new one. The previous secret is kept if the file can't be read.
+func WithJWTAuth(secretFile string) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.jwtSecretFile = secretFile
+	}
+}
+
+type jwtClaims struct {
+	CorrelationID string `json:"correlation_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// signJWT returns a token for a request made in ctx, valid for jwtTTL
+func signJWT(ctx context.Context, secret string) (string, error) {
+	now := time.Now()
+	claims := jwtClaims{
+		CorrelationID: correlation.ExtractFromContext(ctx),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    jwtIssuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(jwtTTL)),
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(strings.TrimSpace(secret)))
+}
+
+// jwtSecret caches the content of a secret file until the file changes
+type jwtSecret struct {
+	path string
+
+	mu      sync.Mutex
+	secret  string
+	modTime time.Time
+	size    int64
+}
+
+func (s *jwtSecret) load() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.reload()
+	if err == nil || s.secret == "" {
+		return s.secret, err
+	}
+
+	log.WithFields(log.Fields{"secret_file": s.path}).WithError(err).Warn("Failed to reload JWT secret, signing with the previous one")
+
+	return s.secret, nil
+}
+
+func (s *jwtSecret) reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+
+	if s.secret != "" && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil
+	}
+
+	content, err := os.ReadFile(filepath.Clean(s.path))
+	if err != nil {
+		return err
+	}
+
+	secret := strings.TrimSpace(string(content))
+	if secret == "" {
+		return ErrEmptyJWTSecret
+	}
+
+	s.secret, s.modTime, s.size = secret, info.ModTime(), info.Size()
+
+	return nil
+}
+
+type jwtAuthTransport struct {
+	next   http.RoundTripper
+	secret *jwtSecret
+}
+
+func newJWTAuthTransport(next http.RoundTripper, secretFile string) (http.RoundTripper, error) {
+	if secretFile == "" {
+		return next, nil
+	}
+
+	secret := &jwtSecret{path: secretFile}
+	if _, err := secret.load(); err != nil {
+		return nil, err
+	}
+
+	return &jwtAuthTransport{next: next, secret: secret}, nil
+}
+
+func (rt *jwtAuthTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	secret, err := rt.secret.load()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := signJWT(request.Context(), secret)
+	if err != nil {
+		return nil, err
+	}
+
+	// A RoundTripper must not modify the request it's given
+	request = request.Clone(request.Context())
+	request.Header.Set(apiSecretHeaderName, token)
+
+	return rt.next.RoundTrip(request)
+}
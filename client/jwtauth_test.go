@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/labkit/correlation"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+)
+
+func writeSecret(t *testing.T, secretFile, secret string, modTime time.Time) {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(secretFile, []byte(secret+"\n"), 0o600))
+	require.NoError(t, os.Chtimes(secretFile, modTime, modTime))
+}
+
+func TestWithJWTAuth(t *testing.T) {
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/jwt_auth",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(r.Header.Get(apiSecretHeaderName)))
+			},
+		},
+	}
+	url := testserver.StartHttpServer(t, requests)
+
+	secretFile := filepath.Join(t.TempDir(), ".gitlab_shell_secret")
+	writeSecret(t, secretFile, "first secret", time.Now().Add(-time.Hour))
+
+	httpClient, err := NewHTTPClientWithOpts(url, "", "", "", 1, []HTTPClientOpt{WithJWTAuth(secretFile)})
+	require.NoError(t, err)
+
+	ctx := correlation.ContextWithCorrelation(context.Background(), "correlation-id")
+
+	claimsSignedWith := func(t *testing.T, secret string) *jwtClaims {
+		t.Helper()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/api/v4/internal/jwt_auth", nil)
+		require.NoError(t, err)
+
+		resp, err := httpClient.RetryableHTTP.HTTPClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		token, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		claims := &jwtClaims{}
+		_, err = jwt.ParseWithClaims(string(token), claims, func(*jwt.Token) (interface{}, error) {
+			return []byte(secret), nil
+		})
+		require.NoError(t, err)
+
+		return claims
+	}
+
+	claims := claimsSignedWith(t, "first secret")
+	require.Equal(t, "gitlab-shell", claims.Issuer)
+	require.Equal(t, "correlation-id", claims.CorrelationID)
+	require.WithinDuration(t, time.Now(), claims.IssuedAt.Time, time.Second)
+	require.WithinDuration(t, time.Now().Add(jwtTTL), claims.ExpiresAt.Time, time.Second)
+
+	t.Run("the secret file is rotated", func(t *testing.T) {
+		writeSecret(t, secretFile, "second secret", time.Now())
+
+		claimsSignedWith(t, "second secret")
+	})
+
+	t.Run("the secret file becomes unreadable", func(t *testing.T) {
+		require.NoError(t, os.Remove(secretFile))
+
+		claimsSignedWith(t, "second secret")
+	})
+}
+
+func TestInvalidJWTSecretFile(t *testing.T) {
+	emptyFile := filepath.Join(t.TempDir(), "empty")
+	writeSecret(t, emptyFile, "", time.Now())
+
+	testCases := []struct {
+		desc          string
+		secretFile    string
+		expectedError error
+	}{
+		{
+			desc:          "missing file",
+			secretFile:    "/does/not/exist",
+			expectedError: os.ErrNotExist,
+		},
+		{
+			desc:          "empty file",
+			secretFile:    emptyFile,
+			expectedError: ErrEmptyJWTSecret,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := NewHTTPClientWithOpts("http://localhost", "", "", "", 1, []HTTPClientOpt{WithJWTAuth(tc.secretFile)})
+			require.ErrorIs(t, err, tc.expectedError)
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// WithLogger logs each attempt at a request to logger, along with the error
+// or status that caused it to be retried. Credentials in headers and URLs
+// are redacted. Without this option retries happen silently.
+func WithLogger(logger retryablehttp.LeveledLogger) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.logger = logger
+	}
+}
+
+func configureLogger(c *retryablehttp.Client, logger retryablehttp.LeveledLogger) {
+	if logger == nil {
+		c.Logger = nil
+		return
+	}
+
+	c.Logger = &redactingLogger{next: logger}
+	c.RequestLogHook = func(_ retryablehttp.Logger, request *http.Request, attempt int) {
+		logger.Debug("request attempt",
+			"method", request.Method,
+			"url", redactURL(request.URL),
+			"attempt", attempt+1,
+			"headers", redactHeaders(request.Header),
+		)
+	}
+}
+
+// redactingLogger hides credentials in the values logged by retryablehttp
+type redactingLogger struct {
+	next retryablehttp.LeveledLogger
+}
+
+func (l *redactingLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.next.Error(msg, redactValues(keysAndValues)...)
+}
+
+func (l *redactingLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.next.Info(msg, redactValues(keysAndValues)...)
+}
+
+func (l *redactingLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.next.Debug(msg, redactValues(keysAndValues)...)
+}
+
+func (l *redactingLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.next.Warn(msg, redactValues(keysAndValues)...)
+}
+
+func redactValues(keysAndValues []interface{}) []interface{} {
+	redactedValues := make([]interface{}, len(keysAndValues))
+	for i, value := range keysAndValues {
+		switch v := value.(type) {
+		case http.Header:
+			redactedValues[i] = redactHeaders(v)
+		case *url.URL:
+			redactedValues[i] = redactURL(v)
+		case *http.Request:
+			redactedValues[i] = v.Method + " " + redactURL(v.URL)
+		default:
+			redactedValues[i] = value
+		}
+	}
+
+	return redactedValues
+}
+
+// redactHeaders returns a copy of header with the values of sensitive
+// headers replaced
+func redactHeaders(header http.Header) http.Header {
+	redactedHeader := header.Clone()
+	for name, values := range redactedHeader {
+		if !sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+			continue
+		}
+
+		for i := range values {
+			values[i] = redacted
+		}
+	}
+
+	return redactedHeader
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"context"
+	"mime"
+	"net/http"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// ErrMaintenanceMode is returned when the internal API responds with an HTML
+// "down for maintenance" page rather than a JSON error
+var ErrMaintenanceMode = &APIError{Msg: "GitLab is currently unavailable due to maintenance. Please try again later."}
+
+// isMaintenancePage reports whether response is an HTML 503, which is what
+// the internal API is fronted with during maintenance
+func isMaintenancePage(response *http.Response) bool {
+	if response == nil || response.StatusCode != http.StatusServiceUnavailable {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+
+	return err == nil && mediaType == "text/html"
+}
+
+// maintenanceRetryPolicy wraps next so that maintenance pages aren't
+// retried: maintenance lasts much longer than the retry budget, and the
+// page needs to be passed on for parseError to recognize it.
+func maintenanceRetryPolicy(next retryablehttp.CheckRetry) retryablehttp.CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if err == nil && isMaintenancePage(resp) {
+			return false, nil
+		}
+
+		return next(ctx, resp, err)
+	}
+}
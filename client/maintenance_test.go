@@ -0,0 +1,54 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	attempts := 0
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/html_503",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				attempts++
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte("<html><body><h1>GitLab is down for maintenance</h1></body></html>"))
+			},
+		},
+		{
+			Path: "/api/v4/internal/json_503",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				json.NewEncoder(w).Encode(&ErrorResponse{Message: "Service unavailable"})
+			},
+		},
+	}
+
+	url := testserver.StartHttpServer(t, requests)
+	httpClient, err := NewHTTPClientWithOpts(url, "", "", "", 1, defaultHttpOpts)
+	require.NoError(t, err)
+
+	client, err := NewGitlabNetClient("", "", "", httpClient)
+	require.NoError(t, err)
+
+	t.Run("HTML 503", func(t *testing.T) {
+		_, err := client.Get(context.Background(), "/html_503")
+		require.ErrorIs(t, err, ErrMaintenanceMode)
+		require.Equal(t, 1, attempts, "maintenance pages should not be retried")
+	})
+
+	t.Run("JSON 503", func(t *testing.T) {
+		_, err := client.Get(context.Background(), "/json_503")
+		require.NotErrorIs(t, err, ErrMaintenanceMode)
+		require.EqualError(t, err, "Internal API unreachable")
+	})
+}
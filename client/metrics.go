@@ -0,0 +1,125 @@
+package client
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsSubsystem = "api_client"
+
+// Metrics are the Prometheus metrics of the requests to the internal API,
+// labelled by endpoint: the path of the request below /api/v4/internal.
+// Several clients may share them, e.g. across configuration reloads.
+type Metrics struct {
+	requestDuration *prometheus.HistogramVec
+	retries         *prometheus.CounterVec
+	responses       *prometheus.CounterVec
+	inFlight        prometheus.Gauge
+}
+
+// NewMetrics registers the metrics of the internal API client with
+// registerer, prefixed with namespace
+func NewMetrics(registerer prometheus.Registerer, namespace string) *Metrics {
+	m := &Metrics{
+		requestDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Subsystem: metricsSubsystem,
+				Name:      "request_duration_seconds",
+				Help:      "A histogram of latencies for requests to the internal API, including their retries.",
+				Buckets:   []float64{0.005, 0.025, 0.1, 0.5, 1, 2.5, 10, 30, 60},
+			},
+			[]string{"endpoint", "method"},
+		),
+		retries: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: metricsSubsystem,
+				Name:      "retries_total",
+				Help:      "A counter for the retried attempts of requests to the internal API.",
+			},
+			[]string{"endpoint", "method"},
+		),
+		responses: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: metricsSubsystem,
+				Name:      "responses_total",
+				Help:      "A counter for the attempts of requests to the internal API, by status code, or \"error\" if the attempt failed without a response.",
+			},
+			[]string{"endpoint", "method", "code"},
+		),
+		inFlight: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: metricsSubsystem,
+				Name:      "in_flight_requests",
+				Help:      "A gauge of the attempts of requests to the internal API currently being performed.",
+			},
+		),
+	}
+
+	registerer.MustRegister(m.requestDuration, m.retries, m.responses, m.inFlight)
+
+	return m
+}
+
+// WithMetrics records the requests of the client in metrics
+func WithMetrics(metrics *Metrics) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.metrics = metrics
+	}
+}
+
+// observeRequest records a request that took attempts, from start
+func (m *Metrics) observeRequest(request *http.Request, attempts int, start time.Time) {
+	endpoint := metricsEndpoint(request)
+
+	m.requestDuration.WithLabelValues(endpoint, request.Method).Observe(time.Since(start).Seconds())
+	if attempts > 1 {
+		m.retries.WithLabelValues(endpoint, request.Method).Add(float64(attempts - 1))
+	}
+}
+
+// metricsEndpoint returns the path of request below the internal API, which
+// is prefixed by the relative URL root of GitLab, if any
+func metricsEndpoint(request *http.Request) string {
+	path := request.URL.Path
+	if i := strings.Index(path, internalAPIPath); i >= 0 {
+		path = path[i+len(internalAPIPath):]
+	}
+
+	return path
+}
+
+type metricsTransport struct {
+	next    http.RoundTripper
+	metrics *Metrics
+}
+
+func newMetricsTransport(next http.RoundTripper, metrics *Metrics) http.RoundTripper {
+	if metrics == nil {
+		return next
+	}
+
+	return &metricsTransport{next: next, metrics: metrics}
+}
+
+func (rt *metricsTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	rt.metrics.inFlight.Inc()
+	defer rt.metrics.inFlight.Dec()
+
+	response, err := rt.next.RoundTrip(request)
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(response.StatusCode)
+	}
+	rt.metrics.responses.WithLabelValues(metricsEndpoint(request), request.Method, code).Inc()
+
+	return response, err
+}
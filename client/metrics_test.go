@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithMetrics(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if requests.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	registry := prometheus.NewRegistry()
+	metrics := NewMetrics(registry, "test")
+
+	httpClient, err := NewHTTPClientWithOpts(server.URL, "/gitlab", "", "", 1, []HTTPClientOpt{
+		WithHTTPRetryOpts(0, 0, 2), WithMetrics(metrics),
+	})
+	require.NoError(t, err)
+
+	client, err := NewGitlabNetClient("", "", "", httpClient)
+	require.NoError(t, err)
+
+	resp, err := client.Get(context.Background(), "/discover")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	require.Equal(t, 2.0, testutil.ToFloat64(metrics.retries.WithLabelValues("/discover", http.MethodGet)))
+	require.Equal(t, 2.0, testutil.ToFloat64(metrics.responses.WithLabelValues("/discover", http.MethodGet, "503")))
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.responses.WithLabelValues("/discover", http.MethodGet, "200")))
+	require.Zero(t, testutil.ToFloat64(metrics.inFlight))
+	require.Equal(t, 1, testutil.CollectAndCount(metrics.requestDuration))
+
+	// A metric for each collector, the histogram and gauge included
+	count, err := testutil.GatherAndCount(registry)
+	require.NoError(t, err)
+	require.Equal(t, 5, count)
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	testCases := []struct {
+		url  string
+		want string
+	}{
+		{url: "http://gitlab/api/v4/internal/allowed", want: "/allowed"},
+		{url: "http://gitlab/gitlab/api/v4/internal/lfs_authenticate?key_id=1", want: "/lfs_authenticate"},
+		{url: "http://gitlab/-/health", want: "/-/health"},
+	}
+
+	for _, tc := range testCases {
+		request, err := http.NewRequest(http.MethodGet, tc.url, nil)
+		require.NoError(t, err)
+
+		require.Equal(t, tc.want, metricsEndpoint(request))
+	}
+}
@@ -0,0 +1,36 @@
+package client
+
+import "net/http"
+
+// RoundTripFunc sends a request of a GitlabNetClient
+type RoundTripFunc func(request *http.Request) (*http.Response, error)
+
+// Middleware intercepts the requests of a GitlabNetClient, e.g. to add
+// headers, record metrics or mirror requests. It may change request, or pass
+// a copy of it, before calling next, and observes the response or the error
+// next returns, before they're turned into an APIError.
+//
+// next sends the request with the retries of the HTTPClient, so a middleware
+// runs once per request rather than per attempt. The body of the request is
+// only available through its GetBody, and can't be replaced. A middleware
+// reading the body of the response must replace it for the caller.
+type Middleware func(request *http.Request, next RoundTripFunc) (*http.Response, error)
+
+// Use appends middlewares to those of the client. They run in the order they
+// were added, the first seeing the request first and the response last. Use
+// must not be called while the client is sending requests.
+func (c *GitlabNetClient) Use(middlewares ...Middleware) {
+	c.middlewares = append(c.middlewares, middlewares...)
+}
+
+// intercept passes request through the middlewares, then to send
+func (c *GitlabNetClient) intercept(request *http.Request, send RoundTripFunc) (*http.Response, error) {
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		middleware, next := c.middlewares[i], send
+		send = func(request *http.Request) (*http.Response, error) {
+			return middleware(request, next)
+		}
+	}
+
+	return send(request)
+}
@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+)
+
+func TestMiddlewares(t *testing.T) {
+	var attempts atomic.Int32
+
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/check",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				if attempts.Add(1) == 1 {
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				}
+
+				w.Write([]byte(r.Header.Get("X-Tenant") + " " + r.Header.Get("X-Request-Order")))
+			},
+		},
+	}
+
+	url := testserver.StartHttpServer(t, requests)
+	httpClient, err := NewHTTPClientWithOpts(url, "", "", "", 1, defaultHttpOpts)
+	require.NoError(t, err)
+
+	client, err := NewGitlabNetClient("", "", "", httpClient)
+	require.NoError(t, err)
+
+	var calls []string
+	var status int
+	client.Use(
+		func(request *http.Request, next RoundTripFunc) (*http.Response, error) {
+			calls = append(calls, "first")
+			request = request.Clone(request.Context())
+			request.Header.Set("X-Tenant", "acme")
+			request.Header.Add("X-Request-Order", "first")
+
+			response, err := next(request)
+			if err == nil {
+				status = response.StatusCode
+			}
+			calls = append(calls, "first done")
+
+			return response, err
+		},
+		func(request *http.Request, next RoundTripFunc) (*http.Response, error) {
+			calls = append(calls, "second")
+			request.Header.Add("X-Request-Order", "second")
+
+			return next(request)
+		},
+	)
+
+	response, err := client.Get(context.Background(), "/check")
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.Equal(t, "acme first", string(body), "X-Request-Order is read as its first value")
+
+	require.Equal(t, []string{"first", "second", "first done"}, calls, "the middlewares run once for the retried request")
+	require.Equal(t, int32(2), attempts.Load())
+	require.Equal(t, http.StatusOK, status)
+}
+
+func TestMiddlewareObservesErrors(t *testing.T) {
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/check",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"message":"Forbidden"}`))
+			},
+		},
+	}
+
+	url := testserver.StartHttpServer(t, requests)
+	httpClient, err := NewHTTPClientWithOpts(url, "", "", "", 1, defaultHttpOpts)
+	require.NoError(t, err)
+
+	client, err := NewGitlabNetClient("", "", "", httpClient)
+	require.NoError(t, err)
+
+	var status int
+	client.Use(func(request *http.Request, next RoundTripFunc) (*http.Response, error) {
+		response, err := next(request)
+		require.NoError(t, err)
+		status = response.StatusCode
+
+		return response, err
+	})
+
+	_, err = client.Get(context.Background(), "/check")
+	require.EqualError(t, err, "Forbidden")
+	require.Equal(t, http.StatusForbidden, status)
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+const (
+	// mirrorTimeout bounds the mirrored requests, which aren't retried
+	mirrorTimeout = 30 * time.Second
+	// mirrorMaxInFlight bounds the mirrored requests sent at once. Requests
+	// sampled beyond it aren't mirrored, so that a slow mirror can't pile up
+	// goroutines.
+	mirrorMaxInFlight = 64
+	// mirrorMaxBodySize bounds the bodies of the requests mirrored, which are
+	// copied in memory
+	mirrorMaxBodySize = 1 << 20
+)
+
+// mirroredPaths are the endpoints of the internal API whose requests are
+// mirrored. They only read, as the mirror may share the database of GitLab:
+// a copy of e.g. a request for a personal access token would create another.
+var mirroredPaths = map[string]bool{
+	"/allowed":         true,
+	"/discover":        true,
+	"/authorized_keys": true,
+	"/check":           true,
+}
+
+// MirrorTo returns a middleware sending a copy of the share ratio, between 0
+// and 1, of the requests to the internal API to mirror too, e.g. to try a new
+// version of GitLab or a failover target with real traffic. Copies are sent
+// asynchronously, and their responses are ignored, only failures being
+// logged. Only the requests to the read-only endpoints of the internal API
+// whose body is at most 1MiB are mirrored.
+//
+// The credentials of the requests aren't copied: mirror authenticates the
+// copies itself, e.g. with WithJWTAuth and the secret of the mirror.
+func MirrorTo(mirror *HTTPClient, ratio float64) Middleware {
+	inFlight := make(chan struct{}, mirrorMaxInFlight)
+
+	return func(request *http.Request, next RoundTripFunc) (*http.Response, error) {
+		if ratio <= 0 || rand.Float64() >= ratio { //nolint:gosec // not used for security
+			return next(request)
+		}
+
+		mirrored, err := mirrorRequest(request, mirror.Host)
+		if err != nil {
+			log.WithContextFields(request.Context(), log.Fields{"path": request.URL.Path}).WithError(err).Debug("Failed to mirror an internal API request")
+		}
+
+		if mirrored != nil {
+			select {
+			case inFlight <- struct{}{}:
+				go func() {
+					defer func() { <-inFlight }()
+					sendMirrored(mirror, mirrored)
+				}()
+			default:
+			}
+		}
+
+		return next(request)
+	}
+}
+
+// mirrorRequest copies request for the host of the mirror, or returns nil
+// for the requests that aren't mirrored. The body is read before the request
+// is sent, as bodies read from seekers can't be read concurrently.
+func mirrorRequest(request *http.Request, host string) (*http.Request, error) {
+	i := strings.Index(request.URL.Path, internalAPIPath)
+	if i < 0 || !mirroredPaths[request.URL.Path[i+len(internalAPIPath):]] {
+		return nil, nil
+	}
+
+	var body []byte
+	if request.GetBody != nil {
+		reader, err := request.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = reader.Close() }()
+
+		if body, err = io.ReadAll(io.LimitReader(reader, mirrorMaxBodySize+1)); err != nil {
+			return nil, err
+		}
+		if len(body) > mirrorMaxBodySize {
+			return nil, nil
+		}
+	} else if request.Body != nil && request.Body != http.NoBody {
+		return nil, nil
+	}
+
+	url := appendPath(host, request.URL.Path[i:])
+	if request.URL.RawQuery != "" {
+		url += "?" + request.URL.RawQuery
+	}
+
+	// The mirrored request outlives the request it copies
+	mirrored, err := http.NewRequestWithContext(context.WithoutCancel(request.Context()), request.Method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	mirrored.Header = request.Header.Clone()
+	mirrored.Header.Del(apiSecretHeaderName)
+	mirrored.Header.Del("Authorization")
+
+	return mirrored, nil
+}
+
+// sendMirrored sends a mirrored request, discarding its response
+func sendMirrored(mirror *HTTPClient, request *http.Request) {
+	fields := log.Fields{"method": request.Method, "path": request.URL.Path}
+
+	ctx, cancel := context.WithTimeout(request.Context(), mirrorTimeout)
+	defer cancel()
+
+	response, err := mirror.RetryableHTTP.HTTPClient.Do(request.WithContext(ctx))
+	if err != nil {
+		log.WithContextFields(request.Context(), fields).WithError(err).Warn("Mirrored internal API request failed")
+		return
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode >= http.StatusInternalServerError {
+		fields["status"] = response.StatusCode
+		log.WithContextFields(request.Context(), fields).Warn("Mirrored internal API request failed")
+	}
+}
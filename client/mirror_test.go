@@ -0,0 +1,155 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+)
+
+type mirroredRequest struct {
+	path          string
+	body          string
+	token         string
+	authorization string
+}
+
+func TestMirrorTo(t *testing.T) {
+	mirrored := make(chan mirroredRequest, 10)
+	mirrorHandler := func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading the mirrored request: %v", err)
+		}
+
+		mirrored <- mirroredRequest{
+			path:          r.URL.RequestURI(),
+			body:          string(body),
+			token:         r.Header.Get(apiSecretHeaderName),
+			authorization: r.Header.Get("Authorization"),
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	mirrorURL := testserver.StartHttpServer(t, []testserver.TestRequestHandler{
+		{Path: "/api/v4/internal/allowed", Handler: mirrorHandler},
+		{Path: "/api/v4/internal/personal_access_token", Handler: mirrorHandler},
+	})
+
+	primaryHandler := func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("primary"))
+	}
+	primaryURL := testserver.StartHttpServer(t, []testserver.TestRequestHandler{
+		{Path: "/api/v4/internal/allowed", Handler: primaryHandler},
+		{Path: "/api/v4/internal/personal_access_token", Handler: primaryHandler},
+	})
+
+	mirrorSecretFile := filepath.Join(t.TempDir(), ".gitlab_shell_secret")
+	writeSecret(t, mirrorSecretFile, "mirror secret", time.Now())
+
+	mirror, err := NewHTTPClientWithOpts(mirrorURL, "", "", "", 1, append(defaultHttpOpts, WithJWTAuth(mirrorSecretFile)))
+	require.NoError(t, err)
+
+	newClient := func(ratio float64) *GitlabNetClient {
+		httpClient, err := NewHTTPClientWithOpts(primaryURL, "", "", "", 1, defaultHttpOpts)
+		require.NoError(t, err)
+
+		client, err := NewGitlabNetClient("user", "password", secret, httpClient)
+		require.NoError(t, err)
+		client.Use(MirrorTo(mirror, ratio))
+
+		return client
+	}
+
+	t.Run("mirrored", func(t *testing.T) {
+		response, err := newClient(1).Post(context.Background(), "/allowed?check=1", map[string]string{"key": "value"})
+		require.NoError(t, err)
+		defer response.Body.Close()
+
+		body, err := io.ReadAll(response.Body)
+		require.NoError(t, err)
+		require.Equal(t, "primary", string(body), "the response of the mirror is ignored")
+
+		select {
+		case request := <-mirrored:
+			require.Equal(t, "/api/v4/internal/allowed?check=1", request.path)
+			require.Equal(t, `{"key":"value"}`, request.body)
+			require.Empty(t, request.authorization, "the credentials of the primary aren't copied")
+
+			_, err := jwt.Parse(request.token, func(*jwt.Token) (interface{}, error) {
+				return []byte("mirror secret"), nil
+			})
+			require.NoError(t, err, "the mirror signs with its own secret")
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "the request wasn't mirrored")
+		}
+	})
+
+	t.Run("not read-only", func(t *testing.T) {
+		response, err := newClient(1).Post(context.Background(), "/personal_access_token", map[string]string{"key_id": "1"})
+		require.NoError(t, err)
+		response.Body.Close()
+
+		select {
+		case request := <-mirrored:
+			require.Fail(t, "the request was mirrored", request.path)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("not sampled", func(t *testing.T) {
+		response, err := newClient(0).Post(context.Background(), "/allowed", map[string]string{"key": "value"})
+		require.NoError(t, err)
+		response.Body.Close()
+
+		select {
+		case request := <-mirrored:
+			require.Fail(t, "the request was mirrored", request.path)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+}
+
+func TestMirrorRequest(t *testing.T) {
+	request, err := http.NewRequest(http.MethodGet, "http://primary/-/readiness", nil)
+	require.NoError(t, err)
+
+	mirrored, err := mirrorRequest(request, "http://mirror")
+	require.NoError(t, err)
+	require.Nil(t, mirrored, "requests outside of the internal API aren't mirrored")
+
+	large, err := newRequest(context.Background(), http.MethodPost, "http://primary/gitlab", "/api/v4/internal/allowed", string(make([]byte, mirrorMaxBodySize)))
+	require.NoError(t, err)
+
+	mirrored, err = mirrorRequest(large.Request, "http://mirror/gitlab")
+	require.NoError(t, err)
+	require.Nil(t, mirrored, "large requests aren't mirrored")
+
+	small, err := newRequest(context.Background(), http.MethodPost, "http://primary/gitlab", "/api/v4/internal/allowed", "small")
+	require.NoError(t, err)
+	small.Header.Set("X-Test", "copied")
+	small.Header.Set(apiSecretHeaderName, "token")
+	small.SetBasicAuth("user", "password")
+
+	mirrored, err = mirrorRequest(small.Request, "http://mirror/gitlab")
+	require.NoError(t, err)
+	require.Equal(t, "http://mirror/gitlab/api/v4/internal/allowed", mirrored.URL.String())
+	require.Equal(t, "copied", mirrored.Header.Get("X-Test"))
+	require.Empty(t, mirrored.Header.Get(apiSecretHeaderName))
+	require.Empty(t, mirrored.Header.Get("Authorization"))
+
+	for _, path := range []string{"/api/v4/internal/lfs_authenticate", "/api/v4/internal/two_factor_recovery_codes", "/api/v4/internal/allowed/extra"} {
+		request, err := newRequest(context.Background(), http.MethodPost, "http://primary", path, "small")
+		require.NoError(t, err)
+
+		mirrored, err = mirrorRequest(request.Request, "http://mirror")
+		require.NoError(t, err)
+		require.Nil(t, mirrored, "%s isn't read-only", path)
+	}
+}
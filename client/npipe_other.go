@@ -0,0 +1,13 @@
+//go:build !windows
+
+package client
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+func dialNamedPipe(context.Context, string, time.Duration) (net.Conn, error) {
+	return nil, ErrNamedPipesUnsupported
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamedPipeUnsupported(t *testing.T) {
+	client, err := NewHTTPClientWithOpts("http+npipe://gitlab", "", "", "", 1, []HTTPClientOpt{WithValidateSocket()})
+	require.NoError(t, err)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, client.Host+"/api/v4/internal/check", nil)
+	require.NoError(t, err)
+
+	_, err = client.RetryableHTTP.HTTPClient.Do(req)
+	require.ErrorIs(t, err, ErrNamedPipesUnsupported)
+}
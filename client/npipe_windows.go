@@ -0,0 +1,21 @@
+package client
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// dialNamedPipe connects to the named pipe at path, waiting for an instance
+// of it to be free for up to timeout, if set, or until ctx is done
+func dialNamedPipe(ctx context.Context, path string, timeout time.Duration) (net.Conn, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return winio.DialPipeContext(ctx, path)
+}
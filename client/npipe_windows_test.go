@@ -0,0 +1,44 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamedPipe(t *testing.T) {
+	pipeName := fmt.Sprintf("gitlab-shell-test-%d", time.Now().UnixNano())
+
+	listener, err := winio.ListenPipe(`\\.\pipe\`+pipeName, nil)
+	require.NoError(t, err)
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}),
+		ReadHeaderTimeout: time.Second,
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	for _, url := range []string{"http+npipe://" + pipeName, "http+npipe:////./pipe/" + pipeName} {
+		t.Run(url, func(t *testing.T) {
+			client, err := NewHTTPClientWithOpts(url, "", "", "", 1, nil)
+			require.NoError(t, err)
+
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, client.Host+"/api/v4/internal/check", nil)
+			require.NoError(t, err)
+
+			resp, err := client.RetryableHTTP.HTTPClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, http.StatusNoContent, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// ErrOCSPStapleMissing is returned when WithRequiredOCSPStaple is set and the
+// server doesn't staple an OCSP response to its certificate
+var ErrOCSPStapleMissing = errors.New("server didn't staple an OCSP response")
+
+// CertRevokedError is returned when the OCSP response stapled by the server
+// reports its certificate as revoked
+type CertRevokedError struct {
+	SerialNumber *big.Int
+	RevokedAt    time.Time
+	Reason       int
+}
+
+func (e *CertRevokedError) Error() string {
+	return fmt.Sprintf("server certificate %s was revoked at %v", e.SerialNumber, e.RevokedAt)
+}
+
+// WithOCSPStapleCheck rejects TLS handshakes when the OCSP response stapled
+// by the server reports its certificate as revoked. Servers that don't staple
+// a response are accepted; use WithRequiredOCSPStaple to reject them too.
+func WithOCSPStapleCheck() HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.ocspStapleCheck = true
+	}
+}
+
+// WithRequiredOCSPStaple is like WithOCSPStapleCheck, but also rejects
+// handshakes with servers that don't staple an OCSP response
+func WithRequiredOCSPStaple() HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.ocspStapleCheck = true
+		hcc.ocspStapleRequired = true
+	}
+}
+
+// configureOCSPStapleCheck adds the check of the stapled OCSP response to
+// tlsConfig, after any verification configured already
+func configureOCSPStapleCheck(hcc httpClientCfg, tlsConfig *tls.Config) {
+	if !hcc.ocspStapleCheck {
+		return
+	}
+
+	roots := tlsConfig.RootCAs
+	next := tlsConfig.VerifyConnection
+	tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+		if next != nil {
+			if err := next(cs); err != nil {
+				return err
+			}
+		}
+
+		return verifyOCSPStaple(cs, roots, hcc.ocspStapleRequired)
+	}
+}
+
+// verifyOCSPStaple checks the staple against the issuer of the server
+// certificate. The staple is ignored when the issuer isn't known, as it
+// can't be told apart from a forged one.
+func verifyOCSPStaple(cs tls.ConnectionState, roots *x509.CertPool, required bool) error {
+	if len(cs.OCSPResponse) == 0 {
+		if required {
+			return ErrOCSPStapleMissing
+		}
+
+		return nil
+	}
+
+	if len(cs.PeerCertificates) == 0 {
+		return errNoPeerCertificates
+	}
+
+	leaf, issuer := leafAndIssuer(cs, roots)
+	if issuer == nil {
+		return nil
+	}
+
+	response, err := ocsp.ParseResponseForCert(cs.OCSPResponse, leaf, issuer)
+	if err != nil {
+		return fmt.Errorf("invalid OCSP staple: %w", err)
+	}
+
+	if response.Status == ocsp.Revoked {
+		return &CertRevokedError{
+			SerialNumber: response.SerialNumber,
+			RevokedAt:    response.RevokedAt,
+			Reason:       response.RevocationReason,
+		}
+	}
+
+	return nil
+}
+
+// leafAndIssuer returns the server certificate and the certificate that
+// issued it, from the verified chain. Verification options relying on
+// VerifyConnection leave the verified chains empty, so the chain is built
+// from the presented certificates and roots, the hostname having been
+// verified already. A trusted self-signed certificate is its own issuer; the
+// issuer is nil when no chain can be built.
+func leafAndIssuer(cs tls.ConnectionState, roots *x509.CertPool) (*x509.Certificate, *x509.Certificate) {
+	leaf := cs.PeerCertificates[0]
+
+	chains := cs.VerifiedChains
+	if len(chains) == 0 {
+		var err error
+		if chains, err = verifyChain(cs.PeerCertificates, roots, ""); err != nil || len(chains) == 0 {
+			return leaf, nil
+		}
+	}
+
+	chain := chains[0]
+	if len(chain) == 1 {
+		return leaf, chain[0]
+	}
+
+	return leaf, chain[1]
+}
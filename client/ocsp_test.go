@@ -0,0 +1,131 @@
+package client
+
+import (
+	"crypto"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+// withOCSPStaple returns cert stapled with an OCSP response of the given
+// status for it, signed by signer
+func withOCSPStaple(t *testing.T, cert, signer testCert, status int) testCert {
+	t.Helper()
+
+	template := ocsp.Response{
+		Status:       status,
+		SerialNumber: cert.cert.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(time.Hour),
+	}
+	if status == ocsp.Revoked {
+		template.RevokedAt = time.Now().Add(-time.Minute)
+		template.RevocationReason = ocsp.KeyCompromise
+	}
+
+	staple, err := ocsp.CreateResponse(signer.cert, signer.cert, template, signer.keyPair.PrivateKey.(crypto.Signer))
+	require.NoError(t, err)
+
+	cert.keyPair.OCSPStaple = staple
+
+	return cert
+}
+
+func TestWithOCSPStapleCheck(t *testing.T) {
+	cert := newTestCert(t, "localhost")
+	caFile := writeTempFile(t, cert.pem)
+
+	good := withOCSPStaple(t, cert, cert, ocsp.Good)
+	revoked := withOCSPStaple(t, cert, cert, ocsp.Revoked)
+	forged := withOCSPStaple(t, cert, newTestCert(t, "other"), ocsp.Good)
+
+	tests := []struct {
+		desc        string
+		cert        testCert
+		opts        []HTTPClientOpt
+		wantRevoked bool
+		wantErr     error
+		errMsg      string
+	}{
+		{desc: "good staple", cert: good, opts: []HTTPClientOpt{WithOCSPStapleCheck()}},
+		{desc: "revoked staple", cert: revoked, opts: []HTTPClientOpt{WithOCSPStapleCheck()}, wantRevoked: true},
+		{desc: "not checked by default", cert: revoked},
+		{desc: "missing staple fails open", cert: cert, opts: []HTTPClientOpt{WithOCSPStapleCheck()}},
+		{desc: "missing staple fails closed", cert: cert, opts: []HTTPClientOpt{WithRequiredOCSPStaple()}, wantErr: ErrOCSPStapleMissing},
+		{desc: "required staple is present", cert: good, opts: []HTTPClientOpt{WithRequiredOCSPStaple()}},
+		{desc: "staple signed by another key", cert: forged, opts: []HTTPClientOpt{WithOCSPStapleCheck()}, errMsg: "invalid OCSP staple"},
+		{desc: "revoked staple without SNI", cert: revoked, opts: []HTTPClientOpt{WithOCSPStapleCheck(), WithoutSNI()}, wantRevoked: true},
+		{
+			desc:        "revoked staple with the verification cache",
+			cert:        revoked,
+			opts:        []HTTPClientOpt{WithOCSPStapleCheck(), WithTLSVerificationCache()},
+			wantRevoked: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			url, _ := startTLSServer(t, tc.cert)
+
+			client, err := NewHTTPClientWithOpts(url, "", caFile, "", 1, append(tc.opts, WithHTTPRetryOpts(0, 0, 0)))
+			require.NoError(t, err)
+
+			err = get(t, client, url)
+			switch {
+			case tc.wantRevoked:
+				var revokedErr *CertRevokedError
+				require.ErrorAs(t, err, &revokedErr)
+				require.Equal(t, cert.cert.SerialNumber, revokedErr.SerialNumber)
+				require.Equal(t, ocsp.KeyCompromise, revokedErr.Reason)
+			case tc.wantErr != nil:
+				require.ErrorIs(t, err, tc.wantErr)
+			case tc.errMsg != "":
+				require.ErrorContains(t, err, tc.errMsg)
+			default:
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestOCSPStapleWithLeafOnlyChain(t *testing.T) {
+	ca := newTestCert(t, "ca")
+	caFile := writeTempFile(t, ca.pem)
+	leaf := newTestLeaf(t, ca, "localhost")
+
+	revoked := withOCSPStaple(t, leaf, ca, ocsp.Revoked)
+	selfSigned := withOCSPStaple(t, leaf, leaf, ocsp.Good)
+
+	verifications := map[string][]HTTPClientOpt{
+		"default":                {},
+		"without SNI":            {WithoutSNI()},
+		"the verification cache": {WithTLSVerificationCache()},
+		"pinning":                {WithPinnedCerts(spkiPin(leaf))},
+	}
+
+	for name, opts := range verifications {
+		opts = append(opts, WithOCSPStapleCheck(), WithHTTPRetryOpts(0, 0, 0))
+
+		t.Run("revoked staple with "+name, func(t *testing.T) {
+			url, _ := startTLSServer(t, revoked)
+
+			client, err := NewHTTPClientWithOpts(url, "", caFile, "", 1, opts)
+			require.NoError(t, err)
+
+			var revokedErr *CertRevokedError
+			require.ErrorAs(t, get(t, client, url), &revokedErr)
+			require.Equal(t, leaf.cert.SerialNumber, revokedErr.SerialNumber)
+		})
+
+		t.Run("staple signed by the leaf with "+name, func(t *testing.T) {
+			url, _ := startTLSServer(t, selfSigned)
+
+			client, err := NewHTTPClientWithOpts(url, "", caFile, "", 1, opts)
+			require.NoError(t, err)
+
+			require.ErrorContains(t, get(t, client, url), "invalid OCSP staple")
+		})
+	}
+}
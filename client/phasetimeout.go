@@ -0,0 +1,172 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Connection lifecycle phases bounded by PhaseTimeouts
+const (
+	PhaseDNS       = "dns"
+	PhaseConnect   = "connect"
+	PhaseTLS       = "tls"
+	PhaseFirstByte = "first_byte"
+)
+
+// PhaseTimeouts bounds the individual phases of a request: resolving the
+// host, connecting, the TLS handshake and waiting for the first byte of the
+// response once the request has been written. A zero value leaves the
+// corresponding phase unbounded.
+type PhaseTimeouts struct {
+	DNS       time.Duration
+	Connect   time.Duration
+	TLS       time.Duration
+	FirstByte time.Duration
+}
+
+// PhaseTimeoutError is returned when a phase exceeds its budget
+type PhaseTimeoutError struct {
+	Phase   string
+	Timeout time.Duration
+}
+
+func (e *PhaseTimeoutError) Error() string {
+	return fmt.Sprintf("%s phase timed out after %v", e.Phase, e.Timeout)
+}
+
+// WithPhaseTimeouts configures per-phase budgets for each request attempt.
+// A phase exceeding its budget fails the attempt with a *PhaseTimeoutError.
+func WithPhaseTimeouts(timeouts PhaseTimeouts) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.phaseTimeouts = timeouts
+	}
+}
+
+func (pt PhaseTimeouts) enabled() bool {
+	return pt.DNS > 0 || pt.Connect > 0 || pt.TLS > 0 || pt.FirstByte > 0
+}
+
+type phaseTimeoutTransport struct {
+	next     http.RoundTripper
+	timeouts PhaseTimeouts
+}
+
+func newPhaseTimeoutTransport(next http.RoundTripper, timeouts PhaseTimeouts) http.RoundTripper {
+	if !timeouts.enabled() {
+		return next
+	}
+
+	return &phaseTimeoutTransport{next: next, timeouts: timeouts}
+}
+
+func (pt *phaseTimeoutTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancelCause(request.Context())
+	timers := &phaseTimers{cancel: cancel}
+
+	ctx = httptrace.WithClientTrace(ctx, pt.clientTrace(timers))
+
+	response, err := pt.next.RoundTrip(request.WithContext(ctx))
+	timers.stopAll()
+
+	if err != nil {
+		var phaseErr *PhaseTimeoutError
+		if cause := context.Cause(ctx); errors.As(cause, &phaseErr) {
+			err = phaseErr
+		}
+		cancel(nil)
+
+		return response, err
+	}
+
+	// The body is read using ctx, so it may only be released once the caller
+	// is done with the response
+	response.Body = &cancelOnCloseBody{ReadCloser: response.Body, cancel: cancel}
+
+	return response, nil
+}
+
+func (pt *phaseTimeoutTransport) clientTrace(timers *phaseTimers) *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { timers.start(PhaseDNS, pt.timeouts.DNS) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { timers.stop(PhaseDNS) },
+		ConnectStart: func(string, string) {
+			timers.start(PhaseConnect, pt.timeouts.Connect)
+		},
+		ConnectDone: func(string, string, error) { timers.stop(PhaseConnect) },
+		TLSHandshakeStart: func() {
+			timers.start(PhaseTLS, pt.timeouts.TLS)
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) { timers.stop(PhaseTLS) },
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			timers.start(PhaseFirstByte, pt.timeouts.FirstByte)
+		},
+		GotFirstResponseByte: func() { timers.stop(PhaseFirstByte) },
+	}
+}
+
+// phaseTimers cancels a request when any running phase timer fires
+type phaseTimers struct {
+	cancel context.CancelCauseFunc
+
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+func (p *phaseTimers) start(phase string, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.timers == nil {
+		p.timers = make(map[string]*time.Timer)
+	}
+	if t, ok := p.timers[phase]; ok {
+		t.Stop()
+	}
+
+	p.timers[phase] = time.AfterFunc(timeout, func() {
+		p.cancel(&PhaseTimeoutError{Phase: phase, Timeout: timeout})
+	})
+}
+
+func (p *phaseTimers) stop(phase string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if t, ok := p.timers[phase]; ok {
+		t.Stop()
+		delete(p.timers, phase)
+	}
+}
+
+func (p *phaseTimers) stopAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for phase, t := range p.timers {
+		t.Stop()
+		delete(p.timers, phase)
+	}
+}
+
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelCauseFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel(nil)
+
+	return err
+}
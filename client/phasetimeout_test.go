@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// stallingRoundTripper reports the start of a phase and then blocks until
+// the request is canceled
+type stallingRoundTripper struct {
+	stall func(trace *httptrace.ClientTrace)
+}
+
+func (s *stallingRoundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	s.stall(httptrace.ContextClientTrace(request.Context()))
+
+	<-request.Context().Done()
+
+	return nil, request.Context().Err()
+}
+
+func TestPhaseTimeouts(t *testing.T) {
+	const budget = 10 * time.Millisecond
+
+	tests := []struct {
+		phase string
+		stall func(trace *httptrace.ClientTrace)
+	}{
+		{
+			phase: PhaseDNS,
+			stall: func(trace *httptrace.ClientTrace) { trace.DNSStart(httptrace.DNSStartInfo{Host: "gitlab.example.com"}) },
+		},
+		{
+			phase: PhaseConnect,
+			stall: func(trace *httptrace.ClientTrace) {
+				trace.DNSStart(httptrace.DNSStartInfo{Host: "gitlab.example.com"})
+				trace.DNSDone(httptrace.DNSDoneInfo{})
+				trace.ConnectStart("tcp", "192.0.2.1:443")
+			},
+		},
+		{
+			phase: PhaseTLS,
+			stall: func(trace *httptrace.ClientTrace) {
+				trace.ConnectStart("tcp", "192.0.2.1:443")
+				trace.ConnectDone("tcp", "192.0.2.1:443", nil)
+				trace.TLSHandshakeStart()
+			},
+		},
+		{
+			phase: PhaseFirstByte,
+			stall: func(trace *httptrace.ClientTrace) { trace.WroteRequest(httptrace.WroteRequestInfo{}) },
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.phase, func(t *testing.T) {
+			rt := newPhaseTimeoutTransport(&stallingRoundTripper{stall: tc.stall}, PhaseTimeouts{
+				DNS: budget, Connect: budget, TLS: budget, FirstByte: budget,
+			})
+
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://gitlab.example.com", nil)
+			require.NoError(t, err)
+
+			_, err = rt.RoundTrip(req)
+			require.Equal(t, &PhaseTimeoutError{Phase: tc.phase, Timeout: budget}, err)
+		})
+	}
+}
+
+func TestPhaseTimeoutsFirstByte(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.Write([]byte("Hello"))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewHTTPClientWithOpts(server.URL, "", "", "", 1, []HTTPClientOpt{
+		WithPhaseTimeouts(PhaseTimeouts{FirstByte: 20 * time.Millisecond}),
+	})
+	require.NoError(t, err)
+
+	request := func(path string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL+path, nil)
+		require.NoError(t, err)
+
+		return client.RetryableHTTP.HTTPClient.Do(req)
+	}
+
+	resp, err := request("/fast")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	_, err = request("/slow")
+	var phaseErr *PhaseTimeoutError
+	require.ErrorAs(t, err, &phaseErr)
+	require.Equal(t, PhaseFirstByte, phaseErr.Phase)
+}
+
+func TestPhaseTimeoutsDisabled(t *testing.T) {
+	next := http.DefaultTransport
+
+	require.Equal(t, next, newPhaseTimeoutTransport(next, PhaseTimeouts{}))
+}
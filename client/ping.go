@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const defaultHealthPath = "/"
+
+// PingFailure categorizes why a Ping failed
+type PingFailure int
+
+// Categories of Ping failures
+const (
+	// PingFailureConnection is any failure to reach the server other than
+	// the ones below
+	PingFailureConnection PingFailure = iota
+	// PingFailureConnectionRefused means nothing is listening at the address
+	PingFailureConnectionRefused
+	// PingFailureTLS means the TLS handshake failed, e.g. because the server
+	// certificate isn't trusted
+	PingFailureTLS
+	// PingFailureStatus means the server responded with a non-2xx status
+	PingFailureStatus
+)
+
+func (f PingFailure) String() string {
+	switch f {
+	case PingFailureConnectionRefused:
+		return "connection refused"
+	case PingFailureTLS:
+		return "TLS failure"
+	case PingFailureStatus:
+		return "unexpected status"
+	default:
+		return "connection failure"
+	}
+}
+
+// PingError is returned by Ping when the health check fails
+type PingError struct {
+	Failure PingFailure
+	// StatusCode is set for PingFailureStatus
+	StatusCode int
+	Err        error
+}
+
+func (e *PingError) Error() string {
+	if e.Failure == PingFailureStatus {
+		return fmt.Sprintf("ping: %s: %d", e.Failure, e.StatusCode)
+	}
+
+	return fmt.Sprintf("ping: %s: %v", e.Failure, e.Err)
+}
+
+func (e *PingError) Unwrap() error {
+	return e.Err
+}
+
+// WithHealthPath sets the path requested by Ping, relative to the GitLab URL.
+// It defaults to "/".
+func WithHealthPath(path string) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.healthPath = path
+	}
+}
+
+// Ping checks connectivity and TLS trust by requesting the health path once,
+// without retrying. It returns nil on a 2xx response and a *PingError
+// otherwise. The deadline of ctx applies.
+func (c *HTTPClient) Ping(ctx context.Context) error {
+	url := strings.TrimSuffix(c.Host, "/") + "/" + strings.TrimPrefix(c.healthPath, "/")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	// The underlying client, rather than the retrying one, makes a single attempt
+	resp, err := c.RetryableHTTP.HTTPClient.Do(req)
+	if err != nil {
+		return &PingError{Failure: pingFailureOf(err), Err: err}
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &PingError{Failure: PingFailureStatus, StatusCode: resp.StatusCode}
+	}
+
+	return nil
+}
+
+func pingFailureOf(err error) PingFailure {
+	var (
+		verificationErr *tls.CertificateVerificationError
+		recordErr       tls.RecordHeaderError
+		alertErr        tls.AlertError
+		unknownAuthErr  x509.UnknownAuthorityError
+		hostnameErr     x509.HostnameError
+		invalidErr      x509.CertificateInvalidError
+	)
+
+	switch {
+	case isConnectionRefused(err):
+		return PingFailureConnectionRefused
+	case errors.As(err, &verificationErr), errors.As(err, &recordErr), errors.As(err, &alertErr),
+		errors.As(err, &unknownAuthErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr),
+		errors.Is(err, errNoPeerCertificates):
+		return PingFailureTLS
+	default:
+		return PingFailureConnection
+	}
+}
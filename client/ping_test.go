@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPing(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		switch r.URL.Path {
+		case "/-/health":
+			w.WriteHeader(http.StatusOK)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	ping := func(ctx context.Context, path string) error {
+		client, err := NewHTTPClientWithOpts(server.URL, "", "", "", 1, []HTTPClientOpt{
+			WithHealthPath(path), WithHTTPRetryOpts(time.Millisecond, time.Millisecond, 2),
+		})
+		require.NoError(t, err)
+
+		return client.Ping(ctx)
+	}
+
+	t.Run("healthy", func(t *testing.T) {
+		require.NoError(t, ping(context.Background(), "/-/health"))
+	})
+
+	t.Run("unexpected status makes a single attempt", func(t *testing.T) {
+		requests.Store(0)
+
+		err := ping(context.Background(), "/unavailable")
+
+		var pingErr *PingError
+		require.ErrorAs(t, err, &pingErr)
+		require.Equal(t, PingFailureStatus, pingErr.Failure)
+		require.Equal(t, http.StatusServiceUnavailable, pingErr.StatusCode)
+		require.Equal(t, int32(1), requests.Load())
+	})
+
+	t.Run("context deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		require.ErrorIs(t, ping(ctx, "/slow"), context.DeadlineExceeded)
+	})
+}
+
+func TestPingConnectionRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	client, err := NewHTTPClientWithOpts("http://"+addr, "", "", "", 1, nil)
+	require.NoError(t, err)
+
+	var pingErr *PingError
+	require.ErrorAs(t, client.Ping(context.Background()), &pingErr)
+	require.Equal(t, PingFailureConnectionRefused, pingErr.Failure)
+}
+
+func TestPingUntrustedCertificate(t *testing.T) {
+	url, _ := startTLSServer(t, newTestCert(t, "localhost"))
+
+	client, err := NewHTTPClientWithOpts(url, "", "", "", 1, nil)
+	require.NoError(t, err)
+
+	var pingErr *PingError
+	require.ErrorAs(t, client.Ping(context.Background()), &pingErr)
+	require.Equal(t, PingFailureTLS, pingErr.Failure)
+}
@@ -0,0 +1,13 @@
+package client
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// isConnectionRefused tells whether err is Winsock's connection refused,
+// which syscall.ECONNREFUSED doesn't match on Windows
+func isConnectionRefused(err error) bool {
+	return errors.Is(err, windows.WSAECONNREFUSED)
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func spkiPin(cert testCert) string {
+	hash := sha256.Sum256(cert.cert.RawSubjectPublicKeyInfo)
+
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func TestWithPinnedCerts(t *testing.T) {
+	cert := newTestCert(t, "localhost")
+	other := newTestCert(t, "other")
+	url, _ := startTLSServer(t, cert)
+	caFile := writeTempFile(t, cert.pem)
+
+	tests := []struct {
+		desc      string
+		caFile    string
+		pins      []string
+		wantError error
+	}{
+		{desc: "matching pin", caFile: caFile, pins: []string{spkiPin(cert)}},
+		{desc: "matching curl-style pin among others", caFile: caFile, pins: []string{spkiPin(other), "sha256//" + spkiPin(cert)}},
+		{desc: "non-matching pin", caFile: caFile, pins: []string{spkiPin(other)}, wantError: ErrCertPinMismatch},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			client, err := NewHTTPClientWithOpts(url, "", tc.caFile, "", 1, []HTTPClientOpt{
+				WithHTTPRetryOpts(0, 0, 0),
+				WithPinnedCerts(tc.pins...),
+			})
+			require.NoError(t, err)
+
+			require.ErrorIs(t, get(t, client, url), tc.wantError)
+		})
+	}
+
+	t.Run("chain verification still applies", func(t *testing.T) {
+		client, err := NewHTTPClientWithOpts(url, "", "", "", 1, []HTTPClientOpt{WithPinnedCerts(spkiPin(cert))})
+		require.NoError(t, err)
+
+		err = get(t, client, url)
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrCertPinMismatch)
+	})
+}
+
+func TestWithPinnedCertsInvalidPin(t *testing.T) {
+	_, err := NewHTTPClientWithOpts("https://localhost", "", "", "", 1, []HTTPClientOpt{WithPinnedCerts("not-a-hash")})
+	require.ErrorContains(t, err, `invalid certificate pin "not-a-hash"`)
+}
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ErrInvalidProxyURL is returned when the URL given to WithProxy can't be
+// used as a proxy
+var ErrInvalidProxyURL = errors.New("invalid proxy URL")
+
+// WithProxy sends requests to HTTP and HTTPS GitLab URLs through the proxy at
+// proxyURL, which may use the http, https or socks5 scheme and embed
+// credentials. Without it, the proxy is taken from the HTTP_PROXY,
+// HTTPS_PROXY and NO_PROXY environment variables. Unix socket URLs are never
+// proxied, and a transport given to WithTransport keeps its own Proxy.
+func WithProxy(proxyURL string) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.proxyURL = proxyURL
+	}
+}
+
+func buildProxy(proxyURL string) (func(*http.Request) (*url.URL, error), error) {
+	if proxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	parsed, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidProxyURL, err)
+	}
+
+	switch parsed.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("%w: unsupported scheme %q", ErrInvalidProxyURL, parsed.Scheme)
+	}
+
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("%w: missing host", ErrInvalidProxyURL)
+	}
+
+	return http.ProxyURL(parsed), nil
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func startProxy(t *testing.T) (*url.URL, <-chan *http.Request) {
+	t.Helper()
+
+	requests := make(chan *http.Request, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+		w.Write([]byte("Hello"))
+	}))
+	t.Cleanup(proxy.Close)
+
+	proxyURL, err := url.Parse(proxy.URL)
+	require.NoError(t, err)
+
+	return proxyURL, requests
+}
+
+func TestWithProxy(t *testing.T) {
+	testCases := []struct {
+		desc              string
+		credentials       *url.Userinfo
+		expectedProxyAuth string
+	}{
+		{
+			desc: "without credentials",
+		},
+		{
+			desc:              "with credentials",
+			credentials:       url.UserPassword("user", "password"),
+			expectedProxyAuth: "Basic dXNlcjpwYXNzd29yZA==",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			proxyURL, requests := startProxy(t)
+			proxyURL.User = tc.credentials
+
+			client, err := NewHTTPClientWithOpts("http://gitlab.example.com", "", "", "", 0, []HTTPClientOpt{WithProxy(proxyURL.String())})
+			require.NoError(t, err)
+
+			require.NoError(t, get(t, client, "http://gitlab.example.com/api/v4/internal/check"))
+
+			r := <-requests
+			require.Equal(t, "gitlab.example.com", r.Host)
+			require.Equal(t, "/api/v4/internal/check", r.URL.Path)
+			require.Equal(t, tc.expectedProxyAuth, r.Header.Get("Proxy-Authorization"))
+		})
+	}
+}
+
+func TestInvalidProxyURL(t *testing.T) {
+	for _, proxyURL := range []string{"://proxy", "ftp://proxy.example.com", "http://"} {
+		t.Run(proxyURL, func(t *testing.T) {
+			_, err := NewHTTPClientWithOpts("http://gitlab.example.com", "", "", "", 0, []HTTPClientOpt{WithProxy(proxyURL)})
+			require.ErrorIs(t, err, ErrInvalidProxyURL)
+		})
+	}
+}
+
+func TestProxyFromEnvironmentByDefault(t *testing.T) {
+	fromEnvironment := reflect.ValueOf(http.ProxyFromEnvironment).Pointer()
+
+	for _, gitlabURL := range []string{"http://gitlab.example.com", "https://gitlab.example.com"} {
+		transport, _, err := buildTransport(httpClientCfg{trustedCAs: &trustedCAs{}}, gitlabURL, "")
+		require.NoError(t, err)
+		require.Equal(t, fromEnvironment, reflect.ValueOf(transport.Proxy).Pointer(), gitlabURL)
+	}
+
+	transport, _, err := buildTransport(httpClientCfg{}, "http+unix:///tmp/gitlab.socket", "")
+	require.NoError(t, err)
+	require.Nil(t, transport.Proxy, "unix sockets aren't proxied")
+}
+
+func TestProxyOfCustomTransportIsKept(t *testing.T) {
+	proxyURL, requests := startProxy(t)
+
+	custom := &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+	opts := []HTTPClientOpt{
+		WithTransport(custom),
+		WithProxy("http://unreachable.example.com"),
+	}
+
+	client, err := NewHTTPClientWithOpts("http://gitlab.example.com", "", "", "", 0, opts)
+	require.NoError(t, err)
+
+	require.NoError(t, get(t, client, "http://gitlab.example.com/api/v4/internal/check"))
+	require.Equal(t, "gitlab.example.com", (<-requests).Host)
+}
+
+func TestSocketIsNotProxied(t *testing.T) {
+	proxyURL, requests := startProxy(t)
+
+	socket := filepath.Join(t.TempDir(), "gitlab.socket")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("Hello"))
+	})}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+	client, err := NewHTTPClientWithOpts(unixSocketProtocol+socket, "", "", "", 0, []HTTPClientOpt{WithProxy(proxyURL.String())})
+	require.NoError(t, err)
+
+	require.NoError(t, get(t, client, socketBaseURL+"/api/v4/internal/check"))
+	require.Empty(t, requests)
+}
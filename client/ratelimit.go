@@ -0,0 +1,193 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"golang.org/x/time/rate"
+)
+
+// OperationContextKey is used as the key in a Context to name the git
+// operation, e.g. "git-receive-pack", a request is made on behalf of. It
+// selects the limit applied by WithOperationRateLimits.
+type OperationContextKey struct{}
+
+// RateLimit is the sustained rate and burst size allowed for an operation
+type RateLimit struct {
+	PerSecond float64
+	Burst     int
+}
+
+// WithOperationRateLimits throttles requests per operation, as named by
+// OperationContextKey, so that e.g. pushes can be limited more strictly than
+// fetches. Requests wait for their turn, or until their context is done.
+// Operations without a limit, and requests without an operation, aren't
+// throttled.
+func WithOperationRateLimits(limits map[string]RateLimit) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.operationRateLimits = limits
+	}
+}
+
+type rateLimitTransport struct {
+	next     http.RoundTripper
+	limiters map[string]*rate.Limiter
+}
+
+func newRateLimitTransport(next http.RoundTripper, limits map[string]RateLimit) http.RoundTripper {
+	if len(limits) == 0 {
+		return next
+	}
+
+	limiters := make(map[string]*rate.Limiter, len(limits))
+	for operation, limit := range limits {
+		limiters[operation] = rate.NewLimiter(rate.Limit(limit.PerSecond), limit.Burst)
+	}
+
+	return &rateLimitTransport{next: next, limiters: limiters}
+}
+
+func (rt *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	operation, _ := req.Context().Value(OperationContextKey{}).(string)
+
+	if limiter, ok := rt.limiters[operation]; ok {
+		if err := limiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+
+	return rt.next.RoundTrip(req)
+}
+
+// ErrServerBusy is returned without contacting the internal API when a
+// request waited for its turn for longer than the queue timeout of
+// WithRateLimits. Its message is shown to the user.
+var ErrServerBusy = &APIError{Msg: "The GitLab server is busy. Please try again later."}
+
+// RateLimits throttle the requests to the internal API, so that a storm of
+// SSH connections can't overwhelm GitLab. A RateLimit without PerSecond is no
+// limit, and its Burst defaults to PerSecond rounded up.
+type RateLimits struct {
+	// Global is shared by all the requests
+	Global RateLimit
+	// Endpoints limit the requests by path below /api/v4/internal, such as
+	// "allowed" or "authorized_keys", on top of Global
+	Endpoints map[string]RateLimit
+	// QueueTimeout is how long a request may wait for its turn before
+	// failing with ErrServerBusy. Requests wait until their context is done
+	// by default.
+	QueueTimeout time.Duration
+}
+
+// WithRateLimits throttles the requests, and their retries, with limits
+func WithRateLimits(limits RateLimits) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.rateLimits = limits
+	}
+}
+
+type endpointRateLimitTransport struct {
+	next         http.RoundTripper
+	global       *rate.Limiter
+	endpoints    map[string]*rate.Limiter
+	queueTimeout time.Duration
+}
+
+func newLimiter(limit RateLimit) *rate.Limiter {
+	if limit.PerSecond <= 0 {
+		return nil
+	}
+
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = int(math.Ceil(limit.PerSecond))
+	}
+
+	return rate.NewLimiter(rate.Limit(limit.PerSecond), burst)
+}
+
+func newEndpointRateLimitTransport(next http.RoundTripper, limits RateLimits) http.RoundTripper {
+	rt := &endpointRateLimitTransport{
+		next:         next,
+		global:       newLimiter(limits.Global),
+		endpoints:    make(map[string]*rate.Limiter),
+		queueTimeout: limits.QueueTimeout,
+	}
+
+	for endpoint, limit := range limits.Endpoints {
+		if limiter := newLimiter(limit); limiter != nil {
+			rt.endpoints[strings.Trim(endpoint, "/")] = limiter
+		}
+	}
+
+	if rt.global == nil && len(rt.endpoints) == 0 {
+		return next
+	}
+
+	return rt
+}
+
+// endpoint returns the path of an internal API URL below /api/v4/internal
+func endpoint(path string) string {
+	_, endpoint, _ := strings.Cut(path, internalAPIPath+"/")
+
+	return endpoint
+}
+
+func (rt *endpointRateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	queueCtx, cancel := ctx, context.CancelFunc(func() {})
+	if rt.queueTimeout > 0 {
+		queueCtx, cancel = context.WithTimeout(ctx, rt.queueTimeout)
+	}
+	defer cancel()
+
+	// The endpoint comes first, so that a request waiting for it doesn't
+	// hold a token of the global limit
+	for _, limiter := range []*rate.Limiter{rt.endpoints[endpoint(req.URL.Path)], rt.global} {
+		if limiter == nil {
+			continue
+		}
+
+		if err := limiter.Wait(queueCtx); err != nil {
+			if rt.queueExpired(ctx, queueCtx) {
+				return nil, ErrServerBusy
+			}
+
+			return nil, err
+		}
+	}
+
+	return rt.next.RoundTrip(req)
+}
+
+// queueExpired reports whether a failed wait is due to the queue timeout
+// rather than to ctx. The wait fails as soon as the turn of the request is
+// known to come after the deadline.
+func (rt *endpointRateLimitTransport) queueExpired(ctx, queueCtx context.Context) bool {
+	if rt.queueTimeout <= 0 || ctx.Err() != nil {
+		return false
+	}
+
+	deadline, ok := ctx.Deadline()
+	queueDeadline, _ := queueCtx.Deadline()
+
+	return !ok || deadline.After(queueDeadline)
+}
+
+// rateLimitRetryPolicy doesn't retry the requests refused with ErrServerBusy,
+// which would only add to the queue
+func rateLimitRetryPolicy(next retryablehttp.CheckRetry) retryablehttp.CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if errors.Is(err, ErrServerBusy) {
+			return false, nil
+		}
+
+		return next(ctx, resp, err)
+	}
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+)
+
+func TestWithOperationRateLimits(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewHTTPClientWithOpts(server.URL, "", "", "", 1, []HTTPClientOpt{
+		WithOperationRateLimits(map[string]RateLimit{
+			"git-receive-pack": {PerSecond: 1, Burst: 2},
+			"git-upload-pack":  {PerSecond: 100, Burst: 10},
+		}),
+	})
+	require.NoError(t, err)
+
+	// Makes 5 requests for operation within a short deadline and returns how
+	// many were let through
+	completed := func(operation string) int {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		ctx = context.WithValue(ctx, OperationContextKey{}, operation)
+
+		n := 0
+		for i := 0; i < 5; i++ {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+			require.NoError(t, err)
+
+			resp, err := client.RetryableHTTP.HTTPClient.Do(req)
+			if err == nil {
+				require.NoError(t, resp.Body.Close())
+				n++
+			}
+		}
+
+		return n
+	}
+
+	require.Equal(t, 2, completed("git-receive-pack"))
+	require.Equal(t, 5, completed("git-upload-pack"))
+	require.Equal(t, 5, completed("unlimited"))
+}
+
+func TestWithRateLimits(t *testing.T) {
+	var requests atomic.Int32
+	handler := func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+	}
+
+	url := testserver.StartHttpServer(t, []testserver.TestRequestHandler{
+		{Path: "/api/v4/internal/allowed", Handler: handler},
+		{Path: "/api/v4/internal/discover", Handler: handler},
+		{Path: "/api/v4/internal/check", Handler: handler},
+	})
+
+	opts := append([]HTTPClientOpt{WithRateLimits(RateLimits{
+		Global:       RateLimit{PerSecond: 1, Burst: 3},
+		Endpoints:    map[string]RateLimit{"allowed": {PerSecond: 1}, "/discover/": {PerSecond: 100, Burst: 10}},
+		QueueTimeout: 50 * time.Millisecond,
+	})}, defaultHttpOpts...)
+	httpClient, err := NewHTTPClientWithOpts(url, "", "", "", 1, opts)
+	require.NoError(t, err)
+
+	client, err := NewGitlabNetClient("", "", "", httpClient)
+	require.NoError(t, err)
+
+	get := func(path string) error {
+		resp, err := client.Get(context.Background(), path)
+		if err == nil {
+			require.NoError(t, resp.Body.Close())
+		}
+		return err
+	}
+
+	require.NoError(t, get("/allowed"))
+	require.Equal(t, ErrServerBusy, get("/allowed"), "the endpoint limit applies")
+
+	require.NoError(t, get("/discover"))
+	require.NoError(t, get("/check"))
+	require.Equal(t, ErrServerBusy, get("/check"), "the global limit applies")
+	require.Equal(t, ErrServerBusy, get("/discover"), "the global limit applies on top of the endpoint one")
+
+	require.Equal(t, int32(3), requests.Load(), "busy requests aren't retried")
+}
+
+func TestRateLimitsWaitForTheirTurn(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewHTTPClientWithOpts(server.URL, "", "", "", 1, []HTTPClientOpt{
+		WithRateLimits(RateLimits{Global: RateLimit{PerSecond: 20, Burst: 1}}),
+	})
+	require.NoError(t, err)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, get(t, client, server.URL+"/api/v4/internal/check"))
+	}
+	require.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	require.NoError(t, get(t, client, server.URL))
+
+	_, err = client.RetryableHTTP.HTTPClient.Do(req)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrServerBusy, "the deadline of the request isn't a queue timeout")
+}
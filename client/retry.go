@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// DefaultRetryStatusCodes are the statuses worth retrying: the server is
+// overloaded or briefly unreachable behind a proxy
+var DefaultRetryStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// authFailureStatusCodes are never retried: the same credentials would fail
+// again, and retrying them may lock the account out
+var authFailureStatusCodes = []int{
+	http.StatusUnauthorized,
+	http.StatusForbidden,
+	http.StatusProxyAuthRequired,
+}
+
+// WithRetryStatusCodes retries only the responses with one of the given
+// statuses, such as DefaultRetryStatusCodes, instead of those retried by
+// WithRetryPolicy. Authentication failures are never retried, even if listed.
+// Requests failing without a response are retried as before.
+func WithRetryStatusCodes(codes ...int) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.retryStatusCodes = codes
+	}
+}
+
+// WithRetryAfter makes the client wait for as long as the Retry-After header
+// of a 429 or 503 response says before retrying it, instead of its own
+// backoff. Responses asking to wait longer than maxWait, or beyond the
+// deadline of the request, aren't retried.
+func WithRetryAfter(maxWait time.Duration) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.retryAfterMax = maxWait
+	}
+}
+
+// WithFullJitter picks the wait before each retry at random between zero and
+// the exponential backoff bounded by WithHTTPRetryOpts, so that clients
+// failing together don't retry together
+func WithFullJitter() HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.fullJitter = true
+	}
+}
+
+// statusCodeRetryPolicy wraps next so that responses are retried depending
+// on their status only
+func statusCodeRetryPolicy(codes []int, next retryablehttp.CheckRetry) retryablehttp.CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if err != nil || resp == nil || ctx.Err() != nil {
+			return next(ctx, resp, err)
+		}
+
+		if slices.Contains(authFailureStatusCodes, resp.StatusCode) || !slices.Contains(codes, resp.StatusCode) {
+			return false, nil
+		}
+
+		return true, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+}
+
+// retryAfterPolicy wraps next so that responses asking to wait longer than
+// maxWait, or beyond the deadline of ctx, aren't retried
+func retryAfterPolicy(maxWait time.Duration, next retryablehttp.CheckRetry) retryablehttp.CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		shouldRetry, checkErr := next(ctx, resp, err)
+		if !shouldRetry || err != nil {
+			return shouldRetry, checkErr
+		}
+
+		wait, ok := retryAfter(resp)
+		if !ok {
+			return shouldRetry, checkErr
+		}
+
+		if deadline, hasDeadline := ctx.Deadline(); wait > maxWait || (hasDeadline && time.Now().Add(wait).After(deadline)) {
+			return false, checkErr
+		}
+
+		return shouldRetry, checkErr
+	}
+}
+
+// retryAfter returns the wait requested by the Retry-After header of a 429
+// or 503 response, given in seconds or as a date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.ParseInt(header, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(header)
+	if err != nil {
+		return 0, false
+	}
+
+	return max(time.Until(date), 0), true
+}
+
+// backoffPolicy returns the wait before a retry enabled by the options,
+// retryablehttp.DefaultBackoff if none is
+func backoffPolicy(hcc httpClientCfg) retryablehttp.Backoff {
+	if hcc.retryAfterMax <= 0 && !hcc.fullJitter {
+		return retryablehttp.DefaultBackoff
+	}
+
+	return func(waitMin, waitMax time.Duration, attemptNum int, resp *http.Response) time.Duration {
+		if wait, ok := retryAfter(resp); ok {
+			if hcc.retryAfterMax > 0 {
+				return min(wait, hcc.retryAfterMax)
+			}
+
+			// As retryablehttp.DefaultBackoff does
+			return wait
+		}
+
+		if !hcc.fullJitter {
+			return retryablehttp.DefaultBackoff(waitMin, waitMax, attemptNum, nil)
+		}
+
+		return fullJitterBackoff(waitMin, waitMax, attemptNum)
+	}
+}
+
+// fullJitterBackoff picks a wait at random up to the exponential backoff of
+// the attempt
+func fullJitterBackoff(waitMin, waitMax time.Duration, attemptNum int) time.Duration {
+	backoff := math.Min(math.Pow(2, float64(attemptNum))*float64(waitMin), float64(waitMax))
+	if backoff <= 0 {
+		return 0
+	}
+
+	//nolint:gosec // The jitter needn't be cryptographically secure
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
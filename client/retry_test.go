@@ -0,0 +1,155 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+)
+
+func TestRetryStatusCodes(t *testing.T) {
+	attempts := make(map[string]int)
+	handler := func(status int) func(http.ResponseWriter, *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			attempts[r.URL.Path]++
+			w.WriteHeader(status)
+		}
+	}
+
+	requests := []testserver.TestRequestHandler{
+		{Path: "/api/v4/internal/bad_gateway", Handler: handler(http.StatusBadGateway)},
+		{Path: "/api/v4/internal/internal_error", Handler: handler(http.StatusInternalServerError)},
+		{Path: "/api/v4/internal/unauthorized", Handler: handler(http.StatusUnauthorized)},
+	}
+
+	url := testserver.StartHttpServer(t, requests)
+	opts := append(defaultHttpOpts, WithRetryStatusCodes(append(DefaultRetryStatusCodes, http.StatusUnauthorized)...))
+	httpClient, err := NewHTTPClientWithOpts(url, "", "", "", 1, opts)
+	require.NoError(t, err)
+
+	client, err := NewGitlabNetClient("", "", "", httpClient)
+	require.NoError(t, err)
+
+	tests := []struct {
+		path     string
+		attempts int
+	}{
+		{path: "/bad_gateway", attempts: 3},
+		{path: "/internal_error", attempts: 1},
+		{path: "/unauthorized", attempts: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			_, err := client.Get(context.Background(), tc.path)
+			require.Error(t, err)
+			require.Equal(t, tc.attempts, attempts["/api/v4/internal"+tc.path])
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	var attempts []time.Time
+	retryAfter := "1"
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/rate_limited",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				attempts = append(attempts, time.Now())
+				if len(attempts) > 1 {
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+
+				w.Header().Set("Retry-After", retryAfter)
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+		},
+	}
+
+	url := testserver.StartHttpServer(t, requests)
+	httpClient, err := NewHTTPClientWithOpts(url, "", "", "", 1, append(defaultHttpOpts, WithRetryAfter(2*time.Second)))
+	require.NoError(t, err)
+
+	client, err := NewGitlabNetClient("", "", "", httpClient)
+	require.NoError(t, err)
+
+	t.Run("within the limit", func(t *testing.T) {
+		attempts = nil
+
+		response, err := client.Get(context.Background(), "/rate_limited")
+		require.NoError(t, err)
+		defer response.Body.Close()
+
+		require.Len(t, attempts, 2)
+		require.GreaterOrEqual(t, attempts[1].Sub(attempts[0]), time.Second)
+	})
+
+	t.Run("beyond the limit", func(t *testing.T) {
+		attempts = nil
+		retryAfter = "60"
+
+		_, err := client.Get(context.Background(), "/rate_limited")
+		require.Error(t, err)
+		require.Len(t, attempts, 1)
+	})
+
+	t.Run("beyond the deadline", func(t *testing.T) {
+		attempts = nil
+		retryAfter = "1"
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		_, err := client.Get(ctx, "/rate_limited")
+		require.Error(t, err)
+		require.Len(t, attempts, 1)
+	})
+}
+
+func TestRetryAfterHeader(t *testing.T) {
+	tests := []struct {
+		desc   string
+		status int
+		header string
+		wait   time.Duration
+		ok     bool
+	}{
+		{desc: "seconds", status: http.StatusTooManyRequests, header: "3", wait: 3 * time.Second, ok: true},
+		{desc: "past date", status: http.StatusServiceUnavailable, header: "Wed, 21 Oct 2015 07:28:00 GMT", ok: true},
+		{desc: "negative", status: http.StatusTooManyRequests, header: "-1"},
+		{desc: "invalid", status: http.StatusTooManyRequests, header: "soon"},
+		{desc: "missing", status: http.StatusTooManyRequests},
+		{desc: "other status", status: http.StatusBadGateway, header: "3"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tc.status, Header: http.Header{}}
+			if tc.header != "" {
+				resp.Header.Set("Retry-After", tc.header)
+			}
+
+			wait, ok := retryAfter(resp)
+			require.Equal(t, tc.ok, ok)
+			require.Equal(t, tc.wait, wait)
+		})
+	}
+}
+
+func TestFullJitterBackoff(t *testing.T) {
+	backoff := backoffPolicy(httpClientCfg{fullJitter: true})
+
+	for attempt := 0; attempt < 10; attempt++ {
+		wait := backoff(time.Second, 5*time.Second, attempt, nil)
+		require.GreaterOrEqual(t, wait, time.Duration(0))
+		require.LessOrEqual(t, wait, min(time.Second<<attempt, 5*time.Second))
+	}
+
+	resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": []string{"2"}}}
+	require.Equal(t, 2*time.Second, backoff(time.Second, 5*time.Second, 0, resp))
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// RetryBudget bounds the retries shared by all the requests made with the
+// contexts it's attached to, such as those of an SSH connection. A degraded
+// GitLab then delays a connection by a bounded number of retries, instead of
+// by the retries of every request it makes. Each request still retries no
+// more than its own retries allow.
+//
+// The budget is drawn from by the requests made through GitlabNetClient;
+// those sent with RetryableHTTP directly may use up a retry of the budget
+// on their last attempt.
+type RetryBudget struct {
+	remaining atomic.Int64
+}
+
+// NewRetryBudget returns a budget of retries, shared by the requests it's
+// attached to with WithRetryBudget
+func NewRetryBudget(retries int) *RetryBudget {
+	budget := &RetryBudget{}
+	budget.remaining.Store(int64(retries))
+
+	return budget
+}
+
+// Remaining returns the number of retries left in the budget
+func (b *RetryBudget) Remaining() int {
+	return int(max(b.remaining.Load(), 0))
+}
+
+// take uses up a retry of the budget, telling whether one was left
+func (b *RetryBudget) take() bool {
+	return b.remaining.Add(-1) >= 0
+}
+
+type retryBudgetContextKey struct{}
+
+func hasRetryBudget(ctx context.Context) bool {
+	_, ok := ctx.Value(retryBudgetContextKey{}).(*RetryBudget)
+
+	return ok
+}
+
+// WithRetryBudget returns ctx with the retries of the requests made with it
+// drawn from budget. A nil budget returns ctx unchanged.
+func WithRetryBudget(ctx context.Context, budget *RetryBudget) context.Context {
+	if budget == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, retryBudgetContextKey{}, budget)
+}
+
+// retryBudgetPolicy wraps next so that the requests made with a RetryBudget
+// are given up on once it's used up. Only the attempts next retries draw
+// from the budget.
+func retryBudgetPolicy(next retryablehttp.CheckRetry) retryablehttp.CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		shouldRetry, checkErr := next(ctx, resp, err)
+
+		budget, ok := ctx.Value(retryBudgetContextKey{}).(*RetryBudget)
+		if !ok || !shouldRetry {
+			return shouldRetry, checkErr
+		}
+
+		if !budget.take() {
+			return false, checkErr
+		}
+
+		return true, checkErr
+	}
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+)
+
+func TestRetryBudget(t *testing.T) {
+	var attempts atomic.Int32
+	url := testserver.StartHttpServer(t, []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/allowed",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				attempts.Add(1)
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+		},
+	})
+
+	httpClient, err := NewHTTPClientWithOpts(url, "", "", "", 1, defaultHttpOpts)
+	require.NoError(t, err)
+
+	client, err := NewGitlabNetClient("", "", "", httpClient)
+	require.NoError(t, err)
+
+	get := func(ctx context.Context) {
+		resp, err := client.Get(ctx, "/allowed")
+		if err == nil {
+			require.NoError(t, resp.Body.Close())
+		}
+	}
+
+	budget := NewRetryBudget(3)
+	ctx := WithRetryBudget(context.Background(), budget)
+
+	get(ctx)
+	require.Equal(t, int32(3), attempts.Load(), "the request retries as often as the client allows")
+	require.Equal(t, 1, budget.Remaining())
+
+	get(ctx)
+	require.Equal(t, int32(5), attempts.Load(), "the request retries with what is left of the budget")
+	require.Equal(t, 0, budget.Remaining())
+
+	get(ctx)
+	require.Equal(t, int32(6), attempts.Load(), "the request isn't retried once the budget is used up")
+	require.Equal(t, 0, budget.Remaining())
+
+	get(context.Background())
+	require.Equal(t, int32(9), attempts.Load(), "the requests without a budget keep their retries")
+
+	require.Equal(t, context.Background(), WithRetryBudget(context.Background(), nil))
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startRotatingTLSServer starts an HTTPS server presenting the certificate
+// held in current, which can be swapped while connections are open
+func startRotatingTLSServer(t *testing.T, current *atomic.Pointer[testCert]) string {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("Hello"))
+	}))
+	server.TLS = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Make new connections perform a full handshake against the current
+		// certificate rather than resuming
+		SessionTicketsDisabled: true,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &current.Load().keyPair, nil
+		},
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+}
+
+// connect makes a request with transport and returns the certificate the
+// connection was established with and whether the connection was reused
+func connect(t *testing.T, transport *http.Transport, url string) ([]byte, bool, error) {
+	t.Helper()
+
+	var reused bool
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused }}
+	ctx := httptrace.WithClientTrace(context.Background(), trace)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	require.NoError(t, err)
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	_, err = resp.Body.Read(make([]byte, 16))
+	require.ErrorContains(t, err, "EOF")
+
+	return resp.TLS.PeerCertificates[0].Raw, reused, nil
+}
+
+func TestVerificationAcrossCertificateRotation(t *testing.T) {
+	oldCert := newTestCert(t, "localhost")
+	newCert := newTestCert(t, "localhost")
+
+	tests := []struct {
+		desc          string
+		opts          []HTTPClientOpt
+		wantNewConnOK bool
+	}{
+		{
+			desc:          "verification cache",
+			opts:          []HTTPClientOpt{WithTLSVerificationCache()},
+			wantNewConnOK: true,
+		},
+		{
+			desc:          "pins covering both certificates",
+			opts:          []HTTPClientOpt{WithPinnedCerts(spkiPin(oldCert), spkiPin(newCert))},
+			wantNewConnOK: true,
+		},
+		{
+			desc: "pin of the old certificate only",
+			opts: []HTTPClientOpt{WithPinnedCerts(spkiPin(oldCert))},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			var current atomic.Pointer[testCert]
+			current.Store(&oldCert)
+			url := startRotatingTLSServer(t, &current)
+
+			hcc := httpClientCfg{caPEMs: [][]byte{oldCert.pem, newCert.pem}}
+			for _, opt := range tc.opts {
+				opt(&hcc)
+			}
+
+			transport, _, err := buildTransport(hcc, url, "")
+			require.NoError(t, err)
+			t.Cleanup(transport.CloseIdleConnections)
+
+			raw, reused, err := connect(t, transport, url)
+			require.NoError(t, err)
+			require.False(t, reused)
+			require.Equal(t, oldCert.cert.Raw, raw)
+
+			current.Store(&newCert)
+
+			// The established connection keeps working
+			raw, reused, err = connect(t, transport, url)
+			require.NoError(t, err)
+			require.True(t, reused)
+			require.Equal(t, oldCert.cert.Raw, raw)
+
+			// A new connection, sharing the same TLS configuration, is
+			// verified against the new certificate
+			newConnTransport := transport.Clone()
+			t.Cleanup(newConnTransport.CloseIdleConnections)
+
+			raw, reused, err = connect(t, newConnTransport, url)
+			if !tc.wantNewConnOK {
+				require.ErrorIs(t, err, ErrCertPinMismatch)
+				return
+			}
+			require.NoError(t, err)
+			require.False(t, reused)
+			require.Equal(t, newCert.cert.Raw, raw)
+		})
+	}
+}
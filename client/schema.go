@@ -0,0 +1,108 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// ErrSchemaViolation is returned when a successful response doesn't conform
+// to the JSON schema registered for its endpoint with WithResponseSchema
+var ErrSchemaViolation = errors.New("response violates the JSON schema")
+
+type responseSchema struct {
+	path   string
+	schema []byte
+}
+
+// WithResponseSchema validates successful responses from the endpoint at
+// path, e.g. "/allowed", against the JSON schema in schema. The path is
+// matched against the end of the request path, so that it doesn't depend on
+// the relative URL root. Responses from other endpoints aren't read.
+func WithResponseSchema(path string, schema []byte) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.responseSchemas = append(hcc.responseSchemas, responseSchema{path: path, schema: schema})
+	}
+}
+
+type compiledSchema struct {
+	path   string
+	schema *jsonschema.Schema
+}
+
+type schemaTransport struct {
+	next    http.RoundTripper
+	schemas []compiledSchema
+}
+
+func newSchemaTransport(next http.RoundTripper, schemas []responseSchema) (http.RoundTripper, error) {
+	if len(schemas) == 0 {
+		return next, nil
+	}
+
+	compiled := make([]compiledSchema, 0, len(schemas))
+	for _, s := range schemas {
+		schema, err := jsonschema.CompileString(s.path, string(s.schema))
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON schema for %s: %w", s.path, err)
+		}
+
+		compiled = append(compiled, compiledSchema{path: s.path, schema: schema})
+	}
+
+	return &schemaTransport{next: next, schemas: compiled}, nil
+}
+
+func (rt *schemaTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := rt.next.RoundTrip(request)
+	if err != nil || response.StatusCode < 200 || response.StatusCode >= 300 {
+		return response, err
+	}
+
+	schema := rt.schemaFor(request.URL.Path)
+	if schema == nil {
+		return response, nil
+	}
+
+	body, err := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateJSON(schema, body); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrSchemaViolation, request.URL.Path, err)
+	}
+
+	response.Body = io.NopCloser(bytes.NewReader(body))
+
+	return response, nil
+}
+
+func (rt *schemaTransport) schemaFor(path string) *jsonschema.Schema {
+	for _, s := range rt.schemas {
+		if strings.HasSuffix(path, s.path) {
+			return s.schema
+		}
+	}
+
+	return nil
+}
+
+func validateJSON(schema *jsonschema.Schema, body []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var doc any
+	if err := decoder.Decode(&doc); err != nil {
+		return err
+	}
+
+	return schema.Validate(doc)
+}
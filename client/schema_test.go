@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const allowedSchema = `{
+	"type": "object",
+	"required": ["status", "gl_id"],
+	"properties": {
+		"status": {"type": "boolean"},
+		"gl_id": {"type": "string"}
+	}
+}`
+
+func TestWithResponseSchema(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/internal/allowed":
+			w.Write([]byte(`{"status": true, "gl_id": "user-1"}`))
+		case "/api/v4/internal/drifted/allowed":
+			w.Write([]byte(`{"status": "yes"}`))
+		case "/api/v4/internal/invalid/allowed":
+			w.Write([]byte(`not json`))
+		case "/api/v4/internal/failed/allowed":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "Not found"}`))
+		default:
+			w.Write([]byte(`anything`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewHTTPClientWithOpts(server.URL, "", "", "", 1, []HTTPClientOpt{
+		WithHTTPRetryOpts(0, 0, 0),
+		WithResponseSchema("/allowed", []byte(allowedSchema)),
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		desc      string
+		path      string
+		wantBody  string
+		wantError bool
+	}{
+		{desc: "conforming response", path: "/api/v4/internal/allowed", wantBody: `{"status": true, "gl_id": "user-1"}`},
+		{desc: "non-conforming response", path: "/api/v4/internal/drifted/allowed", wantError: true},
+		{desc: "invalid JSON", path: "/api/v4/internal/invalid/allowed", wantError: true},
+		{desc: "error responses aren't validated", path: "/api/v4/internal/failed/allowed", wantBody: `{"message": "Not found"}`},
+		{desc: "other endpoints aren't validated", path: "/api/v4/internal/check", wantBody: "anything"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL+tc.path, nil)
+			require.NoError(t, err)
+
+			resp, err := client.RetryableHTTP.HTTPClient.Do(req)
+			if tc.wantError {
+				require.ErrorIs(t, err, ErrSchemaViolation)
+				return
+			}
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, tc.wantBody, string(body))
+		})
+	}
+}
+
+func TestWithResponseSchemaInvalidSchema(t *testing.T) {
+	_, err := NewHTTPClientWithOpts("http://localhost", "", "", "", 1, []HTTPClientOpt{
+		WithResponseSchema("/allowed", []byte(`{"type": 1}`)),
+	})
+	require.ErrorContains(t, err, "invalid JSON schema for /allowed")
+}
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"net/http"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+// WithSlowRequestThreshold logs a warning for each request to the internal
+// API that took longer than threshold, retries included
+func WithSlowRequestThreshold(threshold time.Duration) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.slowRequestThreshold = threshold
+	}
+}
+
+// logSlowRequest logs request if it took longer than the threshold
+func (c *HTTPClient) logSlowRequest(request *http.Request, response *http.Response, attempts int, duration time.Duration) {
+	if c.slowRequestThreshold <= 0 || duration <= c.slowRequestThreshold {
+		return
+	}
+
+	fields := log.Fields{
+		"method":       request.Method,
+		"endpoint":     metricsEndpoint(request),
+		"duration_ms":  duration.Milliseconds(),
+		"threshold_ms": c.slowRequestThreshold.Milliseconds(),
+		"attempts":     attempts,
+	}
+	if response != nil {
+		fields["status"] = response.StatusCode
+	}
+
+	log.WithContextFields(request.Context(), fields).Warn("Slow internal API request")
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithSlowRequestThreshold(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v4/internal/slow" {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	httpClient, err := NewHTTPClientWithOpts(server.URL, "", "", "", 1, []HTTPClientOpt{
+		WithSlowRequestThreshold(10 * time.Millisecond),
+	})
+	require.NoError(t, err)
+
+	client, err := NewGitlabNetClient("", "", "", httpClient)
+	require.NoError(t, err)
+
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	for _, path := range []string{"/fast", "/slow"} {
+		resp, err := client.Get(context.Background(), path)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+
+	var entries []*logrus.Entry
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Slow internal API request" {
+			entries = append(entries, entry)
+		}
+	}
+
+	require.Len(t, entries, 1)
+	require.Equal(t, logrus.WarnLevel, entries[0].Level)
+	require.Equal(t, "/slow", entries[0].Data["endpoint"])
+	require.Equal(t, 1, entries[0].Data["attempts"])
+	require.Equal(t, http.StatusOK, entries[0].Data["status"])
+}
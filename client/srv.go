@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	srvHTTPProtocol  = "srv+http://"
+	srvHTTPSProtocol = "srv+https://"
+	srvLookupTimeout = 5 * time.Second
+)
+
+// ErrNoSRVTargets is returned when the DNS SRV name of a GitLab URL has no
+// targets
+var ErrNoSRVTargets = errors.New("no targets found for DNS SRV name")
+
+type srvResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+var defaultSRVResolver srvResolver = net.DefaultResolver
+
+// expandSRVURLs replaces the srv+http and srv+https URLs of gitlabURLs, such
+// as srv+https://_gitlab._tcp.example.com, with an http or https URL for each
+// of the targets of their DNS SRV name, ordered by priority. The path and
+// credentials of the URL are kept.
+func expandSRVURLs(resolver srvResolver, gitlabURLs []string) ([]string, error) {
+	expanded := make([]string, 0, len(gitlabURLs))
+
+	for _, gitlabURL := range gitlabURLs {
+		scheme := ""
+		switch {
+		case strings.HasPrefix(gitlabURL, srvHTTPProtocol):
+			scheme = "http"
+		case strings.HasPrefix(gitlabURL, srvHTTPSProtocol):
+			scheme = "https"
+		default:
+			expanded = append(expanded, gitlabURL)
+			continue
+		}
+
+		targets, err := resolveSRVURL(resolver, gitlabURL, scheme)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, targets...)
+	}
+
+	return expanded, nil
+}
+
+func resolveSRVURL(resolver srvResolver, gitlabURL, scheme string) ([]string, error) {
+	u, err := url.Parse(gitlabURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), srvLookupTimeout)
+	defer cancel()
+
+	// LookupSRV sorts the records by priority, and randomizes them by weight
+	_, records, err := resolver.LookupSRV(ctx, "", "", u.Hostname())
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %q: %w", u.Hostname(), err)
+	}
+
+	targets := make([]string, 0, len(records))
+	for _, record := range records {
+		target := strings.TrimSuffix(record.Target, ".")
+		// A target of "." means the service isn't available
+		if target == "" {
+			continue
+		}
+
+		u.Scheme = scheme
+		u.Host = net.JoinHostPort(target, strconv.Itoa(int(record.Port)))
+		targets = append(targets, u.String())
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrNoSRVTargets, u.Hostname())
+	}
+
+	return targets, nil
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testCert is a self-signed certificate generated for a single test
+type testCert struct {
+	cert    *x509.Certificate
+	keyPair tls.Certificate
+	pem     []byte
+}
+
+func newTestCert(t testing.TB, commonName string) testCert {
+	t.Helper()
+
+	return newTestCertFor(t, commonName, []string{"localhost", commonName}, net.ParseIP("127.0.0.1"))
+}
+
+// newTestCertFor generates a self-signed certificate valid only for the
+// given DNS names and IP addresses
+func newTestCertFor(t testing.TB, commonName string, dnsNames []string, ips ...net.IP) testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              dnsNames,
+		IPAddresses:           ips,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return testCert{
+		cert:    cert,
+		keyPair: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert},
+		pem:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// newTestLeaf generates a certificate for localhost issued by ca. Servers
+// using it present the leaf alone, without ca.
+func newTestLeaf(t testing.TB, ca testCert, commonName string) testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost", commonName},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.keyPair.PrivateKey)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return testCert{
+		cert:    cert,
+		keyPair: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert},
+		pem:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+func fingerprintOf(cert testCert) [sha256.Size]byte {
+	return sha256.Sum256(cert.cert.Raw)
+}
+
+func writeTempFile(t *testing.T, contents []byte) string {
+	t.Helper()
+
+	f, err := os.CreateTemp(t.TempDir(), "cert")
+	require.NoError(t, err)
+	_, err = f.Write(contents)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	return f.Name()
+}
@@ -15,12 +15,23 @@ import (
 	"gitlab.com/gitlab-org/labkit/log"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
 )
 
 type TestGitalyServer struct {
 	ReceivedMD metadata.MD
 	pb.UnimplementedSSHServiceServer
+	pb.UnimplementedRefServiceServer
+	pb.UnimplementedRepositoryServiceServer
+}
+
+// TestRefs are the refs listed by ListRefs
+var TestRefs = []*pb.ListRefsResponse_Reference{
+	{Name: []byte("refs/heads/main"), Target: "1e292f8fedd741b75372e19097c76d327140c312"},
+	{Name: []byte("refs/heads/feature/sftp"), Target: "5937ac0a7beb003549fc5fd26fc247adbce4a52e"},
+	{Name: []byte("refs/tags/v1.0.0"), Target: "f4e6814c3e4e7a0de82a9e7cd20c626cc963a2f8", PeeledTarget: "1e292f8fedd741b75372e19097c76d327140c312"},
 }
 
 func (s *TestGitalyServer) SSHReceivePack(stream pb.SSHService_SSHReceivePackServer) error {
@@ -79,6 +90,23 @@ func (s *TestGitalyServer) SSHUploadArchive(stream pb.SSHService_SSHUploadArchiv
 	return stream.Send(&pb.SSHUploadArchiveResponse{Stdout: response})
 }
 
+func (s *TestGitalyServer) ListRefs(req *pb.ListRefsRequest, stream pb.RefService_ListRefsServer) error {
+	s.ReceivedMD, _ = metadata.FromIncomingContext(stream.Context())
+
+	return stream.Send(&pb.ListRefsResponse{References: TestRefs})
+}
+
+// GetArchive returns the request as the archive, split across two messages
+func (s *TestGitalyServer) GetArchive(req *pb.GetArchiveRequest, stream pb.RepositoryService_GetArchiveServer) error {
+	s.ReceivedMD, _ = metadata.FromIncomingContext(stream.Context())
+
+	if err := stream.Send(&pb.GetArchiveResponse{Data: []byte("GetArchive: " + req.Repository.GlRepository)}); err != nil {
+		return err
+	}
+
+	return stream.Send(&pb.GetArchiveResponse{Data: []byte(fmt.Sprintf(" %s %s %s", req.CommitId, req.Prefix, req.Format))})
+}
+
 func StartGitalyServer(t *testing.T, network string) (string, *TestGitalyServer) {
 	t.Helper()
 
@@ -126,6 +154,9 @@ func doStartTestServer(t *testing.T, network string, path string) (string, *Test
 
 	testServer := TestGitalyServer{}
 	pb.RegisterSSHServiceServer(server, &testServer)
+	pb.RegisterRefServiceServer(server, &testServer)
+	pb.RegisterRepositoryServiceServer(server, &testServer)
+	healthpb.RegisterHealthServer(server, health.NewServer())
 
 	go func() {
 		require.NoError(t, server.Serve(listener))
@@ -0,0 +1,143 @@
+package testserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const internalAPIPath = "/api/v4/internal"
+
+// MockResponse is a canned response served for an internal API endpoint
+type MockResponse struct {
+	Status int
+	Body   interface{}
+}
+
+// MockAPI is a running in-process mock of the GitLab internal API, for
+// integration-style tests of code built on top of the client package
+type MockAPI struct {
+	URL string
+
+	mu        sync.Mutex
+	responses map[string]MockResponse
+	requests  map[string]int
+}
+
+// DefaultMockResponses returns successful canned responses for the endpoints
+// most commonly exercised by gitlab-shell: access checks, discovery, the
+// health check and the two-factor endpoints. Paths are relative to
+// /api/v4/internal.
+func DefaultMockResponses() map[string]MockResponse {
+	return map[string]MockResponse{
+		"/allowed": {Body: map[string]interface{}{
+			"status":          true,
+			"gl_id":           "user-1",
+			"gl_username":     "alex-doe",
+			"gl_repository":   "project-1",
+			"gl_project_path": "group/project",
+			"git_protocol":    "version=2",
+			"gitaly": map[string]interface{}{
+				"repository": map[string]interface{}{
+					"storage_name":  "default",
+					"relative_path": "group/project.git",
+				},
+				"address": "unix:gitaly.socket",
+				"token":   "token",
+			},
+		}},
+		"/discover": {Body: map[string]interface{}{
+			"id":       1,
+			"name":     "Alex Doe",
+			"username": "alex-doe",
+		}},
+		"/check": {Body: map[string]interface{}{
+			"api_version":    "v4",
+			"gitlab_version": "v17.0.0",
+			"gitlab_rev":     "c3a5e8f",
+			"redis":          true,
+		}},
+		"/two_factor_recovery_codes": {Body: map[string]interface{}{
+			"success":        true,
+			"recovery_codes": []string{"recovery", "codes"},
+		}},
+		"/two_factor_manual_otp_check": {Body: map[string]interface{}{"success": true}},
+		"/two_factor_push_otp_check":   {Body: map[string]interface{}{"success": true}},
+		"/two_factor_push_otp_cancel":  {Body: map[string]interface{}{}},
+	}
+}
+
+// StartMockAPI runs a mock internal API serving DefaultMockResponses
+// overridden by responses. The server is stopped when the test finishes.
+func StartMockAPI(t *testing.T, responses map[string]MockResponse) *MockAPI {
+	t.Helper()
+
+	s := &MockAPI{
+		responses: DefaultMockResponses(),
+		requests:  make(map[string]int),
+	}
+	for path, response := range responses {
+		s.responses[path] = response
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.serveHTTP(t, w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	s.URL = server.URL
+
+	return s
+}
+
+// Requests returns how many requests were received for path
+func (s *MockAPI) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[path]
+}
+
+// SetResponse replaces the canned response for path
+func (s *MockAPI) SetResponse(path string, response MockResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.responses[path] = response
+}
+
+// serveHTTP runs on the goroutines of the server, where the test can't be
+// stopped: failures are reported with t.Errorf and a 500 to the client
+func (s *MockAPI) serveHTTP(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, internalAPIPath)
+
+	s.mu.Lock()
+	s.requests[path]++
+	response, ok := s.responses[path]
+	s.mu.Unlock()
+
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var body []byte
+	if response.Body != nil {
+		var err error
+		if body, err = json.Marshal(response.Body); err != nil {
+			t.Errorf("mock API: encoding the response for %s: %v", path, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if response.Status != 0 {
+		w.WriteHeader(response.Status)
+	}
+
+	w.Write(body)
+}
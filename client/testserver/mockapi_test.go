@@ -0,0 +1,77 @@
+package testserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+)
+
+func TestDefaultResponses(t *testing.T) {
+	server := StartMockAPI(t, nil)
+	gitlabClient := newGitlabNetClient(t, server)
+
+	response, err := gitlabClient.Post(context.Background(), "/allowed", map[string]string{"action": "git-upload-pack"})
+	require.NoError(t, err)
+
+	var allowed map[string]interface{}
+	decode(t, response, &allowed)
+	require.Equal(t, true, allowed["status"])
+	require.Equal(t, "alex-doe", allowed["gl_username"])
+
+	response, err = gitlabClient.Post(context.Background(), "/two_factor_manual_otp_check", nil)
+	require.NoError(t, err)
+
+	var twoFactor map[string]interface{}
+	decode(t, response, &twoFactor)
+	require.Equal(t, true, twoFactor["success"])
+
+	require.Equal(t, 1, server.Requests("/allowed"))
+	require.Equal(t, 1, server.Requests("/two_factor_manual_otp_check"))
+}
+
+func TestOverriddenResponses(t *testing.T) {
+	server := StartMockAPI(t, map[string]MockResponse{
+		"/allowed": {Status: http.StatusForbidden, Body: map[string]interface{}{"status": false, "message": "Access denied"}},
+	})
+	gitlabClient := newGitlabNetClient(t, server)
+
+	_, err := gitlabClient.Post(context.Background(), "/allowed", nil)
+	require.EqualError(t, err, "Access denied")
+
+	server.SetResponse("/discover", MockResponse{Body: map[string]interface{}{"username": "jane"}})
+
+	response, err := gitlabClient.Get(context.Background(), "/discover?username=jane")
+	require.NoError(t, err)
+
+	var discover map[string]interface{}
+	decode(t, response, &discover)
+	require.Equal(t, "jane", discover["username"])
+}
+
+func TestUnknownEndpoint(t *testing.T) {
+	gitlabClient := newGitlabNetClient(t, StartMockAPI(t, nil))
+
+	_, err := gitlabClient.Get(context.Background(), "/unknown")
+	require.EqualError(t, err, "Internal API error (404)")
+}
+
+func newGitlabNetClient(t *testing.T, server *MockAPI) *client.GitlabNetClient {
+	httpClient, err := client.NewHTTPClientWithOpts(server.URL, "", "", "", 1, nil)
+	require.NoError(t, err)
+
+	gitlabClient, err := client.NewGitlabNetClient("", "", "secret", httpClient)
+	require.NoError(t, err)
+
+	return gitlabClient
+}
+
+func decode(t *testing.T, response *http.Response, v interface{}) {
+	defer response.Body.Close()
+
+	require.NoError(t, json.NewDecoder(response.Body).Decode(v))
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"sync"
+	"time"
+)
+
+var errNoPeerCertificates = errors.New("tls: server presented no certificates")
+
+// verificationCache remembers the leaf certificate that was last successfully
+// verified for the server, so that repeated handshakes with the same
+// certificate can skip building and verifying the chain again. A rotated
+// certificate simply misses the cache and replaces the entry once verified;
+// connections established earlier aren't affected.
+type verificationCache struct {
+	roots      *x509.CertPool
+	serverName string
+
+	mu    sync.Mutex
+	entry *verifiedLeaf
+}
+
+type verifiedLeaf struct {
+	fingerprint [sha256.Size]byte
+	notAfter    time.Time
+}
+
+// newVerificationCache creates a cache verifying certificates against roots
+// for serverName. The name is supplied explicitly because crypto/tls doesn't
+// report one in the connection state when dialing an IP address.
+func newVerificationCache(roots *x509.CertPool, serverName string) *verificationCache {
+	return &verificationCache{
+		roots:      roots,
+		serverName: serverName,
+	}
+}
+
+// verifyConnection is suitable for use as tls.Config.VerifyConnection. It
+// performs the same verification crypto/tls would, unless the presented leaf
+// matches the cached one.
+func (c *verificationCache) verifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errNoPeerCertificates
+	}
+
+	leaf := cs.PeerCertificates[0]
+	fingerprint := sha256.Sum256(leaf.Raw)
+
+	if c.lookup(fingerprint) {
+		return nil
+	}
+
+	if _, err := verifyChain(cs.PeerCertificates, c.roots, c.serverName); err != nil {
+		c.store(nil)
+		return err
+	}
+
+	c.store(&verifiedLeaf{fingerprint: fingerprint, notAfter: leaf.NotAfter})
+
+	return nil
+}
+
+// verifyChain verifies certs the way crypto/tls does, returning the chains
+// built from the leaf to a root. Hostname verification is skipped when
+// dnsName is empty.
+func verifyChain(certs []*x509.Certificate, roots *x509.CertPool, dnsName string) ([][]*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return nil, errNoPeerCertificates
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       dnsName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	return certs[0].Verify(opts)
+}
+
+func (c *verificationCache) lookup(fingerprint [sha256.Size]byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entry == nil {
+		return false
+	}
+
+	if c.entry.fingerprint != fingerprint || time.Now().After(c.entry.notAfter) {
+		// The server presented a different (or expired) certificate, so
+		// whatever we verified previously no longer applies.
+		c.entry = nil
+		return false
+	}
+
+	return true
+}
+
+func (c *verificationCache) store(entry *verifiedLeaf) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entry = entry
+}
+
+// enableVerificationCache replaces the default verification of tlsConfig
+// with a cached one and turns on session resumption.
+func enableVerificationCache(tlsConfig *tls.Config, serverName string) {
+	cache := newVerificationCache(tlsConfig.RootCAs, serverName)
+
+	tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	// Verification is still performed, by the cache in VerifyConnection
+	tlsConfig.InsecureSkipVerify = true // #nosec G402
+	tlsConfig.VerifyConnection = cache.verifyConnection
+}
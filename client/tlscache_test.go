@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerificationCache(t *testing.T) {
+	first := newTestCert(t, "first.example.com")
+	second := newTestCert(t, "second.example.com")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(first.cert)
+	roots.AddCert(second.cert)
+
+	cache := newVerificationCache(roots, "localhost")
+	state := func(cert testCert) tls.ConnectionState {
+		return tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert.cert}}
+	}
+
+	require.NoError(t, cache.verifyConnection(state(first)))
+	require.Equal(t, fingerprintOf(first), cache.entry.fingerprint)
+
+	t.Run("cached leaf skips verification", func(t *testing.T) {
+		cache.roots = x509.NewCertPool()
+		t.Cleanup(func() { cache.roots = roots })
+
+		require.NoError(t, cache.verifyConnection(state(first)))
+	})
+
+	t.Run("changed leaf invalidates the cache", func(t *testing.T) {
+		require.NoError(t, cache.verifyConnection(state(second)))
+		require.Equal(t, fingerprintOf(second), cache.entry.fingerprint)
+	})
+
+	t.Run("untrusted leaf is rejected and clears the cache", func(t *testing.T) {
+		untrusted := newTestCert(t, "untrusted.example.com")
+
+		require.Error(t, cache.verifyConnection(state(untrusted)))
+		require.Nil(t, cache.entry)
+	})
+
+	t.Run("wrong hostname is rejected", func(t *testing.T) {
+		other := newVerificationCache(roots, "gitlab.example.com")
+
+		require.Error(t, other.verifyConnection(state(first)))
+	})
+
+	t.Run("no certificates", func(t *testing.T) {
+		require.ErrorIs(t, cache.verifyConnection(tls.ConnectionState{}), errNoPeerCertificates)
+	})
+}
+
+func TestTLSVerificationCacheOption(t *testing.T) {
+	cert := newTestCert(t, "localhost")
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "Hello")
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert.keyPair}, MinVersion: tls.VersionTLS12}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	caFile := writeTempFile(t, cert.pem)
+
+	client, err := NewHTTPClientWithOpts(server.URL, "", caFile, "", 1, []HTTPClientOpt{WithTLSVerificationCache()})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+		require.NoError(t, err)
+
+		resp, err := client.RetryableHTTP.HTTPClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+}
+
+func BenchmarkVerifyConnection(b *testing.B) {
+	cert := newTestCert(b, "localhost")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.cert)
+	state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert.cert}}
+
+	b.Run("uncached", func(b *testing.B) {
+		opts := x509.VerifyOptions{Roots: roots, DNSName: "localhost"}
+		for i := 0; i < b.N; i++ {
+			if _, err := cert.cert.Verify(opts); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		cache := newVerificationCache(roots, "localhost")
+		for i := 0; i < b.N; i++ {
+			if err := cache.verifyConnection(state); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
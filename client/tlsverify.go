@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+)
+
+// WithoutSNI omits the server name indication from TLS handshakes, for
+// backends reached by IP address that reject server names they don't
+// recognize.
+//
+// Without a server name there is nothing to check the certificate's hostname
+// against, so only its chain is verified: any certificate issued by a trusted
+// CA is accepted. Only use this with a private CA (caFile/caPath), ideally
+// combined with pinning, never with the system trust store alone.
+func WithoutSNI() HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.withoutSNI = true
+	}
+}
+
+// WithTLSServerName sets the server name sent in TLS handshakes and verified
+// against the server certificate, instead of the host of the GitLab URL. This
+// is useful when the URL points at e.g. a VIP whose certificate is issued for
+// another name. Combined with WithoutSNI the name is only used for
+// verification.
+func WithTLSServerName(name string) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.tlsServerName = name
+	}
+}
+
+// configureVerification replaces the default certificate verification of
+// tlsConfig when an option requires it
+func configureVerification(hcc httpClientCfg, tlsConfig *tls.Config, hostname string) {
+	tlsConfig.ServerName = hcc.tlsServerName
+
+	verifyName := hostname
+	switch {
+	case hcc.tlsServerName != "":
+		verifyName = hcc.tlsServerName
+	case hcc.withoutSNI:
+		verifyName = ""
+	}
+
+	switch {
+	case hcc.tlsVerificationCache:
+		enableVerificationCache(tlsConfig, verifyName)
+	case hcc.withoutSNI:
+		roots := tlsConfig.RootCAs
+		// Verification is still performed, without the hostname, in VerifyConnection
+		tlsConfig.InsecureSkipVerify = true // #nosec G402
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			_, err := verifyChain(cs.PeerCertificates, roots, verifyName)
+			return err
+		}
+	}
+}
+
+// dialTLSWithoutSNI returns a DialTLSContext for transport which performs
+// the handshake itself, since http.Transport always sets a server name
+func dialTLSWithoutSNI(transport *http.Transport) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dial := transport.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{}).DialContext
+		}
+
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig := transport.TLSClientConfig.Clone()
+		tlsConfig.ServerName = ""
+
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+
+		return tlsConn, nil
+	}
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startTLSServer starts an HTTPS server presenting cert and records the
+// server names sent by clients
+func startTLSServer(t *testing.T, cert testCert) (string, func() []string) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var serverNames []string
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("Hello"))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert.keyPair},
+		MinVersion:   tls.VersionTLS12,
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			serverNames = append(serverNames, hello.ServerName)
+			return nil, nil
+		},
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	// Dial by name so that a server name would normally be sent
+	url := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+	return url, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]string(nil), serverNames...)
+	}
+}
+
+func get(t *testing.T, client *HTTPClient, url string) error {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+	require.NoError(t, err)
+
+	resp, err := client.RetryableHTTP.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+func TestWithoutSNI(t *testing.T) {
+	// The certificate is not valid for the name we dial
+	cert := newTestCertFor(t, "gitlab.internal", []string{"gitlab.internal"})
+	url, serverNames := startTLSServer(t, cert)
+	caFile := writeTempFile(t, cert.pem)
+
+	t.Run("hostname verification fails by default", func(t *testing.T) {
+		client, err := NewHTTPClientWithOpts(url, "", caFile, "", 1, []HTTPClientOpt{WithHTTPRetryOpts(0, 0, 0)})
+		require.NoError(t, err)
+
+		require.Error(t, get(t, client, url))
+		require.Equal(t, []string{"localhost"}, serverNames())
+	})
+
+	t.Run("no SNI is sent and the chain is verified", func(t *testing.T) {
+		client, err := NewHTTPClientWithOpts(url, "", caFile, "", 1, []HTTPClientOpt{WithoutSNI()})
+		require.NoError(t, err)
+
+		require.NoError(t, get(t, client, url))
+		require.Equal(t, []string{"localhost", ""}, serverNames())
+	})
+
+	t.Run("an untrusted chain is still rejected", func(t *testing.T) {
+		untrusted := writeTempFile(t, newTestCert(t, "other").pem)
+		client, err := NewHTTPClientWithOpts(url, "", untrusted, "", 1, []HTTPClientOpt{WithoutSNI(), WithHTTPRetryOpts(0, 0, 0)})
+		require.NoError(t, err)
+
+		require.Error(t, get(t, client, url))
+	})
+}
+
+func TestWithTLSServerName(t *testing.T) {
+	cert := newTestCertFor(t, "gitlab.internal", []string{"gitlab.internal"})
+	url, serverNames := startTLSServer(t, cert)
+	caFile := writeTempFile(t, cert.pem)
+
+	client, err := NewHTTPClientWithOpts(url, "", caFile, "", 1, []HTTPClientOpt{WithTLSServerName("gitlab.internal")})
+	require.NoError(t, err)
+
+	transport, _, err := buildTransport(httpClientCfg{tlsServerName: "gitlab.internal"}, url, "")
+	require.NoError(t, err)
+	require.Equal(t, "gitlab.internal", transport.TLSClientConfig.ServerName)
+
+	require.NoError(t, get(t, client, url))
+	require.Equal(t, []string{"gitlab.internal"}, serverNames())
+}
+
+func TestWithTLSServerNameWithoutSNI(t *testing.T) {
+	cert := newTestCertFor(t, "gitlab.internal", []string{"gitlab.internal"})
+	url, serverNames := startTLSServer(t, cert)
+	caFile := writeTempFile(t, cert.pem)
+
+	t.Run("verifies against the configured name", func(t *testing.T) {
+		client, err := NewHTTPClientWithOpts(url, "", caFile, "", 1, []HTTPClientOpt{WithTLSServerName("gitlab.internal"), WithoutSNI()})
+		require.NoError(t, err)
+
+		require.NoError(t, get(t, client, url))
+		require.Equal(t, []string{""}, serverNames())
+	})
+
+	t.Run("rejects a certificate for another name", func(t *testing.T) {
+		client, err := NewHTTPClientWithOpts(url, "", caFile, "", 1, []HTTPClientOpt{
+			WithTLSServerName("gitlab.example.com"), WithoutSNI(), WithHTTPRetryOpts(0, 0, 0),
+		})
+		require.NoError(t, err)
+
+		require.Error(t, get(t, client, url))
+	})
+}
@@ -4,13 +4,17 @@ import (
 	"net/http"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+
 	"gitlab.com/gitlab-org/labkit/correlation"
 	"gitlab.com/gitlab-org/labkit/log"
 	"gitlab.com/gitlab-org/labkit/tracing"
 )
 
 type transport struct {
-	next http.RoundTripper
+	next             http.RoundTripper
+	reuseConnections bool
 }
 
 func (rt *transport) RoundTrip(request *http.Request) (*http.Response, error) {
@@ -20,9 +24,12 @@ func (rt *transport) RoundTrip(request *http.Request) (*http.Response, error) {
 	if ok {
 		request.Header.Add("X-Forwarded-For", originalRemoteIP)
 	}
-	request.Close = true
+	request.Close = !rt.reuseConnections
 	request.Header.Add("User-Agent", defaultUserAgent)
 
+	// Propagates the W3C trace context when OpenTelemetry tracing is enabled
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(request.Header))
+
 	start := time.Now()
 
 	response, err := rt.next.RoundTrip(request)
@@ -60,6 +67,12 @@ func DefaultTransport() http.RoundTripper {
 }
 
 func NewTransport(next http.RoundTripper) http.RoundTripper {
-	t := &transport{next: next}
+	return newTransport(next, false)
+}
+
+// newTransport is NewTransport, optionally keeping connections open for reuse
+// rather than closing them after each request
+func newTransport(next http.RoundTripper, reuseConnections bool) http.RoundTripper {
+	t := &transport{next: next, reuseConnections: reuseConnections}
 	return correlation.NewInstrumentedRoundTripper(tracing.NewRoundTripper(t))
 }
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// TransportSettings tunes the connections made by the HttpClient. A zero
+// value leaves the corresponding setting at its default. Connections are
+// closed after each request unless MaxIdleConnsPerHost or IdleConnTimeout is
+// set.
+type TransportSettings struct {
+	// DialTimeout bounds establishing a connection, including to a unix
+	// socket
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout bounds the TLS handshake with an HTTPS server
+	TLSHandshakeTimeout time.Duration
+	// KeepAlive is the interval between TCP keepalive probes. A negative
+	// value disables them.
+	KeepAlive time.Duration
+	// MaxIdleConnsPerHost is the number of idle connections kept for reuse
+	// per host, 2 by default
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept before being
+	// closed. Idle connections are kept indefinitely by default.
+	IdleConnTimeout time.Duration
+}
+
+// WithTransportSettings applies settings to the transport of every GitLab
+// URL. A transport given to WithTransport keeps the values it sets, and its
+// own DialContext.
+func WithTransportSettings(settings TransportSettings) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.transportSettings = settings
+	}
+}
+
+func (ts TransportSettings) reusesConnections() bool {
+	return ts.MaxIdleConnsPerHost > 0 || ts.IdleConnTimeout > 0
+}
+
+func (ts TransportSettings) dialer() *net.Dialer {
+	return &net.Dialer{Timeout: ts.DialTimeout, KeepAlive: ts.KeepAlive}
+}
+
+// apply sets the settings on transport where it leaves them unset
+func (ts TransportSettings) apply(transport *http.Transport) {
+	if transport.DialContext == nil && (ts.DialTimeout != 0 || ts.KeepAlive != 0) {
+		transport.DialContext = ts.dialer().DialContext
+	}
+	if transport.TLSHandshakeTimeout == 0 {
+		transport.TLSHandshakeTimeout = ts.TLSHandshakeTimeout
+	}
+	if transport.MaxIdleConnsPerHost == 0 {
+		transport.MaxIdleConnsPerHost = ts.MaxIdleConnsPerHost
+	}
+	if transport.IdleConnTimeout == 0 {
+		transport.IdleConnTimeout = ts.IdleConnTimeout
+	}
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithTransportSettings(t *testing.T) {
+	settings := TransportSettings{
+		DialTimeout:         time.Second,
+		TLSHandshakeTimeout: 2 * time.Second,
+		KeepAlive:           3 * time.Second,
+		MaxIdleConnsPerHost: 20,
+		IdleConnTimeout:     4 * time.Second,
+	}
+	hcc := httpClientCfg{transportSettings: settings, trustedCAs: &trustedCAs{}}
+
+	for _, gitlabURL := range []string{"http://localhost", "https://localhost", "http+unix:///tmp/gitlab.socket"} {
+		t.Run(gitlabURL, func(t *testing.T) {
+			transport, _, err := buildTransport(hcc, gitlabURL, "")
+			require.NoError(t, err)
+
+			require.NotNil(t, transport.DialContext)
+			require.Equal(t, settings.TLSHandshakeTimeout, transport.TLSHandshakeTimeout)
+			require.Equal(t, settings.MaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+			require.Equal(t, settings.IdleConnTimeout, transport.IdleConnTimeout)
+		})
+	}
+}
+
+func TestTransportSettingsKeepCustomTransportValues(t *testing.T) {
+	hcc := httpClientCfg{
+		transportSettings: TransportSettings{MaxIdleConnsPerHost: 20, IdleConnTimeout: time.Second},
+		transport:         &http.Transport{MaxIdleConnsPerHost: 5},
+	}
+
+	transport, _, err := buildTransport(hcc, "http://localhost", "")
+	require.NoError(t, err)
+
+	require.Equal(t, 5, transport.MaxIdleConnsPerHost)
+	require.Equal(t, time.Second, transport.IdleConnTimeout)
+}
+
+func TestIdleConnTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("Hello"))
+	}))
+	t.Cleanup(server.Close)
+
+	opts := []HTTPClientOpt{WithTransportSettings(TransportSettings{IdleConnTimeout: 100 * time.Millisecond})}
+	client, err := NewHTTPClientWithOpts(server.URL, "", "", "", 0, opts)
+	require.NoError(t, err)
+
+	reused := func() bool {
+		var reused bool
+		trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused }}
+
+		req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodGet, server.URL, nil)
+		require.NoError(t, err)
+
+		resp, err := client.RetryableHTTP.HTTPClient.Do(req)
+		require.NoError(t, err)
+		_, err = io.Copy(io.Discard, resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		return reused
+	}
+
+	require.False(t, reused())
+	require.True(t, reused(), "connections are reused while idle for less than IdleConnTimeout")
+
+	time.Sleep(300 * time.Millisecond)
+	require.False(t, reused(), "connections idle for longer than IdleConnTimeout are closed")
+}
+
+func TestConnectionsClosedByDefault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.True(t, r.Close)
+		w.Write([]byte("Hello"))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewHTTPClientWithOpts(server.URL, "", "", "", 0, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, get(t, client, server.URL))
+}
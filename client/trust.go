@@ -0,0 +1,28 @@
+package client
+
+import "crypto/x509"
+
+// CertPool returns a copy of the pool of CAs the client trusts for HTTPS
+// connections: the system roots along with those from caFile, caPath and
+// WithCACertPEM. It is nil when no GitLab URL uses HTTPS. Being a copy,
+// changing it doesn't affect the client.
+func (c *HTTPClient) CertPool() *x509.CertPool {
+	if c.trustedCAs == nil {
+		return nil
+	}
+
+	return c.trustedCAs.pool.Clone()
+}
+
+// TrustedCertSubjects returns the DER encoded subjects of the CAs added on
+// top of the system roots, from caFile, caPath and WithCACertPEM. The system
+// roots themselves can't be listed on every platform and are left out; use
+// CertPool to verify certificates against the complete pool.
+func (c *HTTPClient) TrustedCertSubjects() [][]byte {
+	if c.trustedCAs == nil {
+		return nil
+	}
+
+	// Subjects is only deprecated for pools holding the system roots
+	return c.trustedCAs.custom.Subjects() //nolint:staticcheck
+}
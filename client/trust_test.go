@@ -0,0 +1,43 @@
+package client
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedCertSubjects(t *testing.T) {
+	fileCert := newTestCert(t, "file-ca")
+	pemCert := newTestCert(t, "pem-ca")
+
+	client, err := NewHTTPClientWithOpts("https://localhost", "", writeTempFile(t, fileCert.pem), "", 1, []HTTPClientOpt{
+		WithCACertPEM(pemCert.pem),
+	})
+	require.NoError(t, err)
+
+	require.ElementsMatch(t, [][]byte{fileCert.cert.RawSubject, pemCert.cert.RawSubject}, client.TrustedCertSubjects())
+}
+
+func TestCertPool(t *testing.T) {
+	cert := newTestCert(t, "localhost")
+
+	client, err := NewHTTPClientWithOpts("https://localhost", "", "", "", 1, []HTTPClientOpt{WithCACertPEM(cert.pem)})
+	require.NoError(t, err)
+
+	pool := client.CertPool()
+	_, err = cert.cert.Verify(x509.VerifyOptions{Roots: pool, DNSName: "localhost"})
+	require.NoError(t, err)
+
+	// Changing the copy doesn't affect the client
+	pool.AddCert(newTestCert(t, "other").cert)
+	require.Len(t, client.TrustedCertSubjects(), 1)
+}
+
+func TestCertPoolWithoutHTTPS(t *testing.T) {
+	client, err := NewHTTPClientWithOpts("http://localhost", "", "", "", 1, nil)
+	require.NoError(t, err)
+
+	require.Nil(t, client.CertPool())
+	require.Empty(t, client.TrustedCertSubjects())
+}
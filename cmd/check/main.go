@@ -1,12 +1,23 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"net"
 	"os"
+	"os/signal"
+	"syscall"
+
+	"gitlab.com/gitlab-org/labkit/log"
+	"gitlab.com/gitlab-org/labkit/monitoring"
 
 	checkCmd "gitlab.com/gitlab-org/gitlab-shell/v14/cmd/check/command"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/configcheck"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/healthcheck"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/sshdconfig"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/executable"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
@@ -34,6 +45,31 @@ func main() {
 		os.Exit(1)
 	}
 
+	// "gitlab-shell-check config" only checks the config file, which may
+	// not be readable otherwise
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		cmd := &configcheck.Command{RootDir: executable.RootDir, ReadWriter: readWriter}
+		if _, err := cmd.Execute(context.Background()); err != nil {
+			fmt.Fprintf(readWriter.ErrOut, "%v\n", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	// "gitlab-shell-check sshd-config" prints the sshd_config of OpenSSH
+	// matching the config, and "gitlab-shell-check sshd-config -import FILE"
+	// the config matching a sshd_config, to move between OpenSSH and
+	// gitlab-sshd
+	if len(os.Args) > 1 && os.Args[1] == "sshd-config" {
+		if err := sshdConfig(executable.RootDir, readWriter, os.Args[2:]); err != nil {
+			fmt.Fprintf(readWriter.ErrOut, "%v\n", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	config, err := config.NewFromDirExternal(executable.RootDir)
 	if err != nil {
 		fmt.Fprintln(readWriter.ErrOut, "Failed to read config, exiting")
@@ -52,8 +88,67 @@ func main() {
 	ctx, finished := command.Setup(executable.Name, config)
 	defer finished()
 
+	// "gitlab-shell-check watch" runs the checks in a loop, as a sidecar
+	// reporting the health of gitlab-shell
+	if len(os.Args) > 1 && os.Args[1] == "watch" {
+		if err := watch(ctx, config); err != nil {
+			fmt.Fprintf(readWriter.ErrOut, "%v\n", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	if ctx, err = cmd.Execute(ctx); err != nil {
 		fmt.Fprintf(readWriter.ErrOut, "%v\n", err)
 		os.Exit(1)
 	}
 }
+
+func sshdConfig(rootDir string, readWriter *readwriter.ReadWriter, args []string) error {
+	flags := flag.NewFlagSet("sshd-config", flag.ContinueOnError)
+	flags.SetOutput(readWriter.ErrOut)
+	importPath := flags.String("import", "", "The sshd_config to import the settings of")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var cmd command.Command = &sshdconfig.ExportCommand{RootDir: rootDir, ReadWriter: readWriter}
+	if *importPath != "" {
+		cmd = &sshdconfig.ImportCommand{Path: *importPath, ReadWriter: readWriter}
+	}
+
+	_, err := cmd.Execute(context.Background())
+
+	return err
+}
+
+// watch runs the checks until SIGINT or SIGTERM, serving their metrics on
+// the web_listen address of self_check
+func watch(ctx context.Context, cfg *config.Config) error {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	cfg.GitalyClient.InitSidechannelRegistry(ctx)
+	defer cfg.GitalyClient.Close()
+
+	if address := cfg.SelfCheck.WebListen; address != "" {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return fmt.Errorf("failed to listen for monitoring requests: %w", err)
+		}
+
+		go func() {
+			err := monitoring.Start(
+				monitoring.WithListener(listener),
+				monitoring.WithBuildInformation(Version, BuildTime),
+			)
+
+			log.WithError(err).Fatal("monitoring service raised an error")
+		}()
+	}
+
+	_, err := (&healthcheck.WatchCommand{Config: cfg}).Execute(ctx)
+
+	return err
+}
@@ -60,6 +60,12 @@ func TestParseSuccess(t *testing.T) {
 			arguments:    []string{"git", "git", "key"},
 			expectedArgs: &commandargs.AuthorizedKeys{Arguments: []string{"git", "git", "key"}, ExpectedUser: "git", ActualUser: "git", Key: "key"},
 		},
+		{
+			desc:         "It parses authorized-keys command in batch mode",
+			executable:   &executable.Executable{Name: executable.AuthorizedKeysCheck},
+			arguments:    []string{"--batch", "git", "git"},
+			expectedArgs: &commandargs.AuthorizedKeys{Arguments: []string{"--batch", "git", "git"}, ExpectedUser: "git", ActualUser: "git", Batch: true},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -88,13 +94,13 @@ func TestParseFailure(t *testing.T) {
 			desc:          "With not enough arguments for the AuthorizedKeysCheck",
 			executable:    &executable.Executable{Name: executable.AuthorizedKeysCheck},
 			arguments:     []string{"user"},
-			expectedError: "# Insufficient arguments. 1. Usage\n#\tgitlab-shell-authorized-keys-check <expected-username> <actual-username> <key>",
+			expectedError: "# Insufficient arguments. 1. Usage\n#\tgitlab-shell-authorized-keys-check <expected-username> <actual-username> <key|fingerprint>",
 		},
 		{
 			desc:          "With too many arguments for the AuthorizedKeysCheck",
 			executable:    &executable.Executable{Name: executable.AuthorizedKeysCheck},
 			arguments:     []string{"user", "user", "key", "something-else"},
-			expectedError: "# Insufficient arguments. 4. Usage\n#\tgitlab-shell-authorized-keys-check <expected-username> <actual-username> <key>",
+			expectedError: "# Insufficient arguments. 4. Usage\n#\tgitlab-shell-authorized-keys-check <expected-username> <actual-username> <key|fingerprint>",
 		},
 		{
 			desc:          "With missing username for the AuthorizedKeysCheck",
@@ -108,6 +114,18 @@ func TestParseFailure(t *testing.T) {
 			arguments:     []string{"user", "user", ""},
 			expectedError: "# No key provided",
 		},
+		{
+			desc:          "With a key in batch mode",
+			executable:    &executable.Executable{Name: executable.AuthorizedKeysCheck},
+			arguments:     []string{"--batch", "user", "user", "key"},
+			expectedError: "# Insufficient arguments. 4. Usage\n#\tgitlab-shell-authorized-keys-check --batch <expected-username> <actual-username> < keys",
+		},
+		{
+			desc:          "With missing username in batch mode",
+			executable:    &executable.Executable{Name: executable.AuthorizedKeysCheck},
+			arguments:     []string{"--batch", "", "user"},
+			expectedError: "# No username provided",
+		},
 	}
 
 	for _, tc := range testCases {
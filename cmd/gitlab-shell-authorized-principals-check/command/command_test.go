@@ -60,6 +60,12 @@ func TestParseSuccess(t *testing.T) {
 			arguments:    []string{"key", "principal-1", "principal-2"},
 			expectedArgs: &commandargs.AuthorizedPrincipals{Arguments: []string{"key", "principal-1", "principal-2"}, KeyId: "key", Principals: []string{"principal-1", "principal-2"}},
 		},
+		{
+			desc:         "It parses the certificate of authorized-principals command",
+			executable:   &executable.Executable{Name: executable.AuthorizedPrincipalsCheck},
+			arguments:    []string{"--certificate", "AAAA", "key", "group:backend/*"},
+			expectedArgs: &commandargs.AuthorizedPrincipals{Arguments: []string{"--certificate", "AAAA", "key", "group:backend/*"}, KeyId: "key", Principals: []string{"group:backend/*"}, Certificate: "AAAA"},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -96,6 +102,12 @@ func TestParseFailure(t *testing.T) {
 			arguments:     []string{"", "principal"},
 			expectedError: "# No key_id provided",
 		},
+		{
+			desc:          "With a missing certificate for the AuthorizedPrincipalsCheck",
+			executable:    &executable.Executable{Name: executable.AuthorizedPrincipalsCheck},
+			arguments:     []string{"--certificate"},
+			expectedError: "# No certificate provided",
+		},
 		{
 			desc:          "With blank principal for the AuthorizedPrincipalsCheck",
 			executable:    &executable.Executable{Name: executable.AuthorizedPrincipalsCheck},
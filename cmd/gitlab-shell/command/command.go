@@ -5,11 +5,13 @@ import (
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/discover"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/dryrun"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/lfsauthenticate"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/lfstransfer"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/personalaccesstoken"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/receivepack"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/commandpolicy"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disallowedcommand"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/twofactorrecover"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/twofactorverify"
@@ -33,6 +35,17 @@ func New(arguments []string, env sshenv.Env, config *config.Config, readWriter *
 	return nil, disallowedcommand.Error
 }
 
+// NewDryRun creates a command reporting what the command of the provided
+// arguments and environment would do, without doing it
+func NewDryRun(arguments []string, env sshenv.Env, config *config.Config, readWriter *readwriter.ReadWriter) (command.Command, error) {
+	args, err := Parse(arguments, env)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dryrun.Command{Config: config, Args: args, ReadWriter: readWriter}, nil
+}
+
 // NewWithKey creates a new command with the provided key ID
 func NewWithKey(gitlabKeyID string, env sshenv.Env, config *config.Config, readWriter *readwriter.ReadWriter) (command.Command, error) {
 	args, err := Parse(nil, env)
@@ -107,6 +120,15 @@ func Parse(arguments []string, env sshenv.Env) (*commandargs.Shell, error) {
 
 // Build constructs a command based on the provided arguments, config, and readWriter
 func Build(args *commandargs.Shell, config *config.Config, readWriter *readwriter.ReadWriter) command.Command {
+	cmd := build(args, config, readWriter)
+	if cmd == nil || config == nil || !commandpolicy.Configured(config.CommandPolicy) {
+		return cmd
+	}
+
+	return &commandpolicy.Command{Command: cmd, Config: config, Args: args}
+}
+
+func build(args *commandargs.Shell, config *config.Config, readWriter *readwriter.ReadWriter) command.Command {
 	switch args.CommandType {
 	case commandargs.Discover:
 		return &discover.Command{Config: config, Args: args, ReadWriter: readWriter}
@@ -12,6 +12,7 @@ import (
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/lfstransfer"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/personalaccesstoken"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/receivepack"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/commandpolicy"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disallowedcommand"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/twofactorrecover"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/twofactorverify"
@@ -184,6 +185,19 @@ func TestPATCommands(t *testing.T) {
 	}
 }
 
+func TestCommandPolicy(t *testing.T) {
+	cfg := &config.Config{
+		GitlabUrl:     "http+unix://gitlab.socket",
+		PATConfig:     config.PATConfig{Enabled: true},
+		CommandPolicy: config.CommandPolicyConfig{DenyTokenCommands: true},
+	}
+
+	command, err := cmd.New([]string{}, buildEnv("personal_access_token"), cfg, nil)
+	require.NoError(t, err)
+	require.IsType(t, &commandpolicy.Command{}, command)
+	require.IsType(t, &personalaccesstoken.Command{}, command.(*commandpolicy.Command).Command)
+}
+
 func TestFailingNew(t *testing.T) {
 	testCases := []struct {
 		desc          string
@@ -341,6 +355,41 @@ func TestParseSuccess(t *testing.T) {
 			arguments:    []string{},
 			expectedArgs: &commandargs.Shell{Arguments: []string{}, SshArgs: []string{"git-lfs-transfer", "group/repo", "download"}, CommandType: commandargs.LfsTransfer, Env: sshenv.Env{IsSSHConnection: true, OriginalCommand: "git-lfs-transfer 'group/repo' download"}},
 		},
+		{
+			desc:         "It parses an unquoted repository with a .git suffix",
+			executable:   &executable.Executable{Name: executable.GitlabShell},
+			env:          sshenv.Env{IsSSHConnection: true, OriginalCommand: `git-upload-pack user/repo.git`},
+			arguments:    []string{},
+			expectedArgs: &commandargs.Shell{Arguments: []string{}, SshArgs: []string{"git-upload-pack", "user/repo.git"}, CommandType: commandargs.UploadPack, Env: sshenv.Env{IsSSHConnection: true, OriginalCommand: `git-upload-pack user/repo.git`}},
+		},
+		{
+			desc:         "It joins an unquoted repository with spaces",
+			executable:   &executable.Executable{Name: executable.GitlabShell},
+			env:          sshenv.Env{IsSSHConnection: true, OriginalCommand: `git-receive-pack my group/my repo.git`},
+			arguments:    []string{},
+			expectedArgs: &commandargs.Shell{Arguments: []string{}, SshArgs: []string{"git-receive-pack", "my group/my repo.git"}, CommandType: commandargs.ReceivePack, Env: sshenv.Env{IsSSHConnection: true, OriginalCommand: `git-receive-pack my group/my repo.git`}},
+		},
+		{
+			desc:         "It keeps a quoted repository with spaces",
+			executable:   &executable.Executable{Name: executable.GitlabShell},
+			env:          sshenv.Env{IsSSHConnection: true, OriginalCommand: `git upload-archive 'my group/my repo'`},
+			arguments:    []string{},
+			expectedArgs: &commandargs.Shell{Arguments: []string{}, SshArgs: []string{"git-upload-archive", "my group/my repo"}, CommandType: commandargs.UploadArchive, Env: sshenv.Env{IsSSHConnection: true, OriginalCommand: `git upload-archive 'my group/my repo'`}},
+		},
+		{
+			desc:         "It decodes a percent-encoded repository",
+			executable:   &executable.Executable{Name: executable.GitlabShell},
+			env:          sshenv.Env{IsSSHConnection: true, OriginalCommand: `git-upload-pack '/my%20group/r%C3%A9po.git'`},
+			arguments:    []string{},
+			expectedArgs: &commandargs.Shell{Arguments: []string{}, SshArgs: []string{"git-upload-pack", "/my group/répo.git"}, CommandType: commandargs.UploadPack, Env: sshenv.Env{IsSSHConnection: true, OriginalCommand: `git-upload-pack '/my%20group/r%C3%A9po.git'`}},
+		},
+		{
+			desc:         "It decodes a percent-encoded LFS repository",
+			executable:   &executable.Executable{Name: executable.GitlabShell},
+			env:          sshenv.Env{IsSSHConnection: true, OriginalCommand: `git-lfs-transfer my%20group/repo upload`},
+			arguments:    []string{},
+			expectedArgs: &commandargs.Shell{Arguments: []string{}, SshArgs: []string{"git-lfs-transfer", "my group/repo", "upload"}, CommandType: commandargs.LfsTransfer, Env: sshenv.Env{IsSSHConnection: true, OriginalCommand: `git-lfs-transfer my%20group/repo upload`}},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -378,6 +427,13 @@ func TestParseFailure(t *testing.T) {
 			arguments:     []string{},
 			expectedError: "Invalid SSH command: invalid command line string",
 		},
+		{
+			desc:          "It fails if the repository is badly percent-encoded",
+			executable:    &executable.Executable{Name: executable.GitlabShell},
+			env:           sshenv.Env{IsSSHConnection: true, OriginalCommand: "git-upload-pack group/repo%zz"},
+			arguments:     []string{},
+			expectedError: `Invalid SSH command: invalid repository path: invalid URL escape "%zz"`,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"reflect"
+	"syscall"
 
 	grpccodes "google.golang.org/grpc/codes"
 	grpcstatus "google.golang.org/grpc/status"
@@ -11,13 +14,19 @@ import (
 	"gitlab.com/gitlab-org/labkit/fips"
 	"gitlab.com/gitlab-org/labkit/log"
 
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
 	shellCmd "gitlab.com/gitlab-org/gitlab-shell/v14/cmd/gitlab-shell/command"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/dryrun"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/errormessage"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/language"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/console"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/executable"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/i18n"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sessionrecord"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
 )
 
@@ -52,8 +61,19 @@ func main() {
 	logCloser := logger.Configure(config)
 	defer logCloser.Close()
 
-	env := sshenv.NewFromEnv()
-	cmd, err := shellCmd.New(os.Args[1:], env, config, readWriter)
+	var envOpts []sshenv.Opt
+	if config.ProxyRemoteAddrEnv != "" {
+		envOpts = append(envOpts, sshenv.WithProxyRemoteAddr(config.ProxyRemoteAddrEnv))
+	}
+	env := sshenv.NewFromEnv(envOpts...)
+	arguments, dryRun := dryrun.Enabled(os.Args[1:], os.Getenv)
+
+	var cmd command.Command
+	if dryRun {
+		cmd, err = shellCmd.NewDryRun(arguments, env, config, readWriter)
+	} else {
+		cmd, err = shellCmd.New(arguments, env, config, readWriter)
+	}
 	if err != nil {
 		// For now this could happen if `SSH_CONNECTION` is not set on
 		// the environment
@@ -64,17 +84,53 @@ func main() {
 	ctx, finished := command.Setup(executable.Name, config)
 	defer finished()
 
+	// The process serves a single SSH connection
+	ctx = client.WithRetryBudget(ctx, config.NewRetryBudget())
+
+	// Interrupting the command, e.g. with Ctrl-C, cancels its context for it
+	// to clean up, such as a pending two-factor push notification. A second
+	// signal terminates right away.
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	// The RPCs to Gitaly are given the time the client waits for the command,
+	// for them not to carry on once it gave up
+	if env.ClientTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, env.ClientTimeout)
+		defer cancel()
+	}
+
+	logger.AddContextFields(ctx, log.Fields{"remote_ip": env.RemoteAddr, "remote_ip_source": env.RemoteAddrSource})
+	ctx = i18n.NewContext(ctx, language.Select(config, env, ""))
+
 	config.GitalyClient.InitSidechannelRegistry(ctx)
 
+	recorder, err := sessionrecord.Start(ctx, config, cmd, readWriter)
+	if err != nil {
+		log.ContextLogger(ctx).WithError(err).Error("gitlab-shell: main: failed to start the session recording")
+		fmt.Fprintln(readWriter.ErrOut, "Failed to record the session, exiting")
+		os.Exit(1)
+	}
+
 	cmdName := reflect.TypeOf(cmd).String()
 	ctxlog := log.ContextLogger(ctx)
 	ctxlog.WithFields(log.Fields{"env": env, "command": cmdName}).Info("gitlab-shell: main: executing command")
 	fips.Check()
 
-	if _, err := cmd.Execute(ctx); err != nil {
+	_, err = cmd.Execute(ctx)
+	if closeErr := recorder.Close(); closeErr != nil {
+		ctxlog.WithError(closeErr).Error("gitlab-shell: main: failed to record the session")
+	}
+
+	if err != nil {
 		ctxlog.WithError(err).Warn("gitlab-shell: main: command execution failed")
 		if grpcstatus.Convert(err).Code() != grpccodes.Internal {
-			console.DisplayWarningMessage(err.Error(), readWriter.ErrOut)
+			console.DisplayWarningMessages(errormessage.Messages(ctx, config, err, err.Error()), readWriter.ErrOut)
 		}
 		os.Exit(1)
 	}
@@ -354,13 +354,19 @@ func TestDiscoverSuccess(t *testing.T) {
 }
 
 func TestPersonalAccessTokenSuccess(t *testing.T) {
+	policyHandler := customHandler{
+		url: "/api/v4/internal/personal_access_token/policy",
+		caller: func(w http.ResponseWriter, _ *http.Request) {
+			fmt.Fprint(w, `{"success": true, "scopes": ["api", "read_api"], "max_lifetime_days": 365}`)
+		},
+	}
 	handler := customHandler{
 		url: "/api/v4/internal/personal_access_token",
 		caller: func(w http.ResponseWriter, _ *http.Request) {
 			fmt.Fprint(w, `{"success": true, "token": "testtoken", "scopes": ["api"], "expires_at": "9001-01-01"}`)
 		},
 	}
-	client := runSSHD(t, successAPI(t, handler))
+	client := runSSHD(t, successAPI(t, policyHandler, handler))
 
 	session, err := client.NewSession()
 	require.NoError(t, err)
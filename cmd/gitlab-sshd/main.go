@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -18,7 +20,8 @@ import (
 )
 
 var (
-	configDir = flag.String("config-dir", "", "The directory the config is in")
+	configDir    = flag.String("config-dir", "", "The directory the config is in")
+	strictConfig = flag.Bool("strict-config", false, "Refuse configurations with unknown keys or unusable files")
 
 	// Version is the current version of gitlab-shell
 	Version = "(unknown version)" // Set at build time in the Makefile
@@ -29,49 +32,135 @@ var (
 func overrideConfigFromEnvironment(cfg *config.Config) {
 	if gitlabUrl := os.Getenv("GITLAB_URL"); gitlabUrl != "" {
 		cfg.GitlabUrl = gitlabUrl
+		cfg.GitlabUrls = nil
 	}
 	if gitlabTracing := os.Getenv("GITLAB_TRACING"); gitlabTracing != "" {
 		cfg.GitlabTracing = gitlabTracing
 	}
 	if gitlabShellSecret := os.Getenv("GITLAB_SHELL_SECRET"); gitlabShellSecret != "" {
 		cfg.Secret = gitlabShellSecret
+		cfg.SecretSource = config.SecretSourceConfig{}
 	}
 	if gitlabLogFormat := os.Getenv("GITLAB_LOG_FORMAT"); gitlabLogFormat != "" {
 		cfg.LogFormat = gitlabLogFormat
 	}
 }
 
-func main() {
-	command.CheckForVersionFlag(os.Args, Version, BuildTime)
-
-	flag.Parse()
-
+// loadConfig reads the configuration from the config dir, if any, and the
+// environment. It returns the problems found in the config file, which are
+// errors with -strict-config.
+func loadConfig() (*config.Config, []string, error) {
 	cfg := new(config.Config)
+	var problems []string
 	if *configDir != "" {
 		var err error
 		cfg, err = config.NewFromDir(*configDir)
 		if err != nil {
-			log.WithError(err).Fatal("failed to load configuration from specified directory")
+			return nil, nil, fmt.Errorf("failed to load configuration from specified directory: %w", err)
+		}
+
+		if problems, err = cfg.Lint(); err != nil {
+			return nil, nil, fmt.Errorf("failed to check configuration: %w", err)
+		}
+
+		if *strictConfig && len(problems) > 0 {
+			return nil, nil, fmt.Errorf("configuration error: %s", strings.Join(problems, "; "))
 		}
 	}
 
 	overrideConfigFromEnvironment(cfg)
 	if err := cfg.IsSane(); err != nil {
 		if *configDir == "" {
-			log.WithError(err).Fatal("no config-dir provided, using only environment variables")
-		} else {
-			log.WithError(err).Fatal("configuration error")
+			return nil, nil, fmt.Errorf("no config-dir provided, using only environment variables: %w", err)
 		}
+
+		return nil, nil, fmt.Errorf("configuration error: %w", err)
 	}
 
 	cfg.ApplyGlobalState()
 
+	return cfg, problems, nil
+}
+
+func warnConfigProblems(ctx context.Context, problems []string) {
+	for _, problem := range problems {
+		log.WithContextFields(ctx, log.Fields{"problem": problem}).Warn("Configuration problem, use -strict-config to refuse it")
+	}
+}
+
+// reloadOnSignal reloads the configuration of server on every SIGHUP
+func reloadOnSignal(ctx context.Context, server *sshd.Server) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		log.WithContextFields(ctx, log.Fields{"config_dir": *configDir}).Info("Reloading configuration")
+
+		cfg, problems, err := loadConfig()
+		if err == nil {
+			warnConfigProblems(ctx, problems)
+			cfg.GitalyClient.InitSidechannelRegistry(ctx)
+			err = server.Reload(cfg)
+		}
+
+		if err != nil {
+			log.ContextLogger(ctx).WithError(err).Error("Failed to reload configuration, keeping the current one")
+			continue
+		}
+
+		log.ContextLogger(ctx).Info("Configuration reloaded")
+	}
+}
+
+// toggleDebugOnSignal switches the log level to debug on every SIGUSR1, or
+// back to the configured one
+func toggleDebugOnSignal(ctx context.Context) {
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+
+	for range usr1 {
+		level := logger.ToggleDebug()
+		log.WithContextFields(ctx, log.Fields{"level": level}).Info("Log level changed")
+	}
+}
+
+// upgradeOnSignal hands the sockets of server over to a new process on every
+// SIGUSR2, and shuts server down through done once the new process serves
+func upgradeOnSignal(ctx context.Context, server *sshd.Server, done chan<- os.Signal) {
+	usr2 := make(chan os.Signal, 1)
+	signal.Notify(usr2, syscall.SIGUSR2)
+
+	for sig := range usr2 {
+		log.ContextLogger(ctx).Info("Upgrading to a new process")
+
+		if err := server.Upgrade(ctx); err != nil {
+			log.ContextLogger(ctx).WithError(err).Error("Failed to upgrade, carrying on")
+			continue
+		}
+
+		done <- sig
+		return
+	}
+}
+
+func main() {
+	command.CheckForVersionFlag(os.Args, Version, BuildTime)
+
+	flag.Parse()
+
+	cfg, problems, err := loadConfig()
+	if err != nil {
+		log.WithError(err).Fatal("failed to load configuration")
+	}
+
 	logCloser := logger.ConfigureStandalone(cfg)
 	defer logCloser.Close()
 
 	ctx, finished := command.Setup("gitlab-sshd", cfg)
 	defer finished()
 
+	warnConfigProblems(ctx, problems)
+
 	cfg.GitalyClient.InitSidechannelRegistry(ctx)
 
 	server, err := sshd.NewServer(cfg)
@@ -81,9 +170,14 @@ func main() {
 
 	// Startup monitoring endpoint.
 	if cfg.Server.WebListen != "" {
+		listener, err := server.ListenMonitoring()
+		if err != nil {
+			log.WithError(err).Fatal("Failed to listen for monitoring requests")
+		}
+
 		go func() {
 			err := monitoring.Start(
-				monitoring.WithListenerAddress(cfg.Server.WebListen),
+				monitoring.WithListener(listener),
 				monitoring.WithBuildInformation(Version, BuildTime),
 				monitoring.WithServeMux(server.MonitoringServeMux()),
 			)
@@ -95,8 +189,20 @@ func main() {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	go reloadOnSignal(ctx, server)
+	go toggleDebugOnSignal(ctx)
+
+	if cfg.Server.DebugListen != "" {
+		go func() {
+			if err := server.ServeDebug(ctx); err != nil {
+				log.WithError(err).Fatal("debug service raised an error")
+			}
+		}()
+	}
+
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, syscall.SIGINT, syscall.SIGTERM)
+	go upgradeOnSignal(ctx, server, done)
 
 	go func() {
 		sig := <-done
@@ -105,9 +211,7 @@ func main() {
 		gracePeriod := time.Duration(cfg.Server.GracePeriod)
 		log.WithContextFields(ctx, log.Fields{"shutdown_timeout_s": gracePeriod.Seconds(), "signal": sig.String()}).Info("Shutdown initiated")
 
-		server.Shutdown()
-
-		<-time.After(gracePeriod)
+		server.Drain(ctx, gracePeriod)
 
 		cancel()
 	}()
@@ -0,0 +1,198 @@
+// Package auditlog provides a structured audit log of SSH sessions, separate
+// from operational logs. Records are chained by hash so that a removed or
+// altered record can be detected with Verify.
+package auditlog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+)
+
+const (
+	// bufferSize is the number of records queued while the sink is slow
+	// before further records are dropped
+	bufferSize = 1024
+	// closeTimeout bounds how long Close waits for queued records to be written
+	closeTimeout = 5 * time.Second
+)
+
+// Results of a session
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Record is the audit record written, as a JSON line, for each SSH session
+type Record struct {
+	// Sequence numbers the records of a log from 1, without gaps
+	Sequence   uint64    `json:"sequence"`
+	Time       time.Time `json:"time"`
+	Username   string    `json:"username,omitempty"`
+	KeyID      string    `json:"key_id,omitempty"`
+	RemoteIP   string    `json:"remote_ip"`
+	Command    string    `json:"command"`
+	Repository string    `json:"repository,omitempty"`
+	BytesIn    int64     `json:"bytes_in"`
+	BytesOut   int64     `json:"bytes_out"`
+	DurationS  float64   `json:"duration_s"`
+	Result     string    `json:"result"`
+	ExitStatus uint32    `json:"exit_status"`
+	// PrevHash is the Hash of the previous record, empty for the first one
+	PrevHash string `json:"prev_hash"`
+	// Hash is the SHA256 of the record encoded without it
+	Hash string `json:"hash,omitempty"`
+}
+
+// NewRecord derives a Record from env. Only the verb of the command and the
+// repository it operates on are recorded, not the complete command.
+func NewRecord(env sshenv.Env) Record {
+	record := Record{
+		Time:     time.Now().UTC(),
+		RemoteIP: env.RemoteAddr,
+	}
+
+	if host, _, err := net.SplitHostPort(env.RemoteAddr); err == nil {
+		record.RemoteIP = host
+	}
+
+	if gc, err := env.GitCommand(); err == nil {
+		record.Command = gc.Verb
+		record.Repository = gc.RepoPath
+	} else if args, err := env.CommandArgs(); err == nil && len(args) > 0 {
+		record.Command = args[0]
+	}
+
+	return record
+}
+
+// seal sets the hash of record and returns it encoded as a JSON line
+func seal(record Record) (Record, []byte, error) {
+	record.Hash = ""
+	unsealed, err := json.Marshal(record)
+	if err != nil {
+		return record, nil, err
+	}
+
+	sum := sha256.Sum256(unsealed)
+	record.Hash = hex.EncodeToString(sum[:])
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return record, nil, err
+	}
+
+	return record, append(line, '\n'), nil
+}
+
+// Logger writes audit records to a sink in the background, so that a slow
+// or stuck sink can't stall SSH sessions. Records that don't fit in the buffer
+// or fail to be written are dropped and counted, and left out of the chain.
+type Logger struct {
+	w       io.WriteCloser
+	records chan Record
+	done    chan struct{}
+	dropped atomic.Int64
+
+	// The end of the chain, only accessed by run
+	sequence uint64
+	prevHash string
+
+	closeOnce sync.Once
+}
+
+// New returns a Logger writing to w, which it takes ownership of
+func New(w io.WriteCloser) *Logger {
+	return newLogger(w, nil)
+}
+
+// newLogger returns a Logger whose chain carries on from last, the last
+// record already in the sink
+func newLogger(w io.WriteCloser, last *Record) *Logger {
+	l := &Logger{
+		w:       w,
+		records: make(chan Record, bufferSize),
+		done:    make(chan struct{}),
+	}
+
+	if last != nil {
+		l.sequence = last.Sequence
+		l.prevHash = last.Hash
+	}
+
+	go l.run()
+
+	return l
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+
+	for record := range l.records {
+		record.Sequence = l.sequence + 1
+		record.PrevHash = l.prevHash
+
+		sealed, line, err := seal(record)
+		if err != nil {
+			l.dropped.Add(1)
+			continue
+		}
+
+		if _, err := l.w.Write(line); err != nil {
+			l.dropped.Add(1)
+			continue
+		}
+
+		l.sequence = sealed.Sequence
+		l.prevHash = sealed.Hash
+	}
+}
+
+// Write queues record for writing without blocking. Its Sequence and hashes
+// are set when it's written. It is a no-op on a nil Logger, so callers needn't
+// check whether auditing is enabled.
+func (l *Logger) Write(record Record) {
+	if l == nil {
+		return
+	}
+
+	select {
+	case l.records <- record:
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of records that couldn't be written
+func (l *Logger) Dropped() int64 {
+	return l.dropped.Load()
+}
+
+// Close writes out the queued records, waiting up to closeTimeout, and
+// closes the sink. Write must not be called after Close.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.records)
+
+		select {
+		case <-l.done:
+		case <-time.After(closeTimeout):
+		}
+
+		err = l.w.Close()
+	})
+
+	return err
+}
@@ -0,0 +1,246 @@
+package auditlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+)
+
+func TestNewRecord(t *testing.T) {
+	tests := []struct {
+		desc string
+		env  sshenv.Env
+		want Record
+	}{
+		{
+			desc: "git command",
+			env:  sshenv.Env{RemoteAddr: "192.0.2.1", OriginalCommand: "git-upload-pack 'group/project.git'"},
+			want: Record{RemoteIP: "192.0.2.1", Command: "git-upload-pack", Repository: "group/project.git"},
+		},
+		{
+			desc: "other command",
+			env:  sshenv.Env{RemoteAddr: "192.0.2.1:22022", OriginalCommand: "personal_access_token name api"},
+			want: Record{RemoteIP: "192.0.2.1", Command: "personal_access_token"},
+		},
+		{
+			desc: "no command",
+			env:  sshenv.Env{RemoteAddr: "192.0.2.1"},
+			want: Record{RemoteIP: "192.0.2.1"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			record := NewRecord(tc.env)
+
+			require.WithinDuration(t, time.Now(), record.Time, time.Minute)
+			record.Time = time.Time{}
+			require.Equal(t, tc.want, record)
+		})
+	}
+}
+
+func writeRecords(t *testing.T, logger *Logger, commands ...string) {
+	t.Helper()
+
+	for _, command := range commands {
+		logger.Write(Record{Time: time.Now().UTC(), Command: command, Result: ResultSuccess})
+	}
+
+	require.NoError(t, logger.Close())
+	require.Zero(t, logger.Dropped())
+}
+
+func readRecords(t *testing.T, r io.Reader) []Record {
+	t.Helper()
+
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var record Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.NoError(t, scanner.Err())
+
+	return records
+}
+
+func TestFileTarget(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	logger, err := Open("file:" + path)
+	require.NoError(t, err)
+	writeRecords(t, logger, "git-upload-pack", "git-receive-pack")
+
+	// The chain carries on after a restart
+	logger, err = Open("file:" + path)
+	require.NoError(t, err)
+	writeRecords(t, logger, "discover")
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, Verify(strings.NewReader(string(content))))
+
+	records := readRecords(t, strings.NewReader(string(content)))
+	require.Len(t, records, 3)
+
+	for i, record := range records {
+		require.Equal(t, uint64(i+1), record.Sequence)
+		require.NotEmpty(t, record.Hash)
+		if i > 0 {
+			require.Equal(t, records[i-1].Hash, record.PrevHash)
+		}
+	}
+	require.Empty(t, records[0].PrevHash)
+	require.Equal(t, "discover", records[2].Command)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestFileTargetWithInvalidLastRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	require.NoError(t, os.WriteFile(path, []byte("not a record\n"), 0o600))
+
+	_, err := Open("file:" + path)
+	require.ErrorContains(t, err, "invalid last record")
+}
+
+func TestVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	logger, err := Open("file:" + path)
+	require.NoError(t, err)
+	writeRecords(t, logger, "git-upload-pack", "git-receive-pack", "discover")
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.SplitAfter(string(content), "\n")
+
+	tests := []struct {
+		desc          string
+		log           string
+		expectedError string
+	}{
+		{
+			desc: "an intact log",
+			log:  string(content),
+		},
+		{
+			desc: "a rotated log",
+			log:  lines[1] + lines[2],
+		},
+		{
+			desc:          "a removed record",
+			log:           lines[0] + lines[2],
+			expectedError: "audit log chain is broken: line 2: sequence 3 follows 1",
+		},
+		{
+			desc:          "an altered record",
+			log:           lines[0] + strings.Replace(lines[1], "git-receive-pack", "git-upload-pack", 1) + lines[2],
+			expectedError: "audit log chain is broken: line 2: hash mismatch",
+		},
+		{
+			desc:          "a reordered record",
+			log:           lines[0] + lines[2] + lines[1],
+			expectedError: "audit log chain is broken: line 2: sequence 3 follows 1",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := Verify(strings.NewReader(tc.log))
+
+			if tc.expectedError == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.expectedError)
+				require.ErrorIs(t, err, ErrBrokenChain)
+			}
+		})
+	}
+}
+
+func TestHTTPTarget(t *testing.T) {
+	received := make(chan Record, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var record Record
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&record))
+		received <- record
+	}))
+	defer server.Close()
+
+	logger, err := Open(server.URL + "/audit")
+	require.NoError(t, err)
+	writeRecords(t, logger, "git-upload-pack", "git-receive-pack")
+
+	first, second := <-received, <-received
+	require.Equal(t, "git-upload-pack", first.Command)
+	require.Equal(t, first.Hash, second.PrevHash)
+}
+
+func TestHTTPTargetFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	logger, err := Open(server.URL)
+	require.NoError(t, err)
+
+	logger.Write(Record{Command: "discover"})
+	require.NoError(t, logger.Close())
+	require.Equal(t, int64(1), logger.Dropped())
+}
+
+func TestSyslogTarget(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	logger, err := Open("syslog:udp://" + conn.LocalAddr().String())
+	require.NoError(t, err)
+	writeRecords(t, logger, "git-upload-pack")
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, 4096)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+
+	message := string(buf[:n])
+	require.Contains(t, message, syslogTag)
+	require.Contains(t, message, `"command":"git-upload-pack"`)
+}
+
+func TestInvalidTarget(t *testing.T) {
+	for _, target := range []string{"", "audit.log", "fd:3", "syslog:localhost"} {
+		t.Run(target, func(t *testing.T) {
+			_, err := Open(target)
+			require.ErrorIs(t, err, ErrInvalidTarget)
+		})
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	var logger *Logger
+
+	logger.Write(Record{})
+	require.NoError(t, logger.Close())
+}
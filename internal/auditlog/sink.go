@@ -0,0 +1,143 @@
+package auditlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/syslog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	filePrefix   = "file:"
+	syslogPrefix = "syslog:"
+	syslogTag    = "gitlab-sshd"
+
+	httpTimeout = 10 * time.Second
+
+	// tailSize is how much of the end of a log file is read to find the
+	// record its chain carries on from
+	tailSize = 64 * 1024
+)
+
+// ErrInvalidTarget is returned by Open for targets that are neither
+// "file:PATH", "syslog:[NETWORK://ADDRESS]" nor an HTTP(S) URL
+var ErrInvalidTarget = errors.New("audit log target must be file:PATH, syslog:[NETWORK://ADDRESS] or an HTTP(S) URL")
+
+// Open opens the audit log at target:
+//
+//	file:PATH                   appends to the file at PATH
+//	syslog:                     sends to the local syslog daemon
+//	syslog:NETWORK://ADDRESS    sends to a remote syslog daemon, e.g. syslog:udp://192.0.2.1:514
+//	http://... or https://...   posts each record to the URL
+func Open(target string) (*Logger, error) {
+	switch {
+	case strings.HasPrefix(target, filePrefix):
+		return openFile(strings.TrimPrefix(target, filePrefix))
+	case strings.HasPrefix(target, syslogPrefix):
+		return openSyslog(strings.TrimPrefix(target, syslogPrefix))
+	case strings.HasPrefix(target, "http://"), strings.HasPrefix(target, "https://"):
+		if _, err := url.Parse(target); err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTarget, target)
+		}
+
+		return New(&httpWriter{url: target, client: &http.Client{Timeout: httpTimeout}}), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTarget, target)
+	}
+}
+
+// openFile appends to the log file at path, carrying on the chain of the
+// records already in it
+func openFile(path string) (*Logger, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	last, err := lastRecord(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to read audit log %s: %w", path, err)
+	}
+
+	return newLogger(file, last), nil
+}
+
+// lastRecord returns the last record of a log file, or nil if it has none
+func lastRecord(file *os.File) (*Record, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	offset := max(info.Size()-tailSize, 0)
+	tail := make([]byte, info.Size()-offset)
+	if _, err := file.ReadAt(tail, offset); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(tail), []byte("\n"))
+	line := lines[len(lines)-1]
+	if len(line) == 0 {
+		return nil, nil
+	}
+
+	record := &Record{}
+	if err := json.Unmarshal(line, record); err != nil {
+		return nil, fmt.Errorf("invalid last record: %w", err)
+	}
+
+	return record, nil
+}
+
+func openSyslog(address string) (*Logger, error) {
+	var network, raddr string
+	if address != "" {
+		u, err := url.Parse(address)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTarget, syslogPrefix+address)
+		}
+
+		network, raddr = u.Scheme, u.Host
+	}
+
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_AUTH, syslogTag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	return New(w), nil
+}
+
+// httpWriter posts each write, a single record, to url
+type httpWriter struct {
+	url    string
+	client *http.Client
+}
+
+func (w *httpWriter) Write(p []byte) (int, error) {
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(p))
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, fmt.Errorf("audit log endpoint returned %s", resp.Status)
+	}
+
+	return len(p), nil
+}
+
+func (w *httpWriter) Close() error {
+	w.client.CloseIdleConnections()
+
+	return nil
+}
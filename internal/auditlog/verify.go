@@ -0,0 +1,51 @@
+package auditlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrBrokenChain is returned by Verify for a log with a removed, reordered or
+// altered record
+var ErrBrokenChain = errors.New("audit log chain is broken")
+
+// Verify checks the chain of the records read from r, one JSON line each. The
+// first record is trusted as the start of the chain, so that a rotated log can
+// be verified on its own.
+func Verify(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+
+	var previous *Record
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		record := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return fmt.Errorf("%w: line %d: %w", ErrBrokenChain, lineNumber, err)
+		}
+
+		if previous != nil {
+			if record.Sequence != previous.Sequence+1 {
+				return fmt.Errorf("%w: line %d: sequence %d follows %d", ErrBrokenChain, lineNumber, record.Sequence, previous.Sequence)
+			}
+
+			if record.PrevHash != previous.Hash {
+				return fmt.Errorf("%w: line %d: previous hash mismatch", ErrBrokenChain, lineNumber)
+			}
+		}
+
+		sealed, _, err := seal(*record)
+		if err != nil {
+			return err
+		}
+
+		if sealed.Hash != record.Hash {
+			return fmt.Errorf("%w: line %d: hash mismatch", ErrBrokenChain, lineNumber)
+		}
+
+		previous = record
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,167 @@
+// Package auditpipe provides functionality for writing SSH connection audit
+// records to an external pipe, e.g. for SIEM integration
+package auditpipe
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+)
+
+const (
+	fdPrefix   = "fd:"
+	unixPrefix = "unix:"
+
+	// bufferSize is the number of records queued while the pipe is slow
+	// before further records are dropped
+	bufferSize = 1024
+	// closeTimeout bounds how long Close waits for queued records to be written
+	closeTimeout = 5 * time.Second
+)
+
+// ErrInvalidTarget is returned by Open for targets that are neither
+// "fd:N" nor "unix:PATH"
+var ErrInvalidTarget = errors.New("audit pipe target must be fd:N or unix:PATH")
+
+// Record is the audit record written, as a JSON line, for each SSH connection
+type Record struct {
+	Time               time.Time `json:"time"`
+	RemoteIP           string    `json:"remote_ip"`
+	GitProtocolVersion int       `json:"git_protocol_version"`
+	Command            string    `json:"command"`
+	Repository         string    `json:"repository,omitempty"`
+}
+
+// NewRecord derives a Record from env. Only the verb of the command and the
+// repository it operates on are recorded, not the complete command.
+func NewRecord(env sshenv.Env) Record {
+	record := Record{
+		Time:               time.Now().UTC(),
+		RemoteIP:           env.RemoteAddr,
+		GitProtocolVersion: env.ProtocolVersion,
+	}
+
+	if gc, err := env.GitCommand(); err == nil {
+		record.Command = gc.Verb
+		record.Repository = gc.RepoPath
+	} else if args, err := env.CommandArgs(); err == nil && len(args) > 0 {
+		record.Command = args[0]
+	}
+
+	return record
+}
+
+// Pipe writes audit records in the background, so that a slow or stuck
+// reader can't stall git operations. Records that don't fit in the buffer
+// are dropped and counted.
+type Pipe struct {
+	w       io.WriteCloser
+	records chan []byte
+	done    chan struct{}
+	dropped atomic.Int64
+
+	closeOnce sync.Once
+}
+
+// Open opens the audit pipe at target: "fd:N" for an inherited file
+// descriptor or "unix:PATH" for a unix socket
+func Open(target string) (*Pipe, error) {
+	var w io.WriteCloser
+
+	switch {
+	case strings.HasPrefix(target, fdPrefix):
+		fd, err := strconv.ParseUint(strings.TrimPrefix(target, fdPrefix), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTarget, target)
+		}
+		w = os.NewFile(uintptr(fd), target)
+	case strings.HasPrefix(target, unixPrefix):
+		conn, err := net.Dial("unix", strings.TrimPrefix(target, unixPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit pipe: %w", err)
+		}
+		w = conn
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTarget, target)
+	}
+
+	return New(w), nil
+}
+
+// New returns a Pipe writing to w, which it takes ownership of
+func New(w io.WriteCloser) *Pipe {
+	p := &Pipe{
+		w:       w,
+		records: make(chan []byte, bufferSize),
+		done:    make(chan struct{}),
+	}
+
+	go p.run()
+
+	return p
+}
+
+func (p *Pipe) run() {
+	defer close(p.done)
+
+	for line := range p.records {
+		if _, err := p.w.Write(line); err != nil {
+			p.dropped.Add(1)
+		}
+	}
+}
+
+// Write queues record for writing without blocking. It is a no-op on a nil
+// Pipe, so callers needn't check whether auditing is enabled.
+func (p *Pipe) Write(record Record) {
+	if p == nil {
+		return
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		p.dropped.Add(1)
+		return
+	}
+
+	select {
+	case p.records <- append(line, '\n'):
+	default:
+		p.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of records that couldn't be written
+func (p *Pipe) Dropped() int64 {
+	return p.dropped.Load()
+}
+
+// Close writes out the queued records, waiting up to closeTimeout, and
+// closes the underlying writer. Write must not be called after Close.
+func (p *Pipe) Close() error {
+	if p == nil {
+		return nil
+	}
+
+	var err error
+	p.closeOnce.Do(func() {
+		close(p.records)
+		select {
+		case <-p.done:
+		case <-time.After(closeTimeout):
+		}
+		err = p.w.Close()
+	})
+
+	return err
+}
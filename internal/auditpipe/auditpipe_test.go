@@ -0,0 +1,131 @@
+package auditpipe
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+)
+
+func TestNewRecord(t *testing.T) {
+	tests := []struct {
+		desc string
+		env  sshenv.Env
+		want Record
+	}{
+		{
+			desc: "git command",
+			env:  sshenv.Env{RemoteAddr: "192.0.2.1", ProtocolVersion: 2, OriginalCommand: "git-upload-pack 'group/project.git'"},
+			want: Record{RemoteIP: "192.0.2.1", GitProtocolVersion: 2, Command: "git-upload-pack", Repository: "group/project.git"},
+		},
+		{
+			desc: "other command",
+			env:  sshenv.Env{RemoteAddr: "192.0.2.1", OriginalCommand: "2fa_verify"},
+			want: Record{RemoteIP: "192.0.2.1", Command: "2fa_verify"},
+		},
+		{
+			desc: "no command",
+			env:  sshenv.Env{RemoteAddr: "192.0.2.1"},
+			want: Record{RemoteIP: "192.0.2.1"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			record := NewRecord(tc.env)
+			require.WithinDuration(t, time.Now(), record.Time, time.Minute)
+
+			record.Time = time.Time{}
+			require.Equal(t, tc.want, record)
+		})
+	}
+}
+
+func TestOpenUnixSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "audit.socket")
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	pipe, err := Open("unix:" + socketPath)
+	require.NoError(t, err)
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for _, remoteIP := range []string{"192.0.2.1", "192.0.2.2"} {
+		pipe.Write(NewRecord(sshenv.Env{RemoteAddr: remoteIP, OriginalCommand: "git-receive-pack 'group/project.git'"}))
+	}
+	require.NoError(t, pipe.Close())
+
+	scanner := bufio.NewScanner(conn)
+	for _, remoteIP := range []string{"192.0.2.1", "192.0.2.2"} {
+		require.True(t, scanner.Scan())
+
+		var record Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		require.Equal(t, remoteIP, record.RemoteIP)
+		require.Equal(t, "git-receive-pack", record.Command)
+		require.Equal(t, "group/project.git", record.Repository)
+	}
+	require.False(t, scanner.Scan())
+	require.Zero(t, pipe.Dropped())
+}
+
+func TestOpenInvalidTarget(t *testing.T) {
+	for _, target := range []string{"", "/var/log/audit", "fd:stdout"} {
+		_, err := Open(target)
+		require.ErrorIs(t, err, ErrInvalidTarget, target)
+	}
+}
+
+type blockingWriter struct {
+	unblock chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.unblock
+	return len(p), nil
+}
+
+func (w *blockingWriter) Close() error { return nil }
+
+func TestWriteDoesNotBlock(t *testing.T) {
+	w := &blockingWriter{unblock: make(chan struct{})}
+	pipe := New(w)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < bufferSize+10; i++ {
+			pipe.Write(Record{Command: "git-upload-pack"})
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write blocked on a stuck reader")
+	}
+
+	// bufferSize records are queued and the writer may be holding one more,
+	// everything else is dropped
+	require.InDelta(t, 10, pipe.Dropped(), 1)
+
+	close(w.unblock)
+	require.NoError(t, pipe.Close())
+}
+
+func TestNilPipe(t *testing.T) {
+	var pipe *Pipe
+
+	pipe.Write(Record{})
+	require.NoError(t, pipe.Close())
+}
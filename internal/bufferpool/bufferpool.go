@@ -0,0 +1,78 @@
+// Package bufferpool shares the buffers the data of transfers is copied
+// through, between the SSH channels and Gitaly or GitLab, so that thousands
+// of concurrent transfers don't each allocate and discard their own
+package bufferpool
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultSize is the size of the buffers, the one io.Copy allocates
+const DefaultSize = 32 * 1024
+
+// pool holds buffers of a single size
+type pool struct {
+	size    int
+	buffers sync.Pool
+}
+
+func newPool(size int) *pool {
+	p := &pool{size: size}
+	p.buffers.New = func() any {
+		buf := make([]byte, size)
+		return &buf
+	}
+
+	return p
+}
+
+var current atomic.Pointer[pool]
+
+func init() {
+	current.Store(newPool(DefaultSize))
+}
+
+// SetSize sets the size of the buffers handed out from now on. Sizes below 1
+// select DefaultSize.
+func SetSize(size int) {
+	if size < 1 {
+		size = DefaultSize
+	}
+
+	if current.Load().size != size {
+		current.Store(newPool(size))
+	}
+}
+
+// Size returns the size of the buffers handed out
+func Size() int {
+	return current.Load().size
+}
+
+// Get returns a buffer, to be given back with Put once unused
+func Get() *[]byte {
+	return current.Load().buffers.Get().(*[]byte)
+}
+
+// Put gives back a buffer returned by Get. Buffers of a previous size are
+// dropped.
+func Put(buf *[]byte) {
+	p := current.Load()
+	if len(*buf) != p.size {
+		return
+	}
+
+	p.buffers.Put(buf)
+}
+
+// Copy is io.Copy through a pooled buffer. Like io.Copy, it lets src write
+// itself to dst if it implements io.WriterTo, or dst read src if it
+// implements io.ReaderFrom, without the buffer.
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	buf := Get()
+	defer Put(buf)
+
+	return io.CopyBuffer(dst, src, *buf)
+}
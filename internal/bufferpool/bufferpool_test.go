@@ -0,0 +1,58 @@
+package bufferpool
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetSize(t *testing.T) {
+	t.Cleanup(func() { SetSize(DefaultSize) })
+
+	buf := Get()
+	require.Len(t, *buf, DefaultSize)
+
+	SetSize(64 * 1024)
+	require.Equal(t, 64*1024, Size())
+	require.Len(t, *Get(), 64*1024)
+
+	// The buffers of the previous size are dropped
+	Put(buf)
+	require.Len(t, *Get(), 64*1024)
+
+	SetSize(0)
+	require.Equal(t, DefaultSize, Size())
+}
+
+func TestCopy(t *testing.T) {
+	data := strings.Repeat("0123456789", 10*1024)
+	dst := &bytes.Buffer{}
+
+	n, err := Copy(struct{ io.Writer }{dst}, struct{ io.Reader }{strings.NewReader(data)})
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+	require.Equal(t, data, dst.String())
+}
+
+func BenchmarkCopy(b *testing.B) {
+	data := bytes.Repeat([]byte("0123456789"), 100*1024)
+
+	// The readers and writers hide io.WriterTo and io.ReaderFrom, as those of
+	// SSH channels and HTTP bodies don't implement them
+	copyData := func(b *testing.B, copyFn func(io.Writer, io.Reader) (int64, error)) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+
+		for i := 0; i < b.N; i++ {
+			if _, err := copyFn(struct{ io.Writer }{io.Discard}, struct{ io.Reader }{bytes.NewReader(data)}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("io.Copy", func(b *testing.B) { copyData(b, io.Copy) })
+	b.Run("pooled", func(b *testing.B) { copyData(b, Copy) })
+}
@@ -1,9 +1,12 @@
 package authorizedkeys
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
@@ -12,6 +15,10 @@ import (
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/keyline"
 )
 
+// maxKeyLineLength is the longest key read in batch mode, well above that of
+// the largest RSA keys
+const maxKeyLineLength = 64 * 1024
+
 type Command struct {
 	Config     *config.Config
 	Args       *commandargs.AuthorizedKeys
@@ -28,6 +35,10 @@ func (c *Command) Execute(ctx context.Context) (context.Context, error) {
 		return ctx, nil
 	}
 
+	if c.Args.Batch {
+		return ctx, c.printBatchKeyLines(ctx)
+	}
+
 	if err := c.printKeyLine(ctx); err != nil {
 		return ctx, err
 	}
@@ -38,7 +49,81 @@ func (c *Command) Execute(ctx context.Context) (context.Context, error) {
 func (c *Command) printKeyLine(ctx context.Context) error {
 	response, err := c.getAuthorizedKey(ctx)
 	if err != nil {
-		fmt.Fprintln(c.ReadWriter.Out, fmt.Sprintf("# No key was found for %s", c.Args.Key))
+		return c.printResponse(c.Args.Key, nil)
+	}
+
+	return c.printResponse(c.Args.Key, response)
+}
+
+// printBatchKeyLines answers the keys read on stdin, one per line, in
+// batches of authorizedkeys.MaxBatchSize, each in a single request
+func (c *Command) printBatchKeyLines(ctx context.Context) error {
+	client, err := authorizedkeys.NewClient(c.Config)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(c.ReadWriter.In)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxKeyLineLength)
+
+	var keys []string
+	for scanner.Scan() {
+		key := strings.TrimSpace(scanner.Text())
+		if key == "" {
+			continue
+		}
+
+		keys = append(keys, key)
+		if len(keys) == authorizedkeys.MaxBatchSize {
+			if err := c.printBatch(ctx, client, keys); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read keys: %w", err)
+	}
+
+	return c.printBatch(ctx, client, keys)
+}
+
+func (c *Command) printBatch(ctx context.Context, client *authorizedkeys.Client, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	responses, err := client.GetBatch(ctx, keys)
+	if errors.Is(err, authorizedkeys.ErrBatchUnsupported) {
+		responses = getEach(ctx, client, keys)
+	} else if err != nil {
+		// As for a single key, the keys are reported as missing
+		responses = make([]*authorizedkeys.Response, len(keys))
+	}
+
+	for i, key := range keys {
+		if err := c.printResponse(key, responses[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getEach looks up keys one by one, for versions of GitLab without the batch
+// endpoint
+func getEach(ctx context.Context, client *authorizedkeys.Client, keys []string) []*authorizedkeys.Response {
+	responses := make([]*authorizedkeys.Response, len(keys))
+	for i, key := range keys {
+		responses[i], _ = lookup(ctx, client, key)
+	}
+
+	return responses
+}
+
+func (c *Command) printResponse(key string, response *authorizedkeys.Response) error {
+	if response == nil {
+		fmt.Fprintln(c.ReadWriter.Out, fmt.Sprintf("# No key was found for %s", key))
 		return nil
 	}
 
@@ -58,5 +143,14 @@ func (c *Command) getAuthorizedKey(ctx context.Context) (*authorizedkeys.Respons
 		return nil, err
 	}
 
-	return client.GetByKey(ctx, c.Args.Key)
+	return lookup(ctx, client, c.Args.Key)
+}
+
+func lookup(ctx context.Context, client *authorizedkeys.Client, key string) (*authorizedkeys.Response, error) {
+	// OpenSSH passes a fingerprint for %f and the encoded key for %k
+	if authorizedkeys.IsFingerprint(key) {
+		return client.GetByFingerprint(ctx, key)
+	}
+
+	return client.GetByKey(ctx, key)
 }
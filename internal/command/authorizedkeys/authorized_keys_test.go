@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -20,7 +21,13 @@ var (
 		{
 			Path: "/api/v4/internal/authorized_keys",
 			Handler: func(w http.ResponseWriter, r *http.Request) {
-				if r.URL.Query().Get("key") == "key" {
+				if r.URL.Query().Get("fingerprint") == "nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8" {
+					body := map[string]interface{}{
+						"id":  2,
+						"key": "public-key",
+					}
+					json.NewEncoder(w).Encode(body)
+				} else if r.URL.Query().Get("key") == "key" {
 					body := map[string]interface{}{
 						"id":  1,
 						"key": "public-key",
@@ -39,6 +46,30 @@ var (
 				}
 			},
 		},
+		{
+			Path: "/api/v4/internal/authorized_keys/batch",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				var request struct {
+					Lookups []map[string]string `json:"lookups"`
+				}
+				json.NewDecoder(r.Body).Decode(&request)
+
+				keys := []interface{}{}
+				for _, lookup := range request.Lookups {
+					switch {
+					case lookup["key"] == "broken":
+						w.WriteHeader(http.StatusForbidden)
+						return
+					case lookup["key"] == "key":
+						keys = append(keys, map[string]interface{}{"id": 1, "key": "public-key"})
+					default:
+						keys = append(keys, nil)
+					}
+				}
+
+				json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+			},
+		},
 	}
 )
 
@@ -57,6 +88,16 @@ func TestExecute(t *testing.T) {
 			arguments:      &commandargs.AuthorizedKeys{ExpectedUser: "user", ActualUser: "user", Key: "key"},
 			expectedOutput: "command=\"/tmp/bin/gitlab-shell key-1\",no-port-forwarding,no-X11-forwarding,no-agent-forwarding,no-pty public-key\n",
 		},
+		{
+			desc:           "With matching username and fingerprint",
+			arguments:      &commandargs.AuthorizedKeys{ExpectedUser: "user", ActualUser: "user", Key: "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8"},
+			expectedOutput: "command=\"/tmp/bin/gitlab-shell key-2\",no-port-forwarding,no-X11-forwarding,no-agent-forwarding,no-pty public-key\n",
+		},
+		{
+			desc:           "When the fingerprint is invalid",
+			arguments:      &commandargs.AuthorizedKeys{ExpectedUser: "user", ActualUser: "user", Key: "SHA256:invalid"},
+			expectedOutput: "# No key was found for SHA256:invalid\n",
+		},
 		{
 			desc:           "When key doesn't match any existing key",
 			arguments:      &commandargs.AuthorizedKeys{ExpectedUser: "user", ActualUser: "user", Key: "not-found"},
@@ -91,3 +132,61 @@ func TestExecute(t *testing.T) {
 		})
 	}
 }
+
+func TestExecuteBatch(t *testing.T) {
+	keyLine := "command=\"/tmp/bin/gitlab-shell key-1\",no-port-forwarding,no-X11-forwarding,no-agent-forwarding,no-pty public-key\n"
+
+	testCases := []struct {
+		desc           string
+		requests       []testserver.TestRequestHandler
+		arguments      *commandargs.AuthorizedKeys
+		input          string
+		expectedOutput string
+	}{
+		{
+			desc:           "With keys found and not",
+			requests:       requests,
+			arguments:      &commandargs.AuthorizedKeys{ExpectedUser: "user", ActualUser: "user", Batch: true},
+			input:          "key\n\nnot-found\n key \n",
+			expectedOutput: keyLine + "# No key was found for not-found\n" + keyLine,
+		},
+		{
+			desc:           "When the API fails",
+			requests:       requests,
+			arguments:      &commandargs.AuthorizedKeys{ExpectedUser: "user", ActualUser: "user", Batch: true},
+			input:          "key\nbroken\n",
+			expectedOutput: "# No key was found for key\n# No key was found for broken\n",
+		},
+		{
+			desc:           "Without the batch endpoint",
+			requests:       requests[:1],
+			arguments:      &commandargs.AuthorizedKeys{ExpectedUser: "user", ActualUser: "user", Batch: true},
+			input:          "key\nnot-found\n",
+			expectedOutput: keyLine + "# No key was found for not-found\n",
+		},
+		{
+			desc:      "With mismatching usernames",
+			requests:  requests,
+			arguments: &commandargs.AuthorizedKeys{ExpectedUser: "user", ActualUser: "other", Batch: true},
+			input:     "key\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			url := testserver.StartSocketHttpServer(t, tc.requests)
+			buffer := &bytes.Buffer{}
+
+			cmd := &Command{
+				Config:     &config.Config{RootDir: "/tmp", GitlabUrl: url},
+				Args:       tc.arguments,
+				ReadWriter: &readwriter.ReadWriter{Out: buffer, In: strings.NewReader(tc.input)},
+			}
+
+			_, err := cmd.Execute(context.Background())
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedOutput, buffer.String())
+		})
+	}
+}
@@ -2,14 +2,33 @@ package authorizedprincipals
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"gitlab.com/gitlab-org/labkit/log"
+	"golang.org/x/crypto/ssh"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/authorizedprincipals"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/keyline"
 )
 
+// GroupPrefix starts the principals naming a group, such as
+// "group:backend/api". Group principals, which may be wildcards, such as
+// "group:backend/*", are granted by the internal API.
+const GroupPrefix = "group:"
+
+var (
+	errPatternsNeedCertificate = fmt.Errorf("# Wildcard and group principals need %s", commandargs.CertificateFlag)
+	errInvalidCertificate      = errors.New("# Invalid certificate")
+)
+
 type Command struct {
 	Config     *config.Config
 	Args       *commandargs.AuthorizedPrincipals
@@ -17,15 +36,18 @@ type Command struct {
 }
 
 func (c *Command) Execute(ctx context.Context) (context.Context, error) {
-	if err := c.printPrincipalLines(); err != nil {
+	if err := c.printPrincipalLines(ctx); err != nil {
 		return ctx, err
 	}
 
 	return ctx, nil
 }
 
-func (c *Command) printPrincipalLines() error {
-	principals := c.Args.Principals
+func (c *Command) printPrincipalLines(ctx context.Context) error {
+	principals, err := c.principals(ctx)
+	if err != nil {
+		return err
+	}
 
 	for _, principal := range principals {
 		if err := c.printPrincipalLine(principal); err != nil {
@@ -36,6 +58,88 @@ func (c *Command) printPrincipalLines() error {
 	return nil
 }
 
+// principals returns the principals to print: those given, and those of the
+// certificate matching the wildcard principals given or, once granted by
+// the internal API, the group principals given
+func (c *Command) principals(ctx context.Context) ([]string, error) {
+	var principals, groups []string
+	var certPrincipals []string
+
+	for _, pattern := range c.Args.Principals {
+		if !IsPattern(pattern) {
+			principals = appendNew(principals, pattern)
+			continue
+		}
+
+		if certPrincipals == nil {
+			var err error
+			if certPrincipals, err = c.certificatePrincipals(); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, principal := range certPrincipals {
+			if matched, _ := path.Match(pattern, principal); !matched {
+				continue
+			}
+
+			if strings.HasPrefix(principal, GroupPrefix) {
+				groups = appendNew(groups, principal)
+			} else {
+				principals = appendNew(principals, principal)
+			}
+		}
+	}
+
+	if len(groups) == 0 {
+		return principals, nil
+	}
+
+	granted, err := c.grantedGroups(ctx, groups)
+	if err != nil {
+		// Group principals aren't granted while the internal API can't tell
+		log.WithContextFields(ctx, log.Fields{"key_id": c.Args.KeyId, "principals": groups}).WithError(err).Warn("Failed to look up group principals")
+
+		return principals, nil
+	}
+
+	return append(principals, granted...), nil
+}
+
+func (c *Command) grantedGroups(ctx context.Context, groups []string) ([]string, error) {
+	client, err := authorizedprincipals.NewClient(c.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.GrantedGroups(ctx, c.Args.KeyId, groups)
+}
+
+// certificatePrincipals returns the principals of the certificate given with
+// commandargs.CertificateFlag
+func (c *Command) certificatePrincipals() ([]string, error) {
+	if c.Args.Certificate == "" {
+		return nil, errPatternsNeedCertificate
+	}
+
+	data, err := base64.StdEncoding.DecodeString(c.Args.Certificate)
+	if err != nil {
+		return nil, errInvalidCertificate
+	}
+
+	key, err := ssh.ParsePublicKey(data)
+	if err != nil {
+		return nil, errInvalidCertificate
+	}
+
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, errInvalidCertificate
+	}
+
+	return append([]string{}, cert.ValidPrincipals...), nil
+}
+
 func (c *Command) printPrincipalLine(principal string) error {
 	principalKeyLine, err := keyline.NewPrincipalKeyLine(c.Args.KeyId, principal, c.Config)
 	if err != nil {
@@ -46,3 +150,17 @@ func (c *Command) printPrincipalLine(principal string) error {
 
 	return nil
 }
+
+// IsPattern tells whether principal is matched against the principals of the
+// certificate, being a wildcard or a group
+func IsPattern(principal string) bool {
+	return strings.HasPrefix(principal, GroupPrefix) || strings.ContainsAny(principal, `*?[\`)
+}
+
+func appendNew(principals []string, principal string) []string {
+	if slices.Contains(principals, principal) {
+		return principals
+	}
+
+	return append(principals, principal)
+}
@@ -3,10 +3,17 @@ package authorizedprincipals
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
 
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
@@ -49,3 +56,129 @@ func TestExecute(t *testing.T) {
 		})
 	}
 }
+
+func TestExecutePatterns(t *testing.T) {
+	var requested []string
+	url := testserver.StartSocketHttpServer(t, []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/authorized_principals/groups",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				var request struct {
+					KeyID      string   `json:"key_id"`
+					Principals []string `json:"principals"`
+				}
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+				require.Equal(t, "alex-doe", request.KeyID)
+				requested = request.Principals
+
+				json.NewEncoder(w).Encode(map[string][]string{"principals": {"group:backend/api", "group:unrequested"}})
+			},
+		},
+	})
+
+	certificate := newCertificate(t, "deploy-staging", "deploy-production", "group:backend/api", "group:backend/web", "group:frontend")
+	line := func(principal string) string {
+		return "command=\"/tmp/bin/gitlab-shell username-alex-doe\",no-port-forwarding,no-X11-forwarding,no-agent-forwarding,no-pty " + principal + "\n"
+	}
+
+	testCases := []struct {
+		desc              string
+		principals        []string
+		certificate       string
+		expectedOutput    string
+		expectedRequested []string
+		expectedError     string
+	}{
+		{
+			desc:           "wildcard",
+			principals:     []string{"sshUsers", "deploy-*"},
+			certificate:    certificate,
+			expectedOutput: line("sshUsers") + line("deploy-staging") + line("deploy-production"),
+		},
+		{
+			desc:              "groups",
+			principals:        []string{"group:backend/*", "group:backend/api"},
+			certificate:       certificate,
+			expectedOutput:    line("group:backend/api"),
+			expectedRequested: []string{"group:backend/api", "group:backend/web"},
+		},
+		{
+			desc:           "no match",
+			principals:     []string{"admin-*"},
+			certificate:    certificate,
+			expectedOutput: "",
+		},
+		{
+			desc:          "without certificate",
+			principals:    []string{"deploy-*"},
+			expectedError: "# Wildcard and group principals need --certificate",
+		},
+		{
+			desc:          "invalid certificate",
+			principals:    []string{"group:backend/*"},
+			certificate:   "not a certificate",
+			expectedError: "# Invalid certificate",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			requested = nil
+			buffer := &bytes.Buffer{}
+
+			cmd := &Command{
+				Config:     &config.Config{RootDir: "/tmp", GitlabUrl: url},
+				Args:       &commandargs.AuthorizedPrincipals{KeyId: "alex-doe", Principals: tc.principals, Certificate: tc.certificate},
+				ReadWriter: &readwriter.ReadWriter{Out: buffer},
+			}
+
+			_, err := cmd.Execute(context.Background())
+			if tc.expectedError != "" {
+				require.EqualError(t, err, tc.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedOutput, buffer.String())
+			require.Equal(t, tc.expectedRequested, requested)
+		})
+	}
+}
+
+func TestExecuteGroupsUnavailable(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	noRetries := 0
+
+	cmd := &Command{
+		Config: &config.Config{
+			RootDir:   "/tmp",
+			GitlabUrl: "http://127.0.0.1:1",
+			HttpSettings: config.HttpSettingsConfig{
+				Endpoints: map[string]config.APIEndpointConfig{"authorized_principals/groups": {Retries: &noRetries}},
+			},
+		},
+		Args:       &commandargs.AuthorizedPrincipals{KeyId: "alex-doe", Principals: []string{"sshUsers", "group:*"}, Certificate: newCertificate(t, "group:backend")},
+		ReadWriter: &readwriter.ReadWriter{Out: buffer},
+	}
+
+	_, err := cmd.Execute(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "command=\"/tmp/bin/gitlab-shell username-alex-doe\",no-port-forwarding,no-X11-forwarding,no-agent-forwarding,no-pty sshUsers\n", buffer.String(), "group principals aren't granted without the internal API")
+}
+
+// newCertificate returns a user certificate for principals, encoded as the %k
+// of sshd
+func newCertificate(t *testing.T, principals ...string) string {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	key, err := ssh.NewPublicKey(publicKey)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	require.NoError(t, err)
+
+	cert := &ssh.Certificate{Key: key, CertType: ssh.UserCert, KeyId: "alex-doe", ValidPrincipals: principals, ValidBefore: ssh.CertTimeInfinity}
+	require.NoError(t, cert.SignCert(rand.Reader, signer))
+
+	return base64.StdEncoding.EncodeToString(cert.Marshal())
+}
@@ -8,7 +8,10 @@ import (
 	"strings"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/telemetry"
 	"gitlab.com/gitlab-org/labkit/correlation"
+	"gitlab.com/gitlab-org/labkit/log"
 	"gitlab.com/gitlab-org/labkit/tracing"
 )
 
@@ -61,18 +64,24 @@ func Setup(serviceName string, config *config.Config) (context.Context, func())
 		tracing.WithConnectionString(config.GitlabTracing),
 	)
 
+	shutdownTelemetry, err := telemetry.Initialize(context.Background(), serviceName, config.OpenTelemetry)
+	if err != nil {
+		log.WithError(err).Warn("Failed to initialize OpenTelemetry tracing")
+		shutdownTelemetry = func(context.Context) error { return nil }
+	}
+
 	ctx, finished := tracing.ExtractFromEnv(context.Background())
 	ctx = correlation.ContextWithClientName(ctx, serviceName)
 
-	correlationID := correlation.ExtractFromContext(ctx)
-	if correlationID == "" {
-		correlationID := correlation.SafeRandomID()
-		ctx = correlation.ContextWithCorrelation(ctx, correlationID)
+	// Unless the caller passed one in the environment
+	if gitlabnet.CorrelationID(ctx) == "" {
+		ctx = gitlabnet.ContextWithCorrelationID(ctx)
 	}
 
 	return ctx, func() {
 		finished()
 		closer.Close()
+		_ = shutdownTelemetry(context.Background())
 	}
 }
 
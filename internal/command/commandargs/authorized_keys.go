@@ -5,14 +5,23 @@ import (
 	"fmt"
 )
 
+// BatchFlag makes gitlab-shell-authorized-keys-check read the keys to look up
+// on stdin, one per line, instead of taking one as an argument
+const BatchFlag = "--batch"
+
 type AuthorizedKeys struct {
 	Arguments    []string
 	ExpectedUser string
 	ActualUser   string
 	Key          string
+	Batch        bool
 }
 
 func (ak *AuthorizedKeys) Parse() error {
+	if len(ak.Arguments) > 0 && ak.Arguments[0] == BatchFlag {
+		return ak.parseBatch()
+	}
+
 	if err := ak.validate(); err != nil {
 		return err
 	}
@@ -24,6 +33,24 @@ func (ak *AuthorizedKeys) Parse() error {
 	return nil
 }
 
+func (ak *AuthorizedKeys) parseBatch() error {
+	argsSize := len(ak.Arguments)
+
+	if argsSize != 3 {
+		return errors.New(fmt.Sprintf("# Insufficient arguments. %d. Usage\n#\tgitlab-shell-authorized-keys-check --batch <expected-username> <actual-username> < keys", argsSize))
+	}
+
+	if ak.Arguments[1] == "" || ak.Arguments[2] == "" {
+		return errors.New("# No username provided")
+	}
+
+	ak.ExpectedUser = ak.Arguments[1]
+	ak.ActualUser = ak.Arguments[2]
+	ak.Batch = true
+
+	return nil
+}
+
 func (ak *AuthorizedKeys) GetArguments() []string {
 	return ak.Arguments
 }
@@ -32,7 +59,7 @@ func (ak *AuthorizedKeys) validate() error {
 	argsSize := len(ak.Arguments)
 
 	if argsSize != 3 {
-		return errors.New(fmt.Sprintf("# Insufficient arguments. %d. Usage\n#\tgitlab-shell-authorized-keys-check <expected-username> <actual-username> <key>", argsSize))
+		return errors.New(fmt.Sprintf("# Insufficient arguments. %d. Usage\n#\tgitlab-shell-authorized-keys-check <expected-username> <actual-username> <key|fingerprint>", argsSize))
 	}
 
 	expectedUsername := ak.Arguments[0]
@@ -5,19 +5,36 @@ import (
 	"fmt"
 )
 
+// CertificateFlag gives gitlab-shell-authorized-principals-check the
+// certificate being authenticated, the %k of sshd, before the key ID. The
+// principals of the certificate are matched against the wildcard and group
+// principals.
+const CertificateFlag = "--certificate"
+
 type AuthorizedPrincipals struct {
-	Arguments  []string
-	KeyId      string
-	Principals []string
+	Arguments   []string
+	KeyId       string
+	Principals  []string
+	Certificate string
 }
 
 func (ap *AuthorizedPrincipals) Parse() error {
-	if err := ap.validate(); err != nil {
+	arguments := ap.Arguments
+	if len(arguments) > 0 && arguments[0] == CertificateFlag {
+		if len(arguments) < 2 || arguments[1] == "" {
+			return errors.New("# No certificate provided")
+		}
+
+		ap.Certificate = arguments[1]
+		arguments = arguments[2:]
+	}
+
+	if err := validatePrincipalArguments(arguments); err != nil {
 		return err
 	}
 
-	ap.KeyId = ap.Arguments[0]
-	ap.Principals = ap.Arguments[1:]
+	ap.KeyId = arguments[0]
+	ap.Principals = arguments[1:]
 
 	return nil
 }
@@ -26,15 +43,15 @@ func (ap *AuthorizedPrincipals) GetArguments() []string {
 	return ap.Arguments
 }
 
-func (ap *AuthorizedPrincipals) validate() error {
-	argsSize := len(ap.Arguments)
+func validatePrincipalArguments(arguments []string) error {
+	argsSize := len(arguments)
 
 	if argsSize < 2 {
 		return errors.New(fmt.Sprintf("# Insufficient arguments. %d. Usage\n#\tgitlab-shell-authorized-principals-check <key-id> <principal1> [<principal2>...]", argsSize))
 	}
 
-	keyId := ap.Arguments[0]
-	principals := ap.Arguments[1:]
+	keyId := arguments[0]
+	principals := arguments[1:]
 
 	if keyId == "" {
 		return errors.New("# No key_id provided")
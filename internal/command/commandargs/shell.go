@@ -2,7 +2,9 @@ package commandargs
 
 import (
 	"fmt"
+	"net/url"
 	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/mattn/go-shellwords"
@@ -26,6 +28,9 @@ var (
 	whoUsernameRegex = regexp.MustCompile(`\Ausername-(?P<username>\S+)\z`)
 
 	GitCommands = []CommandType{LfsAuthenticate, UploadPack, ReceivePack, UploadArchive}
+
+	// repositoryOnlyCommands take the repository as their only argument
+	repositoryOnlyCommands = []CommandType{UploadPack, ReceivePack, UploadArchive}
 )
 
 type Shell struct {
@@ -78,6 +83,21 @@ func (s *Shell) parseWho() {
 	}
 }
 
+// Who returns the user the command is run as, in the form of the arguments
+// parseWho reads, e.g. "key-42" or "username-jane", or the Kerberos principal
+func (s *Shell) Who() string {
+	switch {
+	case s.GitlabUsername != "":
+		return "username-" + s.GitlabUsername
+	case s.GitlabKrb5Principal != "":
+		return s.GitlabKrb5Principal
+	case s.GitlabKeyId != "":
+		return "key-" + s.GitlabKeyId
+	default:
+		return ""
+	}
+}
+
 func tryParse(r *regexp.Regexp, argument string) string {
 	// sshd may execute the session for AuthorizedKeysCommand in multiple ways:
 	// 1. key-id
@@ -121,6 +141,32 @@ func (s *Shell) ParseCommand(commandString string) error {
 
 	s.defineCommandType()
 
+	return s.normalizeRepository()
+}
+
+// normalizeRepository rewrites the repository of Git commands the way some
+// clients, such as those built into IDEs, send it: the path of a repository
+// with spaces may be split into several arguments when it isn't quoted, and
+// may be percent-encoded.
+func (s *Shell) normalizeRepository() error {
+	if len(s.SshArgs) < 2 || !slices.Contains(GitCommands, s.CommandType) && s.CommandType != LfsTransfer {
+		return nil
+	}
+
+	// These only take a repository, so that every argument is part of it
+	if len(s.SshArgs) > 2 && slices.Contains(repositoryOnlyCommands, s.CommandType) {
+		s.SshArgs = []string{s.SshArgs[0], strings.Join(s.SshArgs[1:], " ")}
+	}
+
+	if strings.Contains(s.SshArgs[1], "%") {
+		repo, err := url.PathUnescape(s.SshArgs[1])
+		if err != nil {
+			return fmt.Errorf("invalid repository path: %w", err)
+		}
+
+		s.SshArgs[1] = repo
+	}
+
 	return nil
 }
 
@@ -0,0 +1,41 @@
+package configcheck
+
+import (
+	"context"
+	"fmt"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+var configMessage = "Configuration valid"
+
+// Command checks the config file of RootDir, printing the problems found
+type Command struct {
+	RootDir    string
+	ReadWriter *readwriter.ReadWriter
+}
+
+func (c *Command) Execute(ctx context.Context) (context.Context, error) {
+	cfg, err := config.NewFromDir(c.RootDir)
+	if err != nil {
+		return ctx, fmt.Errorf("%v: FAILED - %v", configMessage, err)
+	}
+
+	problems, err := cfg.Lint()
+	if err != nil {
+		return ctx, fmt.Errorf("%v: FAILED - %v", configMessage, err)
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintln(c.ReadWriter.Out, problem)
+	}
+
+	if len(problems) > 0 {
+		return ctx, fmt.Errorf("%v: FAILED - %d problem(s) found", configMessage, len(problems))
+	}
+
+	fmt.Fprintf(c.ReadWriter.Out, "%v: OK\n", configMessage)
+
+	return ctx, nil
+}
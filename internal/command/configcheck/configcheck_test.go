@@ -0,0 +1,67 @@
+package configcheck
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+)
+
+func writeConfig(t *testing.T, data string) string {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yml"), []byte("secret: sssh\n"+data), 0o600))
+
+	return dir
+}
+
+func TestExecute(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		data           string
+		expectedOutput string
+		expectedError  string
+	}{
+		{
+			desc:           "a valid config",
+			data:           "gitlab_url: http://localhost\nsshd:\n  grace_period: 10s\n",
+			expectedOutput: "Configuration valid: OK\n",
+		},
+		{
+			desc:           "unknown keys",
+			data:           "gitlab_url: http://localhost\nsshd:\n  listn: \"[::]:22\"\nlog_levl: debug\n",
+			expectedOutput: "line 4: unknown key sshd.listn\nline 5: unknown key log_levl\n",
+			expectedError:  "Configuration valid: FAILED - 2 problem(s) found",
+		},
+		{
+			desc:           "a missing host key",
+			data:           "sshd:\n  host_key_files: [/missing/ssh_host_ed25519_key]\n",
+			expectedOutput: "sshd.host_key_files[0]: stat /missing/ssh_host_ed25519_key: no such file or directory\n",
+			expectedError:  "Configuration valid: FAILED - 1 problem(s) found",
+		},
+		{
+			desc:          "an invalid duration",
+			data:          "sshd:\n  grace_period: soon\n",
+			expectedError: "Configuration valid: FAILED - yaml: unmarshal errors:\n  line 3: cannot unmarshal !!str `soon` into time.Duration",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			cmd := &Command{RootDir: writeConfig(t, tc.data), ReadWriter: &readwriter.ReadWriter{Out: out}}
+
+			_, err := cmd.Execute(context.Background())
+			if tc.expectedError != "" {
+				require.EqualError(t, err, tc.expectedError)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.expectedOutput, out.String())
+		})
+	}
+}
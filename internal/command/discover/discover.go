@@ -7,8 +7,11 @@ import (
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/language"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/motd"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/discover"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/i18n"
 )
 
 type Command struct {
@@ -20,18 +23,22 @@ type Command struct {
 func (c *Command) Execute(ctx context.Context) (context.Context, error) {
 	response, err := c.getUserInfo(ctx)
 	if err != nil {
-		return ctx, fmt.Errorf("Failed to get username: %v", err)
+		return ctx, i18n.Errorf(language.Select(c.Config, c.Args.Env, ""), "Failed to get username: %v", err)
 	}
 
+	lang := language.Select(c.Config, c.Args.Env, response.PreferredLanguage)
+
 	logData := command.LogData{}
 	if response.IsAnonymous() {
 		logData.Username = "Anonymous"
-		fmt.Fprintf(c.ReadWriter.Out, "Welcome to GitLab, Anonymous!\n")
+		fmt.Fprintln(c.ReadWriter.Out, i18n.Text(lang, "Welcome to GitLab, Anonymous!"))
 	} else {
 		logData.Username = response.Username
-		fmt.Fprintf(c.ReadWriter.Out, "Welcome to GitLab, @%s!\n", response.Username)
+		fmt.Fprintln(c.ReadWriter.Out, i18n.Sprintf(lang, "Welcome to GitLab, @%s!", response.Username))
 	}
 
+	motd.Display(ctx, c.Config, response.Username, c.ReadWriter.ErrOut)
+
 	ctxWithLogData := context.WithValue(ctx, "logData", logData)
 
 	return ctxWithLogData, nil
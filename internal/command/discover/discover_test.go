@@ -16,6 +16,7 @@ import (
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
 )
 
 var requests = []testserver.TestRequestHandler{
@@ -29,6 +30,14 @@ var requests = []testserver.TestRequestHandler{
 					"name":     "Alex Doe",
 				}
 				json.NewEncoder(w).Encode(body)
+			} else if r.URL.Query().Get("username") == "jo-doe" {
+				body := map[string]interface{}{
+					"id":                 3,
+					"username":           "jo-doe",
+					"name":               "Jo Doe",
+					"preferred_language": "de",
+				}
+				json.NewEncoder(w).Encode(body)
 			} else if r.URL.Query().Get("username") == "broken_message" {
 				body := map[string]string{
 					"message": "Forbidden!",
@@ -95,6 +104,56 @@ func TestExecute(t *testing.T) {
 	}
 }
 
+func TestExecuteInLanguage(t *testing.T) {
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	testCases := []struct {
+		desc           string
+		arguments      *commandargs.Shell
+		language       config.LanguageConfig
+		expectedOutput string
+	}{
+		{
+			desc:           "With the preferred language of the user",
+			arguments:      &commandargs.Shell{GitlabUsername: "jo-doe"},
+			expectedOutput: "Willkommen bei GitLab, @jo-doe!\n",
+		},
+		{
+			desc:           "With the language of the client",
+			arguments:      &commandargs.Shell{GitlabUsername: "jo-doe", Env: sshenv.Env{Language: "es"}},
+			expectedOutput: "¡Bienvenido a GitLab, @jo-doe!\n",
+		},
+		{
+			desc:           "With the default language",
+			arguments:      &commandargs.Shell{GitlabUsername: "alex-doe"},
+			language:       config.LanguageConfig{Default: "fr_FR"},
+			expectedOutput: "Bienvenue sur GitLab, @alex-doe !\n",
+		},
+		{
+			desc:           "With English asked for by the client",
+			arguments:      &commandargs.Shell{GitlabUsername: "jo-doe", Env: sshenv.Env{Language: "en"}},
+			language:       config.LanguageConfig{Default: "fr"},
+			expectedOutput: "Welcome to GitLab, @jo-doe!\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			buffer := &bytes.Buffer{}
+			cmd := &Command{
+				Config:     &config.Config{GitlabUrl: url, Language: tc.language},
+				Args:       tc.arguments,
+				ReadWriter: &readwriter.ReadWriter{Out: buffer},
+			}
+
+			_, err := cmd.Execute(context.Background())
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedOutput, buffer.String())
+		})
+	}
+}
+
 func TestFailingExecute(t *testing.T) {
 	url := testserver.StartSocketHttpServer(t, requests)
 
@@ -0,0 +1,203 @@
+// Package dryrun reports what gitlab-shell would do for a command, to debug
+// permission issues: the access check of git commands is performed, but no
+// Gitaly RPC is made, nor any data transferred.
+package dryrun
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+
+	pb "gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disallowedcommand"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
+)
+
+const (
+	// Flag enables the dry-run mode of gitlab-shell
+	Flag = "--dry-run"
+	// TraceEnv enables the dry-run mode of gitlab-shell when set to 1
+	TraceEnv = "GITLAB_SHELL_TRACE"
+)
+
+// gitCommands are the commands whose access check and Gitaly RPC are
+// reported
+var gitCommands = []commandargs.CommandType{commandargs.UploadPack, commandargs.ReceivePack, commandargs.UploadArchive}
+
+// Enabled removes Flag from arguments, and reports whether the dry-run mode
+// is enabled, by Flag or by TraceEnv as returned by getenv
+func Enabled(arguments []string, getenv func(string) string) ([]string, bool) {
+	i := slices.Index(arguments, Flag)
+	if i < 0 {
+		return arguments, getenv(TraceEnv) == "1"
+	}
+
+	return slices.Delete(slices.Clone(arguments), i, i+1), true
+}
+
+// Report is written as JSON by Command
+type Report struct {
+	Command   string   `json:"command"`
+	Arguments []string `json:"arguments,omitempty"`
+	// Who is the key, username or Kerberos principal the command runs as
+	Who        string `json:"who,omitempty"`
+	Repository string `json:"repository,omitempty"`
+	// Checked is false for the commands whose access isn't checked by the
+	// dry run
+	Checked bool `json:"checked"`
+	Allowed bool `json:"allowed"`
+	// Message is the reason for denying access
+	Message      string        `json:"message,omitempty"`
+	Access       *Access       `json:"access,omitempty"`
+	Gitaly       *GitalyRPC    `json:"gitaly,omitempty"`
+	CustomAction *CustomAction `json:"custom_action,omitempty"`
+}
+
+// Access is what the internal API allowed
+type Access struct {
+	Username         string   `json:"username,omitempty"`
+	UserID           string   `json:"gl_id,omitempty"`
+	GlRepository     string   `json:"gl_repository,omitempty"`
+	ProjectID        int      `json:"project_id,omitempty"`
+	GitConfigOptions []string `json:"git_config_options,omitempty"`
+	GitProtocol      string   `json:"git_protocol,omitempty"`
+}
+
+// GitalyRPC is the RPC that would be made, without the token it's made with
+type GitalyRPC struct {
+	Method        string            `json:"method"`
+	Address       string            `json:"address"`
+	Storage       string            `json:"storage"`
+	RelativePath  string            `json:"relative_path"`
+	GlProjectPath string            `json:"gl_project_path,omitempty"`
+	Features      map[string]string `json:"features,omitempty"`
+	// ObjectDirectory and AlternateObjectDirectories are those of the
+	// quarantine and object pool of the repository
+	ObjectDirectory            string   `json:"object_directory,omitempty"`
+	AlternateObjectDirectories []string `json:"alternate_object_directories,omitempty"`
+	GitConfigOptions           []string `json:"git_config_options,omitempty"`
+}
+
+// CustomAction is the action, such as a Geo proxy to the primary site,
+// performed instead of a Gitaly RPC
+type CustomAction struct {
+	Action       string   `json:"action"`
+	APIEndpoints []string `json:"api_endpoints,omitempty"`
+	PrimaryRepo  string   `json:"primary_repo,omitempty"`
+}
+
+// Command writes the Report of the command of its Args
+type Command struct {
+	Config     *config.Config
+	Args       *commandargs.Shell
+	ReadWriter *readwriter.ReadWriter
+}
+
+// Execute checks the access to the repository of a git command, and writes
+// the report
+func (c *Command) Execute(ctx context.Context) (context.Context, error) {
+	report := &Report{
+		Command:   string(c.Args.CommandType),
+		Arguments: c.Args.SshArgs,
+		Who:       c.Args.Who(),
+	}
+
+	if slices.Contains(gitCommands, c.Args.CommandType) {
+		if len(c.Args.SshArgs) != 2 {
+			return ctx, disallowedcommand.Error
+		}
+
+		if err := c.check(ctx, report); err != nil {
+			return ctx, err
+		}
+	}
+
+	encoder := json.NewEncoder(c.ReadWriter.Out)
+	encoder.SetIndent("", "  ")
+
+	return ctx, encoder.Encode(report)
+}
+
+func (c *Command) check(ctx context.Context, report *Report) error {
+	report.Repository = c.Args.SshArgs[1]
+	report.Checked = true
+
+	verifier, err := accessverifier.NewClient(c.Config)
+	if err != nil {
+		return err
+	}
+
+	response, err := verifier.Verify(ctx, c.Args, c.Args.CommandType, report.Repository)
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) {
+		// Access denied, or an error of the internal API
+		report.Message = apiErr.Msg
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !response.Success {
+		report.Message = response.Message
+		return nil
+	}
+
+	report.Allowed = true
+	report.Access = &Access{
+		Username:         response.Username,
+		UserID:           response.UserID,
+		GlRepository:     response.Repo,
+		ProjectID:        response.ProjectID,
+		GitConfigOptions: response.GitConfigOptions,
+		GitProtocol:      c.Args.Env.GitProtocolVersion,
+	}
+
+	if response.IsCustomAction() {
+		report.CustomAction = &CustomAction{
+			Action:       response.Payload.Action,
+			APIEndpoints: response.Payload.Data.APIEndpoints,
+			PrimaryRepo:  response.Payload.Data.PrimaryRepo,
+		}
+
+		return nil
+	}
+
+	repo := response.Gitaly.Repository()
+	report.Gitaly = &GitalyRPC{
+		Method:                     method(c.Args.CommandType, &response.Gitaly),
+		Address:                    response.Gitaly.Address,
+		Storage:                    repo.StorageName,
+		RelativePath:               repo.RelativePath,
+		GlProjectPath:              repo.GlProjectPath,
+		Features:                   response.Gitaly.Features,
+		ObjectDirectory:            repo.GitObjectDirectory,
+		AlternateObjectDirectories: repo.GitAlternateObjectDirectories,
+	}
+	if c.Args.CommandType == commandargs.UploadPack {
+		report.Gitaly.GitConfigOptions = response.UploadPackConfigOptions()
+	}
+
+	return nil
+}
+
+// method returns the Gitaly RPC made for commandType
+func method(commandType commandargs.CommandType, gitaly *accessverifier.Gitaly) string {
+	switch commandType {
+	case commandargs.UploadPack:
+		if gitaly.FeatureEnabled(accessverifier.FeatureUploadPackSidechannel, true) {
+			return pb.SSHService_SSHUploadPackWithSidechannel_FullMethodName
+		}
+		return pb.SSHService_SSHUploadPack_FullMethodName
+	case commandargs.ReceivePack:
+		return pb.SSHService_SSHReceivePack_FullMethodName
+	default:
+		return pb.SSHService_SSHUploadArchive_FullMethodName
+	}
+}
@@ -0,0 +1,145 @@
+package dryrun
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/testhelper/requesthandlers"
+)
+
+func TestEnabled(t *testing.T) {
+	noEnv := func(string) string { return "" }
+	trace := func(name string) string {
+		if name == TraceEnv {
+			return "1"
+		}
+		return ""
+	}
+
+	arguments, enabled := Enabled([]string{"--dry-run", "key-1"}, noEnv)
+	require.True(t, enabled)
+	require.Equal(t, []string{"key-1"}, arguments)
+
+	arguments, enabled = Enabled([]string{"key-1"}, trace)
+	require.True(t, enabled)
+	require.Equal(t, []string{"key-1"}, arguments)
+
+	arguments, enabled = Enabled([]string{"key-1"}, noEnv)
+	require.False(t, enabled)
+	require.Equal(t, []string{"key-1"}, arguments)
+}
+
+func TestExecute(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		command  commandargs.CommandType
+		requests []testserver.TestRequestHandler
+		expected Report
+	}{
+		{
+			desc:     "allowed upload-pack",
+			command:  commandargs.UploadPack,
+			requests: requesthandlers.BuildAllowedWithGitalyHandlers(t, "unix:gitaly.socket"),
+			expected: Report{
+				Command:    "git-upload-pack",
+				Arguments:  []string{"git-upload-pack", "group/repo"},
+				Who:        "key-1",
+				Repository: "group/repo",
+				Checked:    true,
+				Allowed:    true,
+				Access: &Access{
+					Username: "alex-doe",
+					UserID:   "1",
+				},
+				Gitaly: &GitalyRPC{
+					Method:        "/gitaly.SSHService/SSHUploadPackWithSidechannel",
+					Address:       "unix:gitaly.socket",
+					Storage:       "storage_name",
+					RelativePath:  "relative_path",
+					GlProjectPath: "group/project-path",
+					Features: map[string]string{
+						"gitaly-feature-cache_invalidator":        "true",
+						"gitaly-feature-inforef_uploadpack_cache": "false",
+						"some-other-ff": "true",
+					},
+					ObjectDirectory:            "path/to/git_object_directory",
+					AlternateObjectDirectories: []string{"path/to/git_alternate_object_directory"},
+				},
+			},
+		},
+		{
+			desc:     "denied receive-pack",
+			command:  commandargs.ReceivePack,
+			requests: requesthandlers.BuildDisallowedByAPIHandlers(t),
+			expected: Report{
+				Command:    "git-receive-pack",
+				Arguments:  []string{"git-receive-pack", "group/repo"},
+				Who:        "key-1",
+				Repository: "group/repo",
+				Checked:    true,
+				Message:    "Disallowed by API call",
+			},
+		},
+		{
+			desc:     "proxied push",
+			command:  commandargs.ReceivePack,
+			requests: requesthandlers.BuildAllowedWithCustomActionsHandlers(t),
+			expected: Report{
+				Command:    "git-receive-pack",
+				Arguments:  []string{"git-receive-pack", "group/repo"},
+				Who:        "key-1",
+				Repository: "group/repo",
+				Checked:    true,
+				Allowed:    true,
+				Access:     &Access{UserID: "1"},
+				CustomAction: &CustomAction{
+					Action:       "geo_proxy_to_primary",
+					APIEndpoints: []string{"/geo/proxy/info_refs", "/geo/proxy/push"},
+					PrimaryRepo:  "https://repo/path",
+				},
+			},
+		},
+		{
+			desc:    "unchecked command",
+			command: commandargs.Discover,
+			expected: Report{
+				Command: "discover",
+				Who:     "key-1",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			url := testserver.StartHttpServer(t, tc.requests)
+
+			args := &commandargs.Shell{GitlabKeyId: "1", CommandType: tc.command}
+			if tc.command != commandargs.Discover {
+				args.SshArgs = []string{string(tc.command), "group/repo"}
+			}
+
+			output := &bytes.Buffer{}
+			cmd := &Command{
+				Config:     &config.Config{GitlabUrl: url},
+				Args:       args,
+				ReadWriter: &readwriter.ReadWriter{Out: output},
+			}
+
+			_, err := cmd.Execute(context.Background())
+			require.NoError(t, err)
+
+			var report Report
+			require.NoError(t, json.Unmarshal(output.Bytes(), &report))
+			require.Equal(t, tc.expected, report)
+			require.NotContains(t, output.String(), `"token"`)
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package githttp
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/console"
+)
+
+// displayProxyMessage tells the user that their request is served by the Geo
+// primary site rather than the secondary they connected to, which accounts
+// for the additional latency
+func displayProxyMessage(cfg *config.Config, out io.Writer, primaryRepo string) {
+	if cfg.Geo.HideProxyMessage {
+		return
+	}
+
+	message := cfg.Geo.ProxyMessage
+	if message == "" {
+		message = fmt.Sprintf("This request to a Geo secondary site is proxied to the primary site%s, which may take longer.", primaryHost(primaryRepo))
+	}
+
+	console.DisplayInfoMessage(message, out)
+}
+
+// primaryHost returns the host of the primary repository to name in messages,
+// never its credentials or path
+func primaryHost(primaryRepo string) string {
+	u, err := url.Parse(primaryRepo)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	return " at " + u.Host
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/bufferpool"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
@@ -40,6 +41,8 @@ func (c *PullCommand) Execute(ctx context.Context) error {
 	data := c.Response.Payload.Data
 	client := &git.Client{URL: data.PrimaryRepo, Headers: data.RequestHeaders}
 
+	displayProxyMessage(c.Config, c.ReadWriter.ErrOut, data.PrimaryRepo)
+
 	// For Git over SSH routing
 	if data.GeoProxyFetchSSHDirectToPrimary {
 		log.ContextLogger(ctx).Info("Using Git over SSH upload pack")
@@ -70,7 +73,7 @@ func (c *PullCommand) requestInfoRefs(ctx context.Context, client *git.Client) e
 		return fmt.Errorf("Unexpected git-upload-pack response")
 	}
 
-	_, err = io.Copy(c.ReadWriter.Out, response.Body)
+	_, err = bufferpool.Copy(c.ReadWriter.Out, response.Body)
 
 	return err
 }
@@ -82,7 +85,7 @@ func (c *PullCommand) requestSSHUploadPack(ctx context.Context, client *git.Clie
 	}
 	defer response.Body.Close()
 
-	_, err = io.Copy(c.ReadWriter.Out, response.Body)
+	_, err = bufferpool.Copy(c.ReadWriter.Out, response.Body)
 
 	return err
 }
@@ -97,7 +100,7 @@ func (c *PullCommand) requestUploadPack(ctx context.Context, client *git.Client,
 	}
 	defer response.Body.Close()
 
-	_, err = io.Copy(c.ReadWriter.Out, response.Body)
+	_, err = bufferpool.Copy(c.ReadWriter.Out, response.Body)
 
 	return err
 }
@@ -29,7 +29,7 @@ func TestPullExecute(t *testing.T) {
 
 	cmd := &PullCommand{
 		Config:     &config.Config{GitlabUrl: url},
-		ReadWriter: &readwriter.ReadWriter{Out: output, In: input},
+		ReadWriter: &readwriter.ReadWriter{Out: output, ErrOut: io.Discard, In: input},
 		Response: &accessverifier.Response{
 			Payload: accessverifier.CustomPayload{
 				Data: accessverifier.CustomPayloadData{PrimaryRepo: url},
@@ -48,7 +48,7 @@ func TestPullExecuteWithDepth(t *testing.T) {
 
 	cmd := &PullCommand{
 		Config:     &config.Config{GitlabUrl: url},
-		ReadWriter: &readwriter.ReadWriter{Out: output, In: input},
+		ReadWriter: &readwriter.ReadWriter{Out: output, ErrOut: io.Discard, In: input},
 		Response: &accessverifier.Response{
 			Payload: accessverifier.CustomPayload{
 				Data: accessverifier.CustomPayloadData{PrimaryRepo: url, GeoProxyFetchDirectToPrimaryWithOptions: true},
@@ -67,7 +67,7 @@ func TestPullExecuteWithSSHUploadPack(t *testing.T) {
 
 	cmd := &PullCommand{
 		Config:     &config.Config{GitlabUrl: url},
-		ReadWriter: &readwriter.ReadWriter{Out: output, In: input},
+		ReadWriter: &readwriter.ReadWriter{Out: output, ErrOut: io.Discard, In: input},
 		Response: &accessverifier.Response{
 			Payload: accessverifier.CustomPayload{
 				Data: accessverifier.CustomPayloadData{
@@ -125,7 +125,8 @@ func TestPullExecuteWithFailedInfoRefs(t *testing.T) {
 			url := testserver.StartHttpServer(t, requests)
 
 			cmd := &PullCommand{
-				Config: &config.Config{GitlabUrl: url},
+				Config:     &config.Config{GitlabUrl: url},
+				ReadWriter: &readwriter.ReadWriter{ErrOut: io.Discard},
 				Response: &accessverifier.Response{
 					Payload: accessverifier.CustomPayload{
 						Data: accessverifier.CustomPayloadData{PrimaryRepo: url},
@@ -147,7 +148,7 @@ func TestExecuteWithFailedUploadPack(t *testing.T) {
 
 	cmd := &PullCommand{
 		Config:     &config.Config{GitlabUrl: url},
-		ReadWriter: &readwriter.ReadWriter{Out: output, In: input},
+		ReadWriter: &readwriter.ReadWriter{Out: output, ErrOut: io.Discard, In: input},
 		Response: &accessverifier.Response{
 			Payload: accessverifier.CustomPayload{
 				Data: accessverifier.CustomPayloadData{PrimaryRepo: url},
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/bufferpool"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
@@ -39,6 +40,8 @@ func (c *PushCommand) Execute(ctx context.Context) error {
 	data := c.Response.Payload.Data
 	client := &git.Client{URL: data.PrimaryRepo, Headers: data.RequestHeaders}
 
+	displayProxyMessage(c.Config, c.ReadWriter.ErrOut, data.PrimaryRepo)
+
 	// For Git over SSH routing
 	if data.GeoProxyPushSSHDirectToPrimary {
 		log.ContextLogger(ctx).Info("Using Git over SSH receive pack")
@@ -69,7 +72,7 @@ func (c *PushCommand) requestInfoRefs(ctx context.Context, client *git.Client) e
 		return fmt.Errorf("Unexpected git-receive-pack response")
 	}
 
-	_, err = io.Copy(c.ReadWriter.Out, response.Body)
+	_, err = bufferpool.Copy(c.ReadWriter.Out, response.Body)
 
 	return err
 }
@@ -81,7 +84,7 @@ func (c *PushCommand) requestSSHReceivePack(ctx context.Context, client *git.Cli
 	}
 	defer response.Body.Close()
 
-	_, err = io.Copy(c.ReadWriter.Out, response.Body)
+	_, err = bufferpool.Copy(c.ReadWriter.Out, response.Body)
 
 	return err
 }
@@ -96,7 +99,7 @@ func (c *PushCommand) requestReceivePack(ctx context.Context, client *git.Client
 	}
 	defer response.Body.Close()
 
-	_, err = io.Copy(c.ReadWriter.Out, response.Body)
+	_, err = bufferpool.Copy(c.ReadWriter.Out, response.Body)
 
 	return err
 }
@@ -119,7 +122,7 @@ func (c *PushCommand) readFromStdin(pw *io.PipeWriter) {
 	}
 
 	if needsPackData {
-		io.Copy(pw, c.ReadWriter.In)
+		bufferpool.Copy(pw, c.ReadWriter.In)
 	}
 
 	pw.Close()
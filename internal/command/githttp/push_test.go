@@ -31,7 +31,7 @@ func TestExecute(t *testing.T) {
 
 	cmd := &PushCommand{
 		Config:     &config.Config{GitlabUrl: url},
-		ReadWriter: &readwriter.ReadWriter{Out: output, In: input},
+		ReadWriter: &readwriter.ReadWriter{Out: output, ErrOut: io.Discard, In: input},
 		Response: &accessverifier.Response{
 			Payload: accessverifier.CustomPayload{
 				Data: accessverifier.CustomPayloadData{PrimaryRepo: url},
@@ -84,7 +84,8 @@ func TestExecuteWithFailedInfoRefs(t *testing.T) {
 			url := testserver.StartHttpServer(t, requests)
 
 			cmd := &PushCommand{
-				Config: &config.Config{GitlabUrl: url},
+				Config:     &config.Config{GitlabUrl: url},
+				ReadWriter: &readwriter.ReadWriter{ErrOut: io.Discard},
 				Response: &accessverifier.Response{
 					Payload: accessverifier.CustomPayload{
 						Data: accessverifier.CustomPayloadData{PrimaryRepo: url},
@@ -105,7 +106,7 @@ func TestExecuteWithFailedReceivePack(t *testing.T) {
 
 	cmd := &PushCommand{
 		Config:     &config.Config{GitlabUrl: url},
-		ReadWriter: &readwriter.ReadWriter{Out: output, In: input},
+		ReadWriter: &readwriter.ReadWriter{Out: output, ErrOut: io.Discard, In: input},
 		Response: &accessverifier.Response{
 			Payload: accessverifier.CustomPayload{
 				Data: accessverifier.CustomPayloadData{PrimaryRepo: url},
@@ -125,7 +126,7 @@ func TestPushExecuteWithSSHReceivePack(t *testing.T) {
 
 	cmd := &PushCommand{
 		Config:     &config.Config{GitlabUrl: url},
-		ReadWriter: &readwriter.ReadWriter{Out: output, In: input},
+		ReadWriter: &readwriter.ReadWriter{Out: output, ErrOut: io.Discard, In: input},
 		Response: &accessverifier.Response{
 			Payload: accessverifier.CustomPayload{
 				Data: accessverifier.CustomPayloadData{
@@ -206,3 +207,45 @@ func setupSSHPush(t *testing.T, uploadPackStatusCode int) string {
 
 	return testserver.StartHttpServer(t, requests)
 }
+
+func TestProxyMessage(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		geo      config.GeoConfig
+		expected string
+	}{
+		{
+			desc:     "default message",
+			expected: "remote: \nremote: This request to a Geo secondary site is proxied to the primary site at HOST, which may take longer.\nremote: \n",
+		},
+		{
+			desc:     "custom message",
+			geo:      config.GeoConfig{ProxyMessage: "Pushes are served by the primary site in Europe"},
+			expected: "remote: \nremote: Pushes are served by the primary site in Europe\nremote: \n",
+		},
+		{
+			desc: "hidden message",
+			geo:  config.GeoConfig{HideProxyMessage: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			url, input := setup(t, http.StatusOK)
+			errOutput := &bytes.Buffer{}
+
+			cmd := &PushCommand{
+				Config:     &config.Config{GitlabUrl: url, Geo: tc.geo},
+				ReadWriter: &readwriter.ReadWriter{Out: io.Discard, ErrOut: errOutput, In: input},
+				Response: &accessverifier.Response{
+					Payload: accessverifier.CustomPayload{
+						Data: accessverifier.CustomPayloadData{PrimaryRepo: url},
+					},
+				},
+			}
+
+			require.NoError(t, cmd.Execute(context.Background()))
+			require.Equal(t, strings.ReplaceAll(tc.expected, "HOST", strings.TrimPrefix(url, "http://")), errOutput.String())
+		})
+	}
+}
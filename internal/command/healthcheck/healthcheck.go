@@ -20,7 +20,7 @@ type Command struct {
 }
 
 func (c *Command) Execute(ctx context.Context) (context.Context, error) {
-	response, err := c.runCheck(ctx)
+	response, err := runCheck(ctx, c.Config)
 	if err != nil {
 		return ctx, fmt.Errorf("%v: FAILED - %v", apiMessage, err)
 	}
@@ -35,8 +35,8 @@ func (c *Command) Execute(ctx context.Context) (context.Context, error) {
 	return ctx, nil
 }
 
-func (c *Command) runCheck(ctx context.Context) (*healthcheck.Response, error) {
-	client, err := healthcheck.NewClient(c.Config)
+func runCheck(ctx context.Context, cfg *config.Config) (*healthcheck.Response, error) {
+	client, err := healthcheck.NewClient(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,263 @@
+package healthcheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+	grpccodes "google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	grpcstatus "google.golang.org/grpc/status"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitaly"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+// The names of the checks run by WatchCommand. The Gitaly servers are
+// checked as "gitaly:ADDRESS".
+const (
+	checkAPI       = "internal_api"
+	checkSecret    = "secret"
+	checkRedis     = "redis"
+	checkClockSkew = "clock_skew"
+	checkGitaly    = "gitaly"
+)
+
+// CheckResult is the outcome of a check
+type CheckResult struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Status is the outcome of a round of checks, as written to the status file
+type Status struct {
+	CheckedAt time.Time `json:"checked_at"`
+	Healthy   bool      `json:"healthy"`
+	// ClockSkewSeconds is how far the local clock is ahead of the clock of
+	// the internal API, when it could be measured
+	ClockSkewSeconds *float64      `json:"clock_skew_s,omitempty"`
+	Checks           []CheckResult `json:"checks"`
+}
+
+// WatchCommand checks in a loop, until its context is canceled, that the
+// internal API is reachable and accepts the secret, that the clocks agree and
+// that the Gitaly servers of the configuration serve. The results are written
+// to the status file and exported as Prometheus metrics.
+type WatchCommand struct {
+	Config *config.Config
+}
+
+func (c *WatchCommand) Execute(ctx context.Context) (context.Context, error) {
+	ticker := time.NewTicker(durationOrDefault(c.Config.SelfCheck.Interval, config.DefaultSelfCheckConfig.Interval))
+	defer ticker.Stop()
+
+	for {
+		status := c.run(ctx)
+		// A round cut short by the end of the watch isn't reported
+		if ctx.Err() != nil {
+			return ctx, nil
+		}
+
+		c.report(ctx, status)
+
+		select {
+		case <-ctx.Done():
+			return ctx, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// run runs a round of checks
+func (c *WatchCommand) run(ctx context.Context) Status {
+	timeout := durationOrDefault(c.Config.SelfCheck.Timeout, config.DefaultSelfCheckConfig.Timeout)
+
+	status := Status{CheckedAt: time.Now(), Healthy: true}
+	status.Checks, status.ClockSkewSeconds = c.checkAPI(ctx, timeout)
+
+	for _, server := range c.Config.SelfCheck.Gitaly {
+		status.Checks = append(status.Checks, c.checkGitaly(ctx, timeout, server))
+	}
+
+	for _, result := range status.Checks {
+		status.Healthy = status.Healthy && result.Healthy
+	}
+
+	return status
+}
+
+// checkAPI requests the health check endpoint of the internal API, which
+// tells whether the API is reachable, accepts the secret and reaches Redis,
+// and the clock skew from the Date of the response
+func (c *WatchCommand) checkAPI(ctx context.Context, timeout time.Duration) ([]CheckResult, *float64) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	sent := time.Now()
+	response, err := runCheck(ctx, c.Config)
+	received := time.Now()
+
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden) {
+		notChecked := errors.New("not checked, the secret is rejected")
+
+		return []CheckResult{
+			newCheckResult(checkAPI, nil),
+			newCheckResult(checkSecret, fmt.Errorf("rejected by the internal API: %w", err)),
+			newCheckResult(checkRedis, notChecked),
+			newCheckResult(checkClockSkew, notChecked),
+		}, nil
+	}
+
+	if err != nil {
+		return []CheckResult{
+			newCheckResult(checkAPI, err),
+			newCheckResult(checkSecret, err),
+			newCheckResult(checkRedis, err),
+			newCheckResult(checkClockSkew, err),
+		}, nil
+	}
+
+	var redisErr error
+	if !response.Redis {
+		redisErr = errors.New("unavailable to the internal API")
+	}
+
+	results := []CheckResult{
+		newCheckResult(checkAPI, nil),
+		newCheckResult(checkSecret, nil),
+		newCheckResult(checkRedis, redisErr),
+	}
+
+	if response.Date.IsZero() {
+		return append(results, newCheckResult(checkClockSkew, errors.New("the internal API sent no Date header"))), nil
+	}
+
+	// The Date of the response is taken between sending the request and
+	// receiving the response, and has a resolution of a second
+	skew := sent.Add(received.Sub(sent) / 2).Sub(response.Date)
+	maxSkew := durationOrDefault(c.Config.SelfCheck.MaxClockSkew, config.DefaultSelfCheckConfig.MaxClockSkew)
+
+	var skewErr error
+	if skew.Abs() > maxSkew+time.Second {
+		skewErr = fmt.Errorf("the clock is %s off the clock of the internal API", skew.Truncate(time.Second))
+	}
+
+	skewSeconds := skew.Seconds()
+
+	return append(results, newCheckResult(checkClockSkew, skewErr)), &skewSeconds
+}
+
+// checkGitaly asks a Gitaly server for its health, with the previous tokens
+// of the server if it refuses its token
+func (c *WatchCommand) checkGitaly(ctx context.Context, timeout time.Duration, server config.SelfCheckGitalyConfig) CheckResult {
+	name := checkGitaly + ":" + server.Address
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var err error
+	for _, token := range append([]string{server.Token}, server.PreviousTokens...) {
+		err = c.checkGitalyWithToken(ctx, server.Address, token)
+		if code := grpcstatus.Code(err); code != grpccodes.Unauthenticated && code != grpccodes.PermissionDenied {
+			break
+		}
+	}
+
+	return newCheckResult(name, err)
+}
+
+func (c *WatchCommand) checkGitalyWithToken(ctx context.Context, address, token string) error {
+	conn, err := c.Config.GitalyClient.GetConnection(ctx, gitaly.Command{Address: address, Token: token})
+	if err != nil {
+		return err
+	}
+
+	response, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err == nil && response.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		err = fmt.Errorf("status %s", response.GetStatus())
+	}
+
+	return err
+}
+
+// report exports status as metrics, and writes it to the status file
+func (c *WatchCommand) report(ctx context.Context, status Status) {
+	metrics.CheckLastRunTimestampSeconds.Set(float64(status.CheckedAt.Unix()))
+	if status.ClockSkewSeconds != nil {
+		metrics.CheckClockSkewSeconds.Set(*status.ClockSkewSeconds)
+	}
+
+	for _, result := range status.Checks {
+		healthy := 0.0
+		if result.Healthy {
+			healthy = 1
+		} else {
+			log.WithContextFields(ctx, log.Fields{"check": result.Name, "error": result.Error}).Warn("Check failed")
+		}
+
+		metrics.CheckHealthy.WithLabelValues(result.Name).Set(healthy)
+	}
+
+	if path := c.Config.SelfCheck.StatusFile; path != "" {
+		if err := writeStatusFile(path, status); err != nil {
+			log.WithContextFields(ctx, log.Fields{"status_file": path}).WithError(err).Warn("Failed to write the status file")
+		}
+	}
+}
+
+// writeStatusFile replaces the status file with status in one go, for it to
+// never be read half written
+func writeStatusFile(path string, status Status) error {
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	// #nosec G302 -- the status is meant to be read by other processes
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func newCheckResult(name string, err error) CheckResult {
+	if err != nil {
+		return CheckResult{Name: name, Error: err.Error()}
+	}
+
+	return CheckResult{Name: name, Healthy: true}
+}
+
+func durationOrDefault(d, defaultDuration config.YamlDuration) time.Duration {
+	if d <= 0 {
+		return time.Duration(defaultDuration)
+	}
+
+	return time.Duration(d)
+}
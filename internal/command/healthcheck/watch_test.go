@@ -0,0 +1,177 @@
+package healthcheck
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+func newWatchCommand(t *testing.T, handlers []testserver.TestRequestHandler, selfCheck config.SelfCheckConfig) *WatchCommand {
+	cfg := &config.Config{GitlabUrl: testserver.StartSocketHttpServer(t, handlers), SelfCheck: selfCheck}
+	cfg.GitalyClient.InitSidechannelRegistry(context.Background())
+	t.Cleanup(cfg.GitalyClient.Close)
+
+	return &WatchCommand{Config: cfg}
+}
+
+func checkResults(status Status) map[string]string {
+	results := make(map[string]string)
+	for _, result := range status.Checks {
+		results[result.Name] = result.Error
+		if result.Healthy {
+			results[result.Name] = "ok"
+		}
+	}
+
+	return results
+}
+
+func TestWatchChecks(t *testing.T) {
+	gitalyAddress, _ := testserver.StartGitalyServer(t, "tcp")
+
+	skewedHandlers := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/check",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+				json.NewEncoder(w).Encode(okResponse)
+			},
+		},
+	}
+
+	unauthorizedHandlers := buildTestHandlers(http.StatusUnauthorized, nil)
+
+	testCases := []struct {
+		desc     string
+		handlers []testserver.TestRequestHandler
+		gitaly   []config.SelfCheckGitalyConfig
+		healthy  bool
+		expected map[string]string
+	}{
+		{
+			desc:     "healthy",
+			handlers: okHandlers,
+			gitaly:   []config.SelfCheckGitalyConfig{{Address: gitalyAddress}},
+			healthy:  true,
+			expected: map[string]string{
+				"internal_api":            "ok",
+				"secret":                  "ok",
+				"redis":                   "ok",
+				"clock_skew":              "ok",
+				"gitaly:" + gitalyAddress: "ok",
+			},
+		},
+		{
+			desc:     "Redis unavailable",
+			handlers: badRedisHandlers,
+			expected: map[string]string{
+				"internal_api": "ok",
+				"secret":       "ok",
+				"redis":        "unavailable to the internal API",
+				"clock_skew":   "ok",
+			},
+		},
+		{
+			desc:     "secret rejected",
+			handlers: unauthorizedHandlers,
+			expected: map[string]string{
+				"internal_api": "ok",
+				"secret":       "rejected by the internal API: Internal API error (401)",
+				"redis":        "not checked, the secret is rejected",
+				"clock_skew":   "not checked, the secret is rejected",
+			},
+		},
+		{
+			desc:     "clock skew",
+			handlers: skewedHandlers,
+			expected: map[string]string{
+				"internal_api": "ok",
+				"secret":       "ok",
+				"redis":        "ok",
+				"clock_skew":   "the clock is 1h0m0s off the clock of the internal API",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cmd := newWatchCommand(t, tc.handlers, config.SelfCheckConfig{Gitaly: tc.gitaly})
+
+			status := cmd.run(context.Background())
+			require.Equal(t, tc.healthy, status.Healthy)
+			require.Equal(t, tc.expected, checkResults(status))
+		})
+	}
+}
+
+func TestWatchUnreachableAPI(t *testing.T) {
+	cmd := &WatchCommand{Config: &config.Config{GitlabUrl: "http+unix:///does/not/exist"}}
+
+	status := cmd.run(context.Background())
+	require.False(t, status.Healthy)
+	require.Nil(t, status.ClockSkewSeconds)
+
+	for _, result := range status.Checks {
+		require.False(t, result.Healthy, result.Name)
+		require.NotEmpty(t, result.Error, result.Name)
+	}
+}
+
+func TestWatchUnavailableGitaly(t *testing.T) {
+	address := "unix:" + filepath.Join(t.TempDir(), "gitaly.sock")
+	cmd := newWatchCommand(t, okHandlers, config.SelfCheckConfig{
+		Timeout: config.YamlDuration(100 * time.Millisecond),
+		Gitaly:  []config.SelfCheckGitalyConfig{{Address: address}},
+	})
+
+	status := cmd.run(context.Background())
+	require.False(t, status.Healthy)
+	require.NotEqual(t, "ok", checkResults(status)["gitaly:"+address])
+}
+
+func TestWatchExecute(t *testing.T) {
+	statusFile := filepath.Join(t.TempDir(), "status.json")
+	cmd := newWatchCommand(t, okHandlers, config.SelfCheckConfig{
+		Interval:   config.YamlDuration(10 * time.Millisecond),
+		StatusFile: statusFile,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := cmd.Execute(ctx)
+		require.NoError(t, err)
+	}()
+
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(statusFile)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+
+	data, err := os.ReadFile(statusFile)
+	require.NoError(t, err)
+
+	var status Status
+	require.NoError(t, json.Unmarshal(data, &status))
+	require.True(t, status.Healthy)
+	require.NotNil(t, status.ClockSkewSeconds)
+	require.Len(t, status.Checks, 4)
+
+	require.InDelta(t, 1, testutil.ToFloat64(metrics.CheckHealthy.WithLabelValues("secret")), 0.1)
+	require.InDelta(t, float64(status.CheckedAt.Unix()), testutil.ToFloat64(metrics.CheckLastRunTimestampSeconds), 1)
+}
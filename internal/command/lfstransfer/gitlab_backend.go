@@ -90,7 +90,7 @@ func (b *GitlabBackend) issueBatchArgs(op string, oid string, href string, heade
 		return args, err
 	}
 
-	h := hmac.New(sha256.New, []byte(b.config.Secret))
+	h := hmac.New(sha256.New, []byte(b.config.CurrentSecret()))
 	_, err = h.Write(dataBinary)
 	if err != nil {
 		return args, err
@@ -180,9 +180,7 @@ func (b *GitlabBackend) parseAndCheckBatchArgs(op, oid, id, token string) (href
 			message: "invalid token",
 		}
 	}
-	h := hmac.New(sha256.New, []byte(b.config.Secret))
-	h.Write(idBinary)
-	if !hmac.Equal(tokenBinary, h.Sum(nil)) {
+	if !validIDToken(b.config.AcceptedSecrets(), idBinary, tokenBinary) {
 		return "", nil, &errCustom{
 			err:     transfer.ErrForbidden,
 			message: "token hash mismatch",
@@ -213,6 +211,20 @@ func (b *GitlabBackend) parseAndCheckBatchArgs(op, oid, id, token string) (href
 	return idData.Href, idData.Headers, nil
 }
 
+// validIDToken tells whether token is the HMAC of id with one of secrets, so
+// that IDs issued before the secret was rotated stay valid
+func validIDToken(secrets []string, id, token []byte) bool {
+	for _, secret := range secrets {
+		h := hmac.New(sha256.New, []byte(secret))
+		h.Write(id)
+		if hmac.Equal(token, h.Sum(nil)) {
+			return true
+		}
+	}
+
+	return false
+}
+
 type uploadCloser struct{}
 
 func (c *uploadCloser) Close() error {
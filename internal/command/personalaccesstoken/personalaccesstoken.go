@@ -4,22 +4,28 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"gitlab.com/gitlab-org/labkit/log"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/language"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/personalaccesstoken"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/i18n"
 )
 
 const (
 	usageText         = "Usage: personal_access_token <name> <scope1[,scope2,...]> [ttl_days]"
 	expiresDateFormat = "2006-01-02"
+	defaultTTLDays    = 30
+	readerLimit       = 1024
 )
 
 type Command struct {
@@ -32,34 +38,67 @@ type Command struct {
 type tokenArgs struct {
 	Name        string
 	Scopes      []string
-	ExpiresDate string // Calculated, a TTL is passed from command-line.
+	TTLDays     int    // Passed from command-line, or the default.
+	ExpiresDate string // Calculated from the TTL.
+	explicitTTL bool
 }
 
 func (c *Command) Execute(ctx context.Context) (context.Context, error) {
-	err := c.parseTokenArgs()
+	client, err := personalaccesstoken.NewClient(c.Config)
 	if err != nil {
 		return ctx, err
 	}
 
+	lang := language.For(ctx, c.Config, c.Args)
+
+	if err := c.parseTokenArgs(lang); err != nil {
+		if policy, policyErr := client.GetPolicy(ctx, c.Args); policyErr == nil && len(policy.Scopes) > 0 {
+			return ctx, i18n.Errorf(lang, "%v. Available scopes: %s", err, strings.Join(policy.Scopes, ","))
+		}
+
+		return ctx, err
+	}
+
+	policy, err := client.GetPolicy(ctx, c.Args)
+	if errors.Is(err, personalaccesstoken.ErrPolicyUnsupported) {
+		log.ContextLogger(ctx).Debug("personalaccesstoken: execute: No policy, leaving the validation to the API")
+		policy = nil
+	} else if err != nil {
+		return ctx, err
+	}
+
+	if err := c.validateTokenArgs(lang, policy); err != nil {
+		return ctx, err
+	}
+
+	if c.Args.Env.Terminal.Interactive() && c.getUserAnswer(ctx, lang) != "yes" {
+		log.ContextLogger(ctx).Debug("personalaccesstoken: execute: User chose not to continue")
+		fmt.Fprintln(c.ReadWriter.Out, "\n"+i18n.Text(lang, "A personal access token has *not* been created."))
+
+		return ctx, nil
+	}
+
 	log.WithContextFields(ctx, log.Fields{
 		"token_args": c.TokenArgs,
 	}).Info("personalaccesstoken: execute: requesting token")
 
-	response, err := c.getPersonalAccessToken(ctx)
+	response, err := client.GetPersonalAccessToken(ctx, c.Args, c.TokenArgs.Name, &c.TokenArgs.Scopes, c.TokenArgs.ExpiresDate)
 	if err != nil {
 		return ctx, err
 	}
 
-	fmt.Fprint(c.ReadWriter.Out, "Token:   "+response.Token+"\n")
-	fmt.Fprint(c.ReadWriter.Out, "Scopes:  "+strings.Join(response.Scopes, ",")+"\n")
-	fmt.Fprint(c.ReadWriter.Out, "Expires: "+response.ExpiresAt+"\n")
+	fmt.Fprint(c.ReadWriter.Out, fields(lang, [][2]string{
+		{"Token:", response.Token},
+		{"Scopes:", strings.Join(response.Scopes, ",")},
+		{"Expires:", response.ExpiresAt},
+	}))
 
 	return ctx, nil
 }
 
-func (c *Command) parseTokenArgs() error {
+func (c *Command) parseTokenArgs(lang string) error {
 	if len(c.Args.SshArgs) < 3 || len(c.Args.SshArgs) > 4 {
-		return errors.New(usageText)
+		return errors.New(i18n.Text(lang, usageText))
 	}
 
 	var rectfiedScopes []string
@@ -79,26 +118,84 @@ func (c *Command) parseTokenArgs() error {
 	}
 
 	if len(c.Args.SshArgs) < 4 {
-		c.TokenArgs.ExpiresDate = time.Now().AddDate(0, 0, 30).Format(expiresDateFormat)
+		c.TokenArgs.TTLDays = defaultTTLDays
 		return nil
 	}
 	rawTTL := c.Args.SshArgs[3]
 
 	TTL, err := strconv.Atoi(rawTTL)
 	if err != nil || TTL < 0 {
-		return fmt.Errorf("Invalid value for days_ttl: '%s'", rawTTL)
+		return i18n.Errorf(lang, "Invalid value for days_ttl: '%s'", rawTTL)
 	}
 
-	c.TokenArgs.ExpiresDate = time.Now().AddDate(0, 0, TTL+1).Format(expiresDateFormat)
+	c.TokenArgs.TTLDays = TTL
+	c.TokenArgs.explicitTTL = true
 
 	return nil
 }
 
-func (c *Command) getPersonalAccessToken(ctx context.Context) (*personalaccesstoken.Response, error) {
-	client, err := personalaccesstoken.NewClient(c.Config)
-	if err != nil {
-		return nil, err
+// validateTokenArgs checks the requested scopes and TTL against the policy of
+// the instance, so that invalid requests fail with a helpful message rather
+// than the API's. Without a policy, only the expiry date is calculated.
+func (c *Command) validateTokenArgs(lang string, policy *personalaccesstoken.PolicyResponse) error {
+	if policy != nil {
+		for _, scope := range c.TokenArgs.Scopes {
+			if !slices.Contains(policy.Scopes, scope) {
+				return i18n.Errorf(lang, "Invalid scope: '%s'. Available scopes: %s", scope, strings.Join(policy.Scopes, ","))
+			}
+		}
+
+		maxTTL := policy.MaxLifetimeDays
+		if maxTTL > 0 && c.TokenArgs.TTLDays > maxTTL {
+			if c.TokenArgs.explicitTTL {
+				return i18n.Errorf(lang, "Invalid value for days_ttl: '%d'. The maximum is %d days", c.TokenArgs.TTLDays, maxTTL)
+			}
+
+			c.TokenArgs.TTLDays = maxTTL
+		}
+	}
+
+	// An explicit TTL counts the current day as well
+	days := c.TokenArgs.TTLDays
+	if c.TokenArgs.explicitTTL {
+		days++
+	}
+	c.TokenArgs.ExpiresDate = time.Now().AddDate(0, 0, days).Format(expiresDateFormat)
+
+	return nil
+}
+
+// getUserAnswer asks the user to confirm the token. It's only called when the
+// session has a terminal, so that scripts aren't left waiting for an answer.
+func (c *Command) getUserAnswer(ctx context.Context, lang string) string {
+	summary := fields(lang, [][2]string{
+		{"Name:", c.TokenArgs.Name},
+		{"Scopes:", strings.Join(c.TokenArgs.Scopes, ",")},
+		{"Expires:", c.TokenArgs.ExpiresDate},
+	}) + "\n" + i18n.Text(lang, "Are you sure you want to create this personal access token? (yes/no)")
+	fmt.Fprintln(c.ReadWriter.Out, summary)
+
+	var answer string
+	if _, err := fmt.Fscanln(io.LimitReader(c.ReadWriter.In, readerLimit), &answer); err != nil {
+		log.ContextLogger(ctx).WithError(err).Debug("personalaccesstoken: getUserAnswer: Failed to get user input")
+	}
+
+	return answer
+}
+
+// fields lines up the values of the labelled fields of a token, in lang
+func fields(lang string, labelled [][2]string) string {
+	labels := make([]string, len(labelled))
+	width := 0
+	for i, field := range labelled {
+		labels[i] = i18n.Text(lang, field[0])
+		width = max(width, utf8.RuneCountInString(labels[i]))
+	}
+
+	var b strings.Builder
+	for i, field := range labelled {
+		fmt.Fprintf(&b, "%-*s %s\n", width, labels[i], field[1])
 	}
 
-	return client.GetPersonalAccessToken(ctx, c.Args, c.TokenArgs.Name, &c.TokenArgs.Scopes, c.TokenArgs.ExpiresDate)
+	return b.String()
 }
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -16,12 +17,49 @@ import (
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/personalaccesstoken"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+	pkgsshenv "gitlab.com/gitlab-org/gitlab-shell/v14/pkg/sshenv"
 )
 
-var requests []testserver.TestRequestHandler
+var (
+	requests        []testserver.TestRequestHandler
+	availableScopes = []string{"api", "read_api", "read_repository", "read_reposotory"}
+)
 
 func setup(t *testing.T) {
 	requests = []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/personal_access_token/policy",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				var requestBody *personalaccesstoken.PolicyRequestBody
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&requestBody))
+
+				switch requestBody.KeyID {
+				case "legacy":
+					w.WriteHeader(http.StatusNotFound)
+				case "nopolicy":
+					body := map[string]interface{}{
+						"success": false,
+						"message": "Policy unavailable!",
+					}
+					json.NewEncoder(w).Encode(body)
+				case "shortlifetime":
+					body := map[string]interface{}{
+						"success":           true,
+						"scopes":            availableScopes,
+						"max_lifetime_days": 7,
+					}
+					json.NewEncoder(w).Encode(body)
+				default:
+					body := map[string]interface{}{
+						"success":           true,
+						"scopes":            availableScopes,
+						"max_lifetime_days": 365,
+					}
+					json.NewEncoder(w).Encode(body)
+				}
+			},
+		},
 		{
 			Path: "/api/v4/internal/personal_access_token",
 			Handler: func(w http.ResponseWriter, r *http.Request) {
@@ -75,6 +113,8 @@ const (
 	cmdname = "personal_access_token"
 )
 
+var terminal = sshenv.Env{Terminal: pkgsshenv.Terminal{TTY: "/dev/pts/0", Type: "xterm"}}
+
 func TestExecute(t *testing.T) {
 	setup(t)
 
@@ -92,6 +132,14 @@ func TestExecute(t *testing.T) {
 			arguments:     &commandargs.Shell{},
 			expectedError: usageText,
 		},
+		{
+			desc: "Without any arguments for a known user",
+			arguments: &commandargs.Shell{
+				GitlabKeyId: "default",
+				SshArgs:     []string{cmdname},
+			},
+			expectedError: usageText + ". Available scopes: api,read_api,read_repository,read_reposotory",
+		},
 		{
 			desc: "With too few arguments",
 			arguments: &commandargs.Shell{
@@ -139,7 +187,7 @@ func TestExecute(t *testing.T) {
 				GitlabKeyId: "badresponse",
 				SshArgs:     []string{cmdname, "newtoken", "read_api,read_repository"},
 			},
-			expectedError: "Parsing failed",
+			expectedError: "Parsing failed: /api/v4/internal/personal_access_token: empty response",
 		},
 		{
 			desc: "when API returns an error",
@@ -206,6 +254,25 @@ func TestExecute(t *testing.T) {
 			},
 			expectedError: "Invalid scope: 'read_reposotory'. Valid scopes are: [\"api\", \"create_runner\", \"k8s_proxy\", \"read_api\", \"read_registry\", \"read_repository\", \"read_user\", \"write_registry\", \"write_repository\"]",
 		},
+		{
+			desc:      "With a scope outside the policy",
+			PATConfig: config.PATConfig{AllowedScopes: []string{"read_api", "write_repository"}},
+			arguments: &commandargs.Shell{
+				GitlabKeyId: "default",
+				SshArgs:     []string{cmdname, "newtoken", "write_repository"},
+			},
+			expectedError: "Invalid scope: 'write_repository'. Available scopes: api,read_api,read_repository,read_reposotory",
+		},
+		{
+			desc: "When the instance has no policy",
+			arguments: &commandargs.Shell{
+				GitlabKeyId: "legacy",
+				SshArgs:     []string{cmdname, "newtoken", "sudo", "400"},
+			},
+			expectedOutput: "Token:   YXuxvUgCEmeePY3G1YAa\n" +
+				"Scopes:  sudo\n" +
+				"Expires: 9001-11-17\n",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -233,3 +300,175 @@ func TestExecute(t *testing.T) {
 		})
 	}
 }
+
+func TestPolicyValidation(t *testing.T) {
+	setup(t)
+
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	testCases := []struct {
+		desc           string
+		arguments      *commandargs.Shell
+		expectedScopes string
+		expectedDays   int
+		expectedError  string
+	}{
+		{
+			desc: "With the default ttl",
+			arguments: &commandargs.Shell{
+				GitlabKeyId: "default",
+				SshArgs:     []string{cmdname, "newtoken", "api"},
+			},
+			expectedScopes: "api",
+			expectedDays:   30,
+		},
+		{
+			desc: "With the maximum ttl",
+			arguments: &commandargs.Shell{
+				GitlabKeyId: "default",
+				SshArgs:     []string{cmdname, "newtoken", "api", "365"},
+			},
+			expectedScopes: "api",
+			expectedDays:   366,
+		},
+		{
+			desc: "With a ttl above the maximum",
+			arguments: &commandargs.Shell{
+				GitlabKeyId: "default",
+				SshArgs:     []string{cmdname, "newtoken", "api", "366"},
+			},
+			expectedError: "Invalid value for days_ttl: '366'. The maximum is 365 days",
+		},
+		{
+			desc: "With a default ttl above the maximum",
+			arguments: &commandargs.Shell{
+				GitlabKeyId: "shortlifetime",
+				SshArgs:     []string{cmdname, "newtoken", "read_api,read_repository"},
+			},
+			expectedScopes: "read_api,read_repository",
+			expectedDays:   7,
+		},
+		{
+			desc: "With an unknown scope",
+			arguments: &commandargs.Shell{
+				GitlabKeyId: "default",
+				SshArgs:     []string{cmdname, "newtoken", "api,sudo"},
+			},
+			expectedError: "Invalid scope: 'sudo'. Available scopes: api,read_api,read_repository,read_reposotory",
+		},
+		{
+			desc: "When the policy is unavailable",
+			arguments: &commandargs.Shell{
+				GitlabKeyId: "nopolicy",
+				SshArgs:     []string{cmdname, "newtoken", "api"},
+			},
+			expectedError: "Policy unavailable!",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			input := bytes.NewBufferString("no\n")
+			tc.arguments.Env = terminal
+
+			cmd := &Command{
+				Config:     &config.Config{GitlabUrl: url},
+				Args:       tc.arguments,
+				ReadWriter: &readwriter.ReadWriter{Out: output, In: input},
+			}
+
+			_, err := cmd.Execute(context.Background())
+
+			if tc.expectedError != "" {
+				require.EqualError(t, err, tc.expectedError)
+				require.Empty(t, output.String())
+				return
+			}
+
+			require.NoError(t, err)
+
+			expiresDate := time.Now().AddDate(0, 0, tc.expectedDays).Format(expiresDateFormat)
+			expectedOutput := "Name:    newtoken\n" +
+				"Scopes:  " + tc.expectedScopes + "\n" +
+				"Expires: " + expiresDate + "\n\n" +
+				"Are you sure you want to create this personal access token? (yes/no)\n" +
+				"\nA personal access token has *not* been created.\n"
+			require.Equal(t, expectedOutput, output.String())
+		})
+	}
+}
+
+func TestConfirmation(t *testing.T) {
+	setup(t)
+
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	testCases := []struct {
+		desc          string
+		env           sshenv.Env
+		input         string
+		expectedToken bool
+	}{
+		{desc: "When the user confirms", env: terminal, input: "yes\n", expectedToken: true},
+		{desc: "When the user declines", env: terminal, input: "no\n"},
+		{desc: "Without an answer", env: terminal, input: ""},
+		{desc: "Without a terminal", input: "", expectedToken: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			output := &bytes.Buffer{}
+
+			cmd := &Command{
+				Config: &config.Config{GitlabUrl: url},
+				Args: &commandargs.Shell{
+					GitlabKeyId: "default",
+					SshArgs:     []string{cmdname, "newtoken", "api"},
+					Env:         tc.env,
+				},
+				ReadWriter: &readwriter.ReadWriter{Out: output, In: bytes.NewBufferString(tc.input)},
+			}
+
+			_, err := cmd.Execute(context.Background())
+			require.NoError(t, err)
+
+			if tc.expectedToken {
+				require.Contains(t, output.String(), "Token:   YXuxvUgCEmeePY3G1YAa\n")
+				if !tc.env.Terminal.Interactive() {
+					require.NotContains(t, output.String(), "(yes/no)")
+				}
+			} else {
+				require.NotContains(t, output.String(), "Token:")
+				require.Contains(t, output.String(), "A personal access token has *not* been created.")
+			}
+		})
+	}
+}
+
+func TestExecuteInLanguage(t *testing.T) {
+	setup(t)
+
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	output := &bytes.Buffer{}
+	cmd := &Command{
+		Config: &config.Config{GitlabUrl: url, Language: config.LanguageConfig{Default: "de"}},
+		Args: &commandargs.Shell{
+			GitlabKeyId: "default",
+			SshArgs:     []string{cmdname, "newtoken", "api"},
+			Env:         terminal,
+		},
+		ReadWriter: &readwriter.ReadWriter{Out: output, In: bytes.NewBufferString("yes\n")},
+	}
+
+	_, err := cmd.Execute(context.Background())
+	require.NoError(t, err)
+	require.Contains(t, output.String(), "Bereiche: api\nLäuft ab: ")
+	require.Contains(t, output.String(), "Möchten Sie dieses persönliche Zugriffstoken wirklich erstellen? (yes/no)")
+	require.Contains(t, output.String(), "Token:    YXuxvUgCEmeePY3G1YAa\n")
+
+	cmd.Args.SshArgs = []string{cmdname, "newtoken", "api", "bad_ttl"}
+	_, err = cmd.Execute(context.Background())
+	require.EqualError(t, err, "Ungültiger Wert für days_ttl: 'bad_ttl'. Verfügbare Bereiche: api,read_api,read_repository,read_reposotory")
+}
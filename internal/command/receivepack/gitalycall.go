@@ -16,7 +16,7 @@ func (c *Command) performGitalyCall(ctx context.Context, response *accessverifie
 	gc := handler.NewGitalyCommand(c.Config, string(commandargs.ReceivePack), response)
 
 	request := &pb.SSHReceivePackRequest{
-		Repository:       &response.Gitaly.Repo,
+		Repository:       response.Gitaly.Repository(),
 		GlId:             response.Who,
 		GlRepository:     response.Repo,
 		GlUsername:       response.Username,
@@ -28,7 +28,7 @@ func (c *Command) performGitalyCall(ctx context.Context, response *accessverifie
 		ctx, cancel := gc.PrepareContext(ctx, request.Repository, c.Args.Env)
 		defer cancel()
 
-		rw := c.ReadWriter
+		rw := gc.ReadWriter(c.ReadWriter)
 		return client.ReceivePack(ctx, conn, rw.In, rw.Out, rw.ErrOut, request)
 	})
 }
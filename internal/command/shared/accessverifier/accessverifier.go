@@ -3,16 +3,26 @@ package accessverifier
 import (
 	"context"
 	"errors"
+	"net/netip"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/motd"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/console"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/ipfilter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+
+	"gitlab.com/gitlab-org/labkit/log"
 )
 
 type Response = accessverifier.Response
 
+// ErrIPNotAllowed is returned when the user may not connect from their IP
+var ErrIPNotAllowed = errors.New("Your IP address is not allowed to access this resource")
+
 type Command struct {
 	Config     *config.Config
 	Args       *commandargs.Shell
@@ -36,9 +46,40 @@ func (c *Command) Verify(ctx context.Context, action commandargs.CommandType, re
 		return nil, errors.New(response.Message)
 	}
 
+	logger.AddContextFields(ctx, log.Fields{"username": response.Username, "key_id": c.Args.GitlabKeyId})
+
+	if c.Config.Server.IPFilter.CheckUserRestrictions {
+		if err := checkAllowedIPs(response.AllowedIPs, c.Args.Env.RemoteAddr); err != nil {
+			return nil, err
+		}
+	}
+
+	motd.Display(ctx, c.Config, response.Username, c.ReadWriter.ErrOut)
+
 	return response, nil
 }
 
+// checkAllowedIPs refuses remoteAddr if it's outside of the IPs a user is
+// restricted to. The internal API checks the IP too, but the restrictions of
+// the user may be stricter than those it knows of.
+func checkAllowedIPs(allowedIPs []string, remoteAddr string) error {
+	if len(allowedIPs) == 0 {
+		return nil
+	}
+
+	prefixes, err := ipfilter.ParsePrefixes(allowedIPs)
+	if err != nil {
+		return err
+	}
+
+	addr, err := netip.ParseAddr(gitlabnet.ParseIP(remoteAddr))
+	if err != nil || !ipfilter.Contains(prefixes, addr) {
+		return ErrIPNotAllowed
+	}
+
+	return nil
+}
+
 func (c *Command) displayConsoleMessages(messages []string) {
 	console.DisplayInfoMessages(messages, c.ReadWriter.ErrOut)
 }
@@ -34,7 +34,13 @@ func setup(t *testing.T) (*Command, *bytes.Buffer, *bytes.Buffer) {
 				err = json.Unmarshal(b, &requestBody)
 				require.NoError(t, err)
 
-				if requestBody.KeyID == "1" {
+				if requestBody.KeyID == "3" {
+					body := map[string]interface{}{
+						"status":      true,
+						"allowed_ips": []string{"192.0.2.0/24", "2001:db8::1"},
+					}
+					require.NoError(t, json.NewEncoder(w).Encode(body))
+				} else if requestBody.KeyID == "1" {
 					body := map[string]interface{}{
 						"gl_console_messages": []string{"console", "message"},
 					}
@@ -79,3 +85,30 @@ func TestConsoleMessages(t *testing.T) {
 	require.Equal(t, "remote: \nremote: console\nremote: message\nremote: \n", errBuf.String())
 	require.Empty(t, outBuf.String())
 }
+
+func TestAllowedIPs(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		remoteAddr    string
+		check         bool
+		expectedError error
+	}{
+		{desc: "allowed IP", remoteAddr: "192.0.2.10:22022", check: true},
+		{desc: "allowed single IP", remoteAddr: "[2001:db8::1]:22022", check: true},
+		{desc: "other IP", remoteAddr: "198.51.100.1:22022", check: true, expectedError: ErrIPNotAllowed},
+		{desc: "other IP without the check", remoteAddr: "198.51.100.1:22022"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cmd, _, _ := setup(t)
+			cmd.Config.Server.IPFilter.CheckUserRestrictions = tc.check
+
+			cmd.Args = &commandargs.Shell{GitlabKeyId: "3"}
+			cmd.Args.Env.RemoteAddr = tc.remoteAddr
+			_, err := cmd.Verify(context.Background(), action, repo)
+
+			require.ErrorIs(t, err, tc.expectedError)
+		})
+	}
+}
@@ -0,0 +1,208 @@
+// Package commandpolicy restricts the commands users may run, by command,
+// group of users and network of the client, as set in
+// config.CommandPolicyConfig
+package commandpolicy
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"path"
+	"slices"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disallowedcommand"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/discover"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/ipfilter"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+// Actions of a rule
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// ErrDenied is returned for the commands the policy denies
+var ErrDenied = fmt.Errorf("%w by the administrator", disallowedcommand.Error)
+
+// tokenCommands issue credentials, and are denied by DenyTokenCommands
+var tokenCommands = []commandargs.CommandType{commandargs.TwoFactorRecover, commandargs.PersonalAccessToken}
+
+// Configured reports whether cfg restricts any command
+func Configured(cfg config.CommandPolicyConfig) bool {
+	return cfg.DenyTokenCommands || len(cfg.Rules) > 0
+}
+
+// Policy is a parsed config.CommandPolicyConfig
+type Policy struct {
+	rules             []rule
+	denyTokenCommands bool
+}
+
+type rule struct {
+	allow    bool
+	commands []string
+	// users are those of the groups of the rule, nil for any user
+	users    []string
+	networks []netip.Prefix
+}
+
+// New parses cfg, rejecting unknown actions and groups, and invalid patterns
+// and networks
+func New(cfg config.CommandPolicyConfig) (*Policy, error) {
+	p := &Policy{denyTokenCommands: cfg.DenyTokenCommands}
+
+	for i, ruleCfg := range cfg.Rules {
+		r, err := newRule(cfg, ruleCfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid command policy rule %d: %w", i+1, err)
+		}
+
+		p.rules = append(p.rules, r)
+	}
+
+	return p, nil
+}
+
+func newRule(cfg config.CommandPolicyConfig, ruleCfg config.CommandPolicyRule) (rule, error) {
+	r := rule{allow: ruleCfg.Action == Allow, commands: ruleCfg.Commands}
+
+	if ruleCfg.Action != Allow && ruleCfg.Action != Deny {
+		return r, fmt.Errorf("unknown action %q", ruleCfg.Action)
+	}
+
+	for _, pattern := range ruleCfg.Commands {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return r, fmt.Errorf("invalid command %q: %w", pattern, err)
+		}
+	}
+
+	for _, group := range ruleCfg.Groups {
+		users, ok := cfg.Groups[group]
+		if !ok {
+			return r, fmt.Errorf("unknown group %q", group)
+		}
+
+		r.users = append(r.users, users...)
+	}
+
+	if len(ruleCfg.Groups) > 0 && r.users == nil {
+		// The groups are empty, and match no user
+		r.users = []string{}
+	}
+
+	networks, err := ipfilter.ParsePrefixes(ruleCfg.Networks)
+	if err != nil {
+		return r, err
+	}
+	r.networks = networks
+
+	return r, nil
+}
+
+// Check returns ErrDenied if commandType is denied to the client at
+// remoteAddr. username is only called for the rules restricted to groups.
+func (p *Policy) Check(ctx context.Context, commandType commandargs.CommandType, remoteAddr string, username func(context.Context) (string, error)) error {
+	addr, addrErr := netip.ParseAddr(gitlabnet.ParseIP(remoteAddr))
+
+	for _, r := range p.rules {
+		if !r.matchesCommand(commandType) {
+			continue
+		}
+
+		if len(r.networks) > 0 && (addrErr != nil || !ipfilter.Contains(r.networks, addr)) {
+			continue
+		}
+
+		if r.users != nil {
+			name, err := username(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to check the command policy: %w", err)
+			}
+
+			if !slices.Contains(r.users, name) {
+				continue
+			}
+		}
+
+		if r.allow {
+			return nil
+		}
+
+		return ErrDenied
+	}
+
+	if p.denyTokenCommands && slices.Contains(tokenCommands, commandType) {
+		return ErrDenied
+	}
+
+	return nil
+}
+
+func (r rule) matchesCommand(commandType commandargs.CommandType) bool {
+	if len(r.commands) == 0 {
+		return true
+	}
+
+	for _, pattern := range r.commands {
+		if matched, _ := path.Match(pattern, string(commandType)); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Command runs the wrapped command if the policy of the config allows it
+type Command struct {
+	command.Command
+	Config *config.Config
+	Args   *commandargs.Shell
+}
+
+// Execute checks the policy, then runs the command
+func (c *Command) Execute(ctx context.Context) (context.Context, error) {
+	policy, err := New(c.Config.CommandPolicy)
+	if err != nil {
+		return ctx, err
+	}
+
+	if err := policy.Check(ctx, c.Args.CommandType, c.Args.Env.RemoteAddr, c.username); err != nil {
+		log.WithContextFields(ctx, log.Fields{
+			"command":     c.Args.CommandType,
+			"remote_addr": c.Args.Env.RemoteAddr,
+		}).WithError(err).Info("commandpolicy: command refused")
+
+		return ctx, err
+	}
+
+	return c.Command.Execute(ctx)
+}
+
+// Unwrap returns the wrapped command
+func (c *Command) Unwrap() command.Command {
+	return c.Command
+}
+
+func (c *Command) username(ctx context.Context) (string, error) {
+	if c.Args.GitlabUsername != "" {
+		return c.Args.GitlabUsername, nil
+	}
+
+	client, err := discover.NewClient(c.Config)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := client.GetByCommandArgs(ctx, c.Args)
+	if err != nil {
+		return "", err
+	}
+
+	return response.Username, nil
+}
@@ -0,0 +1,149 @@
+package commandpolicy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+)
+
+var policyConfig = config.CommandPolicyConfig{
+	DenyTokenCommands: true,
+	Groups: map[string][]string{
+		"admins": {"alice"},
+		"empty":  {},
+	},
+	Rules: []config.CommandPolicyRule{
+		{Action: Allow, Commands: []string{"2fa_*", "personal_access_token"}, Groups: []string{"admins"}},
+		{Action: Deny, Commands: []string{"git-receive-pack"}, Networks: []string{"192.0.2.0/24"}},
+		{Action: Deny, Commands: []string{"git-upload-archive"}, Groups: []string{"empty"}},
+		{Action: Deny, Commands: []string{"git-lfs-*"}},
+	},
+}
+
+func TestCheck(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		command     commandargs.CommandType
+		remoteAddr  string
+		username    string
+		expectedErr error
+	}{
+		{desc: "token command allowed to a group", command: commandargs.PersonalAccessToken, username: "alice"},
+		{desc: "pattern allowed to a group", command: commandargs.TwoFactorRecover, username: "alice"},
+		{desc: "token command denied by default", command: commandargs.PersonalAccessToken, username: "bob", expectedErr: ErrDenied},
+		{desc: "other commands allowed by default", command: commandargs.TwoFactorVerify, username: "bob"},
+		{desc: "command denied to a network", command: commandargs.ReceivePack, remoteAddr: "192.0.2.1", expectedErr: ErrDenied},
+		{desc: "command allowed to other networks", command: commandargs.ReceivePack, remoteAddr: "198.51.100.1"},
+		{desc: "empty group matches no user", command: commandargs.UploadArchive, username: "bob"},
+		{desc: "command denied to everyone", command: commandargs.LfsAuthenticate, expectedErr: ErrDenied},
+	}
+
+	policy, err := New(policyConfig)
+	require.NoError(t, err)
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			username := func(context.Context) (string, error) { return tc.username, nil }
+
+			err := policy.Check(context.Background(), tc.command, tc.remoteAddr, username)
+			require.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestCheckResolvesUsernameLazily(t *testing.T) {
+	policy, err := New(policyConfig)
+	require.NoError(t, err)
+
+	failing := func(context.Context) (string, error) { return "", errors.New("lookup failed") }
+
+	require.NoError(t, policy.Check(context.Background(), commandargs.UploadPack, "", failing))
+	require.ErrorContains(t, policy.Check(context.Background(), commandargs.PersonalAccessToken, "", failing), "lookup failed")
+}
+
+func TestNewInvalid(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		rule          config.CommandPolicyRule
+		expectedError string
+	}{
+		{desc: "unknown action", rule: config.CommandPolicyRule{Action: "maybe"}, expectedError: `invalid command policy rule 1: unknown action "maybe"`},
+		{desc: "unknown group", rule: config.CommandPolicyRule{Action: Deny, Groups: []string{"nobody"}}, expectedError: `invalid command policy rule 1: unknown group "nobody"`},
+		{desc: "invalid pattern", rule: config.CommandPolicyRule{Action: Deny, Commands: []string{"[git"}}, expectedError: `invalid command policy rule 1: invalid command "[git"`},
+		{desc: "invalid network", rule: config.CommandPolicyRule{Action: Deny, Networks: []string{"192.0.2.0/33"}}, expectedError: `invalid command policy rule 1: invalid IP filter entry "192.0.2.0/33"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := New(config.CommandPolicyConfig{Rules: []config.CommandPolicyRule{tc.rule}})
+			require.ErrorContains(t, err, tc.expectedError)
+		})
+	}
+}
+
+type fakeCommand struct {
+	executed bool
+}
+
+func (f *fakeCommand) Execute(ctx context.Context) (context.Context, error) {
+	f.executed = true
+	return ctx, nil
+}
+
+func TestExecute(t *testing.T) {
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/discover",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "1", r.URL.Query().Get("key_id"))
+				require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{"username": "alice"}))
+			},
+		},
+	}
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	testCases := []struct {
+		desc        string
+		args        *commandargs.Shell
+		expectedErr error
+	}{
+		{
+			desc: "allowed to the user of the key",
+			args: &commandargs.Shell{GitlabKeyId: "1", CommandType: commandargs.PersonalAccessToken},
+		},
+		{
+			desc:        "denied to the given user",
+			args:        &commandargs.Shell{GitlabUsername: "bob", CommandType: commandargs.PersonalAccessToken},
+			expectedErr: ErrDenied,
+		},
+		{
+			desc:        "denied to the network",
+			args:        &commandargs.Shell{GitlabKeyId: "1", CommandType: commandargs.ReceivePack, Env: sshenv.Env{RemoteAddr: "192.0.2.1"}},
+			expectedErr: ErrDenied,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			wrapped := &fakeCommand{}
+			cmd := &Command{
+				Command: wrapped,
+				Config:  &config.Config{GitlabUrl: url, CommandPolicy: policyConfig},
+				Args:    tc.args,
+			}
+
+			_, err := cmd.Execute(context.Background())
+			require.ErrorIs(t, err, tc.expectedErr)
+			require.Equal(t, tc.expectedErr == nil, wrapped.executed)
+		})
+	}
+}
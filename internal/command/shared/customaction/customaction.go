@@ -59,6 +59,10 @@ func (c *Command) processAPIEndpoints(ctx context.Context, response *accessverif
 		return err
 	}
 
+	if response.Payload.Data.ProtocolVersion >= StreamingProtocolVersion {
+		return c.processStreamingAPIEndpoints(ctx, client, response)
+	}
+
 	data := response.Payload.Data
 	request := &Request{Data: data}
 	request.Data.UserID = response.Who
@@ -1,11 +1,14 @@
 package customaction
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -153,3 +156,131 @@ func TestExecuteNoEOFSent(t *testing.T) {
 	// and "output" string from the second request
 	require.Equal(t, "customoutput", outBuf.String())
 }
+
+func TestExecuteStreaming(t *testing.T) {
+	who := "key-1"
+	command := "0000000000000000000000000000000000000000 343d70886785dc1f98aaf70f3b4ca87c93a5d0dd refs/heads/main\n"
+	commands := fmt.Sprintf("%04x%s0000", len(command)+4, command)
+
+	testCases := []struct {
+		desc           string
+		eofSent        bool
+		input          string
+		expectedOutput string
+	}{
+		{
+			desc:           "push",
+			eofSent:        true,
+			input:          commands + "PACK data",
+			expectedOutput: commands + "PACK data",
+		},
+		{
+			desc:           "fetch",
+			input:          "0032want 343d70886785dc1f98aaf70f3b4ca87c93a5d0dd\n0009done\nleft on stdin",
+			expectedOutput: "0032want 343d70886785dc1f98aaf70f3b4ca87c93a5d0dd\n0009done\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			readRequest := func(t *testing.T, r *http.Request) string {
+				require.Equal(t, StreamContentType, r.Header.Get("Content-Type"))
+
+				body := bufio.NewReader(r.Body)
+				header, err := body.ReadBytes('\n')
+				require.NoError(t, err)
+
+				var request StreamRequest
+				require.NoError(t, json.Unmarshal(header, &request))
+				require.Equal(t, who, request.Data.UserID)
+
+				payload, err := io.ReadAll(body)
+				require.NoError(t, err)
+
+				return string(payload)
+			}
+
+			requests := []testserver.TestRequestHandler{
+				{
+					Path: "/geo/proxy/info_refs",
+					Handler: func(w http.ResponseWriter, r *http.Request) {
+						require.Empty(t, readRequest(t, r))
+
+						_, err := w.Write([]byte("custom"))
+						require.NoError(t, err)
+					},
+				},
+				{
+					Path: "/geo/proxy/pack",
+					Handler: func(w http.ResponseWriter, r *http.Request) {
+						require.Equal(t, tc.expectedOutput, readRequest(t, r))
+
+						_, err := w.Write([]byte("output"))
+						require.NoError(t, err)
+					},
+				},
+			}
+
+			url := testserver.StartSocketHttpServer(t, requests)
+
+			outBuf := &bytes.Buffer{}
+			response := &accessverifier.Response{
+				Who: who,
+				Payload: accessverifier.CustomPayload{
+					Action: "geo_proxy_to_primary",
+					Data: accessverifier.CustomPayloadData{
+						APIEndpoints:    []string{"/geo/proxy/info_refs", "/geo/proxy/pack"},
+						Username:        "custom",
+						PrimaryRepo:     "https://repo/path",
+						ProtocolVersion: StreamingProtocolVersion,
+					},
+				},
+			}
+
+			cmd := &Command{
+				Config:     &config.Config{GitlabUrl: url},
+				ReadWriter: &readwriter.ReadWriter{ErrOut: &bytes.Buffer{}, Out: outBuf, In: bytes.NewBufferString(tc.input)},
+				EOFSent:    tc.eofSent,
+			}
+
+			require.NoError(t, cmd.Execute(context.Background(), response))
+			require.Equal(t, "customoutput", outBuf.String())
+		})
+	}
+}
+
+func TestReadPktLine(t *testing.T) {
+	r := strings.NewReader("0009done\n0000PACK")
+
+	line, err := readPktLine(r)
+	require.NoError(t, err)
+	require.Equal(t, "0009done\n", string(line))
+
+	line, err = readPktLine(r)
+	require.NoError(t, err)
+	require.Equal(t, "0000", string(line))
+
+	rest, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "PACK", string(rest), "nothing is read ahead of the pkt-lines")
+
+	_, err = readPktLine(strings.NewReader("zzzz"))
+	require.EqualError(t, err, `read pkt-line: invalid packet length "zzzz"`)
+}
+
+func TestOnceBody(t *testing.T) {
+	body := onceBody(strings.NewReader("data"))
+
+	// Asking for the body again is fine until it's read from
+	_, err := body()
+	require.NoError(t, err)
+
+	r, err := body()
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "data", string(data))
+
+	_, err = body()
+	require.ErrorIs(t, err, errBodyReplayed)
+}
@@ -0,0 +1,188 @@
+package customaction
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/bufferpool"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/pktline"
+)
+
+const (
+	// StreamingProtocolVersion is the protocol_version of the payload of the
+	// custom actions whose requests and responses are streamed
+	StreamingProtocolVersion = 2
+
+	// StreamContentType is the content type of the streamed requests. Their
+	// body is a line of JSON, a StreamRequest, followed by the data read
+	// from stdin. The body of the response is the data written to stdout.
+	StreamContentType = "application/x-gitlab-shell-custom-action-stream"
+
+	maxPktSize = 0xffff
+	flushPkt   = "0000"
+)
+
+var errBodyReplayed = errors.New("custom action error: the data read from stdin can't be sent again")
+
+// StreamRequest starts the body of a streamed request
+type StreamRequest struct {
+	SecretToken []byte                           `json:"secret_token"`
+	Data        accessverifier.CustomPayloadData `json:"data"`
+}
+
+// processStreamingAPIEndpoints performs the custom action with the streaming
+// protocol: nothing read from stdin nor returned by the API is held in memory
+// beyond the pkt-lines preceding the pack data
+func (c *Command) processStreamingAPIEndpoints(ctx context.Context, client *client.GitlabNetClient, response *accessverifier.Response) error {
+	data := response.Payload.Data
+	data.UserID = response.Who
+
+	header, err := json.Marshal(&StreamRequest{Data: data})
+	if err != nil {
+		return err
+	}
+	header = append(header, '\n')
+
+	var input io.Reader = bytes.NewReader(nil)
+
+	for i, endpoint := range data.APIEndpoints {
+		ctxlog := log.WithContextFields(ctx, log.Fields{
+			"primary_repo": data.PrimaryRepo,
+			"endpoint":     endpoint,
+		})
+
+		ctxlog.Info("customaction: processStreamingAPIEndpoints: Performing custom action")
+
+		if err := c.performStreamingRequest(ctx, client, endpoint, io.MultiReader(bytes.NewReader(header), input)); err != nil {
+			return err
+		}
+
+		// Nothing would read stdin after the last request
+		if i == len(data.APIEndpoints)-1 {
+			break
+		}
+
+		if input, err = c.streamFromStdin(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Command) performStreamingRequest(ctx context.Context, client *client.GitlabNetClient, endpoint string, body io.Reader) error {
+	response, err := client.DoStream(ctx, http.MethodPost, endpoint, StreamContentType, onceBody(body))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	_, err = bufferpool.Copy(c.ReadWriter.Out, response.Body)
+
+	return err
+}
+
+// onceBody streams r for a single attempt at a request, as stdin can't be
+// read again. It may be asked for r again until it's read from: retryablehttp
+// does so to find the length of the body.
+func onceBody(r io.Reader) client.Body {
+	body := &onceReader{Reader: r}
+
+	return func() (io.Reader, error) {
+		if body.read {
+			return nil, errBodyReplayed
+		}
+
+		return body, nil
+	}
+}
+
+type onceReader struct {
+	io.Reader
+	read bool
+}
+
+func (r *onceReader) Read(p []byte) (int, error) {
+	r.read = true
+
+	return r.Reader.Read(p)
+}
+
+// streamFromStdin returns the data of stdin to send to the next endpoint, as
+// readFromStdin and readFromStdinNoEOF do, but streams the pack data rather
+// than buffering it
+func (c *Command) streamFromStdin() (io.Reader, error) {
+	var lines []byte
+	needsPackData := false
+
+	for {
+		line, err := readPktLine(c.ReadWriter.In)
+		if err == io.EOF {
+			return bytes.NewReader(lines), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line...)
+
+		if !c.EOFSent && pktline.IsDone(line) {
+			return bytes.NewReader(lines), nil
+		}
+
+		if c.EOFSent && pktline.IsFlush(line) {
+			break
+		}
+
+		if !needsPackData && !pktline.IsRefRemoval(line) {
+			needsPackData = true
+		}
+	}
+
+	if !needsPackData {
+		return bytes.NewReader(lines), nil
+	}
+
+	return io.MultiReader(bytes.NewReader(lines), c.ReadWriter.In), nil
+}
+
+// readPktLine reads a single pkt-line from r, including its length prefix.
+// Unlike pktline.NewScanner, it doesn't read ahead of it, so that the pack
+// data following the pkt-lines can be streamed from r.
+func readPktLine(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+
+		return nil, fmt.Errorf("read pkt-line: %w", err)
+	}
+
+	length, err := strconv.ParseUint(string(header), 16, 16)
+	if err != nil || length > maxPktSize {
+		return nil, fmt.Errorf("read pkt-line: invalid packet length %q", header)
+	}
+
+	// Flush, delimiter and response-end packets are only a length
+	if length < 4 {
+		return header, nil
+	}
+
+	line := make([]byte, length)
+	copy(line, header)
+	if _, err := io.ReadFull(r, line[4:]); err != nil {
+		return nil, fmt.Errorf("read pkt-line: %w", err)
+	}
+
+	return line, nil
+}
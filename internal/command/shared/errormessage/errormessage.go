@@ -0,0 +1,98 @@
+// Package errormessage renders the messages shown to users when a command
+// fails, from the templates admins may set to e.g. direct them to their own
+// help desk
+package errormessage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/commandlimiter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/i18n"
+)
+
+// twoFactorRequiredMsg starts the message of the internal API when a
+// command requires a two-factor verification first
+const twoFactorRequiredMsg = "OTP verification is required"
+
+// Data is given to the templates of the messages
+type Data struct {
+	// Message is the message shown without a template
+	Message       string
+	SupportURL    string
+	CorrelationID string
+}
+
+// Format returns the message shown to users for err: the template of the kind
+// of err, if any, rendered with message, or message itself
+func Format(ctx context.Context, cfg *config.Config, err error, message string) string {
+	text := templateFor(cfg.ErrorMessages, err)
+	if text == "" {
+		return message
+	}
+
+	tmpl, parseErr := template.New("error_message").Parse(text)
+	if parseErr != nil {
+		log.ContextLogger(ctx).WithError(parseErr).Warn("errormessage: invalid template")
+		return message
+	}
+
+	data := Data{
+		Message:       message,
+		SupportURL:    cfg.ErrorMessages.SupportURL,
+		CorrelationID: gitlabnet.CorrelationID(ctx),
+	}
+
+	var buf bytes.Buffer
+	if execErr := tmpl.Execute(&buf, data); execErr != nil {
+		log.ContextLogger(ctx).WithError(execErr).Warn("errormessage: invalid template")
+		return message
+	}
+
+	return buf.String()
+}
+
+// Messages returns the lines shown to users for err: its message, as Format
+// returns it, followed by the correlation ID of the command to quote when
+// asking for support, unless the message already has it, in the language of
+// ctx
+func Messages(ctx context.Context, cfg *config.Config, err error, message string) []string {
+	messages := []string{Format(ctx, cfg, err, message)}
+
+	if id := gitlabnet.CorrelationID(ctx); id != "" && !strings.Contains(messages[0], id) {
+		messages = append(messages, i18n.Sprintf(i18n.FromContext(ctx), "Correlation ID: %s", id))
+	}
+
+	return messages
+}
+
+// templateFor returns the template of the kind of err
+func templateFor(cfg config.ErrorMessagesConfig, err error) string {
+	var apiErr *client.APIError
+	isAPIErr := errors.As(err, &apiErr)
+
+	switch {
+	case errors.Is(err, commandlimiter.ErrLimited) || errors.Is(err, client.ErrServerBusy) ||
+		(isAPIErr && apiErr.StatusCode == http.StatusTooManyRequests):
+		return cfg.RateLimited
+	case client.IsUnavailable(err):
+		return cfg.APIUnreachable
+	case !isAPIErr:
+		return ""
+	case strings.HasPrefix(apiErr.Msg, twoFactorRequiredMsg):
+		return cfg.TwoFactorRequired
+	case apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden || apiErr.StatusCode == http.StatusNotFound:
+		return cfg.AccessDenied
+	default:
+		return ""
+	}
+}
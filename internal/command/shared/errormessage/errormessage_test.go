@@ -0,0 +1,113 @@
+package errormessage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/labkit/correlation"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/commandlimiter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/i18n"
+)
+
+func TestFormat(t *testing.T) {
+	cfg := &config.Config{ErrorMessages: config.ErrorMessagesConfig{
+		SupportURL:        "https://help.example.com",
+		AccessDenied:      "{{.Message}} Request access at {{.SupportURL}}",
+		APIUnreachable:    "GitLab is unreachable, quote {{.CorrelationID}}",
+		TwoFactorRequired: "Verify at {{.SupportURL}}",
+		RateLimited:       "Slow down",
+	}}
+
+	testCases := []struct {
+		desc     string
+		err      error
+		expected string
+	}{
+		{
+			desc:     "access denied",
+			err:      &client.APIError{Msg: "Access denied", StatusCode: http.StatusForbidden},
+			expected: "original message Request access at https://help.example.com",
+		},
+		{
+			desc:     "project not found",
+			err:      &client.APIError{Msg: "The project you were looking for could not be found.", StatusCode: http.StatusNotFound},
+			expected: "original message Request access at https://help.example.com",
+		},
+		{
+			desc:     "API unreachable",
+			err:      &client.APIError{Msg: "Internal API unreachable"},
+			expected: "GitLab is unreachable, quote abc123",
+		},
+		{
+			desc:     "internal API error",
+			err:      &client.APIError{Msg: "Internal API error (502)", StatusCode: http.StatusBadGateway},
+			expected: "GitLab is unreachable, quote abc123",
+		},
+		{
+			desc:     "two-factor verification required",
+			err:      &client.APIError{Msg: "OTP verification is required to access the repository.", StatusCode: http.StatusUnauthorized},
+			expected: "Verify at https://help.example.com",
+		},
+		{
+			desc:     "too many requests to the API",
+			err:      &client.APIError{Msg: "Too many requests", StatusCode: http.StatusTooManyRequests},
+			expected: "Slow down",
+		},
+		{
+			desc:     "API busy",
+			err:      client.ErrServerBusy,
+			expected: "Slow down",
+		},
+		{
+			desc:     "too many sessions",
+			err:      commandlimiter.ErrLimited,
+			expected: "Slow down",
+		},
+		{
+			desc:     "other errors",
+			err:      errors.New("Invalid SSH command"),
+			expected: "original message",
+		},
+	}
+
+	ctx := correlation.ContextWithCorrelation(context.Background(), "abc123")
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			require.Equal(t, tc.expected, Format(ctx, cfg, tc.err, "original message"))
+		})
+	}
+}
+
+func TestFormatWithoutTemplates(t *testing.T) {
+	err := &client.APIError{Msg: "Access denied", StatusCode: http.StatusForbidden}
+
+	require.Equal(t, "Access denied", Format(context.Background(), &config.Config{}, err, "Access denied"))
+}
+
+func TestFormatInvalidTemplate(t *testing.T) {
+	cfg := &config.Config{ErrorMessages: config.ErrorMessagesConfig{AccessDenied: "{{.Unknown}}"}}
+	err := &client.APIError{Msg: "Access denied", StatusCode: http.StatusForbidden}
+
+	require.Equal(t, "Access denied", Format(context.Background(), cfg, err, "Access denied"))
+}
+
+func TestMessages(t *testing.T) {
+	err := &client.APIError{Msg: "Internal API unreachable"}
+	ctx := correlation.ContextWithCorrelation(context.Background(), "abc123")
+
+	require.Equal(t, []string{"Internal API unreachable", "Correlation ID: abc123"}, Messages(ctx, &config.Config{}, err, err.Msg))
+	require.Equal(t, []string{"Internal API unreachable"}, Messages(context.Background(), &config.Config{}, err, err.Msg))
+
+	cfg := &config.Config{ErrorMessages: config.ErrorMessagesConfig{APIUnreachable: "Quote {{.CorrelationID}}"}}
+	require.Equal(t, []string{"Quote abc123"}, Messages(ctx, cfg, err, err.Msg), "the ID isn't repeated")
+
+	ctx = i18n.NewContext(ctx, "es")
+	require.Equal(t, []string{"Internal API unreachable", "ID de correlación: abc123"}, Messages(ctx, &config.Config{}, err, err.Msg))
+}
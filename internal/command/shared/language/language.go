@@ -0,0 +1,55 @@
+// Package language selects the language of the messages the commands show
+// to users
+package language
+
+import (
+	"context"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/discover"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/i18n"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+)
+
+// Select returns the language of the messages shown to the client of env:
+// the one it asked for, else preferred, the language of the user on GitLab,
+// else the default of the instance
+func Select(cfg *config.Config, env sshenv.Env, preferred string) string {
+	if env.Language != "" {
+		return env.Language
+	}
+
+	if language := i18n.Parse(preferred); language != "" {
+		return language
+	}
+
+	return i18n.Parse(cfg.Language.Default)
+}
+
+// For returns the language Select returns for the user of args, looking up
+// their preferred language on GitLab when the client didn't ask for one and
+// language.user_preference is set. Failing lookups fall back to the default.
+func For(ctx context.Context, cfg *config.Config, args *commandargs.Shell) string {
+	if args.Env.Language != "" || !cfg.Language.UserPreference {
+		return Select(cfg, args.Env, "")
+	}
+
+	return Select(cfg, args.Env, preferred(ctx, cfg, args))
+}
+
+func preferred(ctx context.Context, cfg *config.Config, args *commandargs.Shell) string {
+	client, err := discover.NewClient(cfg)
+	if err == nil {
+		var response *discover.Response
+		if response, err = client.GetByCommandArgs(ctx, args); err == nil {
+			return response.PreferredLanguage
+		}
+	}
+
+	log.ContextLogger(ctx).WithError(err).Warn("language: failed to look up the preferred language of the user")
+
+	return ""
+}
@@ -0,0 +1,81 @@
+package language
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+)
+
+func TestSelect(t *testing.T) {
+	cfg := &config.Config{Language: config.LanguageConfig{Default: "fr"}}
+
+	require.Equal(t, "es", Select(cfg, sshenv.Env{Language: "es"}, "de"))
+	require.Equal(t, "de", Select(cfg, sshenv.Env{}, "de_DE"))
+	require.Equal(t, "fr", Select(cfg, sshenv.Env{}, "xx"))
+	require.Empty(t, Select(&config.Config{}, sshenv.Env{}, ""))
+}
+
+func TestFor(t *testing.T) {
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/discover",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("key_id") != "1" {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+
+				json.NewEncoder(w).Encode(map[string]interface{}{"id": 2, "username": "alex-doe", "preferred_language": "es"})
+			},
+		},
+	}
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	testCases := []struct {
+		desc     string
+		language config.LanguageConfig
+		args     *commandargs.Shell
+		expected string
+	}{
+		{
+			desc:     "With the user preference",
+			language: config.LanguageConfig{UserPreference: true},
+			args:     &commandargs.Shell{GitlabKeyId: "1"},
+			expected: "es",
+		},
+		{
+			desc:     "With the language of the client",
+			language: config.LanguageConfig{UserPreference: true},
+			args:     &commandargs.Shell{GitlabKeyId: "1", Env: sshenv.Env{Language: "de"}},
+			expected: "de",
+		},
+		{
+			desc:     "Without the user preference",
+			language: config.LanguageConfig{Default: "fr"},
+			args:     &commandargs.Shell{GitlabKeyId: "1"},
+			expected: "fr",
+		},
+		{
+			desc:     "When the lookup fails",
+			language: config.LanguageConfig{Default: "fr", UserPreference: true},
+			args:     &commandargs.Shell{GitlabKeyId: "2"},
+			expected: "fr",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cfg := &config.Config{GitlabUrl: url, Language: tc.language}
+
+			require.Equal(t, tc.expected, For(context.Background(), cfg, tc.args))
+		})
+	}
+}
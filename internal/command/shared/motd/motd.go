@@ -0,0 +1,107 @@
+// Package motd renders the login banner and the message of the day that
+// admins set to announce e.g. maintenance windows to users
+package motd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"text/template"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/console"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/motd"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+// Data is given to the templates of the messages
+type Data struct {
+	// Username is empty in the banner, shown before authentication
+	Username string
+	Instance string
+}
+
+// Banner returns the message shown by gitlab-sshd before authentication
+func Banner(ctx context.Context, cfg *config.Config) string {
+	messages := get(ctx, cfg)
+	if messages.Banner == "" {
+		return ""
+	}
+
+	banner := render(ctx, messages.Banner, Data{Instance: InstanceName(cfg)})
+	if banner != "" && !strings.HasSuffix(banner, "\n") {
+		banner += "\n"
+	}
+
+	return banner
+}
+
+// Display writes the message of the day for username to out, as console
+// messages
+func Display(ctx context.Context, cfg *config.Config, username string, out io.Writer) {
+	messages := get(ctx, cfg)
+	if messages.Message == "" {
+		return
+	}
+
+	message := render(ctx, messages.Message, Data{Username: username, Instance: InstanceName(cfg)})
+	if message == "" {
+		return
+	}
+
+	console.DisplayInfoMessages(strings.Split(strings.TrimRight(message, "\n"), "\n"), out)
+}
+
+// get returns the messages of the config, or of the internal API if the
+// config says so. Failing to fetch them doesn't fail the command.
+func get(ctx context.Context, cfg *config.Config) motd.Response {
+	if !cfg.MOTD.FromAPI {
+		return motd.Response{Banner: cfg.MOTD.Banner, Message: cfg.MOTD.Message}
+	}
+
+	client, err := motd.NewClient(cfg)
+	if err == nil {
+		var response *motd.Response
+		if response, err = client.Get(ctx); err == nil {
+			return *response
+		}
+	}
+
+	log.ContextLogger(ctx).WithError(err).Warn("motd: failed to fetch the messages")
+
+	return motd.Response{}
+}
+
+func render(ctx context.Context, text string, data Data) string {
+	tmpl, err := template.New("motd").Parse(text)
+	if err != nil {
+		log.ContextLogger(ctx).WithError(err).Warn("motd: invalid template")
+		return ""
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		log.ContextLogger(ctx).WithError(err).Warn("motd: invalid template")
+		return ""
+	}
+
+	return buf.String()
+}
+
+// InstanceName returns the configured name of the instance, or the host of
+// its URL
+func InstanceName(cfg *config.Config) string {
+	if cfg.MOTD.InstanceName != "" {
+		return cfg.MOTD.InstanceName
+	}
+
+	u, err := url.Parse(cfg.GitlabUrl)
+	if err != nil || u.Host == "" || strings.HasSuffix(u.Scheme, "+unix") {
+		return "GitLab"
+	}
+
+	return u.Host
+}
@@ -0,0 +1,61 @@
+package motd
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func TestDisplay(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		motd     config.MOTDConfig
+		username string
+		expected string
+	}{
+		{
+			desc: "no message",
+		},
+		{
+			desc:     "templated message",
+			motd:     config.MOTDConfig{Message: "Hello {{.Username}}, welcome to {{.Instance}}"},
+			username: "alex-doe",
+			expected: "remote: \nremote: Hello alex-doe, welcome to gitlab.example.com\nremote: \n",
+		},
+		{
+			desc:     "multi-line message",
+			motd:     config.MOTDConfig{Message: "{{.Instance}} is down for maintenance\non Sunday\n", InstanceName: "GitLab Europe"},
+			expected: "remote: \nremote: GitLab Europe is down for maintenance\nremote: on Sunday\nremote: \n",
+		},
+		{
+			desc: "invalid template",
+			motd: config.MOTDConfig{Message: "Hello {{.Unknown}}"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cfg := &config.Config{GitlabUrl: "https://gitlab.example.com", MOTD: tc.motd}
+			out := &bytes.Buffer{}
+
+			Display(context.Background(), cfg, tc.username, out)
+			require.Equal(t, tc.expected, out.String())
+		})
+	}
+}
+
+func TestBanner(t *testing.T) {
+	cfg := &config.Config{
+		GitlabUrl: "http+unix:///tmp/gitlab.socket",
+		MOTD:      config.MOTDConfig{Banner: "{{.Instance}} is down for maintenance on Sunday"},
+	}
+
+	require.Equal(t, "GitLab is down for maintenance on Sunday\n", Banner(context.Background(), cfg))
+
+	cfg.MOTD.Banner = ""
+	require.Empty(t, Banner(context.Background(), cfg))
+}
@@ -0,0 +1,160 @@
+// Package sshdconfig converts between the sshd section of config.yml and the
+// sshd_config of OpenSSH, to move between gitlab-sshd and OpenSSH
+package sshdconfig
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/authorizedprincipals"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+// ExportCommand prints the sshd_config stanza that makes OpenSSH serve
+// GitLab as the config of RootDir has gitlab-sshd do
+type ExportCommand struct {
+	RootDir    string
+	ReadWriter *readwriter.ReadWriter
+}
+
+func (c *ExportCommand) Execute(ctx context.Context) (context.Context, error) {
+	cfg, err := config.NewFromDir(c.RootDir)
+	if err != nil {
+		return ctx, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	Export(c.ReadWriter.Out, cfg, c.RootDir)
+
+	return ctx, nil
+}
+
+// Export writes the sshd_config stanza matching cfg to w, for the
+// executables of gitlab-shell in rootDir. The options OpenSSH only takes
+// globally come first, then those of the GitLab user in a Match block.
+func Export(w io.Writer, cfg *config.Config, rootDir string) {
+	server := cfg.Server
+	user := cfg.User
+	if user == "" {
+		user = config.DefaultConfig.User
+	}
+
+	fmt.Fprintf(w, "# Generated by gitlab-shell-check sshd-config from %s\n", filepath.Join(rootDir, "config.yml"))
+
+	for _, address := range listenAddresses(server) {
+		writeOption(w, "", "ListenAddress", address)
+	}
+	for _, file := range server.HostKeyFiles {
+		writeOption(w, "", "HostKey", file)
+	}
+	for _, file := range server.HostCertFiles {
+		writeOption(w, "", "HostCertificate", file)
+	}
+	if server.LoginGraceTime > 0 {
+		writeOption(w, "", "LoginGraceTime", sshdTime(time.Duration(server.LoginGraceTime)))
+	}
+	writeOption(w, "", "Ciphers", strings.Join(firstList(server.Algorithms.Ciphers, server.Ciphers), ","))
+	writeOption(w, "", "MACs", strings.Join(firstList(server.Algorithms.MACs, server.MACs), ","))
+	writeOption(w, "", "KexAlgorithms", strings.Join(firstList(server.Algorithms.KexAlgorithms, server.KexAlgorithms), ","))
+	writeOption(w, "", "HostKeyAlgorithms", strings.Join(server.Algorithms.HostKeyAlgorithms, ","))
+
+	const indent = "  "
+
+	fmt.Fprintf(w, "\nMatch User %s\n", user)
+	writeOption(w, indent, "AuthorizedKeysCommand", filepath.Join(rootDir, "bin", "gitlab-shell-authorized-keys-check")+" "+user+" %u %k")
+	writeOption(w, indent, "AuthorizedKeysCommandUser", user)
+
+	if server.TrustedUserCAKeys != "" {
+		principals := "%u"
+		if len(server.AuthorizedPrincipals) > 0 {
+			principals = strings.Join(server.AuthorizedPrincipals, " ")
+		}
+
+		args := "%i " + principals
+		// Wildcard and group principals are matched against the certificate
+		if slices.ContainsFunc(server.AuthorizedPrincipals, authorizedprincipals.IsPattern) {
+			args = commandargs.CertificateFlag + " %k " + args
+		}
+
+		writeOption(w, indent, "TrustedUserCAKeys", server.TrustedUserCAKeys)
+		writeOption(w, indent, "AuthorizedPrincipalsCommand", filepath.Join(rootDir, "bin", "gitlab-shell-authorized-principals-check")+" "+args)
+		writeOption(w, indent, "AuthorizedPrincipalsCommandUser", user)
+	}
+
+	writeOption(w, indent, "PubkeyAcceptedAlgorithms", strings.Join(server.PublicKeyAlgorithms, ","))
+	writeOption(w, indent, "AcceptEnv", "GIT_PROTOCOL")
+	if server.ClientAliveInterval > 0 {
+		writeOption(w, indent, "ClientAliveInterval", sshdTime(time.Duration(server.ClientAliveInterval)))
+	}
+	if server.ClientAliveCountMax > 0 {
+		writeOption(w, indent, "ClientAliveCountMax", fmt.Sprint(server.ClientAliveCountMax))
+	}
+	writeOption(w, indent, "DisableForwarding", "yes")
+	writeOption(w, indent, "PermitTTY", "no")
+
+	// OpenSSH reads the banner from a file
+	if banner := cfg.MOTD.Banner; banner != "" {
+		fmt.Fprintf(w, "%s# Banner FILE, with FILE holding motd.banner:\n", indent)
+		for _, line := range strings.Split(strings.TrimRight(banner, "\n"), "\n") {
+			fmt.Fprintf(w, "%s#   %s\n", indent, line)
+		}
+	}
+
+	fmt.Fprintln(w, "Match all")
+}
+
+// writeOption writes an option unless its value is empty
+func writeOption(w io.Writer, indent, keyword, value string) {
+	if value == "" {
+		return
+	}
+
+	fmt.Fprintf(w, "%s%s %s\n", indent, keyword, value)
+}
+
+// listenAddresses returns the addresses listened on in the format of
+// ListenAddress, which needs a host
+func listenAddresses(server config.ServerConfig) []string {
+	listens := []string{server.Listen}
+	for _, listener := range server.Listeners {
+		listens = append(listens, listener.Listen)
+	}
+
+	var addresses []string
+	for _, listen := range listens {
+		host, port, err := net.SplitHostPort(listen)
+		if err != nil {
+			continue
+		}
+
+		if host == "" {
+			addresses = append(addresses, net.JoinHostPort("0.0.0.0", port), net.JoinHostPort("::", port))
+		} else {
+			addresses = append(addresses, net.JoinHostPort(host, port))
+		}
+	}
+
+	return addresses
+}
+
+func firstList(lists ...[]string) []string {
+	for _, list := range lists {
+		if len(list) > 0 {
+			return list
+		}
+	}
+
+	return nil
+}
+
+// sshdTime formats d in the time format of sshd_config, in whole seconds
+func sshdTime(d time.Duration) string {
+	return fmt.Sprintf("%ds", int64((d+time.Second-1)/time.Second))
+}
@@ -0,0 +1,87 @@
+package sshdconfig
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func TestExport(t *testing.T) {
+	cfg := &config.Config{
+		User: "git",
+		Server: config.ServerConfig{
+			Listen:               ":2222",
+			Listeners:            []config.ListenerConfig{{Listen: "10.0.0.1:22"}},
+			HostKeyFiles:         []string{"/etc/ssh/ssh_host_ed25519_key"},
+			LoginGraceTime:       config.YamlDuration(90 * time.Second),
+			ClientAliveInterval:  config.YamlDuration(15 * time.Second),
+			ClientAliveCountMax:  3,
+			Ciphers:              []string{"aes128-ctr"},
+			TrustedUserCAKeys:    "/etc/ssh/ca.pub",
+			AuthorizedPrincipals: []string{"sshUsers"},
+			Algorithms:           config.AlgorithmsConfig{Ciphers: []string{"aes256-gcm@openssh.com", "chacha20-poly1305@openssh.com"}},
+		},
+		MOTD: config.MOTDConfig{Banner: "Welcome\nto GitLab\n"},
+	}
+
+	out := &bytes.Buffer{}
+	Export(out, cfg, "/srv/gitlab-shell")
+
+	require.Equal(t, `# Generated by gitlab-shell-check sshd-config from /srv/gitlab-shell/config.yml
+ListenAddress 0.0.0.0:2222
+ListenAddress [::]:2222
+ListenAddress 10.0.0.1:22
+HostKey /etc/ssh/ssh_host_ed25519_key
+LoginGraceTime 90s
+Ciphers aes256-gcm@openssh.com,chacha20-poly1305@openssh.com
+
+Match User git
+  AuthorizedKeysCommand /srv/gitlab-shell/bin/gitlab-shell-authorized-keys-check git %u %k
+  AuthorizedKeysCommandUser git
+  TrustedUserCAKeys /etc/ssh/ca.pub
+  AuthorizedPrincipalsCommand /srv/gitlab-shell/bin/gitlab-shell-authorized-principals-check %i sshUsers
+  AuthorizedPrincipalsCommandUser git
+  AcceptEnv GIT_PROTOCOL
+  ClientAliveInterval 15s
+  ClientAliveCountMax 3
+  DisableForwarding yes
+  PermitTTY no
+  # Banner FILE, with FILE holding motd.banner:
+  #   Welcome
+  #   to GitLab
+Match all
+`, out.String())
+}
+
+func TestExportPrincipalPatterns(t *testing.T) {
+	cfg := &config.Config{Server: config.ServerConfig{TrustedUserCAKeys: "/etc/ssh/ca.pub", AuthorizedPrincipals: []string{"sshUsers", "group:backend/*"}}}
+
+	out := &bytes.Buffer{}
+	Export(out, cfg, "/srv/gitlab-shell")
+
+	require.Contains(t, out.String(), "\n  AuthorizedPrincipalsCommand /srv/gitlab-shell/bin/gitlab-shell-authorized-principals-check --certificate %k %i sshUsers group:backend/*\n")
+}
+
+func TestExportCommand(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yml"), []byte("secret: sssh\nuser: gitlab\n"), 0o600))
+
+	out := &bytes.Buffer{}
+	cmd := &ExportCommand{RootDir: dir, ReadWriter: &readwriter.ReadWriter{Out: out}}
+
+	_, err := cmd.Execute(context.Background())
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "\nMatch User gitlab\n")
+
+	cmd.RootDir = t.TempDir()
+	_, err = cmd.Execute(context.Background())
+	require.ErrorContains(t, err, "failed to read config")
+}
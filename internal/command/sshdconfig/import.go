@@ -0,0 +1,338 @@
+package sshdconfig
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+const defaultSSHDPort = "22"
+
+// ImportCommand prints the settings of config.yml matching the sshd_config
+// at Path, and the options of Path it couldn't import
+type ImportCommand struct {
+	Path       string
+	ReadWriter *readwriter.ReadWriter
+}
+
+func (c *ImportCommand) Execute(ctx context.Context) (context.Context, error) {
+	f, err := os.Open(filepath.Clean(c.Path))
+	if err != nil {
+		return ctx, err
+	}
+	defer func() { _ = f.Close() }()
+
+	imported, problems, err := Import(f)
+	if err != nil {
+		return ctx, fmt.Errorf("failed to import %s: %w", c.Path, err)
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintln(c.ReadWriter.ErrOut, problem)
+	}
+
+	fmt.Fprintf(c.ReadWriter.Out, "# Imported by gitlab-shell-check sshd-config from %s\n", c.Path)
+
+	encoder := yaml.NewEncoder(c.ReadWriter.Out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(imported); err != nil {
+		return ctx, err
+	}
+
+	return ctx, encoder.Close()
+}
+
+// Imported are the settings of config.yml imported from a sshd_config
+type Imported struct {
+	Server importedServer `yaml:"sshd"`
+	MOTD   importedMOTD   `yaml:"motd,omitempty"`
+}
+
+type importedServer struct {
+	Listen              string                  `yaml:"listen,omitempty"`
+	Listeners           []importedListener      `yaml:"listeners,omitempty"`
+	HostKeyFiles        []string                `yaml:"host_key_files,omitempty"`
+	HostCertFiles       []string                `yaml:"host_cert_files,omitempty"`
+	LoginGraceTime      config.YamlDuration     `yaml:"login_grace_time,omitempty"`
+	ClientAliveInterval config.YamlDuration     `yaml:"client_alive_interval,omitempty"`
+	ClientAliveCountMax int                     `yaml:"client_alive_count_max,omitempty"`
+	TrustedUserCAKeys   string                  `yaml:"trusted_user_ca_keys,omitempty"`
+	PublicKeyAlgorithms []string                `yaml:"public_key_algorithms,omitempty"`
+	Algorithms          config.AlgorithmsConfig `yaml:"algorithms,omitempty"`
+}
+
+type importedListener struct {
+	Listen string `yaml:"listen"`
+}
+
+type importedMOTD struct {
+	Banner string `yaml:"banner,omitempty"`
+}
+
+// Import reads a sshd_config from r, returning the settings of config.yml
+// matching its global options, and the problems found in it: the options
+// that have no equivalent, and those of Match blocks, which aren't imported
+func Import(r io.Reader) (*Imported, []string, error) {
+	imported := &Imported{}
+	var problems []string
+	var ports, addresses []string
+	seen := map[string]bool{}
+	inMatch := false
+
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		keyword, args := parseLine(scanner.Text())
+		if keyword == "" {
+			continue
+		}
+		name := strings.ToLower(keyword)
+
+		problem := func(format string, a ...any) {
+			problems = append(problems, fmt.Sprintf("line %d: %s: ", lineNumber, keyword)+fmt.Sprintf(format, a...))
+		}
+
+		if name == "match" {
+			inMatch = !(len(args) == 1 && strings.EqualFold(args[0], "all"))
+			if inMatch {
+				problem("the options of Match blocks aren't imported")
+			}
+			continue
+		}
+
+		if inMatch {
+			continue
+		}
+
+		if len(args) == 0 {
+			problem("missing argument")
+			continue
+		}
+
+		// sshd uses the first value of most options, and all the values of
+		// a few
+		first := !seen[name]
+		seen[name] = true
+
+		switch name {
+		case "port":
+			ports = append(ports, args[0])
+		case "listenaddress":
+			addresses = append(addresses, args[0])
+		case "hostkey":
+			imported.Server.HostKeyFiles = append(imported.Server.HostKeyFiles, args[0])
+		case "hostcertificate":
+			imported.Server.HostCertFiles = append(imported.Server.HostCertFiles, args[0])
+		case "logingracetime", "clientaliveinterval":
+			d, err := parseSSHDTime(args[0])
+			if err != nil {
+				problem("%v", err)
+				continue
+			}
+
+			if first && name == "logingracetime" {
+				imported.Server.LoginGraceTime = config.YamlDuration(d)
+			} else if first {
+				imported.Server.ClientAliveInterval = config.YamlDuration(d)
+			}
+		case "clientalivecountmax":
+			n, err := strconv.Atoi(args[0])
+			if err != nil {
+				problem("invalid count %q", args[0])
+			} else if first {
+				imported.Server.ClientAliveCountMax = n
+			}
+		case "trustedusercakeys":
+			if first {
+				imported.Server.TrustedUserCAKeys = args[0]
+			}
+		case "ciphers", "macs", "kexalgorithms", "hostkeyalgorithms", "pubkeyacceptedalgorithms", "pubkeyacceptedkeytypes":
+			// Lists changing the defaults of OpenSSH, with a leading +, - or ^,
+			// don't say which algorithms they select
+			if strings.ContainsAny(args[0][:1], "+-^") {
+				problem("lists changing the default algorithms of OpenSSH aren't imported")
+				continue
+			}
+
+			if first {
+				setAlgorithms(&imported.Server, name, strings.Split(args[0], ","))
+			}
+		case "banner":
+			if !first || args[0] == "none" {
+				continue
+			}
+
+			banner, err := os.ReadFile(filepath.Clean(args[0]))
+			if err != nil {
+				problem("%v", err)
+				continue
+			}
+			imported.MOTD.Banner = string(banner)
+		case "include":
+			problem("included files aren't imported")
+		default:
+			problem("no equivalent in gitlab-sshd")
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	listens, err := listens(ports, addresses)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(listens) > 0 {
+		imported.Server.Listen = listens[0]
+		for _, listen := range listens[1:] {
+			imported.Server.Listeners = append(imported.Server.Listeners, importedListener{Listen: listen})
+		}
+	}
+
+	return imported, problems, nil
+}
+
+// parseLine returns the keyword of a line of sshd_config and its arguments, which may be quoted. Keywords may be separated from their
+// arguments by an equal sign.
+func parseLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return line, nil
+	}
+
+	keyword, rest := line[:i], strings.TrimLeft(line[i:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+
+	var args []string
+	for rest != "" {
+		var arg string
+		if rest[0] == '"' {
+			arg, rest, _ = strings.Cut(rest[1:], `"`)
+		} else if j := strings.IndexAny(rest, " \t"); j >= 0 {
+			arg, rest = rest[:j], rest[j:]
+		} else {
+			arg, rest = rest, ""
+		}
+
+		if strings.HasPrefix(arg, "#") {
+			break
+		}
+		args = append(args, arg)
+		rest = strings.TrimLeft(rest, " \t")
+	}
+
+	return keyword, args
+}
+
+func setAlgorithms(server *importedServer, keyword string, algorithms []string) {
+	switch keyword {
+	case "ciphers":
+		server.Algorithms.Ciphers = algorithms
+	case "macs":
+		server.Algorithms.MACs = algorithms
+	case "kexalgorithms":
+		server.Algorithms.KexAlgorithms = algorithms
+	case "hostkeyalgorithms":
+		server.Algorithms.HostKeyAlgorithms = algorithms
+	default:
+		server.PublicKeyAlgorithms = algorithms
+	}
+}
+
+// listens combines the ports and the addresses of sshd_config, which take
+// the ports given to them or else listen on every port
+func listens(ports, addresses []string) ([]string, error) {
+	if len(ports) == 0 {
+		ports = []string{defaultSSHDPort}
+	}
+
+	if len(addresses) == 0 {
+		if len(ports) == 1 && ports[0] == defaultSSHDPort {
+			return nil, nil
+		}
+
+		addresses = []string{""}
+	}
+
+	var listens []string
+	for _, address := range addresses {
+		if host, port, err := net.SplitHostPort(address); err == nil {
+			listens = append(listens, net.JoinHostPort(host, port))
+			continue
+		}
+
+		host := strings.Trim(address, "[]")
+		for _, port := range ports {
+			if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+				return nil, fmt.Errorf("invalid port %q", port)
+			}
+
+			listens = append(listens, net.JoinHostPort(host, port))
+		}
+	}
+
+	return listens, nil
+}
+
+var sshdTimeUnits = map[byte]time.Duration{
+	's': time.Second,
+	'm': time.Minute,
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+}
+
+// parseSSHDTime parses the time format of sshd_config, such as 90 or 1m30s,
+// in which numbers without a unit are seconds
+func parseSSHDTime(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, errors.New("invalid time \"\"")
+	}
+
+	var total time.Duration
+
+	for rest := strings.ToLower(s); rest != ""; {
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+
+		n, err := strconv.ParseInt(rest[:i], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid time %q", s)
+		}
+		rest = rest[i:]
+
+		unit := time.Second
+		if rest != "" {
+			var ok bool
+			if unit, ok = sshdTimeUnits[rest[0]]; !ok {
+				return 0, fmt.Errorf("invalid time %q", s)
+			}
+			rest = rest[1:]
+		}
+
+		total += time.Duration(n) * unit
+	}
+
+	return total, nil
+}
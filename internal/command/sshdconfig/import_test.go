@@ -0,0 +1,123 @@
+package sshdconfig
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func TestImport(t *testing.T) {
+	banner := filepath.Join(t.TempDir(), "banner")
+	require.NoError(t, os.WriteFile(banner, []byte("Welcome\n"), 0o600))
+
+	sshdConfig := `# The sshd_config of a GitLab server
+Port 2222
+ListenAddress 10.0.0.1
+ListenAddress [::1]:22
+HostKey /etc/ssh/ssh_host_ed25519_key
+HostKey=/etc/ssh/ssh_host_rsa_key
+LoginGraceTime 1m30s
+LoginGraceTime 10
+ClientAliveInterval	15 # seconds
+ClientAliveCountMax 3
+Ciphers aes256-gcm@openssh.com,chacha20-poly1305@openssh.com
+MACs +hmac-sha1
+X11Forwarding no
+Banner "` + banner + `"
+
+Match User git
+  AuthorizedKeysCommand /bin/true
+Match all
+TrustedUserCAKeys /etc/ssh/ca.pub
+`
+
+	imported, problems, err := Import(strings.NewReader(sshdConfig))
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"line 12: MACs: lists changing the default algorithms of OpenSSH aren't imported",
+		"line 13: X11Forwarding: no equivalent in gitlab-sshd",
+		"line 16: Match: the options of Match blocks aren't imported",
+	}, problems)
+
+	require.Equal(t, &Imported{
+		Server: importedServer{
+			Listen:              "10.0.0.1:2222",
+			Listeners:           []importedListener{{Listen: "[::1]:22"}},
+			HostKeyFiles:        []string{"/etc/ssh/ssh_host_ed25519_key", "/etc/ssh/ssh_host_rsa_key"},
+			LoginGraceTime:      config.YamlDuration(90 * time.Second),
+			ClientAliveInterval: config.YamlDuration(15 * time.Second),
+			ClientAliveCountMax: 3,
+			TrustedUserCAKeys:   "/etc/ssh/ca.pub",
+			Algorithms:          config.AlgorithmsConfig{Ciphers: []string{"aes256-gcm@openssh.com", "chacha20-poly1305@openssh.com"}},
+		},
+		MOTD: importedMOTD{Banner: "Welcome\n"},
+	}, imported)
+}
+
+func TestImportListens(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		sshdConfig     string
+		expectedListen string
+		expectedErr    string
+	}{
+		{desc: "the default port", sshdConfig: "Port 22\n"},
+		{desc: "a port", sshdConfig: "Port 2222\n", expectedListen: ":2222"},
+		{desc: "an IPv6 address", sshdConfig: "ListenAddress ::1\n", expectedListen: "[::1]:22"},
+		{desc: "an invalid port", sshdConfig: "Port ssh\n", expectedErr: `invalid port "ssh"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			imported, _, err := Import(strings.NewReader(tc.sshdConfig))
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedListen, imported.Server.Listen)
+		})
+	}
+}
+
+func TestParseSSHDTime(t *testing.T) {
+	for s, expected := range map[string]time.Duration{
+		"90":     90 * time.Second,
+		"1m30s":  90 * time.Second,
+		"1H":     time.Hour,
+		"1w2d":   9 * 24 * time.Hour,
+		"10s10s": 20 * time.Second,
+	} {
+		d, err := parseSSHDTime(s)
+		require.NoError(t, err, s)
+		require.Equal(t, expected, d, s)
+	}
+
+	for _, s := range []string{"", "m", "10y", "1.5m"} {
+		_, err := parseSSHDTime(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestImportCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sshd_config")
+	require.NoError(t, os.WriteFile(path, []byte("Port 2222\nUsePAM yes\n"), 0o600))
+
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := &ImportCommand{Path: path, ReadWriter: &readwriter.ReadWriter{Out: out, ErrOut: errOut}}
+
+	_, err := cmd.Execute(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "# Imported by gitlab-shell-check sshd-config from "+path+"\nsshd:\n  listen: :2222\n", out.String())
+	require.Equal(t, "line 2: UsePAM: no equivalent in gitlab-sshd\n", errOut.String())
+}
@@ -11,8 +11,10 @@ import (
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/language"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/twofactorrecover"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/i18n"
 )
 
 const readerLimit = 1024
@@ -29,22 +31,27 @@ func (c *Command) Execute(ctx context.Context) (context.Context, error) {
 	ctxlog := log.ContextLogger(ctx)
 	ctxlog.Debug("twofactorrecover: execute: Waiting for user input")
 
-	if c.getUserAnswer(ctx) == "yes" {
+	lang := language.For(ctx, c.Config, c.Args)
+
+	if c.getUserAnswer(ctx, lang) == "yes" {
 		ctxlog.Debug("twofactorrecover: execute: User chose to continue")
-		c.displayRecoveryCodes(ctx)
+		c.displayRecoveryCodes(ctx, lang)
 	} else {
 		ctxlog.Debug("twofactorrecover: execute: User chose not to continue")
-		fmt.Fprintln(c.ReadWriter.Out, "\nNew recovery codes have *not* been generated. Existing codes will remain valid.")
+		fmt.Fprintln(c.ReadWriter.Out, "\n"+i18n.Text(lang, "New recovery codes have *not* been generated. Existing codes will remain valid."))
 	}
 
 	return ctx, nil
 }
 
-func (c *Command) getUserAnswer(ctx context.Context) string {
+// getUserAnswer asks the user to confirm, even without a terminal: unlike
+// creating a personal access token, generating codes invalidates the existing
+// ones, and clients rarely ask for a terminal to run a command
+func (c *Command) getUserAnswer(ctx context.Context, lang string) string {
 	question :=
 		"Are you sure you want to generate new two-factor recovery codes?\n" +
 			"Any existing recovery codes you saved will be invalidated. (yes/no)"
-	fmt.Fprintln(c.ReadWriter.Out, question)
+	fmt.Fprintln(c.ReadWriter.Out, i18n.Text(lang, question))
 
 	var answer string
 	if _, err := fmt.Fscanln(io.LimitReader(c.ReadWriter.In, readerLimit), &answer); err != nil {
@@ -54,7 +61,7 @@ func (c *Command) getUserAnswer(ctx context.Context) string {
 	return answer
 }
 
-func (c *Command) displayRecoveryCodes(ctx context.Context) {
+func (c *Command) displayRecoveryCodes(ctx context.Context, lang string) {
 	ctxlog := log.ContextLogger(ctx)
 
 	codes, err := c.getRecoveryCodes(ctx)
@@ -62,15 +69,15 @@ func (c *Command) displayRecoveryCodes(ctx context.Context) {
 	if err == nil {
 		ctxlog.Debug("twofactorrecover: displayRecoveryCodes: recovery codes successfully generated")
 		messageWithCodes :=
-			"\nYour two-factor authentication recovery codes are:\n\n" +
+			"\n" + i18n.Text(lang, "Your two-factor authentication recovery codes are:") + "\n\n" +
 				strings.Join(codes, "\n") +
-				"\n\nDuring sign in, use one of the codes above when prompted for\n" +
-				"your two-factor code. Then, visit your Profile Settings and add\n" +
-				"a new device so you do not lose access to your account again.\n"
+				"\n\n" + i18n.Text(lang, "During sign in, use one of the codes above when prompted for\n"+
+				"your two-factor code. Then, visit your Profile Settings and add\n"+
+				"a new device so you do not lose access to your account again.") + "\n"
 		fmt.Fprint(c.ReadWriter.Out, messageWithCodes)
 	} else {
 		ctxlog.WithError(err).Error("twofactorrecover: displayRecoveryCodes: failed to generate recovery codes")
-		fmt.Fprintf(c.ReadWriter.Out, "\nAn error occurred while trying to generate new recovery codes.\n%v\n", err)
+		fmt.Fprintf(c.ReadWriter.Out, "\n%s\n%v\n", i18n.Text(lang, "An error occurred while trying to generate new recovery codes."), err)
 	}
 }
 
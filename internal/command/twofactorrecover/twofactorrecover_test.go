@@ -85,7 +85,7 @@ func TestExecute(t *testing.T) {
 			desc:           "With bad response",
 			arguments:      &commandargs.Shell{GitlabKeyId: "-1"},
 			answer:         "yes\n",
-			expectedOutput: question + errorHeader + "Parsing failed\n",
+			expectedOutput: question + errorHeader + "Parsing failed: /api/v4/internal/two_factor_recovery_codes: empty response\n",
 		},
 		{
 			desc:           "With API returns an error",
@@ -11,13 +11,28 @@ import (
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/language"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/twofactorverify"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/i18n"
 )
 
 const (
-	timeout = 30 * time.Second
-	prompt  = "OTP: "
+	defaultTimeout = 30 * time.Second
+	prompt         = "OTP: "
+	waitingMessage = "No OTP entered, waiting for push authentication..."
+)
+
+// spinnerFrames are shown in turn next to the countdown of the push
+// authentication
+var spinnerFrames = []rune{'|', '/', '-', '\\'}
+
+var (
+	// pushFallbackDelay is how long to wait for an OTP to be entered before
+	// falling back to push authentication, unless configured
+	pushFallbackDelay = 10 * time.Second
+	// spinnerInterval is how often the countdown is updated
+	spinnerInterval = time.Second
 )
 
 // Command represents the command for two-factor verification
@@ -27,44 +42,110 @@ type Command struct {
 	ReadWriter *readwriter.ReadWriter
 }
 
-// Execute executes the two-factor verification command
+// Execute prompts for an OTP and verifies it. If none is entered within the
+// push fallback delay, a push notification is sent to the user's
+// authenticator as well, with a countdown until the verification times out,
+// and whichever verification succeeds first allows Git operations. A push
+// notification still pending when Execute returns is cancelled.
 func (c *Command) Execute(ctx context.Context) (context.Context, error) {
 	client, err := twofactorverify.NewClient(c.Config)
 	if err != nil {
 		return ctx, err
 	}
+	if interval := time.Duration(c.Config.TwoFactor.PushPollInterval); interval > 0 {
+		client.PushPollInterval = interval
+	}
 
-	ctx, cancel := context.WithTimeout(ctx, timeout)
+	timeout := defaultTimeout
+	if t := time.Duration(c.Config.TwoFactor.Timeout); t > 0 {
+		timeout = t
+	}
+	fallbackDelay := pushFallbackDelay
+	if d := time.Duration(c.Config.TwoFactor.PushFallbackDelay); d > 0 {
+		fallbackDelay = d
+	}
+
+	lang := language.For(ctx, c.Config, c.Args)
+	waiting := i18n.Text(lang, waitingMessage)
+
+	verifyCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
+	deadline, _ := verifyCtx.Deadline()
 
 	fmt.Fprint(c.ReadWriter.Out, prompt)
 
-	resultCh := make(chan string)
-	go func() {
-		err := client.PushAuth(ctx, c.Args)
-		if err == nil {
-			resultCh <- "OTP has been validated by Push Authentication. Git operations are now allowed."
-		}
-	}()
+	// Both verifications send at most one result, so neither blocks once
+	// the command has returned
+	resultCh := make(chan string, 2)
+	otpEntered := make(chan struct{})
 
 	go func() {
-		answer, err := c.getOTP(ctx)
-		if err != nil {
-			resultCh <- formatErr(err)
-		}
+		answer, err := c.getOTP(verifyCtx, lang)
+		close(otpEntered)
 
-		if err := client.VerifyOTP(ctx, c.Args, answer); err != nil {
-			resultCh <- formatErr(err)
+		if err != nil {
+			resultCh <- formatErr(lang, err)
+		} else if err := client.VerifyOTP(verifyCtx, c.Args, answer); err != nil {
+			resultCh <- formatErr(lang, err)
 		} else {
-			resultCh <- "OTP validation successful. Git operations are now allowed."
+			resultCh <- i18n.Text(lang, "OTP validation successful. Git operations are now allowed.")
 		}
 	}()
 
+	pushFallback := time.NewTimer(fallbackDelay)
+	defer pushFallback.Stop()
+	pushFallbackCh := pushFallback.C
+
+	// pushDone is closed once the push authentication has returned, and
+	// with it the cancellation of a pending notification
+	var pushDone chan struct{}
+	var spinner *time.Ticker
+	var spinnerCh <-chan time.Time
+	frame := 0
+
 	var message string
-	select {
-	case message = <-resultCh:
-	case <-ctx.Done():
-		message = formatErr(ctx.Err())
+	for message == "" {
+		select {
+		case <-otpEntered:
+			otpEntered, pushFallbackCh, spinnerCh = nil, nil, nil
+		case <-pushFallbackCh:
+			pushFallbackCh = nil
+
+			fmt.Fprint(c.ReadWriter.Out, "\n"+waiting)
+
+			spinner = time.NewTicker(spinnerInterval)
+			spinnerCh = spinner.C
+
+			pushDone = make(chan struct{})
+			go func() {
+				defer close(pushDone)
+
+				if err := client.PushAuth(verifyCtx, c.Args); err != nil {
+					log.ContextLogger(verifyCtx).WithError(err).Info("twofactorverify: push authentication failed")
+					return
+				}
+
+				resultCh <- i18n.Text(lang, "OTP has been validated by Push Authentication. Git operations are now allowed.")
+			}()
+		case <-spinnerCh:
+			remaining := time.Until(deadline).Round(time.Second)
+			fmt.Fprintf(c.ReadWriter.Out, "\r%s %c %s ", waiting, spinnerFrames[frame%len(spinnerFrames)], i18n.Sprintf(lang, "%v remaining", remaining))
+			frame++
+		case message = <-resultCh:
+		case <-verifyCtx.Done():
+			message = formatErr(lang, verifyCtx.Err())
+		}
+	}
+
+	if spinner != nil {
+		spinner.Stop()
+	}
+
+	// Stops a push authentication still waiting, which cancels its
+	// notification before the command returns
+	cancel()
+	if pushDone != nil {
+		<-pushDone
 	}
 
 	log.WithContextFields(ctx, log.Fields{"message": message}).Info("Two factor verify command finished")
@@ -73,7 +154,7 @@ func (c *Command) Execute(ctx context.Context) (context.Context, error) {
 	return ctx, nil
 }
 
-func (c *Command) getOTP(ctx context.Context) (string, error) {
+func (c *Command) getOTP(ctx context.Context, lang string) (string, error) {
 	var answer string
 	otpLength := int64(64)
 	reader := io.LimitReader(c.ReadWriter.In, otpLength)
@@ -82,12 +163,12 @@ func (c *Command) getOTP(ctx context.Context) (string, error) {
 	}
 
 	if answer == "" {
-		return "", fmt.Errorf("OTP cannot be blank") //revive:disable:error-strings
+		return "", i18n.Errorf(lang, "OTP cannot be blank") //revive:disable:error-strings
 	}
 
 	return answer, nil
 }
 
-func formatErr(err error) string {
-	return fmt.Sprintf("OTP validation failed: %v", err)
+func formatErr(lang string, err error) string {
+	return i18n.Sprintf(lang, "OTP validation failed: %v", err)
 }
@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -27,7 +29,16 @@ func (*blockingReader) Read([]byte) (int, error) {
 }
 
 func setup(t *testing.T) []testserver.TestRequestHandler {
+	requests, _ := setupWithCancel(t)
+
+	return requests
+}
+
+// setupWithCancel also returns the key IDs of the cancelled push
+// notifications
+func setupWithCancel(t *testing.T) ([]testserver.TestRequestHandler, chan string) {
 	waitInfinitely := make(chan struct{})
+	cancelled := make(chan string, 1)
 	requests := []testserver.TestRequestHandler{
 		{
 			Path: "/api/v4/internal/two_factor_manual_otp_check",
@@ -83,12 +94,27 @@ func setup(t *testing.T) []testserver.TestRequestHandler {
 				}
 			},
 		},
+		{
+			Path: "/api/v4/internal/two_factor_push_otp_cancel",
+			Handler: func(_ http.ResponseWriter, r *http.Request) {
+				var requestBody *twofactorverify.RequestBody
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&requestBody))
+
+				select {
+				case cancelled <- requestBody.KeyID:
+				default:
+				}
+			},
+		},
 	}
 
-	return requests
+	return requests, cancelled
 }
 
-const errorHeader = "OTP validation failed: "
+const (
+	errorHeader         = "OTP validation failed: "
+	pushFallbackMessage = "No OTP entered, waiting for push authentication..."
+)
 
 func TestExecute(t *testing.T) {
 	requests := setup(t)
@@ -99,6 +125,7 @@ func TestExecute(t *testing.T) {
 		desc           string
 		arguments      *commandargs.Shell
 		input          io.Reader
+		pushFallback   bool
 		expectedOutput string
 	}{
 		{
@@ -115,7 +142,8 @@ func TestExecute(t *testing.T) {
 			desc:           "Verify via push authentication",
 			arguments:      &commandargs.Shell{GitlabKeyId: "verify_via_push"},
 			input:          &blockingReader{},
-			expectedOutput: "OTP has been validated by Push Authentication. Git operations are now allowed.\n",
+			pushFallback:   true,
+			expectedOutput: pushFallbackMessage + "\nOTP has been validated by Push Authentication. Git operations are now allowed.\n",
 		},
 		{
 			desc:           "With an empty OTP",
@@ -126,7 +154,7 @@ func TestExecute(t *testing.T) {
 		{
 			desc:           "With bad response",
 			arguments:      &commandargs.Shell{GitlabKeyId: "-1"},
-			expectedOutput: errorHeader + "Parsing failed\n",
+			expectedOutput: errorHeader + "Parsing failed: /api/v4/internal/two_factor_manual_otp_check: empty response\n",
 		},
 		{
 			desc:           "With API returns an error",
@@ -154,6 +182,11 @@ func TestExecute(t *testing.T) {
 				input = bytes.NewBufferString("123456\n")
 			}
 
+			if tc.pushFallback {
+				defer func(delay time.Duration) { pushFallbackDelay = delay }(pushFallbackDelay)
+				pushFallbackDelay = time.Millisecond
+			}
+
 			cmd := &Command{
 				Config:     &config.Config{GitlabUrl: url},
 				Args:       tc.arguments,
@@ -168,6 +201,33 @@ func TestExecute(t *testing.T) {
 	}
 }
 
+func TestOTPEnteredAfterPushFallback(t *testing.T) {
+	requests := setup(t)
+
+	defer func(delay time.Duration) { pushFallbackDelay = delay }(pushFallbackDelay)
+	pushFallbackDelay = time.Millisecond
+
+	input, inputWriter := io.Pipe()
+	output := &bytes.Buffer{}
+
+	url := testserver.StartSocketHttpServer(t, requests)
+	cmd := &Command{
+		Config:     &config.Config{GitlabUrl: url},
+		Args:       &commandargs.Shell{GitlabKeyId: "verify_via_otp"},
+		ReadWriter: &readwriter.ReadWriter{Out: output, In: input},
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		inputWriter.Write([]byte("123456\n"))
+	}()
+
+	_, err := cmd.Execute(context.Background())
+
+	require.NoError(t, err)
+	require.Equal(t, prompt+"\n"+pushFallbackMessage+"\nOTP validation successful. Git operations are now allowed.\n", output.String())
+}
+
 func TestCanceledContext(t *testing.T) {
 	requests := setup(t)
 
@@ -192,3 +252,64 @@ func TestCanceledContext(t *testing.T) {
 	require.NoError(t, <-errCh)
 	require.Equal(t, prompt+"\n"+errorHeader+"context canceled\n", output.String())
 }
+
+func TestPushCountdown(t *testing.T) {
+	requests, cancelled := setupWithCancel(t)
+
+	defer func(interval time.Duration) { spinnerInterval = interval }(spinnerInterval)
+	spinnerInterval = 10 * time.Millisecond
+
+	output := &bytes.Buffer{}
+
+	url := testserver.StartSocketHttpServer(t, requests)
+	cmd := &Command{
+		Config: &config.Config{
+			GitlabUrl: url,
+			TwoFactor: config.TwoFactorConfig{
+				Timeout:           config.YamlDuration(100 * time.Millisecond),
+				PushFallbackDelay: config.YamlDuration(time.Millisecond),
+			},
+		},
+		Args:       &commandargs.Shell{GitlabKeyId: "wait_infinitely"},
+		ReadWriter: &readwriter.ReadWriter{Out: output, In: &blockingReader{}},
+	}
+
+	_, err := cmd.Execute(context.Background())
+	require.NoError(t, err)
+
+	require.Contains(t, output.String(), "\r"+pushFallbackMessage+" | ")
+	require.Contains(t, output.String(), " remaining ")
+	require.True(t, strings.HasSuffix(output.String(), "\n"+errorHeader+"context deadline exceeded\n"))
+
+	// The pending notification is cancelled before the command returns
+	require.Len(t, cancelled, 1)
+	require.Equal(t, "wait_infinitely", <-cancelled)
+}
+
+func TestPushCancelledOnOTP(t *testing.T) {
+	requests, cancelled := setupWithCancel(t)
+
+	defer func(delay time.Duration) { pushFallbackDelay = delay }(pushFallbackDelay)
+	pushFallbackDelay = time.Millisecond
+
+	input, inputWriter := io.Pipe()
+	output := &bytes.Buffer{}
+
+	url := testserver.StartSocketHttpServer(t, requests)
+	cmd := &Command{
+		Config:     &config.Config{GitlabUrl: url},
+		Args:       &commandargs.Shell{GitlabKeyId: "verify_via_otp"},
+		ReadWriter: &readwriter.ReadWriter{Out: output, In: input},
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		inputWriter.Write([]byte("123456\n"))
+	}()
+
+	_, err := cmd.Execute(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, cancelled, 1)
+	require.Equal(t, "verify_via_otp", <-cancelled)
+}
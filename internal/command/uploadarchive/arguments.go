@@ -0,0 +1,143 @@
+package uploadarchive
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxArguments is the limit of git upload-archive itself
+	maxArguments = 64
+	maxPktSize   = 0xffff
+	flushPkt     = "0000"
+
+	argumentPrefix = "argument "
+)
+
+var errTooManyArguments = fmt.Errorf("too many options (>%d)", maxArguments)
+
+// arguments are the options of git archive sent by the client before the
+// flush packet, such as --format=zip, and the raw packets carrying them
+type arguments struct {
+	args []string
+	raw  []byte
+}
+
+// readArguments reads the argument packets of the client. It doesn't read
+// ahead of the flush packet, so that Gitaly can be given the raw packets
+// followed by the rest of r.
+func readArguments(r io.Reader) (*arguments, error) {
+	a := &arguments{}
+
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF && len(a.raw) == 0 {
+				// Left to git upload-archive to report
+				return a, nil
+			}
+
+			return nil, fmt.Errorf("read argument: %w", err)
+		}
+		a.raw = append(a.raw, header...)
+
+		if string(header) == flushPkt {
+			return a, nil
+		}
+
+		length, err := strconv.ParseUint(string(header), 16, 16)
+		if err != nil || length <= 4 || length > maxPktSize {
+			return nil, fmt.Errorf("read argument: invalid packet length %q", header)
+		}
+
+		payload := make([]byte, length-4)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, fmt.Errorf("read argument: %w", err)
+		}
+		a.raw = append(a.raw, payload...)
+
+		arg, ok := strings.CutPrefix(strings.TrimSuffix(string(payload), "\n"), argumentPrefix)
+		if !ok {
+			return nil, fmt.Errorf("read argument: unexpected packet %q", payload)
+		}
+
+		if len(a.args) == maxArguments {
+			return nil, errTooManyArguments
+		}
+		a.args = append(a.args, arg)
+	}
+}
+
+// reader returns the input of git upload-archive: the packets read by
+// readArguments, then the rest of r
+func (a *arguments) reader(r io.Reader) io.Reader {
+	return io.MultiReader(bytes.NewReader(a.raw), r)
+}
+
+// validate checks the format and the prefix of the archive against formats,
+// once the options are parsed like git archive does, until "--"
+func (a *arguments) validate(formats []string) error {
+	for i := 0; i < len(a.args); i++ {
+		arg := a.args[i]
+		if arg == "--" {
+			return nil
+		}
+
+		name, value, hasValue := strings.Cut(arg, "=")
+		if name != "--format" && name != "--prefix" {
+			continue
+		}
+
+		if !hasValue {
+			if i+1 == len(a.args) {
+				return fmt.Errorf("%s requires a value", name)
+			}
+			i++
+			value = a.args[i]
+		}
+
+		switch name {
+		case "--format":
+			if len(formats) > 0 && !slices.Contains(formats, value) {
+				return fmt.Errorf("format %q isn't allowed, use one of: %s", value, strings.Join(formats, ", "))
+			}
+		case "--prefix":
+			if err := validatePrefix(value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// validatePrefix rejects the prefixes that would extract the archive out of
+// the current directory
+func validatePrefix(prefix string) error {
+	if path.IsAbs(prefix) || strings.HasPrefix(prefix, "\\") {
+		return errors.New("the prefix can't be an absolute path")
+	}
+
+	for _, elem := range strings.FieldsFunc(prefix, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if elem == ".." {
+			return errors.New(`the prefix can't contain ".."`)
+		}
+	}
+
+	return nil
+}
+
+// nack tells the client that the archive is refused, for git archive to
+// show reason
+func nack(w io.Writer, reason string) error {
+	pkt := "NACK " + reason + "\n"
+	_, err := fmt.Fprintf(w, "%04x%s%s", len(pkt)+4, pkt, flushPkt)
+
+	return err
+}
@@ -0,0 +1,124 @@
+package uploadarchive
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadArguments(t *testing.T) {
+	input := "001aargument --format=zip\n001cargument --prefix=repo/\n0012argument HEAD\n0000rest"
+	in := strings.NewReader(input)
+
+	a, err := readArguments(in)
+	require.NoError(t, err)
+	require.Equal(t, []string{"--format=zip", "--prefix=repo/", "HEAD"}, a.args)
+
+	replayed, err := io.ReadAll(a.reader(in))
+	require.NoError(t, err)
+	require.Equal(t, input, string(replayed))
+}
+
+func TestReadArgumentsErrors(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		input         string
+		expectedError string
+	}{
+		{
+			desc:          "an invalid length",
+			input:         "zzzzargument HEAD\n",
+			expectedError: `read argument: invalid packet length "zzzz"`,
+		},
+		{
+			desc:          "a truncated packet",
+			input:         "0012argu",
+			expectedError: "read argument: unexpected EOF",
+		},
+		{
+			desc:          "no flush packet",
+			input:         "0012argument HEAD\n",
+			expectedError: "read argument: EOF",
+		},
+		{
+			desc:          "another packet",
+			input:         "0009done\n0000",
+			expectedError: `read argument: unexpected packet "done\n"`,
+		},
+		{
+			desc:          "too many arguments",
+			input:         strings.Repeat("0012argument HEAD\n", maxArguments+1) + "0000",
+			expectedError: "too many options (>64)",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := readArguments(strings.NewReader(tc.input))
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestValidateArguments(t *testing.T) {
+	formats := []string{"tar", "zip"}
+
+	testCases := []struct {
+		desc          string
+		args          []string
+		expectedError string
+	}{
+		{desc: "no options", args: []string{"HEAD"}},
+		{desc: "an allowed format", args: []string{"--format=zip", "HEAD"}},
+		{desc: "an allowed format as the next argument", args: []string{"--format", "tar", "HEAD"}},
+		{desc: "a relative prefix", args: []string{"--prefix=repo/v1.0/", "HEAD"}},
+		{desc: "a path after --", args: []string{"HEAD", "--", "--format=7z"}},
+		{
+			desc:          "a disallowed format",
+			args:          []string{"--format=tgz", "HEAD"},
+			expectedError: `format "tgz" isn't allowed, use one of: tar, zip`,
+		},
+		{
+			desc:          "a disallowed format as the next argument",
+			args:          []string{"--format", "tgz", "HEAD"},
+			expectedError: `format "tgz" isn't allowed, use one of: tar, zip`,
+		},
+		{
+			desc:          "a missing format",
+			args:          []string{"--format"},
+			expectedError: "--format requires a value",
+		},
+		{
+			desc:          "an absolute prefix",
+			args:          []string{"--prefix=/etc/", "HEAD"},
+			expectedError: "the prefix can't be an absolute path",
+		},
+		{
+			desc:          "a prefix going up",
+			args:          []string{"--prefix", "repo/../../", "HEAD"},
+			expectedError: `the prefix can't contain ".."`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := (&arguments{args: tc.args}).validate(formats)
+			if tc.expectedError == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.expectedError)
+			}
+		})
+	}
+
+	require.NoError(t, (&arguments{args: []string{"--format=7z"}}).validate(nil))
+}
+
+func TestNack(t *testing.T) {
+	out := &bytes.Buffer{}
+	require.NoError(t, nack(out, "no"))
+	require.Equal(t, "000cNACK no\n0000", out.String())
+}
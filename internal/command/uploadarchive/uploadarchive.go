@@ -3,12 +3,14 @@ package uploadarchive
 
 import (
 	"context"
+	"fmt"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/accessverifier"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disallowedcommand"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/commandlimiter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 )
 
@@ -34,6 +36,12 @@ func (c *Command) Execute(ctx context.Context) (context.Context, error) {
 		return ctx, err
 	}
 
+	release, err := commandlimiter.Acquire(ctx, c.Args.CommandType, response, c.Args.Env.RemoteAddr)
+	if err != nil {
+		return ctx, err
+	}
+	defer release()
+
 	logData := command.NewLogData(
 		response.Gitaly.Repo.GlProjectPath,
 		response.Username,
@@ -42,7 +50,20 @@ func (c *Command) Execute(ctx context.Context) (context.Context, error) {
 	)
 	ctxWithLogData := context.WithValue(ctx, logInfo{}, logData)
 
-	return ctxWithLogData, c.performGitalyCall(ctx, response)
+	archiveArgs, err := readArguments(c.ReadWriter.In)
+	if err != nil {
+		return ctxWithLogData, err
+	}
+
+	if err := archiveArgs.validate(c.Config.UploadArchive.Formats); err != nil {
+		if nackErr := nack(c.ReadWriter.Out, err.Error()); nackErr != nil {
+			return ctxWithLogData, nackErr
+		}
+
+		return ctxWithLogData, fmt.Errorf("archive refused: %w", err)
+	}
+
+	return ctxWithLogData, c.performGitalyCall(ctx, response, archiveArgs.reader(c.ReadWriter.In))
 }
 
 func (c *Command) verifyAccess(ctx context.Context, repo string) (*accessverifier.Response, error) {
@@ -19,7 +19,7 @@ import (
 func TestAllowedAccess(t *testing.T) {
 	gitalyAddress, _ := testserver.StartGitalyServer(t, "unix")
 	requests := requesthandlers.BuildAllowedWithGitalyHandlers(t, gitalyAddress)
-	cmd := setup(t, "1", requests)
+	cmd := setup(t, "1", requests, "001aargument --format=zip\n0000")
 	cmd.Config.GitalyClient.InitSidechannelRegistry(context.Background())
 
 	correlationID := correlation.SafeRandomID()
@@ -37,17 +37,28 @@ func TestAllowedAccess(t *testing.T) {
 func TestForbiddenAccess(t *testing.T) {
 	requests := requesthandlers.BuildDisallowedByAPIHandlers(t)
 
-	cmd := setup(t, "disallowed", requests)
+	cmd := setup(t, "disallowed", requests, "0000")
 
 	_, err := cmd.Execute(context.Background())
 	require.Equal(t, "Disallowed by API call", err.Error())
 }
 
-func setup(t *testing.T, keyID string, requests []testserver.TestRequestHandler) *Command {
+func TestRefusedArchive(t *testing.T) {
+	gitalyAddress, _ := testserver.StartGitalyServer(t, "unix")
+	requests := requesthandlers.BuildAllowedWithGitalyHandlers(t, gitalyAddress)
+	cmd := setup(t, "1", requests, "0019argument --format=7z\n0000")
+	cmd.Config.UploadArchive = config.DefaultUploadArchiveConfig
+
+	_, err := cmd.Execute(context.Background())
+	require.EqualError(t, err, `archive refused: format "7z" isn't allowed, use one of: tar, tgz, tar.gz, zip`)
+	require.Equal(t, `0046NACK format "7z" isn't allowed, use one of: tar, tgz, tar.gz, zip`+"\n0000", cmd.ReadWriter.Out.(*bytes.Buffer).String())
+}
+
+func setup(t *testing.T, keyID string, requests []testserver.TestRequestHandler, in string) *Command {
 	url := testserver.StartHttpServer(t, requests)
 
 	output := &bytes.Buffer{}
-	input := bytes.NewBufferString("input")
+	input := bytes.NewBufferString(in)
 
 	cmd := &Command{
 		Config:     &config.Config{GitlabUrl: url},
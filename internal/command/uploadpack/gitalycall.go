@@ -16,10 +16,14 @@ import (
 func (c *Command) performGitalyCall(ctx context.Context, response *accessverifier.Response) (*pb.PackfileNegotiationStatistics, error) {
 	gc := handler.NewGitalyCommand(c.Config, string(commandargs.UploadPack), response)
 
+	if !response.Gitaly.FeatureEnabled(accessverifier.FeatureUploadPackSidechannel, true) {
+		return nil, c.performStreamedGitalyCall(ctx, gc, response)
+	}
+
 	request := &pb.SSHUploadPackWithSidechannelRequest{
-		Repository:       &response.Gitaly.Repo,
+		Repository:       response.Gitaly.Repository(),
 		GitProtocol:      c.Args.Env.GitProtocolVersion,
-		GitConfigOptions: response.GitConfigOptions,
+		GitConfigOptions: response.UploadPackConfigOptions(),
 	}
 
 	var stats *pb.PackfileNegotiationStatistics
@@ -28,7 +32,7 @@ func (c *Command) performGitalyCall(ctx context.Context, response *accessverifie
 		defer cancel()
 
 		registry := c.Config.GitalyClient.SidechannelRegistry
-		rw := c.ReadWriter
+		rw := gc.ReadWriter(c.ReadWriter)
 
 		var (
 			result client.UploadPackResult
@@ -43,3 +47,21 @@ func (c *Command) performGitalyCall(ctx context.Context, response *accessverifie
 
 	return stats, err
 }
+
+// performStreamedGitalyCall sends the packfile through the gRPC stream
+// instead of the sidechannel, which doesn't report negotiation statistics
+func (c *Command) performStreamedGitalyCall(ctx context.Context, gc *handler.GitalyCommand, response *accessverifier.Response) error {
+	request := &pb.SSHUploadPackRequest{
+		Repository:       response.Gitaly.Repository(),
+		GitProtocol:      c.Args.Env.GitProtocolVersion,
+		GitConfigOptions: response.UploadPackConfigOptions(),
+	}
+
+	return gc.RunGitalyCommand(ctx, func(ctx context.Context, conn *grpc.ClientConn) (int32, error) {
+		ctx, cancel := gc.PrepareContext(ctx, request.Repository, c.Args.Env)
+		defer cancel()
+
+		rw := gc.ReadWriter(c.ReadWriter)
+		return client.UploadPack(ctx, conn, rw.In, rw.Out, rw.ErrOut, request)
+	})
+}
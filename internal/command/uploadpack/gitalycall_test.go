@@ -3,7 +3,9 @@ package uploadpack
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -13,6 +15,7 @@ import (
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/testhelper/requesthandlers"
 )
@@ -80,3 +83,47 @@ func TestUploadPack(t *testing.T) {
 		})
 	}
 }
+
+func TestUploadPackWithoutSidechannel(t *testing.T) {
+	gitalyAddress, _ := testserver.StartGitalyServer(t, "unix")
+
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/allowed",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				body := map[string]interface{}{
+					"status": true,
+					"gl_id":  "1",
+					"gitaly": map[string]interface{}{
+						"repository": map[string]interface{}{"gl_repository": "group/repo"},
+						"address":    gitalyAddress,
+						"features":   map[string]string{accessverifier.FeatureUploadPackSidechannel: "false"},
+					},
+				}
+				require.NoError(t, json.NewEncoder(w).Encode(body))
+			},
+		},
+	}
+	url := testserver.StartHttpServer(t, requests)
+
+	output := &bytes.Buffer{}
+	repo := "group/repo"
+
+	cfg := &config.Config{GitlabUrl: url}
+	cfg.GitalyClient.InitSidechannelRegistry(context.Background())
+
+	cmd := &Command{
+		Config: cfg,
+		Args: &commandargs.Shell{
+			GitlabKeyId: "1",
+			CommandType: commandargs.UploadPack,
+			SshArgs:     []string{"git-upload-pack", repo},
+			Env:         sshenv.Env{IsSSHConnection: true, OriginalCommand: "git-upload-pack " + repo, RemoteAddr: "127.0.0.1"},
+		},
+		ReadWriter: &readwriter.ReadWriter{ErrOut: output, Out: output, In: &bytes.Buffer{}},
+	}
+
+	_, err := cmd.Execute(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "UploadPack: "+repo, output.String())
+}
@@ -13,6 +13,7 @@ import (
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/accessverifier"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/customaction"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disallowedcommand"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/commandlimiter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 )
 
@@ -38,6 +39,17 @@ func (c *Command) Execute(ctx context.Context) (context.Context, error) {
 		return ctx, err
 	}
 
+	allowed := c.Config.GitProtocol.AllowedVersions(response.Gitaly.Repo.GlProjectPath)
+	if c.Args.Env, err = c.Args.Env.RestrictProtocolVersion(allowed); err != nil {
+		return ctx, err
+	}
+
+	release, err := commandlimiter.Acquire(ctx, c.Args.CommandType, response, c.Args.Env.RemoteAddr)
+	if err != nil {
+		return ctx, err
+	}
+	defer release()
+
 	logData := command.NewLogData(
 		response.Gitaly.Repo.GlProjectPath,
 		response.Username,
@@ -11,7 +11,9 @@ import (
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/commandlimiter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/testhelper/requesthandlers"
 )
 
@@ -39,6 +41,33 @@ func TestForbiddenAccess(t *testing.T) {
 	require.Equal(t, "Disallowed by API call", err.Error())
 }
 
+func TestLimitedAccess(t *testing.T) {
+	gitalyAddress, _ := testserver.StartGitalyServer(t, "unix")
+	requests := requesthandlers.BuildAllowedWithGitalyHandlers(t, gitalyAddress)
+	cmd := setup(t, "1", requests)
+	cmd.Args.CommandType = commandargs.UploadPack
+
+	limiter, err := commandlimiter.New(map[string]config.CommandLimitsConfig{"git-upload-pack": {MaxSessions: 1}})
+	require.NoError(t, err)
+	_, err = limiter.Acquire("git-upload-pack", "user-2", "192.0.2.1")
+	require.NoError(t, err)
+
+	_, err = cmd.Execute(commandlimiter.NewContext(context.Background(), limiter))
+	require.ErrorIs(t, err, commandlimiter.ErrLimited)
+}
+
+func TestDisabledProtocolVersion(t *testing.T) {
+	gitalyAddress, _ := testserver.StartGitalyServer(t, "unix")
+	requests := requesthandlers.BuildAllowedWithGitalyHandlers(t, gitalyAddress)
+	cmd := setup(t, "1", requests)
+	cmd.Config.GitProtocol = config.GitProtocolConfig{
+		Repositories: []config.GitProtocolRepositoryConfig{{Pattern: "group/*", Versions: []int{2}}},
+	}
+
+	_, err := cmd.Execute(context.Background())
+	require.ErrorIs(t, err, sshenv.ErrProtocolVersionDisabled)
+}
+
 func setup(t *testing.T, keyID string, requests []testserver.TestRequestHandler) *Command {
 	url := testserver.StartHttpServer(t, requests)
 
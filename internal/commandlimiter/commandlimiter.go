@@ -0,0 +1,160 @@
+// Package commandlimiter caps the git commands gitlab-sshd runs at once,
+// globally, per user and per source IP, so that a single client can't take up
+// the whole daemon
+package commandlimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+// Limits that can be exceeded, as reported by metrics
+const (
+	limitMaxSessions        = "max_sessions"
+	limitMaxSessionsPerUser = "max_sessions_per_user"
+	limitMaxSessionsPerIP   = "max_sessions_per_ip"
+)
+
+// supportedCommands are the commands that acquire a session from the Limiter
+var supportedCommands = []commandargs.CommandType{commandargs.UploadPack, commandargs.ReceivePack, commandargs.UploadArchive}
+
+// ErrLimited is returned by Acquire when a limit is reached. Its message is
+// shown to the user.
+var ErrLimited = errors.New("Too many connections, please retry later")
+
+// Limiter enforces config.CommandLimitsConfig. A nil Limiter has no limits.
+type Limiter struct {
+	limits map[string]config.CommandLimitsConfig
+
+	mu       sync.Mutex
+	sessions map[string]int64
+	users    map[sessionKey]int64
+	ips      map[sessionKey]int64
+}
+
+// sessionKey identifies the sessions of a command by a user or a source IP
+type sessionKey struct {
+	command string
+	id      string
+}
+
+// New returns a Limiter enforcing limits, by command name such as
+// git-upload-pack
+func New(limits map[string]config.CommandLimitsConfig) (*Limiter, error) {
+	for command := range limits {
+		if !slices.Contains(supportedCommands, commandargs.CommandType(command)) {
+			return nil, fmt.Errorf("command limits aren't supported for %q, only for %v", command, supportedCommands)
+		}
+	}
+
+	return &Limiter{
+		limits:   limits,
+		sessions: make(map[string]int64),
+		users:    make(map[sessionKey]int64),
+		ips:      make(map[sessionKey]int64),
+	}, nil
+}
+
+type limiterKey struct{}
+
+// NewContext returns a context carrying l, for the commands run with it
+func NewContext(ctx context.Context, l *Limiter) context.Context {
+	return context.WithValue(ctx, limiterKey{}, l)
+}
+
+// Acquire reserves a session of command, for the user given access by
+// response, with the Limiter of ctx if any. The returned function releases it
+// and must be called once the command is over.
+func Acquire(ctx context.Context, command commandargs.CommandType, response *accessverifier.Response, remoteAddr string) (func(), error) {
+	l, _ := ctx.Value(limiterKey{}).(*Limiter)
+
+	user := response.UserID
+	if user == "" && response.KeyID != 0 {
+		user = "key-" + strconv.Itoa(response.KeyID)
+	}
+
+	return l.Acquire(string(command), user, gitlabnet.ParseIP(remoteAddr))
+}
+
+// Acquire reserves a session of command for user from ip. The returned
+// function releases it and must be called once the command is over.
+func (l *Limiter) Acquire(command, user, ip string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	limits, ok := l.limits[command]
+	if !ok {
+		return func() {}, nil
+	}
+
+	userKey := sessionKey{command: command, id: user}
+	ipKey := sessionKey{command: command, id: ip}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case exceeds(limits.MaxSessions, l.sessions[command]):
+		return nil, limited(command, limitMaxSessions)
+	case user != "" && exceeds(limits.MaxSessionsPerUser, l.users[userKey]):
+		return nil, limited(command, limitMaxSessionsPerUser)
+	case ip != "" && exceeds(limits.MaxSessionsPerIP, l.ips[ipKey]):
+		return nil, limited(command, limitMaxSessionsPerIP)
+	}
+
+	l.sessions[command]++
+	increment(l.users, userKey, user != "")
+	increment(l.ips, ipKey, ip != "")
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			l.sessions[command]--
+			decrement(l.users, userKey, user != "")
+			decrement(l.ips, ipKey, ip != "")
+		})
+	}, nil
+}
+
+func exceeds(limit, sessions int64) bool {
+	return limit > 0 && sessions >= limit
+}
+
+func limited(command, limit string) error {
+	metrics.SshdLimitedCommandsTotal.WithLabelValues(command, limit).Inc()
+
+	return ErrLimited
+}
+
+func increment(counts map[sessionKey]int64, key sessionKey, ok bool) {
+	if ok {
+		counts[key]++
+	}
+}
+
+// decrement forgets the keys without sessions, so that the maps only hold
+// the users and IPs with commands running
+func decrement(counts map[sessionKey]int64, key sessionKey, ok bool) {
+	if !ok {
+		return
+	}
+
+	if counts[key]--; counts[key] <= 0 {
+		delete(counts, key)
+	}
+}
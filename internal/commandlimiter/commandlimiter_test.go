@@ -0,0 +1,127 @@
+package commandlimiter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+func newLimiter(t *testing.T, limits map[string]config.CommandLimitsConfig) *Limiter {
+	t.Helper()
+
+	l, err := New(limits)
+	require.NoError(t, err)
+
+	return l
+}
+
+func TestLimits(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		limits        config.CommandLimitsConfig
+		user, ip      string
+		expectedLimit string
+	}{
+		{
+			desc:          "global limit",
+			limits:        config.CommandLimitsConfig{MaxSessions: 2},
+			user:          "user-3",
+			ip:            "192.0.2.3",
+			expectedLimit: limitMaxSessions,
+		},
+		{
+			desc:          "per-user limit",
+			limits:        config.CommandLimitsConfig{MaxSessionsPerUser: 1},
+			user:          "user-1",
+			ip:            "192.0.2.3",
+			expectedLimit: limitMaxSessionsPerUser,
+		},
+		{
+			desc:          "per-IP limit",
+			limits:        config.CommandLimitsConfig{MaxSessionsPerIP: 1},
+			user:          "user-3",
+			ip:            "192.0.2.1",
+			expectedLimit: limitMaxSessionsPerIP,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			metrics.SshdLimitedCommandsTotal.Reset()
+
+			l := newLimiter(t, map[string]config.CommandLimitsConfig{"git-upload-pack": tc.limits})
+
+			first, err := l.Acquire("git-upload-pack", "user-1", "192.0.2.1")
+			require.NoError(t, err)
+			_, err = l.Acquire("git-upload-pack", "user-2", "192.0.2.2")
+			require.NoError(t, err)
+
+			_, err = l.Acquire("git-upload-pack", tc.user, tc.ip)
+			require.ErrorIs(t, err, ErrLimited)
+			require.InDelta(t, 1, testutil.ToFloat64(metrics.SshdLimitedCommandsTotal.WithLabelValues("git-upload-pack", tc.expectedLimit)), 0.1)
+
+			// Other commands aren't limited
+			_, err = l.Acquire("git-receive-pack", tc.user, tc.ip)
+			require.NoError(t, err)
+
+			// Releasing twice has no effect
+			first()
+			first()
+
+			_, err = l.Acquire("git-upload-pack", tc.user, tc.ip)
+			require.NoError(t, err)
+
+			_, err = l.Acquire("git-upload-pack", tc.user, tc.ip)
+			require.ErrorIs(t, err, ErrLimited)
+		})
+	}
+}
+
+func TestReleaseForgetsIdleUsers(t *testing.T) {
+	l := newLimiter(t, map[string]config.CommandLimitsConfig{"git-receive-pack": {MaxSessionsPerUser: 1, MaxSessionsPerIP: 1}})
+
+	release, err := l.Acquire("git-receive-pack", "user-1", "192.0.2.1")
+	require.NoError(t, err)
+	require.Len(t, l.users, 1)
+	require.Len(t, l.ips, 1)
+
+	release()
+	require.Empty(t, l.users)
+	require.Empty(t, l.ips)
+}
+
+func TestUnsupportedCommand(t *testing.T) {
+	_, err := New(map[string]config.CommandLimitsConfig{"git-lfs-transfer": {MaxSessions: 1}})
+	require.EqualError(t, err, `command limits aren't supported for "git-lfs-transfer", only for [git-upload-pack git-receive-pack git-upload-archive]`)
+}
+
+func TestAcquireFromContext(t *testing.T) {
+	l := newLimiter(t, map[string]config.CommandLimitsConfig{"git-upload-pack": {MaxSessionsPerUser: 1}})
+	ctx := NewContext(context.Background(), l)
+
+	// Keys that don't belong to a user are limited on their own
+	deployKey := &accessverifier.Response{KeyID: 1}
+	_, err := Acquire(ctx, commandargs.UploadPack, deployKey, "192.0.2.1:22000")
+	require.NoError(t, err)
+	_, err = Acquire(ctx, commandargs.UploadPack, deployKey, "192.0.2.1:22001")
+	require.ErrorIs(t, err, ErrLimited)
+
+	user := &accessverifier.Response{KeyID: 2, UserID: "user-1"}
+	_, err = Acquire(ctx, commandargs.UploadPack, user, "192.0.2.1:22002")
+	require.NoError(t, err)
+	_, err = Acquire(ctx, commandargs.UploadPack, &accessverifier.Response{KeyID: 3, UserID: "user-1"}, "192.0.2.1:22003")
+	require.ErrorIs(t, err, ErrLimited)
+
+	// Without a Limiter, as in gitlab-shell, nothing is limited
+	for i := 0; i < 2; i++ {
+		_, err = Acquire(context.Background(), commandargs.UploadPack, deployKey, "192.0.2.1")
+		require.NoError(t, err)
+	}
+}
@@ -1,17 +1,24 @@
 package config
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
+	"gitlab.com/gitlab-org/labkit/log"
+	grpccodes "google.golang.org/grpc/codes"
 	"gopkg.in/yaml.v3"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/bufferpool"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/faultinjection"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitaly"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
 )
@@ -19,6 +26,9 @@ import (
 const (
 	configFile            = "config.yml"
 	defaultSecretFileName = ".gitlab_shell_secret"
+
+	// minGitalyWindowSize is the smallest gRPC flow control window, 64KiB
+	minGitalyWindowSize = 65535
 )
 
 type YamlDuration time.Duration
@@ -31,25 +41,334 @@ type GSSAPIConfig struct {
 }
 
 type ServerConfig struct {
-	Listen                  string       `yaml:"listen,omitempty"`
-	ProxyProtocol           bool         `yaml:"proxy_protocol,omitempty"`
-	ProxyPolicy             string       `yaml:"proxy_policy,omitempty"`
-	ProxyAllowed            []string     `yaml:"proxy_allowed,omitempty"`
-	WebListen               string       `yaml:"web_listen,omitempty"`
-	ConcurrentSessionsLimit int64        `yaml:"concurrent_sessions_limit,omitempty"`
-	ClientAliveInterval     YamlDuration `yaml:"client_alive_interval,omitempty"`
-	GracePeriod             YamlDuration `yaml:"grace_period"`
-	ProxyHeaderTimeout      YamlDuration `yaml:"proxy_header_timeout"`
-	LoginGraceTime          YamlDuration `yaml:"login_grace_time"`
-	ReadinessProbe          string       `yaml:"readiness_probe"`
-	LivenessProbe           string       `yaml:"liveness_probe"`
-	HostKeyFiles            []string     `yaml:"host_key_files,omitempty"`
-	HostCertFiles           []string     `yaml:"host_cert_files,omitempty"`
-	MACs                    []string     `yaml:"macs"`
-	KexAlgorithms           []string     `yaml:"kex_algorithms"`
-	PublicKeyAlgorithms     []string     `yaml:"public_key_algorithms"`
-	Ciphers                 []string     `yaml:"ciphers"`
-	GSSAPI                  GSSAPIConfig `yaml:"gssapi,omitempty"`
+	Listen                  string   `yaml:"listen,omitempty"`
+	ProxyProtocol           bool     `yaml:"proxy_protocol,omitempty"`
+	ProxyPolicy             string   `yaml:"proxy_policy,omitempty"`
+	ProxyAllowed            []string `yaml:"proxy_allowed,omitempty"`
+	WebListen               string   `yaml:"web_listen,omitempty"`
+	ConcurrentSessionsLimit int64    `yaml:"concurrent_sessions_limit,omitempty"`
+	// MaxSessionsPerConnection caps the sessions opened over the lifetime of
+	// a connection, one after the other or at once. 0 is no limit.
+	MaxSessionsPerConnection int64        `yaml:"max_sessions_per_connection,omitempty"`
+	ClientAliveInterval      YamlDuration `yaml:"client_alive_interval,omitempty"`
+	GracePeriod              YamlDuration `yaml:"grace_period"`
+	ProxyHeaderTimeout       YamlDuration `yaml:"proxy_header_timeout"`
+	LoginGraceTime           YamlDuration `yaml:"login_grace_time"`
+	ReadinessProbe           string       `yaml:"readiness_probe"`
+	LivenessProbe            string       `yaml:"liveness_probe"`
+	HostKeyFiles             []string     `yaml:"host_key_files,omitempty"`
+	// HostKeyDir enables the generation of host keys: a key of each type
+	// missing from HostKeyFiles is loaded from this directory, and generated
+	// into it on first startup.
+	HostKeyDir          string       `yaml:"host_key_dir,omitempty"`
+	HostCertFiles       []string     `yaml:"host_cert_files,omitempty"`
+	MACs                []string     `yaml:"macs"`
+	KexAlgorithms       []string     `yaml:"kex_algorithms"`
+	PublicKeyAlgorithms []string     `yaml:"public_key_algorithms"`
+	Ciphers             []string     `yaml:"ciphers"`
+	GSSAPI              GSSAPIConfig `yaml:"gssapi,omitempty"`
+	// TrustedUserCAKeys is a file of CA public keys, in authorized_keys
+	// format, trusted to sign user certificates. The key ID of a certificate
+	// is the GitLab username it authenticates.
+	TrustedUserCAKeys string `yaml:"trusted_user_ca_keys,omitempty"`
+	// AuthorizedPrincipals are the principals accepted in certificates signed
+	// by TrustedUserCAKeys. Defaults to the SSH user.
+	AuthorizedPrincipals []string `yaml:"authorized_principals,omitempty"`
+	// AuditPipe is where an audit record is written for each SSH connection:
+	// "fd:N" for an inherited file descriptor or "unix:PATH" for a unix socket
+	AuditPipe string `yaml:"audit_pipe,omitempty"`
+	// AuditLog is where a record of the outcome of each SSH session is
+	// written: "file:PATH", "syslog:[NETWORK://ADDRESS]" or an HTTP(S) URL
+	AuditLog string `yaml:"audit_log,omitempty"`
+	// ConnectionLimits bounds the connections accepted from clients
+	ConnectionLimits ConnectionLimitsConfig `yaml:"connection_limits,omitempty"`
+	// Tarpit holds, rather than refuses, the connections of the source IPs
+	// that repeatedly fail to authenticate
+	Tarpit TarpitConfig `yaml:"tarpit,omitempty"`
+	// CommandLimits bounds the sessions of git-upload-pack,
+	// git-receive-pack and git-upload-archive running at once, by command
+	CommandLimits map[string]CommandLimitsConfig `yaml:"command_limits,omitempty"`
+	// Algorithms pins the algorithms negotiated with clients. It takes
+	// precedence over MACs, KexAlgorithms and Ciphers.
+	Algorithms AlgorithmsConfig `yaml:"algorithms,omitempty"`
+	// SFTP serves the refs and archives of projects over the sftp subsystem
+	SFTP SFTPConfig `yaml:"sftp,omitempty"`
+	// SessionIdleTimeout closes a session once no data has been sent or
+	// received on it for this long
+	SessionIdleTimeout YamlDuration `yaml:"session_idle_timeout,omitempty"`
+	// MaxSessionDuration closes a session once it has been open for this long
+	MaxSessionDuration YamlDuration `yaml:"max_session_duration,omitempty"`
+	// PackfileGracePeriod extends MaxSessionDuration once a git-upload-pack
+	// session has started sending the packfile, so that nearly complete
+	// clones can finish
+	PackfileGracePeriod YamlDuration `yaml:"packfile_grace_period,omitempty"`
+	// DebugListen is the loopback address or Unix socket of the debug
+	// endpoints, such as pprof. They are disabled when empty.
+	DebugListen string `yaml:"debug_listen,omitempty"`
+	// IPFilter refuses connections by source IP before the SSH handshake
+	IPFilter IPFilterConfig `yaml:"ip_filter,omitempty"`
+	// HealthChecks serves probes that check the SSH listener and the
+	// internal API, beyond ReadinessProbe and LivenessProbe
+	HealthChecks HealthChecksConfig `yaml:"health_checks,omitempty"`
+	// BandwidthLimits throttles the data exchanged by sessions
+	BandwidthLimits BandwidthLimitsConfig `yaml:"bandwidth_limits,omitempty"`
+	// TrustedGateways are the CIDRs of the bastion hosts, such as ProxyJump
+	// hosts, trusted to report the address of the clients they proxy with a
+	// proxy-info@gitlab.com request
+	TrustedGateways []string `yaml:"trusted_gateways,omitempty"`
+	// PushHeartbeatInterval is how long a git-receive-pack session may be
+	// quiet, e.g. while its hooks run, before a keepalive is sent on it
+	PushHeartbeatInterval YamlDuration `yaml:"push_heartbeat_interval,omitempty"`
+	// SessionWebhooks are notified of each SSH session once it ends
+	SessionWebhooks SessionWebhooksConfig `yaml:"session_webhooks,omitempty"`
+	// AuthorizedKeysFallback authenticates keys against a local
+	// authorized_keys file while the internal API is unavailable
+	AuthorizedKeysFallback AuthorizedKeysFallbackConfig `yaml:"authorized_keys_fallback,omitempty"`
+	// ExternalAuthenticator decides on the public keys, and SSH users,
+	// GitLab doesn't know
+	ExternalAuthenticator ExternalAuthenticatorConfig `yaml:"external_authenticator,omitempty"`
+	// Listeners are addresses listened on besides Listen, each with its own
+	// PROXY protocol settings and, optionally, connection limits
+	Listeners []ListenerConfig `yaml:"listeners,omitempty"`
+	// ClientAliveCountMax is the number of keepalives, sent every
+	// ClientAliveInterval, that may go unanswered before the connection is
+	// closed. Unanswered keepalives never close it when 0.
+	ClientAliveCountMax int `yaml:"client_alive_count_max,omitempty"`
+	// TCPKeepAlive sets the TCP keepalive probes of accepted connections
+	TCPKeepAlive TCPKeepAliveConfig `yaml:"tcp_keepalive,omitempty"`
+	// KeyRestrictions reject the user keys of weak types or sizes before
+	// they're looked up in GitLab
+	KeyRestrictions KeyRestrictionsConfig `yaml:"key_restrictions,omitempty"`
+	// WebListenSocket and DebugListenSocket set the permissions of the
+	// sockets of WebListen and DebugListen when these are Unix sockets,
+	// given as unix:<path>
+	WebListenSocket   UnixSocketConfig `yaml:"web_listen_socket,omitempty"`
+	DebugListenSocket UnixSocketConfig `yaml:"debug_listen_socket,omitempty"`
+	// Maintenance only allows fetches, e.g. during upgrades
+	Maintenance MaintenanceConfig `yaml:"maintenance,omitempty"`
+}
+
+// MaintenanceConfig puts gitlab-sshd in a read-only maintenance mode, in
+// which fetches are allowed but pushes and the commands issuing credentials
+// are refused with Message. The mode can also be switched on and off with
+// the debug endpoints.
+type MaintenanceConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Message is a text/template template, given the .Command refused and
+	// the .Instance name
+	Message string `yaml:"message,omitempty"`
+	// FromAPI also follows the maintenance mode of the internal API,
+	// fetched again every RefreshInterval
+	FromAPI         bool         `yaml:"from_api,omitempty"`
+	RefreshInterval YamlDuration `yaml:"refresh_interval,omitempty"`
+}
+
+// UnixSocketConfig sets the permissions of a Unix socket listened on. Those
+// left empty are the defaults of the process.
+type UnixSocketConfig struct {
+	// Mode is in octal, e.g. "0660"
+	Mode string `yaml:"mode,omitempty"`
+	// Owner and Group are names or numeric IDs
+	Owner string `yaml:"owner,omitempty"`
+	Group string `yaml:"group,omitempty"`
+}
+
+// KeyRestrictionsConfig restricts the user keys by type, like the SSH key
+// restrictions of GitLab: each is the minimum size of the keys of its type in
+// bits, 0 to allow them all, or -1 to reject them all. DSA keys are always
+// rejected.
+type KeyRestrictionsConfig struct {
+	RSA       int `yaml:"rsa,omitempty"`
+	ECDSA     int `yaml:"ecdsa,omitempty"`
+	ED25519   int `yaml:"ed25519,omitempty"`
+	ECDSASK   int `yaml:"ecdsa_sk,omitempty"`
+	ED25519SK int `yaml:"ed25519_sk,omitempty"`
+}
+
+// TCPKeepAliveConfig sets the TCP keepalive probes of accepted connections.
+// Zero values keep the defaults of Go, which probes every 15s.
+type TCPKeepAliveConfig struct {
+	// Disabled turns the probes off
+	Disabled bool `yaml:"disabled,omitempty"`
+	// Idle is how long a connection may be quiet before the first probe
+	Idle YamlDuration `yaml:"idle,omitempty"`
+	// Interval is the time between unanswered probes. Linux only.
+	Interval YamlDuration `yaml:"interval,omitempty"`
+	// Count is the number of unanswered probes after which the connection
+	// is dropped. Linux only.
+	Count int `yaml:"count,omitempty"`
+}
+
+// ListenerConfig is an additional address gitlab-sshd listens on
+type ListenerConfig struct {
+	// Listen is the address, such as ":2222" or "[fe80::1%eth0]:22" for a
+	// link-local IPv6 address scoped to an interface
+	Listen        string   `yaml:"listen"`
+	ProxyProtocol bool     `yaml:"proxy_protocol,omitempty"`
+	ProxyPolicy   string   `yaml:"proxy_policy,omitempty"`
+	ProxyAllowed  []string `yaml:"proxy_allowed,omitempty"`
+	// ConnectionLimits bounds the connections accepted on this address
+	// alone. The connections count against the limits of the server when
+	// unset.
+	ConnectionLimits *ConnectionLimitsConfig `yaml:"connection_limits,omitempty"`
+	// ConfigDir is the directory of the config.yml of another GitLab
+	// instance, served on this address in place of this one
+	ConfigDir string `yaml:"config_dir,omitempty"`
+}
+
+// AuthorizedKeysFallbackConfig configures the authorized_keys file keys are
+// looked up in when the internal API can't be reached, as written by GitLab
+// with the command="... key-<id>" option of each key. The sessions
+// authenticated with it may only run read-only git commands.
+type AuthorizedKeysFallbackConfig struct {
+	File string `yaml:"file,omitempty"`
+	// RefreshInterval is how often the file is checked for changes, a
+	// minute by default
+	RefreshInterval YamlDuration `yaml:"refresh_interval,omitempty"`
+}
+
+// ExternalAuthenticatorConfig asks a program, or else an HTTP endpoint,
+// whether to accept a public key unknown to GitLab, or offered for another
+// SSH user than User, and which GitLab user or key it authenticates
+type ExternalAuthenticatorConfig struct {
+	// Command is run with the key as JSON on its standard input, and prints
+	// its verdict as JSON
+	Command []string `yaml:"command,omitempty"`
+	// URL is posted the key as JSON, and answers its verdict as JSON
+	URL string `yaml:"url,omitempty"`
+	// Timeout bounds each decision, 5 seconds by default
+	Timeout YamlDuration `yaml:"timeout,omitempty"`
+}
+
+// SessionWebhooksConfig posts a JSON summary of each SSH session, once it
+// ends, to URLs
+type SessionWebhooksConfig struct {
+	URLs []string `yaml:"urls,omitempty"`
+	// SecretFile holds the key of the HMAC-SHA256 signature of the bodies,
+	// sent as the X-Gitlab-Shell-Signature header
+	SecretFile string `yaml:"secret_file,omitempty"`
+	// MaxAttempts is the number of attempts to post a summary, including
+	// the first one. Defaults to 3.
+	MaxAttempts int `yaml:"max_attempts,omitempty"`
+	// Timeout bounds each attempt. Defaults to 10s.
+	Timeout YamlDuration `yaml:"timeout,omitempty"`
+}
+
+// BandwidthLimitsConfig caps the bytes per second read from and written to
+// the SSH channels of sessions, in both directions together. A zero value
+// leaves the corresponding limit disabled.
+type BandwidthLimitsConfig struct {
+	// PerSession caps each session
+	PerSession int64 `yaml:"per_session,omitempty"`
+	// Global caps all the sessions together
+	Global int64 `yaml:"global,omitempty"`
+}
+
+// IPFilterConfig lists the CIDRs, or single IPs, gitlab-sshd accepts and
+// refuses connections from. A denied IP is refused even if it's allowed.
+type IPFilterConfig struct {
+	// Allow restricts connections to these CIDRs when set
+	Allow []string `yaml:"allow,omitempty"`
+	Deny  []string `yaml:"deny,omitempty"`
+	// FromAPI adds the CIDRs returned by the internal API to the lists,
+	// fetched again every RefreshInterval
+	FromAPI         bool         `yaml:"from_api,omitempty"`
+	RefreshInterval YamlDuration `yaml:"refresh_interval,omitempty"`
+	// CheckUserRestrictions refuses commands from IPs outside of the
+	// allowed_ips returned by /allowed for the user, if any
+	CheckUserRestrictions bool `yaml:"check_user_restrictions,omitempty"`
+}
+
+// HealthChecksConfig configures the health checks of gitlab-sshd, served on
+// WebListen. The result of a check is cached for Interval, so that frequent
+// probes don't load the internal API.
+type HealthChecksConfig struct {
+	// ReadinessPath returns 200 OK once the SSH listener accepts connections
+	// and the internal API is reachable
+	ReadinessPath string `yaml:"readiness_path,omitempty"`
+	// LivenessPath returns 200 OK unless the SSH listener stopped accepting
+	// connections while serving
+	LivenessPath string       `yaml:"liveness_path,omitempty"`
+	Interval     YamlDuration `yaml:"interval,omitempty"`
+	Timeout      YamlDuration `yaml:"timeout,omitempty"`
+}
+
+// SFTPConfig configures the read-only sftp subsystem of gitlab-sshd
+type SFTPConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+}
+
+// AlgorithmsConfig lists the algorithms gitlab-sshd allows, in order of
+// preference. An empty list keeps the defaults for that kind of algorithm.
+type AlgorithmsConfig struct {
+	KexAlgorithms []string `yaml:"kex_algorithms,omitempty"`
+	Ciphers       []string `yaml:"ciphers,omitempty"`
+	MACs          []string `yaml:"macs,omitempty"`
+	// HostKeyAlgorithms are the signature algorithms offered for host keys
+	// and their certificates, such as rsa-sha2-512 for an RSA host key
+	HostKeyAlgorithms []string `yaml:"host_key_algorithms,omitempty"`
+}
+
+// ConnectionLimitsConfig bounds the connections accepted by gitlab-sshd. A
+// zero value leaves the corresponding limit disabled.
+type ConnectionLimitsConfig struct {
+	// RatePerIP is the sustained number of new connections per second
+	// accepted from a single source IP
+	RatePerIP float64 `yaml:"rate_per_ip,omitempty"`
+	// BurstPerIP is the number of connections a source IP may open at once
+	// before RatePerIP applies. Defaults to 1 when RatePerIP is set.
+	BurstPerIP int `yaml:"burst_per_ip,omitempty"`
+	// BanDuration is how long all connections from a source IP are refused
+	// after it exceeds RatePerIP
+	BanDuration YamlDuration `yaml:"ban_duration,omitempty"`
+	// MaxConnections caps the number of connections open at once
+	MaxConnections int64 `yaml:"max_connections,omitempty"`
+	// MaxUnauthenticated caps the number of connections that haven't yet
+	// completed authentication
+	MaxUnauthenticated int64 `yaml:"max_unauthenticated,omitempty"`
+	// MaxAuthenticated caps the number of authenticated connections
+	MaxAuthenticated int64 `yaml:"max_authenticated,omitempty"`
+}
+
+// TarpitConfig wastes the time of the scanners that guess credentials: once a
+// source IP failed to authenticate MaxFailures times within Window, its
+// connections are held for Duration before the SSH handshake, slowly sent
+// lines that precede the version of the server, and logged. The tarpit is
+// disabled when MaxFailures is 0.
+type TarpitConfig struct {
+	MaxFailures int          `yaml:"max_failures,omitempty"`
+	Window      YamlDuration `yaml:"window,omitempty"`
+	// Duration is how long a source IP stays in the tarpit after it's put in
+	Duration YamlDuration `yaml:"duration,omitempty"`
+	// Interval is how often a line is sent to a held connection
+	Interval YamlDuration `yaml:"interval,omitempty"`
+	// MaxHold is how long a connection is held before it's closed
+	MaxHold YamlDuration `yaml:"max_hold,omitempty"`
+	// MaxConnections caps the connections held at once. The others from
+	// the source IPs in the tarpit are closed right away.
+	MaxConnections int64 `yaml:"max_connections,omitempty"`
+}
+
+// CommandLimitsConfig caps the sessions of a command running at once. A zero
+// value leaves the corresponding limit disabled.
+type CommandLimitsConfig struct {
+	MaxSessions int64 `yaml:"max_sessions,omitempty"`
+	// MaxSessionsPerUser applies to each GitLab user, or to each key for
+	// keys that don't belong to a user
+	MaxSessionsPerUser int64 `yaml:"max_sessions_per_user,omitempty"`
+	MaxSessionsPerIP   int64 `yaml:"max_sessions_per_ip,omitempty"`
+}
+
+// OpenTelemetryConfig configures the export of OpenTelemetry traces
+type OpenTelemetryConfig struct {
+	// Endpoint is the host:port of an OTLP/HTTP collector. Tracing is
+	// disabled when empty.
+	Endpoint string `yaml:"endpoint,omitempty"`
+	// Insecure connects to the collector without TLS
+	Insecure bool `yaml:"insecure,omitempty"`
+	// SamplingRatio is the fraction of connections traced, from 0 to 1.
+	// Defaults to 1.
+	SamplingRatio float64 `yaml:"sampling_ratio"`
 }
 
 type HttpSettingsConfig struct {
@@ -58,6 +377,107 @@ type HttpSettingsConfig struct {
 	ReadTimeoutSeconds uint64 `yaml:"read_timeout"`
 	CaFile             string `yaml:"ca_file"`
 	CaPath             string `yaml:"ca_path"`
+	ClientCert         string `yaml:"client_cert,omitempty"`
+	ClientKey          string `yaml:"client_key,omitempty"`
+	TLSServerName      string `yaml:"tls_server_name,omitempty"`
+	// HTTP2 multiplexes the requests to GitLab over fewer connections
+	HTTP2 HTTP2Config `yaml:"http2,omitempty"`
+	// RateLimits throttle the requests to GitLab
+	RateLimits APIRateLimitsConfig `yaml:"rate_limits,omitempty"`
+	// Endpoints override read_timeout and the retries by path below
+	// /api/v4/internal, such as allowed
+	Endpoints map[string]APIEndpointConfig `yaml:"endpoints,omitempty"`
+	// SlowRequestThreshold logs a warning for the requests to GitLab taking
+	// longer, retries included
+	SlowRequestThreshold YamlDuration `yaml:"slow_request_threshold,omitempty"`
+	// StrictResponses fails the responses of GitLab with fields unknown to
+	// gitlab-shell, instead of ignoring these fields
+	StrictResponses bool `yaml:"strict_responses,omitempty"`
+	// Compression compresses the large request bodies to GitLab, and its
+	// responses
+	Compression HTTPCompressionConfig `yaml:"compression,omitempty"`
+	// Mirror sends a copy of a share of the requests to another GitLab
+	Mirror APIMirrorConfig `yaml:"mirror,omitempty"`
+	// RetryBudget bounds the retries of all the requests to GitLab made for
+	// an SSH connection, as client.RetryBudget. Unset leaves only the
+	// retries of each request.
+	RetryBudget *int `yaml:"retry_budget,omitempty"`
+}
+
+// APIMirrorConfig mirrors Percentage of the requests to the internal API to
+// the GitLab at URL, as client.MirrorTo, ignoring its responses. The copies
+// are signed with the secret in SecretFile, the secret_file of gitlab-shell
+// by default.
+type APIMirrorConfig struct {
+	URL        string  `yaml:"url,omitempty"`
+	Percentage float64 `yaml:"percentage,omitempty"`
+	SecretFile string  `yaml:"secret_file,omitempty"`
+}
+
+// HTTPCompressionConfig compresses the JSON request bodies to GitLab of at
+// least MinSizeBytes with gzip, and asks GitLab to compress its responses
+type HTTPCompressionConfig struct {
+	Enabled      bool `yaml:"enabled,omitempty"`
+	MinSizeBytes int  `yaml:"min_size_bytes,omitempty"`
+}
+
+// APIRateLimitsConfig limits the requests per second to the internal API, as
+// client.RateLimits. PerSecond and Burst are the global limit.
+type APIRateLimitsConfig struct {
+	PerSecond float64 `yaml:"per_second,omitempty"`
+	Burst     int     `yaml:"burst,omitempty"`
+	// Endpoints are limits by path below /api/v4/internal, such as allowed
+	Endpoints    map[string]APIRateLimitConfig `yaml:"endpoints,omitempty"`
+	QueueTimeout YamlDuration                  `yaml:"queue_timeout,omitempty"`
+}
+
+// APIRateLimitConfig is the rate of requests to an internal API endpoint
+type APIRateLimitConfig struct {
+	PerSecond float64 `yaml:"per_second"`
+	Burst     int     `yaml:"burst,omitempty"`
+}
+
+func (r *APIRateLimitsConfig) rateLimits() client.RateLimits {
+	limits := client.RateLimits{
+		Global:       client.RateLimit{PerSecond: r.PerSecond, Burst: r.Burst},
+		QueueTimeout: time.Duration(r.QueueTimeout),
+	}
+
+	if len(r.Endpoints) > 0 {
+		limits.Endpoints = make(map[string]client.RateLimit, len(r.Endpoints))
+		for endpoint, limit := range r.Endpoints {
+			limits.Endpoints[endpoint] = client.RateLimit{PerSecond: limit.PerSecond, Burst: limit.Burst}
+		}
+	}
+
+	return limits
+}
+
+// APIEndpointConfig is the timeout and retries of the requests to an internal
+// API endpoint, as client.EndpointPolicy
+type APIEndpointConfig struct {
+	Timeout YamlDuration `yaml:"timeout,omitempty"`
+	// Retries after the first attempt. Unset keeps the default.
+	Retries *int `yaml:"retries,omitempty"`
+}
+
+func endpointPolicies(endpoints map[string]APIEndpointConfig) map[string]client.EndpointPolicy {
+	policies := make(map[string]client.EndpointPolicy, len(endpoints))
+	for endpoint, e := range endpoints {
+		policies[endpoint] = client.EndpointPolicy{Timeout: time.Duration(e.Timeout), RetryMax: e.Retries}
+	}
+
+	return policies
+}
+
+// HTTP2Config enables HTTP/2 to GitLab, as client.HTTP2Settings
+type HTTP2Config struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Cleartext speaks h2c to http:// and http+unix:// URLs
+	Cleartext                  bool         `yaml:"cleartext,omitempty"`
+	StrictMaxConcurrentStreams bool         `yaml:"strict_max_concurrent_streams,omitempty"`
+	ReadIdleTimeout            YamlDuration `yaml:"read_idle_timeout,omitempty"`
+	PingTimeout                YamlDuration `yaml:"ping_timeout,omitempty"`
 }
 
 type LFSConfig struct {
@@ -69,29 +489,445 @@ type PATConfig struct {
 	AllowedScopes []string `yaml:"allowed_scopes,omitempty"`
 }
 
+// GitalyConfig tunes the RPCs made to Gitaly, to ride out its restarts
+type GitalyConfig struct {
+	// WaitForReady makes RPCs wait for an unavailable Gitaly until their
+	// deadline rather than failing straight away
+	WaitForReady bool              `yaml:"wait_for_ready,omitempty"`
+	Retry        GitalyRetryConfig `yaml:"retry,omitempty"`
+	// RPCTimeout is the deadline of RPCs not listed in RPCTimeouts
+	RPCTimeout YamlDuration `yaml:"rpc_timeout,omitempty"`
+	// RPCTimeouts are the deadlines of RPCs by full method name, such as
+	// /gitaly.SSHService/SSHReceivePack
+	RPCTimeouts map[string]YamlDuration `yaml:"rpc_timeouts,omitempty"`
+	// TLS verifies the connections to tls:// Gitaly addresses
+	TLS GitalyTLSConfig `yaml:"tls,omitempty"`
+	// StorageTLS overrides TLS for the Gitaly of the storages returned by
+	// the internal API, by storage name
+	StorageTLS map[string]GitalyTLSConfig `yaml:"storage_tls,omitempty"`
+	// SlowTransferThreshold and LargeTransferThresholdBytes log a warning
+	// for the Git transfers taking longer, or sending or receiving more
+	SlowTransferThreshold       YamlDuration `yaml:"slow_transfer_threshold,omitempty"`
+	LargeTransferThresholdBytes int64        `yaml:"large_transfer_threshold_bytes,omitempty"`
+	// FlowControl bounds the data of the gRPC streams buffered for Gitaly
+	FlowControl GitalyFlowControlConfig `yaml:"flow_control,omitempty"`
+	// MaxWriteStall stops the transfers whose client accepted nothing of
+	// the output of Gitaly for longer, releasing the RPC
+	MaxWriteStall YamlDuration `yaml:"max_write_stall,omitempty"`
+}
+
+// GitalyTLSConfig configures the connections to tls:// Gitaly addresses. The
+// certificate of Gitaly is verified against CAFile, or the system certificate
+// pool, and ClientCert is presented for mutual TLS.
+type GitalyTLSConfig struct {
+	CAFile     string `yaml:"ca_file,omitempty"`
+	ClientCert string `yaml:"client_cert,omitempty"`
+	ClientKey  string `yaml:"client_key,omitempty"`
+	// ServerName is the name the certificate of Gitaly is verified against,
+	// instead of the host of its address
+	ServerName string `yaml:"server_name,omitempty"`
+	// SPIFFE presents an X.509 SVID instead of ClientCert
+	SPIFFE *GitalySPIFFEConfig `yaml:"spiffe,omitempty"`
+}
+
+// GitalySPIFFEConfig presents the X.509 SVID of gitlab-shell, fetched from the
+// SPIFFE Workload API of a local agent and rotated with it, as the client
+// certificate for mutual TLS
+type GitalySPIFFEConfig struct {
+	// WorkloadAPIAddr is the address of the Workload API, e.g.
+	// unix:///run/spire/agent.sock. Defaults to the SPIFFE_ENDPOINT_SOCKET
+	// environment variable.
+	WorkloadAPIAddr string `yaml:"workload_api_addr,omitempty"`
+	// GitalyIDs verify the certificate of Gitaly as an SVID with one of
+	// these SPIFFE IDs, against the trust bundles of the agent rather than
+	// CAFile and ServerName
+	GitalyIDs []string `yaml:"gitaly_ids,omitempty"`
+}
+
+// merge returns c, with the fields it doesn't set taken from defaults
+func (c GitalyTLSConfig) merge(defaults GitalyTLSConfig) GitalyTLSConfig {
+	if c.CAFile == "" {
+		c.CAFile = defaults.CAFile
+	}
+	if c.ClientCert == "" && c.ClientKey == "" && c.SPIFFE == nil {
+		c.ClientCert, c.ClientKey, c.SPIFFE = defaults.ClientCert, defaults.ClientKey, defaults.SPIFFE
+	}
+	if c.ServerName == "" {
+		c.ServerName = defaults.ServerName
+	}
+
+	return c
+}
+
+// tlsConfig returns the TLS configuration of c. The connections presenting
+// SVIDs of the same Workload API share its source from sources.
+func (c GitalyTLSConfig) tlsConfig(sources map[string]*gitaly.SPIFFESource) (*tls.Config, error) {
+	config, err := gitaly.NewTLSConfig(c.CAFile, c.ClientCert, c.ClientKey, c.ServerName)
+	if err != nil || c.SPIFFE == nil {
+		return config, err
+	}
+
+	source, ok := sources[c.SPIFFE.WorkloadAPIAddr]
+	if !ok {
+		source = gitaly.NewSPIFFESource(c.SPIFFE.WorkloadAPIAddr)
+		sources[c.SPIFFE.WorkloadAPIAddr] = source
+	}
+
+	if err := gitaly.ApplySPIFFE(config, source, c.SPIFFE.GitalyIDs); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// GitalyRetryConfig retries the streaming RPCs to Gitaly that fail to be
+// established
+type GitalyRetryConfig struct {
+	MaxAttempts       int          `yaml:"max_attempts,omitempty"`
+	InitialBackoff    YamlDuration `yaml:"initial_backoff,omitempty"`
+	MaxBackoff        YamlDuration `yaml:"max_backoff,omitempty"`
+	BackoffMultiplier float64      `yaml:"backoff_multiplier,omitempty"`
+	// RetryableStatusCodes are gRPC status codes, such as UNAVAILABLE
+	RetryableStatusCodes []string `yaml:"retryable_status_codes,omitempty"`
+}
+
+// GitalyFlowControlConfig tunes the flow control of the gRPC connections to
+// Gitaly, in bytes. Zero values keep the defaults of gRPC.
+type GitalyFlowControlConfig struct {
+	InitialWindowSize     int32 `yaml:"initial_window_size,omitempty"`
+	InitialConnWindowSize int32 `yaml:"initial_conn_window_size,omitempty"`
+	ReadBufferSize        int   `yaml:"read_buffer_size,omitempty"`
+	WriteBufferSize       int   `yaml:"write_buffer_size,omitempty"`
+}
+
+// DiscoverCacheConfig configures the cache of the users discovered through the
+// internal API. A zero TTL disables it.
+type DiscoverCacheConfig struct {
+	TTL YamlDuration `yaml:"ttl,omitempty"`
+	// NegativeTTL is how long unknown keys and users are cached, capped by
+	// TTL
+	NegativeTTL YamlDuration `yaml:"negative_ttl,omitempty"`
+}
+
+// AccessCacheConfig configures the cache of the successful access checks of
+// git-upload-pack and git-upload-archive. Enabled by a TTL.
+type AccessCacheConfig struct {
+	TTL YamlDuration `yaml:"ttl,omitempty"`
+	// InvalidationPath is the path on the web_listen address of gitlab-sshd
+	// GitLab posts to, with a JWT signed with the secret, for the cached
+	// checks of a user or project to be dropped
+	InvalidationPath string `yaml:"invalidation_path,omitempty"`
+}
+
+// GeoConfig configures the requests a Geo secondary site proxies to the
+// primary site
+type GeoConfig struct {
+	// ProxyMessage is shown to users whose requests are proxied, instead of
+	// the default one naming the primary site
+	ProxyMessage     string `yaml:"proxy_message,omitempty"`
+	HideProxyMessage bool   `yaml:"hide_proxy_message,omitempty"`
+}
+
+// LogRotationConfig rotates LogFile once it grows beyond MaxSizeMB. The
+// rotated files are named after LogFile and the time of their rotation.
+type LogRotationConfig struct {
+	// MaxSizeMB enables the rotation
+	MaxSizeMB int64 `yaml:"max_size_mb,omitempty"`
+	// MaxAge and MaxBackups remove the rotated files older than MaxAge, and
+	// all but the MaxBackups most recent ones. Zero keeps them all.
+	MaxAge     YamlDuration `yaml:"max_age,omitempty"`
+	MaxBackups int          `yaml:"max_backups,omitempty"`
+	// Compress gzips the rotated files
+	Compress bool `yaml:"compress,omitempty"`
+}
+
+// UploadArchiveConfig restricts the archives clients may ask
+// git-upload-archive for
+type UploadArchiveConfig struct {
+	// Formats are the allowed values of --format, any when empty
+	Formats []string `yaml:"formats,omitempty"`
+}
+
+// LogSinkConfig sends the logs to syslog or journald instead of LogFile
+type LogSinkConfig struct {
+	// Type is "syslog" or "journald". The logs go to LogFile when empty.
+	Type string `yaml:"type,omitempty"`
+	// Tag names the program in the logs. Defaults to the name of the
+	// executable.
+	Tag    string       `yaml:"tag,omitempty"`
+	Syslog SyslogConfig `yaml:"syslog,omitempty"`
+}
+
+// SyslogConfig configures the syslog daemon the logs are sent to, in the
+// RFC 5424 format
+type SyslogConfig struct {
+	// Network is "unix" for the local daemon, the default, or "tcp" or "tls"
+	// for a remote one
+	Network string `yaml:"network,omitempty"`
+	// Address is the host:port of a remote daemon, or the socket of the local
+	// one, by default /dev/log
+	Address string `yaml:"address,omitempty"`
+	// Facility is e.g. "daemon" or "local0". Defaults to "user".
+	Facility string `yaml:"facility,omitempty"`
+	// CAFile verifies the certificate of a remote daemon over TLS, instead
+	// of the system CAs
+	CAFile string `yaml:"ca_file,omitempty"`
+}
+
+// CommandPolicyConfig restricts the commands users may run. The first rule
+// matching a command decides whether it's allowed; commands matching no rule
+// are allowed, except those issuing credentials when DenyTokenCommands is set.
+type CommandPolicyConfig struct {
+	// DenyTokenCommands denies 2fa_recovery_codes and personal_access_token
+	// unless a rule allows them
+	DenyTokenCommands bool `yaml:"deny_token_commands,omitempty"`
+	// Groups are named lists of usernames that rules can refer to
+	Groups map[string][]string `yaml:"groups,omitempty"`
+	Rules  []CommandPolicyRule `yaml:"rules,omitempty"`
+}
+
+// CommandPolicyRule allows or denies commands, optionally only to the users
+// of some groups or to clients from some networks. An empty list matches
+// anything.
+type CommandPolicyRule struct {
+	// Action is "allow" or "deny"
+	Action string `yaml:"action"`
+	// Commands are command names, such as git-receive-pack, or patterns
+	// such as 2fa_*
+	Commands []string `yaml:"commands,omitempty"`
+	Groups   []string `yaml:"groups,omitempty"`
+	// Networks are CIDRs or IPs of the clients
+	Networks []string `yaml:"networks,omitempty"`
+}
+
+// MOTDConfig configures the messages shown to users, e.g. to announce
+// maintenance windows. Messages are text/template templates, given the
+// .Username of the user and the .Instance name.
+type MOTDConfig struct {
+	// Banner is shown by gitlab-sshd before authentication, when the
+	// username isn't known yet
+	Banner string `yaml:"banner,omitempty"`
+	// Message is shown after authentication, before the output of commands
+	Message string `yaml:"message,omitempty"`
+	// InstanceName defaults to the host of the GitLab URL
+	InstanceName string `yaml:"instance_name,omitempty"`
+	// FromAPI fetches the banner and message from the internal API instead
+	FromAPI bool `yaml:"from_api,omitempty"`
+	// CacheTTL is how long the messages fetched from the internal API are
+	// kept
+	CacheTTL YamlDuration `yaml:"cache_ttl,omitempty"`
+}
+
+// ErrorMessagesConfig replaces the messages shown to users when a command
+// fails. Messages are text/template templates, given the original .Message,
+// the .SupportURL and the .CorrelationID of the command. An empty template
+// keeps the original message.
+type ErrorMessagesConfig struct {
+	SupportURL        string `yaml:"support_url,omitempty"`
+	AccessDenied      string `yaml:"access_denied,omitempty"`
+	APIUnreachable    string `yaml:"api_unreachable,omitempty"`
+	TwoFactorRequired string `yaml:"two_factor_required,omitempty"`
+	RateLimited       string `yaml:"rate_limited,omitempty"`
+}
+
+// LanguageConfig selects the language of the messages shown to users whose
+// client didn't ask for one with LANG or LC_MESSAGES
+type LanguageConfig struct {
+	// Default is the language of the instance, such as "de", English by
+	// default
+	Default string `yaml:"default,omitempty"`
+	// UserPreference looks up the preferred language of the users on GitLab
+	// before running the interactive commands, such as 2fa_verify. discover
+	// always uses it, as it looks up the user anyway.
+	UserPreference bool `yaml:"user_preference,omitempty"`
+}
+
+// TwoFactorConfig configures the 2fa_verify command
+type TwoFactorConfig struct {
+	// Timeout bounds the whole verification, 30 seconds by default
+	Timeout YamlDuration `yaml:"timeout,omitempty"`
+	// PushFallbackDelay is how long to wait for an OTP to be entered before
+	// sending a push notification, 10 seconds by default
+	PushFallbackDelay YamlDuration `yaml:"push_fallback_delay,omitempty"`
+	// PushPollInterval is the wait between two checks of a pending push
+	// notification, 2 seconds by default
+	PushPollInterval YamlDuration `yaml:"push_poll_interval,omitempty"`
+}
+
+// SessionRecordingConfig records the streams of the interactive commands,
+// such as discover, 2fa_verify or personal_access_token, in Dir
+type SessionRecordingConfig struct {
+	// Dir enables the recording
+	Dir string `yaml:"dir,omitempty"`
+	// Format is "asciicast", the default, for an asciicast v2 file of each
+	// session, or "raw" for a file of each of its input and output streams
+	Format string `yaml:"format,omitempty"`
+	// MaxAge removes the recordings older than it. Zero keeps them all.
+	MaxAge YamlDuration `yaml:"max_age,omitempty"`
+}
+
+// AuthorizedKeysCacheConfig caches the keys found by
+// gitlab-shell-authorized-keys-check in Dir, signed with the secret, so that
+// they are still accepted while the internal API is unavailable
+type AuthorizedKeysCacheConfig struct {
+	// Dir enables the cache
+	Dir string `yaml:"dir,omitempty"`
+	// TTL is how long a cached key is used without asking the internal API.
+	// Zero, the default, always asks it.
+	TTL YamlDuration `yaml:"ttl,omitempty"`
+	// MaxAge is how long a cached key is used while the internal API is
+	// unavailable, 1 hour by default
+	MaxAge YamlDuration `yaml:"max_age,omitempty"`
+}
+
+// GitProtocolConfig restricts the git protocol versions, 0, 1 or 2, that
+// clients may speak to Gitaly, e.g. to force version 2 or to disable it while
+// chasing a regression. Clients asking for a version that isn't allowed are
+// downgraded to the highest allowed version below it, and refused when there
+// is none.
+type GitProtocolConfig struct {
+	// Versions are the versions allowed, all of them when empty
+	Versions []int `yaml:"versions,omitempty"`
+	// Repositories override Versions for some projects, the first one
+	// matching the project applying
+	Repositories []GitProtocolRepositoryConfig `yaml:"repositories,omitempty"`
+}
+
+// GitProtocolRepositoryConfig allows versions to the projects matching Pattern
+type GitProtocolRepositoryConfig struct {
+	// Pattern is a shell pattern matched against the full path of the
+	// project, such as gitlab-org/*
+	Pattern  string `yaml:"pattern"`
+	Versions []int  `yaml:"versions"`
+}
+
+// AllowedVersions returns the git protocol versions allowed for the project at
+// projectPath, none allowing any version
+func (c GitProtocolConfig) AllowedVersions(projectPath string) []int {
+	for _, repository := range c.Repositories {
+		if matched, err := path.Match(repository.Pattern, projectPath); err == nil && matched {
+			return repository.Versions
+		}
+	}
+
+	return c.Versions
+}
+
+func (c GitProtocolConfig) validate() error {
+	versions := [][]int{c.Versions}
+	for _, repository := range c.Repositories {
+		if _, err := path.Match(repository.Pattern, ""); err != nil {
+			return fmt.Errorf("invalid git_protocol pattern %q: %w", repository.Pattern, err)
+		}
+		versions = append(versions, repository.Versions)
+	}
+
+	for _, list := range versions {
+		for _, version := range list {
+			if version < 0 || version > 2 {
+				return fmt.Errorf("invalid git_protocol version %d", version)
+			}
+		}
+	}
+
+	return nil
+}
+
+// SelfCheckConfig configures gitlab-shell-check watch, which checks
+// gitlab-shell can serve in a loop
+type SelfCheckConfig struct {
+	// Interval is the time between two rounds of checks
+	Interval YamlDuration `yaml:"interval,omitempty"`
+	// Timeout bounds each check
+	Timeout YamlDuration `yaml:"timeout,omitempty"`
+	// MaxClockSkew is the largest difference between the clocks of
+	// gitlab-shell and the internal API that passes the check, beyond which
+	// the tokens they exchange may be rejected
+	MaxClockSkew YamlDuration `yaml:"max_clock_skew,omitempty"`
+	// StatusFile is where the results of the last round are written, as JSON
+	StatusFile string `yaml:"status_file,omitempty"`
+	// WebListen is the address the Prometheus metrics are served on
+	WebListen string `yaml:"web_listen,omitempty"`
+	// Gitaly are the Gitaly servers whose health is checked
+	Gitaly []SelfCheckGitalyConfig `yaml:"gitaly,omitempty"`
+}
+
+// SelfCheckGitalyConfig is a Gitaly server checked by gitlab-shell-check watch
+type SelfCheckGitalyConfig struct {
+	Address string `yaml:"address"`
+	Token   string `yaml:"token,omitempty"`
+	// PreviousTokens are tried when Gitaly refuses Token, while it's rotated
+	PreviousTokens []string `yaml:"previous_tokens,omitempty"`
+}
+
 type Config struct {
 	User                  string `yaml:"user,omitempty"`
 	RootDir               string
-	LogFile               string `yaml:"log_file,omitempty"`
-	LogFormat             string `yaml:"log_format,omitempty"`
-	LogLevel              string `yaml:"log_level,omitempty"`
-	GitlabUrl             string `yaml:"gitlab_url"`
-	GitlabRelativeURLRoot string `yaml:"gitlab_relative_url_root"`
-	GitlabTracing         string `yaml:"gitlab_tracing"`
-	// SecretFilePath is only for parsing. Application code should always use Secret.
-	SecretFilePath string             `yaml:"secret_file"`
-	Secret         string             `yaml:"secret"`
-	SslCertDir     string             `yaml:"ssl_cert_dir"`
-	HttpSettings   HttpSettingsConfig `yaml:"http_settings"`
-	Server         ServerConfig       `yaml:"sshd"`
-	LFSConfig      LFSConfig          `yaml:"lfs"`
-	PATConfig      PATConfig          `yaml:"pat"`
+	LogFile               string              `yaml:"log_file,omitempty"`
+	LogFormat             string              `yaml:"log_format,omitempty"`
+	LogLevel              string              `yaml:"log_level,omitempty"`
+	LogLevels             map[string]string   `yaml:"log_levels,omitempty"`
+	GitlabUrl             string              `yaml:"gitlab_url"`
+	GitlabRelativeURLRoot string              `yaml:"gitlab_relative_url_root"`
+	GitlabTracing         string              `yaml:"gitlab_tracing"`
+	OpenTelemetry         OpenTelemetryConfig `yaml:"opentelemetry,omitempty"`
+	// SecretFilePath is only for parsing. Application code should always use CurrentSecret.
+	SecretFilePath string              `yaml:"secret_file"`
+	Secret         string              `yaml:"secret"`
+	SslCertDir     string              `yaml:"ssl_cert_dir"`
+	HttpSettings   HttpSettingsConfig  `yaml:"http_settings"`
+	Server         ServerConfig        `yaml:"sshd"`
+	LFSConfig      LFSConfig           `yaml:"lfs"`
+	PATConfig      PATConfig           `yaml:"pat"`
+	Gitaly         GitalyConfig        `yaml:"gitaly"`
+	DiscoverCache  DiscoverCacheConfig `yaml:"discover_cache"`
+	AccessCache    AccessCacheConfig   `yaml:"access_cache"`
+	Geo            GeoConfig           `yaml:"geo"`
+	MOTD           MOTDConfig          `yaml:"motd"`
+	CommandPolicy  CommandPolicyConfig `yaml:"command_policy"`
+	LogSink        LogSinkConfig       `yaml:"log_sink"`
+	LogRotation    LogRotationConfig   `yaml:"log_rotation"`
+	SecretSource   SecretSourceConfig  `yaml:"secret_source"`
+	UploadArchive  UploadArchiveConfig `yaml:"upload_archive"`
+	GitProtocol    GitProtocolConfig   `yaml:"git_protocol"`
+	ErrorMessages  ErrorMessagesConfig `yaml:"error_messages"`
+	TwoFactor      TwoFactorConfig     `yaml:"two_factor"`
+	// Language selects the language of the messages shown to users
+	Language LanguageConfig `yaml:"language,omitempty"`
+	// SessionRecording records the interactive sessions that don't run Git
+	SessionRecording SessionRecordingConfig `yaml:"session_recording,omitempty"`
+	// AuthorizedKeysCache caches the lookups of AuthorizedKeysCommand
+	AuthorizedKeysCache AuthorizedKeysCacheConfig `yaml:"authorized_keys_cache,omitempty"`
+	// PreviousSecrets are still accepted while the secret is rotated, and
+	// tried when GitLab refuses the secret. PreviousSecretFiles is only for
+	// parsing, like SecretFilePath.
+	PreviousSecrets     []string `yaml:"previous_secrets,omitempty"`
+	PreviousSecretFiles []string `yaml:"previous_secret_files,omitempty"`
+	// CopyBufferSize is the size in bytes of the pooled buffers the data of
+	// Git transfers is copied through, 32KiB by default
+	CopyBufferSize int `yaml:"copy_buffer_size,omitempty"`
+	// SelfCheck configures gitlab-shell-check watch
+	SelfCheck SelfCheckConfig `yaml:"self_check,omitempty"`
+	// ProxyRemoteAddrEnv names the ENV gitlab-shell takes the address of the
+	// client from, in place of SSH_CONNECTION, when a TCP load balancer in
+	// front of sshd reports it. Unset, SSH_CONNECTION is used.
+	ProxyRemoteAddrEnv string `yaml:"proxy_remote_addr_env,omitempty"`
 
 	httpClient     *client.HTTPClient
 	httpClientErr  error
 	httpClientOnce sync.Once
 
-	GitalyClient gitaly.Client
+	apiMirror     client.Middleware
+	apiMirrorOnce sync.Once
+
+	secretMu         sync.Mutex
+	secretFetchedAt  time.Time
+	secretRefreshing bool
+
+	// GitlabUrls holds all the URLs when gitlab_url is a list, GitlabUrl
+	// being the first
+	GitlabUrls   []string      `yaml:"-"`
+	GitalyClient gitaly.Client `yaml:"-"`
 }
 
 // The defaults to apply before parsing the config file(s).
@@ -101,8 +937,26 @@ var (
 		LogFormat: "json",
 		LogLevel:  "info",
 		Server:    DefaultServerConfig,
+		MOTD:      DefaultMOTDConfig,
 		User:      "git",
 		PATConfig: DefaultPATConfig,
+		Gitaly:    DefaultGitalyConfig,
+
+		UploadArchive: DefaultUploadArchiveConfig,
+
+		OpenTelemetry: DefaultOpenTelemetryConfig,
+
+		SelfCheck: DefaultSelfCheckConfig,
+	}
+
+	DefaultUploadArchiveConfig = UploadArchiveConfig{
+		Formats: []string{"tar", "tgz", "tar.gz", "zip"},
+	}
+
+	DefaultGitalyConfig = GitalyConfig{
+		Retry: GitalyRetryConfig{
+			RetryableStatusCodes: []string{"UNAVAILABLE"},
+		},
 	}
 
 	DefaultServerConfig = ServerConfig{
@@ -111,10 +965,30 @@ var (
 		ConcurrentSessionsLimit: 10,
 		GracePeriod:             YamlDuration(10 * time.Second),
 		ClientAliveInterval:     YamlDuration(15 * time.Second),
+		ClientAliveCountMax:     3,
 		ProxyHeaderTimeout:      YamlDuration(500 * time.Millisecond),
 		LoginGraceTime:          YamlDuration(60 * time.Second),
 		ReadinessProbe:          "/start",
 		LivenessProbe:           "/health",
+		IPFilter: IPFilterConfig{
+			RefreshInterval: YamlDuration(time.Minute),
+		},
+		Maintenance: MaintenanceConfig{
+			RefreshInterval: YamlDuration(time.Minute),
+		},
+		HealthChecks: HealthChecksConfig{
+			ReadinessPath: "/readiness",
+			LivenessPath:  "/liveness",
+			Interval:      YamlDuration(10 * time.Second),
+			Timeout:       YamlDuration(5 * time.Second),
+		},
+		Tarpit: TarpitConfig{
+			Window:         YamlDuration(10 * time.Minute),
+			Duration:       YamlDuration(time.Hour),
+			Interval:       YamlDuration(10 * time.Second),
+			MaxHold:        YamlDuration(10 * time.Minute),
+			MaxConnections: 1000,
+		},
 		HostKeyFiles: []string{
 			"/run/secrets/ssh-hostkeys/ssh_host_rsa_key",
 			"/run/secrets/ssh-hostkeys/ssh_host_ecdsa_key",
@@ -122,9 +996,23 @@ var (
 		},
 	}
 
+	DefaultMOTDConfig = MOTDConfig{
+		CacheTTL: YamlDuration(time.Minute),
+	}
+
 	DefaultPATConfig = PATConfig{
 		Enabled: true,
 	}
+
+	DefaultOpenTelemetryConfig = OpenTelemetryConfig{
+		SamplingRatio: 1,
+	}
+
+	DefaultSelfCheckConfig = SelfCheckConfig{
+		Interval:     YamlDuration(30 * time.Second),
+		Timeout:      YamlDuration(10 * time.Second),
+		MaxClockSkew: YamlDuration(30 * time.Second),
+	}
 )
 
 func (d *YamlDuration) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -138,21 +1026,107 @@ func (d *YamlDuration) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+// MarshalYAML encodes durations the way they are usually configured, e.g.
+// 10s rather than a number of nanoseconds
+func (d YamlDuration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
 func (c *Config) ApplyGlobalState() {
 	if c.SslCertDir != "" {
 		os.Setenv("SSL_CERT_DIR", c.SslCertDir)
 	}
+
+	bufferpool.SetSize(c.CopyBufferSize)
+}
+
+// clientOpts returns the options of the client of the internal API
+func (s *HttpSettingsConfig) clientOpts() []client.HTTPClientOpt {
+	opts := []client.HTTPClientOpt{client.WithLoadBalancing()}
+
+	if s.ClientCert != "" && s.ClientKey != "" {
+		opts = append(opts, client.WithClientCert(s.ClientCert, s.ClientKey))
+	}
+
+	if s.TLSServerName != "" {
+		opts = append(opts, client.WithTLSServerName(s.TLSServerName))
+	}
+
+	if s.HTTP2.Enabled {
+		opts = append(opts, client.WithHTTP2(client.HTTP2Settings{
+			Cleartext:                  s.HTTP2.Cleartext,
+			StrictMaxConcurrentStreams: s.HTTP2.StrictMaxConcurrentStreams,
+			ReadIdleTimeout:            time.Duration(s.HTTP2.ReadIdleTimeout),
+			PingTimeout:                time.Duration(s.HTTP2.PingTimeout),
+		}))
+	}
+
+	if s.RateLimits.PerSecond > 0 || len(s.RateLimits.Endpoints) > 0 {
+		opts = append(opts, client.WithRateLimits(s.RateLimits.rateLimits()))
+	}
+
+	if len(s.Endpoints) > 0 {
+		opts = append(opts, client.WithEndpointPolicies(endpointPolicies(s.Endpoints)))
+	}
+
+	if s.SlowRequestThreshold > 0 {
+		opts = append(opts, client.WithSlowRequestThreshold(time.Duration(s.SlowRequestThreshold)))
+	}
+
+	if s.StrictResponses {
+		opts = append(opts, client.WithStrictResponses())
+	}
+
+	if s.Compression.Enabled {
+		opts = append(opts, client.WithGzip(s.Compression.MinSizeBytes))
+	}
+
+	return opts
+}
+
+// UnmarshalYAML accepts a list of URLs as gitlab_url, which requests are
+// spread across
+func (c *Config) UnmarshalYAML(value *yaml.Node) error {
+	type plain Config
+
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		key, urls := value.Content[i], value.Content[i+1]
+		if key.Value != "gitlab_url" || urls.Kind != yaml.SequenceNode {
+			continue
+		}
+
+		if err := urls.Decode(&c.GitlabUrls); err != nil {
+			return err
+		}
+		if len(c.GitlabUrls) == 0 {
+			return errors.New("gitlab_url is an empty list")
+		}
+
+		// The first URL is decoded as GitlabUrl
+		value.Content[i+1] = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: c.GitlabUrls[0]}
+	}
+
+	return value.Decode((*plain)(c))
+}
+
+// gitlabURLs returns the URLs of the internal API
+func (c *Config) gitlabURLs() []string {
+	if len(c.GitlabUrls) > 0 {
+		return c.GitlabUrls
+	}
+
+	return []string{c.GitlabUrl}
 }
 
 func (c *Config) HTTPClient() (*client.HTTPClient, error) {
 	c.httpClientOnce.Do(func() {
-		client, err := client.NewHTTPClientWithOpts(
-			c.GitlabUrl,
+		client, err := client.NewHTTPClientWithFailover(
+			c.gitlabURLs(),
 			c.GitlabRelativeURLRoot,
 			c.HttpSettings.CaFile,
 			c.HttpSettings.CaPath,
 			c.HttpSettings.ReadTimeoutSeconds,
-			nil,
+			append(c.HttpSettings.clientOpts(), client.WithMetrics(metrics.APIClient)),
 		)
 		if err != nil {
 			c.httpClientErr = err
@@ -160,7 +1134,7 @@ func (c *Config) HTTPClient() (*client.HTTPClient, error) {
 		}
 
 		tr := client.RetryableHTTP.HTTPClient.Transport
-		client.RetryableHTTP.HTTPClient.Transport = metrics.NewRoundTripper(tr)
+		client.RetryableHTTP.HTTPClient.Transport = metrics.NewRoundTripper(faultinjection.RoundTripper(tr))
 
 		c.httpClient = client
 	})
@@ -168,6 +1142,58 @@ func (c *Config) HTTPClient() (*client.HTTPClient, error) {
 	return c.httpClient, c.httpClientErr
 }
 
+// APIMirror returns the middleware mirroring the requests to the internal API
+// that http_settings.mirror configures, or nil. The requests aren't mirrored
+// if the client of the mirror can't be created, or there's no secret file to
+// sign them with.
+func (c *Config) APIMirror() client.Middleware {
+	c.apiMirrorOnce.Do(func() {
+		mirror := c.HttpSettings.Mirror
+		if mirror.URL == "" || mirror.Percentage <= 0 {
+			return
+		}
+
+		secretFile := mirror.SecretFile
+		if secretFile == "" {
+			secretFile = c.SecretFilePath
+		}
+		if secretFile == "" {
+			log.WithFields(log.Fields{"mirror_url": mirror.URL}).Warn("No secret file to sign the requests to the internal API mirror with, not mirroring")
+			return
+		}
+		if !filepath.IsAbs(secretFile) {
+			secretFile = path.Join(c.RootDir, secretFile)
+		}
+
+		httpClient, err := client.NewHTTPClientWithOpts(
+			mirror.URL,
+			c.GitlabRelativeURLRoot,
+			c.HttpSettings.CaFile,
+			c.HttpSettings.CaPath,
+			c.HttpSettings.ReadTimeoutSeconds,
+			append(c.HttpSettings.clientOpts(), client.WithJWTAuth(secretFile)),
+		)
+		if err != nil {
+			log.WithFields(log.Fields{"mirror_url": mirror.URL}).WithError(err).Warn("Failed to create the client of the internal API mirror, not mirroring")
+			return
+		}
+
+		c.apiMirror = client.MirrorTo(httpClient, min(mirror.Percentage, 100)/100)
+	})
+
+	return c.apiMirror
+}
+
+// NewRetryBudget returns the budget of retries of a new SSH connection that
+// http_settings.retry_budget configures, or nil
+func (c *Config) NewRetryBudget() *client.RetryBudget {
+	if c.HttpSettings.RetryBudget == nil {
+		return nil
+	}
+
+	return client.NewRetryBudget(max(*c.HttpSettings.RetryBudget, 0))
+}
+
 // NewFromDirExternal returns a new config from a given root dir. It also applies defaults appropriate for
 // gitlab-shell running in an external SSH server.
 func NewFromDirExternal(dir string) (*Config, error) {
@@ -213,10 +1239,28 @@ func newFromFile(path string) (*Config, error) {
 		cfg.GitlabUrl = unescapedUrl
 	}
 
+	for i, gitlabURL := range cfg.GitlabUrls {
+		if cfg.GitlabUrls[i], err = url.PathUnescape(gitlabURL); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := parseSecret(cfg); err != nil {
 		return nil, err
 	}
 
+	if err := parsePreviousSecrets(cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.GitalyClient.Options, err = cfg.Gitaly.connectionOptions(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.GitProtocol.validate(); err != nil {
+		return nil, err
+	}
+
 	if len(cfg.LogFile) > 0 && cfg.LogFile[0] != '/' && cfg.RootDir != "" {
 		cfg.LogFile = filepath.Join(cfg.RootDir, cfg.LogFile)
 	}
@@ -224,12 +1268,74 @@ func newFromFile(path string) (*Config, error) {
 	return cfg, nil
 }
 
+// connectionOptions converts the configuration into the options of the
+// Gitaly client
+func (c *GitalyConfig) connectionOptions() (gitaly.ConnectionOptions, error) {
+	options := gitaly.ConnectionOptions{
+		WaitForReady: c.WaitForReady,
+		Retry: gitaly.RetryPolicy{
+			MaxAttempts:       c.Retry.MaxAttempts,
+			InitialBackoff:    time.Duration(c.Retry.InitialBackoff),
+			MaxBackoff:        time.Duration(c.Retry.MaxBackoff),
+			BackoffMultiplier: c.Retry.BackoffMultiplier,
+		},
+		Timeout:     time.Duration(c.RPCTimeout),
+		FlowControl: gitaly.FlowControl(c.FlowControl),
+	}
+
+	// gRPC ignores the windows below its default of 64KiB
+	for _, window := range []int32{c.FlowControl.InitialWindowSize, c.FlowControl.InitialConnWindowSize} {
+		if window < 0 || (window > 0 && window < minGitalyWindowSize) {
+			return options, fmt.Errorf("invalid gitaly flow_control window size %d, must be at least %d", window, minGitalyWindowSize)
+		}
+	}
+
+	for _, name := range c.Retry.RetryableStatusCodes {
+		var code grpccodes.Code
+		if err := code.UnmarshalJSON([]byte(strconv.Quote(name))); err != nil {
+			return options, fmt.Errorf("invalid gitaly retryable status code %q", name)
+		}
+
+		options.Retry.RetryableCodes = append(options.Retry.RetryableCodes, code)
+	}
+
+	if len(c.RPCTimeouts) > 0 {
+		options.Timeouts = make(map[string]time.Duration, len(c.RPCTimeouts))
+		for method, timeout := range c.RPCTimeouts {
+			options.Timeouts[method] = time.Duration(timeout)
+		}
+	}
+
+	var err error
+	sources := make(map[string]*gitaly.SPIFFESource)
+	if c.TLS != (GitalyTLSConfig{}) {
+		if options.TLS, err = c.TLS.tlsConfig(sources); err != nil {
+			return options, fmt.Errorf("invalid gitaly tls: %w", err)
+		}
+	}
+
+	if len(c.StorageTLS) > 0 {
+		options.StorageTLS = make(map[string]*tls.Config, len(c.StorageTLS))
+		for storage, storageTLS := range c.StorageTLS {
+			if options.StorageTLS[storage], err = storageTLS.merge(c.TLS).tlsConfig(sources); err != nil {
+				return options, fmt.Errorf("invalid gitaly tls of storage %q: %w", storage, err)
+			}
+		}
+	}
+
+	return options, nil
+}
+
 func parseSecret(cfg *Config) error {
 	// The secret was parsed from yaml no need to read another file
 	if cfg.Secret != "" {
 		return nil
 	}
 
+	if cfg.SecretSource.IsSet() {
+		return cfg.fetchSecret()
+	}
+
 	if cfg.SecretFilePath == "" {
 		cfg.SecretFilePath = defaultSecretFileName
 	}
@@ -247,6 +1353,24 @@ func parseSecret(cfg *Config) error {
 	return nil
 }
 
+// parsePreviousSecrets appends the content of the previous secret files to the
+// previous secrets
+func parsePreviousSecrets(cfg *Config) error {
+	for _, secretFilePath := range cfg.PreviousSecretFiles {
+		if !filepath.IsAbs(secretFilePath) {
+			secretFilePath = path.Join(cfg.RootDir, secretFilePath)
+		}
+
+		secretFileContent, err := os.ReadFile(filepath.Clean(secretFilePath))
+		if err != nil {
+			return fmt.Errorf("failed to read previous secret file: %w", err)
+		}
+		cfg.PreviousSecrets = append(cfg.PreviousSecrets, string(secretFileContent))
+	}
+
+	return nil
+}
+
 // IsSane checks if the given config fulfills the minimum requirements to be able to run.
 // Any error returned by this function should be a startup error. On the other hand
 // if this function returns nil, this doesn't guarantee the config will work, but it's
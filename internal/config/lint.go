@@ -0,0 +1,156 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Lint returns the problems of the config, read from the config file of
+// RootDir, that don't prevent it from being used: unknown keys, which are
+// ignored otherwise, and files that can't be used.
+func (c *Config) Lint() ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(c.RootDir, configFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	if len(doc.Content) > 0 {
+		problems = unknownKeys(doc.Content[0], reflect.TypeOf(Config{}), "")
+	}
+
+	return append(problems, c.fileProblems()...), nil
+}
+
+// unknownKeys lists the keys of node that don't match a field of t
+func unknownKeys(node *yaml.Node, t reflect.Type, path string) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var problems []string
+
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keyPath := joinKeyPath(path, key.Value)
+
+			field, ok := fields[key.Value]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("line %d: unknown key %s", key.Line, keyPath))
+				continue
+			}
+
+			problems = append(problems, unknownKeys(value, field.Type, keyPath)...)
+		}
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			problems = append(problems, unknownKeys(node.Content[i+1], t.Elem(), joinKeyPath(path, node.Content[i].Value))...)
+		}
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			problems = append(problems, unknownKeys(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+
+	return problems
+}
+
+// yamlFields maps the keys of t to its fields, named as yaml.v3 does
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField, t.NumField())
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = strings.ToLower(field.Name)
+		}
+
+		fields[name] = field
+	}
+
+	return fields
+}
+
+func joinKeyPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// fileProblems lists the files of the config that can't be used
+func (c *Config) fileProblems() []string {
+	var problems []string
+
+	check := func(key, path string, isDir bool) {
+		if path == "" {
+			return
+		}
+
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %v", key, err))
+		case isDir && !info.IsDir():
+			problems = append(problems, fmt.Sprintf("%s: %s isn't a directory", key, path))
+		case !isDir && info.IsDir():
+			problems = append(problems, fmt.Sprintf("%s: %s is a directory", key, path))
+		}
+	}
+
+	check("http_settings.ca_file", c.HttpSettings.CaFile, false)
+	check("http_settings.ca_path", c.HttpSettings.CaPath, true)
+	check("sshd.trusted_user_ca_keys", c.Server.TrustedUserCAKeys, false)
+	check("sshd.gssapi.keytab", c.Server.GSSAPI.Keytab, false)
+	check("log_sink.syslog.ca_file", c.LogSink.Syslog.CAFile, false)
+	check("secret_source.token_file", c.SecretSource.TokenFile, false)
+	check("sshd.session_webhooks.secret_file", c.Server.SessionWebhooks.SecretFile, false)
+	check("sshd.authorized_keys_fallback.file", c.Server.AuthorizedKeysFallback.File, false)
+
+	// The default host keys don't matter unless gitlab-sshd is used
+	if !slices.Equal(c.Server.HostKeyFiles, DefaultServerConfig.HostKeyFiles) {
+		for i, path := range c.Server.HostKeyFiles {
+			check(fmt.Sprintf("sshd.host_key_files[%d]", i), path, false)
+		}
+	}
+
+	for i, path := range c.Server.HostCertFiles {
+		check(fmt.Sprintf("sshd.host_cert_files[%d]", i), path, false)
+	}
+
+	settings := c.HttpSettings
+	switch {
+	case (settings.ClientCert == "") != (settings.ClientKey == ""):
+		problems = append(problems, "http_settings: client_cert and client_key must be set together")
+	case settings.ClientCert != "":
+		if _, err := tls.LoadX509KeyPair(settings.ClientCert, settings.ClientKey); err != nil {
+			problems = append(problems, fmt.Sprintf("http_settings.client_cert: %v", err))
+		}
+	}
+
+	return problems
+}
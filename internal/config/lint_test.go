@@ -0,0 +1,53 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLint(t *testing.T) {
+	dir := t.TempDir()
+	data := `
+secret: sssh
+gitlab_url:
+  - http://gitlab-1.example.com
+  - http://gitlab-2.example.com
+log_levels:
+  session: debug
+sshd:
+  gssapi:
+    libpath: /usr/lib/libgssapi_krb5.so.2
+  grace_period: 10s
+command_policy:
+  groups:
+    admins: [alice]
+  rules:
+    - action: deny
+      comands: [git-receive-pack]
+http_settings:
+  client_cert: /etc/gitlab-shell/client.crt
+unknown: true
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, configFile), []byte(data), 0o600))
+
+	cfg, err := NewFromDir(dir)
+	require.NoError(t, err)
+
+	problems, err := cfg.Lint()
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"line 17: unknown key command_policy.rules[0].comands",
+		"line 20: unknown key unknown",
+		"http_settings: client_cert and client_key must be set together",
+	}, problems)
+}
+
+func TestLintWithoutConfigFile(t *testing.T) {
+	cfg := &Config{RootDir: t.TempDir()}
+
+	_, err := cfg.Lint()
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,234 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+const (
+	secretFetchTimeout = 10 * time.Second
+	// secretResponseLimit bounds the responses read when fetching a secret
+	secretResponseLimit = 1 << 20
+)
+
+// SecretSourceConfig fetches the secret from somewhere else than a plain
+// file. Exactly one of Env, Command and URL is set.
+type SecretSourceConfig struct {
+	// Env names the environment variable holding the secret
+	Env string `yaml:"env,omitempty"`
+	// Command is run to print the secret, e.g. to decrypt it with a KMS
+	Command []string `yaml:"command,omitempty"`
+	// URL is fetched with a GET request, e.g. from Vault. TokenFile holds
+	// a bearer token to authenticate with. The secret is the whole body, or
+	// the value at the dot-separated JSONField of a JSON body.
+	URL       string `yaml:"url,omitempty"`
+	TokenFile string `yaml:"token_file,omitempty"`
+	JSONField string `yaml:"json_field,omitempty"`
+	// RefreshInterval is how often the secret is fetched again by
+	// long-running processes. Zero fetches it once.
+	RefreshInterval YamlDuration `yaml:"refresh_interval,omitempty"`
+}
+
+// IsSet reports whether the secret is fetched from the source
+func (s *SecretSourceConfig) IsSet() bool {
+	return s.Env != "" || len(s.Command) > 0 || s.URL != ""
+}
+
+func (s *SecretSourceConfig) validate() error {
+	set := 0
+	for _, isSet := range []bool{s.Env != "", len(s.Command) > 0, s.URL != ""} {
+		if isSet {
+			set++
+		}
+	}
+
+	if set != 1 {
+		return errors.New("secret_source must set exactly one of env, command or url")
+	}
+
+	return nil
+}
+
+// fetch returns the secret, without the trailing newline of commands and
+// files
+func (s *SecretSourceConfig) fetch(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, secretFetchTimeout)
+	defer cancel()
+
+	var secret string
+	var err error
+
+	switch {
+	case s.Env != "":
+		secret = os.Getenv(s.Env)
+	case len(s.Command) > 0:
+		secret, err = s.runCommand(ctx)
+	default:
+		secret, err = s.fetchURL(ctx)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	secret = strings.TrimRight(secret, "\r\n")
+	if secret == "" {
+		return "", errors.New("the secret source returned an empty secret")
+	}
+
+	return secret, nil
+}
+
+func (s *SecretSourceConfig) runCommand(ctx context.Context) (string, error) {
+	// #nosec G204 -- the command is configured by the administrator
+	cmd := exec.CommandContext(ctx, s.Command[0], s.Command[1:]...)
+
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("secret command failed: %w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+
+		return "", fmt.Errorf("secret command failed: %w", err)
+	}
+
+	return string(output), nil
+}
+
+func (s *SecretSourceConfig) fetchURL(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if s.TokenFile != "" {
+		token, err := os.ReadFile(filepath.Clean(s.TokenFile))
+		if err != nil {
+			return "", fmt.Errorf("failed to read the secret token file: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch the secret: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch the secret: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, secretResponseLimit))
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch the secret: %w", err)
+	}
+
+	if s.JSONField == "" {
+		return string(body), nil
+	}
+
+	return jsonField(body, s.JSONField)
+}
+
+// jsonField returns the string at the dot-separated path of a JSON document,
+// such as data.data.secret for Vault's KV version 2
+func jsonField(body []byte, path string) (string, error) {
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return "", fmt.Errorf("failed to parse the secret response: %w", err)
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("no %q field in the secret response", path)
+		}
+
+		if value, ok = object[key]; !ok {
+			return "", fmt.Errorf("no %q field in the secret response", path)
+		}
+	}
+
+	secret, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("the %q field of the secret response isn't a string", path)
+	}
+
+	return secret, nil
+}
+
+// CurrentSecret returns the secret. When it comes from a secret source with
+// a refresh interval, an expired secret keeps being used while it's fetched
+// again in the background, and after failing to be.
+func (c *Config) CurrentSecret() string {
+	c.secretMu.Lock()
+	defer c.secretMu.Unlock()
+
+	interval := time.Duration(c.SecretSource.RefreshInterval)
+	if interval > 0 && !c.secretFetchedAt.IsZero() && !c.secretRefreshing && time.Since(c.secretFetchedAt) >= interval {
+		c.secretRefreshing = true
+		go c.refreshSecret()
+	}
+
+	return c.Secret
+}
+
+// AcceptedSecrets returns the current secret followed by the previous ones,
+// all of which are accepted while the secret is rotated
+func (c *Config) AcceptedSecrets() []string {
+	secrets := []string{c.CurrentSecret()}
+	for _, secret := range c.PreviousSecrets {
+		if strings.TrimSpace(secret) != "" && !slices.Contains(secrets, secret) {
+			secrets = append(secrets, secret)
+		}
+	}
+
+	return secrets
+}
+
+func (c *Config) refreshSecret() {
+	secret, err := c.SecretSource.fetch(context.Background())
+
+	c.secretMu.Lock()
+	defer c.secretMu.Unlock()
+
+	c.secretRefreshing = false
+	c.secretFetchedAt = time.Now()
+
+	if err != nil {
+		log.WithError(err).Warn("Failed to refresh the secret, keeping the current one")
+		return
+	}
+
+	c.Secret = secret
+}
+
+// fetchSecret sets the secret from the secret source
+func (c *Config) fetchSecret() error {
+	if err := c.SecretSource.validate(); err != nil {
+		return err
+	}
+
+	secret, err := c.SecretSource.fetch(context.Background())
+	if err != nil {
+		return err
+	}
+
+	c.Secret, c.secretFetchedAt = secret, time.Now()
+
+	return nil
+}
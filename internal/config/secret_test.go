@@ -0,0 +1,150 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretSource(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("vault-token\n"), 0o600))
+
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/secret/data/gitlab-shell":
+			w.Write([]byte(`{"data":{"data":{"secret":"from vault"}}}`))
+		default:
+			w.Write([]byte("plain secret\n"))
+		}
+	}))
+	t.Cleanup(vault.Close)
+
+	t.Setenv("TEST_GITLAB_SHELL_SECRET", "from env")
+
+	testCases := []struct {
+		desc           string
+		source         SecretSourceConfig
+		expectedSecret string
+		expectedError  string
+	}{
+		{
+			desc:           "an environment variable",
+			source:         SecretSourceConfig{Env: "TEST_GITLAB_SHELL_SECRET"},
+			expectedSecret: "from env",
+		},
+		{
+			desc:          "an unset environment variable",
+			source:        SecretSourceConfig{Env: "TEST_GITLAB_SHELL_UNSET_SECRET"},
+			expectedError: "the secret source returned an empty secret",
+		},
+		{
+			desc:           "a command",
+			source:         SecretSourceConfig{Command: []string{"echo", "from command"}},
+			expectedSecret: "from command",
+		},
+		{
+			desc:          "a failing command",
+			source:        SecretSourceConfig{Command: []string{"sh", "-c", "echo denied >&2; exit 1"}},
+			expectedError: "secret command failed: exit status 1: denied",
+		},
+		{
+			desc:           "a URL",
+			source:         SecretSourceConfig{URL: vault.URL + "/plain", TokenFile: tokenFile},
+			expectedSecret: "plain secret",
+		},
+		{
+			desc:           "a JSON field",
+			source:         SecretSourceConfig{URL: vault.URL + "/v1/secret/data/gitlab-shell", TokenFile: tokenFile, JSONField: "data.data.secret"},
+			expectedSecret: "from vault",
+		},
+		{
+			desc:          "a missing JSON field",
+			source:        SecretSourceConfig{URL: vault.URL + "/v1/secret/data/gitlab-shell", TokenFile: tokenFile, JSONField: "data.secret"},
+			expectedError: `no "data.secret" field in the secret response`,
+		},
+		{
+			desc:          "a URL without a token",
+			source:        SecretSourceConfig{URL: vault.URL + "/plain"},
+			expectedError: "failed to fetch the secret: 403 Forbidden",
+		},
+		{
+			desc:          "several sources",
+			source:        SecretSourceConfig{Env: "TEST_GITLAB_SHELL_SECRET", URL: vault.URL},
+			expectedError: "secret_source must set exactly one of env, command or url",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cfg := &Config{SecretSource: tc.source}
+
+			err := parseSecret(cfg)
+			if tc.expectedError != "" {
+				require.EqualError(t, err, tc.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedSecret, cfg.CurrentSecret())
+		})
+	}
+}
+
+func TestSecretSourceRefresh(t *testing.T) {
+	t.Setenv("TEST_GITLAB_SHELL_SECRET", "first")
+
+	cfg := &Config{SecretSource: SecretSourceConfig{
+		Env:             "TEST_GITLAB_SHELL_SECRET",
+		RefreshInterval: YamlDuration(time.Millisecond),
+	}}
+	require.NoError(t, parseSecret(cfg))
+	require.Equal(t, "first", cfg.CurrentSecret())
+
+	t.Setenv("TEST_GITLAB_SHELL_SECRET", "second")
+	require.Eventually(t, func() bool {
+		return cfg.CurrentSecret() == "second"
+	}, 5*time.Second, time.Millisecond)
+
+	// A failed refresh keeps the current secret
+	t.Setenv("TEST_GITLAB_SHELL_SECRET", "")
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		require.Equal(t, "second", cfg.CurrentSecret())
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSecretOverridesSecretSource(t *testing.T) {
+	cfg := &Config{Secret: "from yaml", SecretSource: SecretSourceConfig{Env: "TEST_GITLAB_SHELL_UNSET_SECRET"}}
+
+	require.NoError(t, parseSecret(cfg))
+	require.Equal(t, "from yaml", cfg.CurrentSecret())
+}
+
+func TestPreviousSecrets(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "previous_secret"), []byte("from file\n"), 0o600))
+
+	cfg := &Config{
+		RootDir:             dir,
+		Secret:              "current",
+		PreviousSecrets:     []string{"current", "", "inline"},
+		PreviousSecretFiles: []string{"previous_secret"},
+	}
+	require.NoError(t, parsePreviousSecrets(cfg))
+	require.Equal(t, []string{"current", "inline", "from file\n"}, cfg.AcceptedSecrets())
+
+	cfg.PreviousSecretFiles = []string{"missing"}
+	require.Error(t, parsePreviousSecrets(cfg))
+}
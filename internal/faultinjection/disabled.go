@@ -0,0 +1,26 @@
+//go:build !faultinjection
+
+package faultinjection
+
+import (
+	"context"
+	"net/http"
+
+	"google.golang.org/grpc"
+)
+
+// Enabled tells whether faults are compiled in
+const Enabled = false
+
+// RoundTripper returns next, faults not being compiled in
+func RoundTripper(next http.RoundTripper) http.RoundTripper {
+	return next
+}
+
+// StreamClientInterceptor returns an interceptor passing streams through,
+// faults not being compiled in
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
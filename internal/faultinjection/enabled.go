@@ -0,0 +1,214 @@
+//go:build faultinjection
+
+package faultinjection
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Enabled tells whether faults are compiled in
+const Enabled = true
+
+var cache specCache
+
+// faultsFor returns the faults configured for target. They're read from the
+// environment on every call, for tests to change them.
+func faultsFor(target string) Faults {
+	spec, err := cache.get(os.Getenv(EnvVar))
+	if err != nil {
+		log.WithError(err).Error("faultinjection: invalid faults, injecting none")
+		return Faults{}
+	}
+
+	return spec[target]
+}
+
+// RoundTripper injects the faults of the api target into the requests of
+// next
+func RoundTripper(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		faults := faultsFor(TargetAPI)
+		ctx := req.Context()
+
+		if err := delay(ctx, TargetAPI, faults.Delay); err != nil {
+			return nil, err
+		}
+
+		if faults.Drop.inject() {
+			logFault(ctx, TargetAPI, "drop")
+			return nil, ErrDropped
+		}
+
+		resp, err := next.RoundTrip(req)
+		if err != nil || !faults.Corrupt.inject() {
+			return resp, err
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		logFault(ctx, TargetAPI, "corrupt")
+
+		// Truncated JSON always fails to parse, unlike JSON with a damaged byte
+		if len(body) > 0 {
+			body = body[:rand.Intn(len(body))] //nolint:gosec // not used for security
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Del("Content-Length")
+
+		return resp, nil
+	})
+}
+
+// StreamClientInterceptor injects the faults of the gitaly target into the
+// messages received from streams
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		return &faultyStream{ClientStream: stream, faults: faultsFor(TargetGitaly)}, nil
+	}
+}
+
+type faultyStream struct {
+	grpc.ClientStream
+	faults Faults
+}
+
+func (s *faultyStream) RecvMsg(m any) error {
+	ctx := s.Context()
+
+	if err := delay(ctx, TargetGitaly, s.faults.Delay); err != nil {
+		return grpcstatus.FromContextError(err).Err()
+	}
+
+	if s.faults.Drop.inject() {
+		logFault(ctx, TargetGitaly, "drop")
+		return grpcstatus.Error(grpccodes.Unavailable, ErrDropped.Error())
+	}
+
+	if err := s.ClientStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	if msg, ok := m.(proto.Message); ok && s.faults.Corrupt.inject() {
+		logFault(ctx, TargetGitaly, "corrupt")
+		corruptMessage(msg.ProtoReflect())
+	}
+
+	return nil
+}
+
+// corruptMessage damages the bytes fields of msg and its nested messages,
+// such as the data of packs or the names of refs
+func corruptMessage(msg protoreflect.Message) {
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsMap():
+		case fd.IsList():
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				corruptValue(fd, list.Get(i))
+			}
+		default:
+			corruptValue(fd, v)
+		}
+
+		return true
+	})
+}
+
+func corruptValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) {
+	switch fd.Kind() {
+	case protoreflect.BytesKind:
+		corrupt(v.Bytes())
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		corruptMessage(v.Message())
+	}
+}
+
+// delay waits for the delay of fault if injected, returning early with the
+// error of ctx once it's done
+func delay(ctx context.Context, target string, fault Fault) error {
+	if !fault.inject() {
+		return nil
+	}
+
+	logFault(ctx, target, "delay")
+
+	timer := time.NewTimer(fault.Delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func logFault(ctx context.Context, target, fault string) {
+	log.WithContextFields(ctx, log.Fields{"target": target, "fault": fault}).Warn("faultinjection: injected a fault")
+}
+
+// inject tells whether to inject the fault, by its probability
+func (f Fault) inject() bool {
+	return f.Probability > 0 && rand.Float64() < f.Probability //nolint:gosec // not used for security
+}
+
+// corrupt damages data in place, flipping the bits of one of its bytes
+func corrupt(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	data[rand.Intn(len(data))] ^= 0xff //nolint:gosec // not used for security
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// specCache caches the faults parsed from the environment, parsing them again
+// when the variable changes
+type specCache struct {
+	mu    sync.Mutex
+	value string
+	spec  Spec
+	err   error
+}
+
+func (c *specCache) get(value string) (Spec, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if (c.spec == nil && c.err == nil) || c.value != value {
+		c.value = value
+		c.spec, c.err = Parse(value)
+	}
+
+	return c.spec, c.err
+}
@@ -0,0 +1,172 @@
+//go:build faultinjection
+
+package faultinjection_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	pb "gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/faultinjection"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitaly"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/discover"
+)
+
+func TestAPIFaults(t *testing.T) {
+	url := testserver.StartSocketHttpServer(t, []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/discover",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(map[string]interface{}{"id": 2, "username": "alex-doe", "name": "Alex Doe"})
+			},
+		},
+	})
+
+	testCases := []struct {
+		desc   string
+		faults string
+		check  func(t *testing.T, response *discover.Response, err error)
+	}{
+		{
+			desc: "without faults",
+			check: func(t *testing.T, response *discover.Response, err error) {
+				require.NoError(t, err)
+				require.Equal(t, "alex-doe", response.Username)
+			},
+		},
+		{
+			desc:   "dropped",
+			faults: "api.drop=1",
+			check: func(t *testing.T, _ *discover.Response, err error) {
+				require.EqualError(t, err, "Internal API unreachable")
+			},
+		},
+		{
+			desc:   "corrupted",
+			faults: "api.corrupt=1",
+			check: func(t *testing.T, _ *discover.Response, err error) {
+				require.ErrorIs(t, err, gitlabnet.ParsingError)
+			},
+		},
+		{
+			desc:   "delayed past the timeout",
+			faults: "api.delay=1:1m",
+			check: func(t *testing.T, _ *discover.Response, err error) {
+				require.EqualError(t, err, "Internal API unreachable")
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Setenv(faultinjection.EnvVar, tc.faults)
+
+			noRetries := 0
+			cfg := &config.Config{
+				GitlabUrl: url,
+				HttpSettings: config.HttpSettingsConfig{
+					Endpoints: map[string]config.APIEndpointConfig{"discover": {Retries: &noRetries}},
+				},
+			}
+
+			client, err := discover.NewClient(cfg)
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			response, err := client.GetByCommandArgs(ctx, &commandargs.Shell{GitlabKeyId: "1"})
+			tc.check(t, response, err)
+		})
+	}
+}
+
+func TestGitalyFaults(t *testing.T) {
+	address, _ := testserver.StartGitalyServer(t, "tcp")
+
+	c := &gitaly.Client{}
+	c.InitSidechannelRegistry(context.Background())
+	t.Cleanup(c.Close)
+
+	conn, err := c.GetConnection(context.Background(), gitaly.Command{ServiceName: "git-upload-archive", Address: address})
+	require.NoError(t, err)
+
+	request := &pb.GetArchiveRequest{Repository: &pb.Repository{}, CommitId: "main", Prefix: "project", Format: pb.GetArchiveRequest_TAR}
+	expected := []byte("GetArchive:  main project TAR")
+
+	testCases := []struct {
+		desc   string
+		faults string
+		check  func(t *testing.T, data []byte, err error)
+	}{
+		{
+			desc: "without faults",
+			check: func(t *testing.T, data []byte, err error) {
+				require.NoError(t, err)
+				require.Equal(t, expected, data)
+			},
+		},
+		{
+			desc:   "dropped",
+			faults: "gitaly.drop=1",
+			check: func(t *testing.T, _ []byte, err error) {
+				require.Equal(t, grpccodes.Unavailable, grpcstatus.Code(err))
+			},
+		},
+		{
+			desc:   "corrupted",
+			faults: "gitaly.corrupt=1",
+			check: func(t *testing.T, data []byte, err error) {
+				require.NoError(t, err)
+				require.Len(t, data, len(expected))
+				require.NotEqual(t, expected, data)
+			},
+		},
+		{
+			desc:   "delayed past the timeout",
+			faults: "gitaly.delay=1:1m",
+			check: func(t *testing.T, _ []byte, err error) {
+				require.Equal(t, grpccodes.DeadlineExceeded, grpcstatus.Code(err))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Setenv(faultinjection.EnvVar, tc.faults)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			stream, err := pb.NewRepositoryServiceClient(conn).GetArchive(ctx, proto.Clone(request).(*pb.GetArchiveRequest))
+			require.NoError(t, err)
+
+			var data []byte
+			for {
+				var response *pb.GetArchiveResponse
+				if response, err = stream.Recv(); err != nil {
+					break
+				}
+				data = append(data, response.GetData()...)
+			}
+			if err == io.EOF {
+				err = nil
+			}
+
+			tc.check(t, data, err)
+		})
+	}
+}
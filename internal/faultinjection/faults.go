@@ -0,0 +1,122 @@
+// Package faultinjection injects faults into the responses of the internal
+// API and the Gitaly streams, to test how gitlab-shell handles failing
+// backends without standing them up. It's compiled in with the
+// faultinjection build tag only, and configured by the GITLAB_SHELL_FAULTS
+// environment variable, e.g.:
+//
+//	GITLAB_SHELL_FAULTS=api.delay=0.1:2s,api.drop=0.05,gitaly.corrupt=0.01
+//
+// Each fault is given as <target>.<fault>=<probability>, the targets being
+// api and gitaly, and the faults:
+//   - delay=<probability>:<duration> delays responses, or messages of streams
+//   - drop=<probability> fails requests, or streams, as if connections were lost
+//   - corrupt=<probability> truncates the bodies of responses, or damages
+//     the data of the messages of streams
+//
+// Without the build tag, the hooks of this package are no-ops.
+package faultinjection
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvVar is the environment variable the faults are configured by
+const EnvVar = "GITLAB_SHELL_FAULTS"
+
+// Targets of the faults
+const (
+	TargetAPI    = "api"
+	TargetGitaly = "gitaly"
+)
+
+// ErrDropped is returned by the requests and streams dropped
+var ErrDropped = errors.New("faultinjection: dropped")
+
+// Fault is injected at Probability, from 0 to 1
+type Fault struct {
+	Probability float64
+	// Delay is the duration of delay faults
+	Delay time.Duration
+}
+
+// Faults are the faults injected into one target
+type Faults struct {
+	Delay   Fault
+	Drop    Fault
+	Corrupt Fault
+}
+
+// Spec are the faults injected by target
+type Spec map[string]Faults
+
+// Parse parses the faults configured as documented by the package
+func Parse(s string) (Spec, error) {
+	spec := Spec{}
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("faultinjection: invalid fault %q", entry)
+		}
+
+		target, name, ok := strings.Cut(key, ".")
+		if !ok || (target != TargetAPI && target != TargetGitaly) {
+			return nil, fmt.Errorf("faultinjection: invalid target in %q", entry)
+		}
+
+		fault, err := parseFault(name, value)
+		if err != nil {
+			return nil, fmt.Errorf("faultinjection: %q: %w", entry, err)
+		}
+
+		faults := spec[target]
+		switch name {
+		case "delay":
+			faults.Delay = fault
+		case "drop":
+			faults.Drop = fault
+		case "corrupt":
+			faults.Corrupt = fault
+		default:
+			return nil, fmt.Errorf("faultinjection: unknown fault in %q", entry)
+		}
+		spec[target] = faults
+	}
+
+	return spec, nil
+}
+
+func parseFault(name, value string) (Fault, error) {
+	probability, delay, hasDelay := strings.Cut(value, ":")
+
+	var fault Fault
+	var err error
+
+	fault.Probability, err = strconv.ParseFloat(probability, 64)
+	if err != nil || fault.Probability < 0 || fault.Probability > 1 {
+		return fault, fmt.Errorf("invalid probability %q", probability)
+	}
+
+	if name != "delay" {
+		if hasDelay {
+			return fault, errors.New("only delays take a duration")
+		}
+
+		return fault, nil
+	}
+
+	if fault.Delay, err = time.ParseDuration(delay); err != nil || fault.Delay <= 0 {
+		return fault, fmt.Errorf("invalid delay %q", delay)
+	}
+
+	return fault, nil
+}
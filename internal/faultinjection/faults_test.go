@@ -0,0 +1,45 @@
+package faultinjection
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	spec, err := Parse("api.delay=0.1:2s, api.drop=0.05,gitaly.corrupt=1,")
+	require.NoError(t, err)
+	require.Equal(t, Spec{
+		TargetAPI: {
+			Delay: Fault{Probability: 0.1, Delay: 2 * time.Second},
+			Drop:  Fault{Probability: 0.05},
+		},
+		TargetGitaly: {
+			Corrupt: Fault{Probability: 1},
+		},
+	}, spec)
+
+	spec, err = Parse("")
+	require.NoError(t, err)
+	require.Empty(t, spec)
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{
+		"api.drop",
+		"api=0.1",
+		"sshd.drop=0.1",
+		"api.hang=0.1",
+		"api.drop=1.5",
+		"api.drop=often",
+		"api.drop=0.1:1s",
+		"api.delay=0.1",
+		"api.delay=0.1:soon",
+	} {
+		t.Run(s, func(t *testing.T) {
+			_, err := Parse(s)
+			require.Error(t, err)
+		})
+	}
+}
@@ -2,11 +2,15 @@ package gitaly
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"sync"
 
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/metadata"
 
 	gitalyauth "gitlab.com/gitlab-org/gitaly/v16/auth"
 	"gitlab.com/gitlab-org/gitaly/v16/client"
@@ -16,6 +20,7 @@ import (
 	"gitlab.com/gitlab-org/labkit/log"
 	grpctracing "gitlab.com/gitlab-org/labkit/tracing/grpc"
 
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/faultinjection"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
 )
 
@@ -23,16 +28,37 @@ type Command struct {
 	ServiceName string
 	Address     string
 	Token       string
+	// Storage is the storage of the repository, whose Gitaly may have its
+	// own TLS configuration, see ConnectionOptions.StorageTLS
+	Storage string
+}
+
+// connectionKey identifies the connections that can be shared by commands.
+// The service name isn't part of it: it's sent with each RPC instead.
+type connectionKey struct {
+	Address string
+	Token   string
+	TLS     *tls.Config
 }
 
 type connectionsCache struct {
 	sync.RWMutex
 
-	connections map[Command]*grpc.ClientConn
+	connections map[connectionKey]*grpc.ClientConn
+}
+
+type serviceNameKey struct{}
+
+// WithServiceName returns a context whose RPCs are reported to Gitaly as made
+// by the given service, e.g. git-upload-pack
+func WithServiceName(ctx context.Context, serviceName string) context.Context {
+	return context.WithValue(ctx, serviceNameKey{}, serviceName)
 }
 
 type Client struct {
 	SidechannelRegistry *gitalyclient.SidechannelRegistry
+	// Options apply to the connections made after they're set
+	Options ConnectionOptions
 
 	cache connectionsCache
 }
@@ -41,36 +67,77 @@ func (c *Client) InitSidechannelRegistry(ctx context.Context) {
 	c.SidechannelRegistry = gitalyclient.NewSidechannelRegistry(log.ContextLogger(ctx))
 }
 
+// GetConnection returns a connection to the Gitaly of cmd, shared by all the
+// commands using the same address and token. A cached connection that is
+// shut down or failing to connect is closed and replaced by a new one.
 func (c *Client) GetConnection(ctx context.Context, cmd Command) (*grpc.ClientConn, error) {
+	key := connectionKey{Address: cmd.Address, Token: cmd.Token, TLS: c.Options.tlsConfig(cmd)}
+
 	c.cache.RLock()
-	conn := c.cache.connections[cmd]
+	conn := c.cache.connections[key]
 	c.cache.RUnlock()
 
-	if conn != nil {
+	if healthy(conn) {
 		return conn, nil
 	}
 
 	c.cache.Lock()
 	defer c.cache.Unlock()
 
-	if conn := c.cache.connections[cmd]; conn != nil {
+	conn = c.cache.connections[key]
+	if healthy(conn) {
 		return conn, nil
 	}
 
+	if conn != nil {
+		state := conn.GetState().String()
+		log.WithContextFields(ctx, log.Fields{"gitaly_address": cmd.Address, "state": state}).Info("Replacing unhealthy Gitaly connection")
+
+		delete(c.cache.connections, key)
+		_ = conn.Close()
+		metrics.GitalyConnectionEvictionsTotal.WithLabelValues(state).Inc()
+	}
+
 	conn, err := c.newConnection(ctx, cmd)
 	if err != nil {
 		return nil, err
 	}
 
 	if c.cache.connections == nil {
-		c.cache.connections = make(map[Command]*grpc.ClientConn)
+		c.cache.connections = make(map[connectionKey]*grpc.ClientConn)
 	}
 
-	c.cache.connections[cmd] = conn
+	c.cache.connections[key] = conn
 
 	return conn, nil
 }
 
+// Close closes the cached connections
+func (c *Client) Close() {
+	c.cache.Lock()
+	defer c.cache.Unlock()
+
+	for key, conn := range c.cache.connections {
+		_ = conn.Close()
+		delete(c.cache.connections, key)
+	}
+}
+
+// healthy reports whether conn can be handed out. An idle connection is: it
+// connects again on its next RPC.
+func healthy(conn *grpc.ClientConn) bool {
+	if conn == nil {
+		return false
+	}
+
+	switch conn.GetState() {
+	case connectivity.TransientFailure, connectivity.Shutdown:
+		return false
+	default:
+		return true
+	}
+}
+
 func (c *Client) newConnection(ctx context.Context, cmd Command) (conn *grpc.ClientConn, err error) {
 	defer func() {
 		label := "ok"
@@ -91,7 +158,7 @@ func (c *Client) newConnection(ctx context.Context, cmd Command) (conn *grpc.Cli
 		log.WithContextFields(ctx, log.Fields{"service_name": serviceName}).Warn("No gRPC service name specified, defaulting to gitlab-shell-unknown")
 	}
 
-	serviceName = fmt.Sprintf("%s-%s", serviceName, cmd.ServiceName)
+	options := c.Options
 
 	connOpts := client.DefaultDialOpts
 	connOpts = append(
@@ -99,17 +166,18 @@ func (c *Client) newConnection(ctx context.Context, cmd Command) (conn *grpc.Cli
 		grpc.WithChainStreamInterceptor(
 			grpctracing.StreamClientTracingInterceptor(),
 			grpc_prometheus.StreamClientInterceptor,
-			grpccorrelation.StreamClientCorrelationInterceptor(
-				grpccorrelation.WithClientName(serviceName),
-			),
+			grpccorrelation.StreamClientCorrelationInterceptor(),
+			streamClientNameInterceptor(serviceName),
+			options.streamInterceptor(),
+			faultinjection.StreamClientInterceptor(),
 		),
 
 		grpc.WithChainUnaryInterceptor(
 			grpctracing.UnaryClientTracingInterceptor(),
 			grpc_prometheus.UnaryClientInterceptor,
-			grpccorrelation.UnaryClientCorrelationInterceptor(
-				grpccorrelation.WithClientName(serviceName),
-			),
+			grpccorrelation.UnaryClientCorrelationInterceptor(),
+			unaryClientNameInterceptor(serviceName),
+			options.unaryInterceptor(),
 		),
 
 		// In https://gitlab.com/groups/gitlab-org/-/epics/8971, we added DNS discovery support to Praefect. This was
@@ -121,7 +189,11 @@ func (c *Client) newConnection(ctx context.Context, cmd Command) (conn *grpc.Cli
 		// Afterward, workhorse can detect and handle DNS discovery automatically. The user needs to setup and set
 		// Gitaly address to something like "dns:gitaly.service.dc1.consul"
 		gitalyclient.WithGitalyDNSResolver(gitalyclient.DefaultDNSResolverBuilderConfig()),
+
+		// Propagates the W3C trace context as gRPC metadata when OpenTelemetry tracing is enabled
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
 	)
+	connOpts = append(connOpts, options.FlowControl.dialOptions()...)
 
 	if cmd.Token != "" {
 		connOpts = append(connOpts,
@@ -129,5 +201,42 @@ func (c *Client) newConnection(ctx context.Context, cmd Command) (conn *grpc.Cli
 		)
 	}
 
-	return client.DialSidechannel(ctx, cmd.Address, c.SidechannelRegistry, connOpts)
+	address := cmd.Address
+	if tlsConfig := options.tlsConfig(cmd); tlsConfig != nil {
+		var dialOpt grpc.DialOption
+		address, dialOpt = tlsDialOptions(address, tlsConfig)
+		connOpts = append(connOpts, dialOpt)
+	}
+
+	// The connection is established with the backchannel handshake of Gitaly:
+	// it's multiplexed with yamux, for Gitaly to open streams back to us over
+	// it. The only service served back is the sidechannel of
+	// SidechannelRegistry, the Gitaly client not allowing for others.
+	return client.DialSidechannel(ctx, address, c.SidechannelRegistry, connOpts)
+}
+
+// clientNameKey is the metadata read by Gitaly for the name of the client
+const clientNameKey = "X-GitLab-Client-Name"
+
+// appendClientName sends the name of the client making an RPC, e.g.
+// gitlab-sshd-git-upload-pack. The service name comes from the context of the
+// RPC because connections are shared by services.
+func appendClientName(ctx context.Context, clientName string) context.Context {
+	if serviceName, ok := ctx.Value(serviceNameKey{}).(string); ok {
+		clientName = fmt.Sprintf("%s-%s", clientName, serviceName)
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, clientNameKey, clientName)
+}
+
+func unaryClientNameInterceptor(clientName string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(appendClientName(ctx, clientName), method, req, reply, cc, opts...)
+	}
+}
+
+func streamClientNameInterceptor(clientName string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(appendClientName(ctx, clientName), desc, cc, method, opts...)
+	}
 }
@@ -6,6 +6,9 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/metadata"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
 )
@@ -46,10 +49,68 @@ func TestCachedConnections(t *testing.T) {
 	require.Len(t, c.cache.connections, 1)
 	require.Equal(t, conn, newConn)
 
+	// Services share connections
+	cmd = Command{ServiceName: "git-receive-pack", Address: "tcp://localhost:9999"}
+	newConn, err = c.GetConnection(context.Background(), cmd)
+	require.NoError(t, err)
+	require.Len(t, c.cache.connections, 1)
+	require.Equal(t, conn, newConn)
+
 	cmd = Command{ServiceName: "git-upload-pack", Address: "tcp://localhost:9998"}
 	_, err = c.GetConnection(context.Background(), cmd)
 	require.NoError(t, err)
 	require.Len(t, c.cache.connections, 2)
+
+	cmd = Command{ServiceName: "git-upload-pack", Address: "tcp://localhost:9998", Token: "token"}
+	_, err = c.GetConnection(context.Background(), cmd)
+	require.NoError(t, err)
+	require.Len(t, c.cache.connections, 3)
+
+	c.Close()
+	require.Empty(t, c.cache.connections)
+	require.Equal(t, connectivity.Shutdown, conn.GetState())
+}
+
+func TestUnhealthyConnectionsAreReplaced(t *testing.T) {
+	metrics.GitalyConnectionEvictionsTotal.Reset()
+
+	c := newClient()
+	cmd := Command{ServiceName: "git-upload-pack", Address: "tcp://localhost:9999"}
+
+	conn, err := c.GetConnection(context.Background(), cmd)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	newConn, err := c.GetConnection(context.Background(), cmd)
+	require.NoError(t, err)
+	require.NotSame(t, conn, newConn)
+	require.Equal(t, 1, c.cache.len())
+	require.InDelta(t, 1, testutil.ToFloat64(metrics.GitalyConnectionEvictionsTotal.WithLabelValues("SHUTDOWN")), 0.1)
+}
+
+func TestClientName(t *testing.T) {
+	var clientNames []string
+	invoker := func(ctx context.Context, _ string, _, _ any, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		clientNames = append(clientNames, md.Get(clientNameKey)...)
+		return nil
+	}
+
+	interceptor := unaryClientNameInterceptor("gitlab-sshd")
+	require.NoError(t, interceptor(WithServiceName(context.Background(), "git-upload-pack"), "", nil, nil, nil, invoker))
+	require.NoError(t, interceptor(WithServiceName(context.Background(), "git-receive-pack"), "", nil, nil, nil, invoker))
+	require.NoError(t, interceptor(context.Background(), "", nil, nil, nil, invoker))
+
+	require.Equal(t, []string{"gitlab-sshd-git-upload-pack", "gitlab-sshd-git-receive-pack", "gitlab-sshd"}, clientNames)
+}
+
+// len returns the number of cached connections, under the lock as the
+// connections being replaced may still be in use
+func (c *connectionsCache) len() int {
+	c.RLock()
+	defer c.RUnlock()
+
+	return len(c.connections)
 }
 
 func newClient() *Client {
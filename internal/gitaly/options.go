@@ -0,0 +1,208 @@
+package gitaly
+
+import (
+	"context"
+	"crypto/tls"
+	"math/rand"
+	"slices"
+	"time"
+
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// Defaults of a RetryPolicy, matching the policy the Gitaly client applies to
+// upload-pack RPCs
+const (
+	DefaultInitialBackoff    = 400 * time.Millisecond
+	DefaultMaxBackoff        = 1400 * time.Millisecond
+	DefaultBackoffMultiplier = 2
+)
+
+// jitter is the fraction of a backoff randomly added or removed from it
+const jitter = 0.2
+
+// ConnectionOptions tune the RPCs made on the connections to Gitaly. The
+// zero value keeps the defaults of the Gitaly client.
+type ConnectionOptions struct {
+	// WaitForReady makes RPCs wait until their deadline for a connection
+	// that is transiently unavailable, e.g. while Gitaly restarts, rather
+	// than failing straight away
+	WaitForReady bool
+	// Retry retries streaming RPCs that fail before they are established
+	Retry RetryPolicy
+	// Timeout is the deadline of RPCs without one in Timeouts. Zero leaves
+	// RPCs without a deadline.
+	Timeout time.Duration
+	// Timeouts are the deadlines of RPCs by full method name, such as
+	// /gitaly.SSHService/SSHReceivePack
+	Timeouts map[string]time.Duration
+	// TLS verifies the connections to tls:// addresses, in place of the
+	// system certificate pool, see NewTLSConfig. StorageTLS replaces it for
+	// the Gitaly of a storage.
+	TLS        *tls.Config
+	StorageTLS map[string]*tls.Config
+	// FlowControl bounds the data of the gRPC streams buffered for Gitaly
+	FlowControl FlowControl
+}
+
+// FlowControl tunes the flow control of the gRPC connections to Gitaly. Gitaly
+// stops sending on a stream once its window is full, until gitlab-shell has
+// written what it received to the client: the windows bound what's buffered
+// for slow clients. The zero value keeps the defaults of gRPC, whose windows
+// grow with the bandwidth-delay product of the connection.
+//
+// The packfiles of git-upload-pack are sent over the sidechannel, whose
+// window is fixed by yamux, rather than over gRPC streams.
+type FlowControl struct {
+	// InitialWindowSize is the window of each stream, and
+	// InitialConnWindowSize the one of the whole connection, in bytes.
+	// Setting either disables the dynamic windows of gRPC.
+	InitialWindowSize     int32
+	InitialConnWindowSize int32
+	// ReadBufferSize and WriteBufferSize are the buffers of the socket
+	// reads and writes, in bytes
+	ReadBufferSize  int
+	WriteBufferSize int
+}
+
+// RetryPolicy retries the establishment of streaming RPCs with an
+// exponential backoff. Nothing has been sent to Gitaly by then, so that any
+// RPC can be retried safely. Established streams and unary RPCs are retried
+// by the service config of the Gitaly client only.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts, including the first one. A
+	// value below 2 disables retries.
+	MaxAttempts       int
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	BackoffMultiplier float64
+	RetryableCodes    []grpccodes.Code
+}
+
+// dialOptions returns the dial options applying f
+func (f FlowControl) dialOptions() []grpc.DialOption {
+	var opts []grpc.DialOption
+	if f.InitialWindowSize > 0 {
+		opts = append(opts, grpc.WithInitialWindowSize(f.InitialWindowSize))
+	}
+	if f.InitialConnWindowSize > 0 {
+		opts = append(opts, grpc.WithInitialConnWindowSize(f.InitialConnWindowSize))
+	}
+	if f.ReadBufferSize > 0 {
+		opts = append(opts, grpc.WithReadBufferSize(f.ReadBufferSize))
+	}
+	if f.WriteBufferSize > 0 {
+		opts = append(opts, grpc.WithWriteBufferSize(f.WriteBufferSize))
+	}
+
+	return opts
+}
+
+func (o *ConnectionOptions) timeout(method string) time.Duration {
+	if timeout, ok := o.Timeouts[method]; ok {
+		return timeout
+	}
+
+	return o.Timeout
+}
+
+func (o *ConnectionOptions) callOptions(opts []grpc.CallOption) []grpc.CallOption {
+	if o.WaitForReady {
+		// Options given by the caller take precedence
+		opts = append([]grpc.CallOption{grpc.WaitForReady(true)}, opts...)
+	}
+
+	return opts
+}
+
+func (o *ConnectionOptions) unaryInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if timeout := o.timeout(method); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		return invoker(ctx, method, req, reply, cc, o.callOptions(opts)...)
+	}
+}
+
+func (o *ConnectionOptions) streamInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		cancel := context.CancelFunc(func() {})
+		if timeout := o.timeout(method); timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		}
+
+		opts = o.callOptions(opts)
+
+		for attempt := 1; ; attempt++ {
+			stream, err := streamer(ctx, desc, cc, method, opts...)
+			if err == nil {
+				return &cancelingStream{ClientStream: stream, cancel: cancel}, nil
+			}
+
+			if !o.Retry.retryable(err, attempt) || !sleep(ctx, o.Retry.backoff(attempt)) {
+				cancel()
+				return nil, err
+			}
+		}
+	}
+}
+
+func (p *RetryPolicy) retryable(err error, attempt int) bool {
+	return attempt < p.MaxAttempts && slices.Contains(p.RetryableCodes, grpcstatus.Code(err))
+}
+
+// backoff returns the time to wait after the given failed attempt
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	initial, maxBackoff, multiplier := p.InitialBackoff, p.MaxBackoff, p.BackoffMultiplier
+	if initial <= 0 {
+		initial = DefaultInitialBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultMaxBackoff
+	}
+	if multiplier < 1 {
+		multiplier = DefaultBackoffMultiplier
+	}
+
+	backoff := float64(initial)
+	for i := 1; i < attempt; i++ {
+		backoff *= multiplier
+	}
+	backoff = min(backoff, float64(maxBackoff))
+
+	//nolint:gosec // The jitter needn't be cryptographically secure
+	return time.Duration(backoff * (1 + jitter*(2*rand.Float64()-1)))
+}
+
+// sleep waits for d, and returns false if ctx is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// cancelingStream releases the deadline of a stream once it's over
+type cancelingStream struct {
+	grpc.ClientStream
+	cancel context.CancelFunc
+}
+
+func (s *cancelingStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.cancel()
+	}
+
+	return err
+}
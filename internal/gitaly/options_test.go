@@ -0,0 +1,190 @@
+package gitaly
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+const testMethod = "/gitaly.RefService/ListRefs"
+
+type fakeStream struct {
+	grpc.ClientStream
+	recvErr error
+}
+
+func (s *fakeStream) RecvMsg(any) error { return s.recvErr }
+
+func TestStreamRetries(t *testing.T) {
+	unavailable := grpcstatus.Error(grpccodes.Unavailable, "connection refused")
+	notFound := grpcstatus.Error(grpccodes.NotFound, "repository not found")
+
+	testCases := []struct {
+		desc             string
+		retry            RetryPolicy
+		errors           []error
+		expectedAttempts int
+		expectedError    error
+	}{
+		{
+			desc:             "without a retry policy",
+			errors:           []error{unavailable, nil},
+			expectedAttempts: 1,
+			expectedError:    unavailable,
+		},
+		{
+			desc:             "a retryable error",
+			retry:            RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, RetryableCodes: []grpccodes.Code{grpccodes.Unavailable}},
+			errors:           []error{unavailable, unavailable, nil},
+			expectedAttempts: 3,
+		},
+		{
+			desc:             "too many retryable errors",
+			retry:            RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, RetryableCodes: []grpccodes.Code{grpccodes.Unavailable}},
+			errors:           []error{unavailable, unavailable, nil},
+			expectedAttempts: 2,
+			expectedError:    unavailable,
+		},
+		{
+			desc:             "an error that isn't retryable",
+			retry:            RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, RetryableCodes: []grpccodes.Code{grpccodes.Unavailable}},
+			errors:           []error{notFound, nil},
+			expectedAttempts: 1,
+			expectedError:    notFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			options := &ConnectionOptions{Retry: tc.retry}
+
+			attempts := 0
+			streamer := func(context.Context, *grpc.StreamDesc, *grpc.ClientConn, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+				err := tc.errors[attempts]
+				attempts++
+				if err != nil {
+					return nil, err
+				}
+
+				return &fakeStream{}, nil
+			}
+
+			_, err := options.streamInterceptor()(context.Background(), &grpc.StreamDesc{}, nil, testMethod, streamer)
+
+			require.Equal(t, tc.expectedAttempts, attempts)
+			require.Equal(t, tc.expectedError, err)
+		})
+	}
+}
+
+func TestStreamRetriesStopWithContext(t *testing.T) {
+	options := &ConnectionOptions{
+		Retry: RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour, RetryableCodes: []grpccodes.Code{grpccodes.Unavailable}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	streamer := func(context.Context, *grpc.StreamDesc, *grpc.ClientConn, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+		cancel()
+		return nil, grpcstatus.Error(grpccodes.Unavailable, "connection refused")
+	}
+
+	_, err := options.streamInterceptor()(ctx, &grpc.StreamDesc{}, nil, testMethod, streamer)
+	require.Equal(t, grpccodes.Unavailable, grpcstatus.Code(err))
+}
+
+func TestTimeouts(t *testing.T) {
+	options := &ConnectionOptions{
+		Timeout:  time.Minute,
+		Timeouts: map[string]time.Duration{testMethod: time.Hour, "/gitaly.SSHService/SSHUploadPackWithSidechannel": 0},
+	}
+
+	testCases := []struct {
+		method          string
+		expectedTimeout time.Duration
+	}{
+		{method: "/gitaly.SSHService/SSHReceivePack", expectedTimeout: time.Minute},
+		{method: testMethod, expectedTimeout: time.Hour},
+		{method: "/gitaly.SSHService/SSHUploadPackWithSidechannel"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.method, func(t *testing.T) {
+			checkDeadline := func(ctx context.Context) {
+				deadline, ok := ctx.Deadline()
+				if tc.expectedTimeout == 0 {
+					require.False(t, ok)
+					return
+				}
+
+				require.True(t, ok)
+				require.WithinDuration(t, time.Now().Add(tc.expectedTimeout), deadline, time.Second)
+			}
+
+			invoker := func(ctx context.Context, _ string, _, _ any, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+				checkDeadline(ctx)
+				return nil
+			}
+			require.NoError(t, options.unaryInterceptor()(context.Background(), tc.method, nil, nil, nil, invoker))
+
+			var streamCtx context.Context
+			streamer := func(ctx context.Context, _ *grpc.StreamDesc, _ *grpc.ClientConn, _ string, _ ...grpc.CallOption) (grpc.ClientStream, error) {
+				checkDeadline(ctx)
+				streamCtx = ctx
+				return &fakeStream{recvErr: context.Canceled}, nil
+			}
+			stream, err := options.streamInterceptor()(context.Background(), &grpc.StreamDesc{}, nil, tc.method, streamer)
+			require.NoError(t, err)
+
+			// The deadline is released once the stream is over
+			require.Error(t, stream.RecvMsg(nil))
+			if tc.expectedTimeout > 0 {
+				require.Error(t, streamCtx.Err())
+			}
+		})
+	}
+}
+
+func TestWaitForReady(t *testing.T) {
+	for _, waitForReady := range []bool{true, false} {
+		options := &ConnectionOptions{WaitForReady: waitForReady}
+
+		invoker := func(_ context.Context, _ string, _, _ any, _ *grpc.ClientConn, opts ...grpc.CallOption) error {
+			if waitForReady {
+				require.Equal(t, []grpc.CallOption{grpc.WaitForReady(true)}, opts)
+			} else {
+				require.Empty(t, opts)
+			}
+
+			return nil
+		}
+
+		require.NoError(t, options.unaryInterceptor()(context.Background(), testMethod, nil, nil, nil, invoker))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := &RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, BackoffMultiplier: 3}
+
+	require.InDelta(t, 100*time.Millisecond, policy.backoff(1), float64(20*time.Millisecond))
+	require.InDelta(t, 300*time.Millisecond, policy.backoff(2), float64(60*time.Millisecond))
+	require.InDelta(t, time.Second, policy.backoff(5), float64(200*time.Millisecond))
+
+	defaults := &RetryPolicy{}
+	require.InDelta(t, DefaultInitialBackoff, defaults.backoff(1), float64(DefaultInitialBackoff)*jitter)
+}
+
+func TestFlowControlDialOptions(t *testing.T) {
+	require.Empty(t, FlowControl{}.dialOptions())
+	require.Len(t, FlowControl{InitialWindowSize: 1 << 20, WriteBufferSize: 64 << 10}.dialOptions(), 2)
+	require.Len(t, FlowControl{
+		InitialWindowSize:     1 << 20,
+		InitialConnWindowSize: 4 << 20,
+		ReadBufferSize:        64 << 10,
+		WriteBufferSize:       64 << 10,
+	}.dialOptions(), 4)
+}
@@ -0,0 +1,134 @@
+package gitaly
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// spiffeFetchTimeout bounds the wait for the first SVID when verifying the
+// certificate of Gitaly, which isn't given the context of the dial
+const spiffeFetchTimeout = 10 * time.Second
+
+// x509Source provides the SVID of gitlab-shell and the trust bundles, as
+// workloadapi.X509Source does
+type x509Source interface {
+	x509svid.Source
+	x509bundle.Source
+}
+
+// SPIFFESource fetches the X.509 SVID of gitlab-shell, and the trust bundles
+// SVIDs are verified against, from the SPIFFE Workload API of a local agent
+// such as SPIRE. They're updated as the agent rotates them. The agent is only
+// contacted once a connection needs them, and again after a failure.
+type SPIFFESource struct {
+	newSource func(ctx context.Context) (x509Source, error)
+
+	mu     sync.Mutex
+	source x509Source
+}
+
+// NewSPIFFESource returns a source using the Workload API at addr, e.g.
+// unix:///run/spire/agent.sock, or at the SPIFFE_ENDPOINT_SOCKET environment
+// variable when addr is empty
+func NewSPIFFESource(addr string) *SPIFFESource {
+	var opts []workloadapi.X509SourceOption
+	if addr != "" {
+		opts = append(opts, workloadapi.WithClientOptions(workloadapi.WithAddr(addr)))
+	}
+
+	return &SPIFFESource{
+		newSource: func(ctx context.Context) (x509Source, error) {
+			return workloadapi.NewX509Source(ctx, opts...)
+		},
+	}
+}
+
+// get returns the source, waiting for the first update of the Workload API
+// until ctx is done
+func (s *SPIFFESource) get(ctx context.Context) (x509Source, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.source == nil {
+		source, err := s.newSource(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.source = source
+	}
+
+	return s.source, nil
+}
+
+// Close stops watching the Workload API for updates
+func (s *SPIFFESource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	closer, ok := s.source.(interface{ Close() error })
+	if !ok {
+		return nil
+	}
+	s.source = nil
+
+	return closer.Close()
+}
+
+// ApplySPIFFE makes config present the SVID of source as the client
+// certificate. When gitalyIDs are given, the certificate of Gitaly is
+// verified as an SVID with one of these IDs against the trust bundles of
+// source, in place of the CAs and server name of config.
+func ApplySPIFFE(config *tls.Config, source *SPIFFESource, gitalyIDs []string) error {
+	if len(config.Certificates) > 0 {
+		return errors.New("client_cert and spiffe can't be set together")
+	}
+
+	config.GetClientCertificate = func(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		svids, err := source.get(cri.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		return tlsconfig.GetClientCertificate(svids)(cri)
+	}
+
+	if len(gitalyIDs) == 0 {
+		return nil
+	}
+
+	ids := make([]spiffeid.ID, 0, len(gitalyIDs))
+	for _, gitalyID := range gitalyIDs {
+		id, err := spiffeid.FromString(gitalyID)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	authorizer := tlsconfig.AuthorizeOneOf(ids...)
+
+	// SVIDs name no host, so they're verified by VerifyPeerCertificate alone
+	config.InsecureSkipVerify = true //nolint:gosec // Verified against the trust bundles below
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		ctx, cancel := context.WithTimeout(context.Background(), spiffeFetchTimeout)
+		defer cancel()
+
+		bundles, err := source.get(ctx)
+		if err != nil {
+			return err
+		}
+
+		return tlsconfig.VerifyPeerCertificate(bundles, authorizer)(rawCerts, nil)
+	}
+
+	return nil
+}
@@ -0,0 +1,223 @@
+package gitaly
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"github.com/stretchr/testify/require"
+)
+
+var testTrustDomain = spiffeid.RequireTrustDomainFromString("example.org")
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "SPIRE"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key}
+}
+
+// svid issues an X.509 SVID for the path of the test trust domain
+func (ca *testCA) svid(t *testing.T, path string) *x509svid.SVID {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	id := spiffeid.RequireFromPath(testTrustDomain, path)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		URIs:         []*url.URL{id.URL()},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &x509svid.SVID{ID: id, Certificates: []*x509.Certificate{cert}, PrivateKey: key}
+}
+
+// staticX509Source stands in for the Workload API, its SVID replaced to
+// rotate it
+type staticX509Source struct {
+	mu     sync.Mutex
+	svid   *x509svid.SVID
+	bundle *x509bundle.Bundle
+}
+
+func (s *staticX509Source) GetX509SVID() (*x509svid.SVID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.svid, nil
+}
+
+func (s *staticX509Source) GetX509BundleForTrustDomain(trustDomain spiffeid.TrustDomain) (*x509bundle.Bundle, error) {
+	if trustDomain != s.bundle.TrustDomain() {
+		return nil, errors.New("unknown trust domain")
+	}
+
+	return s.bundle, nil
+}
+
+func (s *staticX509Source) rotate(svid *x509svid.SVID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.svid = svid
+}
+
+// startSPIFFEServer accepts TLS connections presenting the SVID of gitaly,
+// sending the ID of the SVID each client presented
+func startSPIFFEServer(t *testing.T, ca *testCA, gitaly *x509svid.SVID) (string, <-chan string) {
+	t.Helper()
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{gitaly.Certificates[0].Raw}, PrivateKey: gitaly.PrivateKey}},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	ids := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			tlsConn := conn.(*tls.Conn)
+			if tlsConn.Handshake() == nil {
+				ids <- tlsConn.ConnectionState().PeerCertificates[0].URIs[0].String()
+			}
+			conn.Close()
+		}
+	}()
+
+	return listener.Addr().String(), ids
+}
+
+func TestApplySPIFFE(t *testing.T) {
+	ca := newTestCA(t)
+	source := &staticX509Source{
+		svid:   ca.svid(t, "/gitlab-shell"),
+		bundle: x509bundle.FromX509Authorities(testTrustDomain, []*x509.Certificate{ca.cert}),
+	}
+	spiffeSource := &SPIFFESource{newSource: func(context.Context) (x509Source, error) { return source, nil }}
+
+	addr, ids := startSPIFFEServer(t, ca, ca.svid(t, "/gitaly"))
+
+	dial := func(gitalyIDs ...string) error {
+		config, err := NewTLSConfig("", "", "", "")
+		require.NoError(t, err)
+		require.NoError(t, ApplySPIFFE(config, spiffeSource, gitalyIDs))
+
+		conn, err := (&tls.Dialer{Config: config}).DialContext(context.Background(), "tcp", addr)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close()
+	}
+
+	receive := func() string {
+		select {
+		case id := <-ids:
+			return id
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "no TLS handshake")
+			return ""
+		}
+	}
+
+	t.Run("the SVID is presented", func(t *testing.T) {
+		require.NoError(t, dial("spiffe://example.org/gitaly"))
+		require.Equal(t, "spiffe://example.org/gitlab-shell", receive())
+	})
+
+	t.Run("the rotated SVID is presented", func(t *testing.T) {
+		source.rotate(ca.svid(t, "/gitlab-shell-rotated"))
+
+		require.NoError(t, dial("spiffe://example.org/gitaly"))
+		require.Equal(t, "spiffe://example.org/gitlab-shell-rotated", receive())
+	})
+
+	t.Run("Gitaly must present an authorized ID", func(t *testing.T) {
+		require.ErrorContains(t, dial("spiffe://example.org/other"), "unexpected ID")
+	})
+}
+
+func TestSPIFFESourceRetriesFailures(t *testing.T) {
+	attempts := 0
+	source := &SPIFFESource{newSource: func(context.Context) (x509Source, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, errors.New("agent unavailable")
+		}
+
+		return &staticX509Source{}, nil
+	}}
+
+	_, err := source.get(context.Background())
+	require.EqualError(t, err, "agent unavailable")
+
+	first, err := source.get(context.Background())
+	require.NoError(t, err)
+	second, err := source.get(context.Background())
+	require.NoError(t, err)
+	require.Same(t, first, second)
+	require.Equal(t, 2, attempts)
+}
+
+func TestApplySPIFFEErrors(t *testing.T) {
+	source := NewSPIFFESource("unix:///missing.sock")
+
+	require.EqualError(t, ApplySPIFFE(&tls.Config{Certificates: []tls.Certificate{{}}}, source, nil), "client_cert and spiffe can't be set together")
+	require.Error(t, ApplySPIFFE(&tls.Config{}, source, []string{"not a SPIFFE ID"}))
+}
@@ -0,0 +1,95 @@
+package gitaly
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+const (
+	tlsScheme = "tls://"
+	tcpScheme = "tcp://"
+)
+
+// NewTLSConfig returns the TLS configuration of the connections to tls://
+// Gitaly addresses: the certificate of Gitaly is verified against caFile, or
+// the system certificate pool, and the client certificate is presented if
+// certFile is set. serverName overrides the host of the address.
+func NewTLSConfig(caFile, certFile, keyFile, serverName string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(filepath.Clean(caFile))
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("client_cert and client_key must be set together")
+	}
+
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// tlsConfig returns the TLS configuration of the connection of cmd, or nil
+// to leave it to the Gitaly client
+func (o *ConnectionOptions) tlsConfig(cmd Command) *tls.Config {
+	if !strings.HasPrefix(cmd.Address, tlsScheme) {
+		return nil
+	}
+
+	if config, ok := o.StorageTLS[cmd.Storage]; ok {
+		return config
+	}
+
+	return o.TLS
+}
+
+// tlsDialOptions dials address over TLS with config. The Gitaly client only
+// verifies tls:// addresses against the system certificate pool, so that it's
+// given a tcp:// address instead, with a dialer setting up TLS.
+func tlsDialOptions(address string, config *tls.Config) (string, grpc.DialOption) {
+	dial := func(ctx context.Context, addr string) (net.Conn, error) {
+		config := config.Clone()
+		config.NextProtos = []string{"h2"}
+		if config.ServerName == "" {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			config.ServerName = host
+		}
+
+		dialer := &tls.Dialer{Config: config}
+
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	return tcpScheme + strings.TrimPrefix(address, tlsScheme), grpc.WithContextDialer(dial)
+}
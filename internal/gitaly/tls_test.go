@@ -0,0 +1,134 @@
+package gitaly
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestCert writes a self-signed certificate for dnsName and its key,
+// returning their paths and the certificate
+func writeTestCert(t *testing.T, commonName, dnsName string, usage x509.ExtKeyUsage) (string, string, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{usage},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{dnsName},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	return certFile, keyFile, cert
+}
+
+func TestMutualTLS(t *testing.T) {
+	serverCert, serverKey, _ := writeTestCert(t, "gitaly", "gitaly.internal", x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey, client := writeTestCert(t, "gitlab-shell", "gitlab-shell.internal", x509.ExtKeyUsageClientAuth)
+
+	serverKeyPair, err := tls.LoadX509KeyPair(serverCert, serverKey)
+	require.NoError(t, err)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(client)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverKeyPair},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		NextProtos:   []string{"h2"},
+		MinVersion:   tls.VersionTLS12,
+	})
+	require.NoError(t, err)
+	defer listener.Close()
+
+	handshakes := make(chan tls.ConnectionState, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		tlsConn := conn.(*tls.Conn)
+		if tlsConn.Handshake() == nil {
+			handshakes <- tlsConn.ConnectionState()
+		}
+	}()
+
+	tlsConfig, err := NewTLSConfig(serverCert, clientCert, clientKey, "gitaly.internal")
+	require.NoError(t, err)
+
+	c := newClient()
+	c.Options.StorageTLS = map[string]*tls.Config{"secondary": tlsConfig}
+	defer c.Close()
+
+	conn, err := c.GetConnection(context.Background(), Command{
+		ServiceName: "git-upload-pack",
+		Address:     "tls://" + listener.Addr().String(),
+		Storage:     "secondary",
+	})
+	require.NoError(t, err)
+	conn.Connect()
+
+	select {
+	case state := <-handshakes:
+		require.Equal(t, "h2", state.NegotiatedProtocol)
+		require.Len(t, state.PeerCertificates, 1)
+		require.Equal(t, "gitlab-shell", state.PeerCertificates[0].Subject.CommonName)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no TLS handshake")
+	}
+}
+
+func TestConnectionTLSConfig(t *testing.T) {
+	defaultTLS, storageTLS := &tls.Config{}, &tls.Config{}
+	options := ConnectionOptions{TLS: defaultTLS, StorageTLS: map[string]*tls.Config{"secondary": storageTLS}}
+
+	require.Same(t, defaultTLS, options.tlsConfig(Command{Address: "tls://gitaly:9999", Storage: "default"}))
+	require.Same(t, storageTLS, options.tlsConfig(Command{Address: "tls://gitaly:9999", Storage: "secondary"}))
+	require.Nil(t, options.tlsConfig(Command{Address: "tcp://gitaly:9999", Storage: "secondary"}))
+	require.Nil(t, (&ConnectionOptions{}).tlsConfig(Command{Address: "tls://gitaly:9999"}))
+}
+
+func TestNewTLSConfigErrors(t *testing.T) {
+	_, err := NewTLSConfig("", "client.crt", "", "")
+	require.EqualError(t, err, "client_cert and client_key must be set together")
+
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0o600))
+	_, err = NewTLSConfig(notPEM, "", "", "")
+	require.EqualError(t, err, "no certificate found in "+notPEM)
+}
@@ -0,0 +1,121 @@
+package accessverifier
+
+import (
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+// Results of a cache lookup, as reported by metrics
+const (
+	cacheHit  = "hit"
+	cacheMiss = "miss"
+)
+
+// maxCacheEntries bounds the size of the cache. Once it's reached, responses
+// aren't cached until entries expire.
+const maxCacheEntries = 10000
+
+// responseCache caches the bodies of the successful responses of the
+// internal API to the access checks of reads, so that the fetches of a
+// repository by a user within a short window share a single call. It is
+// shared by all the clients of the process: gitlab-sshd creates them per
+// command.
+type responseCache struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	body    []byte
+	expires time.Time
+	// userID and projectID identify the entries GitLab invalidates
+	userID    string
+	projectID int
+}
+
+var cache = &responseCache{now: time.Now, entries: make(map[string]cacheEntry)}
+
+// get returns the cached body for key, if it hasn't expired
+func (c *responseCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || !c.now().Before(entry.expires) {
+		metrics.AccessCacheLookupsTotal.WithLabelValues(cacheMiss).Inc()
+		return nil, false
+	}
+
+	metrics.AccessCacheLookupsTotal.WithLabelValues(cacheHit).Inc()
+
+	return entry.body, true
+}
+
+// set caches the body of response for key during ttl. Responses with a
+// transfer quota aren't cached, as the usage they report would go stale.
+func (c *responseCache) set(key string, body []byte, response *Response, ttl time.Duration) {
+	if ttl <= 0 || response.TransferQuota != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if len(c.entries) >= maxCacheEntries {
+		c.sweep(now)
+	}
+	if len(c.entries) >= maxCacheEntries {
+		return
+	}
+
+	c.entries[key] = cacheEntry{
+		body:      body,
+		expires:   now.Add(ttl),
+		userID:    response.UserID,
+		projectID: response.ProjectID,
+	}
+}
+
+// invalidate drops the entries of the user userID and of the project
+// projectID, or all of them when neither is given, and returns how many it
+// dropped
+func (c *responseCache) invalidate(userID string, projectID int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dropped := 0
+	for key, entry := range c.entries {
+		if userID != "" && entry.userID != userID {
+			continue
+		}
+		if projectID != 0 && entry.projectID != projectID {
+			continue
+		}
+
+		delete(c.entries, key)
+		dropped++
+	}
+
+	return dropped
+}
+
+// sweep removes the expired entries
+func (c *responseCache) sweep(now time.Time) {
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Invalidate drops the cached access checks of the user userID, a GitLab ID
+// such as "user-1", and of the project projectID, or all of them when
+// neither is given. Both given drop the checks of the user on the project.
+// It returns how many checks it dropped.
+func Invalidate(userID string, projectID int) int {
+	return cache.invalidate(userID, projectID)
+}
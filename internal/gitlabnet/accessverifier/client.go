@@ -2,9 +2,20 @@
 package accessverifier
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"google.golang.org/protobuf/proto"
 
 	pb "gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
@@ -16,10 +27,18 @@ import (
 const (
 	sshProtocol = "ssh"
 	anyChanges  = "_any"
+	// bundleID identifies the bundle of BundleURI in the bundle list
+	bundleID = "gitlab"
 )
 
+// FeatureUploadPackSidechannel is the feature flag by which GitLab can turn
+// off the sidechannel of git-upload-pack, e.g. while a Gitaly server doesn't
+// support it. The sidechannel is used unless the flag is "false".
+const FeatureUploadPackSidechannel = "gitlab-shell-upload-pack-sidechannel"
+
 // Client is a client for accessing resources
 type Client struct {
+	config *config.Config
 	client *client.GitlabNetClient
 }
 
@@ -44,6 +63,125 @@ type Gitaly struct {
 	Address  string            `json:"address"`
 	Token    string            `json:"token"`
 	Features map[string]string `json:"features"`
+	// ObjectPool is the repository whose objects the repository borrows
+	ObjectPool *pb.Repository `json:"object_pool,omitempty"`
+	// Quarantine routes the objects written by the command to a quarantine
+	// directory, from which the objects of the repository still are read
+	Quarantine *Quarantine `json:"quarantine,omitempty"`
+	// Filters are the partial clone filters git-upload-pack accepts, such as
+	// blob:none or tree, as named by uploadpackfilter.<filter>.allow
+	Filters []string `json:"filters,omitempty"`
+}
+
+// Quarantine represents the object directories of a quarantined command,
+// relative to the repository
+type Quarantine struct {
+	ObjectDirectory            string   `json:"object_directory"`
+	AlternateObjectDirectories []string `json:"alternate_object_directories,omitempty"`
+}
+
+// Repository returns the repository to send to Gitaly, routed to the object
+// directories of the quarantine, the object pool and the repository
+func (g *Gitaly) Repository() *pb.Repository {
+	repo := proto.Clone(&g.Repo).(*pb.Repository)
+
+	if g.Quarantine != nil && g.Quarantine.ObjectDirectory != "" {
+		repo.GitObjectDirectory = g.Quarantine.ObjectDirectory
+		repo.GitAlternateObjectDirectories = appendMissing(repo.GitAlternateObjectDirectories, g.Quarantine.AlternateObjectDirectories...)
+	}
+
+	if dir := g.objectPoolDirectory(); dir != "" {
+		repo.GitAlternateObjectDirectories = appendMissing(repo.GitAlternateObjectDirectories, dir)
+	}
+
+	return repo
+}
+
+// objectPoolDirectory returns the object directory of the pool relative to
+// the repository, e.g. while the pool isn't linked to it yet, or "" if the
+// pool is on another storage
+func (g *Gitaly) objectPoolDirectory() string {
+	pool := g.ObjectPool
+	if pool == nil || pool.RelativePath == "" || g.Repo.RelativePath == "" {
+		return ""
+	}
+	if pool.StorageName != "" && pool.StorageName != g.Repo.StorageName {
+		return ""
+	}
+
+	dir, err := filepath.Rel(g.Repo.RelativePath, filepath.Join(pool.RelativePath, "objects"))
+	if err != nil {
+		return ""
+	}
+
+	return dir
+}
+
+// UploadPackConfigOptions returns the Git config options of git-upload-pack,
+// allowing only the partial clone filters of the response and advertising its
+// bundle URI
+func (r *Response) UploadPackConfigOptions() []string {
+	bundleURI := validBundleURI(r.BundleURI)
+	if len(r.Gitaly.Filters) == 0 && bundleURI == "" {
+		return r.GitConfigOptions
+	}
+
+	options := slices.Clone(r.GitConfigOptions)
+	if len(r.Gitaly.Filters) > 0 {
+		options = append(options, "uploadpack.allowFilter=true", "uploadpackfilter.allow=false")
+		for _, filter := range r.Gitaly.Filters {
+			options = append(options, fmt.Sprintf("uploadpackfilter.%s.allow=true", filter))
+		}
+	}
+
+	// Git advertises the bundle-uri capability over protocol v2 only, for
+	// clients to download the bundle before fetching what it lacks
+	if bundleURI != "" {
+		options = append(options,
+			"uploadpack.advertiseBundleURIs=true",
+			"bundle.version=1",
+			"bundle.mode=all",
+			fmt.Sprintf("bundle.%s.uri=%s", bundleID, bundleURI),
+		)
+	}
+
+	return options
+}
+
+// validBundleURI returns uri if it's an absolute HTTP(S) URL that can be
+// passed as a Git config option, or ""
+func validBundleURI(uri string) string {
+	if uri == "" || strings.ContainsFunc(uri, unicode.IsControl) {
+		return ""
+	}
+
+	parsed, err := url.Parse(uri)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return ""
+	}
+
+	return uri
+}
+
+func appendMissing(dirs []string, more ...string) []string {
+	for _, dir := range more {
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
+
+// FeatureEnabled returns the value of the feature flag name, or
+// defaultValue if it isn't set
+func (g *Gitaly) FeatureEnabled(name string, defaultValue bool) bool {
+	value, ok := g.Features[name]
+	if !ok {
+		return defaultValue
+	}
+
+	return value == "true"
 }
 
 // CustomPayloadData represents custom payload data
@@ -58,6 +196,9 @@ type CustomPayloadData struct {
 	GeoProxyFetchDirectToPrimaryWithOptions bool              `json:"geo_proxy_fetch_direct_to_primary_with_options"`
 	GeoProxyFetchSSHDirectToPrimary         bool              `json:"geo_proxy_fetch_ssh_direct_to_primary"`
 	GeoProxyPushSSHDirectToPrimary          bool              `json:"geo_proxy_push_ssh_direct_to_primary"`
+	// ProtocolVersion 2 streams the requests to APIEndpoints and their
+	// responses, rather than exchanging them as JSON
+	ProtocolVersion int `json:"protocol_version,omitempty"`
 }
 
 // CustomPayload represents a custom payload
@@ -68,24 +209,45 @@ type CustomPayload struct {
 
 // Response represents a response from GitLab
 type Response struct {
-	Success          bool          `json:"status"`
-	Message          string        `json:"message"`
-	Repo             string        `json:"gl_repository"`
-	UserID           string        `json:"gl_id"`
-	KeyType          string        `json:"gl_key_type"`
-	KeyID            int           `json:"gl_key_id"`
-	ProjectID        int           `json:"gl_project_id"`
-	RootNamespaceID  int           `json:"gl_root_namespace_id"`
-	Username         string        `json:"gl_username"`
-	GitConfigOptions []string      `json:"git_config_options"`
-	Gitaly           Gitaly        `json:"gitaly"`
-	GitProtocol      string        `json:"git_protocol"`
-	Payload          CustomPayload `json:"payload"`
-	ConsoleMessages  []string      `json:"gl_console_messages"`
-	Who              string
-	StatusCode       int
+	Success          bool     `json:"status"`
+	Message          string   `json:"message"`
+	Repo             string   `json:"gl_repository"`
+	UserID           string   `json:"gl_id"`
+	KeyType          string   `json:"gl_key_type"`
+	KeyID            int      `json:"gl_key_id"`
+	ProjectID        int      `json:"gl_project_id"`
+	RootNamespaceID  int      `json:"gl_root_namespace_id"`
+	Username         string   `json:"gl_username"`
+	GitConfigOptions []string `json:"git_config_options"`
+	// BundleURI is the URL of a bundle of the repository, e.g. on a CDN,
+	// which clients may clone from before fetching the rest
+	BundleURI       string        `json:"bundle_uri,omitempty"`
+	Gitaly          Gitaly        `json:"gitaly"`
+	GitProtocol     string        `json:"git_protocol"`
+	Payload         CustomPayload `json:"payload"`
+	ConsoleMessages []string      `json:"gl_console_messages"`
+	Who             string
+	StatusCode      int
 	// NeedAudit indicates whether git event should be audited to rails.
 	NeedAudit bool `json:"need_audit"`
+	// AllowedIPs restricts the IPs the user may connect from, when set
+	AllowedIPs []string `json:"allowed_ips,omitempty"`
+	// TransferQuota limits the bytes the user transfers in a day, when set
+	TransferQuota *TransferQuota `json:"transfer_quota,omitempty"`
+}
+
+// TransferQuota is the daily transfer quota of a user, and how much of it
+// they used so far
+type TransferQuota struct {
+	LimitBytes int64 `json:"limit_bytes"`
+	UsedBytes  int64 `json:"used_bytes"`
+	// ResetsAt is when the usage goes back to 0, e.g. "2024-01-02T00:00:00Z"
+	ResetsAt string `json:"resets_at,omitempty"`
+}
+
+// Remaining returns the bytes the user may still transfer
+func (q *TransferQuota) Remaining() int64 {
+	return max(q.LimitBytes-q.UsedBytes, 0)
 }
 
 // NewClient creates a new instance of Client
@@ -95,7 +257,7 @@ func NewClient(config *config.Config) (*Client, error) {
 		return nil, fmt.Errorf("error creating http client: %v", err)
 	}
 
-	return &Client{client: client}, nil
+	return &Client{config: config, client: client}, nil
 }
 
 // Verify verifies access to a GitLab resource
@@ -119,13 +281,56 @@ func (c *Client) Verify(ctx context.Context, args *commandargs.Shell, action com
 
 	request.CheckIP = gitlabnet.ParseIP(args.Env.RemoteAddr)
 
+	cacheKey, cacheable := c.cacheKey(request)
+	if cacheable {
+		if body, ok := cache.get(cacheKey); ok {
+			return parse(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(body))}, args)
+		}
+	}
+
+	ctx = context.WithValue(ctx, client.OperationContextKey{}, string(action))
 	response, err := c.client.Post(ctx, "/allowed", request)
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = response.Body.Close() }()
 
-	return parse(response, args)
+	if !cacheable {
+		return parse(response, args)
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	response.Body = io.NopCloser(bytes.NewReader(body))
+
+	parsed, err := parse(response, args)
+	if err == nil && parsed.Success && parsed.StatusCode == http.StatusOK {
+		cache.set(cacheKey, body, parsed, time.Duration(c.config.AccessCache.TTL))
+	}
+
+	return parsed, err
+}
+
+// cacheKey returns the key of the access check of request in the cache, and
+// whether it's cached at all: only the checks of reads are, pushes being
+// checked against their changes
+func (c *Client) cacheKey(request *Request) (string, bool) {
+	if c.config.AccessCache.TTL <= 0 {
+		return "", false
+	}
+
+	if request.Action != commandargs.UploadPack && request.Action != commandargs.UploadArchive {
+		return "", false
+	}
+
+	key, err := json.Marshal(request)
+	if err != nil {
+		return "", false
+	}
+
+	return c.config.GitlabUrl + "\x00" + string(key), true
 }
 
 func parse(hr *http.Response, args *commandargs.Shell) (*Response, error) {
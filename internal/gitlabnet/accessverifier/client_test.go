@@ -3,17 +3,22 @@ package accessverifier
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path"
+	"slices"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	pb "gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/testhelper"
 )
@@ -174,7 +179,7 @@ func TestErrorResponses(t *testing.T) {
 		{
 			desc:          "A response with bad JSON",
 			fakeID:        "3",
-			expectedError: "Parsing failed",
+			expectedError: "Parsing failed: /api/v4/internal/allowed: truncated JSON",
 		},
 		{
 			desc:          "An error response without message",
@@ -316,3 +321,183 @@ func setupWithAPIInspector(t *testing.T, inspector func(*Request)) *Client {
 
 	return client
 }
+
+func TestGitalyRepository(t *testing.T) {
+	repo := &pb.Repository{
+		StorageName:                   "default",
+		RelativePath:                  "@hashed/5f/9c/5f9c.git",
+		GitAlternateObjectDirectories: []string{"objects/alternate"},
+	}
+
+	testCases := []struct {
+		desc                       string
+		objectPool                 *pb.Repository
+		quarantine                 *Quarantine
+		expectedObjectDirectory    string
+		expectedAlternateDirectory []string
+	}{
+		{
+			desc:                       "without routing",
+			expectedAlternateDirectory: []string{"objects/alternate"},
+		},
+		{
+			desc: "with a quarantine",
+			quarantine: &Quarantine{
+				ObjectDirectory:            "objects/incoming-1",
+				AlternateObjectDirectories: []string{"objects", "objects/alternate"},
+			},
+			expectedObjectDirectory:    "objects/incoming-1",
+			expectedAlternateDirectory: []string{"objects/alternate", "objects"},
+		},
+		{
+			desc:                       "with an object pool",
+			objectPool:                 &pb.Repository{StorageName: "default", RelativePath: "@pools/ab/cd/abcd.git"},
+			expectedAlternateDirectory: []string{"objects/alternate", "../../../../@pools/ab/cd/abcd.git/objects"},
+		},
+		{
+			desc:                       "with an object pool on another storage",
+			objectPool:                 &pb.Repository{StorageName: "other", RelativePath: "@pools/ab/cd/abcd.git"},
+			expectedAlternateDirectory: []string{"objects/alternate"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			gitaly := &Gitaly{ObjectPool: tc.objectPool, Quarantine: tc.quarantine}
+			gitaly.Repo.StorageName = repo.StorageName
+			gitaly.Repo.RelativePath = repo.RelativePath
+			gitaly.Repo.GitAlternateObjectDirectories = slices.Clone(repo.GitAlternateObjectDirectories)
+
+			result := gitaly.Repository()
+
+			require.Equal(t, repo.RelativePath, result.RelativePath)
+			require.Equal(t, tc.expectedObjectDirectory, result.GitObjectDirectory)
+			require.Equal(t, tc.expectedAlternateDirectory, result.GitAlternateObjectDirectories)
+			require.Equal(t, []string{"objects/alternate"}, gitaly.Repo.GitAlternateObjectDirectories)
+		})
+	}
+}
+
+func TestUploadPackConfigOptions(t *testing.T) {
+	response := &Response{GitConfigOptions: []string{"uploadpack.allowAnySHA1InWant=true"}}
+	require.Equal(t, []string{"uploadpack.allowAnySHA1InWant=true"}, response.UploadPackConfigOptions())
+
+	response.Gitaly.Filters = []string{"blob:none", "tree"}
+	require.Equal(t, []string{
+		"uploadpack.allowAnySHA1InWant=true",
+		"uploadpack.allowFilter=true",
+		"uploadpackfilter.allow=false",
+		"uploadpackfilter.blob:none.allow=true",
+		"uploadpackfilter.tree.allow=true",
+	}, response.UploadPackConfigOptions())
+	require.Len(t, response.GitConfigOptions, 1)
+
+	response = &Response{BundleURI: "https://cdn.example.com/bundles/project.bundle"}
+	require.Equal(t, []string{
+		"uploadpack.advertiseBundleURIs=true",
+		"bundle.version=1",
+		"bundle.mode=all",
+		"bundle.gitlab.uri=https://cdn.example.com/bundles/project.bundle",
+	}, response.UploadPackConfigOptions())
+
+	for _, uri := range []string{"file:///srv/project.bundle", "/srv/project.bundle", "https://cdn.example.com/a\nb", "https:///project.bundle"} {
+		response.BundleURI = uri
+		require.Empty(t, response.UploadPackConfigOptions(), uri)
+	}
+}
+
+func TestCache(t *testing.T) {
+	metrics.AccessCacheLookupsTotal.Reset()
+	t.Cleanup(func() { Invalidate("", 0) })
+
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	t.Cleanup(func() { cache.now = time.Now })
+
+	testRoot := testhelper.PrepareTestRootDir(t)
+	body := responseBody(t, testRoot, "allowed.json")
+
+	calls := 0
+	url := testserver.StartSocketHttpServer(t, []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/allowed",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				calls++
+
+				var request Request
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+
+				if request.KeyID == "denied" {
+					w.WriteHeader(http.StatusForbidden)
+					fmt.Fprint(w, `{"status": false, "message": "denied"}`)
+					return
+				}
+
+				_, err := w.Write(body)
+				require.NoError(t, err)
+			},
+		},
+	})
+
+	client, err := NewClient(&config.Config{
+		GitlabUrl:   url,
+		AccessCache: config.AccessCacheConfig{TTL: config.YamlDuration(time.Minute)},
+	})
+	require.NoError(t, err)
+
+	verify := func(keyID string, action commandargs.CommandType) (*Response, error) {
+		return client.Verify(context.Background(), &commandargs.Shell{GitlabKeyId: keyID}, action, repo)
+	}
+
+	for i := 0; i < 2; i++ {
+		result, err := verify("1", uploadPackAction)
+		require.NoError(t, err)
+		require.Equal(t, buildExpectedResponse("key-1"), result)
+	}
+	require.Equal(t, 1, calls)
+
+	// Pushes are checked every time
+	for i := 0; i < 2; i++ {
+		_, err := verify("1", receivePackAction)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 3, calls)
+
+	// Denials aren't cached
+	for i := 0; i < 2; i++ {
+		_, err := verify("denied", uploadPackAction)
+		require.Error(t, err)
+	}
+	require.Equal(t, 5, calls)
+
+	// Invalidating another user keeps the entry
+	require.Equal(t, 0, Invalidate("user-2", 0))
+	_, err = verify("1", uploadPackAction)
+	require.NoError(t, err)
+	require.Equal(t, 5, calls)
+
+	require.Equal(t, 1, Invalidate("user-1", 0))
+	_, err = verify("1", uploadPackAction)
+	require.NoError(t, err)
+	require.Equal(t, 6, calls)
+
+	now = now.Add(time.Minute)
+	_, err = verify("1", uploadPackAction)
+	require.NoError(t, err)
+	require.Equal(t, 7, calls)
+
+	require.InDelta(t, 2, testutil.ToFloat64(metrics.AccessCacheLookupsTotal.WithLabelValues("hit")), 0.1)
+	require.InDelta(t, 5, testutil.ToFloat64(metrics.AccessCacheLookupsTotal.WithLabelValues("miss")), 0.1)
+}
+
+func TestCacheDisabled(t *testing.T) {
+	testRoot := testhelper.PrepareTestRootDir(t)
+	okResponse := testResponse{body: responseBody(t, testRoot, "allowed.json"), status: http.StatusOK}
+	client := setup(t, nil, map[string]testResponse{"1": okResponse})
+
+	for i := 0; i < 2; i++ {
+		_, err := client.Verify(context.Background(), &commandargs.Shell{GitlabKeyId: "1"}, uploadPackAction, repo)
+		require.NoError(t, err)
+	}
+	require.Empty(t, cache.entries)
+}
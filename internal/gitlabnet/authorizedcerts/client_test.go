@@ -70,7 +70,7 @@ func TestGetByKeyErrorResponses(t *testing.T) {
 		{
 			desc:          "A response with bad JSON",
 			key:           "broken-json",
-			expectedError: "Parsing failed",
+			expectedError: "Parsing failed: /api/v4/internal/authorized_certs: truncated JSON",
 		},
 		{
 			desc:          "A forbidden (403) response without message",
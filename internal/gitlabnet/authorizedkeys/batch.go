@@ -0,0 +1,102 @@
+package authorizedkeys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
+)
+
+const (
+	// BatchPath represents the path to the endpoint looking up many
+	// authorized keys at once
+	BatchPath = AuthorizedKeysPath + "/batch"
+
+	// MaxBatchSize is the most keys looked up in a single request
+	MaxBatchSize = 1000
+)
+
+// ErrBatchUnsupported is returned by GetBatch when GitLab has no batch
+// endpoint, in which case the keys must be looked up one by one
+var ErrBatchUnsupported = errors.New("batch lookups of authorized keys are unsupported")
+
+// Lookup is a key to look up in a batch: either an encoded key, or a
+// fingerprint along with its format
+type Lookup struct {
+	Key             string `json:"key,omitempty"`
+	Fingerprint     string `json:"fingerprint,omitempty"`
+	FingerprintType string `json:"fingerprint_type,omitempty"`
+}
+
+type batchRequest struct {
+	Lookups []Lookup `json:"lookups"`
+}
+
+// batchResponse lists the keys found in the order of the lookups, null for
+// the missing ones
+type batchResponse struct {
+	Keys []*Response `json:"keys"`
+}
+
+// GetBatch looks up keys, each an encoded key or a fingerprint as accepted by
+// GetByFingerprint, in a single request. It returns their responses in the
+// same order, nil for the keys not found or invalid.
+func (c *Client) GetBatch(ctx context.Context, keys []string) ([]*Response, error) {
+	if len(keys) > MaxBatchSize {
+		return nil, fmt.Errorf("too many keys in a batch: %d, at most %d", len(keys), MaxBatchSize)
+	}
+
+	results := make([]*Response, len(keys))
+
+	// The invalid fingerprints aren't sent, indexes maps the lookups to keys
+	var lookups []Lookup
+	var indexes []int
+	for i, key := range keys {
+		lookup := Lookup{Key: key}
+
+		if IsFingerprint(key) {
+			format, fingerprint, err := ParseFingerprint(key)
+			if err != nil {
+				continue
+			}
+
+			lookup = Lookup{Fingerprint: fingerprint, FingerprintType: format}
+		}
+
+		lookups = append(lookups, lookup)
+		indexes = append(indexes, i)
+	}
+
+	if len(lookups) == 0 {
+		return results, nil
+	}
+
+	response, err := c.client.Post(ctx, BatchPath, &batchRequest{Lookups: lookups})
+	if err != nil {
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			return nil, ErrBatchUnsupported
+		}
+
+		return nil, err
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	parsedResponse := &batchResponse{}
+	if err := gitlabnet.ParseJSON(response, parsedResponse); err != nil {
+		return nil, err
+	}
+
+	if len(parsedResponse.Keys) != len(lookups) {
+		return nil, fmt.Errorf("%w: %d keys returned for %d lookups", gitlabnet.ParsingError, len(parsedResponse.Keys), len(lookups))
+	}
+
+	for i, key := range parsedResponse.Keys {
+		results[indexes[i]] = key
+	}
+
+	return results, nil
+}
@@ -0,0 +1,192 @@
+package authorizedkeys
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+// defaultCacheMaxAge is how long a cached key is used while the internal API
+// is unavailable, unless configured otherwise
+const defaultCacheMaxAge = time.Hour
+
+// keyCache stores the keys found on disk, one file per lookup, as every
+// lookup runs in a process of its own
+type keyCache struct {
+	dir    string
+	ttl    time.Duration
+	maxAge time.Duration
+	secret []byte
+	now    func() time.Time
+}
+
+// cacheEntry is a cached key. Its signature covers the lookup and the time
+// it was stored, so that an entry can't be altered, replayed for another key
+// or made to look recent without the secret.
+type cacheEntry struct {
+	Lookup    string    `json:"lookup"`
+	Response  Response  `json:"response"`
+	StoredAt  time.Time `json:"stored_at"`
+	Signature string    `json:"signature"`
+}
+
+// newKeyCache returns the cache the configuration enables, or nil. Entries
+// can't be signed without a secret, so there is no cache without one.
+func newKeyCache(cfg *config.Config) *keyCache {
+	cacheConfig := cfg.AuthorizedKeysCache
+	if cacheConfig.Dir == "" {
+		return nil
+	}
+
+	secret := cfg.CurrentSecret()
+	if secret == "" {
+		return nil
+	}
+
+	maxAge := time.Duration(cacheConfig.MaxAge)
+	if maxAge <= 0 {
+		maxAge = defaultCacheMaxAge
+	}
+
+	return &keyCache{
+		dir:    cacheConfig.Dir,
+		ttl:    time.Duration(cacheConfig.TTL),
+		maxAge: maxAge,
+		secret: []byte(secret),
+		now:    time.Now,
+	}
+}
+
+// fetch answers lookup from the cache if it was stored within the TTL, or
+// with get otherwise. The response of get is cached, and its refusal removes
+// the cached one. While the internal API is unavailable, a response stored
+// within the max age is returned instead of the error.
+func (c *keyCache) fetch(ctx context.Context, lookup string, get func() (*Response, error)) (*Response, error) {
+	cached := c.get(lookup)
+	if cached != nil && c.now().Sub(cached.StoredAt) < c.ttl {
+		return &cached.Response, nil
+	}
+
+	response, err := get()
+	if err == nil {
+		c.store(ctx, lookup, response)
+		return response, nil
+	}
+
+	if client.IsUnavailable(err) {
+		if cached != nil && c.now().Sub(cached.StoredAt) < c.maxAge {
+			log.WithContextFields(ctx, log.Fields{"stored_at": cached.StoredAt}).WithError(err).
+				Warn("authorizedkeys: the internal API is unavailable, using the cached key")
+
+			return &cached.Response, nil
+		}
+
+		return nil, err
+	}
+
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) {
+		c.remove(lookup)
+	}
+
+	return nil, err
+}
+
+// get returns the entry of lookup if there is one, validly signed
+func (c *keyCache) get(lookup string) *cacheEntry {
+	data, err := os.ReadFile(c.path(lookup))
+	if err != nil {
+		return nil
+	}
+
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil
+	}
+
+	signature, err := hex.DecodeString(entry.Signature)
+	if err != nil || entry.Lookup != lookup || !hmac.Equal(signature, c.sign(entry)) {
+		return nil
+	}
+
+	return entry
+}
+
+// store writes the entry to a temporary file renamed over the previous one,
+// so that concurrent lookups never read a partial entry. A failure only
+// leaves the key uncached.
+func (c *keyCache) store(ctx context.Context, lookup string, response *Response) {
+	entry := &cacheEntry{Lookup: lookup, Response: *response, StoredAt: c.now().UTC()}
+	entry.Signature = hex.EncodeToString(c.sign(entry))
+
+	if err := c.write(entry); err != nil {
+		log.WithContextFields(ctx, log.Fields{"dir": c.dir}).WithError(err).Warn("authorizedkeys: failed to cache the key")
+	}
+}
+
+func (c *keyCache) write(entry *cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(c.dir, 0o700); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(c.dir, ".entry-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), c.path(entry.Lookup))
+}
+
+func (c *keyCache) remove(lookup string) {
+	_ = os.Remove(c.path(lookup))
+}
+
+// path names the entry of lookup after its hash, as lookups hold encoded keys
+// longer than file names may be
+func (c *keyCache) path(lookup string) string {
+	sum := sha256.Sum256([]byte(lookup))
+
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+func (c *keyCache) sign(entry *cacheEntry) []byte {
+	mac := hmac.New(sha256.New, c.secret)
+	for _, field := range []string{
+		entry.Lookup,
+		strconv.FormatInt(entry.Response.ID, 10),
+		entry.Response.Key,
+		strconv.FormatInt(entry.StoredAt.UnixNano(), 10),
+	} {
+		mac.Write([]byte(field))
+		mac.Write([]byte{0})
+	}
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,188 @@
+package authorizedkeys
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+const testLookup = "http+unix://gitlab.socket/authorized_keys?key=key"
+
+var (
+	found       = &Response{ID: 1, Key: "public-key"}
+	unavailable = &client.APIError{Msg: "Internal API error (503)", StatusCode: 503}
+	notFound    = &client.APIError{Msg: "Internal API error (404)", StatusCode: 404}
+)
+
+func newTestCache(t *testing.T, ttl time.Duration) (*keyCache, *time.Time) {
+	now := time.Now()
+
+	cache := newKeyCache(&config.Config{
+		Secret:              "secret",
+		AuthorizedKeysCache: config.AuthorizedKeysCacheConfig{Dir: t.TempDir(), TTL: config.YamlDuration(ttl)},
+	})
+	require.NotNil(t, cache)
+	cache.now = func() time.Time { return now }
+
+	return cache, &now
+}
+
+// answer returns a lookup of the internal API answering with response and err,
+// and counting its calls in calls
+func answer(calls *int, response *Response, err error) func() (*Response, error) {
+	return func() (*Response, error) {
+		*calls++
+		return response, err
+	}
+}
+
+func TestCacheWhenUnavailable(t *testing.T) {
+	cache, now := newTestCache(t, 0)
+	calls := 0
+
+	response, err := cache.fetch(context.Background(), testLookup, answer(&calls, found, nil))
+	require.NoError(t, err)
+	require.Equal(t, found, response)
+
+	// Without TTL the API is asked each time, and its outage is covered by
+	// the cached key
+	*now = now.Add(30 * time.Minute)
+	response, err = cache.fetch(context.Background(), testLookup, answer(&calls, nil, unavailable))
+	require.NoError(t, err)
+	require.Equal(t, found, response)
+	require.Equal(t, 2, calls)
+
+	// Until the max age
+	*now = now.Add(time.Hour)
+	_, err = cache.fetch(context.Background(), testLookup, answer(&calls, nil, unavailable))
+	require.Equal(t, unavailable, err)
+}
+
+func TestCacheTTL(t *testing.T) {
+	cache, now := newTestCache(t, time.Minute)
+	calls := 0
+
+	for i := 0; i < 2; i++ {
+		response, err := cache.fetch(context.Background(), testLookup, answer(&calls, found, nil))
+		require.NoError(t, err)
+		require.Equal(t, found, response)
+	}
+	require.Equal(t, 1, calls)
+
+	*now = now.Add(time.Minute)
+	_, err := cache.fetch(context.Background(), testLookup, answer(&calls, found, nil))
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+}
+
+func TestCacheRemovesRefusedKeys(t *testing.T) {
+	cache, _ := newTestCache(t, 0)
+	calls := 0
+
+	_, err := cache.fetch(context.Background(), testLookup, answer(&calls, found, nil))
+	require.NoError(t, err)
+
+	_, err = cache.fetch(context.Background(), testLookup, answer(&calls, nil, notFound))
+	require.Equal(t, notFound, err)
+	require.NoFileExists(t, cache.path(testLookup))
+
+	_, err = cache.fetch(context.Background(), testLookup, answer(&calls, nil, unavailable))
+	require.Equal(t, unavailable, err)
+}
+
+func TestCacheRejectsTamperedEntries(t *testing.T) {
+	const otherLookup = "http+unix://gitlab.socket/authorized_keys?key=other"
+
+	testCases := []struct {
+		desc   string
+		tamper func(t *testing.T, cache *keyCache)
+	}{
+		{
+			desc: "with another key",
+			tamper: func(t *testing.T, cache *keyCache) {
+				entry := cache.get(testLookup)
+				entry.Response.Key = "attacker-key"
+				require.NoError(t, cache.write(entry))
+			},
+		},
+		{
+			desc: "made to look recent",
+			tamper: func(t *testing.T, cache *keyCache) {
+				entry := cache.get(testLookup)
+				entry.StoredAt = entry.StoredAt.Add(time.Hour)
+				require.NoError(t, cache.write(entry))
+			},
+		},
+		{
+			desc: "copied from another lookup",
+			tamper: func(t *testing.T, cache *keyCache) {
+				cache.store(context.Background(), otherLookup, &Response{ID: 2, Key: "other-key"})
+
+				data, err := os.ReadFile(cache.path(otherLookup))
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(cache.path(testLookup), data, 0o600))
+			},
+		},
+		{
+			desc: "signed with another secret",
+			tamper: func(t *testing.T, cache *keyCache) {
+				other := *cache
+				other.secret = []byte("other-secret")
+				other.store(context.Background(), testLookup, &Response{ID: 1, Key: "attacker-key"})
+			},
+		},
+		{
+			desc: "corrupted",
+			tamper: func(t *testing.T, cache *keyCache) {
+				require.NoError(t, os.WriteFile(cache.path(testLookup), []byte("{"), 0o600))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cache, _ := newTestCache(t, 0)
+			calls := 0
+
+			_, err := cache.fetch(context.Background(), testLookup, answer(&calls, found, nil))
+			require.NoError(t, err)
+
+			tc.tamper(t, cache)
+
+			_, err = cache.fetch(context.Background(), testLookup, answer(&calls, nil, unavailable))
+			require.Equal(t, unavailable, err)
+		})
+	}
+}
+
+func TestNoCache(t *testing.T) {
+	require.Nil(t, newKeyCache(&config.Config{Secret: "secret"}))
+	require.Nil(t, newKeyCache(&config.Config{AuthorizedKeysCache: config.AuthorizedKeysCacheConfig{Dir: t.TempDir()}}))
+}
+
+func TestClientCachesKeys(t *testing.T) {
+	url := testserver.StartSocketHttpServer(t, requests)
+	dir := t.TempDir()
+
+	client, err := NewClient(&config.Config{
+		GitlabUrl:           url,
+		Secret:              "secret",
+		AuthorizedKeysCache: config.AuthorizedKeysCacheConfig{Dir: dir, TTL: config.YamlDuration(time.Minute)},
+	})
+	require.NoError(t, err)
+
+	response, err := client.GetByKey(context.Background(), "key")
+	require.NoError(t, err)
+	require.Equal(t, found, response)
+
+	entry := client.cache.get(url + "/authorized_keys?key=key")
+	require.NotNil(t, entry)
+	require.Equal(t, *found, entry.Response)
+}
@@ -4,6 +4,7 @@ package authorizedkeys
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"net/url"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
@@ -20,6 +21,7 @@ const (
 type Client struct {
 	config *config.Config
 	client *client.GitlabNetClient
+	cache  *keyCache
 }
 
 // Response represents the response structure for authorized keys
@@ -35,7 +37,7 @@ func NewClient(config *config.Config) (*Client, error) {
 		return nil, fmt.Errorf("error creating http client: %v", err)
 	}
 
-	return &Client{config: config, client: client}, nil
+	return &Client{config: config, client: client, cache: newKeyCache(config)}, nil
 }
 
 // GetByKey retrieves authorized keys by key
@@ -45,12 +47,42 @@ func (c *Client) GetByKey(ctx context.Context, key string) (*Response, error) {
 		return nil, err
 	}
 
+	return c.get(ctx, path)
+}
+
+// GetByFingerprint retrieves authorized keys by their SHA256 or MD5
+// fingerprint, in any of the forms accepted by ParseFingerprint
+func (c *Client) GetByFingerprint(ctx context.Context, fingerprint string) (*Response, error) {
+	path, err := pathWithFingerprint(fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.get(ctx, path)
+}
+
+// get looks up the key of path, through the cache if one is configured
+func (c *Client) get(ctx context.Context, path string) (*Response, error) {
+	if c.cache == nil {
+		return c.getFromAPI(ctx, path)
+	}
+
+	return c.cache.fetch(ctx, c.config.GitlabUrl+path, func() (*Response, error) {
+		return c.getFromAPI(ctx, path)
+	})
+}
+
+func (c *Client) getFromAPI(ctx context.Context, path string) (*Response, error) {
 	response, err := c.client.Get(ctx, path)
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = response.Body.Close() }()
 
+	return parse(response)
+}
+
+func parse(response *http.Response) (*Response, error) {
 	parsedResponse := &Response{}
 	if err := gitlabnet.ParseJSON(response, parsedResponse); err != nil {
 		return nil, err
@@ -71,3 +103,22 @@ func pathWithKey(key string) (string, error) {
 
 	return u.String(), nil
 }
+
+func pathWithFingerprint(fingerprint string) (string, error) {
+	format, fingerprint, err := ParseFingerprint(fingerprint)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(AuthorizedKeysPath)
+	if err != nil {
+		return "", err
+	}
+
+	params := u.Query()
+	params.Set("fingerprint", fingerprint)
+	params.Set("fingerprint_type", format)
+	u.RawQuery = params.Encode()
+
+	return u.String(), nil
+}
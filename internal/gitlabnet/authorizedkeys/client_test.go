@@ -14,6 +14,11 @@ import (
 
 var (
 	requests []testserver.TestRequestHandler
+
+	expectedFingerprints = map[string]string{
+		FingerprintSHA256: "nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8",
+		FingerprintMD5:    "d4:1d:8c:d9:8f:00:b2:04:e9:80:09:98:ec:f8:42:7e",
+	}
 )
 
 func init() {
@@ -21,6 +26,16 @@ func init() {
 		{
 			Path: "/api/v4/internal/authorized_keys",
 			Handler: func(w http.ResponseWriter, r *http.Request) {
+				if fingerprint := r.URL.Query().Get("fingerprint"); fingerprint != "" {
+					if fingerprint != expectedFingerprints[r.URL.Query().Get("fingerprint_type")] {
+						w.WriteHeader(http.StatusNotFound)
+						return
+					}
+
+					json.NewEncoder(w).Encode(&Response{ID: 2, Key: "public-key"})
+					return
+				}
+
 				switch r.URL.Query().Get("key") {
 				case "key":
 					body := &Response{
@@ -43,6 +58,33 @@ func init() {
 				}
 			},
 		},
+		{
+			Path: "/api/v4/internal/authorized_keys/batch",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				var request batchRequest
+				if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+
+				response := &batchResponse{}
+				for _, lookup := range request.Lookups {
+					switch {
+					case lookup.Key == "broken":
+						w.WriteHeader(http.StatusForbidden)
+						return
+					case lookup.Key == "key":
+						response.Keys = append(response.Keys, &Response{ID: 1, Key: "public-key"})
+					case lookup.Fingerprint != "" && lookup.Fingerprint == expectedFingerprints[lookup.FingerprintType]:
+						response.Keys = append(response.Keys, &Response{ID: 2, Key: "public-key"})
+					default:
+						response.Keys = append(response.Keys, nil)
+					}
+				}
+
+				json.NewEncoder(w).Encode(response)
+			},
+		},
 	}
 }
 
@@ -70,7 +112,7 @@ func TestGetByKeyErrorResponses(t *testing.T) {
 		{
 			desc:          "A response with bad JSON",
 			key:           "broken-json",
-			expectedError: "Parsing failed",
+			expectedError: "Parsing failed: /api/v4/internal/authorized_keys: truncated JSON",
 		},
 		{
 			desc:          "A forbidden (403) response without message",
@@ -94,6 +136,92 @@ func TestGetByKeyErrorResponses(t *testing.T) {
 	}
 }
 
+func TestGetByFingerprint(t *testing.T) {
+	client := setup(t)
+
+	testCases := []struct {
+		desc          string
+		fingerprint   string
+		expectedError string
+	}{
+		{
+			desc:        "An SHA256 fingerprint",
+			fingerprint: "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8",
+		},
+		{
+			desc:        "An MD5 fingerprint with a prefix",
+			fingerprint: "MD5:D4:1D:8C:D9:8F:00:B2:04:E9:80:09:98:EC:F8:42:7E",
+		},
+		{
+			desc:        "An MD5 fingerprint without a prefix",
+			fingerprint: "d4:1d:8c:d9:8f:00:b2:04:e9:80:09:98:ec:f8:42:7e",
+		},
+		{
+			desc:          "An unknown fingerprint",
+			fingerprint:   "SHA256:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU",
+			expectedError: "Internal API error (404)",
+		},
+		{
+			desc:          "An invalid fingerprint",
+			fingerprint:   "SHA256:invalid",
+			expectedError: `invalid key fingerprint: "SHA256:invalid"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			resp, err := client.GetByFingerprint(context.Background(), tc.fingerprint)
+
+			if tc.expectedError != "" {
+				require.EqualError(t, err, tc.expectedError)
+				require.Nil(t, resp)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, &Response{ID: 2, Key: "public-key"}, resp)
+		})
+	}
+}
+
+func TestGetBatch(t *testing.T) {
+	client := setup(t)
+
+	result, err := client.GetBatch(context.Background(), []string{
+		"key",
+		"not-found",
+		"SHA256:invalid",
+		"SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8",
+	})
+	require.NoError(t, err)
+	require.Equal(t, []*Response{
+		{ID: 1, Key: "public-key"},
+		nil,
+		nil,
+		{ID: 2, Key: "public-key"},
+	}, result)
+}
+
+func TestGetBatchErrors(t *testing.T) {
+	client := setup(t)
+
+	_, err := client.GetBatch(context.Background(), []string{"key", "broken"})
+	require.EqualError(t, err, "Internal API error (403)")
+
+	_, err = client.GetBatch(context.Background(), make([]string, MaxBatchSize+1))
+	require.EqualError(t, err, "too many keys in a batch: 1001, at most 1000")
+}
+
+func TestGetBatchUnsupported(t *testing.T) {
+	url := testserver.StartSocketHttpServer(t, requests[:1])
+
+	client, err := NewClient(&config.Config{GitlabUrl: url})
+	require.NoError(t, err)
+
+	_, err = client.GetBatch(context.Background(), []string{"key"})
+	require.ErrorIs(t, err, ErrBatchUnsupported)
+}
+
 func setup(t *testing.T) *Client {
 	url := testserver.StartSocketHttpServer(t, requests)
 
@@ -0,0 +1,59 @@
+package authorizedkeys
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Fingerprint formats sent to the authorized_keys endpoint
+const (
+	FingerprintSHA256 = "sha256"
+	FingerprintMD5    = "md5"
+)
+
+const (
+	sha256Prefix = "SHA256:"
+	md5Prefix    = "MD5:"
+)
+
+// ErrInvalidFingerprint is returned for a fingerprint that is neither a
+// valid SHA256 nor MD5 fingerprint
+var ErrInvalidFingerprint = errors.New("invalid key fingerprint")
+
+// ParseFingerprint parses a fingerprint as printed by ssh-keygen -l or passed
+// by OpenSSH for %f: "SHA256:" followed by unpadded base64, or 16
+// colon-separated hex bytes optionally prefixed with "MD5:". It returns the
+// format and the fingerprint as GitLab stores it, without the prefix.
+func ParseFingerprint(fingerprint string) (string, string, error) {
+	if encoded, ok := strings.CutPrefix(fingerprint, sha256Prefix); ok {
+		sum, err := base64.RawStdEncoding.DecodeString(encoded)
+		if err != nil || len(sum) != 32 {
+			return "", "", fmt.Errorf("%w: %q", ErrInvalidFingerprint, fingerprint)
+		}
+
+		return FingerprintSHA256, encoded, nil
+	}
+
+	encoded := strings.ToLower(strings.TrimPrefix(fingerprint, md5Prefix))
+	bytes := strings.Split(encoded, ":")
+	if len(bytes) != 16 {
+		return "", "", fmt.Errorf("%w: %q", ErrInvalidFingerprint, fingerprint)
+	}
+
+	for _, b := range bytes {
+		if _, err := hex.DecodeString(b); err != nil || len(b) != 2 {
+			return "", "", fmt.Errorf("%w: %q", ErrInvalidFingerprint, fingerprint)
+		}
+	}
+
+	return FingerprintMD5, encoded, nil
+}
+
+// IsFingerprint reports whether key is a fingerprint rather than a base64
+// encoded public key. Encoded keys never contain a colon.
+func IsFingerprint(key string) bool {
+	return strings.Contains(key, ":")
+}
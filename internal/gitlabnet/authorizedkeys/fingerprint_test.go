@@ -0,0 +1,69 @@
+package authorizedkeys
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFingerprint(t *testing.T) {
+	testCases := []struct {
+		desc                string
+		fingerprint         string
+		expectedFormat      string
+		expectedFingerprint string
+	}{
+		{
+			desc:                "SHA256",
+			fingerprint:         "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8",
+			expectedFormat:      FingerprintSHA256,
+			expectedFingerprint: "nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8",
+		},
+		{
+			desc:                "MD5 with a prefix",
+			fingerprint:         "MD5:d4:1d:8c:d9:8f:00:b2:04:e9:80:09:98:ec:f8:42:7e",
+			expectedFormat:      FingerprintMD5,
+			expectedFingerprint: "d4:1d:8c:d9:8f:00:b2:04:e9:80:09:98:ec:f8:42:7e",
+		},
+		{
+			desc:                "uppercase MD5 without a prefix",
+			fingerprint:         "D4:1D:8C:D9:8F:00:B2:04:E9:80:09:98:EC:F8:42:7E",
+			expectedFormat:      FingerprintMD5,
+			expectedFingerprint: "d4:1d:8c:d9:8f:00:b2:04:e9:80:09:98:ec:f8:42:7e",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			format, fingerprint, err := ParseFingerprint(tc.fingerprint)
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedFormat, format)
+			require.Equal(t, tc.expectedFingerprint, fingerprint)
+		})
+	}
+}
+
+func TestParseInvalidFingerprint(t *testing.T) {
+	for _, fingerprint := range []string{
+		"",
+		"SHA256:",
+		"SHA256:not base64!",
+		"SHA256:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=",
+		"SHA256:AAAA",
+		"d4:1d:8c:d9:8f:00:b2:04:e9:80:09:98:ec:f8:42",
+		"d4:1d:8c:d9:8f:00:b2:04:e9:80:09:98:ec:f8:42:zz",
+		"d4:1d:8c:d9:8f:00:b2:04:e9:80:09:98:ec:f8:4:27e",
+	} {
+		t.Run(fingerprint, func(t *testing.T) {
+			_, _, err := ParseFingerprint(fingerprint)
+			require.ErrorIs(t, err, ErrInvalidFingerprint)
+		})
+	}
+}
+
+func TestIsFingerprint(t *testing.T) {
+	require.True(t, IsFingerprint("SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8"))
+	require.True(t, IsFingerprint("d4:1d:8c:d9:8f:00:b2:04:e9:80:09:98:ec:f8:42:7e"))
+	require.False(t, IsFingerprint("AAAAC3NzaC1lZDI1NTE5AAAAIFQ+wUnBTz6e1P0uGiXRhsS5F0yo9Gji0+8Ldbs+Nzfo"))
+}
@@ -0,0 +1,75 @@
+// Package authorizedprincipals resolves the group principals of certificates
+// with the internal API
+package authorizedprincipals
+
+import (
+	"context"
+	"fmt"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
+)
+
+// GroupsPath is the endpoint granting group principals
+const GroupsPath = "/authorized_principals/groups"
+
+// Client wraps a gitlab client and its associated config
+type Client struct {
+	config *config.Config
+	client *client.GitlabNetClient
+}
+
+// Request asks which of Principals, each "group:" followed by the full path
+// of a group, grant access to the user of KeyID
+type Request struct {
+	KeyID      string   `json:"key_id"`
+	Principals []string `json:"principals"`
+}
+
+// Response lists the principals granted, among those requested
+type Response struct {
+	Principals []string `json:"principals"`
+}
+
+// NewClient instantiates a Client with config
+func NewClient(config *config.Config) (*Client, error) {
+	client, err := gitlabnet.GetClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating http client: %v", err)
+	}
+
+	return &Client{config: config, client: client}, nil
+}
+
+// GrantedGroups returns those of principals that grant access to the user of
+// keyID, such as the groups the user is a member of, in a single request
+func (c *Client) GrantedGroups(ctx context.Context, keyID string, principals []string) ([]string, error) {
+	response, err := c.client.Post(ctx, GroupsPath, &Request{KeyID: keyID, Principals: principals})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	parsedResponse := &Response{}
+	if err := gitlabnet.ParseJSON(response, parsedResponse); err != nil {
+		return nil, err
+	}
+
+	// GitLab only grants principals it was asked about
+	requested := make(map[string]bool, len(principals))
+	for _, principal := range principals {
+		requested[principal] = true
+	}
+
+	var granted []string
+	for _, principal := range parsedResponse.Principals {
+		if requested[principal] {
+			granted = append(granted, principal)
+		}
+	}
+
+	return granted, nil
+}
@@ -0,0 +1,50 @@
+package authorizedprincipals
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func TestGrantedGroups(t *testing.T) {
+	url := testserver.StartSocketHttpServer(t, []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/authorized_principals/groups",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				request := &Request{}
+				require.NoError(t, json.NewDecoder(r.Body).Decode(request))
+
+				switch request.KeyID {
+				case "alex-doe":
+					// The first of the principals requested, and one that wasn't
+					json.NewEncoder(w).Encode(&Response{Principals: []string{request.Principals[0], "group:other"}})
+				case "blocked":
+					w.WriteHeader(http.StatusForbidden)
+					json.NewEncoder(w).Encode(&client.ErrorResponse{Message: "Not allowed!"})
+				default:
+					w.Write([]byte("{"))
+				}
+			},
+		},
+	})
+
+	c, err := NewClient(&config.Config{GitlabUrl: url})
+	require.NoError(t, err)
+
+	granted, err := c.GrantedGroups(context.Background(), "alex-doe", []string{"group:backend/api", "group:backend/web"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"group:backend/api"}, granted, "principals that weren't requested aren't granted")
+
+	_, err = c.GrantedGroups(context.Background(), "blocked", []string{"group:backend/api"})
+	require.EqualError(t, err, "Not allowed!")
+
+	_, err = c.GrantedGroups(context.Background(), "broken", []string{"group:backend/api"})
+	require.EqualError(t, err, "Parsing failed: /api/v4/internal/authorized_principals/groups: truncated JSON")
+}
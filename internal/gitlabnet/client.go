@@ -2,9 +2,12 @@ package gitlabnet
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"strings"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
 
@@ -25,17 +28,68 @@ func GetClient(config *config.Config) (*client.GitlabNetClient, error) {
 		return nil, fmt.Errorf("Unsupported protocol")
 	}
 
-	return client.NewGitlabNetClient(config.HttpSettings.User, config.HttpSettings.Password, config.Secret, httpClient)
+	secrets := config.AcceptedSecrets()
+
+	gitlabnetClient, err := client.NewGitlabNetClient(config.HttpSettings.User, config.HttpSettings.Password, secrets[0], httpClient)
+	if err != nil {
+		return nil, err
+	}
+	gitlabnetClient.SetPreviousSecrets(secrets[1:])
+
+	if mirror := config.APIMirror(); mirror != nil {
+		gitlabnetClient.Use(mirror)
+	}
+
+	return gitlabnetClient, nil
 }
 
+// ParseJSON decodes the JSON body of hr into response. It fails with a
+// ParsingError describing how the body doesn't match response, e.g. a field
+// of another type, so that mismatched versions of gitlab-shell and GitLab can
+// be told apart from a broken GitLab. Fields unknown to response are errors
+// for the clients created with client.WithStrictResponses.
 func ParseJSON(hr *http.Response, response interface{}) error {
-	if err := json.NewDecoder(hr.Body).Decode(response); err != nil {
-		return ParsingError
+	decoder := json.NewDecoder(hr.Body)
+	if client.StrictResponses(hr) {
+		decoder.DisallowUnknownFields()
+	}
+
+	if err := decoder.Decode(response); err != nil {
+		return fmt.Errorf("%w: %s", ParsingError, describeJSONError(hr, err))
 	}
 
 	return nil
 }
 
+// describeJSONError explains the error decoding the body of hr
+func describeJSONError(hr *http.Response, err error) string {
+	var description string
+
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		description = fmt.Sprintf("field %q is a JSON %s, expected %s", typeErr.Field, typeErr.Value, typeErr.Type)
+	case errors.As(err, &typeErr):
+		description = fmt.Sprintf("the response is a JSON %s, expected %s", typeErr.Value, typeErr.Type)
+	case errors.As(err, &syntaxErr):
+		description = fmt.Sprintf("invalid JSON at offset %d", syntaxErr.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		description = "truncated JSON"
+	case errors.Is(err, io.EOF):
+		description = "empty response"
+	default:
+		// Such as an unknown field with client.WithStrictResponses
+		description = strings.TrimPrefix(err.Error(), "json: ")
+	}
+
+	if hr.Request != nil && hr.Request.URL != nil {
+		return hr.Request.URL.Path + ": " + description
+	}
+
+	return description
+}
+
 func ParseIP(remoteAddr string) string {
 	// The remoteAddr field can be filled by:
 	// 1. An IP address via the SSH_CONNECTION environment variable
@@ -0,0 +1,101 @@
+package gitlabnet
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+)
+
+func TestParseJSON(t *testing.T) {
+	type response struct {
+		Status bool   `json:"status"`
+		GLID   string `json:"gl_id"`
+	}
+
+	testCases := []struct {
+		desc          string
+		body          string
+		strict        bool
+		expectedError string
+	}{
+		{
+			desc: "a valid response",
+			body: `{"status": true, "gl_id": "user-1"}`,
+		},
+		{
+			desc: "a response with an unknown field",
+			body: `{"status": true, "gl_id": "user-1", "gl_new": 1}`,
+		},
+		{
+			desc:          "a response with an unknown field, strictly",
+			body:          `{"status": true, "gl_id": "user-1", "gl_new": 1}`,
+			strict:        true,
+			expectedError: `Parsing failed: /api/v4/internal/allowed: unknown field "gl_new"`,
+		},
+		{
+			desc:          "a field of another type",
+			body:          `{"status": "yes"}`,
+			expectedError: `Parsing failed: /api/v4/internal/allowed: field "status" is a JSON string, expected bool`,
+		},
+		{
+			desc:          "a response of another type",
+			body:          `[]`,
+			expectedError: `Parsing failed: /api/v4/internal/allowed: the response is a JSON array, expected gitlabnet.response`,
+		},
+		{
+			desc:          "invalid JSON",
+			body:          `{"status": tru}`,
+			expectedError: `Parsing failed: /api/v4/internal/allowed: invalid JSON at offset 15`,
+		},
+		{
+			desc:          "an empty response",
+			expectedError: `Parsing failed: /api/v4/internal/allowed: empty response`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				io.WriteString(w, tc.body)
+			}))
+			t.Cleanup(server.Close)
+
+			var opts []client.HTTPClientOpt
+			if tc.strict {
+				opts = append(opts, client.WithStrictResponses())
+			}
+
+			httpClient, err := client.NewHTTPClientWithOpts(server.URL, "", "", "", 1, opts)
+			require.NoError(t, err)
+
+			c, err := client.NewGitlabNetClient("", "", "", httpClient)
+			require.NoError(t, err)
+
+			hr, err := c.Get(context.Background(), "/allowed")
+			require.NoError(t, err)
+			defer hr.Body.Close()
+
+			err = ParseJSON(hr, &response{})
+			if tc.expectedError == "" {
+				require.NoError(t, err)
+				return
+			}
+
+			require.EqualError(t, err, tc.expectedError)
+			require.ErrorIs(t, err, ParsingError)
+		})
+	}
+}
+
+func TestParseJSONWithoutRequest(t *testing.T) {
+	hr := &http.Response{Body: io.NopCloser(strings.NewReader(`{`))}
+
+	require.EqualError(t, ParseJSON(hr, &struct{}{}), "Parsing failed: truncated JSON")
+}
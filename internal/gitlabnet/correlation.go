@@ -0,0 +1,22 @@
+package gitlabnet
+
+import (
+	"context"
+
+	"gitlab.com/gitlab-org/labkit/correlation"
+)
+
+// ContextWithCorrelationID returns ctx with a new correlation ID. Every
+// gitlab-shell invocation and gitlab-sshd connection gets one before anything
+// else happens, so that all of its log lines carry it, along with its
+// requests to the internal API, as X-Request-Id, and its Gitaly RPCs, in
+// their metadata.
+func ContextWithCorrelationID(ctx context.Context) context.Context {
+	return correlation.ContextWithCorrelation(ctx, correlation.SafeRandomID())
+}
+
+// CorrelationID returns the correlation ID of ctx, for commands to reuse
+// e.g. in the messages shown to users
+func CorrelationID(ctx context.Context) string {
+	return correlation.ExtractFromContext(ctx)
+}
@@ -0,0 +1,44 @@
+package gitlabnet
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/labkit/correlation"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func TestCorrelationIDPropagation(t *testing.T) {
+	requestIDs := make(chan string, 1)
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/check",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				requestIDs <- r.Header.Get("X-Request-Id")
+			},
+		},
+	}
+
+	url := testserver.StartSocketHttpServer(t, requests)
+	client, err := GetClient(&config.Config{GitlabUrl: url})
+	require.NoError(t, err)
+
+	ctx := ContextWithCorrelationID(context.Background())
+	require.NotEmpty(t, CorrelationID(ctx))
+	require.NotEqual(t, CorrelationID(ctx), CorrelationID(ContextWithCorrelationID(ctx)), "a new ID is generated")
+
+	response, err := client.Get(ctx, "/check")
+	require.NoError(t, err)
+	response.Body.Close()
+
+	require.Equal(t, CorrelationID(ctx), <-requestIDs)
+}
+
+func TestCorrelationIDWithoutID(t *testing.T) {
+	require.Empty(t, CorrelationID(context.Background()))
+	require.Equal(t, "abc", CorrelationID(correlation.ContextWithCorrelation(context.Background(), "abc")))
+}
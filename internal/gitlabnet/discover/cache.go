@@ -0,0 +1,88 @@
+package discover
+
+import (
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+// Results of a cache lookup, as reported by metrics
+const (
+	cacheHit         = "hit"
+	cacheNegativeHit = "negative_hit"
+	cacheMiss        = "miss"
+)
+
+// maxCacheEntries bounds the size of the cache. Once it's reached, responses
+// aren't cached until entries expire.
+const maxCacheEntries = 10000
+
+// responseCache caches the responses of the internal API in the process, so
+// that the connections of a key within a short window share a single call.
+// It is shared by all the clients of the process: gitlab-sshd creates them
+// per command.
+type responseCache struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	response *Response
+	expires  time.Time
+}
+
+var cache = &responseCache{now: time.Now, entries: make(map[string]cacheEntry)}
+
+// get returns a copy of the cached response for key, if it hasn't expired
+func (c *responseCache) get(key string) (*Response, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || !c.now().Before(entry.expires) {
+		metrics.DiscoverCacheLookupsTotal.WithLabelValues(cacheMiss).Inc()
+		return nil, false
+	}
+
+	if entry.response.IsAnonymous() {
+		metrics.DiscoverCacheLookupsTotal.WithLabelValues(cacheNegativeHit).Inc()
+	} else {
+		metrics.DiscoverCacheLookupsTotal.WithLabelValues(cacheHit).Inc()
+	}
+
+	response := *entry.response
+
+	return &response, true
+}
+
+// set caches a copy of response for key during ttl
+func (c *responseCache) set(key string, response *Response, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if len(c.entries) >= maxCacheEntries {
+		c.sweep(now)
+	}
+	if len(c.entries) >= maxCacheEntries {
+		return
+	}
+
+	cached := *response
+	c.entries[key] = cacheEntry{response: &cached, expires: now.Add(ttl)}
+}
+
+// sweep removes the expired entries
+func (c *responseCache) sweep(now time.Time) {
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
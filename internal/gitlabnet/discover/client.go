@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"time"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
@@ -24,6 +25,9 @@ type Response struct {
 	UserID   int64  `json:"id"`
 	Name     string `json:"name"`
 	Username string `json:"username"`
+	// PreferredLanguage is the language the user selected on GitLab, such as
+	// "pt_BR"
+	PreferredLanguage string `json:"preferred_language"`
 }
 
 // NewClient creates a new instance of the user discovery client
@@ -58,13 +62,34 @@ func (c *Client) GetByCommandArgs(ctx context.Context, args *commandargs.Shell)
 func (c *Client) getResponse(ctx context.Context, params url.Values) (*Response, error) {
 	path := "/discover?" + params.Encode()
 
+	cacheConfig := c.config.DiscoverCache
+	cacheKey := c.config.GitlabUrl + path
+	if cacheConfig.TTL > 0 {
+		if response, ok := cache.get(cacheKey); ok {
+			return response, nil
+		}
+	}
+
 	response, err := c.client.Get(ctx, path)
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = response.Body.Close() }()
 
-	return parse(response)
+	parsed, err := parse(response)
+	if err != nil {
+		return nil, err
+	}
+
+	// Anonymous responses, for unknown keys and users, are cached for
+	// shorter so that new ones are soon recognized
+	ttl := cacheConfig.TTL
+	if parsed.IsAnonymous() {
+		ttl = min(cacheConfig.NegativeTTL, ttl)
+	}
+	cache.set(cacheKey, parsed, time.Duration(ttl))
+
+	return parsed, nil
 }
 
 func parse(hr *http.Response) (*Response, error) {
@@ -7,12 +7,16 @@ import (
 	"net/http"
 	"net/url"
 	"testing"
+	"time"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
 )
 
 var (
@@ -120,7 +124,7 @@ func TestErrorResponses(t *testing.T) {
 		{
 			desc:          "A response with bad JSON",
 			fakeUsername:  "broken_json",
-			expectedError: "Parsing failed",
+			expectedError: "Parsing failed: /api/v4/internal/discover: truncated JSON",
 		},
 		{
 			desc:          "An error response without message",
@@ -141,6 +145,108 @@ func TestErrorResponses(t *testing.T) {
 	}
 }
 
+func TestCache(t *testing.T) {
+	metrics.DiscoverCacheLookupsTotal.Reset()
+
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	t.Cleanup(func() { cache.now = time.Now })
+
+	calls := 0
+	url := testserver.StartSocketHttpServer(t, []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/discover",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				calls++
+
+				switch r.URL.Query().Get("key_id") {
+				case "1":
+					json.NewEncoder(w).Encode(&Response{UserID: 2, Username: "alex-doe", Name: "Alex Doe"})
+				case "broken":
+					w.WriteHeader(http.StatusForbidden)
+				default:
+					fmt.Fprint(w, "null")
+				}
+			},
+		},
+	})
+
+	client, err := NewClient(&config.Config{
+		GitlabUrl:     url,
+		DiscoverCache: config.DiscoverCacheConfig{TTL: config.YamlDuration(time.Minute), NegativeTTL: config.YamlDuration(10 * time.Second)},
+	})
+	require.NoError(t, err)
+
+	get := func(keyID string) (*Response, error) {
+		return client.GetByCommandArgs(context.Background(), &commandargs.Shell{GitlabKeyId: keyID})
+	}
+
+	for i := 0; i < 2; i++ {
+		result, err := get("1")
+		require.NoError(t, err)
+		require.Equal(t, &Response{UserID: 2, Username: "alex-doe", Name: "Alex Doe"}, result)
+
+		result, err = get("2")
+		require.NoError(t, err)
+		require.True(t, result.IsAnonymous())
+	}
+	require.Equal(t, 2, calls)
+
+	// Cached responses can't be altered through the copies returned
+	result, err := get("1")
+	require.NoError(t, err)
+	result.Username = "altered"
+	result, err = get("1")
+	require.NoError(t, err)
+	require.Equal(t, "alex-doe", result.Username)
+
+	// Unknown keys expire first
+	now = now.Add(30 * time.Second)
+	_, err = get("1")
+	require.NoError(t, err)
+	_, err = get("2")
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+
+	now = now.Add(time.Minute)
+	_, err = get("1")
+	require.NoError(t, err)
+	require.Equal(t, 4, calls)
+
+	// Errors aren't cached
+	for i := 0; i < 2; i++ {
+		_, err = get("broken")
+		require.Error(t, err)
+	}
+	require.Equal(t, 6, calls)
+
+	require.InDelta(t, 4, testutil.ToFloat64(metrics.DiscoverCacheLookupsTotal.WithLabelValues("hit")), 0.1)
+	require.InDelta(t, 1, testutil.ToFloat64(metrics.DiscoverCacheLookupsTotal.WithLabelValues("negative_hit")), 0.1)
+	require.InDelta(t, 6, testutil.ToFloat64(metrics.DiscoverCacheLookupsTotal.WithLabelValues("miss")), 0.1)
+}
+
+func TestCacheDisabled(t *testing.T) {
+	calls := 0
+	url := testserver.StartSocketHttpServer(t, []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/discover",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				calls++
+				json.NewEncoder(w).Encode(&Response{UserID: 2, Username: "alex-doe"})
+			},
+		},
+	})
+
+	client, err := NewClient(&config.Config{GitlabUrl: url})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err := client.GetByCommandArgs(context.Background(), &commandargs.Shell{GitlabKeyId: "1"})
+		require.NoError(t, err)
+	}
+	require.Equal(t, 2, calls)
+}
+
 func setup(t *testing.T) *Client {
 	url := testserver.StartSocketHttpServer(t, requests)
 
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"time"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
@@ -27,6 +28,9 @@ type Response struct {
 	GitlabVersion  string `json:"gitlab_version"`
 	GitlabRevision string `json:"gitlab_rev"`
 	Redis          bool   `json:"redis"`
+	// Date is the time of the response according to GitLab, zero if it
+	// didn't send a Date header
+	Date time.Time `json:"-"`
 }
 
 // NewClient initializes a client's struct
@@ -59,5 +63,7 @@ func parse(hr *http.Response) (*Response, error) {
 		return nil, err
 	}
 
+	response.Date, _ = http.ParseTime(hr.Header.Get("Date"))
+
 	return response, nil
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"testing"
+	"time"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
@@ -35,6 +36,9 @@ func TestCheck(t *testing.T) {
 
 	result, err := client.Check(context.Background())
 	require.NoError(t, err)
+	require.WithinDuration(t, time.Now(), result.Date, time.Minute)
+
+	result.Date = time.Time{}
 	require.Equal(t, testResponse, result)
 }
 
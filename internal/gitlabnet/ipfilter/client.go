@@ -0,0 +1,164 @@
+// Package ipfilter implements a HTTP client to request the CIDRs that
+// gitlab-sshd accepts or refuses connections from, and matches IPs against
+// them
+package ipfilter
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+const (
+	ipFilterPath = "/ip_filter"
+	fetchTimeout = 10 * time.Second
+)
+
+// Client defines configuration for ipfilter client
+type Client struct {
+	config *config.Config
+	client *client.GitlabNetClient
+}
+
+// Response contains the CIDRs set by admins
+type Response struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// Lists are parsed CIDRs to accept and refuse connections from
+type Lists struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+// cache holds the lists last fetched in the process, so that they outlive
+// the clients, which are rebuilt on reload
+var cache struct {
+	mu        sync.Mutex
+	lists     *Lists
+	fetchedAt time.Time
+	fetching  bool
+}
+
+// NewClient initializes a client's struct
+func NewClient(config *config.Config) (*Client, error) {
+	client, err := gitlabnet.GetClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating http client: %v", err)
+	}
+
+	return &Client{config: config, client: client}, nil
+}
+
+// Get fetches the lists
+func (c *Client) Get(ctx context.Context) (*Lists, error) {
+	resp, err := c.client.Get(ctx, ipFilterPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	response := &Response{}
+	if err := gitlabnet.ParseJSON(resp, response); err != nil {
+		return nil, err
+	}
+
+	allow, err := ParsePrefixes(response.Allow)
+	if err != nil {
+		return nil, err
+	}
+
+	deny, err := ParsePrefixes(response.Deny)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Lists{Allow: allow, Deny: deny}, nil
+}
+
+// Cached returns the lists last fetched without waiting for the internal API:
+// they are fetched again in the background once older than interval. It
+// returns nil until they have been fetched once, and the lists fetched last
+// while the internal API fails.
+func (c *Client) Cached(interval time.Duration) *Lists {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if !cache.fetching && (cache.lists == nil || time.Since(cache.fetchedAt) >= interval) {
+		cache.fetching = true
+		go c.refresh()
+	}
+
+	return cache.lists
+}
+
+func (c *Client) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+
+	lists, err := c.Get(ctx)
+	if err != nil {
+		log.WithError(err).Warn("ipfilter: failed to fetch the IP filter, keeping the current one")
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.fetching = false
+	if err == nil {
+		cache.lists = lists
+		cache.fetchedAt = time.Now()
+	}
+}
+
+// ParsePrefixes parses CIDRs, such as 192.0.2.0/24, and IPs, which stand for
+// a CIDR of their own
+func ParsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid IP filter entry %q: %w", cidr, err)
+			}
+
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP filter entry %q: %w", cidr, err)
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// Contains reports whether ip is in one of prefixes
+func Contains(prefixes []netip.Prefix, ip netip.Addr) bool {
+	ip = ip.Unmap()
+
+	for _, prefix := range prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,86 @@
+package ipfilter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func TestGet(t *testing.T) {
+	client := setup(t, &Response{Allow: []string{"192.0.2.0/24"}, Deny: []string{"192.0.2.1"}})
+
+	lists, err := client.Get(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, &Lists{
+		Allow: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")},
+		Deny:  []netip.Prefix{netip.MustParsePrefix("192.0.2.1/32")},
+	}, lists)
+}
+
+func TestGetInvalidEntry(t *testing.T) {
+	client := setup(t, &Response{Deny: []string{"not an IP"}})
+
+	_, err := client.Get(context.Background())
+	require.ErrorContains(t, err, `invalid IP filter entry "not an IP"`)
+}
+
+func TestCached(t *testing.T) {
+	cache.mu.Lock()
+	cache.lists = nil
+	cache.mu.Unlock()
+
+	client := setup(t, &Response{Deny: []string{"192.0.2.1"}})
+
+	require.Eventually(t, func() bool {
+		lists := client.Cached(time.Hour)
+		return lists != nil && len(lists.Deny) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestParsePrefixes(t *testing.T) {
+	prefixes, err := ParsePrefixes([]string{"192.0.2.10/24", " 2001:db8::1 ", "::ffff:198.51.100.1"})
+	require.NoError(t, err)
+	require.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("192.0.2.0/24"),
+		netip.MustParsePrefix("2001:db8::1/128"),
+		netip.MustParsePrefix("198.51.100.1/32"),
+	}, prefixes)
+
+	_, err = ParsePrefixes([]string{"192.0.2.0/33"})
+	require.Error(t, err)
+}
+
+func TestContains(t *testing.T) {
+	prefixes := []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+
+	require.True(t, Contains(prefixes, netip.MustParseAddr("192.0.2.1")))
+	require.True(t, Contains(prefixes, netip.MustParseAddr("::ffff:192.0.2.1")))
+	require.False(t, Contains(prefixes, netip.MustParseAddr("198.51.100.1")))
+	require.False(t, Contains(nil, netip.MustParseAddr("192.0.2.1")))
+}
+
+func setup(t *testing.T, response *Response) *Client {
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/ip_filter",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				require.NoError(t, json.NewEncoder(w).Encode(response))
+			},
+		},
+	}
+
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	client, err := NewClient(&config.Config{GitlabUrl: url})
+	require.NoError(t, err)
+
+	return client
+}
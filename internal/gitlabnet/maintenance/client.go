@@ -0,0 +1,105 @@
+// Package maintenance implements a HTTP client to request whether GitLab is
+// in maintenance, during which gitlab-sshd only allows fetches
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+const (
+	maintenancePath = "/maintenance_mode"
+	fetchTimeout    = 10 * time.Second
+)
+
+// Client defines configuration for maintenance client
+type Client struct {
+	config *config.Config
+	client *client.GitlabNetClient
+}
+
+// Response tells whether GitLab is in maintenance. Message, a template like
+// the one of the maintenance section of the config, replaces the configured
+// one when set.
+type Response struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message"`
+}
+
+// cache holds the response last fetched in the process, so that it outlives
+// the clients, which are rebuilt on reload
+var cache struct {
+	mu        sync.Mutex
+	response  *Response
+	fetchedAt time.Time
+	fetching  bool
+}
+
+// NewClient initializes a client's struct
+func NewClient(config *config.Config) (*Client, error) {
+	client, err := gitlabnet.GetClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating http client: %v", err)
+	}
+
+	return &Client{config: config, client: client}, nil
+}
+
+// Get fetches whether GitLab is in maintenance
+func (c *Client) Get(ctx context.Context) (*Response, error) {
+	resp, err := c.client.Get(ctx, maintenancePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	response := &Response{}
+	if err := gitlabnet.ParseJSON(resp, response); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// Cached returns the response last fetched without waiting for the internal
+// API: it's fetched again in the background once older than interval. It
+// returns nil until it has been fetched once, and the response fetched last
+// while the internal API fails.
+func (c *Client) Cached(interval time.Duration) *Response {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if !cache.fetching && (cache.response == nil || time.Since(cache.fetchedAt) >= interval) {
+		cache.fetching = true
+		go c.refresh()
+	}
+
+	return cache.response
+}
+
+func (c *Client) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+
+	response, err := c.Get(ctx)
+	if err != nil {
+		log.WithError(err).Warn("maintenance: failed to fetch the maintenance mode, keeping the current one")
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.fetching = false
+	if err == nil {
+		cache.response = response
+		cache.fetchedAt = time.Now()
+	}
+}
@@ -0,0 +1,53 @@
+package maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func TestGet(t *testing.T) {
+	client := setup(t, &Response{Enabled: true, Message: "Upgrading to 17.0"})
+
+	response, err := client.Get(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, &Response{Enabled: true, Message: "Upgrading to 17.0"}, response)
+}
+
+func TestCached(t *testing.T) {
+	cache.mu.Lock()
+	cache.response = nil
+	cache.mu.Unlock()
+
+	client := setup(t, &Response{Enabled: true})
+
+	require.Eventually(t, func() bool {
+		response := client.Cached(time.Hour)
+		return response != nil && response.Enabled
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func setup(t *testing.T, response *Response) *Client {
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/maintenance_mode",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				require.NoError(t, json.NewEncoder(w).Encode(response))
+			},
+		},
+	}
+
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	client, err := NewClient(&config.Config{GitlabUrl: url})
+	require.NoError(t, err)
+
+	return client
+}
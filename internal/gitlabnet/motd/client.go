@@ -0,0 +1,104 @@
+// Package motd implements a HTTP client to request the messages shown to
+// users from the internal API
+package motd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
+)
+
+const motdPath = "/motd"
+
+// Client defines configuration for motd client
+type Client struct {
+	config *config.Config
+	client *client.GitlabNetClient
+}
+
+// Response contains the messages set by admins. Both are templates, like the
+// ones of the motd section of the config.
+type Response struct {
+	Banner  string `json:"banner"`
+	Message string `json:"message"`
+}
+
+// cache holds the last response in the process: gitlab-sshd shows the banner
+// before authentication, which mustn't cost an API call each time
+var cache struct {
+	mu       sync.Mutex
+	response *Response
+	expires  time.Time
+}
+
+// NewClient initializes a client's struct
+func NewClient(config *config.Config) (*Client, error) {
+	client, err := gitlabnet.GetClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating http client: %v", err)
+	}
+
+	return &Client{config: config, client: client}, nil
+}
+
+// Get returns the messages, from the cache if they were fetched within the
+// cache TTL. An instance without messages responds with 404 Not Found.
+func (c *Client) Get(ctx context.Context) (*Response, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.response != nil && time.Now().Before(cache.expires) {
+		response := *cache.response
+		return &response, nil
+	}
+
+	response, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.response = response
+	cache.expires = time.Now().Add(time.Duration(c.config.MOTD.CacheTTL))
+
+	copied := *response
+
+	return &copied, nil
+}
+
+func (c *Client) get(ctx context.Context) (*Response, error) {
+	resp, err := c.client.Get(ctx, motdPath)
+	if err != nil {
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && (apiErr.Msg == notFoundMessage || apiErr.Msg == notFoundError) {
+			return &Response{}, nil
+		}
+
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	return parse(resp)
+}
+
+// The errors of 404 Not Found responses, with the message of the internal
+// API or without any
+const (
+	notFoundMessage = "404 Not Found"
+	notFoundError   = "Internal API error (404)"
+)
+
+func parse(hr *http.Response) (*Response, error) {
+	response := &Response{}
+	if err := gitlabnet.ParseJSON(hr, response); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
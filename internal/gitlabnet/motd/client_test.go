@@ -0,0 +1,114 @@
+package motd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func resetCache(t *testing.T) {
+	t.Helper()
+
+	cache.response = nil
+	t.Cleanup(func() { cache.response = nil })
+}
+
+func TestGet(t *testing.T) {
+	resetCache(t)
+
+	calls := 0
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/motd",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				calls++
+				json.NewEncoder(w).Encode(&Response{Banner: "Maintenance on Sunday", Message: "Hello {{.Username}}"})
+			},
+		},
+	}
+
+	client := setup(t, requests, time.Hour)
+
+	for i := 0; i < 2; i++ {
+		response, err := client.Get(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, &Response{Banner: "Maintenance on Sunday", Message: "Hello {{.Username}}"}, response)
+	}
+
+	require.Equal(t, 1, calls, "the messages should be cached")
+}
+
+func TestGetWithoutCache(t *testing.T) {
+	resetCache(t)
+
+	calls := 0
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/motd",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				calls++
+				json.NewEncoder(w).Encode(&Response{})
+			},
+		},
+	}
+
+	client := setup(t, requests, 0)
+
+	for i := 0; i < 2; i++ {
+		_, err := client.Get(context.Background())
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 2, calls)
+}
+
+func TestGetWithoutMessages(t *testing.T) {
+	resetCache(t)
+
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/motd",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"message": "404 Not Found"})
+			},
+		},
+	}
+
+	response, err := setup(t, requests, 0).Get(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, &Response{}, response)
+}
+
+func TestGetFailure(t *testing.T) {
+	resetCache(t)
+
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/motd",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+			},
+		},
+	}
+
+	_, err := setup(t, requests, time.Hour).Get(context.Background())
+	require.EqualError(t, err, "Internal API error (403)")
+	require.Nil(t, cache.response, "failures shouldn't be cached")
+}
+
+func setup(t *testing.T, requests []testserver.TestRequestHandler, ttl time.Duration) *Client {
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	client, err := NewClient(&config.Config{GitlabUrl: url, MOTD: config.MOTDConfig{CacheTTL: config.YamlDuration(ttl)}})
+	require.NoError(t, err)
+
+	return client
+}
@@ -14,6 +14,10 @@ import (
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/discover"
 )
 
+// ErrPolicyUnsupported is returned by GetPolicy when the GitLab instance has
+// no policy endpoint, as before it was introduced
+var ErrPolicyUnsupported = errors.New("personal access token policy not supported")
+
 // Client represents a client for managing personal access tokens
 type Client struct {
 	config *config.Config
@@ -38,6 +42,23 @@ type RequestBody struct {
 	ExpiresAt string   `json:"expires_at,omitempty"`
 }
 
+// PolicyRequestBody represents the request body for fetching the personal
+// access token policy of a user
+type PolicyRequestBody struct {
+	KeyID  string `json:"key_id,omitempty"`
+	UserID int64  `json:"user_id,omitempty"`
+}
+
+// PolicyResponse represents the scopes a user may request and the longest
+// lifetime the instance allows for a personal access token. A MaxLifetimeDays
+// of zero means the lifetime isn't limited.
+type PolicyResponse struct {
+	Success         bool     `json:"success"`
+	Scopes          []string `json:"scopes"`
+	MaxLifetimeDays int      `json:"max_lifetime_days"`
+	Message         string   `json:"message"`
+}
+
 // NewClient creates a new instance of Client
 func NewClient(config *config.Config) (*Client, error) {
 	client, err := gitlabnet.GetClient(config)
@@ -64,6 +85,43 @@ func (c *Client) GetPersonalAccessToken(ctx context.Context, args *commandargs.S
 	return parse(response)
 }
 
+// GetPolicy retrieves the scopes and the maximum lifetime the user may request
+// for a personal access token
+func (c *Client) GetPolicy(ctx context.Context, args *commandargs.Shell) (*PolicyResponse, error) {
+	requestBody := &PolicyRequestBody{}
+	if args.GitlabKeyId != "" {
+		requestBody.KeyID = args.GitlabKeyId
+	} else {
+		userID, err := c.getUserID(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		requestBody.UserID = userID
+	}
+
+	response, err := c.client.Post(ctx, "/personal_access_token/policy", requestBody)
+	if err != nil {
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			return nil, ErrPolicyUnsupported
+		}
+
+		return nil, err
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	policy := &PolicyResponse{}
+	if err := gitlabnet.ParseJSON(response, policy); err != nil {
+		return nil, err
+	}
+
+	if !policy.Success {
+		return nil, errors.New(policy.Message)
+	}
+
+	return policy, nil
+}
+
 func parse(hr *http.Response) (*Response, error) {
 	response := &Response{}
 	if err := gitlabnet.ParseJSON(hr, response); err != nil {
@@ -78,11 +136,6 @@ func parse(hr *http.Response) (*Response, error) {
 }
 
 func (c *Client) getRequestBody(ctx context.Context, args *commandargs.Shell, name string, scopes *[]string, expiresAt string) (*RequestBody, error) {
-	client, err := discover.NewClient(c.config)
-	if err != nil {
-		return nil, err
-	}
-
 	requestBody := &RequestBody{Name: name, Scopes: *scopes, ExpiresAt: expiresAt}
 	if args.GitlabKeyId != "" {
 		requestBody.KeyID = args.GitlabKeyId
@@ -90,11 +143,25 @@ func (c *Client) getRequestBody(ctx context.Context, args *commandargs.Shell, na
 		return requestBody, nil
 	}
 
-	userInfo, err := client.GetByCommandArgs(ctx, args)
+	userID, err := c.getUserID(ctx, args)
 	if err != nil {
 		return nil, err
 	}
-	requestBody.UserID = userInfo.UserID
+	requestBody.UserID = userID
 
 	return requestBody, nil
 }
+
+func (c *Client) getUserID(ctx context.Context, args *commandargs.Shell) (int64, error) {
+	client, err := discover.NewClient(c.config)
+	if err != nil {
+		return 0, err
+	}
+
+	userInfo, err := client.GetByCommandArgs(ctx, args)
+	if err != nil {
+		return 0, err
+	}
+
+	return userInfo.UserID, nil
+}
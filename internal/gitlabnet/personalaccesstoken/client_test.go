@@ -70,6 +70,35 @@ func initialize(t *testing.T) {
 				}
 			},
 		},
+		{
+			Path: "/api/v4/internal/personal_access_token/policy",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				var requestBody *PolicyRequestBody
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&requestBody))
+
+				switch {
+				case requestBody.KeyID == "0" || requestBody.UserID == 1:
+					body := map[string]interface{}{
+						"success":           true,
+						"scopes":            []string{"api", "read_api"},
+						"max_lifetime_days": 365,
+					}
+					json.NewEncoder(w).Encode(body)
+				case requestBody.KeyID == "1":
+					body := map[string]interface{}{
+						"success": false,
+						"message": "missing user",
+					}
+					json.NewEncoder(w).Encode(body)
+				case requestBody.KeyID == "3":
+					w.Write([]byte("{ \"message\": \"broken json!\""))
+				case requestBody.KeyID == "5":
+					w.WriteHeader(http.StatusNotFound)
+				default:
+					w.WriteHeader(http.StatusForbidden)
+				}
+			},
+		},
 		{
 			Path: "/api/v4/internal/discover",
 			Handler: func(w http.ResponseWriter, _ *http.Request) {
@@ -140,7 +169,7 @@ func TestErrorResponses(t *testing.T) {
 		{
 			desc:          "A response with bad JSON",
 			fakeID:        "3",
-			expectedError: "Parsing failed",
+			expectedError: "Parsing failed: /api/v4/internal/personal_access_token: truncated JSON",
 		},
 		{
 			desc:          "An error response without message",
@@ -162,6 +191,63 @@ func TestErrorResponses(t *testing.T) {
 	}
 }
 
+func TestGetPolicy(t *testing.T) {
+	client := setup(t)
+
+	testCases := []struct {
+		desc           string
+		args           *commandargs.Shell
+		expectedPolicy *PolicyResponse
+		expectedError  string
+	}{
+		{
+			desc:           "By key ID",
+			args:           &commandargs.Shell{GitlabKeyId: "0"},
+			expectedPolicy: &PolicyResponse{Success: true, Scopes: []string{"api", "read_api"}, MaxLifetimeDays: 365},
+		},
+		{
+			desc:           "By username",
+			args:           &commandargs.Shell{GitlabUsername: "jane-doe"},
+			expectedPolicy: &PolicyResponse{Success: true, Scopes: []string{"api", "read_api"}, MaxLifetimeDays: 365},
+		},
+		{
+			desc:          "A response with an error message",
+			args:          &commandargs.Shell{GitlabKeyId: "1"},
+			expectedError: "missing user",
+		},
+		{
+			desc:          "A response with bad JSON",
+			args:          &commandargs.Shell{GitlabKeyId: "3"},
+			expectedError: "Parsing failed: /api/v4/internal/personal_access_token/policy: truncated JSON",
+		},
+		{
+			desc:          "An error response without message",
+			args:          &commandargs.Shell{GitlabKeyId: "4"},
+			expectedError: "Internal API error (403)",
+		},
+		{
+			desc:          "An instance without the policy endpoint",
+			args:          &commandargs.Shell{GitlabKeyId: "5"},
+			expectedError: ErrPolicyUnsupported.Error(),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			policy, err := client.GetPolicy(context.Background(), tc.args)
+
+			if tc.expectedError != "" {
+				require.EqualError(t, err, tc.expectedError)
+				require.Nil(t, policy)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedPolicy, policy)
+		})
+	}
+}
+
 func setup(t *testing.T) *Client {
 	initialize(t)
 	url := testserver.StartSocketHttpServer(t, requests)
@@ -0,0 +1,53 @@
+// Package transferusage reports to GitLab the bytes users transferred, which
+// their transfer quotas are enforced with
+package transferusage
+
+import (
+	"context"
+	"fmt"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
+)
+
+const reportPath = "/transfer_usage"
+
+// Client reports the transfer usage of users
+type Client struct {
+	client *client.GitlabNetClient
+}
+
+// Request is the usage of a Git command, counted by GitLab against the
+// quota of the user
+type Request struct {
+	UserID     string `json:"gl_id"`
+	Username   string `json:"gl_username,omitempty"`
+	Repository string `json:"gl_repository"`
+	ProjectID  int    `json:"gl_project_id,omitempty"`
+	Command    string `json:"command"`
+	BytesIn    int64  `json:"bytes_in"`
+	BytesOut   int64  `json:"bytes_out"`
+	// Trimmed tells whether the command was stopped at the quota
+	Trimmed bool `json:"trimmed"`
+}
+
+// NewClient creates a new instance of Client
+func NewClient(config *config.Config) (*Client, error) {
+	client, err := gitlabnet.GetClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating http client: %v", err)
+	}
+
+	return &Client{client: client}, nil
+}
+
+// Report reports the usage of request
+func (c *Client) Report(ctx context.Context, request *Request) error {
+	response, err := c.client.Post(ctx, reportPath, request)
+	if err != nil {
+		return err
+	}
+
+	return response.Body.Close()
+}
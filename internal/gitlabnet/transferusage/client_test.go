@@ -0,0 +1,50 @@
+package transferusage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func TestReport(t *testing.T) {
+	var received Request
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/transfer_usage",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodPost, r.Method)
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+
+				if received.UserID == "user-2" {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+
+				w.WriteHeader(http.StatusNoContent)
+			},
+		},
+	}
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	client, err := NewClient(&config.Config{GitlabUrl: url})
+	require.NoError(t, err)
+
+	request := &Request{
+		UserID:     "user-1",
+		Repository: "project-1",
+		Command:    "git-upload-pack",
+		BytesIn:    10,
+		BytesOut:   2048,
+		Trimmed:    true,
+	}
+	require.NoError(t, client.Report(context.Background(), request))
+	require.Equal(t, *request, received)
+
+	require.Error(t, client.Report(context.Background(), &Request{UserID: "user-2"}))
+}
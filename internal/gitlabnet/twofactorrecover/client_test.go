@@ -122,7 +122,7 @@ func TestErrorResponses(t *testing.T) {
 		{
 			desc:          "A response with bad JSON",
 			fakeID:        "3",
-			expectedError: "Parsing failed",
+			expectedError: "Parsing failed: /api/v4/internal/two_factor_recovery_codes: truncated JSON",
 		},
 		{
 			desc:          "An error response without message",
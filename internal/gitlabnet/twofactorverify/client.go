@@ -6,6 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
@@ -14,16 +17,32 @@ import (
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/discover"
 )
 
+// cancelTimeout bounds the request cancelling a push notification, which is
+// sent once the context of PushAuth is already done
+const cancelTimeout = 5 * time.Second
+
+// DefaultPushPollInterval is the wait between two checks of a pending push
+// notification
+const DefaultPushPollInterval = 2 * time.Second
+
 // Client represents a client for interacting with the two-factor verification API.
 type Client struct {
 	config *config.Config
 	client *client.GitlabNetClient
+
+	// PushPollInterval is the wait between two checks of a pending push
+	// notification
+	PushPollInterval time.Duration
 }
 
 // Response represents the response from the two-factor verification API.
 type Response struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
+	// Pending is set while a push notification is neither approved nor
+	// rejected, for it to be checked again with PushID
+	Pending bool   `json:"pending,omitempty"`
+	PushID  string `json:"push_id,omitempty"`
 }
 
 // RequestBody represents the request body for two-factor verification.
@@ -31,6 +50,7 @@ type RequestBody struct {
 	KeyID      string `json:"key_id,omitempty"`
 	UserID     int64  `json:"user_id,omitempty"`
 	OTPAttempt string `json:"otp_attempt,omitempty"`
+	PushID     string `json:"push_id,omitempty"`
 }
 
 // NewClient creates a new instance of the two-factor verification client.
@@ -40,7 +60,7 @@ func NewClient(config *config.Config) (*Client, error) {
 		return nil, fmt.Errorf("error creating http client: %v", err)
 	}
 
-	return &Client{config: config, client: client}, nil
+	return &Client{config: config, client: client, PushPollInterval: DefaultPushPollInterval}, nil
 }
 
 // VerifyOTP verifies the one-time password (OTP) for two-factor authentication.
@@ -59,20 +79,73 @@ func (c *Client) VerifyOTP(ctx context.Context, args *commandargs.Shell, otp str
 	return parse(response)
 }
 
-// PushAuth performs two-factor push authentication.
+// PushAuth sends a push notification to the authenticator of the user and
+// waits until it's approved or rejected. The internal API may either hold the
+// request until then, or answer that the notification is pending, in which
+// case it's checked again every PushPollInterval. If ctx is done first, the
+// notification is cancelled, so that it can't be approved anymore.
 func (c *Client) PushAuth(ctx context.Context, args *commandargs.Shell) error {
 	requestBody, err := c.getRequestBody(ctx, args, "")
 	if err != nil {
 		return err
 	}
 
+	for {
+		response, err := c.checkPush(ctx, requestBody)
+		if err != nil {
+			if ctx.Err() != nil {
+				c.cancelPush(ctx, requestBody)
+				return ctx.Err()
+			}
+
+			return err
+		}
+
+		if !response.Pending {
+			if !response.Success {
+				return errors.New(response.Message)
+			}
+
+			return nil
+		}
+
+		requestBody.PushID = response.PushID
+
+		select {
+		case <-time.After(c.PushPollInterval):
+		case <-ctx.Done():
+			c.cancelPush(ctx, requestBody)
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *Client) checkPush(ctx context.Context, requestBody *RequestBody) (*Response, error) {
 	response, err := c.client.Post(ctx, "/two_factor_push_otp_check", requestBody)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() { _ = response.Body.Close() }()
 
-	return parse(response)
+	parsed := &Response{}
+	if err := gitlabnet.ParseJSON(response, parsed); err != nil {
+		return nil, err
+	}
+
+	return parsed, nil
+}
+
+// cancelPush cancels the push notification of requestBody, once ctx is done
+func (c *Client) cancelPush(ctx context.Context, requestBody *RequestBody) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cancelTimeout)
+	defer cancel()
+
+	response, err := c.client.Post(ctx, "/two_factor_push_otp_cancel", requestBody)
+	if err != nil {
+		log.ContextLogger(ctx).WithError(err).Info("twofactorverify: failed to cancel push notification")
+		return
+	}
+	_ = response.Body.Close()
 }
 
 func parse(hr *http.Response) error {
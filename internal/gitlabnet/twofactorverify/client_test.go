@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"testing"
+	"time"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/discover"
 
@@ -127,7 +128,7 @@ func TestErrorResponses(t *testing.T) {
 		{
 			desc:          "A response with bad JSON",
 			fakeID:        "3",
-			expectedError: "Parsing failed",
+			expectedError: "Parsing failed: /api/v4/internal/two_factor_manual_otp_check: truncated JSON",
 		},
 		{
 			desc:          "An error response without message",
@@ -178,7 +179,7 @@ func TestErrorResponsesPush(t *testing.T) {
 		{
 			desc:          "A response with bad JSON",
 			fakeID:        "3",
-			expectedError: "Parsing failed",
+			expectedError: "Parsing failed: /api/v4/internal/two_factor_push_otp_check: truncated JSON",
 		},
 		{
 			desc:          "An error response without message",
@@ -197,6 +198,65 @@ func TestErrorResponsesPush(t *testing.T) {
 	}
 }
 
+func TestPushAuthPending(t *testing.T) {
+	var pushIDs []string
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/two_factor_push_otp_check",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				var requestBody *RequestBody
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&requestBody))
+				pushIDs = append(pushIDs, requestBody.PushID)
+
+				body := map[string]interface{}{"pending": true, "push_id": "push-1"}
+				if len(pushIDs) == 3 {
+					body = map[string]interface{}{"success": true}
+				}
+				require.NoError(t, json.NewEncoder(w).Encode(body))
+			},
+		},
+	}
+
+	client, err := NewClient(&config.Config{GitlabUrl: testserver.StartSocketHttpServer(t, requests)})
+	require.NoError(t, err)
+	client.PushPollInterval = time.Millisecond
+
+	require.NoError(t, client.PushAuth(context.Background(), &commandargs.Shell{GitlabKeyId: "0"}))
+	require.Equal(t, []string{"", "push-1", "push-1"}, pushIDs)
+}
+
+func TestPushAuthCancel(t *testing.T) {
+	cancelled := make(chan *RequestBody, 1)
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/two_factor_push_otp_check",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				body := map[string]interface{}{"pending": true, "push_id": "push-1"}
+				require.NoError(t, json.NewEncoder(w).Encode(body))
+			},
+		},
+		{
+			Path: "/api/v4/internal/two_factor_push_otp_cancel",
+			Handler: func(_ http.ResponseWriter, r *http.Request) {
+				var requestBody *RequestBody
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&requestBody))
+				cancelled <- requestBody
+			},
+		},
+	}
+
+	client, err := NewClient(&config.Config{GitlabUrl: testserver.StartSocketHttpServer(t, requests)})
+	require.NoError(t, err)
+	client.PushPollInterval = time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err = client.PushAuth(ctx, &commandargs.Shell{GitlabKeyId: "0"})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, &RequestBody{KeyID: "0", PushID: "push-1"}, <-cancelled)
+}
+
 func setup(t *testing.T) *Client {
 	requests := initialize(t)
 	url := testserver.StartSocketHttpServer(t, requests)
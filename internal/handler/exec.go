@@ -3,9 +3,11 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"google.golang.org/grpc"
 	grpccodes "google.golang.org/grpc/codes"
@@ -21,6 +23,10 @@ import (
 	"gitlab.com/gitlab-org/labkit/log"
 )
 
+// deadlineExceededMessage is shown to users when a command is stopped at the
+// deadline set from the session limits and the timeout of the client
+const deadlineExceededMessage = "The command ran out of the time allowed to it by the client or the server, and was stopped."
+
 // GitalyHandlerFunc implementations are responsible for making
 // an appropriate Gitaly call using the provided client and context
 // and returning an error from the Gitaly call.
@@ -31,6 +37,8 @@ type GitalyCommand struct {
 	Config   *config.Config
 	Response *accessverifier.Response
 	Command  gitaly.Command
+
+	transfer transfer
 }
 
 // NewGitalyCommand creates a new GitalyCommand instance
@@ -39,6 +47,7 @@ func NewGitalyCommand(cfg *config.Config, serviceName string, response *accessve
 		ServiceName: serviceName,
 		Address:     response.Gitaly.Address,
 		Token:       response.Gitaly.Token,
+		Storage:     response.Gitaly.Repo.StorageName,
 	}
 
 	return &GitalyCommand{Config: cfg, Response: response, Command: gc}
@@ -65,6 +74,15 @@ func processGitalyError(statusErr error) error {
 // through GitLab-Shell. It ensures that logging, tracing and other
 // common concerns are configured before executing the `handler`.
 func (gc *GitalyCommand) RunGitalyCommand(ctx context.Context, handler GitalyHandlerFunc) error {
+	ctx, stopTransfer, err := gc.limitTransfer(ctx)
+	if err != nil {
+		return err
+	}
+	defer stopTransfer()
+
+	ctx, stopStall := gc.limitStall(ctx)
+	defer stopStall()
+
 	// We leave the connection open for future reuse
 	conn, err := gc.getConn(ctx)
 	if err != nil {
@@ -74,8 +92,21 @@ func (gc *GitalyCommand) RunGitalyCommand(ctx context.Context, handler GitalyHan
 	}
 
 	childCtx := withOutgoingMetadata(ctx, gc.Response.Gitaly.Features)
+	childCtx = gitaly.WithServiceName(childCtx, gc.Command.ServiceName)
 	ctxlog := log.ContextLogger(childCtx)
+
+	start := time.Now()
 	exitStatus, err := handler(childCtx, conn)
+	gc.logLargeTransfer(childCtx, time.Since(start))
+	stallErr := gc.finishStall(childCtx)
+
+	if quotaErr := gc.finishTransfer(childCtx); quotaErr != nil {
+		return quotaErr
+	}
+
+	if stallErr != nil {
+		return stallErr
+	}
 
 	if err != nil {
 		ctxlog.WithError(err).WithFields(log.Fields{"exit_status": exitStatus}).Error("Failed to execute Git command")
@@ -83,6 +114,10 @@ func (gc *GitalyCommand) RunGitalyCommand(ctx context.Context, handler GitalyHan
 		if grpcstatus.Code(err) == grpccodes.Unavailable {
 			return processGitalyError(err)
 		}
+
+		if grpcstatus.Code(err) == grpccodes.DeadlineExceeded || errors.Is(err, context.DeadlineExceeded) {
+			return grpcstatus.Error(grpccodes.DeadlineExceeded, deadlineExceededMessage)
+		}
 	}
 
 	return err
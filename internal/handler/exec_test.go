@@ -106,6 +106,21 @@ func TestGitalyLimitErr(t *testing.T) {
 	require.Equal(t, err, grpcstatus.Error(grpccodes.Unavailable, "GitLab is currently unable to handle this request due to load."))
 }
 
+func TestDeadlineExceededErr(t *testing.T) {
+	cmd := NewGitalyCommand(
+		newConfig(),
+		string(commandargs.UploadPack),
+		&accessverifier.Response{
+			Gitaly: accessverifier.Gitaly{Address: "tcp://localhost:9999"},
+		},
+	)
+
+	for _, handlerErr := range []error{grpcstatus.Error(grpccodes.DeadlineExceeded, "context deadline exceeded"), context.DeadlineExceeded} {
+		err := cmd.RunGitalyCommand(context.Background(), makeHandler(t, handlerErr))
+		require.Equal(t, err, grpcstatus.Error(grpccodes.DeadlineExceeded, deadlineExceededMessage))
+	}
+}
+
 func TestRunGitalyCommandMetadata(t *testing.T) {
 	tests := []struct {
 		name string
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gitlab.com/gitlab-org/labkit/log"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/transferusage"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+// Actions taken on the commands of users over their transfer quota, as
+// reported by metrics
+const (
+	quotaRefused = "refused"
+	quotaTrimmed = "trimmed"
+)
+
+// errTransferQuotaExceeded fails the reads and writes of a transfer stopped at
+// the quota of the user
+var errTransferQuotaExceeded = errors.New("transfer quota exceeded")
+
+// limitTransfer refuses the command of a user who used their transfer quota
+// up, or else limits the transfer to what's left of it. The returned context
+// is canceled once the transfer exceeds it.
+func (gc *GitalyCommand) limitTransfer(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	quota := gc.transferQuota()
+	if quota == nil {
+		return ctx, func() {}, nil
+	}
+
+	if quota.Remaining() == 0 {
+		metrics.AccessTransferQuotaExceededTotal.WithLabelValues(quotaRefused).Inc()
+		log.WithContextFields(ctx, gc.quotaFields()).Info("Refused a Git command over the transfer quota")
+
+		return ctx, func() {}, quotaError("You have used up your daily transfer quota of %s.", quota)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	gc.transfer.limit = quota.Remaining()
+	gc.transfer.stop = func() { cancel(errTransferQuotaExceeded) }
+
+	return ctx, func() { cancel(nil) }, nil
+}
+
+// finishTransfer reports the usage of the transfer to GitLab, and returns the
+// error the command fails with if it was stopped at the quota
+func (gc *GitalyCommand) finishTransfer(ctx context.Context) error {
+	quota := gc.transferQuota()
+	if quota == nil {
+		return nil
+	}
+
+	trimmed := gc.transfer.exceeded.Load()
+	gc.reportTransferUsage(ctx, trimmed)
+
+	if !trimmed {
+		return nil
+	}
+
+	metrics.AccessTransferQuotaExceededTotal.WithLabelValues(quotaTrimmed).Inc()
+	log.WithContextFields(ctx, gc.quotaFields()).Info("Stopped a Git transfer at the transfer quota")
+
+	return quotaError("The transfer was stopped as it exceeded your daily transfer quota of %s.", quota)
+}
+
+// reportTransferUsage reports the bytes transferred to GitLab, which counts
+// them against the quota of the user. Failures are only logged.
+func (gc *GitalyCommand) reportTransferUsage(ctx context.Context, trimmed bool) {
+	bytesIn, bytesOut := gc.transfer.in.Load(), gc.transfer.out.Load()
+	if bytesIn == 0 && bytesOut == 0 {
+		return
+	}
+
+	// The context of a trimmed transfer is canceled already
+	ctx = context.WithoutCancel(ctx)
+	ctxlog := log.WithContextFields(ctx, gc.quotaFields())
+
+	client, err := transferusage.NewClient(gc.Config)
+	if err != nil {
+		ctxlog.WithError(err).Error("Failed to create the transfer usage client")
+		return
+	}
+
+	err = client.Report(ctx, &transferusage.Request{
+		UserID:     gc.Response.UserID,
+		Username:   gc.Response.Username,
+		Repository: gc.Response.Repo,
+		ProjectID:  gc.Response.ProjectID,
+		Command:    gc.Command.ServiceName,
+		BytesIn:    bytesIn,
+		BytesOut:   bytesOut,
+		Trimmed:    trimmed,
+	})
+	if err != nil {
+		ctxlog.WithError(err).Error("Failed to report the transfer usage")
+	}
+}
+
+// transferQuota returns the transfer quota of the user, nil if none
+func (gc *GitalyCommand) transferQuota() *accessverifier.TransferQuota {
+	if gc.Response == nil {
+		return nil
+	}
+
+	return gc.Response.TransferQuota
+}
+
+func (gc *GitalyCommand) quotaFields() log.Fields {
+	quota := gc.Response.TransferQuota
+
+	return log.Fields{
+		"command":              gc.Command.ServiceName,
+		"user_id":              gc.Response.UserID,
+		"gl_repository":        gc.Response.Repo,
+		"quota_limit_bytes":    quota.LimitBytes,
+		"quota_used_bytes":     quota.UsedBytes,
+		"transfer_bytes_in":    gc.transfer.in.Load(),
+		"transfer_bytes_out":   gc.transfer.out.Load(),
+		"transfer_limit_bytes": gc.transfer.limit,
+	}
+}
+
+// quotaError returns the error shown to users over quota, given message
+// formatted with the quota
+func quotaError(message string, quota *accessverifier.TransferQuota) error {
+	message = fmt.Sprintf(message, formatBytes(quota.LimitBytes))
+	if quota.ResetsAt != "" {
+		message += " It resets at " + quota.ResetsAt + "."
+	}
+
+	return grpcstatus.Error(grpccodes.ResourceExhausted, message)
+}
+
+// formatBytes formats n in binary units, e.g. "1.5 GiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/transferusage"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+func TestTransferQuota(t *testing.T) {
+	testCases := []struct {
+		desc            string
+		quota           *accessverifier.TransferQuota
+		expectedOutput  string
+		expectedError   string
+		expectedCalled  bool
+		expectedReport  *transferusage.Request
+		expectedRefused float64
+		expectedTrimmed float64
+	}{
+		{
+			desc:           "without a quota",
+			expectedOutput: "pack data",
+			expectedCalled: true,
+		},
+		{
+			desc:           "within the quota",
+			quota:          &accessverifier.TransferQuota{LimitBytes: 1024, UsedBytes: 512},
+			expectedOutput: "pack data",
+			expectedCalled: true,
+			expectedReport: &transferusage.Request{UserID: "user-1", Repository: "project-1", Command: "git-upload-pack", BytesIn: 9, BytesOut: 9},
+		},
+		{
+			desc:            "over the quota",
+			quota:           &accessverifier.TransferQuota{LimitBytes: 2048, UsedBytes: 2048, ResetsAt: "2024-01-02T00:00:00Z"},
+			expectedError:   "You have used up your daily transfer quota of 2.0 KiB. It resets at 2024-01-02T00:00:00Z.",
+			expectedRefused: 1,
+		},
+		{
+			desc:            "exceeding the quota",
+			quota:           &accessverifier.TransferQuota{LimitBytes: 1024, UsedBytes: 1014},
+			expectedOutput:  "p",
+			expectedError:   "The transfer was stopped as it exceeded your daily transfer quota of 1.0 KiB.",
+			expectedCalled:  true,
+			expectedReport:  &transferusage.Request{UserID: "user-1", Repository: "project-1", Command: "git-upload-pack", BytesIn: 9, BytesOut: 1, Trimmed: true},
+			expectedTrimmed: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			metrics.AccessTransferQuotaExceededTotal.Reset()
+
+			var report *transferusage.Request
+			url := testserver.StartSocketHttpServer(t, []testserver.TestRequestHandler{
+				{
+					Path: "/api/v4/internal/transfer_usage",
+					Handler: func(w http.ResponseWriter, r *http.Request) {
+						report = &transferusage.Request{}
+						require.NoError(t, json.NewDecoder(r.Body).Decode(report))
+					},
+				},
+			})
+
+			cfg := newConfig()
+			cfg.GitlabUrl = url
+
+			cmd := NewGitalyCommand(cfg, string(commandargs.UploadPack), &accessverifier.Response{
+				UserID:        "user-1",
+				Repo:          "project-1",
+				TransferQuota: tc.quota,
+				Gitaly:        accessverifier.Gitaly{Address: "tcp://localhost:9999"},
+			})
+
+			out := &bytes.Buffer{}
+			rw := cmd.ReadWriter(&readwriter.ReadWriter{In: strings.NewReader("pack data"), Out: out})
+
+			called := false
+			err := cmd.RunGitalyCommand(context.Background(), func(context.Context, *grpc.ClientConn) (int32, error) {
+				called = true
+				_, err := io.Copy(rw.Out, rw.In)
+				return 0, err
+			})
+
+			require.Equal(t, tc.expectedCalled, called)
+			require.Equal(t, tc.expectedOutput, out.String())
+			require.Equal(t, tc.expectedReport, report)
+			if tc.expectedError == "" {
+				require.NoError(t, err)
+			} else {
+				require.Equal(t, grpccodes.ResourceExhausted, grpcstatus.Code(err))
+				require.Equal(t, tc.expectedError, grpcstatus.Convert(err).Message())
+			}
+
+			require.InDelta(t, tc.expectedRefused, testutil.ToFloat64(metrics.AccessTransferQuotaExceededTotal.WithLabelValues(quotaRefused)), 0.1)
+			require.InDelta(t, tc.expectedTrimmed, testutil.ToFloat64(metrics.AccessTransferQuotaExceededTotal.WithLabelValues(quotaTrimmed)), 0.1)
+		})
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	require.Equal(t, "512 B", formatBytes(512))
+	require.Equal(t, "1.5 KiB", formatBytes(1536))
+	require.Equal(t, "10.0 GiB", formatBytes(10<<30))
+}
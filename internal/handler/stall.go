@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+// stallThreshold is the time from which a write to the client counts as
+// stalled, the client reading slower than Gitaly sends, rather than as the
+// latency of the write
+const stallThreshold = 10 * time.Millisecond
+
+// errClientStalled fails the writes of a transfer stopped for its client
+// reading none of it for longer than the max write stall
+var errClientStalled = errors.New("client stopped reading the transfer")
+
+// watchWrite starts the watchdog of a write to the client, which stops the
+// transfer once the write blocks for longer than maxStall. It returns nil
+// without a max write stall.
+func (t *transfer) watchWrite() *time.Timer {
+	if t.maxStall <= 0 || t.stopStalled == nil {
+		return nil
+	}
+
+	return time.AfterFunc(t.maxStall, func() {
+		if t.stalledOut.CompareAndSwap(false, true) {
+			t.stopStalled()
+		}
+	})
+}
+
+// wrote stops the watchdog of a write that blocked for the given time, and
+// counts it as stalled from stallThreshold
+func (t *transfer) wrote(watchdog *time.Timer, blocked time.Duration) {
+	if watchdog != nil {
+		watchdog.Stop()
+	}
+
+	if blocked >= stallThreshold {
+		t.stalled.Add(int64(blocked))
+	}
+}
+
+// limitStall stops the transfer once a write to the client blocks for longer
+// than the max write stall: the returned context is canceled, so that Gitaly
+// releases the RPC. The blocked write itself only returns once the client
+// reads again or the session is closed.
+//
+// The output of Gitaly is written to the client as it's received: while a
+// write blocks, the RPC isn't read from, and Gitaly stops sending once the
+// flow control window is full. A slow client slows Gitaly down rather than
+// growing the memory of gitlab-shell, but holds the RPC until it's stopped.
+func (gc *GitalyCommand) limitStall(ctx context.Context) (context.Context, context.CancelFunc) {
+	if gc.Config == nil || gc.Config.Gitaly.MaxWriteStall <= 0 {
+		return ctx, func() {}
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	gc.transfer.maxStall = time.Duration(gc.Config.Gitaly.MaxWriteStall)
+	gc.transfer.stopStalled = func() { cancel(errClientStalled) }
+
+	return ctx, func() { cancel(nil) }
+}
+
+// finishStall reports the time the transfer was stalled by the client, and
+// returns the error the command fails with if it was stopped for it
+func (gc *GitalyCommand) finishStall(ctx context.Context) error {
+	stalled := time.Duration(gc.transfer.stalled.Load())
+	metrics.GitalyWriteStallSeconds.WithLabelValues(gc.Command.ServiceName).Observe(stalled.Seconds())
+
+	if !gc.transfer.stalledOut.Load() {
+		return nil
+	}
+
+	metrics.GitalyStalledTransfersTotal.WithLabelValues(gc.Command.ServiceName).Inc()
+	log.WithContextFields(ctx, log.Fields{
+		"command":         gc.Command.ServiceName,
+		"bytes_out":       gc.transfer.out.Load(),
+		"write_stall_s":   stalled.Seconds(),
+		"max_write_stall": gc.transfer.maxStall.String(),
+	}).Info("Stopped a Git transfer whose client stopped reading it")
+
+	return grpcstatus.Errorf(grpccodes.Aborted, "The transfer was stopped as the client read none of it for %v.", gc.transfer.maxStall)
+}
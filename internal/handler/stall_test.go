@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+// slowWriter is a client reading the output after delay, or once unblocked
+// is closed
+type slowWriter struct {
+	delay     time.Duration
+	unblocked chan struct{}
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	select {
+	case <-time.After(w.delay):
+	case <-w.unblocked:
+	}
+
+	return len(p), nil
+}
+
+func TestWriteStall(t *testing.T) {
+	testCases := []struct {
+		desc            string
+		maxWriteStall   time.Duration
+		delay           time.Duration
+		expectedError   string
+		expectedStall   time.Duration
+		expectedStalled float64
+	}{
+		{
+			desc:          "a slow client",
+			delay:         2 * stallThreshold,
+			expectedStall: 2 * stallThreshold,
+		},
+		{
+			desc:          "a slow client within the max write stall",
+			maxWriteStall: time.Minute,
+			delay:         2 * stallThreshold,
+			expectedStall: 2 * stallThreshold,
+		},
+		{
+			desc:            "a client that stopped reading",
+			maxWriteStall:   50 * time.Millisecond,
+			delay:           time.Hour,
+			expectedError:   "The transfer was stopped as the client read none of it for 50ms.",
+			expectedStall:   50 * time.Millisecond,
+			expectedStalled: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			metrics.GitalyStalledTransfersTotal.Reset()
+			metrics.GitalyWriteStallSeconds.Reset()
+
+			cfg := newConfig()
+			cfg.Gitaly = config.GitalyConfig{MaxWriteStall: config.YamlDuration(tc.maxWriteStall)}
+
+			cmd := NewGitalyCommand(cfg, string(commandargs.UploadPack), &accessverifier.Response{
+				Gitaly: accessverifier.Gitaly{Address: "tcp://localhost:9999"},
+			})
+
+			client := &slowWriter{delay: tc.delay, unblocked: make(chan struct{})}
+			rw := cmd.ReadWriter(&readwriter.ReadWriter{Out: client})
+
+			err := cmd.RunGitalyCommand(context.Background(), func(ctx context.Context, _ *grpc.ClientConn) (int32, error) {
+				go func() {
+					// The session is closed once the RPC is stopped
+					<-ctx.Done()
+					close(client.unblocked)
+				}()
+
+				if _, err := rw.Out.Write([]byte("pack data")); err != nil {
+					return 1, err
+				}
+
+				if tc.expectedError != "" {
+					require.ErrorIs(t, context.Cause(ctx), errClientStalled)
+
+					_, err := rw.Out.Write([]byte("more pack data"))
+					require.ErrorIs(t, err, errClientStalled)
+				}
+
+				return 0, nil
+			})
+
+			if tc.expectedError == "" {
+				require.NoError(t, err)
+			} else {
+				require.Equal(t, grpccodes.Aborted, grpcstatus.Code(err))
+				require.Equal(t, tc.expectedError, grpcstatus.Convert(err).Message())
+			}
+
+			require.GreaterOrEqual(t, time.Duration(cmd.transfer.stalled.Load()), tc.expectedStall)
+			require.Equal(t, 1, testutil.CollectAndCount(metrics.GitalyWriteStallSeconds))
+			require.InDelta(t, tc.expectedStalled, testutil.ToFloat64(metrics.GitalyStalledTransfersTotal.WithLabelValues(string(commandargs.UploadPack))), 0.1)
+		})
+	}
+}
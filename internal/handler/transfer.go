@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/bufferpool"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+)
+
+// transfer counts the bytes a Git command received from and sent to the
+// client, which may happen concurrently
+type transfer struct {
+	in, out atomic.Int64
+
+	// limit bounds the bytes of the transfer, when set. The transfer is
+	// trimmed at it: stop is called and the reads and writes fail.
+	limit    int64
+	stop     func()
+	exceeded atomic.Bool
+
+	// stalled is the time the writes to the client were blocked for, see
+	// stallThreshold. A write blocked for longer than maxStall calls
+	// stopStalled, and the writes fail from then on.
+	stalled     atomic.Int64
+	maxStall    time.Duration
+	stopStalled func()
+	stalledOut  atomic.Bool
+}
+
+// allowed returns how many of n bytes the transfer may still move
+func (t *transfer) allowed(n int) int {
+	if t.limit <= 0 {
+		return n
+	}
+
+	remaining := t.limit - t.in.Load() - t.out.Load()
+
+	return int(max(min(int64(n), remaining), 0))
+}
+
+// exceed stops the transfer, the first time it's called
+func (t *transfer) exceed() error {
+	if t.exceeded.CompareAndSwap(false, true) && t.stop != nil {
+		t.stop()
+	}
+
+	return errTransferQuotaExceeded
+}
+
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+	t *transfer
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	allowed := cr.t.allowed(len(p))
+	if allowed == 0 && len(p) > 0 {
+		return 0, cr.t.exceed()
+	}
+
+	n, err := cr.r.Read(p[:allowed])
+	cr.n.Add(int64(n))
+	return n, err
+}
+
+// WriteTo makes the io.Copy of Gitaly clients copy the input of the client
+// through a pooled buffer
+func (cr *countingReader) WriteTo(w io.Writer) (int64, error) {
+	return bufferpool.Copy(w, struct{ io.Reader }{cr})
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+	t *transfer
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.t.stalledOut.Load() {
+		return 0, errClientStalled
+	}
+
+	allowed := cw.t.allowed(len(p))
+	if allowed == 0 && len(p) > 0 {
+		return 0, cw.t.exceed()
+	}
+
+	start := time.Now()
+	watchdog := cw.t.watchWrite()
+	n, err := cw.w.Write(p[:allowed])
+	cw.t.wrote(watchdog, time.Since(start))
+	cw.n.Add(int64(n))
+	if err == nil && allowed < len(p) {
+		err = cw.t.exceed()
+	}
+	return n, err
+}
+
+// ReadWriter returns rw counting the bytes of the transfer, so that
+// RunGitalyCommand logs the transfers above the configured thresholds,
+// enforces the transfer quota of the user and measures how long the client
+// kept the output of Gitaly waiting
+func (gc *GitalyCommand) ReadWriter(rw *readwriter.ReadWriter) *readwriter.ReadWriter {
+	return &readwriter.ReadWriter{
+		In:     &countingReader{r: rw.In, n: &gc.transfer.in, t: &gc.transfer},
+		Out:    &countingWriter{w: rw.Out, n: &gc.transfer.out, t: &gc.transfer},
+		ErrOut: rw.ErrOut,
+	}
+}
+
+// logLargeTransfer logs a warning if the transfer took longer than the
+// slow transfer threshold, or sent or received more than the large transfer
+// threshold
+func (gc *GitalyCommand) logLargeTransfer(ctx context.Context, duration time.Duration) {
+	if gc.Config == nil {
+		return
+	}
+
+	cfg := gc.Config.Gitaly
+	bytesIn, bytesOut := gc.transfer.in.Load(), gc.transfer.out.Load()
+
+	slow := cfg.SlowTransferThreshold > 0 && duration > time.Duration(cfg.SlowTransferThreshold)
+	large := cfg.LargeTransferThresholdBytes > 0 && max(bytesIn, bytesOut) > cfg.LargeTransferThresholdBytes
+	if !slow && !large {
+		return
+	}
+
+	repository := &gc.Response.Gitaly.Repo
+	fields := log.Fields{
+		"command":         gc.Command.ServiceName,
+		"gl_project_path": repository.GlProjectPath,
+		"gl_repository":   repository.GlRepository,
+		"user_id":         gc.Response.UserID,
+		"username":        gc.Response.Username,
+		"gl_key_id":       gc.Response.KeyID,
+		"bytes_in":        bytesIn,
+		"bytes_out":       bytesOut,
+		"duration_s":      duration.Seconds(),
+		"write_stall_s":   time.Duration(gc.transfer.stalled.Load()).Seconds(),
+		"slow":            slow,
+		"large":           large,
+	}
+
+	log.WithContextFields(ctx, fields).Warn("Large or slow Git transfer")
+}
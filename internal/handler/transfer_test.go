@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
+)
+
+func TestLargeTransferLogging(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		gitaly      config.GitalyConfig
+		expectedLog bool
+	}{
+		{
+			desc: "without thresholds",
+		},
+		{
+			desc:   "below the thresholds",
+			gitaly: config.GitalyConfig{SlowTransferThreshold: config.YamlDuration(time.Hour), LargeTransferThresholdBytes: 1024},
+		},
+		{
+			desc:        "above the size threshold",
+			gitaly:      config.GitalyConfig{LargeTransferThresholdBytes: 4},
+			expectedLog: true,
+		},
+		{
+			desc:        "above the duration threshold",
+			gitaly:      config.GitalyConfig{SlowTransferThreshold: config.YamlDuration(time.Nanosecond)},
+			expectedLog: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			hook := test.NewGlobal()
+			defer hook.Reset()
+
+			cfg := newConfig()
+			cfg.Gitaly = tc.gitaly
+
+			cmd := NewGitalyCommand(cfg, string(commandargs.ReceivePack), &accessverifier.Response{
+				Username: "jane-doe",
+				Gitaly:   accessverifier.Gitaly{Address: "tcp://localhost:9999"},
+			})
+
+			out := &bytes.Buffer{}
+			rw := cmd.ReadWriter(&readwriter.ReadWriter{In: strings.NewReader("pack data"), Out: out})
+
+			err := cmd.RunGitalyCommand(context.Background(), func(context.Context, *grpc.ClientConn) (int32, error) {
+				_, err := io.Copy(rw.Out, rw.In)
+				return 0, err
+			})
+			require.NoError(t, err)
+			require.Equal(t, "pack data", out.String())
+
+			var entries []*logrus.Entry
+			for _, entry := range hook.AllEntries() {
+				if entry.Message == "Large or slow Git transfer" {
+					entries = append(entries, entry)
+				}
+			}
+
+			if !tc.expectedLog {
+				require.Empty(t, entries)
+				return
+			}
+
+			require.Len(t, entries, 1)
+			require.Equal(t, logrus.WarnLevel, entries[0].Level)
+			require.Equal(t, "jane-doe", entries[0].Data["username"])
+			require.Equal(t, int64(9), entries[0].Data["bytes_in"])
+			require.Equal(t, int64(9), entries[0].Data["bytes_out"])
+		})
+	}
+}
+
+func TestCountingReaderWriteTo(t *testing.T) {
+	gc := &GitalyCommand{}
+	rw := gc.ReadWriter(&readwriter.ReadWriter{In: strings.NewReader("input")})
+
+	out := &bytes.Buffer{}
+	n, err := io.Copy(out, rw.In)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), n)
+	require.Equal(t, "input", out.String())
+	require.Equal(t, int64(5), gc.transfer.in.Load())
+}
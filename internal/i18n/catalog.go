@@ -0,0 +1,127 @@
+package i18n
+
+// catalogs holds the translations of the messages by language. Format verbs
+// must be kept in the same order as in the English messages. The answers the
+// commands expect, such as "yes", aren't translated.
+var catalogs = map[string]map[string]string{
+	"de": {
+		// discover
+		"Welcome to GitLab, Anonymous!": "Willkommen bei GitLab, Anonymous!",
+		"Welcome to GitLab, @%s!":       "Willkommen bei GitLab, @%s!",
+		"Failed to get username: %v":    "Benutzername konnte nicht abgerufen werden: %v",
+
+		// 2fa_verify
+		"No OTP entered, waiting for push authentication...": "Kein OTP eingegeben, warte auf Push-Authentifizierung...",
+		"%v remaining": "noch %v",
+		"OTP validation successful. Git operations are now allowed.":                     "OTP-Validierung erfolgreich. Git-Operationen sind jetzt erlaubt.",
+		"OTP has been validated by Push Authentication. Git operations are now allowed.": "Das OTP wurde per Push-Authentifizierung validiert. Git-Operationen sind jetzt erlaubt.",
+		"OTP validation failed: %v": "OTP-Validierung fehlgeschlagen: %v",
+		"OTP cannot be blank":       "Das OTP darf nicht leer sein",
+
+		// 2fa_recovery_codes
+		"Are you sure you want to generate new two-factor recovery codes?\nAny existing recovery codes you saved will be invalidated. (yes/no)":                                                        "Möchten Sie wirklich neue Wiederherstellungscodes für die Zwei-Faktor-Authentifizierung generieren?\nAlle bestehenden Wiederherstellungscodes, die Sie gespeichert haben, werden ungültig. (yes/no)",
+		"New recovery codes have *not* been generated. Existing codes will remain valid.":                                                                                                              "Es wurden *keine* neuen Wiederherstellungscodes generiert. Die bestehenden Codes bleiben gültig.",
+		"Your two-factor authentication recovery codes are:":                                                                                                                                           "Ihre Wiederherstellungscodes für die Zwei-Faktor-Authentifizierung lauten:",
+		"During sign in, use one of the codes above when prompted for\nyour two-factor code. Then, visit your Profile Settings and add\na new device so you do not lose access to your account again.": "Verwenden Sie bei der Anmeldung einen der obigen Codes, wenn Sie\nnach Ihrem Zwei-Faktor-Code gefragt werden. Öffnen Sie danach Ihre\nProfileinstellungen und fügen Sie ein neues Gerät hinzu, damit Sie\nnicht erneut den Zugriff auf Ihr Konto verlieren.",
+		"An error occurred while trying to generate new recovery codes.":                                                                                                                               "Beim Generieren neuer Wiederherstellungscodes ist ein Fehler aufgetreten.",
+
+		// personal_access_token
+		"Usage: personal_access_token <name> <scope1[,scope2,...]> [ttl_days]": "Verwendung: personal_access_token <name> <scope1[,scope2,...]> [ttl_days]",
+		"Invalid value for days_ttl: '%s'":                                     "Ungültiger Wert für days_ttl: '%s'",
+		"Invalid value for days_ttl: '%d'. The maximum is %d days":             "Ungültiger Wert für days_ttl: '%d'. Das Maximum beträgt %d Tage",
+		"Invalid scope: '%s'. Available scopes: %s":                            "Ungültiger Bereich: '%s'. Verfügbare Bereiche: %s",
+		"%v. Available scopes: %s":                                             "%v. Verfügbare Bereiche: %s",
+		"Name:":                                                                "Name:",
+		"Scopes:":                                                              "Bereiche:",
+		"Expires:":                                                             "Läuft ab:",
+		"Token:":                                                               "Token:",
+		"Are you sure you want to create this personal access token? (yes/no)": "Möchten Sie dieses persönliche Zugriffstoken wirklich erstellen? (yes/no)",
+		"A personal access token has *not* been created.":                      "Es wurde *kein* persönliches Zugriffstoken erstellt.",
+
+		// errors
+		"Correlation ID: %s":           "Korrelations-ID: %s",
+		"Unknown command: %v":          "Unbekannter Befehl: %v",
+		"Failed to parse command: %v":  "Befehl konnte nicht verarbeitet werden: %v",
+		"Failed to record the session": "Die Sitzung konnte nicht aufgezeichnet werden",
+	},
+	"es": {
+		// discover
+		"Welcome to GitLab, Anonymous!": "¡Bienvenido a GitLab, Anonymous!",
+		"Welcome to GitLab, @%s!":       "¡Bienvenido a GitLab, @%s!",
+		"Failed to get username: %v":    "No se pudo obtener el nombre de usuario: %v",
+
+		// 2fa_verify
+		"No OTP entered, waiting for push authentication...": "No se introdujo ningún OTP, esperando la autenticación push...",
+		"%v remaining": "quedan %v",
+		"OTP validation successful. Git operations are now allowed.":                     "Validación del OTP correcta. Las operaciones de Git ya están permitidas.",
+		"OTP has been validated by Push Authentication. Git operations are now allowed.": "El OTP se ha validado mediante la autenticación push. Las operaciones de Git ya están permitidas.",
+		"OTP validation failed: %v": "Error en la validación del OTP: %v",
+		"OTP cannot be blank":       "El OTP no puede estar vacío",
+
+		// 2fa_recovery_codes
+		"Are you sure you want to generate new two-factor recovery codes?\nAny existing recovery codes you saved will be invalidated. (yes/no)":                                                        "¿Seguro que quiere generar nuevos códigos de recuperación de dos factores?\nTodos los códigos de recuperación existentes que haya guardado quedarán invalidados. (yes/no)",
+		"New recovery codes have *not* been generated. Existing codes will remain valid.":                                                                                                              "*No* se han generado nuevos códigos de recuperación. Los códigos existentes siguen siendo válidos.",
+		"Your two-factor authentication recovery codes are:":                                                                                                                                           "Sus códigos de recuperación de autenticación de dos factores son:",
+		"During sign in, use one of the codes above when prompted for\nyour two-factor code. Then, visit your Profile Settings and add\na new device so you do not lose access to your account again.": "Al iniciar sesión, use uno de los códigos anteriores cuando se le\npida su código de dos factores. Después, vaya a la configuración de\nsu perfil y añada un nuevo dispositivo para no volver a perder el\nacceso a su cuenta.",
+		"An error occurred while trying to generate new recovery codes.":                                                                                                                               "Se produjo un error al intentar generar nuevos códigos de recuperación.",
+
+		// personal_access_token
+		"Usage: personal_access_token <name> <scope1[,scope2,...]> [ttl_days]": "Uso: personal_access_token <name> <scope1[,scope2,...]> [ttl_days]",
+		"Invalid value for days_ttl: '%s'":                                     "Valor no válido para days_ttl: '%s'",
+		"Invalid value for days_ttl: '%d'. The maximum is %d days":             "Valor no válido para days_ttl: '%d'. El máximo es de %d días",
+		"Invalid scope: '%s'. Available scopes: %s":                            "Ámbito no válido: '%s'. Ámbitos disponibles: %s",
+		"%v. Available scopes: %s":                                             "%v. Ámbitos disponibles: %s",
+		"Name:":                                                                "Nombre:",
+		"Scopes:":                                                              "Ámbitos:",
+		"Expires:":                                                             "Caduca:",
+		"Token:":                                                               "Token:",
+		"Are you sure you want to create this personal access token? (yes/no)": "¿Seguro que quiere crear este token de acceso personal? (yes/no)",
+		"A personal access token has *not* been created.":                      "*No* se ha creado el token de acceso personal.",
+
+		// errors
+		"Correlation ID: %s":           "ID de correlación: %s",
+		"Unknown command: %v":          "Comando desconocido: %v",
+		"Failed to parse command: %v":  "No se pudo analizar el comando: %v",
+		"Failed to record the session": "No se pudo grabar la sesión",
+	},
+	"fr": {
+		// discover
+		"Welcome to GitLab, Anonymous!": "Bienvenue sur GitLab, Anonymous !",
+		"Welcome to GitLab, @%s!":       "Bienvenue sur GitLab, @%s !",
+		"Failed to get username: %v":    "Impossible d'obtenir le nom d'utilisateur : %v",
+
+		// 2fa_verify
+		"No OTP entered, waiting for push authentication...": "Aucun OTP saisi, en attente de l'authentification push...",
+		"%v remaining": "%v restantes",
+		"OTP validation successful. Git operations are now allowed.":                     "Validation de l'OTP réussie. Les opérations Git sont maintenant autorisées.",
+		"OTP has been validated by Push Authentication. Git operations are now allowed.": "L'OTP a été validé par l'authentification push. Les opérations Git sont maintenant autorisées.",
+		"OTP validation failed: %v": "Échec de la validation de l'OTP : %v",
+		"OTP cannot be blank":       "L'OTP ne peut pas être vide",
+
+		// 2fa_recovery_codes
+		"Are you sure you want to generate new two-factor recovery codes?\nAny existing recovery codes you saved will be invalidated. (yes/no)":                                                        "Voulez-vous vraiment générer de nouveaux codes de récupération à deux facteurs ?\nTous les codes de récupération existants que vous avez enregistrés seront invalidés. (yes/no)",
+		"New recovery codes have *not* been generated. Existing codes will remain valid.":                                                                                                              "De nouveaux codes de récupération n'ont *pas* été générés. Les codes existants restent valides.",
+		"Your two-factor authentication recovery codes are:":                                                                                                                                           "Vos codes de récupération d'authentification à deux facteurs sont :",
+		"During sign in, use one of the codes above when prompted for\nyour two-factor code. Then, visit your Profile Settings and add\na new device so you do not lose access to your account again.": "Lors de la connexion, utilisez l'un des codes ci-dessus lorsque votre\ncode à deux facteurs vous est demandé. Ensuite, ouvrez les paramètres\nde votre profil et ajoutez un nouvel appareil afin de ne plus perdre\nl'accès à votre compte.",
+		"An error occurred while trying to generate new recovery codes.":                                                                                                                               "Une erreur s'est produite lors de la génération de nouveaux codes de récupération.",
+
+		// personal_access_token
+		"Usage: personal_access_token <name> <scope1[,scope2,...]> [ttl_days]": "Utilisation : personal_access_token <name> <scope1[,scope2,...]> [ttl_days]",
+		"Invalid value for days_ttl: '%s'":                                     "Valeur non valide pour days_ttl : '%s'",
+		"Invalid value for days_ttl: '%d'. The maximum is %d days":             "Valeur non valide pour days_ttl : '%d'. Le maximum est de %d jours",
+		"Invalid scope: '%s'. Available scopes: %s":                            "Portée non valide : '%s'. Portées disponibles : %s",
+		"%v. Available scopes: %s":                                             "%v. Portées disponibles : %s",
+		"Name:":                                                                "Nom :",
+		"Scopes:":                                                              "Portées :",
+		"Expires:":                                                             "Expire le :",
+		"Token:":                                                               "Jeton :",
+		"Are you sure you want to create this personal access token? (yes/no)": "Voulez-vous vraiment créer ce jeton d'accès personnel ? (yes/no)",
+		"A personal access token has *not* been created.":                      "Le jeton d'accès personnel n'a *pas* été créé.",
+
+		// errors
+		"Correlation ID: %s":           "ID de corrélation : %s",
+		"Unknown command: %v":          "Commande inconnue : %v",
+		"Failed to parse command: %v":  "Impossible d'analyser la commande : %v",
+		"Failed to record the session": "Impossible d'enregistrer la session",
+	},
+}
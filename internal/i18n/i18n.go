@@ -0,0 +1,76 @@
+// Package i18n translates the messages shown to users into their language.
+// Messages are looked up by their English text, which is shown when a
+// language has no translation for them.
+package i18n
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// English is the language the messages are written in
+const English = "en"
+
+type languageKey struct{}
+
+// Parse returns the language of a locale such as "de_DE.UTF-8", as found in
+// LANG or LC_MESSAGES, or a language tag such as "pt-BR", as GitLab stores
+// them. Locales without a language, "C" and "POSIX", are English. Languages
+// without translations are "".
+func Parse(locale string) string {
+	locale = strings.TrimSpace(locale)
+	if locale == "C" || locale == "POSIX" || strings.HasPrefix(locale, "C.") {
+		return English
+	}
+
+	// The language is followed by the territory, codeset and modifier, as in
+	// "language_TERRITORY.codeset@modifier"
+	language, _, _ := strings.Cut(locale, ".")
+	language, _, _ = strings.Cut(language, "@")
+	language = strings.ToLower(strings.ReplaceAll(language, "-", "_"))
+
+	if _, ok := catalogs[language]; ok {
+		return language
+	}
+
+	language, _, _ = strings.Cut(language, "_")
+	if _, ok := catalogs[language]; ok || language == English {
+		return language
+	}
+
+	return ""
+}
+
+// Text returns message in language, or message itself when it has no
+// translation
+func Text(language, message string) string {
+	if translation, ok := catalogs[language][message]; ok {
+		return translation
+	}
+
+	return message
+}
+
+// Sprintf formats the translation of format in language
+func Sprintf(language, format string, args ...interface{}) string {
+	return fmt.Sprintf(Text(language, format), args...)
+}
+
+// Errorf is Sprintf returning an error, for the errors shown to users
+func Errorf(language, format string, args ...interface{}) error {
+	return fmt.Errorf(Text(language, format), args...)
+}
+
+// NewContext returns a context carrying language, for the messages shown to
+// users on behalf of the commands run with it, such as their errors
+func NewContext(ctx context.Context, language string) context.Context {
+	return context.WithValue(ctx, languageKey{}, language)
+}
+
+// FromContext returns the language ctx carries, "" if none
+func FromContext(ctx context.Context) string {
+	language, _ := ctx.Value(languageKey{}).(string)
+
+	return language
+}
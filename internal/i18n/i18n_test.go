@@ -0,0 +1,57 @@
+package i18n
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		locale   string
+		expected string
+	}{
+		{locale: "de_DE.UTF-8", expected: "de"},
+		{locale: "fr_CA", expected: "fr"},
+		{locale: "es_ES@euro", expected: "es"},
+		{locale: "es-MX", expected: "es"},
+		{locale: " FR ", expected: "fr"},
+		{locale: "en_US.UTF-8", expected: English},
+		{locale: "C", expected: English},
+		{locale: "C.UTF-8", expected: English},
+		{locale: "POSIX", expected: English},
+		{locale: "xx_XX.UTF-8", expected: ""},
+		{locale: "", expected: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.locale, func(t *testing.T) {
+			require.Equal(t, tc.expected, Parse(tc.locale))
+		})
+	}
+}
+
+func TestSprintf(t *testing.T) {
+	require.Equal(t, "Willkommen bei GitLab, @alex!", Sprintf("de", "Welcome to GitLab, @%s!", "alex"))
+	require.Equal(t, "Welcome to GitLab, @alex!", Sprintf(English, "Welcome to GitLab, @%s!", "alex"))
+	require.Equal(t, "Welcome to GitLab, @alex!", Sprintf("", "Welcome to GitLab, @%s!", "alex"))
+	require.Equal(t, "Untranslated 1", Sprintf("de", "Untranslated %d", 1))
+	require.EqualError(t, Errorf("fr", "Unknown command: %v", "ls"), "Commande inconnue : ls")
+}
+
+func TestCatalogsKeepVerbs(t *testing.T) {
+	verbs := regexp.MustCompile(`%[a-z]`)
+
+	for language, catalog := range catalogs {
+		for message, translation := range catalog {
+			require.Equal(t, verbs.FindAllString(message, -1), verbs.FindAllString(translation, -1), "%s: %q", language, message)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	require.Empty(t, FromContext(context.Background()))
+	require.Equal(t, "de", FromContext(NewContext(context.Background(), "de")))
+}
@@ -0,0 +1,76 @@
+package logger
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/gitlab-org/labkit/correlation"
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+// contextFields are added to the lines logged with a correlation ID, so that
+// e.g. every line of a connection names its user
+var contextFields = struct {
+	mu              sync.RWMutex
+	byCorrelationID map[string]log.Fields
+}{byCorrelationID: make(map[string]log.Fields)}
+
+// AddContextFields adds fields, such as username, key_id or remote_ip, to
+// every line logged with the correlation ID of ctx until RemoveContextFields
+// is called. Empty values are ignored.
+func AddContextFields(ctx context.Context, fields log.Fields) {
+	correlationID := correlation.ExtractFromContext(ctx)
+	if correlationID == "" {
+		return
+	}
+
+	contextFields.mu.Lock()
+	defer contextFields.mu.Unlock()
+
+	current := contextFields.byCorrelationID[correlationID]
+	for key, value := range fields {
+		if value == "" || value == nil {
+			continue
+		}
+
+		if current == nil {
+			current = make(log.Fields, len(fields))
+			contextFields.byCorrelationID[correlationID] = current
+		}
+		current[key] = value
+	}
+}
+
+// RemoveContextFields forgets the fields added for the correlation ID of ctx
+func RemoveContextFields(ctx context.Context) {
+	contextFields.mu.Lock()
+	defer contextFields.mu.Unlock()
+
+	delete(contextFields.byCorrelationID, correlation.ExtractFromContext(ctx))
+}
+
+type contextFieldsHook struct{}
+
+func (contextFieldsHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire adds the context fields of the line, leaving those it already has
+func (contextFieldsHook) Fire(entry *logrus.Entry) error {
+	correlationID, _ := entry.Data[correlation.FieldName].(string)
+	if correlationID == "" {
+		return nil
+	}
+
+	contextFields.mu.RLock()
+	defer contextFields.mu.RUnlock()
+
+	for key, value := range contextFields.byCorrelationID[correlationID] {
+		if _, ok := entry.Data[key]; !ok {
+			entry.Data[key] = value
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// journaldSocket receives the entries in the native protocol of journald:
+// https://systemd.io/JOURNAL_NATIVE_PROTOCOL/
+var journaldSocket = "/run/systemd/journal/socket"
+
+// journaldSink sends the message and fields of the lines to journald, as
+// fields of their own
+type journaldSink struct {
+	conn *net.UnixConn
+	tag  string
+}
+
+func dialJournald(tag string) (*journaldSink, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to journald: %w", err)
+	}
+
+	return &journaldSink{conn: conn, tag: tag}, nil
+}
+
+func (s *journaldSink) send(entry *logrus.Entry, _ []byte) error {
+	var buf bytes.Buffer
+
+	appendJournalField(&buf, "MESSAGE", entry.Message)
+	appendJournalField(&buf, "PRIORITY", strconv.Itoa(severity(entry.Level)))
+	appendJournalField(&buf, "SYSLOG_IDENTIFIER", s.tag)
+
+	for key, value := range entry.Data {
+		appendJournalField(&buf, journalFieldName(key), fmt.Sprint(value))
+	}
+
+	_, err := s.conn.Write(buf.Bytes())
+
+	return err
+}
+
+// journalFieldName turns key into a field name, which only has uppercase
+// letters, digits and underscores, and doesn't start with an underscore,
+// as those are set by journald
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'):
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+
+	name = strings.TrimLeft(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "FIELD_" + name
+	}
+
+	return name
+}
+
+// appendJournalField encodes a field as NAME=value, or in the binary form
+// if value spans several lines
+func appendJournalField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+
+	if !strings.Contains(value, "\n") {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+
+		return
+	}
+
+	buf.WriteByte('\n')
+	_ = binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+func (s *journaldSink) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,150 @@
+package logger
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ComponentField names the component a line is logged by. Lines without it
+// are attributed to the word before the first colon of their message, such
+// as "session" for "session: handle: entering request loop".
+const ComponentField = "component"
+
+var componentRegex = regexp.MustCompile(`\A([a-z_]+):`)
+
+// levels holds the log levels: the base one and those of components, which
+// override it. They can be changed at runtime.
+var levels = struct {
+	mu         sync.RWMutex
+	base       logrus.Level
+	configured logrus.Level
+	components map[string]logrus.Level
+}{base: logrus.InfoLevel, configured: logrus.InfoLevel}
+
+// setLevels replaces the levels with those of the config
+func setLevels(base string, components map[string]string) error {
+	baseLevel, err := logrus.ParseLevel(base)
+	if err != nil {
+		return fmt.Errorf("invalid log level: %w", err)
+	}
+
+	componentLevels := make(map[string]logrus.Level, len(components))
+	for component, level := range components {
+		componentLevels[component], err = logrus.ParseLevel(level)
+		if err != nil {
+			return fmt.Errorf("invalid log level of %s: %w", component, err)
+		}
+	}
+
+	levels.mu.Lock()
+	defer levels.mu.Unlock()
+
+	levels.base, levels.configured, levels.components = baseLevel, baseLevel, componentLevels
+	applyLevels()
+
+	return nil
+}
+
+// SetLevel changes the level of component, or the base level if component is
+// empty, until the next reload or restart
+func SetLevel(component, level string) error {
+	parsed, err := logrus.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("invalid log level: %w", err)
+	}
+
+	levels.mu.Lock()
+	defer levels.mu.Unlock()
+
+	if component == "" {
+		levels.base = parsed
+	} else {
+		if levels.components == nil {
+			levels.components = make(map[string]logrus.Level)
+		}
+		levels.components[component] = parsed
+	}
+	applyLevels()
+
+	return nil
+}
+
+// ToggleDebug switches the base level to debug, or back to the configured
+// one if it's debug already, and returns the new level
+func ToggleDebug() string {
+	levels.mu.Lock()
+	defer levels.mu.Unlock()
+
+	if levels.base == logrus.DebugLevel && levels.configured != logrus.DebugLevel {
+		levels.base = levels.configured
+	} else {
+		levels.base = logrus.DebugLevel
+	}
+	applyLevels()
+
+	return levels.base.String()
+}
+
+// Levels returns the base level, named "", and those of the components
+func Levels() map[string]string {
+	levels.mu.RLock()
+	defer levels.mu.RUnlock()
+
+	current := map[string]string{"": levels.base.String()}
+	for component, level := range levels.components {
+		current[component] = level.String()
+	}
+
+	return current
+}
+
+// applyLevels sets the level of the logger to the most verbose one, leaving
+// the others to levelFormatter. levels.mu must be held.
+func applyLevels() {
+	verbose := levels.base
+	for _, level := range levels.components {
+		verbose = max(verbose, level)
+	}
+
+	logrus.StandardLogger().SetLevel(verbose)
+}
+
+func enabled(entry *logrus.Entry) bool {
+	levels.mu.RLock()
+	defer levels.mu.RUnlock()
+
+	level, ok := levels.components[component(entry)]
+	if !ok {
+		level = levels.base
+	}
+
+	return entry.Level <= level
+}
+
+func component(entry *logrus.Entry) string {
+	if name, ok := entry.Data[ComponentField].(string); ok {
+		return name
+	}
+
+	if match := componentRegex.FindStringSubmatch(entry.Message); match != nil {
+		return match[1]
+	}
+
+	return ""
+}
+
+// levelFormatter drops the lines below the level of their component
+type levelFormatter struct {
+	logrus.Formatter
+}
+
+func (f *levelFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	if !enabled(entry) {
+		return nil, nil
+	}
+
+	return f.Formatter.Format(entry)
+}
@@ -1,17 +1,25 @@
 package logger
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log/syslog"
 	"os"
+	"sync"
 	"time"
 
+	"github.com/sirupsen/logrus"
 	"gitlab.com/gitlab-org/labkit/log"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 )
 
+// formatLogfmt is labkit's text format, without colors even on a terminal
+const formatLogfmt = "logfmt"
+
+var addHookOnce sync.Once
+
 func logFmt(inFmt string) string {
 	// Hide the "combined" format, since that makes no sense in gitlab-shell.
 	// The default is JSON when unspecified.
@@ -39,23 +47,94 @@ func logFile(inFile string) string {
 }
 
 func buildOpts(cfg *config.Config) []log.LoggerOption {
+	format := logFmt(cfg.LogFormat)
+	if format == formatLogfmt {
+		format = "text"
+	}
+
 	return []log.LoggerOption{
-		log.WithFormatter(logFmt(cfg.LogFormat)),
+		log.WithFormatter(format),
 		log.WithOutputName(logFile(cfg.LogFile)),
 		log.WithTimezone(time.UTC),
 		log.WithLogLevel(logLevel(cfg.LogLevel)),
 	}
 }
 
+// initialize configures labkit to write to LogFile, through a rotatingFile if
+// log_rotation is enabled
+func initialize(cfg *config.Config) (io.Closer, error) {
+	opts := buildOpts(cfg)
+
+	path := logFile(cfg.LogFile)
+	if cfg.LogRotation.MaxSizeMB <= 0 || path == "stdout" || path == "stderr" {
+		return log.Initialize(opts...)
+	}
+
+	file, err := openRotatingFile(path, cfg.LogRotation)
+	if err != nil {
+		return nil, err
+	}
+
+	closer, err := log.Initialize(append(opts, log.WithWriter(file))...)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return closerFunc(func() error {
+		return errors.Join(closer.Close(), file.Close())
+	}), nil
+}
+
+// install applies the settings labkit doesn't support: the logfmt format, the
+// levels of components and the context fields
+func install(cfg *config.Config) {
+	std := logrus.StandardLogger()
+
+	if logFmt(cfg.LogFormat) == formatLogfmt {
+		std.SetFormatter(&utcFormatter{Formatter: &logrus.TextFormatter{DisableColors: true, FullTimestamp: true, TimestampFormat: time.RFC3339}})
+	}
+	std.SetFormatter(&levelFormatter{Formatter: std.Formatter})
+
+	addHookOnce.Do(func() {
+		// The context fields are added before the lines reach the sink
+		std.AddHook(contextFieldsHook{})
+		std.AddHook(sinkHook)
+	})
+	sinkHook.set(nil)
+
+	if err := setLevels(logLevel(cfg.LogLevel), cfg.LogLevels); err != nil {
+		// labkit already warned about an invalid base level, and ignored it
+		_ = setLevels(std.GetLevel().String(), nil)
+		log.WithError(err).Warn("Unable to configure the log levels of components, ignoring them")
+	}
+}
+
+type utcFormatter struct {
+	logrus.Formatter
+}
+
+func (f *utcFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	entryCopy := *entry
+	entryCopy.Time = entryCopy.Time.UTC()
+
+	return f.Formatter.Format(&entryCopy)
+}
+
 // Configure configures the logging singleton for operation inside a remote TTY (like SSH). In this
 // mode an empty LogFile is not accepted and syslog is used as a fallback when LogFile could not be
 // opened for writing.
 func Configure(cfg *config.Config) io.Closer {
+	sinkCloser, sinkErr := configureSink(cfg)
+	if sinkCloser != nil {
+		return sinkCloser
+	}
+
 	var closer io.Closer = io.NopCloser(nil)
 	err := fmt.Errorf("No logfile specified")
 
 	if cfg.LogFile != "" {
-		closer, err = log.Initialize(buildOpts(cfg)...)
+		closer, err = initialize(cfg)
 	}
 
 	if err != nil {
@@ -76,6 +155,9 @@ func Configure(cfg *config.Config) io.Closer {
 		}
 	}
 
+	install(cfg)
+	warnSinkFailure(sinkErr)
+
 	return closer
 }
 
@@ -83,7 +165,12 @@ func Configure(cfg *config.Config) io.Closer {
 // empty LogFile is treated as logging to stderr, and standard output is used as a fallback
 // when LogFile could not be opened for writing.
 func ConfigureStandalone(cfg *config.Config) io.Closer {
-	closer, err1 := log.Initialize(buildOpts(cfg)...)
+	sinkCloser, sinkErr := configureSink(cfg)
+	if sinkCloser != nil {
+		return sinkCloser
+	}
+
+	closer, err1 := initialize(cfg)
 	if err1 != nil {
 		var err2 error
 
@@ -99,5 +186,8 @@ func ConfigureStandalone(cfg *config.Config) io.Closer {
 		}
 	}
 
+	install(cfg)
+	warnSinkFailure(sinkErr)
+
 	return closer
 }
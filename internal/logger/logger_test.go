@@ -1,11 +1,14 @@
 package logger
 
 import (
+	"context"
 	"os"
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/labkit/correlation"
 	"gitlab.com/gitlab-org/labkit/log"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
@@ -89,6 +92,124 @@ func TestLogInUTC(t *testing.T) {
 	require.True(t, r)
 }
 
+func TestLogfmt(t *testing.T) {
+	tmpFile := createTempFile(t)
+
+	config := config.Config{
+		LogFile:   tmpFile,
+		LogFormat: "logfmt",
+	}
+
+	closer := Configure(&config)
+	defer closer.Close()
+
+	log.WithFields(log.Fields{"key": "value with spaces"}).Info("this is a test")
+
+	data, err := os.ReadFile(tmpFile)
+	require.NoError(t, err)
+	require.Regexp(t, `\Atime="[0-9-]+T[0-9:]+Z" level=info msg="this is a test" key="value with spaces"\n\z`, string(data))
+}
+
+func TestComponentLogLevels(t *testing.T) {
+	tmpFile := createTempFile(t)
+
+	config := config.Config{
+		LogFile:   tmpFile,
+		LogFormat: "json",
+		LogLevel:  "warn",
+		LogLevels: map[string]string{"session": "debug"},
+	}
+
+	closer := Configure(&config)
+	defer closer.Close()
+
+	log.WithFields(log.Fields{}).Debug("session: handle: debug message")
+	log.WithField(ComponentField, "session").Debug("tagged debug message")
+	log.WithFields(log.Fields{}).Info("server: handleConn: info message")
+	log.WithFields(log.Fields{}).Warn("server: handleConn: warning message")
+
+	data, err := os.ReadFile(tmpFile)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"msg":"session: handle: debug message"`)
+	require.Contains(t, string(data), `"msg":"tagged debug message"`)
+	require.NotContains(t, string(data), `"msg":"server: handleConn: info message"`)
+	require.Contains(t, string(data), `"msg":"server: handleConn: warning message"`)
+}
+
+func TestSetLevel(t *testing.T) {
+	tmpFile := createTempFile(t)
+
+	config := config.Config{
+		LogFile:   tmpFile,
+		LogFormat: "json",
+	}
+
+	closer := Configure(&config)
+	defer closer.Close()
+
+	require.Error(t, SetLevel("", "loud"))
+	require.NoError(t, SetLevel("server", "error"))
+	require.NoError(t, SetLevel("", "debug"))
+	require.Equal(t, map[string]string{"": "debug", "server": "error"}, Levels())
+
+	log.WithFields(log.Fields{}).Debug("debug message")
+	log.WithFields(log.Fields{}).Warn("server: handleConn: warning message")
+
+	data, err := os.ReadFile(tmpFile)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"msg":"debug message"`)
+	require.NotContains(t, string(data), `warning message`)
+}
+
+func TestToggleDebug(t *testing.T) {
+	config := config.Config{
+		LogFile:   createTempFile(t),
+		LogFormat: "json",
+	}
+
+	closer := Configure(&config)
+	defer closer.Close()
+
+	require.Equal(t, "debug", ToggleDebug())
+	require.Equal(t, "debug", Levels()[""])
+	require.Equal(t, "info", ToggleDebug())
+	require.Equal(t, "info", Levels()[""])
+}
+
+func TestContextFields(t *testing.T) {
+	tmpFile := createTempFile(t)
+
+	config := config.Config{
+		LogFile:   tmpFile,
+		LogFormat: "json",
+	}
+
+	closer := Configure(&config)
+	defer closer.Close()
+
+	ctx := correlation.ContextWithCorrelation(context.Background(), "the-correlation-id")
+	AddContextFields(ctx, log.Fields{"remote_ip": "127.0.0.1", "username": ""})
+	AddContextFields(ctx, log.Fields{"username": "alex-doe"})
+
+	log.ContextLogger(ctx).Info("first message")
+	log.WithContextFields(ctx, log.Fields{"username": "other"}).Info("second message")
+	log.Info("third message")
+
+	RemoveContextFields(ctx)
+	log.ContextLogger(ctx).Info("fourth message")
+
+	data, err := os.ReadFile(tmpFile)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 4)
+	require.Contains(t, lines[0], `"remote_ip":"127.0.0.1"`)
+	require.Contains(t, lines[0], `"username":"alex-doe"`)
+	require.Contains(t, lines[1], `"username":"other"`)
+	require.NotContains(t, lines[2], "remote_ip")
+	require.NotContains(t, lines[3], "remote_ip")
+}
+
 func createTempFile(t *testing.T) string {
 	t.Helper()
 
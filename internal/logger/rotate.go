@@ -0,0 +1,274 @@
+package logger
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+const (
+	// backupTimestamp is sortable, and has no colons, which some tools
+	// don't accept in file names
+	backupTimestamp = "2006-01-02T15-04-05.000"
+	compressedExt   = ".gz"
+)
+
+// rotatingFile is a log file that is rotated once it grows beyond maxSize.
+// gitlab-shell runs a process per command, all appending to the same file:
+// the process rotating the file holds a lock on it, and the others open the
+// new file once they notice it was moved.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+	now        func() time.Time
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	hup    chan os.Signal
+	closed bool
+}
+
+func openRotatingFile(path string, cfg config.LogRotationConfig) (*rotatingFile, error) {
+	f := &rotatingFile{
+		path:       path,
+		maxSize:    cfg.MaxSizeMB * 1024 * 1024,
+		maxAge:     time.Duration(cfg.MaxAge),
+		maxBackups: cfg.MaxBackups,
+		compress:   cfg.Compress,
+		now:        time.Now,
+	}
+
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	// Reopen the file for external rotators too, as labkit does
+	f.hup = make(chan os.Signal, 1)
+	signal.Notify(f.hup, syscall.SIGHUP)
+	go func() {
+		for range f.hup {
+			_ = f.Reopen()
+		}
+	}()
+
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	f.file, f.size = file, info.Size()
+
+	return nil
+}
+
+// Reopen opens the file at the path again, e.g. once it was moved
+func (f *rotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil
+	}
+
+	_ = f.file.Close()
+
+	return f.open()
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	if f.moved() {
+		_ = f.file.Close()
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+
+	return n, err
+}
+
+// moved reports whether the path no longer is the open file, e.g. because
+// another process rotated it. The size is refreshed otherwise, since other
+// processes write to the file too.
+func (f *rotatingFile) moved() bool {
+	pathInfo, err := os.Stat(f.path)
+	if err != nil {
+		return true
+	}
+
+	fileInfo, err := f.file.Stat()
+	if err != nil || !os.SameFile(pathInfo, fileInfo) {
+		return true
+	}
+
+	f.size = fileInfo.Size()
+
+	return false
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := syscall.Flock(int(f.file.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock the log file: %w", err)
+	}
+
+	now := f.now()
+
+	// Another process may have rotated the file while this one waited
+	if !f.moved() && f.size > 0 {
+		backup := f.path + "." + now.UTC().Format(backupTimestamp)
+		if err := os.Rename(f.path, backup); err != nil {
+			_ = syscall.Flock(int(f.file.Fd()), syscall.LOCK_UN)
+			return fmt.Errorf("failed to rotate the log file: %w", err)
+		}
+	}
+
+	_ = syscall.Flock(int(f.file.Fd()), syscall.LOCK_UN)
+	_ = f.file.Close()
+
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	f.cleanUp(now)
+
+	return nil
+}
+
+// cleanUp compresses the backups and removes those beyond the retention.
+// Errors are ignored, as there's nowhere to log them.
+func (f *rotatingFile) cleanUp(now time.Time) {
+	backups := f.backups()
+
+	var expired []string
+	if f.maxBackups > 0 && len(backups) > f.maxBackups {
+		expired, backups = backups[:len(backups)-f.maxBackups], backups[len(backups)-f.maxBackups:]
+	}
+
+	for _, backup := range backups {
+		if f.maxAge > 0 && now.Sub(backupTime(f.path, backup)) > f.maxAge {
+			expired = append(expired, backup)
+			continue
+		}
+
+		if f.compress && !strings.HasSuffix(backup, compressedExt) {
+			_ = compressFile(backup)
+		}
+	}
+
+	for _, backup := range expired {
+		_ = os.Remove(backup)
+	}
+}
+
+// backups lists the rotated files, oldest first
+func (f *rotatingFile) backups() []string {
+	matches, _ := filepath.Glob(f.path + ".*")
+
+	backups := slices.DeleteFunc(matches, func(match string) bool {
+		return backupTime(f.path, match).IsZero()
+	})
+	slices.Sort(backups)
+
+	return backups
+}
+
+// backupTime returns the time a backup was rotated, or the zero time if the
+// file isn't a backup
+func backupTime(path, backup string) time.Time {
+	timestamp := strings.TrimSuffix(strings.TrimPrefix(backup, path+"."), compressedExt)
+
+	rotatedAt, err := time.Parse(backupTimestamp, timestamp)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return rotatedAt
+}
+
+// compressFile replaces path with its gzipped copy. The copy is written to a
+// temporary file, so that others processes never see a partial one.
+func compressFile(path string) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	gz := gzip.NewWriter(tmp)
+	_, copyErr := io.Copy(gz, src)
+	err = errors.Join(copyErr, gz.Close(), tmp.Close())
+	if err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), path+compressedExt); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+
+	signal.Stop(f.hup)
+	close(f.hup)
+
+	return f.file.Close()
+}
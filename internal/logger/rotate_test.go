@@ -0,0 +1,147 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func openTestRotatingFile(t *testing.T, cfg config.LogRotationConfig) (*rotatingFile, string) {
+	path := filepath.Join(t.TempDir(), "gitlab-shell.log")
+
+	f, err := openRotatingFile(path, cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	// Rotate every two lines
+	f.maxSize = 20
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	f.now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+
+	return f, path
+}
+
+func writeLines(t *testing.T, f *rotatingFile, lines ...string) {
+	for _, line := range lines {
+		_, err := f.Write([]byte(line + "\n"))
+		require.NoError(t, err)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	f, path := openTestRotatingFile(t, config.LogRotationConfig{MaxBackups: 2})
+
+	writeLines(t, f, "line 1", "line 2", "line 3", "line 4", "line 5", "line 6", "line 7")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "line 7\n", string(data))
+
+	backups := f.backups()
+	require.Len(t, backups, 2)
+	require.Equal(t, path+".2026-01-01T00-02-00.000", backups[0])
+
+	data, err = os.ReadFile(backups[0])
+	require.NoError(t, err)
+	require.Equal(t, "line 3\nline 4\n", string(data))
+
+	data, err = os.ReadFile(backups[1])
+	require.NoError(t, err)
+	require.Equal(t, "line 5\nline 6\n", string(data))
+}
+
+func TestRotatingFileMaxAge(t *testing.T) {
+	f, _ := openTestRotatingFile(t, config.LogRotationConfig{MaxAge: config.YamlDuration(90 * time.Second)})
+
+	writeLines(t, f, "line 1", "line 2", "line 3", "line 4", "line 5", "line 6", "line 7")
+
+	// The first backup is two minutes old by the time of the third rotation
+	backups := f.backups()
+	require.Len(t, backups, 2)
+	require.True(t, strings.HasSuffix(backups[0], "T00-02-00.000"))
+}
+
+func TestRotatingFileCompress(t *testing.T) {
+	f, path := openTestRotatingFile(t, config.LogRotationConfig{Compress: true})
+
+	writeLines(t, f, "line 1", "line 2", "line 3")
+
+	backups := f.backups()
+	require.Equal(t, []string{path + ".2026-01-01T00-01-00.000.gz"}, backups)
+
+	file, err := os.Open(backups[0])
+	require.NoError(t, err)
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	require.Equal(t, "line 1\nline 2\n", string(data))
+
+	matches, err := filepath.Glob(path + ".*.tmp*")
+	require.NoError(t, err)
+	require.Empty(t, matches)
+}
+
+func TestRotatingFileRotatedElsewhere(t *testing.T) {
+	f, path := openTestRotatingFile(t, config.LogRotationConfig{})
+
+	writeLines(t, f, "line 1")
+	require.NoError(t, os.Rename(path, path+".old"))
+	writeLines(t, f, "line 2")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "line 2\n", string(data))
+
+	data, err = os.ReadFile(path + ".old")
+	require.NoError(t, err)
+	require.Equal(t, "line 1\n", string(data))
+}
+
+func TestRotatingFileReopen(t *testing.T) {
+	f, path := openTestRotatingFile(t, config.LogRotationConfig{})
+
+	writeLines(t, f, "line 1")
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, f.Reopen())
+	writeLines(t, f, "line 2")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "line 2\n", string(data))
+}
+
+func TestConfigureWithLogRotation(t *testing.T) {
+	tmpFile := createTempFile(t)
+
+	config := config.Config{
+		LogFile:     tmpFile,
+		LogFormat:   "json",
+		LogRotation: config.LogRotationConfig{MaxSizeMB: 1},
+	}
+
+	closer := Configure(&config)
+	defer closer.Close()
+
+	log.Info("this is a test")
+
+	data, err := os.ReadFile(tmpFile)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"msg":"this is a test"`)
+}
@@ -0,0 +1,144 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+// Log sinks that LogFile can be replaced with
+const (
+	SinkSyslog   = "syslog"
+	SinkJournald = "journald"
+)
+
+// sink receives every line logged, formatted as configured
+type sink interface {
+	send(entry *logrus.Entry, line []byte) error
+	Close() error
+}
+
+var sinkHook = &logSinkHook{}
+
+// logSinkHook sends the lines to the sink, if any. Lines that fail to be
+// sent are dropped: logrus would print the error to stderr, which is the
+// terminal of the user in gitlab-shell.
+type logSinkHook struct {
+	mu   sync.Mutex
+	sink sink
+}
+
+func (h *logSinkHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *logSinkHook) Fire(entry *logrus.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.sink == nil {
+		return nil
+	}
+
+	line, err := entry.Logger.Formatter.Format(entry)
+	if err != nil || len(line) == 0 {
+		return nil
+	}
+
+	_ = h.sink.send(entry, line)
+
+	return nil
+}
+
+// set replaces the sink, closing the previous one
+func (h *logSinkHook) set(s sink) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.sink != nil {
+		_ = h.sink.Close()
+	}
+	h.sink = s
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// configureSink sends the logs to the sink of the config instead of a file.
+// It returns a nil io.Closer if there's no sink, or it fails to be opened.
+func configureSink(cfg *config.Config) (io.Closer, error) {
+	if cfg.LogSink.Type == "" {
+		return nil, nil
+	}
+
+	s, err := openSink(cfg.LogSink)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := log.Initialize(append(buildOpts(cfg), log.WithWriter(io.Discard))...); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+
+	install(cfg)
+	sinkHook.set(s)
+
+	return closerFunc(func() error {
+		sinkHook.set(nil)
+		return nil
+	}), nil
+}
+
+// warnSinkFailure logs why the logs go to a file rather than the sink, once
+// the logger is configured
+func warnSinkFailure(err error) {
+	if err != nil {
+		log.WithError(err).Warn("Unable to configure the log sink, logging to log_file instead")
+	}
+}
+
+func openSink(cfg config.LogSinkConfig) (sink, error) {
+	tag := cfg.Tag
+	if tag == "" {
+		tag = filepath.Base(os.Args[0])
+	}
+
+	switch cfg.Type {
+	case SinkSyslog:
+		return dialSyslog(cfg.Syslog, tag)
+	case SinkJournald:
+		return dialJournald(tag)
+	default:
+		return nil, fmt.Errorf("unknown log sink %q", cfg.Type)
+	}
+}
+
+// severity maps the levels of logrus to the severities shared by syslog and
+// journald
+func severity(level logrus.Level) int {
+	switch level {
+	case logrus.PanicLevel:
+		return 0 // emerg
+	case logrus.FatalLevel:
+		return 2 // crit
+	case logrus.ErrorLevel:
+		return 3 // err
+	case logrus.WarnLevel:
+		return 4 // warning
+	case logrus.InfoLevel:
+		return 6 // info
+	default:
+		return 7 // debug
+	}
+}
@@ -0,0 +1,153 @@
+package logger
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func listenUnixgram(t *testing.T) (*net.UnixConn, string) {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn, socket
+}
+
+func readDatagram(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+
+	buf := make([]byte, 65536)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+
+	return string(buf[:n])
+}
+
+func TestLocalSyslogSink(t *testing.T) {
+	conn, socket := listenUnixgram(t)
+
+	cfg := &config.Config{
+		LogFormat: "json",
+		LogSink: config.LogSinkConfig{
+			Type:   SinkSyslog,
+			Tag:    "gitlab-shell",
+			Syslog: config.SyslogConfig{Address: socket, Facility: "local0"},
+		},
+	}
+
+	closer := Configure(cfg)
+	defer closer.Close()
+
+	log.WithFields(log.Fields{"key": "value"}).Warn("this is a test")
+
+	message := readDatagram(t, conn)
+	// local0 is 16, warning is 4
+	require.Regexp(t, `\A<132>1 \S+Z \S+ gitlab-shell \d+ - - \{.*"msg":"this is a test".*\}\z`, message)
+	require.Contains(t, message, `"key":"value"`)
+}
+
+func TestRemoteSyslogSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	cfg := &config.Config{
+		LogFormat: "logfmt",
+		LogSink: config.LogSinkConfig{
+			Type:   SinkSyslog,
+			Tag:    "gitlab-sshd",
+			Syslog: config.SyslogConfig{Network: "tcp", Address: listener.Addr().String()},
+		},
+	}
+
+	closer := ConfigureStandalone(cfg)
+	defer closer.Close()
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	log.WithFields(log.Fields{}).Error("this is a test")
+
+	reader := bufio.NewReader(conn)
+	length, err := reader.ReadString(' ')
+	require.NoError(t, err)
+
+	size, err := strconv.Atoi(strings.TrimSpace(length))
+	require.NoError(t, err)
+
+	message := make([]byte, size)
+	_, err = reader.Read(message)
+	require.NoError(t, err)
+
+	// user is 1, err is 3
+	require.Regexp(t, `\A<11>1 \S+Z \S+ gitlab-sshd \d+ - - time=\S+ level=error msg="this is a test"\z`, string(message))
+}
+
+func TestJournaldSink(t *testing.T) {
+	conn, socket := listenUnixgram(t)
+
+	defaultSocket := journaldSocket
+	journaldSocket = socket
+	t.Cleanup(func() { journaldSocket = defaultSocket })
+
+	cfg := &config.Config{
+		LogSink: config.LogSinkConfig{Type: SinkJournald, Tag: "gitlab-shell"},
+	}
+
+	closer := Configure(cfg)
+	defer closer.Close()
+
+	log.WithFields(log.Fields{"remote_ip": "127.0.0.1", "_private": "a\nb"}).Info("this is a test")
+
+	entry := readDatagram(t, conn)
+	require.Contains(t, entry, "MESSAGE=this is a test\n")
+	require.Contains(t, entry, "PRIORITY=6\n")
+	require.Contains(t, entry, "SYSLOG_IDENTIFIER=gitlab-shell\n")
+	require.Contains(t, entry, "REMOTE_IP=127.0.0.1\n")
+
+	multiline := binary.LittleEndian.AppendUint64([]byte("PRIVATE\n"), 3)
+	require.Contains(t, entry, string(multiline)+"a\nb\n")
+}
+
+func TestSinkFallback(t *testing.T) {
+	tmpFile := createTempFile(t)
+
+	cfg := &config.Config{
+		LogFile:   tmpFile,
+		LogFormat: "json",
+		LogSink:   config.LogSinkConfig{Type: SinkSyslog, Syslog: config.SyslogConfig{Address: filepath.Join(t.TempDir(), "missing")}},
+	}
+
+	closer := Configure(cfg)
+	defer closer.Close()
+
+	log.Info("this is a test")
+
+	contents, err := os.ReadFile(tmpFile)
+	require.NoError(t, err)
+	data := string(contents)
+	require.Contains(t, data, `"msg":"Unable to configure the log sink, logging to log_file instead"`)
+	require.Contains(t, data, `"msg":"this is a test"`)
+}
+
+func TestJournalFieldName(t *testing.T) {
+	require.Equal(t, "REMOTE_IP", journalFieldName("remote_ip"))
+	require.Equal(t, "CORRELATION_ID", journalFieldName("correlation-id"))
+	require.Equal(t, "PRIVATE", journalFieldName("__private"))
+	require.Equal(t, "FIELD_1ST", journalFieldName("1st"))
+}
@@ -0,0 +1,222 @@
+package logger
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+const (
+	syslogNetworkUnix = "unix"
+	syslogNetworkTCP  = "tcp"
+	syslogNetworkTLS  = "tls"
+
+	syslogTimeout = 5 * time.Second
+	// syslogTimestamp is RFC 3339 with the microseconds allowed by RFC 5424
+	syslogTimestamp = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// localSyslogSockets are tried in turn when no address is configured
+var localSyslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSink sends RFC 5424 messages to a syslog daemon. Messages sent over
+// a stream are framed by their length, as RFC 6587 describes, except over a
+// local stream socket, where they end with a newline.
+type syslogSink struct {
+	network   string
+	addresses []string
+	tlsConfig *tls.Config
+	facility  int
+	hostname  string
+	tag       string
+	pid       int
+
+	conn     net.Conn
+	datagram bool
+}
+
+func dialSyslog(cfg config.SyslogConfig, tag string) (*syslogSink, error) {
+	s := &syslogSink{network: cfg.Network, tag: tag, pid: os.Getpid(), hostname: "-"}
+
+	if s.network == "" {
+		s.network = syslogNetworkUnix
+	}
+
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		s.hostname = hostname
+	}
+
+	facility := cfg.Facility
+	if facility == "" {
+		facility = "user"
+	}
+
+	var ok bool
+	if s.facility, ok = syslogFacilities[facility]; !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+
+	switch s.network {
+	case syslogNetworkUnix:
+		s.addresses = localSyslogSockets
+		if cfg.Address != "" {
+			s.addresses = []string{cfg.Address}
+		}
+	case syslogNetworkTCP, syslogNetworkTLS:
+		if cfg.Address == "" {
+			return nil, fmt.Errorf("no address given for syslog over %s", s.network)
+		}
+		s.addresses = []string{cfg.Address}
+	default:
+		return nil, fmt.Errorf("unknown syslog network %q", s.network)
+	}
+
+	if s.network == syslogNetworkTLS {
+		var err error
+		if s.tlsConfig, err = syslogTLSConfig(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func syslogTLSConfig(cfg config.SyslogConfig) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog address: %w", err)
+	}
+
+	tlsConfig := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the syslog CA file: %w", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate found in the syslog CA file")
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+func (s *syslogSink) connect() error {
+	dialer := &net.Dialer{Timeout: syslogTimeout}
+
+	switch s.network {
+	case syslogNetworkTCP:
+		conn, err := dialer.Dial("tcp", s.addresses[0])
+		if err != nil {
+			return fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		s.conn = conn
+	case syslogNetworkTLS:
+		conn, err := tls.DialWithDialer(dialer, "tcp", s.addresses[0], s.tlsConfig)
+		if err != nil {
+			return fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		s.conn = conn
+	default:
+		return s.connectLocal(dialer)
+	}
+
+	return nil
+}
+
+func (s *syslogSink) connectLocal(dialer *net.Dialer) error {
+	var err error
+
+	for _, address := range s.addresses {
+		for _, network := range []string{"unixgram", "unix"} {
+			var conn net.Conn
+			if conn, err = dialer.Dial(network, address); err == nil {
+				s.conn, s.datagram = conn, network == "unixgram"
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("failed to connect to the local syslog: %w", err)
+}
+
+// send writes the message, connecting again once if the connection was lost
+func (s *syslogSink) send(entry *logrus.Entry, line []byte) error {
+	message := s.frame(s.format(entry, line))
+
+	for attempt := 0; ; attempt++ {
+		if s.conn == nil {
+			if err := s.connect(); err != nil {
+				return err
+			}
+		}
+
+		_ = s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		_, err := s.conn.Write(message)
+		if err == nil || attempt > 0 {
+			return err
+		}
+
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// format builds the RFC 5424 message of a line, without structured data
+func (s *syslogSink) format(entry *logrus.Entry, line []byte) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "<%d>1 %s %s %s %d - - ",
+		s.facility*8+severity(entry.Level),
+		entry.Time.UTC().Format(syslogTimestamp),
+		s.hostname,
+		s.tag,
+		s.pid,
+	)
+	buf.Write(bytes.TrimRight(line, "\n"))
+
+	return buf.Bytes()
+}
+
+func (s *syslogSink) frame(message []byte) []byte {
+	switch {
+	case s.datagram:
+		return message
+	case s.network == syslogNetworkUnix:
+		return append(message, '\n')
+	default:
+		return append([]byte(strconv.Itoa(len(message))+" "), message...)
+	}
+}
+
+func (s *syslogSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+
+	return s.conn.Close()
+}
@@ -6,13 +6,18 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
 )
 
 const (
-	namespace       = "gitlab_shell"
-	sshdSubsystem   = "sshd"
-	httpSubsystem   = "http"
-	gitalySubsystem = "gitaly"
+	namespace         = "gitlab_shell"
+	sshdSubsystem     = "sshd"
+	httpSubsystem     = "http"
+	gitalySubsystem   = "gitaly"
+	discoverSubsystem = "discover"
+	checkSubsystem    = "check"
+	accessSubsystem   = "access"
 
 	httpInFlightRequestsMetricName       = "in_flight_requests"
 	httpRequestsTotalMetricName          = "requests_total"
@@ -23,11 +28,43 @@ const (
 	sshdSessionDurationSecondsName            = "session_duration_seconds"
 	sshdSessionEstablishedDurationSecondsName = "session_established_duration_seconds"
 	sshdCanceledSessionsName                  = "canceled_sessions"
+	sshdSessionsInFlightName                  = "in_flight_sessions"
+	sshdSessionsTotalName                     = "sessions_total"
+	sshdHandshakeDurationSecondsName          = "handshake_duration_seconds"
+	sshdHandshakeFailuresTotalName            = "handshake_failures_total"
+	sshdRejectedConnectionsTotalName          = "rejected_connections_total"
+	sshdLimitedCommandsTotalName              = "limited_commands_total"
+	sshdDrainingName                          = "draining"
+	sshdDrainTimedOutConnectionsTotalName     = "drain_timed_out_connections_total"
+	sshdConfigReloadsTotalName                = "config_reloads_total"
+	sshdSessionTimeoutsTotalName              = "session_timeouts_total"
+	sshdThrottledStreamsName                  = "throttled_streams"
+	sshdThrottledSecondsTotalName             = "throttled_seconds_total"
+	sshdAuthorizedKeysFallbackTotalName       = "authorized_keys_fallback_total"
+	sshdExternalAuthTotalName                 = "external_authentications_total"
+	sshdClientAliveTimeoutsTotalName          = "client_alive_timeouts_total"
+	sshdConnectionSessionsName                = "connection_sessions"
+	sshdTarpitConnectionsName                 = "tarpit_connections"
+	sshdTarpitConnectionsTotalName            = "tarpit_connections_total"
+	sshdConnectionStageDurationSecondsName    = "connection_stage_duration_seconds"
 
 	sliSshdSessionsTotalName       = "gitlab_sli:shell_sshd_sessions:total"
 	sliSshdSessionsErrorsTotalName = "gitlab_sli:shell_sshd_sessions:errors_total"
 
-	gitalyConnectionsTotalName = "connections_total"
+	discoverCacheLookupsTotalName = "cache_lookups_total"
+
+	accessCacheLookupsTotalName       = "cache_lookups_total"
+	accessCacheInvalidationsTotalName = "cache_invalidations_total"
+	accessTransferQuotaExceededName   = "transfer_quota_exceeded_total"
+
+	gitalyConnectionsTotalName         = "connections_total"
+	gitalyConnectionEvictionsTotalName = "connection_evictions_total"
+	gitalyWriteStallSecondsName        = "write_stall_seconds"
+	gitalyStalledTransfersTotalName    = "stalled_transfers_total"
+
+	checkHealthyName                 = "healthy"
+	checkClockSkewSecondsName        = "clock_skew_seconds"
+	checkLastRunTimestampSecondsName = "last_run_timestamp_seconds"
 )
 
 var (
@@ -68,6 +105,207 @@ var (
 		},
 	)
 
+	SshdSessionsInFlight = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdSessionsInFlightName,
+			Help:      "A gauge of sessions currently being served by gitlab-shell sshd.",
+		},
+	)
+
+	SshdSessionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdSessionsTotalName,
+			Help:      "The number of sessions running a command in gitlab-shell sshd, by command.",
+		},
+		[]string{"command"},
+	)
+
+	SshdHandshakeDuration = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdHandshakeDurationSecondsName,
+			Help:      "A histogram of latencies of successful SSH handshakes, including authentication, in gitlab-shell sshd.",
+			Buckets: []float64{
+				0.01, /* 10ms */
+				0.05, /* 50ms */
+				0.1,  /* 100ms */
+				0.5,  /* 500ms */
+				1.0,  /* 1s */
+				5.0,  /* 5s */
+			},
+		},
+	)
+
+	SshdHandshakeFailuresTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdHandshakeFailuresTotalName,
+			Help:      "The number of SSH handshakes that failed in gitlab-shell sshd.",
+		},
+	)
+
+	SshdRejectedConnectionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdRejectedConnectionsTotalName,
+			Help:      "The number of connections refused by the connection limits and the IP filter of gitlab-shell sshd, by reason.",
+		},
+		[]string{"reason"},
+	)
+
+	SshdLimitedCommandsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdLimitedCommandsTotalName,
+			Help:      "The number of git commands refused by the command limits of gitlab-shell sshd, by command and limit.",
+		},
+		[]string{"command", "limit"},
+	)
+
+	SshdSessionTimeoutsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdSessionTimeoutsTotalName,
+			Help:      "The number of sessions closed by gitlab-shell sshd for exceeding a timeout, by reason.",
+		},
+		[]string{"reason"},
+	)
+
+	SshdThrottledStreams = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdThrottledStreamsName,
+			Help:      "The number of session streams of gitlab-shell sshd currently waiting for a bandwidth limit, by limit.",
+		},
+		[]string{"limit"},
+	)
+
+	SshdThrottledSecondsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdThrottledSecondsTotalName,
+			Help:      "The time session streams of gitlab-shell sshd spent waiting for a bandwidth limit, by limit.",
+		},
+		[]string{"limit"},
+	)
+
+	SshdDraining = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdDrainingName,
+			Help:      "Set to 1 while gitlab-shell sshd is draining its connections before shutting down.",
+		},
+	)
+
+	SshdDrainTimedOutConnectionsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdDrainTimedOutConnectionsTotalName,
+			Help:      "The number of connections still open when gitlab-shell sshd stopped waiting for them to drain.",
+		},
+	)
+
+	SshdConfigReloadsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdConfigReloadsTotalName,
+			Help:      "The number of configuration reloads attempted by gitlab-shell sshd, by status.",
+		},
+		[]string{"status"},
+	)
+
+	SshdAuthorizedKeysFallbackTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdAuthorizedKeysFallbackTotalName,
+			Help:      "The number of public keys authenticated by the authorized keys fallback while the internal API was unavailable.",
+		},
+	)
+
+	SshdExternalAuthTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdExternalAuthTotalName,
+			Help:      "The number of public keys decided on by the external authenticator, by result: accepted, rejected or error.",
+		},
+		[]string{"result"},
+	)
+
+	SshdClientAliveTimeoutsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdClientAliveTimeoutsTotalName,
+			Help:      "The number of connections closed because the client didn't answer keepalive messages.",
+		},
+	)
+
+	SshdConnectionSessions = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdConnectionSessionsName,
+			Help:      "A histogram of the number of sessions opened over the SSH connections to gitlab-shell sshd.",
+			Buckets:   []float64{1, 2, 4, 8, 16, 32, 64},
+		},
+	)
+
+	SshdTarpitConnections = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdTarpitConnectionsName,
+			Help:      "The number of connections held in the tarpit of gitlab-shell sshd.",
+		},
+	)
+
+	SshdTarpitConnectionsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdTarpitConnectionsTotalName,
+			Help:      "The number of connections held in the tarpit of gitlab-shell sshd, from source IPs that repeatedly failed to authenticate.",
+		},
+	)
+
+	SshdConnectionStageDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdConnectionStageDurationSecondsName,
+			Help:      "A histogram of the durations of the stages of connections and their sessions in gitlab-shell sshd, by stage.",
+			Buckets: []float64{
+				0.001, /* 1ms */
+				0.005, /* 5ms */
+				0.01,  /* 10ms */
+				0.05,  /* 50ms */
+				0.1,   /* 100ms */
+				0.5,   /* 500ms */
+				1.0,   /* 1s */
+				5.0,   /* 5s */
+				30.0,  /* 30s */
+				60.0,  /* 1m */
+			},
+		},
+		[]string{"stage"},
+	)
+
 	SshdHitMaxSessions = promauto.NewCounter(
 		prometheus.CounterOpts{
 			Namespace: namespace,
@@ -101,6 +339,111 @@ var (
 		[]string{"status"},
 	)
 
+	DiscoverCacheLookupsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: discoverSubsystem,
+			Name:      discoverCacheLookupsTotalName,
+			Help:      "Number of lookups in the cache of discovered users, by result: hit, negative_hit or miss",
+		},
+		[]string{"result"},
+	)
+
+	AccessCacheLookupsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: accessSubsystem,
+			Name:      accessCacheLookupsTotalName,
+			Help:      "Number of lookups in the cache of access checks, by result: hit or miss",
+		},
+		[]string{"result"},
+	)
+
+	AccessCacheInvalidationsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: accessSubsystem,
+			Name:      accessCacheInvalidationsTotalName,
+			Help:      "Number of requests of GitLab to drop cached access checks",
+		},
+	)
+
+	AccessTransferQuotaExceededTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: accessSubsystem,
+			Name:      accessTransferQuotaExceededName,
+			Help:      "Number of Git commands refused or trimmed as their user exceeded their transfer quota",
+		},
+		[]string{"action"},
+	)
+
+	GitalyConnectionEvictionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: gitalySubsystem,
+			Name:      gitalyConnectionEvictionsTotalName,
+			Help:      "Number of cached Gitaly connections that have been replaced for being unhealthy",
+		},
+		[]string{"state"},
+	)
+
+	GitalyWriteStallSeconds = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: gitalySubsystem,
+			Name:      gitalyWriteStallSecondsName,
+			Help:      "Time the output of Gitaly waited for clients reading it slower than it was sent, per Git command",
+			Buckets: []float64{
+				0.01,  /* 10ms */
+				0.1,   /* 100ms */
+				1.0,   /* 1s */
+				10.0,  /* 10s */
+				60.0,  /* 1m */
+				300.0, /* 5m */
+			},
+		},
+		[]string{"command"},
+	)
+
+	GitalyStalledTransfersTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: gitalySubsystem,
+			Name:      gitalyStalledTransfersTotalName,
+			Help:      "Number of Git transfers stopped as their client read none of them for longer than the max write stall",
+		},
+		[]string{"command"},
+	)
+
+	CheckHealthy = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: checkSubsystem,
+			Name:      checkHealthyName,
+			Help:      "Set to 1 when the last run of a check of gitlab-shell-check watch passed, 0 otherwise, by check",
+		},
+		[]string{"check"},
+	)
+
+	CheckClockSkewSeconds = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: checkSubsystem,
+			Name:      checkClockSkewSecondsName,
+			Help:      "How far the local clock is ahead of the clock of the internal API, as last measured by gitlab-shell-check watch",
+		},
+	)
+
+	CheckLastRunTimestampSeconds = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: checkSubsystem,
+			Name:      checkLastRunTimestampSecondsName,
+			Help:      "The time of the last run of the checks of gitlab-shell-check watch",
+		},
+	)
+
 	// The metrics and the buckets size are similar to the ones we have for handlers in Labkit
 	// When the MR: https://gitlab.com/gitlab-org/labkit/-/merge_requests/150 is merged,
 	// these metrics can be refactored out of Gitlab Shell code by using the helper function from Labkit
@@ -135,6 +478,10 @@ var (
 		[]string{"code", "method"},
 	)
 
+	// APIClient are the metrics of the requests of the internal API client,
+	// by endpoint
+	APIClient = client.NewMetrics(prometheus.DefaultRegisterer, namespace)
+
 	httpInFlightRequests = promauto.NewGauge(
 		prometheus.GaugeOpts{
 			Namespace: namespace,
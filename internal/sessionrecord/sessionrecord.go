@@ -0,0 +1,318 @@
+// Package sessionrecord records the input and output of the interactive
+// commands that don't run Git, for the records of interactive access some
+// regulations require
+package sessionrecord
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/correlation"
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/discover"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/personalaccesstoken"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/twofactorrecover"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/twofactorverify"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+// Formats of the recordings
+const (
+	FormatAsciicast = "asciicast"
+	FormatRaw       = "raw"
+)
+
+const (
+	asciicastExt = ".cast"
+	rawInExt     = ".in"
+	rawOutExt    = ".out"
+
+	// The size of the terminal in asciicast headers. Sessions have no
+	// pseudo-terminal, so the output is that of a plain stream.
+	terminalWidth  = 80
+	terminalHeight = 24
+)
+
+// Recorder records the streams of a session until it's closed
+type Recorder struct {
+	mu      sync.Mutex
+	started time.Time
+	files   []*os.File
+	// cast encodes the events of an asciicast recording, nil for the raw
+	// format
+	cast *json.Encoder
+	in   io.Writer
+	out  io.Writer
+	err  error
+}
+
+// asciicastHeader is the first line of an asciicast v2 recording
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Start records the streams of rw, which cmd runs with, if the configuration
+// enables the recording of sessions and cmd is interactive. It returns a nil
+// Recorder otherwise.
+func Start(ctx context.Context, cfg *config.Config, cmd command.Command, rw *readwriter.ReadWriter) (*Recorder, error) {
+	recording := cfg.SessionRecording
+	if recording.Dir == "" {
+		return nil, nil
+	}
+
+	args, ok := interactiveArgs(cmd)
+	if !ok {
+		return nil, nil
+	}
+
+	format := recording.Format
+	if format == "" {
+		format = FormatAsciicast
+	}
+	if format != FormatAsciicast && format != FormatRaw {
+		return nil, fmt.Errorf("invalid session recording format %q", format)
+	}
+
+	if err := os.MkdirAll(recording.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create session recording dir: %w", err)
+	}
+
+	if maxAge := time.Duration(recording.MaxAge); maxAge > 0 {
+		prune(ctx, recording.Dir, maxAge)
+	}
+
+	correlationID := correlation.ExtractFromContext(ctx)
+	if correlationID == "" {
+		correlationID = correlation.SafeRandomID()
+	}
+
+	r := &Recorder{started: time.Now()}
+	name := filepath.Join(recording.Dir, r.started.UTC().Format("20060102T150405.000000000Z")+"-"+correlationID)
+
+	var err error
+	if format == FormatAsciicast {
+		err = r.openAsciicast(name, asciicastHeader{
+			Version:   2,
+			Width:     terminalWidth,
+			Height:    terminalHeight,
+			Timestamp: r.started.Unix(),
+			Title:     strings.Join(args.SshArgs, " "),
+			Env: map[string]string{
+				"GL_ID":          args.Who(),
+				"REMOTE_ADDR":    args.Env.RemoteAddr,
+				"CORRELATION_ID": correlationID,
+			},
+		})
+	} else {
+		err = r.openRaw(name)
+	}
+	if err != nil {
+		r.closeFiles()
+		return nil, err
+	}
+
+	rw.In = &recordingReader{r: rw.In, recorder: r}
+	rw.Out = &recordingWriter{w: rw.Out, recorder: r}
+	rw.ErrOut = &recordingWriter{w: rw.ErrOut, recorder: r}
+
+	return r, nil
+}
+
+func (r *Recorder) openAsciicast(name string, header asciicastHeader) error {
+	file, err := create(name + asciicastExt)
+	if err != nil {
+		return err
+	}
+	r.files = append(r.files, file)
+
+	r.cast = json.NewEncoder(file)
+	r.cast.SetEscapeHTML(false)
+
+	return r.cast.Encode(header)
+}
+
+func (r *Recorder) openRaw(name string) error {
+	in, err := create(name + rawInExt)
+	if err != nil {
+		return err
+	}
+	r.files = append(r.files, in)
+
+	out, err := create(name + rawOutExt)
+	if err != nil {
+		return err
+	}
+	r.files = append(r.files, out)
+
+	r.in = in
+	r.out = out
+
+	return nil
+}
+
+func create(name string) (*os.File, error) {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("create session recording: %w", err)
+	}
+
+	return file, nil
+}
+
+// record writes data, read from the client when input is true or sent to it
+// otherwise. A failure stops the recording but not the session, or the
+// session would depend on the disk space of the recordings.
+func (r *Recorder) record(input bool, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil || r.files == nil {
+		return
+	}
+
+	switch {
+	case r.cast != nil:
+		code := "o"
+		if input {
+			code = "i"
+		}
+		r.err = r.cast.Encode([]interface{}{time.Since(r.started).Seconds(), code, string(data)})
+	case input:
+		_, r.err = r.in.Write(data)
+	default:
+		_, r.err = r.out.Write(data)
+	}
+}
+
+// Close completes the recording. It is safe to call on a nil Recorder.
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.err
+	if closeErr := r.closeFiles(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func (r *Recorder) closeFiles() error {
+	var err error
+	for _, file := range r.files {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	r.files = nil
+
+	return err
+}
+
+type recordingReader struct {
+	r        io.Reader
+	recorder *Recorder
+}
+
+func (rr *recordingReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	rr.recorder.record(true, p[:n])
+
+	return n, err
+}
+
+type recordingWriter struct {
+	w        io.Writer
+	recorder *Recorder
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	n, err := rw.w.Write(p)
+	rw.recorder.record(false, p[:n])
+
+	return n, err
+}
+
+// prune removes the recordings in dir last written more than maxAge ago
+func prune(ctx context.Context, dir string, maxAge time.Duration) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.WithContextFields(ctx, log.Fields{"dir": dir}).WithError(err).Warn("sessionrecord: failed to list recordings")
+		return
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !isRecording(entry.Name()) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.WithContextFields(ctx, log.Fields{"path": path}).WithError(err).Warn("sessionrecord: failed to remove recording")
+		}
+	}
+}
+
+func isRecording(name string) bool {
+	switch filepath.Ext(name) {
+	case asciicastExt, rawInExt, rawOutExt:
+		return true
+	default:
+		return false
+	}
+}
+
+// interactiveArgs returns the arguments of cmd if it's an interactive command
+// to record. Commands wrapped, e.g. by a command policy, are unwrapped first.
+func interactiveArgs(cmd command.Command) (*commandargs.Shell, bool) {
+	for {
+		wrapper, ok := cmd.(interface{ Unwrap() command.Command })
+		if !ok {
+			break
+		}
+		cmd = wrapper.Unwrap()
+	}
+
+	switch c := cmd.(type) {
+	case *discover.Command:
+		return c.Args, c.Args != nil
+	case *twofactorverify.Command:
+		return c.Args, c.Args != nil
+	case *twofactorrecover.Command:
+		return c.Args, c.Args != nil
+	case *personalaccesstoken.Command:
+		return c.Args, c.Args != nil
+	default:
+		return nil, false
+	}
+}
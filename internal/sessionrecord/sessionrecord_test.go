@@ -0,0 +1,222 @@
+package sessionrecord
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/discover"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/commandpolicy"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/uploadpack"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+)
+
+func discoverCommand() *discover.Command {
+	return &discover.Command{Args: &commandargs.Shell{
+		GitlabKeyId: "1",
+		SshArgs:     []string{"discover"},
+		Env:         sshenv.Env{RemoteAddr: "127.0.0.1"},
+	}}
+}
+
+func newReadWriter(input string) (*readwriter.ReadWriter, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	return &readwriter.ReadWriter{Out: out, ErrOut: out, In: strings.NewReader(input)}, out
+}
+
+// runSession reads the input of rw and replies to it
+func runSession(t *testing.T, rw *readwriter.ReadWriter) {
+	_, err := io.WriteString(rw.Out, "Welcome to GitLab, @alex!\n")
+	require.NoError(t, err)
+
+	input, err := io.ReadAll(rw.In)
+	require.NoError(t, err)
+
+	_, err = io.WriteString(rw.ErrOut, "read "+string(input))
+	require.NoError(t, err)
+}
+
+func recordings(t *testing.T, dir string) []string {
+	names, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+
+	return names
+}
+
+func TestAsciicastRecording(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{SessionRecording: config.SessionRecordingConfig{Dir: dir}}
+	rw, out := newReadWriter("input\n")
+
+	recorder, err := Start(context.Background(), cfg, discoverCommand(), rw)
+	require.NoError(t, err)
+	require.NotNil(t, recorder)
+
+	runSession(t, rw)
+	require.NoError(t, recorder.Close())
+
+	// The session is unaffected by its recording
+	require.Equal(t, "Welcome to GitLab, @alex!\nread input\n", out.String())
+
+	names := recordings(t, dir)
+	require.Len(t, names, 1)
+	require.Equal(t, asciicastExt, filepath.Ext(names[0]))
+
+	file, err := os.Open(names[0])
+	require.NoError(t, err)
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	require.True(t, scanner.Scan())
+	var header asciicastHeader
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &header))
+	require.Equal(t, 2, header.Version)
+	require.Equal(t, "discover", header.Title)
+	require.Equal(t, "key-1", header.Env["GL_ID"])
+	require.Equal(t, "127.0.0.1", header.Env["REMOTE_ADDR"])
+
+	var events [][]interface{}
+	for scanner.Scan() {
+		var event []interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		require.Len(t, event, 3)
+		events = append(events, event[1:])
+	}
+	require.Equal(t, [][]interface{}{
+		{"o", "Welcome to GitLab, @alex!\n"},
+		{"i", "input\n"},
+		{"o", "read input\n"},
+	}, events)
+}
+
+func TestRawRecording(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{SessionRecording: config.SessionRecordingConfig{Dir: dir, Format: FormatRaw}}
+	rw, _ := newReadWriter("input\n")
+
+	recorder, err := Start(context.Background(), cfg, discoverCommand(), rw)
+	require.NoError(t, err)
+
+	runSession(t, rw)
+	require.NoError(t, recorder.Close())
+
+	names := recordings(t, dir)
+	require.Len(t, names, 2)
+
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+
+		if filepath.Ext(name) == rawInExt {
+			require.Equal(t, "input\n", string(data))
+		} else {
+			require.Equal(t, rawOutExt, filepath.Ext(name))
+			require.Equal(t, "Welcome to GitLab, @alex!\nread input\n", string(data))
+		}
+	}
+}
+
+func TestRecordingWithCommandPolicy(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		SessionRecording: config.SessionRecordingConfig{Dir: dir},
+		CommandPolicy:    config.CommandPolicyConfig{DenyTokenCommands: true},
+	}
+	cmd := discoverCommand()
+	wrapped := &commandpolicy.Command{Command: cmd, Config: cfg, Args: cmd.Args}
+
+	rw, _ := newReadWriter("")
+	recorder, err := Start(context.Background(), cfg, wrapped, rw)
+	require.NoError(t, err)
+	require.NotNil(t, recorder, "the command wrapped by the policy is recorded")
+	require.NoError(t, recorder.Close())
+
+	require.NotEmpty(t, recordings(t, dir))
+}
+
+func TestNoRecording(t *testing.T) {
+	dir := t.TempDir()
+
+	testCases := []struct {
+		desc string
+		cfg  *config.Config
+		cmd  command.Command
+	}{
+		{
+			desc: "without a dir",
+			cfg:  &config.Config{},
+			cmd:  discoverCommand(),
+		},
+		{
+			desc: "of a Git command",
+			cfg:  &config.Config{SessionRecording: config.SessionRecordingConfig{Dir: dir}},
+			cmd:  &uploadpack.Command{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			rw, _ := newReadWriter("")
+
+			recorder, err := Start(context.Background(), tc.cfg, tc.cmd, rw)
+			require.NoError(t, err)
+			require.Nil(t, recorder)
+			require.NoError(t, recorder.Close())
+			require.IsType(t, &bytes.Buffer{}, rw.Out)
+		})
+	}
+
+	require.Empty(t, recordings(t, dir))
+}
+
+func TestInvalidFormat(t *testing.T) {
+	cfg := &config.Config{SessionRecording: config.SessionRecordingConfig{Dir: t.TempDir(), Format: "mp4"}}
+	rw, _ := newReadWriter("")
+
+	_, err := Start(context.Background(), cfg, discoverCommand(), rw)
+	require.EqualError(t, err, `invalid session recording format "mp4"`)
+}
+
+func TestRetention(t *testing.T) {
+	dir := t.TempDir()
+
+	old := filepath.Join(dir, "old"+asciicastExt)
+	recent := filepath.Join(dir, "recent"+rawOutExt)
+	other := filepath.Join(dir, "other.txt")
+	for _, name := range []string{old, recent, other} {
+		require.NoError(t, os.WriteFile(name, nil, 0o600))
+	}
+
+	longAgo := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(old, longAgo, longAgo))
+	require.NoError(t, os.Chtimes(other, longAgo, longAgo))
+
+	cfg := &config.Config{SessionRecording: config.SessionRecordingConfig{
+		Dir:    dir,
+		MaxAge: config.YamlDuration(24 * time.Hour),
+	}}
+	rw, _ := newReadWriter("")
+
+	recorder, err := Start(context.Background(), cfg, discoverCommand(), rw)
+	require.NoError(t, err)
+	require.NoError(t, recorder.Close())
+
+	require.NoFileExists(t, old)
+	require.FileExists(t, recent)
+	require.FileExists(t, other)
+	require.Len(t, recordings(t, dir), 3)
+}
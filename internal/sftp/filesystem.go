@@ -0,0 +1,239 @@
+// Package sftp serves a read-only SFTP filesystem of the refs and archives of
+// the projects a user is allowed to download
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	sftplib "github.com/pkg/sftp"
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
+)
+
+// projectSeparator separates the path of a project from the path of a file in
+// it, as it does in GitLab URLs: /group/project/-/refs/heads/main
+const projectSeparator = "/-"
+
+// Filesystem resolves SFTP requests against the projects of the user that
+// Args identifies. Each project is checked with the same access verification
+// as git-upload-archive the first time it's accessed.
+type Filesystem struct {
+	Config *config.Config
+	Args   *commandargs.Shell
+
+	ctx      context.Context
+	modTime  time.Time
+	mu       sync.Mutex
+	projects map[string]*project
+}
+
+// NewFilesystem returns a Filesystem whose requests to GitLab and Gitaly are
+// made in ctx
+func NewFilesystem(ctx context.Context, cfg *config.Config, args *commandargs.Shell) *Filesystem {
+	return &Filesystem{
+		Config:   cfg,
+		Args:     args,
+		ctx:      ctx,
+		modTime:  time.Now(),
+		projects: make(map[string]*project),
+	}
+}
+
+// Serve handles the SFTP protocol on channel until the client disconnects or
+// ctx is canceled
+func (fs *Filesystem) Serve(ctx context.Context, channel io.ReadWriteCloser) error {
+	server := sftplib.NewRequestServer(channel, sftplib.Handlers{
+		FileGet:  fs,
+		FilePut:  fs,
+		FileCmd:  fs,
+		FileList: fs,
+	})
+
+	stop := context.AfterFunc(ctx, func() { _ = server.Close() })
+	defer stop()
+
+	err := server.Serve()
+	if errors.Is(err, io.EOF) || ctx.Err() != nil {
+		return nil
+	}
+
+	return err
+}
+
+// Fileread returns the content of a ref or an archive
+func (fs *Filesystem) Fileread(r *sftplib.Request) (io.ReaderAt, error) {
+	p, n, err := fs.lookup(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case n.isDir():
+		return nil, sftplib.ErrSSHFxFailure
+	case n.archive != nil:
+		return p.archive(fs.ctx, fs.Config, fs.Args.Env, n.archive)
+	default:
+		return bytes.NewReader(n.content), nil
+	}
+}
+
+// Filewrite refuses uploads: the filesystem is read-only
+func (fs *Filesystem) Filewrite(*sftplib.Request) (io.WriterAt, error) {
+	return nil, sftplib.ErrSSHFxPermissionDenied
+}
+
+// Filecmd refuses renames, removals and any other change
+func (fs *Filesystem) Filecmd(*sftplib.Request) error {
+	return sftplib.ErrSSHFxPermissionDenied
+}
+
+// Filelist lists directories and stats files
+func (fs *Filesystem) Filelist(r *sftplib.Request) (sftplib.ListerAt, error) {
+	filepath := cleanPath(r.Filepath)
+
+	switch r.Method {
+	case "List":
+		if !strings.Contains(filepath+"/", projectSeparator+"/") {
+			return fs.listNamespace(filepath), nil
+		}
+
+		_, n, err := fs.lookup(filepath)
+		if err != nil {
+			return nil, err
+		}
+		if !n.isDir() {
+			return nil, sftplib.ErrSSHFxFailure
+		}
+
+		return lister(n.list(fs.modTime)), nil
+	case "Stat":
+		if !strings.Contains(filepath+"/", projectSeparator+"/") {
+			return lister{dirInfo(path.Base(filepath), fs.modTime)}, nil
+		}
+
+		_, n, err := fs.lookup(filepath)
+		if err != nil {
+			return nil, err
+		}
+
+		return lister{n.info(fs.modTime)}, nil
+	default:
+		return nil, sftplib.ErrSSHFxOpUnsupported
+	}
+}
+
+// listNamespace lists a path outside of any project. Namespaces can't be
+// listed, but a project directory holds the separator directory.
+func (fs *Filesystem) listNamespace(filepath string) lister {
+	if filepath == "/" {
+		return nil
+	}
+
+	if _, err := fs.project(strings.TrimPrefix(filepath, "/")); err != nil {
+		return nil
+	}
+
+	return lister{dirInfo(strings.TrimPrefix(projectSeparator, "/"), fs.modTime)}
+}
+
+// lookup resolves filepath to the node of a file or a directory in a project
+func (fs *Filesystem) lookup(filepath string) (*project, *node, error) {
+	projectPath, filePath, ok := strings.Cut(strings.TrimPrefix(cleanPath(filepath), "/"), projectSeparator)
+	if !ok || (filePath != "" && !strings.HasPrefix(filePath, "/")) {
+		return nil, nil, os.ErrNotExist
+	}
+
+	p, err := fs.project(projectPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	n := p.root.lookup(strings.TrimPrefix(filePath, "/"))
+	if n == nil {
+		return nil, nil, os.ErrNotExist
+	}
+
+	return p, n, nil
+}
+
+// project verifies access to the project at projectPath and loads its refs,
+// once per session
+func (fs *Filesystem) project(projectPath string) (*project, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if p, ok := fs.projects[projectPath]; ok {
+		return p, nil
+	}
+
+	p, err := fs.loadProject(projectPath)
+	if err != nil {
+		// Projects the user can't access are reported as missing, as GitLab
+		// does, so that their existence isn't disclosed
+		log.WithContextFields(fs.ctx, log.Fields{"project": projectPath}).WithError(err).Info("sftp: project not accessible")
+
+		return nil, os.ErrNotExist
+	}
+
+	fs.projects[projectPath] = p
+
+	return p, nil
+}
+
+func (fs *Filesystem) loadProject(projectPath string) (*project, error) {
+	client, err := accessverifier.NewClient(fs.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := client.Verify(fs.ctx, fs.Args, commandargs.UploadArchive, projectPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !response.Success {
+		return nil, errors.New(response.Message)
+	}
+
+	if response.IsCustomAction() {
+		return nil, errors.New("project is served by another site")
+	}
+
+	p := &project{path: projectPath, response: response}
+	if err := p.loadRefs(fs.ctx, fs.Config, fs.Args.Env); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func cleanPath(filepath string) string {
+	return path.Clean("/" + filepath)
+}
+
+// lister serves a fixed list of file infos
+type lister []os.FileInfo
+
+func (l lister) ListAt(infos []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+
+	n := copy(infos, l[offset:])
+	if n < len(infos) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
@@ -0,0 +1,190 @@
+package sftp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"testing"
+
+	sftplib "github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/testhelper/requesthandlers"
+)
+
+func setup(t *testing.T) (*sftplib.Client, *testserver.TestGitalyServer) {
+	t.Helper()
+
+	gitalyAddress, gitalyServer := testserver.StartGitalyServer(t, "tcp")
+	allowed := requesthandlers.BuildAllowedWithGitalyHandlers(t, gitalyAddress)[0].Handler
+
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/allowed",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				var request struct {
+					Action  string `json:"action"`
+					Project string `json:"project"`
+				}
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+				require.Equal(t, string(commandargs.UploadArchive), request.Action)
+
+				if request.Project != "group/project" {
+					w.WriteHeader(http.StatusNotFound)
+					json.NewEncoder(w).Encode(map[string]interface{}{"status": false, "message": "The project you were looking for could not be found."})
+					return
+				}
+
+				allowed(w, r)
+			},
+		},
+	}
+	url := testserver.StartHttpServer(t, requests)
+
+	cfg := &config.Config{GitlabUrl: url}
+	cfg.GitalyClient.InitSidechannelRegistry(context.Background())
+	args := &commandargs.Shell{GitlabKeyId: "1", Env: sshenv.Env{IsSSHConnection: true, RemoteAddr: "127.0.0.1"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fs := NewFilesystem(ctx, cfg, args)
+
+	serverConn, clientConn := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- fs.Serve(ctx, serverConn) }()
+
+	client, err := sftplib.NewClientPipe(clientConn, clientConn)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		client.Close()
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	return client, gitalyServer
+}
+
+func readFile(t *testing.T, client *sftplib.Client, path string) string {
+	t.Helper()
+
+	file, err := client.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+
+	return string(content)
+}
+
+func readDir(t *testing.T, client *sftplib.Client, path string) []string {
+	t.Helper()
+
+	infos, err := client.ReadDir(path)
+	require.NoError(t, err)
+
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func TestListing(t *testing.T) {
+	client, _ := setup(t)
+
+	require.Empty(t, readDir(t, client, "/"))
+	require.Equal(t, []string{"-"}, readDir(t, client, "/group/project"))
+	require.Empty(t, readDir(t, client, "/group/other"))
+	require.Equal(t, []string{"archive", "refs"}, readDir(t, client, "/group/project/-"))
+	require.Equal(t, []string{"heads", "tags"}, readDir(t, client, "/group/project/-/refs"))
+	require.Equal(t, []string{"feature", "main"}, readDir(t, client, "/group/project/-/refs/heads"))
+	require.Equal(t, []string{"sftp"}, readDir(t, client, "/group/project/-/refs/heads/feature"))
+	require.Equal(t, []string{"v1.0.0"}, readDir(t, client, "/group/project/-/refs/tags"))
+	require.Equal(t, []string{"v1.0.0.tar", "v1.0.0.tar.bz2", "v1.0.0.tar.gz", "v1.0.0.zip"}, readDir(t, client, "/group/project/-/archive/tags"))
+
+	info, err := client.Stat("/group/project/-/refs/heads/main")
+	require.NoError(t, err)
+	require.False(t, info.IsDir())
+	require.Equal(t, int64(41), info.Size())
+
+	info, err = client.Stat("/group/project/-/archive/heads/feature")
+	require.NoError(t, err)
+	require.True(t, info.IsDir())
+
+	_, err = client.Stat("/group/project/-/refs/heads/missing")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = client.Stat("/group/other/-/refs")
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestReadRef(t *testing.T) {
+	client, gitalyServer := setup(t)
+
+	require.Equal(t, "5937ac0a7beb003549fc5fd26fc247adbce4a52e\n", readFile(t, client, "/group/project/-/refs/heads/feature/sftp"))
+	require.Equal(t, "f4e6814c3e4e7a0de82a9e7cd20c626cc963a2f8\n", readFile(t, client, "/group/project/-/refs/tags/v1.0.0"))
+	require.Equal(t, []string{"1"}, gitalyServer.ReceivedMD["user_id"])
+	require.Equal(t, []string{"127.0.0.1"}, gitalyServer.ReceivedMD["remote_ip"])
+}
+
+func TestReadArchive(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", tempDir)
+
+	client, _ := setup(t)
+
+	testCases := []struct {
+		path            string
+		expectedContent string
+	}{
+		{
+			path:            "/group/project/-/archive/heads/main.tar.gz",
+			expectedContent: "GetArchive: group/repo 1e292f8fedd741b75372e19097c76d327140c312 project-main TAR_GZ",
+		},
+		{
+			path:            "/group/project/-/archive/heads/feature/sftp.zip",
+			expectedContent: "GetArchive: group/repo 5937ac0a7beb003549fc5fd26fc247adbce4a52e project-feature-sftp ZIP",
+		},
+		{
+			path:            "/group/project/-/archive/tags/v1.0.0.tar.bz2",
+			expectedContent: "GetArchive: group/repo 1e292f8fedd741b75372e19097c76d327140c312 project-v1.0.0 TAR_BZ2",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			require.Equal(t, tc.expectedContent, readFile(t, client, tc.path))
+		})
+	}
+
+	entries, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	require.Empty(t, entries, "archives are removed once read")
+}
+
+func TestReadOnly(t *testing.T) {
+	client, _ := setup(t)
+
+	_, err := client.Create("/group/project/-/refs/heads/new")
+	require.ErrorIs(t, err, os.ErrPermission)
+
+	err = client.Remove("/group/project/-/refs/heads/main")
+	require.ErrorIs(t, err, os.ErrPermission)
+
+	err = client.Mkdir("/group/project/-/archive/new")
+	require.ErrorIs(t, err, os.ErrPermission)
+
+	err = client.Rename("/group/project/-/refs/heads/main", "/group/project/-/refs/heads/other")
+	require.ErrorIs(t, err, os.ErrPermission)
+}
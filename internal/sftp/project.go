@@ -0,0 +1,281 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	pb "gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
+	"google.golang.org/grpc"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/handler"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+)
+
+const serviceName = "sftp"
+
+// archiveFormats are the archives offered for each ref, by file extension
+var archiveFormats = []struct {
+	extension string
+	format    pb.GetArchiveRequest_Format
+}{
+	{".tar.gz", pb.GetArchiveRequest_TAR_GZ},
+	{".tar.bz2", pb.GetArchiveRequest_TAR_BZ2},
+	{".tar", pb.GetArchiveRequest_TAR},
+	{".zip", pb.GetArchiveRequest_ZIP},
+}
+
+type archiveSpec struct {
+	prefix   string
+	commitID string
+	format   pb.GetArchiveRequest_Format
+}
+
+// project holds the tree of a project's refs and archives:
+//
+//	refs/heads/<branch>            the commit ID the branch points to
+//	refs/tags/<tag>                the object ID the tag points to
+//	archive/heads/<branch>.tar.gz  an archive of the branch, also as .tar.bz2, .tar and .zip
+//	archive/tags/<tag>.tar.gz      an archive of the tagged commit
+type project struct {
+	path     string
+	response *accessverifier.Response
+	root     *node
+}
+
+func (p *project) loadRefs(ctx context.Context, cfg *config.Config, env sshenv.Env) error {
+	repository := p.response.Gitaly.Repository()
+	gc := handler.NewGitalyCommand(cfg, serviceName, p.response)
+
+	var refs []*pb.ListRefsResponse_Reference
+	err := gc.RunGitalyCommand(ctx, func(ctx context.Context, conn *grpc.ClientConn) (int32, error) {
+		ctx, cancel := gc.PrepareContext(ctx, repository, env)
+		defer cancel()
+
+		stream, err := pb.NewRefServiceClient(conn).ListRefs(ctx, &pb.ListRefsRequest{
+			Repository: repository,
+			Patterns:   [][]byte{[]byte("refs/heads/"), []byte("refs/tags/")},
+			PeelTags:   true,
+		})
+		if err != nil {
+			return 1, err
+		}
+
+		for {
+			response, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return 0, nil
+			}
+			if err != nil {
+				return 1, err
+			}
+
+			refs = append(refs, response.GetReferences()...)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	p.root = newDir(strings.TrimPrefix(projectSeparator, "/"))
+	p.root.add("refs/heads", newDir("heads"))
+	p.root.add("refs/tags", newDir("tags"))
+	p.root.add("archive/heads", newDir("heads"))
+	p.root.add("archive/tags", newDir("tags"))
+
+	for _, ref := range refs {
+		name := string(ref.GetName())
+		commitID := ref.GetTarget()
+		if ref.GetPeeledTarget() != "" {
+			commitID = ref.GetPeeledTarget()
+		}
+
+		p.root.add(name, &node{name: path.Base(name), content: []byte(ref.GetTarget() + "\n")})
+
+		kind, shortName, _ := strings.Cut(strings.TrimPrefix(name, "refs/"), "/")
+		prefix := path.Base(p.path) + "-" + strings.ReplaceAll(shortName, "/", "-")
+		for _, f := range archiveFormats {
+			archiveName := path.Join("archive", kind, shortName+f.extension)
+			p.root.add(archiveName, &node{
+				name:    path.Base(archiveName),
+				archive: &archiveSpec{prefix: prefix, commitID: commitID, format: f.format},
+			})
+		}
+	}
+
+	return nil
+}
+
+// archive writes the archive described by spec to a temporary file, since
+// SFTP clients read files at arbitrary offsets. The file is removed when the
+// client closes it.
+func (p *project) archive(ctx context.Context, cfg *config.Config, env sshenv.Env, spec *archiveSpec) (io.ReaderAt, error) {
+	file, err := os.CreateTemp("", "gitlab-sftp-archive-")
+	if err != nil {
+		return nil, err
+	}
+	archive := &temporaryFile{file}
+
+	repository := p.response.Gitaly.Repository()
+	gc := handler.NewGitalyCommand(cfg, serviceName, p.response)
+
+	err = gc.RunGitalyCommand(ctx, func(ctx context.Context, conn *grpc.ClientConn) (int32, error) {
+		ctx, cancel := gc.PrepareContext(ctx, repository, env)
+		defer cancel()
+
+		stream, err := pb.NewRepositoryServiceClient(conn).GetArchive(ctx, &pb.GetArchiveRequest{
+			Repository: repository,
+			CommitId:   spec.commitID,
+			Prefix:     spec.prefix,
+			Format:     spec.format,
+		})
+		if err != nil {
+			return 1, err
+		}
+
+		for {
+			response, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return 0, nil
+			}
+			if err != nil {
+				return 1, err
+			}
+
+			if _, err := file.Write(response.GetData()); err != nil {
+				return 1, err
+			}
+		}
+	})
+	if err != nil {
+		_ = archive.Close()
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+// temporaryFile is removed once closed
+type temporaryFile struct {
+	*os.File
+}
+
+func (f *temporaryFile) Close() error {
+	err := f.File.Close()
+	if removeErr := os.Remove(f.Name()); err == nil {
+		err = removeErr
+	}
+
+	return err
+}
+
+// node is a file or a directory of a project tree
+type node struct {
+	name     string
+	children map[string]*node
+	content  []byte
+	archive  *archiveSpec
+}
+
+func newDir(name string) *node {
+	return &node{name: name, children: make(map[string]*node)}
+}
+
+func (n *node) isDir() bool {
+	return n.children != nil
+}
+
+// add inserts child at the slash-separated path p, creating the directories
+// leading to it. A path clashing with an existing file is ignored.
+func (n *node) add(p string, child *node) {
+	dir, name := path.Split(p)
+	parent := n
+
+	for _, part := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if part == "" {
+			continue
+		}
+
+		next, ok := parent.children[part]
+		if !ok {
+			next = newDir(part)
+			parent.children[part] = next
+		}
+		if !next.isDir() {
+			return
+		}
+
+		parent = next
+	}
+
+	if _, ok := parent.children[name]; !ok {
+		parent.children[name] = child
+	}
+}
+
+func (n *node) lookup(p string) *node {
+	current := n
+
+	for _, part := range strings.Split(p, "/") {
+		if part == "" {
+			continue
+		}
+		if !current.isDir() {
+			return nil
+		}
+
+		next, ok := current.children[part]
+		if !ok {
+			return nil
+		}
+
+		current = next
+	}
+
+	return current
+}
+
+func (n *node) list(modTime time.Time) []os.FileInfo {
+	infos := make([]os.FileInfo, 0, len(n.children))
+	for _, child := range n.children {
+		infos = append(infos, child.info(modTime))
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	return infos
+}
+
+func (n *node) info(modTime time.Time) os.FileInfo {
+	if n.isDir() {
+		return dirInfo(n.name, modTime)
+	}
+
+	// The size of an archive isn't known until it's generated
+	return &fileInfo{name: n.name, size: int64(len(n.content)), mode: 0o444, modTime: modTime}
+}
+
+func dirInfo(name string, modTime time.Time) os.FileInfo {
+	return &fileInfo{name: name, mode: os.ModeDir | 0o555, modTime: modTime}
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) Mode() os.FileMode  { return i.mode }
+func (i *fileInfo) ModTime() time.Time { return i.modTime }
+func (i *fileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *fileInfo) Sys() any           { return nil }
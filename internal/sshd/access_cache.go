@@ -0,0 +1,88 @@
+package sshd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+// accessCacheAuthHeader holds the JWT GitLab authenticates its requests with,
+// signed with the shared secret like the requests of gitlab-shell to GitLab
+const accessCacheAuthHeader = "Gitlab-Shell-Api-Request" // #nosec G101
+
+// maxInvalidationBodySize bounds the body of an invalidation request
+const maxInvalidationBodySize = 4096
+
+var errMissingToken = errors.New("missing token")
+
+type accessCacheInvalidation struct {
+	GlID      string `json:"gl_id"`
+	ProjectID int    `json:"project_id"`
+}
+
+// accessCacheInvalidationHandler lets GitLab drop the cached access checks of
+// a user or a project whose permissions changed
+func (s *Server) accessCacheInvalidationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	cfg, _ := s.currentConfig()
+
+	if err := verifyInvalidationToken(r.Header.Get(accessCacheAuthHeader), cfg.AcceptedSecrets()); err != nil {
+		log.WithContextFields(r.Context(), log.Fields{"remote_addr": r.RemoteAddr}).WithError(err).Warn("Rejected an access cache invalidation")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+		return
+	}
+
+	var invalidation accessCacheInvalidation
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxInvalidationBodySize)).Decode(&invalidation); err != nil {
+		http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+
+		return
+	}
+
+	dropped := accessverifier.Invalidate(invalidation.GlID, invalidation.ProjectID)
+	metrics.AccessCacheInvalidationsTotal.Inc()
+
+	log.WithContextFields(r.Context(), log.Fields{
+		"gl_id":      invalidation.GlID,
+		"project_id": invalidation.ProjectID,
+		"dropped":    dropped,
+	}).Info("Invalidated cached access checks")
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"invalidated": dropped})
+}
+
+// verifyInvalidationToken checks that token is an unexpired JWT signed with
+// one of secrets
+func verifyInvalidationToken(token string, secrets []string) error {
+	if token == "" {
+		return errMissingToken
+	}
+
+	var err error
+	for _, secret := range secrets {
+		_, err = jwt.Parse(token, func(*jwt.Token) (interface{}, error) {
+			return []byte(strings.TrimSpace(secret)), nil
+		}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+		if !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+			return err
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,165 @@
+package sshd
+
+import (
+	"fmt"
+	"slices"
+
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+// The algorithms golang.org/x/crypto/ssh implements on the server side, which
+// are the only names accepted in the algorithms configuration
+var (
+	knownKeyExchanges = []string{
+		"curve25519-sha256",
+		"curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256",
+		"ecdh-sha2-nistp384",
+		"ecdh-sha2-nistp521",
+		"diffie-hellman-group-exchange-sha256",
+		"diffie-hellman-group-exchange-sha1",
+		"diffie-hellman-group16-sha512",
+		"diffie-hellman-group14-sha256",
+		"diffie-hellman-group14-sha1",
+		"diffie-hellman-group1-sha1",
+	}
+
+	knownCiphers = []string{
+		"aes128-gcm@openssh.com",
+		"aes256-gcm@openssh.com",
+		"chacha20-poly1305@openssh.com",
+		"aes128-ctr",
+		"aes192-ctr",
+		"aes256-ctr",
+		"aes128-cbc",
+		"3des-cbc",
+		"arcfour256",
+		"arcfour128",
+		"arcfour",
+	}
+
+	knownMACs = []string{
+		"hmac-sha2-256-etm@openssh.com",
+		"hmac-sha2-512-etm@openssh.com",
+		"hmac-sha2-256",
+		"hmac-sha2-512",
+		"hmac-sha1",
+		"hmac-sha1-96",
+	}
+
+	knownHostKeyAlgorithms = []string{
+		ssh.KeyAlgoED25519,
+		ssh.KeyAlgoECDSA256,
+		ssh.KeyAlgoECDSA384,
+		ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA512,
+		ssh.KeyAlgoRSASHA256,
+		ssh.KeyAlgoRSA,
+		ssh.KeyAlgoDSA,
+	}
+
+	// supportedHostKeyAlgorithms leaves out the SHA-1 signatures of ssh-rsa
+	// and ssh-dss, which OpenSSH disables by default as well
+	supportedHostKeyAlgorithms = []string{
+		ssh.KeyAlgoED25519,
+		ssh.KeyAlgoECDSA256,
+		ssh.KeyAlgoECDSA384,
+		ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA512,
+		ssh.KeyAlgoRSASHA256,
+	}
+)
+
+// algorithmsPolicy returns the algorithms configured for gitlab-sshd. The
+// algorithms section takes precedence over the older top-level settings.
+func algorithmsPolicy(cfg config.ServerConfig) config.AlgorithmsConfig {
+	policy := cfg.Algorithms
+	if len(policy.KexAlgorithms) == 0 {
+		policy.KexAlgorithms = cfg.KexAlgorithms
+	}
+	if len(policy.Ciphers) == 0 {
+		policy.Ciphers = cfg.Ciphers
+	}
+	if len(policy.MACs) == 0 {
+		policy.MACs = cfg.MACs
+	}
+
+	return policy
+}
+
+// validateAlgorithms rejects algorithms golang.org/x/crypto/ssh doesn't
+// implement, so that a typo fails at startup rather than during handshakes
+func validateAlgorithms(policy config.AlgorithmsConfig) error {
+	for _, check := range []struct {
+		kind       string
+		configured []string
+		known      []string
+	}{
+		{"key exchange", policy.KexAlgorithms, knownKeyExchanges},
+		{"cipher", policy.Ciphers, knownCiphers},
+		{"MAC", policy.MACs, knownMACs},
+		{"host key", policy.HostKeyAlgorithms, knownHostKeyAlgorithms},
+	} {
+		for _, name := range check.configured {
+			if !slices.Contains(check.known, name) {
+				return fmt.Errorf("unknown %s algorithm %q", check.kind, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// restrictHostKeys limits the signature algorithms offered for each host key
+// to those allowed, dropping host keys that are left without any
+func restrictHostKeys(hostKeys []ssh.Signer, allowed []string) []ssh.Signer {
+	if len(allowed) == 0 {
+		allowed = supportedHostKeyAlgorithms
+	}
+
+	var restricted []ssh.Signer
+
+	for _, hostKey := range hostKeys {
+		publicKey := hostKey.PublicKey()
+		if cert, ok := publicKey.(*ssh.Certificate); ok {
+			publicKey = cert.Key
+		}
+
+		var algorithms []string
+		for _, algorithm := range hostKeyAlgorithms(publicKey.Type()) {
+			if slices.Contains(allowed, algorithm) {
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+
+		algorithmSigner, ok := hostKey.(ssh.AlgorithmSigner)
+		if len(algorithms) == 0 || !ok {
+			log.WithFields(log.Fields{"host_key_type": publicKey.Type()}).Warn("No allowed host key algorithm for host key, ignoring it")
+			continue
+		}
+
+		signer, err := ssh.NewSignerWithAlgorithms(algorithmSigner, algorithms)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{"host_key_type": publicKey.Type()}).Warn("Failed to restrict host key algorithms, ignoring host key")
+			continue
+		}
+
+		restricted = append(restricted, signer)
+	}
+
+	return restricted
+}
+
+// hostKeyAlgorithms returns the signature algorithms a key of keyType can sign
+// with
+func hostKeyAlgorithms(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+
+	return []string{keyType}
+}
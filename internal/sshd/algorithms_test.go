@@ -0,0 +1,141 @@
+package sshd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/testhelper"
+)
+
+func TestAlgorithmsPolicy(t *testing.T) {
+	policy := algorithmsPolicy(config.ServerConfig{
+		MACs:          []string{"hmac-sha2-256"},
+		KexAlgorithms: []string{"curve25519-sha256"},
+		Ciphers:       []string{"aes256-ctr"},
+		Algorithms: config.AlgorithmsConfig{
+			MACs:              []string{"hmac-sha2-512-etm@openssh.com"},
+			HostKeyAlgorithms: []string{"ssh-ed25519"},
+		},
+	})
+
+	require.Equal(t, config.AlgorithmsConfig{
+		MACs:              []string{"hmac-sha2-512-etm@openssh.com"},
+		KexAlgorithms:     []string{"curve25519-sha256"},
+		Ciphers:           []string{"aes256-ctr"},
+		HostKeyAlgorithms: []string{"ssh-ed25519"},
+	}, policy)
+}
+
+func TestValidateAlgorithms(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		policy        config.AlgorithmsConfig
+		expectedError string
+	}{
+		{
+			desc: "known algorithms",
+			policy: config.AlgorithmsConfig{
+				KexAlgorithms:     []string{"curve25519-sha256", "diffie-hellman-group-exchange-sha256"},
+				Ciphers:           []string{"chacha20-poly1305@openssh.com"},
+				MACs:              []string{"hmac-sha2-256-etm@openssh.com"},
+				HostKeyAlgorithms: []string{"rsa-sha2-512", "ssh-ed25519"},
+			},
+		},
+		{
+			desc:          "unknown key exchange",
+			policy:        config.AlgorithmsConfig{KexAlgorithms: []string{"sntrup761x25519-sha512@openssh.com"}},
+			expectedError: `unknown key exchange algorithm "sntrup761x25519-sha512@openssh.com"`,
+		},
+		{
+			desc:          "unknown cipher",
+			policy:        config.AlgorithmsConfig{Ciphers: []string{"aes128-gcm"}},
+			expectedError: `unknown cipher algorithm "aes128-gcm"`,
+		},
+		{
+			desc:          "unknown MAC",
+			policy:        config.AlgorithmsConfig{MACs: []string{"umac-128-etm@openssh.com"}},
+			expectedError: `unknown MAC algorithm "umac-128-etm@openssh.com"`,
+		},
+		{
+			desc:          "unknown host key algorithm",
+			policy:        config.AlgorithmsConfig{HostKeyAlgorithms: []string{"ssh-ed448"}},
+			expectedError: `unknown host key algorithm "ssh-ed448"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := validateAlgorithms(tc.policy)
+			if tc.expectedError == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.expectedError)
+			}
+		})
+	}
+}
+
+func TestRestrictHostKeys(t *testing.T) {
+	testRoot := testhelper.PrepareTestRootDir(t)
+
+	keyRaw, err := os.ReadFile(path.Join(testRoot, "certs/valid/server.key"))
+	require.NoError(t, err)
+	rsaKey, err := ssh.ParsePrivateKey(keyRaw)
+	require.NoError(t, err)
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ed25519Key, err := ssh.NewSignerFromKey(privateKey)
+	require.NoError(t, err)
+
+	hostKeys := []ssh.Signer{rsaKey, ed25519Key}
+
+	algorithms := func(hostKeys []ssh.Signer) [][]string {
+		var algorithms [][]string
+		for _, hostKey := range hostKeys {
+			algorithms = append(algorithms, hostKey.(ssh.MultiAlgorithmSigner).Algorithms())
+		}
+
+		return algorithms
+	}
+
+	require.Equal(t, [][]string{{"rsa-sha2-512", "rsa-sha2-256"}, {"ssh-ed25519"}}, algorithms(restrictHostKeys(hostKeys, nil)))
+	require.Equal(t, [][]string{{"rsa-sha2-256"}}, algorithms(restrictHostKeys(hostKeys, []string{"rsa-sha2-256"})))
+	require.Equal(t, [][]string{{"ssh-ed25519"}}, algorithms(restrictHostKeys(hostKeys, []string{"ssh-ed25519"})))
+	require.Equal(t, [][]string{{"rsa-sha2-512", "rsa-sha2-256", "ssh-rsa"}}, algorithms(restrictHostKeys(hostKeys, []string{"ssh-rsa", "rsa-sha2-256", "rsa-sha2-512"})))
+}
+
+func TestNewServerConfigWithUnknownAlgorithm(t *testing.T) {
+	testRoot := testhelper.PrepareTestRootDir(t)
+
+	_, err := newServerConfig(&config.Config{
+		GitlabUrl: "http://localhost",
+		Server: config.ServerConfig{
+			HostKeyFiles: []string{path.Join(testRoot, "certs/valid/server.key")},
+			Algorithms:   config.AlgorithmsConfig{Ciphers: []string{"blowfish-cbc"}},
+		},
+	})
+
+	require.EqualError(t, err, `invalid algorithms configuration: unknown cipher algorithm "blowfish-cbc"`)
+}
+
+func TestNewServerConfigWithoutAllowedHostKeys(t *testing.T) {
+	testRoot := testhelper.PrepareTestRootDir(t)
+
+	_, err := newServerConfig(&config.Config{
+		GitlabUrl: "http://localhost",
+		Server: config.ServerConfig{
+			HostKeyFiles: []string{path.Join(testRoot, "certs/valid/server.key")},
+			Algorithms:   config.AlgorithmsConfig{HostKeyAlgorithms: []string{"ssh-ed25519"}},
+		},
+	})
+
+	require.EqualError(t, err, "no host keys allowed by the host key algorithms, aborting")
+}
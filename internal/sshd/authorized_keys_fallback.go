@@ -0,0 +1,170 @@
+package sshd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+const defaultFallbackRefreshInterval = time.Minute
+
+// fallbackExtension marks the permissions of the connections authenticated
+// by the authorized keys fallback
+const fallbackExtension = "authorized-keys-fallback"
+
+// readOnlyCommands are the commands the sessions authenticated by the fallback
+// may run
+var readOnlyCommands = []commandargs.CommandType{commandargs.UploadPack, commandargs.UploadArchive}
+
+var errReadOnlySession = errors.New("GitLab is unavailable, only fetches and archives are allowed")
+
+// keyIDRegexp finds the key ID in the command option GitLab writes for each
+// key, e.g. command="/opt/gitlab/embedded/service/gitlab-shell/bin/gitlab-shell key-42"
+var keyIDRegexp = regexp.MustCompile(`(?:^|\s)key-(\d+)$`)
+
+// authorizedKeysFallback looks keys up in an authorized_keys file, reloaded
+// when it changes
+type authorizedKeysFallback struct {
+	path            string
+	refreshInterval time.Duration
+	now             func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]int64
+	modTime   time.Time
+	size      int64
+	checkedAt time.Time
+}
+
+func newAuthorizedKeysFallback(cfg config.AuthorizedKeysFallbackConfig) (*authorizedKeysFallback, error) {
+	if cfg.File == "" {
+		return nil, nil
+	}
+
+	f := &authorizedKeysFallback{
+		path:            cfg.File,
+		refreshInterval: time.Duration(cfg.RefreshInterval),
+		now:             time.Now,
+	}
+	if f.refreshInterval <= 0 {
+		f.refreshInterval = defaultFallbackRefreshInterval
+	}
+
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// lookup returns the ID of key, if it's in the file
+func (f *authorizedKeysFallback) lookup(key ssh.PublicKey) (int64, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if now := f.now(); now.Sub(f.checkedAt) >= f.refreshInterval {
+		f.checkedAt = now
+		if err := f.reloadIfChanged(); err != nil {
+			log.WithFields(log.Fields{"file": f.path}).WithError(err).Warn("authorized keys fallback: failed to reload the file, keeping the keys loaded before")
+		}
+	}
+
+	id, ok := f.keys[string(key.Marshal())]
+
+	return id, ok
+}
+
+func (f *authorizedKeysFallback) reloadIfChanged() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return nil
+	}
+
+	return f.reload()
+}
+
+func (f *authorizedKeysFallback) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(filepath.Clean(f.path))
+	if err != nil {
+		return err
+	}
+
+	keys, err := parseAuthorizedKeys(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+
+	f.keys, f.modTime, f.size = keys, info.ModTime(), info.Size()
+	f.checkedAt = f.now()
+
+	return nil
+}
+
+// parseAuthorizedKeys returns the IDs of the keys of an authorized_keys file,
+// by marshaled key. Lines without a key ID, such as those added by hand, are
+// skipped.
+func parseAuthorizedKeys(data []byte) (map[string]int64, error) {
+	keys := make(map[string]int64)
+
+	for lineNumber, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		key, _, options, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber+1, err)
+		}
+
+		if id, ok := keyID(options); ok {
+			keys[string(key.Marshal())] = id
+		}
+	}
+
+	return keys, nil
+}
+
+// keyID returns the key ID of the command option among options
+func keyID(options []string) (int64, bool) {
+	for _, option := range options {
+		command, ok := strings.CutPrefix(option, "command=")
+		if !ok {
+			continue
+		}
+
+		match := keyIDRegexp.FindStringSubmatch(strings.Trim(command, `"`))
+		if match == nil {
+			return 0, false
+		}
+
+		id, err := strconv.ParseInt(match[1], 10, 64)
+
+		return id, err == nil
+	}
+
+	return 0, false
+}
@@ -0,0 +1,131 @@
+package sshd
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/authorizedkeys"
+)
+
+func authorizedKeyLine(key ssh.PublicKey, id int) string {
+	return fmt.Sprintf(`command="/opt/gitlab/embedded/service/gitlab-shell/bin/gitlab-shell key-%d",no-port-forwarding,no-X11-forwarding,no-agent-forwarding,no-pty %s`, id, ssh.MarshalAuthorizedKey(key))
+}
+
+func writeAuthorizedKeys(t *testing.T, path string, lines ...string) {
+	t.Helper()
+
+	var data []byte
+	for _, line := range lines {
+		data = append(data, line...)
+	}
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+}
+
+func TestParseAuthorizedKeys(t *testing.T) {
+	first, second, manual := rsaPublicKey(t), newSKEd25519Signer(t).PublicKey(), skECDSAPublicKey(t)
+
+	keys, err := parseAuthorizedKeys([]byte("# Managed by GitLab\n\n" +
+		authorizedKeyLine(first, 1) +
+		authorizedKeyLine(second, 22) +
+		string(ssh.MarshalAuthorizedKey(manual)) +
+		`command="/usr/bin/backup" ` + string(ssh.MarshalAuthorizedKey(manual))))
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{string(first.Marshal()): 1, string(second.Marshal()): 22}, keys)
+
+	_, err = parseAuthorizedKeys([]byte(authorizedKeyLine(first, 1) + "ssh-rsa not-a-key\n"))
+	require.EqualError(t, err, "line 2: ssh: no key found")
+}
+
+func TestAuthorizedKeysFallbackReload(t *testing.T) {
+	first, second := rsaPublicKey(t), skECDSAPublicKey(t)
+
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	writeAuthorizedKeys(t, path, authorizedKeyLine(first, 1))
+
+	f, err := newAuthorizedKeysFallback(config.AuthorizedKeysFallbackConfig{File: path, RefreshInterval: config.YamlDuration(time.Minute)})
+	require.NoError(t, err)
+
+	now := time.Now()
+	f.now = func() time.Time { return now }
+
+	id, ok := f.lookup(first)
+	require.True(t, ok)
+	require.Equal(t, int64(1), id)
+
+	writeAuthorizedKeys(t, path, authorizedKeyLine(second, 2))
+
+	_, ok = f.lookup(second)
+	require.False(t, ok, "the file isn't checked before the refresh interval")
+
+	now = now.Add(time.Minute)
+	id, ok = f.lookup(second)
+	require.True(t, ok)
+	require.Equal(t, int64(2), id)
+
+	_, ok = f.lookup(first)
+	require.False(t, ok)
+
+	require.NoError(t, os.Remove(path))
+	now = now.Add(time.Minute)
+	_, ok = f.lookup(second)
+	require.True(t, ok, "the keys are kept when the file can't be reloaded")
+
+	_, err = newAuthorizedKeysFallback(config.AuthorizedKeysFallbackConfig{File: path})
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestUserKeyFallback(t *testing.T) {
+	known, unavailable, refused := rsaPublicKey(t), skECDSAPublicKey(t), newSKEd25519Signer(t).PublicKey()
+
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/authorized_keys",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Query().Get("key") {
+				case base64.RawStdEncoding.EncodeToString(known.Marshal()):
+					w.Write([]byte(`{ "id": 1, "key": "key" }`))
+				case base64.RawStdEncoding.EncodeToString(refused.Marshal()):
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`{ "message": "404 Not found" }`))
+				default:
+					w.WriteHeader(http.StatusBadGateway)
+				}
+			},
+		},
+	}
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	writeAuthorizedKeys(t, path, authorizedKeyLine(known, 10), authorizedKeyLine(unavailable, 20), authorizedKeyLine(refused, 30))
+
+	cfg := &config.Config{GitlabUrl: url, User: "user"}
+	s := &serverConfig{cfg: cfg}
+
+	var err error
+	s.authorizedKeysClient, err = authorizedkeys.NewClient(cfg)
+	require.NoError(t, err)
+	s.keysFallback, err = newAuthorizedKeysFallback(config.AuthorizedKeysFallbackConfig{File: path})
+	require.NoError(t, err)
+
+	permissions, err := s.handleUserKey(context.Background(), "user", known)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"key-id": "1"}, permissions.Extensions, "the API takes precedence")
+
+	permissions, err = s.handleUserKey(context.Background(), "user", unavailable)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"key-id": "20", fallbackExtension: "true"}, permissions.Extensions)
+
+	_, err = s.handleUserKey(context.Background(), "user", refused)
+	require.EqualError(t, err, "404 Not found", "keys refused by the API aren't looked up")
+}
@@ -0,0 +1,153 @@
+package sshd
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/time/rate"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+// Bandwidth limits a session may wait for
+const (
+	bandwidthLimitSession = "session"
+	bandwidthLimitGlobal  = "global"
+)
+
+// bandwidthLimiter enforces config.BandwidthLimitsConfig: a token bucket of
+// bytes shared by all the sessions, and one per session
+type bandwidthLimiter struct {
+	perSession int64
+	global     *rate.Limiter
+}
+
+func newBandwidthLimiter(cfg config.BandwidthLimitsConfig) *bandwidthLimiter {
+	if cfg.PerSession <= 0 && cfg.Global <= 0 {
+		return nil
+	}
+
+	l := &bandwidthLimiter{perSession: cfg.PerSession}
+	if cfg.Global > 0 {
+		l.global = newBandwidthBucket(cfg.Global)
+	}
+
+	return l
+}
+
+// newBandwidthBucket lets a second worth of bytes through at once
+func newBandwidthBucket(bytesPerSecond int64) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(bytesPerSecond))
+}
+
+type bandwidthBucket struct {
+	limit   string
+	limiter *rate.Limiter
+}
+
+// throttle wraps the channel of a session so that the data read from and
+// written to it waits for the limits. Its stderr isn't throttled.
+func (l *bandwidthLimiter) throttle(ctx context.Context, channel ssh.Channel) ssh.Channel {
+	if l == nil {
+		return channel
+	}
+
+	c := &throttledChannel{Channel: channel, ctx: ctx}
+
+	if l.perSession > 0 {
+		c.buckets = append(c.buckets, bandwidthBucket{limit: bandwidthLimitSession, limiter: newBandwidthBucket(l.perSession)})
+	}
+
+	if l.global != nil {
+		c.buckets = append(c.buckets, bandwidthBucket{limit: bandwidthLimitGlobal, limiter: l.global})
+	}
+
+	c.chunkSize = c.buckets[0].limiter.Burst()
+	for _, bucket := range c.buckets[1:] {
+		c.chunkSize = min(c.chunkSize, bucket.limiter.Burst())
+	}
+
+	return c
+}
+
+type throttledChannel struct {
+	ssh.Channel
+	ctx     context.Context
+	buckets []bandwidthBucket
+	// chunkSize is the most bytes all the buckets let through at once
+	chunkSize int
+}
+
+// Read waits for the bytes once they are read, as their number isn't known
+// before. Reads are capped to a chunk so that they never wait for longer
+// than a second per bucket.
+func (c *throttledChannel) Read(p []byte) (int, error) {
+	if len(p) > c.chunkSize {
+		p = p[:c.chunkSize]
+	}
+
+	n, err := c.Channel.Read(p)
+	if n > 0 {
+		if waitErr := c.wait(n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+
+	return n, err
+}
+
+func (c *throttledChannel) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		chunk := p[:min(len(p), c.chunkSize)]
+
+		if err := c.wait(len(chunk)); err != nil {
+			return written, err
+		}
+
+		n, err := c.Channel.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+
+		p = p[len(chunk):]
+	}
+
+	return written, nil
+}
+
+// wait takes n bytes out of each bucket in turn, waiting for them to refill
+// if needed
+func (c *throttledChannel) wait(n int) error {
+	for _, bucket := range c.buckets {
+		reservation := bucket.limiter.ReserveN(time.Now(), n)
+		delay := reservation.Delay()
+		if delay == 0 {
+			continue
+		}
+
+		metrics.SshdThrottledStreams.WithLabelValues(bucket.limit).Inc()
+		started := time.Now()
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-timer.C:
+		case <-c.ctx.Done():
+			timer.Stop()
+			reservation.Cancel()
+		}
+
+		metrics.SshdThrottledStreams.WithLabelValues(bucket.limit).Dec()
+		metrics.SshdThrottledSecondsTotal.WithLabelValues(bucket.limit).Add(time.Since(started).Seconds())
+
+		if err := c.ctx.Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package sshd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func TestBandwidthLimiterDisabled(t *testing.T) {
+	l := newBandwidthLimiter(config.BandwidthLimitsConfig{})
+	require.Nil(t, l)
+
+	channel := &fakeChannel{}
+	require.Same(t, channel, l.throttle(context.Background(), channel))
+}
+
+func TestBandwidthPerSession(t *testing.T) {
+	l := newBandwidthLimiter(config.BandwidthLimitsConfig{PerSession: 1000})
+
+	out := &bytes.Buffer{}
+	channel := l.throttle(context.Background(), &fakeChannel{stdOut: out})
+
+	started := time.Now()
+	n, err := channel.Write([]byte(strings.Repeat("a", 1500)))
+	require.NoError(t, err)
+	require.Equal(t, 1500, n)
+	require.Equal(t, 1500, out.Len())
+	require.GreaterOrEqual(t, time.Since(started), 400*time.Millisecond, "the bytes beyond the burst wait for the bucket")
+
+	other := l.throttle(context.Background(), &fakeChannel{stdOut: &bytes.Buffer{}})
+	started = time.Now()
+	_, err = other.Write([]byte(strings.Repeat("a", 1000)))
+	require.NoError(t, err)
+	require.Less(t, time.Since(started), 400*time.Millisecond, "other sessions have their own bucket")
+}
+
+func TestBandwidthGlobal(t *testing.T) {
+	l := newBandwidthLimiter(config.BandwidthLimitsConfig{PerSession: 10000, Global: 1000})
+
+	first := l.throttle(context.Background(), &fakeChannel{stdOut: &bytes.Buffer{}})
+	_, err := first.Write([]byte(strings.Repeat("a", 1000)))
+	require.NoError(t, err)
+
+	second := l.throttle(context.Background(), &fakeChannel{stdOut: &bytes.Buffer{}})
+	started := time.Now()
+	_, err = second.Write([]byte(strings.Repeat("a", 200)))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(started), 150*time.Millisecond, "the sessions share the global bucket")
+}
+
+func TestBandwidthCanceled(t *testing.T) {
+	l := newBandwidthLimiter(config.BandwidthLimitsConfig{PerSession: 100})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := &bytes.Buffer{}
+	channel := l.throttle(ctx, &fakeChannel{stdOut: out})
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	n, err := channel.Write([]byte(strings.Repeat("a", 1000)))
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 100, n, "only the burst is written")
+}
@@ -0,0 +1,165 @@
+package sshd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// meteredChannel counts the bytes sent and received on a channel, including
+// its stderr, and records the last time any were
+type meteredChannel struct {
+	ssh.Channel
+	lastActivity atomic.Int64
+	bytesIn      atomic.Int64
+	bytesOut     atomic.Int64
+	// firstWrite is when the first byte was written to the channel, in
+	// nanoseconds since the epoch, not counting stderr
+	firstWrite atomic.Int64
+}
+
+func newMeteredChannel(channel ssh.Channel) *meteredChannel {
+	c := &meteredChannel{Channel: channel}
+	c.touch()
+
+	return c
+}
+
+func (c *meteredChannel) Read(p []byte) (int, error) {
+	n, err := c.Channel.Read(p)
+	c.received(n)
+
+	return n, err
+}
+
+func (c *meteredChannel) Write(p []byte) (int, error) {
+	n, err := c.Channel.Write(p)
+	if n > 0 {
+		c.firstWrite.CompareAndSwap(0, time.Now().UnixNano())
+	}
+	c.sent(n)
+
+	return n, err
+}
+
+// firstSent returns when the first byte was written to the channel, if any
+func (c *meteredChannel) firstSent() (time.Time, bool) {
+	if c == nil {
+		return time.Time{}, false
+	}
+
+	nanos := c.firstWrite.Load()
+
+	return time.Unix(0, nanos), nanos != 0
+}
+
+func (c *meteredChannel) Stderr() io.ReadWriter {
+	return &meteredStderr{ReadWriter: c.Channel.Stderr(), channel: c}
+}
+
+func (c *meteredChannel) received(n int) {
+	if n > 0 {
+		c.bytesIn.Add(int64(n))
+		c.touch()
+	}
+}
+
+func (c *meteredChannel) sent(n int) {
+	if n > 0 {
+		c.bytesOut.Add(int64(n))
+		c.touch()
+	}
+}
+
+func (c *meteredChannel) touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (c *meteredChannel) idleFor() time.Duration {
+	return time.Since(time.Unix(0, c.lastActivity.Load()))
+}
+
+type meteredStderr struct {
+	io.ReadWriter
+	channel *meteredChannel
+}
+
+func (s *meteredStderr) Read(p []byte) (int, error) {
+	n, err := s.ReadWriter.Read(p)
+	s.channel.received(n)
+
+	return n, err
+}
+
+func (s *meteredStderr) Write(p []byte) (int, error) {
+	n, err := s.ReadWriter.Write(p)
+	s.channel.sent(n)
+
+	return n, err
+}
+
+// maxPendingRequests is how many requests of a channel are queued while its
+// session is busy, such as running a command. Later ones are refused.
+const maxPendingRequests = 16
+
+// errChannelClosed cancels the context of a session whose channel was closed
+// by the client, or along with its connection
+var errChannelClosed = errors.New("channel closed")
+
+// watchRequests forwards requests, canceling ctx with errChannelClosed as soon
+// as they're closed, i.e. the channel is, so that the command of the session
+// and its RPCs don't outlive it. The requests received while the session is
+// busy are queued, so that the closing is noticed meanwhile. The returned
+// channel is closed along with requests, or once ctx is done.
+func watchRequests(ctx context.Context, cancel context.CancelCauseFunc, requests <-chan *ssh.Request) <-chan *ssh.Request {
+	forwarded := make(chan *ssh.Request)
+
+	go func() {
+		defer close(forwarded)
+
+		var pending []*ssh.Request
+		defer func() {
+			for _, req := range pending {
+				refuse(req)
+			}
+		}()
+
+		for {
+			var next *ssh.Request
+			var out chan<- *ssh.Request
+			if len(pending) > 0 {
+				next, out = pending[0], forwarded
+			}
+
+			select {
+			case req, ok := <-requests:
+				if !ok {
+					cancel(errChannelClosed)
+					return
+				}
+
+				if len(pending) >= maxPendingRequests {
+					refuse(req)
+					continue
+				}
+				pending = append(pending, req)
+			case out <- next:
+				pending = pending[1:]
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return forwarded
+}
+
+func refuse(req *ssh.Request) {
+	if req.WantReply {
+		_ = req.Reply(false, nil)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"net"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -16,7 +17,9 @@ import (
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disallowedcommand"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/commandlimiter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
 
 	"gitlab.com/gitlab-org/labkit/log"
@@ -39,6 +42,34 @@ type connection struct {
 	nconn              net.Conn
 	maxSessions        int64
 	remoteAddr         string
+	slot               *connectionSlot
+	// tarpit is told of the failures to authenticate
+	tarpit *tarpit
+	// hostKeys are announced to clients and proven on request, see
+	// announceHostKeys
+	hostKeys []ssh.Signer
+	// trustedGateway allows the peer to report the address of the client
+	// it proxies, stored in clientAddr, see handleProxyInfo
+	trustedGateway bool
+	clientAddr     atomic.Pointer[string]
+	// noMoreSessions refuses any new session channel, see NoMoreSessionsMsg
+	noMoreSessions atomic.Bool
+	// sessions counts the session channels accepted on the connection, which
+	// clients such as OpenSSH with ControlMaster open one after the other or
+	// at once
+	sessions int64
+	// stages times the stages of the connection, from its acceptance
+	stages *stageTimer
+}
+
+type sessionNumberKey struct{}
+
+// sessionNumber returns the number of the session handled with ctx on its
+// connection, starting at 1
+func sessionNumber(ctx context.Context) int64 {
+	n, _ := ctx.Value(sessionNumberKey{}).(int64)
+
+	return n
 }
 
 type channelHandler func(context.Context, *ssh.ServerConn, ssh.Channel, <-chan *ssh.Request) error
@@ -52,6 +83,7 @@ func newConnection(cfg *config.Config, nconn net.Conn) *connection {
 		concurrentSessions: semaphore.NewWeighted(maxSessions),
 		nconn:              nconn,
 		remoteAddr:         nconn.RemoteAddr().String(),
+		stages:             newStageTimer(time.Now()),
 	}
 }
 
@@ -63,10 +95,20 @@ func (c *connection) handle(ctx context.Context, srvCfg *ssh.ServerConfig, handl
 		return
 	}
 
+	if err := c.slot.authenticate(); err != nil {
+		log.WithContextFields(ctx, log.Fields{"remote_addr": c.remoteAddr}).WithError(err).Info("connection: handle: connection refused")
+		_ = sconn.Close()
+		return
+	}
+
+	if len(c.hostKeys) > 0 {
+		c.announceHostKeys(ctx, sconn)
+	}
+
 	if c.cfg.Server.ClientAliveInterval > 0 {
 		ticker := time.NewTicker(time.Duration(c.cfg.Server.ClientAliveInterval))
 		defer ticker.Stop()
-		go c.sendKeepAliveMsg(ctx, sconn, ticker)
+		go c.sendKeepAliveMsg(ctx, sconn, ticker, c.cfg.Server.ClientAliveCountMax)
 	}
 
 	c.handleRequests(ctx, sconn, chans, handler)
@@ -81,8 +123,16 @@ func (c *connection) initServerConn(ctx context.Context, srvCfg *ssh.ServerConfi
 		defer func() { _ = c.nconn.SetDeadline(time.Time{}) }()
 	}
 
-	sconn, chans, reqs, err := ssh.NewServerConn(c.nconn, srvCfg)
+	c.stages.end(stageAccept)
+
+	started := time.Now()
+	sconn, chans, reqs, err := ssh.NewServerConn(&bannerConn{Conn: c.nconn, stages: c.stages}, c.timedServerConfig(srvCfg))
 	if err != nil {
+		metrics.SshdHandshakeFailuresTotal.Inc()
+		if isAuthFailure(err) {
+			c.tarpit.recordFailure(gitlabnet.ParseIP(c.remoteAddr))
+		}
+
 		msg := "connection: initServerConn: failed to initialize SSH connection"
 		logger := log.WithContextFields(ctx, log.Fields{"remote_addr": c.remoteAddr}).WithError(err)
 
@@ -94,11 +144,46 @@ func (c *connection) initServerConn(ctx context.Context, srvCfg *ssh.ServerConfi
 
 		return nil, nil, err
 	}
-	go ssh.DiscardRequests(reqs)
+	metrics.SshdHandshakeDuration.Observe(time.Since(started).Seconds())
+	c.stages.end(stageKEX)
+	c.stages.end(stageAuth)
+	go c.handleGlobalRequests(ctx, sconn, reqs)
 
 	return sconn, chans, err
 }
 
+// timedServerConfig returns a copy of srvCfg ending the key exchange stage
+// at the first authentication request, which follows it
+func (c *connection) timedServerConfig(srvCfg *ssh.ServerConfig) *ssh.ServerConfig {
+	timed := *srvCfg
+	endKEX := func() { c.stages.end(stageKEX) }
+
+	if callback := srvCfg.PublicKeyCallback; callback != nil {
+		timed.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			endKEX()
+			return callback(conn, key)
+		}
+	}
+
+	if callback := srvCfg.KeyboardInteractiveCallback; callback != nil {
+		timed.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			endKEX()
+			return callback(conn, client)
+		}
+	}
+
+	// Clients usually start with the none method, only seen by this callback
+	authLog := srvCfg.AuthLogCallback
+	timed.AuthLogCallback = func(conn ssh.ConnMetadata, method string, err error) {
+		endKEX()
+		if authLog != nil {
+			authLog(conn, method, err)
+		}
+	}
+
+	return &timed
+}
+
 func (c *connection) handleRequests(ctx context.Context, sconn *ssh.ServerConn, chans <-chan ssh.NewChannel, handler channelHandler) {
 	ctxlog := log.WithContextFields(ctx, log.Fields{"remote_addr": c.remoteAddr})
 
@@ -111,6 +196,12 @@ func (c *connection) handleRequests(ctx context.Context, sconn *ssh.ServerConn,
 			continue
 		}
 
+		if c.noMoreSessions.Load() {
+			ctxlog.Info("connection: handleRequests: no more sessions allowed")
+			_ = newChannel.Reject(ssh.Prohibited, "no more sessions")
+			continue
+		}
+
 		if !c.concurrentSessions.TryAcquire(1) {
 			ctxlog.Info("connection: handleRequests: too many concurrent sessions")
 			_ = newChannel.Reject(ssh.ResourceShortage, "too many concurrent sessions")
@@ -118,6 +209,13 @@ func (c *connection) handleRequests(ctx context.Context, sconn *ssh.ServerConn,
 			continue
 		}
 
+		if limit := c.cfg.Server.MaxSessionsPerConnection; limit > 0 && c.sessions >= limit {
+			ctxlog.WithField("sessions", c.sessions).Info("connection: handleRequests: too many sessions on the connection")
+			_ = newChannel.Reject(ssh.Prohibited, "too many sessions on this connection")
+			c.concurrentSessions.Release(1)
+			continue
+		}
+
 		channel, requests, err := newChannel.Accept()
 		if err != nil {
 			ctxlog.WithError(err).Error("connection: handleRequests: accepting channel failed")
@@ -125,7 +223,13 @@ func (c *connection) handleRequests(ctx context.Context, sconn *ssh.ServerConn,
 			continue
 		}
 
+		c.sessions++
+		number := c.sessions
+
 		go func() {
+			ctx := context.WithValue(ctx, sessionNumberKey{}, number)
+			ctxlog := ctxlog.WithField("session", number)
+
 			defer func(started time.Time) {
 				duration := time.Since(started).Seconds()
 				metrics.SshdSessionDuration.Observe(duration)
@@ -134,6 +238,9 @@ func (c *connection) handleRequests(ctx context.Context, sconn *ssh.ServerConn,
 
 			defer c.concurrentSessions.Release(1)
 
+			metrics.SshdSessionsInFlight.Inc()
+			defer metrics.SshdSessionsInFlight.Dec()
+
 			// Prevent a panic in a single session from taking out the whole server
 			defer func() {
 				if err := recover(); err != nil {
@@ -149,6 +256,10 @@ func (c *connection) handleRequests(ctx context.Context, sconn *ssh.ServerConn,
 		}()
 	}
 
+	if c.sessions > 0 {
+		metrics.SshdConnectionSessions.Observe(float64(c.sessions))
+	}
+
 	// When a connection has been prematurely closed we block execution until all concurrent sessions are released
 	// in order to allow Gitaly complete the operations and close all the channels gracefully.
 	// If it didn't happen within timeout, we unblock the execution
@@ -158,17 +269,48 @@ func (c *connection) handleRequests(ctx context.Context, sconn *ssh.ServerConn,
 	_ = c.concurrentSessions.Acquire(ctx, c.maxSessions)
 }
 
-func (c *connection) sendKeepAliveMsg(ctx context.Context, sconn *ssh.ServerConn, ticker *time.Ticker) {
+// sendKeepAliveMsg sends a keepalive message on every tick, and closes the
+// connection once maxMissed of them in a row went unanswered, unless
+// maxMissed is 0. A keepalive is only sent once the previous one is answered.
+func (c *connection) sendKeepAliveMsg(ctx context.Context, sconn *ssh.ServerConn, ticker *time.Ticker, maxMissed int) {
 	ctxlog := log.WithContextFields(ctx, log.Fields{"remote_addr": c.remoteAddr})
 
+	// Holds the reply to the single keepalive in flight, so that sending it
+	// never blocks once we're done
+	replies := make(chan struct{}, 1)
+	pending := false
+	missed := 0
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-replies:
+			pending = false
+			missed = 0
 		case <-ticker.C:
+			if pending {
+				missed++
+				if maxMissed > 0 && missed >= maxMissed {
+					ctxlog.WithField("missed_keepalives", missed).Info("connection: sendKeepAliveMsg: client stopped responding, closing connection")
+					metrics.SshdClientAliveTimeoutsTotal.Inc()
+
+					_ = sconn.Close()
+					return
+				}
+
+				continue
+			}
+
 			ctxlog.Debug("connection: sendKeepAliveMsg: send keepalive message to a client")
 
-			_, _, _ = sconn.SendRequest(KeepAliveMsg, true, nil)
+			pending = true
+			go func() {
+				// Clients that don't know the request still reply, with a failure
+				if _, _, err := sconn.SendRequest(KeepAliveMsg, true, nil); err == nil {
+					replies <- struct{}{}
+				}
+			}()
 		}
 	}
 }
@@ -179,12 +321,14 @@ func (c *connection) trackError(ctxlog *logrus.Entry, err error) {
 		return
 	}
 
-	if errors.Is(err, disallowedcommand.Error) {
+	if errors.Is(err, disallowedcommand.Error) || errors.Is(err, commandlimiter.ErrLimited) {
 		return
 	}
 
 	grpcCode := grpcstatus.Code(err)
-	if grpcCode == grpccodes.Canceled || grpcCode == grpccodes.Unavailable {
+	// Commands stopped at the deadline of the client or the session aren't
+	// failures of the server
+	if grpcCode == grpccodes.Canceled || grpcCode == grpccodes.Unavailable || grpcCode == grpccodes.DeadlineExceeded {
 		return
 	} else if grpcCode == grpccodes.Internal && strings.Contains(err.Error(), NotOurRefError) {
 		return
@@ -0,0 +1,214 @@
+package sshd
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/time/rate"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+// Reasons a connection is refused by the connectionLimiter
+const (
+	limitReasonBanned             = "banned"
+	limitReasonRateLimited        = "rate_limited"
+	limitReasonMaxConnections     = "max_connections"
+	limitReasonMaxUnauthenticated = "max_unauthenticated"
+	limitReasonMaxAuthenticated   = "max_authenticated"
+)
+
+// sourceSweepInterval is how often idle source IPs are forgotten
+var sourceSweepInterval = time.Minute
+
+type connectionLimitError struct {
+	reason string
+}
+
+func (e *connectionLimitError) Error() string {
+	return "connection limit reached: " + e.reason
+}
+
+// connectionLimiter enforces config.ConnectionLimitsConfig: a token bucket
+// per source IP, and caps on the connections open at once before and after
+// authentication
+type connectionLimiter struct {
+	cfg   config.ConnectionLimitsConfig
+	burst int
+	now   func() time.Time
+
+	total           *semaphore.Weighted
+	unauthenticated *semaphore.Weighted
+	authenticated   *semaphore.Weighted
+
+	mu        sync.Mutex
+	sources   map[string]*sourceLimit
+	lastSweep time.Time
+}
+
+type sourceLimit struct {
+	limiter     *rate.Limiter
+	bannedUntil time.Time
+	lastSeen    time.Time
+}
+
+func newConnectionLimiter(cfg config.ConnectionLimitsConfig) *connectionLimiter {
+	l := &connectionLimiter{
+		cfg:             cfg,
+		burst:           cfg.BurstPerIP,
+		now:             time.Now,
+		total:           newLimitSemaphore(cfg.MaxConnections),
+		unauthenticated: newLimitSemaphore(cfg.MaxUnauthenticated),
+		authenticated:   newLimitSemaphore(cfg.MaxAuthenticated),
+	}
+
+	if l.burst <= 0 {
+		l.burst = 1
+	}
+
+	if cfg.RatePerIP > 0 {
+		l.sources = make(map[string]*sourceLimit)
+	}
+
+	return l
+}
+
+// admit decides whether a new connection from ip is accepted. The returned
+// slot counts against the unauthenticated limit until it is authenticated,
+// and must be released once the connection is closed.
+func (l *connectionLimiter) admit(ip string) (*connectionSlot, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	if err := l.allowSource(ip); err != nil {
+		return nil, err
+	}
+
+	if !tryAcquire(l.total) {
+		return nil, reject(limitReasonMaxConnections)
+	}
+
+	if !tryAcquire(l.unauthenticated) {
+		release(l.total)
+
+		return nil, reject(limitReasonMaxUnauthenticated)
+	}
+
+	return &connectionSlot{limiter: l}, nil
+}
+
+func (l *connectionLimiter) allowSource(ip string) error {
+	if l.sources == nil {
+		return nil
+	}
+
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	src, ok := l.sources[ip]
+	if !ok {
+		src = &sourceLimit{limiter: rate.NewLimiter(rate.Limit(l.cfg.RatePerIP), l.burst)}
+		l.sources[ip] = src
+	}
+	src.lastSeen = now
+
+	if now.Before(src.bannedUntil) {
+		return reject(limitReasonBanned)
+	}
+
+	if !src.limiter.AllowN(now, 1) {
+		if l.cfg.BanDuration > 0 {
+			src.bannedUntil = now.Add(time.Duration(l.cfg.BanDuration))
+		}
+
+		return reject(limitReasonRateLimited)
+	}
+
+	return nil
+}
+
+// sweep forgets the source IPs that are no longer banned and have been idle
+// long enough for their bucket to refill, so that scanners cycling through
+// addresses don't grow the map without bound
+func (l *connectionLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sourceSweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	refill := time.Duration(float64(l.burst) / l.cfg.RatePerIP * float64(time.Second))
+
+	for ip, src := range l.sources {
+		if now.After(src.bannedUntil) && now.Sub(src.lastSeen) >= refill {
+			delete(l.sources, ip)
+		}
+	}
+}
+
+// connectionSlot is the share of the connection limits held by a connection
+type connectionSlot struct {
+	limiter       *connectionLimiter
+	authenticated bool
+}
+
+// authenticate moves the connection from the unauthenticated to the
+// authenticated limit
+func (s *connectionSlot) authenticate() error {
+	if s == nil || s.authenticated {
+		return nil
+	}
+
+	if !tryAcquire(s.limiter.authenticated) {
+		return reject(limitReasonMaxAuthenticated)
+	}
+
+	release(s.limiter.unauthenticated)
+	s.authenticated = true
+
+	return nil
+}
+
+func (s *connectionSlot) release() {
+	if s == nil {
+		return
+	}
+
+	if s.authenticated {
+		release(s.limiter.authenticated)
+	} else {
+		release(s.limiter.unauthenticated)
+	}
+
+	release(s.limiter.total)
+}
+
+func reject(reason string) error {
+	metrics.SshdRejectedConnectionsTotal.WithLabelValues(reason).Inc()
+
+	return &connectionLimitError{reason: reason}
+}
+
+func newLimitSemaphore(limit int64) *semaphore.Weighted {
+	if limit <= 0 {
+		return nil
+	}
+
+	return semaphore.NewWeighted(limit)
+}
+
+func tryAcquire(sem *semaphore.Weighted) bool {
+	return sem == nil || sem.TryAcquire(1)
+}
+
+func release(sem *semaphore.Weighted) {
+	if sem != nil {
+		sem.Release(1)
+	}
+}
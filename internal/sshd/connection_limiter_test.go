@@ -0,0 +1,140 @@
+package sshd
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func requireLimited(t *testing.T, err error, reason string) {
+	t.Helper()
+
+	var limitErr *connectionLimitError
+	require.True(t, errors.As(err, &limitErr), "expected a connection limit error, got %v", err)
+	require.Equal(t, reason, limitErr.reason)
+}
+
+func TestConnectionLimiterRatePerIP(t *testing.T) {
+	now := time.Now()
+	l := newConnectionLimiter(config.ConnectionLimitsConfig{RatePerIP: 1, BurstPerIP: 2})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		slot, err := l.admit("10.0.0.1")
+		require.NoError(t, err)
+		slot.release()
+	}
+
+	_, err := l.admit("10.0.0.1")
+	requireLimited(t, err, limitReasonRateLimited)
+
+	_, err = l.admit("10.0.0.2")
+	require.NoError(t, err, "other source IPs have their own bucket")
+
+	now = now.Add(time.Second)
+	_, err = l.admit("10.0.0.1")
+	require.NoError(t, err, "the bucket refills over time")
+}
+
+func TestConnectionLimiterBanDuration(t *testing.T) {
+	now := time.Now()
+	l := newConnectionLimiter(config.ConnectionLimitsConfig{
+		RatePerIP:   1,
+		BanDuration: config.YamlDuration(time.Minute),
+	})
+	l.now = func() time.Time { return now }
+
+	_, err := l.admit("10.0.0.1")
+	require.NoError(t, err)
+
+	_, err = l.admit("10.0.0.1")
+	requireLimited(t, err, limitReasonRateLimited)
+
+	now = now.Add(30 * time.Second)
+	_, err = l.admit("10.0.0.1")
+	requireLimited(t, err, limitReasonBanned)
+
+	now = now.Add(31 * time.Second)
+	_, err = l.admit("10.0.0.1")
+	require.NoError(t, err)
+}
+
+func TestConnectionLimiterSweepsIdleSources(t *testing.T) {
+	now := time.Now()
+	l := newConnectionLimiter(config.ConnectionLimitsConfig{
+		RatePerIP:   1,
+		BanDuration: config.YamlDuration(time.Hour),
+	})
+	l.now = func() time.Time { return now }
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		_, err := l.admit(ip)
+		require.NoError(t, err)
+	}
+	_, err := l.admit("10.0.0.2")
+	requireLimited(t, err, limitReasonRateLimited)
+
+	now = now.Add(2 * sourceSweepInterval)
+	_, err = l.admit("10.0.0.3")
+	require.NoError(t, err)
+
+	require.NotContains(t, l.sources, "10.0.0.1")
+	require.Contains(t, l.sources, "10.0.0.2", "banned sources are kept")
+	require.Contains(t, l.sources, "10.0.0.3")
+}
+
+func TestConnectionLimiterMaxConnections(t *testing.T) {
+	l := newConnectionLimiter(config.ConnectionLimitsConfig{MaxConnections: 1})
+
+	slot, err := l.admit("10.0.0.1")
+	require.NoError(t, err)
+	require.NoError(t, slot.authenticate())
+
+	_, err = l.admit("10.0.0.2")
+	requireLimited(t, err, limitReasonMaxConnections)
+
+	slot.release()
+
+	_, err = l.admit("10.0.0.2")
+	require.NoError(t, err)
+}
+
+func TestConnectionLimiterAuthenticationLimits(t *testing.T) {
+	l := newConnectionLimiter(config.ConnectionLimitsConfig{
+		MaxUnauthenticated: 1,
+		MaxAuthenticated:   1,
+	})
+
+	first, err := l.admit("10.0.0.1")
+	require.NoError(t, err)
+
+	_, err = l.admit("10.0.0.2")
+	requireLimited(t, err, limitReasonMaxUnauthenticated)
+
+	require.NoError(t, first.authenticate())
+
+	second, err := l.admit("10.0.0.2")
+	require.NoError(t, err, "authenticating frees an unauthenticated slot")
+
+	requireLimited(t, second.authenticate(), limitReasonMaxAuthenticated)
+	second.release()
+
+	first.release()
+
+	third, err := l.admit("10.0.0.3")
+	require.NoError(t, err)
+	require.NoError(t, third.authenticate())
+}
+
+func TestNilConnectionLimiter(t *testing.T) {
+	var l *connectionLimiter
+
+	slot, err := l.admit("10.0.0.1")
+	require.NoError(t, err)
+	require.NoError(t, slot.authenticate())
+	slot.release()
+}
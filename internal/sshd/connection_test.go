@@ -3,7 +3,10 @@ package sshd
 import (
 	"context"
 	"errors"
+	"io"
+	"net"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -194,11 +197,55 @@ func TestClientAliveInterval(t *testing.T) {
 	defer ticker.Stop()
 
 	conn := &connection{}
-	go conn.sendKeepAliveMsg(context.Background(), &ssh.ServerConn{Conn: f, Permissions: nil}, ticker)
+	go conn.sendKeepAliveMsg(context.Background(), &ssh.ServerConn{Conn: f, Permissions: nil}, ticker, 3)
 
 	require.Eventually(t, func() bool { return KeepAliveMsg == f.SentRequestName() }, time.Second, time.Millisecond)
 }
 
+// unresponsiveConn never gets a reply to the requests it sends
+type unresponsiveConn struct {
+	ssh.Conn
+
+	requests atomic.Int32
+	closed   chan struct{}
+}
+
+func (u *unresponsiveConn) SendRequest(_ string, _ bool, _ []byte) (bool, []byte, error) {
+	u.requests.Add(1)
+	<-u.closed
+
+	return false, nil, io.EOF
+}
+
+func (u *unresponsiveConn) Close() error {
+	close(u.closed)
+
+	return nil
+}
+
+func TestClientAliveCountMax(t *testing.T) {
+	u := &unresponsiveConn{closed: make(chan struct{})}
+	initialTimeouts := testutil.ToFloat64(metrics.SshdClientAliveTimeoutsTotal)
+
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		(&connection{}).sendKeepAliveMsg(context.Background(), &ssh.ServerConn{Conn: u}, ticker, 3)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		require.Fail(t, "the connection wasn't closed")
+	}
+
+	require.Equal(t, int32(1), u.requests.Load(), "a keepalive is only sent once the previous one is answered")
+	require.Equal(t, initialTimeouts+1, testutil.ToFloat64(metrics.SshdClientAliveTimeoutsTotal))
+}
+
 func TestSessionsMetrics(t *testing.T) {
 	// Unfortunately, there is no working way to reset Counter (not CounterVec)
 	// https://pkg.go.dev/github.com/prometheus/client_golang/prometheus#pkg-index
@@ -249,3 +296,32 @@ func eventuallyInDelta(t *testing.T, expected, actual float64) {
 		return ((expected - actual) < delta)
 	}, 1*time.Second, time.Millisecond)
 }
+
+func TestSessionsInFlightMetric(t *testing.T) {
+	initialInFlight := testutil.ToFloat64(metrics.SshdSessionsInFlight)
+
+	conn, chans := setup(&fakeNewChannel{channelType: "session"})
+
+	conn.handleRequests(context.Background(), nil, chans, func(context.Context, *ssh.ServerConn, ssh.Channel, <-chan *ssh.Request) error {
+		require.InDelta(t, initialInFlight+1, testutil.ToFloat64(metrics.SshdSessionsInFlight), 0.1)
+		close(chans)
+		return nil
+	})
+
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.SshdSessionsInFlight) == initialInFlight
+	}, time.Second, time.Millisecond)
+}
+
+func TestHandshakeFailuresMetric(t *testing.T) {
+	initialFailures := testutil.ToFloat64(metrics.SshdHandshakeFailuresTotal)
+
+	serverConn, clientConn := net.Pipe()
+	require.NoError(t, clientConn.Close())
+
+	conn := newConnection(&config.Config{}, serverConn)
+	_, _, err := conn.initServerConn(context.Background(), &ssh.ServerConfig{NoClientAuth: true})
+	require.Error(t, err)
+
+	require.InDelta(t, initialFailures+1, testutil.ToFloat64(metrics.SshdHandshakeFailuresTotal), 0.1)
+}
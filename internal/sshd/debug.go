@@ -0,0 +1,150 @@
+package sshd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	runtimepprof "runtime/pprof"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+// ErrDebugListenNotLoopback is returned when the debug listener would be
+// reachable from other hosts
+var ErrDebugListenNotLoopback = errors.New("debug_listen must be a loopback address")
+
+// ServeDebug serves the debug endpoints on the debug_listen address until ctx
+// is done. The address must only be reachable locally, since the endpoints
+// expose the internals of the process: a loopback address or a Unix socket.
+func (s *Server) ServeDebug(ctx context.Context) error {
+	listener, err := s.listenDebug(ctx)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:           s.DebugServeMux(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
+	address := listener.Addr()
+	log.WithContextFields(ctx, log.Fields{address.Network() + "_address": address.String()}).Info("Listening for debug requests")
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// listenDebug listens on debug_listen, which is a Unix socket or a loopback
+// address
+func (s *Server) listenDebug(ctx context.Context) (net.Listener, error) {
+	addr := s.Config.Server.DebugListen
+	if path, ok := unixSocketPath(addr); ok {
+		listener, err := listenUnix(path, s.Config.Server.DebugListenSocket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen for debug requests: %w", err)
+		}
+
+		return listener, nil
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid debug_listen: %w", err)
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid debug_listen: %w", err)
+	}
+
+	for _, ipAddr := range addrs {
+		if !ipAddr.IP.IsLoopback() {
+			return nil, fmt.Errorf("%w: %s", ErrDebugListenNotLoopback, addr)
+		}
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for debug requests: %w", err)
+	}
+
+	return listener, nil
+}
+
+// DebugServeMux returns the ServeMux of the debug endpoints: the pprof
+// profiles, a dump of all goroutines, the configuration in use, with its
+// secrets redacted, and the log levels and maintenance mode, which POST
+// requests change
+func (s *Server) DebugServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	mux.HandleFunc("/debug/goroutines", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_ = runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+	})
+
+	mux.HandleFunc("/debug/config", func(w http.ResponseWriter, _ *http.Request) {
+		cfg, _ := s.currentConfig()
+
+		dump, err := cfg.RedactedYAML()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/yaml")
+		_, _ = w.Write(dump)
+	})
+
+	mux.HandleFunc("/debug/log_level", serveLogLevel)
+	mux.HandleFunc("/debug/maintenance", s.serveMaintenance)
+
+	return mux
+}
+
+// serveLogLevel returns the log levels, after setting the level of the
+// component parameter, or the base level without one, to the level parameter
+// of a POST request
+func serveLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := logger.SetLevel(r.FormValue("component"), r.FormValue("level")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		log.WithContextFields(r.Context(), log.Fields{
+			"component": r.FormValue("component"),
+			"level":     r.FormValue("level"),
+		}).Info("debug: serveLogLevel: log level changed")
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(logger.Levels())
+}
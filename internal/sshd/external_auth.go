@@ -0,0 +1,187 @@
+package sshd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+const (
+	defaultExternalAuthTimeout = 5 * time.Second
+	// externalAuthResponseLimit bounds the verdict read from the authenticator
+	externalAuthResponseLimit = 64 * 1024
+)
+
+var errExternalAuthRejected = errors.New("rejected by the external authenticator")
+
+// externalAuthRequest describes the key to decide on to the authenticator
+type externalAuthRequest struct {
+	User        string `json:"user"`
+	Key         string `json:"key"`
+	KeyType     string `json:"key_type"`
+	Fingerprint string `json:"fingerprint"`
+	RemoteAddr  string `json:"remote_addr"`
+}
+
+// externalAuthVerdict is the answer of the authenticator: the GitLab user or
+// key that an allowed key authenticates
+type externalAuthVerdict struct {
+	Allowed  bool   `json:"allowed"`
+	Username string `json:"username,omitempty"`
+	KeyID    int64  `json:"key_id,omitempty"`
+}
+
+// externalAuthenticator asks a program, or an HTTP endpoint, configured by
+// the administrator whether to accept a public key
+type externalAuthenticator struct {
+	command []string
+	url     string
+	timeout time.Duration
+	client  *http.Client
+}
+
+func newExternalAuthenticator(cfg config.ExternalAuthenticatorConfig) *externalAuthenticator {
+	if len(cfg.Command) == 0 && cfg.URL == "" {
+		return nil
+	}
+
+	a := &externalAuthenticator{
+		command: cfg.Command,
+		url:     cfg.URL,
+		timeout: time.Duration(cfg.Timeout),
+		client:  &http.Client{},
+	}
+	if a.timeout <= 0 {
+		a.timeout = defaultExternalAuthTimeout
+	}
+
+	return a
+}
+
+// authenticate returns the permissions of key if the authenticator allows it
+func (a *externalAuthenticator) authenticate(ctx context.Context, user string, remoteAddr string, key ssh.PublicKey) (*ssh.Permissions, error) {
+	request := externalAuthRequest{
+		User:        user,
+		Key:         strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+		KeyType:     key.Type(),
+		Fingerprint: ssh.FingerprintSHA256(key),
+		RemoteAddr:  remoteAddr,
+	}
+	logger := log.WithContextFields(ctx, log.Fields{"ssh_user": user, "public_key_fingerprint": request.Fingerprint})
+
+	permissions, err := a.decide(ctx, request)
+	switch {
+	case errors.Is(err, errExternalAuthRejected):
+		logger.Info("public key rejected by the external authenticator")
+		metrics.SshdExternalAuthTotal.WithLabelValues("rejected").Inc()
+	case err != nil:
+		logger.WithError(err).Warn("external authenticator failed, public key rejected")
+		metrics.SshdExternalAuthTotal.WithLabelValues("error").Inc()
+	default:
+		logger.WithFields(log.Fields{"key_id": permissions.Extensions["key-id"], "username": permissions.Extensions["username"]}).Info("public key accepted by the external authenticator")
+		metrics.SshdExternalAuthTotal.WithLabelValues("accepted").Inc()
+	}
+
+	return permissions, err
+}
+
+func (a *externalAuthenticator) decide(ctx context.Context, request externalAuthRequest) (*ssh.Permissions, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var output []byte
+	if len(a.command) > 0 {
+		output, err = a.runCommand(ctx, body)
+	} else {
+		output, err = a.post(ctx, body)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var verdict externalAuthVerdict
+	if err := json.Unmarshal(output, &verdict); err != nil {
+		return nil, fmt.Errorf("invalid verdict: %w", err)
+	}
+
+	return verdict.permissions()
+}
+
+func (a *externalAuthenticator) runCommand(ctx context.Context, input []byte) ([]byte, error) {
+	// #nosec G204 -- the command is configured by the administrator
+	cmd := exec.CommandContext(ctx, a.command[0], a.command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && ctx.Err() == nil {
+			return nil, fmt.Errorf("%w: %s", errExternalAuthRejected, exitErr)
+		}
+
+		return nil, fmt.Errorf("external authenticator command failed: %w", err)
+	}
+
+	return output, nil
+}
+
+func (a *externalAuthenticator) post(ctx context.Context, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch {
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %s", errExternalAuthRejected, resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("external authenticator answered %s", resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, externalAuthResponseLimit))
+}
+
+// permissions returns the permissions of the GitLab user or key of the
+// verdict, which must name exactly one of them
+func (v externalAuthVerdict) permissions() (*ssh.Permissions, error) {
+	if !v.Allowed {
+		return nil, errExternalAuthRejected
+	}
+
+	switch {
+	case v.Username != "" && v.KeyID != 0:
+		return nil, errors.New("invalid verdict: both a username and a key_id given")
+	case v.Username != "":
+		return &ssh.Permissions{Extensions: map[string]string{"username": v.Username}}, nil
+	case v.KeyID > 0:
+		return &ssh.Permissions{Extensions: map[string]string{"key-id": strconv.FormatInt(v.KeyID, 10)}}, nil
+	default:
+		return nil, errors.New("invalid verdict: no username or key_id given")
+	}
+}
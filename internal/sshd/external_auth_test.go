@@ -0,0 +1,216 @@
+package sshd
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/authorizedkeys"
+)
+
+type fakeConnMetadata struct {
+	ssh.ConnMetadata
+	user string
+}
+
+func (m fakeConnMetadata) User() string { return m.user }
+
+func (m fakeConnMetadata) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 2222}
+}
+
+// writeAuthenticatorScript writes a shell script deciding on keys, which
+// records its input next to it
+func writeAuthenticatorScript(t *testing.T, script string) (string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	path, input := filepath.Join(dir, "authenticate"), filepath.Join(dir, "input.json")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\ncat > "+input+"\n"+script+"\n"), 0o700))
+
+	return path, input
+}
+
+func TestExternalAuthenticatorCommand(t *testing.T) {
+	key := rsaPublicKey(t)
+
+	testCases := []struct {
+		desc          string
+		script        string
+		expectedPerms map[string]string
+		expectedErr   string
+	}{
+		{
+			desc:          "a user is allowed",
+			script:        `echo '{"allowed": true, "username": "jdoe"}'`,
+			expectedPerms: map[string]string{"username": "jdoe"},
+		},
+		{
+			desc:          "a key is allowed",
+			script:        `echo '{"allowed": true, "key_id": 42}'`,
+			expectedPerms: map[string]string{"key-id": "42"},
+		},
+		{
+			desc:        "the key is denied",
+			script:      `echo '{"allowed": false}'`,
+			expectedErr: "rejected by the external authenticator",
+		},
+		{
+			desc:        "the command fails",
+			script:      `exit 1`,
+			expectedErr: "rejected by the external authenticator: exit status 1",
+		},
+		{
+			desc:        "the verdict names no user",
+			script:      `echo '{"allowed": true}'`,
+			expectedErr: "invalid verdict: no username or key_id given",
+		},
+		{
+			desc:        "the verdict names both a user and a key",
+			script:      `echo '{"allowed": true, "username": "jdoe", "key_id": 42}'`,
+			expectedErr: "invalid verdict: both a username and a key_id given",
+		},
+		{
+			desc:        "the verdict isn't JSON",
+			script:      `echo yes`,
+			expectedErr: "invalid verdict: invalid character 'y' looking for beginning of value",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			path, input := writeAuthenticatorScript(t, tc.script)
+			a := newExternalAuthenticator(config.ExternalAuthenticatorConfig{Command: []string{path}})
+
+			permissions, err := a.authenticate(context.Background(), "git", "192.0.2.1:2222", key)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedPerms, permissions.Extensions)
+
+			data, err := os.ReadFile(input)
+			require.NoError(t, err)
+
+			var request externalAuthRequest
+			require.NoError(t, json.Unmarshal(data, &request))
+			require.Equal(t, externalAuthRequest{
+				User:        "git",
+				Key:         strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+				KeyType:     ssh.KeyAlgoRSA,
+				Fingerprint: ssh.FingerprintSHA256(key),
+				RemoteAddr:  "192.0.2.1:2222",
+			}, request)
+		})
+	}
+}
+
+func TestExternalAuthenticatorCommandTimeout(t *testing.T) {
+	path, _ := writeAuthenticatorScript(t, `exec sleep 5`)
+	a := newExternalAuthenticator(config.ExternalAuthenticatorConfig{
+		Command: []string{path},
+		Timeout: config.YamlDuration(50 * time.Millisecond),
+	})
+
+	_, err := a.authenticate(context.Background(), "git", "192.0.2.1:2222", rsaPublicKey(t))
+	require.ErrorContains(t, err, "external authenticator command failed")
+}
+
+func TestExternalAuthenticatorURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request externalAuthRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		switch request.User {
+		case "jdoe":
+			w.Write([]byte(`{"allowed": true, "username": "jdoe"}`))
+		case "nobody":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	a := newExternalAuthenticator(config.ExternalAuthenticatorConfig{URL: server.URL})
+	key := rsaPublicKey(t)
+
+	permissions, err := a.authenticate(context.Background(), "jdoe", "192.0.2.1:2222", key)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"username": "jdoe"}, permissions.Extensions)
+
+	_, err = a.authenticate(context.Background(), "nobody", "192.0.2.1:2222", key)
+	require.EqualError(t, err, "rejected by the external authenticator: 403 Forbidden")
+
+	_, err = a.authenticate(context.Background(), "other", "192.0.2.1:2222", key)
+	require.EqualError(t, err, "external authenticator answered 500 Internal Server Error")
+}
+
+func TestHandleUnknownUserKey(t *testing.T) {
+	known, unknown, unavailable := rsaPublicKey(t), skECDSAPublicKey(t), newSKEd25519Signer(t).PublicKey()
+
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/authorized_keys",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Query().Get("key") {
+				case base64.RawStdEncoding.EncodeToString(known.Marshal()):
+					w.Write([]byte(`{ "id": 1, "key": "key" }`))
+				case base64.RawStdEncoding.EncodeToString(unknown.Marshal()):
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`{ "message": "404 Not found" }`))
+				default:
+					w.WriteHeader(http.StatusBadGateway)
+				}
+			},
+		},
+	}
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	path, _ := writeAuthenticatorScript(t, `echo '{"allowed": true, "username": "jdoe"}'`)
+	cfg := &config.Config{GitlabUrl: url, User: "git"}
+	s := &serverConfig{cfg: cfg, externalAuth: newExternalAuthenticator(config.ExternalAuthenticatorConfig{Command: []string{path}})}
+
+	var err error
+	s.authorizedKeysClient, err = authorizedkeys.NewClient(cfg)
+	require.NoError(t, err)
+
+	authenticate := func(user string, key ssh.PublicKey) (*ssh.Permissions, error) {
+		permissions, err := s.handleUserKey(context.Background(), user, key)
+		if err != nil {
+			return s.handleUnknownUserKey(context.Background(), fakeConnMetadata{user: user}, key, err)
+		}
+
+		return permissions, nil
+	}
+
+	permissions, err := authenticate("git", known)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"key-id": "1"}, permissions.Extensions, "GitLab takes precedence")
+
+	permissions, err = authenticate("git", unknown)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"username": "jdoe"}, permissions.Extensions, "unknown keys are decided on")
+
+	permissions, err = authenticate("jdoe", known)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"username": "jdoe"}, permissions.Extensions, "unknown users are decided on")
+
+	_, err = authenticate("git", unavailable)
+	require.Error(t, err, "keys GitLab failed to look up aren't decided on")
+}
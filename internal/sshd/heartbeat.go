@@ -0,0 +1,36 @@
+package sshd
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+// heartbeat sends a keepalive request on the channel of the session whenever
+// it has been quiet for interval, e.g. while the hooks of a push run, so that
+// NAT gateways and load balancers don't drop the connection. Requests aren't
+// data: they leave the output of the command and the idle timeout alone. It
+// returns once ctx is done.
+func (s *session) heartbeat(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.metered.idleFor() < interval {
+				continue
+			}
+
+			// Clients reply with a failure, as they don't know the request,
+			// which is just as good
+			if _, err := s.metered.SendRequest(KeepAliveMsg, true, nil); err != nil {
+				log.ContextLogger(ctx).WithError(err).Debug("session: heartbeat: failed to send keepalive")
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package sshd
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+type keepAliveChannel struct {
+	fakeChannel
+	keepAlives atomic.Int32
+}
+
+func (c *keepAliveChannel) SendRequest(name string, _ bool, _ []byte) (bool, error) {
+	if name == KeepAliveMsg {
+		c.keepAlives.Add(1)
+	}
+
+	return false, nil
+}
+
+func TestHeartbeat(t *testing.T) {
+	channel := &keepAliveChannel{fakeChannel: fakeChannel{stdOut: &bytes.Buffer{}}}
+	s := &session{metered: newMeteredChannel(channel)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.heartbeat(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool { return channel.keepAlives.Load() >= 2 }, 5*time.Second, time.Millisecond)
+
+	cancel()
+	<-done
+
+	// Data sent on the channel postpones the next keepalive
+	channel.keepAlives.Store(0)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go s.heartbeat(ctx, 100*time.Millisecond)
+
+	for i := 0; i < 60; i++ {
+		_, err := s.metered.Write([]byte("remote: running hooks\n"))
+		require.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+	}
+	require.Zero(t, channel.keepAlives.Load())
+}
+
+func TestHeartbeatWithCommandPolicy(t *testing.T) {
+	url := testserver.StartHttpServer(t, []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/allowed",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				// The push is checked for a while, for keepalives to be sent
+				time.Sleep(100 * time.Millisecond)
+				w.WriteHeader(http.StatusForbidden)
+			},
+		},
+	})
+
+	channel := &keepAliveChannel{fakeChannel: fakeChannel{stdErr: &bytes.Buffer{}, stdOut: &bytes.Buffer{}}}
+	s := &session{
+		gitlabKeyID: "root",
+		execCmd:     "git-receive-pack group/repo",
+		channel:     channel,
+		metered:     newMeteredChannel(channel),
+		cfg: &config.Config{
+			GitlabUrl:     url,
+			Server:        config.ServerConfig{PushHeartbeatInterval: config.YamlDuration(10 * time.Millisecond)},
+			CommandPolicy: config.CommandPolicyConfig{DenyTokenCommands: true},
+		},
+	}
+
+	_, _, err := s.handleShell(context.Background(), &ssh.Request{})
+	require.Error(t, err)
+	require.NotZero(t, channel.keepAlives.Load())
+}
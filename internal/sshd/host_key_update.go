@@ -0,0 +1,163 @@
+package sshd
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+// The OpenSSH extension by which clients with UpdateHostKeys enabled learn
+// the host keys of the server, so that keys can be rotated without warnings:
+// https://cvsweb.openbsd.org/src/usr.bin/ssh/PROTOCOL?annotate=HEAD
+const (
+	// HostKeysMsg announces all the host keys of the server
+	HostKeysMsg = "hostkeys-00@openssh.com"
+	// HostKeysProveMsg asks the server to prove it holds the keys it announced
+	HostKeysProveMsg = "hostkeys-prove-00@openssh.com"
+)
+
+var (
+	errUnknownHostKey       = errors.New("unknown host key")
+	errUnknownGlobalRequest = errors.New("unknown global request")
+)
+
+// rsaProofAlgorithms are the signature algorithms of RSA proofs, by
+// preference. OpenSSH verifies them with the algorithm negotiated for the
+// connection if it's an RSA one, which isn't known here: rsa-sha2-512 is the
+// one negotiated by OpenSSH clients unless they are configured otherwise.
+var rsaProofAlgorithms = []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256}
+
+// plainKey returns the public key of a host key, without its certificate
+func plainKey(hostKey ssh.Signer) ssh.PublicKey {
+	publicKey := hostKey.PublicKey()
+	if cert, ok := publicKey.(*ssh.Certificate); ok {
+		return cert.Key
+	}
+
+	return publicKey
+}
+
+// appendString appends s in the SSH wire encoding of strings
+func appendString(buf []byte, s []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(s)))
+	return append(buf, s...)
+}
+
+// parseStrings parses a sequence of strings in the SSH wire encoding
+func parseStrings(payload []byte) ([][]byte, error) {
+	var strs [][]byte
+
+	for len(payload) > 0 {
+		if len(payload) < 4 {
+			return nil, errors.New("truncated string")
+		}
+
+		length := binary.BigEndian.Uint32(payload)
+		payload = payload[4:]
+		if uint64(length) > uint64(len(payload)) {
+			return nil, errors.New("truncated string")
+		}
+
+		strs = append(strs, payload[:length])
+		payload = payload[length:]
+	}
+
+	return strs, nil
+}
+
+// announceHostKeys sends the host keys, without their certificates, as
+// OpenSSH does
+func (c *connection) announceHostKeys(ctx context.Context, sconn *ssh.ServerConn) {
+	var payload []byte
+	for _, hostKey := range c.hostKeys {
+		payload = appendString(payload, plainKey(hostKey).Marshal())
+	}
+
+	if _, _, err := sconn.SendRequest(HostKeysMsg, false, payload); err != nil {
+		log.WithContextFields(ctx, log.Fields{"remote_addr": c.remoteAddr}).WithError(err).Debug("connection: announceHostKeys: failed to send host keys")
+	}
+}
+
+// handleGlobalRequests answers the proofs of host keys and the requests of
+// gateways, see proxy_info.go, and rejects any other global request
+func (c *connection) handleGlobalRequests(ctx context.Context, sconn *ssh.ServerConn, reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		var proof []byte
+		var err error
+
+		switch req.Type {
+		case HostKeysProveMsg:
+			proof, err = proveHostKeys(c.hostKeys, sconn.SessionID(), req.Payload)
+			if err != nil {
+				log.WithContextFields(ctx, log.Fields{"remote_addr": c.remoteAddr}).WithError(err).Info("connection: handleGlobalRequests: failed to prove host keys")
+			}
+		case ProxyInfoMsg:
+			if err = c.handleProxyInfo(ctx, req.Payload); err != nil {
+				log.WithContextFields(ctx, log.Fields{"remote_addr": c.remoteAddr}).WithError(err).Info("connection: handleGlobalRequests: client address refused")
+			}
+		case NoMoreSessionsMsg:
+			c.noMoreSessions.Store(true)
+		default:
+			err = errUnknownGlobalRequest
+		}
+
+		if req.WantReply {
+			_ = req.Reply(err == nil, proof)
+		}
+	}
+}
+
+// proveHostKeys signs each of the host keys listed in payload, binding them
+// to the session
+func proveHostKeys(hostKeys []ssh.Signer, sessionID []byte, payload []byte) ([]byte, error) {
+	blobs, err := parseStrings(payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s request: %w", HostKeysProveMsg, err)
+	}
+
+	var proof []byte
+	for _, blob := range blobs {
+		index := slices.IndexFunc(hostKeys, func(hostKey ssh.Signer) bool {
+			return bytes.Equal(plainKey(hostKey).Marshal(), blob)
+		})
+		if index < 0 {
+			return nil, errUnknownHostKey
+		}
+
+		var data []byte
+		data = appendString(data, []byte(HostKeysProveMsg))
+		data = appendString(data, sessionID)
+		data = appendString(data, blob)
+
+		signature, err := signProof(hostKeys[index], data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign %s host key: %w", plainKey(hostKeys[index]).Type(), err)
+		}
+
+		proof = appendString(proof, ssh.Marshal(signature))
+	}
+
+	return proof, nil
+}
+
+func signProof(hostKey ssh.Signer, data []byte) (*ssh.Signature, error) {
+	if plainKey(hostKey).Type() == ssh.KeyAlgoRSA {
+		if signer, ok := hostKey.(ssh.MultiAlgorithmSigner); ok {
+			for _, algorithm := range rsaProofAlgorithms {
+				if slices.Contains(signer.Algorithms(), algorithm) {
+					return signer.SignWithAlgorithm(rand.Reader, data, algorithm)
+				}
+			}
+		}
+	}
+
+	return hostKey.Sign(rand.Reader, data)
+}
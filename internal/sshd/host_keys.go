@@ -0,0 +1,166 @@
+package sshd
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+const generatedRSAKeyBits = 3072
+
+// hostKeyType is a type of host key that can be generated
+type hostKeyType struct {
+	name     string
+	generate func() (crypto.PrivateKey, error)
+}
+
+// hostKeyTypes are generated in this order, which is also the order in which
+// they are offered
+var hostKeyTypes = []hostKeyType{
+	{
+		name: "rsa",
+		generate: func() (crypto.PrivateKey, error) {
+			return rsa.GenerateKey(rand.Reader, generatedRSAKeyBits)
+		},
+	},
+	{
+		name: "ecdsa",
+		generate: func() (crypto.PrivateKey, error) {
+			return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		},
+	},
+	{
+		name: "ed25519",
+		generate: func() (crypto.PrivateKey, error) {
+			_, key, err := ed25519.GenerateKey(rand.Reader)
+			return key, err
+		},
+	},
+}
+
+// typeOf returns the name of the type of a host key, as in hostKeyTypes
+func typeOf(key ssh.PublicKey) string {
+	switch keyType := key.Type(); {
+	case keyType == ssh.KeyAlgoRSA:
+		return "rsa"
+	case strings.HasPrefix(keyType, "ecdsa-"):
+		return "ecdsa"
+	case keyType == ssh.KeyAlgoED25519:
+		return "ed25519"
+	default:
+		return keyType
+	}
+}
+
+// ensureHostKeys adds to hostKeys a key of each type they lack, loaded from
+// dir or generated into it if it isn't there yet, the way OpenSSH names them:
+// dir/ssh_host_TYPE_key and dir/ssh_host_TYPE_key.pub
+func ensureHostKeys(hostKeys []ssh.Signer, dir string) ([]ssh.Signer, error) {
+	present := make(map[string]bool)
+	for _, key := range hostKeys {
+		present[typeOf(key.PublicKey())] = true
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create host key directory: %w", err)
+	}
+
+	for _, keyType := range hostKeyTypes {
+		if present[keyType.name] {
+			continue
+		}
+
+		filename := filepath.Join(dir, "ssh_host_"+keyType.name+"_key")
+
+		key, err := loadOrGenerateHostKey(filename, keyType)
+		if err != nil {
+			return nil, err
+		}
+
+		hostKeys = append(hostKeys, key)
+	}
+
+	return hostKeys, nil
+}
+
+func loadOrGenerateHostKey(filename string, keyType hostKeyType) (ssh.Signer, error) {
+	keyRaw, err := os.ReadFile(filepath.Clean(filename))
+	if err == nil {
+		key, err := ssh.ParsePrivateKey(keyRaw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse host key %s: %w", filename, err)
+		}
+
+		return key, nil
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read host key %s: %w", filename, err)
+	}
+
+	privateKey, err := keyType.generate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s host key: %w", keyType.name, err)
+	}
+
+	key, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s host key: %w", keyType.name, err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(privateKey, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s host key: %w", keyType.name, err)
+	}
+
+	// The public key goes first: only then is the host key complete
+	if err := writeFileAtomically(filename+".pub", ssh.MarshalAuthorizedKey(key.PublicKey()), 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write host key %s.pub: %w", filename, err)
+	}
+
+	if err := writeFileAtomically(filename, pem.EncodeToMemory(block), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write host key %s: %w", filename, err)
+	}
+
+	log.WithFields(log.Fields{"filename": filename, "fingerprint": ssh.FingerprintSHA256(key.PublicKey())}).Info("Generated host key")
+
+	return key, nil
+}
+
+// writeFileAtomically writes data to filename through a temporary file, so
+// that a partly written file is never left behind
+func writeFileAtomically(filename string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	if err := tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
@@ -0,0 +1,98 @@
+package sshd
+
+import (
+	"net/netip"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/ipfilter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+// Reasons a connection is refused by the ipFilter
+const (
+	filterReasonDenied     = "ip_denied"
+	filterReasonNotAllowed = "ip_not_allowed"
+)
+
+type ipFilterError struct {
+	reason string
+}
+
+func (e *ipFilterError) Error() string {
+	return "connection refused by the IP filter: " + e.reason
+}
+
+// ipFilter enforces config.IPFilterConfig, with the lists of the config and
+// those of the internal API
+type ipFilter struct {
+	allow    []netip.Prefix
+	deny     []netip.Prefix
+	client   *ipfilter.Client
+	interval time.Duration
+}
+
+func newIPFilter(cfg *config.Config) (*ipFilter, error) {
+	filterCfg := cfg.Server.IPFilter
+
+	allow, err := ipfilter.ParsePrefixes(filterCfg.Allow)
+	if err != nil {
+		return nil, err
+	}
+
+	deny, err := ipfilter.ParsePrefixes(filterCfg.Deny)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &ipFilter{allow: allow, deny: deny, interval: time.Duration(filterCfg.RefreshInterval)}
+
+	if filterCfg.FromAPI {
+		if f.client, err = ipfilter.NewClient(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(f.allow) == 0 && len(f.deny) == 0 && f.client == nil {
+		return nil, nil
+	}
+
+	return f, nil
+}
+
+// check decides whether a new connection from ip is accepted. Until the lists
+// of the internal API are fetched, only those of the config apply.
+func (f *ipFilter) check(ip string) error {
+	if f == nil {
+		return nil
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return f.reject(filterReasonNotAllowed)
+	}
+
+	allow, deny := f.allow, f.deny
+	if f.client != nil {
+		if lists := f.client.Cached(f.interval); lists != nil {
+			allow = append(allow[:len(allow):len(allow)], lists.Allow...)
+			deny = append(deny[:len(deny):len(deny)], lists.Deny...)
+		}
+	}
+
+	if ipfilter.Contains(deny, addr) {
+		return f.reject(filterReasonDenied)
+	}
+
+	if len(allow) > 0 && !ipfilter.Contains(allow, addr) {
+		return f.reject(filterReasonNotAllowed)
+	}
+
+	return nil
+}
+
+func (f *ipFilter) reject(reason string) error {
+	metrics.SshdRejectedConnectionsTotal.WithLabelValues(reason).Inc()
+
+	return &ipFilterError{reason: reason}
+}
@@ -0,0 +1,86 @@
+package sshd
+
+import (
+	"crypto/rsa"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+// keyRestriction is the restriction of KeyRestrictionsConfig for the keys
+// of a type, named as in GitLab
+type keyRestriction struct {
+	name    string
+	minBits int
+}
+
+// restrictionFor returns the restriction of keys of keyType
+func restrictionFor(cfg config.KeyRestrictionsConfig, keyType string) (keyRestriction, bool) {
+	switch keyType {
+	case ssh.KeyAlgoRSA:
+		return keyRestriction{name: "RSA", minBits: cfg.RSA}, true
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
+		return keyRestriction{name: "ECDSA", minBits: cfg.ECDSA}, true
+	case ssh.KeyAlgoED25519:
+		return keyRestriction{name: "ED25519", minBits: cfg.ED25519}, true
+	case ssh.KeyAlgoSKECDSA256:
+		return keyRestriction{name: "ECDSA_SK", minBits: cfg.ECDSASK}, true
+	case ssh.KeyAlgoSKED25519:
+		return keyRestriction{name: "ED25519_SK", minBits: cfg.ED25519SK}, true
+	default:
+		return keyRestriction{}, false
+	}
+}
+
+// checkKeyRestrictions returns an error with a message for the user if key,
+// or the key of a certificate, is restricted by cfg
+func checkKeyRestrictions(cfg config.KeyRestrictionsConfig, key ssh.PublicKey) error {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
+	}
+
+	restriction, ok := restrictionFor(cfg, key.Type())
+	if !ok || restriction.minBits == 0 {
+		return nil
+	}
+
+	if restriction.minBits < 0 {
+		return keyRestrictionError(fmt.Sprintf("%s keys are not allowed.", restriction.name))
+	}
+
+	if bits := keyBits(key); bits < restriction.minBits {
+		return keyRestrictionError(fmt.Sprintf("%s keys must be at least %d bits, this key has %d bits.", restriction.name, restriction.minBits, bits))
+	}
+
+	return nil
+}
+
+func keyRestrictionError(message string) error {
+	return &ssh.BannerError{
+		Err:     fmt.Errorf("key restricted: %s", message),
+		Message: message + " Please use a different key.\n",
+	}
+}
+
+// keyBits returns the size of key in bits
+func keyBits(key ssh.PublicKey) int {
+	switch key.Type() {
+	case ssh.KeyAlgoRSA:
+		if cryptoKey, ok := key.(ssh.CryptoPublicKey); ok {
+			if rsaKey, ok := cryptoKey.CryptoPublicKey().(*rsa.PublicKey); ok {
+				return rsaKey.N.BitLen()
+			}
+		}
+
+		return 0
+	case ssh.KeyAlgoECDSA384:
+		return 384
+	case ssh.KeyAlgoECDSA521:
+		return 521
+	default:
+		// ECDSA P-256 and Ed25519 keys, with or without a security key
+		return 256
+	}
+}
@@ -0,0 +1,126 @@
+package sshd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/testhelper"
+)
+
+func TestCheckKeyRestrictions(t *testing.T) {
+	rsaKey := rsaPublicKey(t)
+
+	ecdsaPrivateKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	ecdsaKey, err := ssh.NewPublicKey(&ecdsaPrivateKey.PublicKey)
+	require.NoError(t, err)
+
+	ed25519PublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ed25519Key, err := ssh.NewPublicKey(ed25519PublicKey)
+	require.NoError(t, err)
+
+	rsaCert := &ssh.Certificate{Key: rsaKey, CertType: ssh.UserCert}
+
+	testCases := []struct {
+		desc            string
+		restrictions    config.KeyRestrictionsConfig
+		key             ssh.PublicKey
+		expectedMessage string
+	}{
+		{
+			desc: "without restrictions",
+			key:  rsaKey,
+		},
+		{
+			desc:         "an RSA key of the minimum size",
+			restrictions: config.KeyRestrictionsConfig{RSA: 2048},
+			key:          rsaKey,
+		},
+		{
+			desc:            "a too small RSA key",
+			restrictions:    config.KeyRestrictionsConfig{RSA: 3072},
+			key:             rsaKey,
+			expectedMessage: "RSA keys must be at least 3072 bits, this key has 2048 bits. Please use a different key.\n",
+		},
+		{
+			desc:            "a certificate of a too small RSA key",
+			restrictions:    config.KeyRestrictionsConfig{RSA: 3072},
+			key:             rsaCert,
+			expectedMessage: "RSA keys must be at least 3072 bits, this key has 2048 bits. Please use a different key.\n",
+		},
+		{
+			desc:         "an ECDSA key of the minimum size",
+			restrictions: config.KeyRestrictionsConfig{ECDSA: 384},
+			key:          ecdsaKey,
+		},
+		{
+			desc:            "a too small ECDSA key",
+			restrictions:    config.KeyRestrictionsConfig{ECDSA: 521},
+			key:             ecdsaKey,
+			expectedMessage: "ECDSA keys must be at least 521 bits, this key has 384 bits. Please use a different key.\n",
+		},
+		{
+			desc:            "a forbidden ED25519 key",
+			restrictions:    config.KeyRestrictionsConfig{ED25519: -1},
+			key:             ed25519Key,
+			expectedMessage: "ED25519 keys are not allowed. Please use a different key.\n",
+		},
+		{
+			desc:            "a forbidden ED25519_SK key",
+			restrictions:    config.KeyRestrictionsConfig{ED25519SK: -1},
+			key:             newSKEd25519Signer(t).PublicKey(),
+			expectedMessage: "ED25519_SK keys are not allowed. Please use a different key.\n",
+		},
+		{
+			desc:         "a key of another type than the restricted one",
+			restrictions: config.KeyRestrictionsConfig{ECDSASK: -1},
+			key:          ed25519Key,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := checkKeyRestrictions(tc.restrictions, tc.key)
+			if tc.expectedMessage == "" {
+				require.NoError(t, err)
+				return
+			}
+
+			var bannerErr *ssh.BannerError
+			require.True(t, errors.As(err, &bannerErr))
+			require.Equal(t, tc.expectedMessage, bannerErr.Message)
+		})
+	}
+}
+
+func TestPublicKeyCallbackKeyRestrictions(t *testing.T) {
+	testRoot := testhelper.PrepareTestRootDir(t)
+
+	cfg, err := newServerConfig(&config.Config{
+		GitlabUrl: "http://localhost",
+		User:      "user",
+		Server: config.ServerConfig{
+			HostKeyFiles:    []string{path.Join(testRoot, "certs/valid/server.key")},
+			KeyRestrictions: config.KeyRestrictionsConfig{RSA: -1},
+		},
+	})
+	require.NoError(t, err)
+
+	// The key is rejected without contacting the internal API
+	_, err = cfg.get(context.Background()).PublicKeyCallback(nil, rsaPublicKey(t))
+
+	var bannerErr *ssh.BannerError
+	require.True(t, errors.As(err, &bannerErr))
+	require.Equal(t, "key restricted: RSA keys are not allowed.", err.Error())
+}
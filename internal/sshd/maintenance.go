@@ -0,0 +1,199 @@
+package sshd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	shellCmd "gitlab.com/gitlab-org/gitlab-shell/v14/cmd/gitlab-shell/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/motd"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/maintenance"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+// Sources of the maintenance mode, as reported by /debug/maintenance
+const (
+	maintenanceSourceConfig = "config"
+	maintenanceSourceAPI    = "api"
+	maintenanceSourceAdmin  = "admin"
+)
+
+// lfsDownloadOperation is the operation of the LFS commands that only read
+const lfsDownloadOperation = "download"
+
+const defaultMaintenanceMessage = "GitLab is under maintenance, only fetches are allowed. Please try again later."
+
+// errMaintenance is returned for the commands refused in maintenance
+var errMaintenance = errors.New("command refused in maintenance")
+
+// maintenanceState tells whether gitlab-sshd is in maintenance, with the
+// message template refused commands are shown
+type maintenanceState struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+	Source  string `json:"source,omitempty"`
+}
+
+// maintenanceMode switches gitlab-sshd in and out of maintenance. The debug
+// endpoints override the config and the internal API until cleared.
+type maintenanceMode struct {
+	override atomic.Pointer[maintenanceState]
+}
+
+// state returns whether the connections with cfg are in maintenance, asking
+// the internal API with client unless it's nil
+func (m *maintenanceMode) state(cfg *config.Config, client *maintenance.Client) maintenanceState {
+	if override := m.override.Load(); override != nil {
+		return *override
+	}
+
+	maintenanceCfg := cfg.Server.Maintenance
+	if maintenanceCfg.Enabled {
+		return maintenanceState{Enabled: true, Message: maintenanceCfg.Message, Source: maintenanceSourceConfig}
+	}
+
+	if client == nil {
+		return maintenanceState{}
+	}
+
+	response := client.Cached(time.Duration(maintenanceCfg.RefreshInterval))
+	if response == nil || !response.Enabled {
+		return maintenanceState{}
+	}
+
+	message := response.Message
+	if message == "" {
+		message = maintenanceCfg.Message
+	}
+
+	return maintenanceState{Enabled: true, Message: message, Source: maintenanceSourceAPI}
+}
+
+// stateFunc returns state bound to cfg and client, for the sessions of a
+// connection to look up whenever they run a command
+func (m *maintenanceMode) stateFunc(cfg *config.Config, client *maintenance.Client) func() maintenanceState {
+	return func() maintenanceState {
+		return m.state(cfg, client)
+	}
+}
+
+// allowedInMaintenance tells whether the command of args only reads, and may
+// run in maintenance. Pushes, LFS uploads and the commands issuing
+// credentials are refused.
+func allowedInMaintenance(args *commandargs.Shell) bool {
+	switch args.CommandType {
+	case commandargs.ReceivePack, commandargs.PersonalAccessToken, commandargs.TwoFactorRecover:
+		return false
+	case commandargs.LfsAuthenticate, commandargs.LfsTransfer:
+		return len(args.SshArgs) > 2 && args.SshArgs[2] == lfsDownloadOperation
+	default:
+		return true
+	}
+}
+
+// maintenanceMessageData is given to the template of the maintenance message
+type maintenanceMessageData struct {
+	Command  string
+	Instance string
+}
+
+// maintenanceMessage renders the message of state for command, falling back
+// on the default message
+func maintenanceMessage(ctx context.Context, cfg *config.Config, state maintenanceState, command commandargs.CommandType) string {
+	if state.Message == "" {
+		return defaultMaintenanceMessage
+	}
+
+	tmpl, err := template.New("maintenance").Parse(state.Message)
+	if err != nil {
+		log.ContextLogger(ctx).WithError(err).Warn("maintenance: invalid template")
+		return defaultMaintenanceMessage
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, maintenanceMessageData{Command: string(command), Instance: motd.InstanceName(cfg)}); err != nil {
+		log.ContextLogger(ctx).WithError(err).Warn("maintenance: invalid template")
+		return defaultMaintenanceMessage
+	}
+
+	return buf.String()
+}
+
+// checkMaintenance refuses the command of env while gitlab-sshd is in
+// maintenance, unless it's allowedInMaintenance
+func (s *session) checkMaintenance(ctx context.Context, env sshenv.Env) error {
+	if s.maintenance == nil {
+		return nil
+	}
+
+	state := s.maintenance()
+	if !state.Enabled {
+		return nil
+	}
+
+	args, err := shellCmd.Parse(nil, env)
+	if err != nil || allowedInMaintenance(args) {
+		return nil
+	}
+
+	log.WithContextFields(ctx, log.Fields{
+		"command": args.CommandType,
+		"source":  state.Source,
+	}).Info("session: handleShell: command refused in maintenance")
+
+	s.toStderr(ctx, "ERROR: %s\n", strings.TrimRight(maintenanceMessage(ctx, s.cfg, state, args.CommandType), "\n"))
+
+	return errMaintenance
+}
+
+// serveMaintenance returns the maintenance mode, after overriding it with the
+// enabled and message parameters of a POST request. An empty enabled
+// parameter clears the override.
+func (s *Server) serveMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		enabled := r.FormValue("enabled")
+		if enabled == "" {
+			s.maintenance.override.Store(nil)
+		} else {
+			on, err := strconv.ParseBool(enabled)
+			if err != nil {
+				http.Error(w, "invalid enabled parameter: "+enabled, http.StatusBadRequest)
+				return
+			}
+
+			s.maintenance.override.Store(&maintenanceState{Enabled: on, Message: r.FormValue("message"), Source: maintenanceSourceAdmin})
+		}
+
+		log.WithContextFields(r.Context(), log.Fields{
+			"enabled": enabled,
+			"message": r.FormValue("message"),
+		}).Info("debug: serveMaintenance: maintenance mode changed")
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg, serverConfig := s.currentConfig()
+
+	var client *maintenance.Client
+	if serverConfig != nil {
+		client = serverConfig.maintenanceClient
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.maintenance.state(cfg, client))
+}
@@ -0,0 +1,121 @@
+package sshd
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+)
+
+func TestMaintenanceState(t *testing.T) {
+	var m maintenanceMode
+	cfg := &config.Config{}
+
+	require.Equal(t, maintenanceState{}, m.state(cfg, nil))
+
+	cfg.Server.Maintenance = config.MaintenanceConfig{Enabled: true, Message: "Upgrading"}
+	require.Equal(t, maintenanceState{Enabled: true, Message: "Upgrading", Source: maintenanceSourceConfig}, m.state(cfg, nil))
+
+	m.override.Store(&maintenanceState{Source: maintenanceSourceAdmin})
+	require.Equal(t, maintenanceState{Source: maintenanceSourceAdmin}, m.stateFunc(cfg, nil)(), "the override takes precedence")
+}
+
+func TestAllowedInMaintenance(t *testing.T) {
+	testCases := []struct {
+		command  string
+		expected bool
+	}{
+		{command: "git-upload-pack group/repo", expected: true},
+		{command: "git-upload-archive group/repo", expected: true},
+		{command: "git-lfs-authenticate group/repo download", expected: true},
+		{command: "git-lfs-transfer group/repo download", expected: true},
+		{command: "2fa_verify", expected: true},
+		{command: "git-receive-pack group/repo"},
+		{command: "git-lfs-authenticate group/repo upload"},
+		{command: "git-lfs-transfer group/repo upload"},
+		{command: "personal_access_token"},
+		{command: "2fa_recovery_codes"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.command, func(t *testing.T) {
+			args := &commandargs.Shell{}
+			require.NoError(t, args.ParseCommand(tc.command))
+			require.Equal(t, tc.expected, allowedInMaintenance(args))
+		})
+	}
+}
+
+func TestMaintenanceMessage(t *testing.T) {
+	cfg := &config.Config{MOTD: config.MOTDConfig{InstanceName: "gitlab.example.com"}}
+	message := func(text string) string {
+		return maintenanceMessage(context.Background(), cfg, maintenanceState{Enabled: true, Message: text}, commandargs.ReceivePack)
+	}
+
+	require.Equal(t, defaultMaintenanceMessage, message(""))
+	require.Equal(t, "git-receive-pack is disabled on gitlab.example.com", message("{{.Command}} is disabled on {{.Instance}}"))
+	require.Equal(t, defaultMaintenanceMessage, message("{{.Unknown}}"))
+}
+
+func TestHandleShellMaintenance(t *testing.T) {
+	state := maintenanceState{Enabled: true, Message: "Pushes are disabled on {{.Instance}}"}
+
+	stdErr := &bytes.Buffer{}
+	s := &session{
+		gitlabKeyID: "root",
+		execCmd:     "git-receive-pack group/repo",
+		maintenance: func() maintenanceState { return state },
+		channel:     &fakeChannel{stdErr: stdErr, stdOut: &bytes.Buffer{}},
+		cfg:         &config.Config{GitlabUrl: "http://gitlab.example.com"},
+	}
+
+	_, exitCode, err := s.handleShell(context.Background(), &ssh.Request{})
+	require.Equal(t, errMaintenance, err)
+	require.Equal(t, uint32(1), exitCode)
+	require.Contains(t, stdErr.String(), "ERROR: Pushes are disabled on gitlab.example.com\n")
+
+	env := sshenv.Env{IsSSHConnection: true, OriginalCommand: "git-upload-pack group/repo"}
+	require.NoError(t, s.checkMaintenance(context.Background(), env), "fetches are allowed")
+
+	state.Enabled = false
+	env.OriginalCommand = "git-receive-pack group/repo"
+	require.NoError(t, s.checkMaintenance(context.Background(), env))
+}
+
+func TestServeMaintenance(t *testing.T) {
+	s := &Server{Config: &config.Config{}}
+	mux := s.DebugServeMux()
+
+	serve := func(method, path string) (int, string) {
+		r := httptest.NewRecorder()
+		mux.ServeHTTP(r, httptest.NewRequest(method, path, nil))
+
+		return r.Code, r.Body.String()
+	}
+
+	code, body := serve("GET", "/debug/maintenance")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "{\"enabled\":false}\n", body)
+
+	code, body = serve("POST", "/debug/maintenance?enabled=true&message=Upgrading")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "{\"enabled\":true,\"message\":\"Upgrading\",\"source\":\"admin\"}\n", body)
+
+	code, _ = serve("POST", "/debug/maintenance?enabled=maybe")
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code, body = serve("POST", "/debug/maintenance?enabled=")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "{\"enabled\":false}\n", body)
+
+	code, _ = serve("DELETE", "/debug/maintenance")
+	require.Equal(t, http.StatusMethodNotAllowed, code)
+}
@@ -0,0 +1,193 @@
+package sshd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// sidebandPackData is the sideband carrying the packfile, sent once
+	// negotiation is over
+	sidebandPackData = 1
+	// sidebandError is the sideband of fatal errors, which clients show
+	// before exiting
+	sidebandError = 3
+
+	// interruptTimeout bounds the wait for a write in progress to reach the
+	// end of its packet, for the error to be sent after it
+	interruptTimeout = time.Second
+
+	// resumeHint suggests how to clone a repository too large for the session
+	resumeHint = "To clone a large repository, clone it in steps: git clone --depth=1, " +
+		"then git fetch --deepen=N until git fetch --unshallow completes it"
+)
+
+// errTransferInterrupted is returned to the writes of a transfer once an error
+// has been sent in place of the rest of it
+var errTransferInterrupted = errors.New("transfer interrupted")
+
+// pktlineState follows the pkt-lines of a stream to tell the packet
+// boundaries, where a packet can be inserted, and whether the packfile is
+// being sent
+type pktlineState struct {
+	header    [4]byte
+	headerLen int
+	// remaining is the size of the rest of the payload of the current packet
+	remaining int
+	// bandNext tells the next byte is the first of a payload, its sideband
+	// when the payload is multiplexed
+	bandNext bool
+	// sendingPack is set by the first packet of the packfile sideband
+	sendingPack bool
+	// invalid is set by a stream that isn't made of pkt-lines, whose
+	// boundaries are unknown
+	invalid bool
+}
+
+func (s *pktlineState) atBoundary() bool {
+	return !s.invalid && s.headerLen == 0 && s.remaining == 0
+}
+
+// consume advances s over p. When untilBoundary is set it stops at the first
+// boundary, returning how much of p it consumed.
+func (s *pktlineState) consume(p []byte, untilBoundary bool) int {
+	n := 0
+
+	for n < len(p) && !s.invalid {
+		if untilBoundary && s.atBoundary() {
+			return n
+		}
+
+		if s.remaining > 0 {
+			if s.bandNext && p[n] == sidebandPackData {
+				s.sendingPack = true
+			}
+			s.bandNext = false
+
+			consumed := min(s.remaining, len(p)-n)
+			s.remaining -= consumed
+			n += consumed
+
+			continue
+		}
+
+		consumed := copy(s.header[s.headerLen:], p[n:])
+		s.headerLen += consumed
+		n += consumed
+		if s.headerLen < len(s.header) {
+			continue
+		}
+		s.headerLen = 0
+
+		// 0000 to 0002 are the flush, delimiter and response end packets,
+		// with no payload
+		length, err := strconv.ParseUint(string(s.header[:]), 16, 16)
+		switch {
+		case err != nil || length == 3:
+			s.invalid = true
+		case length > 4:
+			s.remaining = int(length) - 4
+			s.bandNext = true
+		}
+	}
+
+	return len(p)
+}
+
+// packWriter writes the output of upload-pack, which can be interrupted by
+// an error sent at the end of a packet: the last sideband packet once the
+// packfile is being sent, as an ERR packet before
+type packWriter struct {
+	w io.Writer
+
+	mu          sync.Mutex
+	state       pktlineState
+	interrupted bool
+	errorSent   bool
+
+	sendingPack atomic.Bool
+	pending     atomic.Pointer[string]
+}
+
+func newPackWriter(w io.Writer) *packWriter {
+	return &packWriter{w: w}
+}
+
+func (w *packWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.interrupted {
+		return 0, errTransferInterrupted
+	}
+
+	if message := w.pending.Load(); message != nil {
+		probe := w.state
+		if n := probe.consume(p, true); probe.atBoundary() {
+			written, err := w.write(p[:n])
+			if err != nil {
+				return written, err
+			}
+
+			return written, w.sendError(*message)
+		}
+	}
+
+	return w.write(p)
+}
+
+func (w *packWriter) write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.state.consume(p[:n], false)
+	if w.state.sendingPack {
+		w.sendingPack.Store(true)
+	}
+
+	return n, err
+}
+
+// sendError must be called with mu held, at a packet boundary
+func (w *packWriter) sendError(message string) error {
+	payload := "ERR " + message
+	if w.state.sendingPack {
+		payload = string(rune(sidebandError)) + message
+	}
+
+	w.interrupted = true
+	if _, err := fmt.Fprintf(w.w, "%04x%s", len(payload)+4, payload); err != nil {
+		return err
+	}
+	w.errorSent = true
+
+	return errTransferInterrupted
+}
+
+// interrupt sends message as an error in place of the rest of the output,
+// once a write in progress reaches the end of its packet. It reports whether
+// it could within timeout.
+func (w *packWriter) interrupt(message string, timeout time.Duration) bool {
+	w.pending.Store(&message)
+
+	sent := make(chan bool, 1)
+	go func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		if !w.interrupted && w.state.atBoundary() {
+			_ = w.sendError(message)
+		}
+		sent <- w.errorSent
+	}()
+
+	select {
+	case ok := <-sent:
+		return ok
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,121 @@
+package sshd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func pktLine(payload string) string {
+	return fmt.Sprintf("%04x%s", len(payload)+4, payload)
+}
+
+func TestPackWriterInterrupt(t *testing.T) {
+	advertisement := pktLine("0123456789abcdef0123456789abcdef01234567 HEAD\n") + "0000"
+	pack := pktLine("\x01PACK data")
+
+	testCases := []struct {
+		desc        string
+		writes      []string
+		sendingPack bool
+		expected    string
+	}{
+		{
+			desc:     "before the packfile",
+			writes:   []string{advertisement},
+			expected: advertisement + pktLine("ERR interrupted"),
+		},
+		{
+			desc:        "while sending the packfile",
+			writes:      []string{advertisement, pktLine("\x02Counting objects\n"), pack},
+			sendingPack: true,
+			expected:    advertisement + pktLine("\x02Counting objects\n") + pack + pktLine("\x03interrupted"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			w := newPackWriter(out)
+
+			for _, data := range tc.writes {
+				_, err := w.Write([]byte(data))
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.sendingPack, w.sendingPack.Load())
+
+			require.True(t, w.interrupt("interrupted", time.Second))
+			require.Equal(t, tc.expected, out.String())
+
+			_, err := w.Write([]byte(pack))
+			require.ErrorIs(t, err, errTransferInterrupted)
+			require.Equal(t, tc.expected, out.String())
+		})
+	}
+}
+
+func TestPackWriterInterruptWaitsForThePacket(t *testing.T) {
+	out := &bytes.Buffer{}
+	w := newPackWriter(out)
+	pack := pktLine("\x01PACK data")
+
+	_, err := w.Write([]byte(pack[:6]))
+	require.NoError(t, err)
+
+	require.False(t, w.interrupt("interrupted", 10*time.Millisecond), "the packet isn't complete")
+
+	n, err := w.Write([]byte(pack[6:] + pack))
+	require.ErrorIs(t, err, errTransferInterrupted)
+	require.Equal(t, len(pack)-6, n)
+	require.Equal(t, pack+pktLine("\x03interrupted"), out.String())
+}
+
+func TestPackWriterInvalidStream(t *testing.T) {
+	out := &bytes.Buffer{}
+	w := newPackWriter(out)
+
+	_, err := w.Write([]byte("not pkt-lines"))
+	require.NoError(t, err)
+
+	require.False(t, w.interrupt("interrupted", 10*time.Millisecond))
+	require.Equal(t, "not pkt-lines", out.String())
+}
+
+func TestPackfileGracePeriod(t *testing.T) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	channel := &fakeChannel{stdOut: stdout, stdErr: stderr}
+
+	s := &session{
+		cfg: &config.Config{Server: config.ServerConfig{
+			MaxSessionDuration:  config.YamlDuration(50 * time.Millisecond),
+			PackfileGracePeriod: config.YamlDuration(100 * time.Millisecond),
+		}},
+		channel: channel,
+		started: time.Now(),
+		metered: newMeteredChannel(channel),
+	}
+
+	w := newPackWriter(channel)
+	s.packWriter.Store(w)
+	_, err := w.Write([]byte(pktLine("\x01PACK data")))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := time.Now()
+	s.enforceTimeouts(ctx, cancel, s.metered)
+
+	require.GreaterOrEqual(t, time.Since(started), 150*time.Millisecond)
+	require.Error(t, ctx.Err())
+
+	message := "Maximum session duration of 50ms exceeded, closing the session. " + resumeHint
+	require.Equal(t, pktLine("\x01PACK data")+pktLine("\x03"+message), stdout.String())
+	require.Contains(t, stderr.String(), message)
+}
@@ -0,0 +1,164 @@
+package sshd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/healthcheck"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+var errNotServing = errors.New("not serving SSH connections")
+
+// cachedCheck runs check at most once per interval, and reports its last
+// result in between
+type cachedCheck struct {
+	interval time.Duration
+	timeout  time.Duration
+	check    func(ctx context.Context) error
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+func (c *cachedCheck) result(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.checkedAt.IsZero() && time.Since(c.checkedAt) < c.interval {
+		return c.err
+	}
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	c.err = c.check(ctx)
+	c.checkedAt = time.Now()
+
+	return c.err
+}
+
+func probeHandler(name string, probe func(ctx context.Context) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := probe(r.Context()); err != nil {
+			log.WithContextFields(r.Context(), log.Fields{"probe": name}).WithError(err).Warn("Health check failed")
+
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = fmt.Fprintln(w, err)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprintln(w, "ok")
+	}
+}
+
+// healthCheckHandlers returns the readiness and liveness handlers of the
+// health checks
+func (s *Server) healthCheckHandlers() (http.HandlerFunc, http.HandlerFunc) {
+	cfg := s.Config.Server.HealthChecks
+	interval, timeout := time.Duration(cfg.Interval), time.Duration(cfg.Timeout)
+
+	listener := &cachedCheck{interval: interval, timeout: timeout, check: s.checkListener}
+	api := &cachedCheck{interval: interval, timeout: timeout, check: s.checkAPI}
+
+	readiness := func(ctx context.Context) error {
+		if s.getStatus() != StatusReady {
+			return errNotServing
+		}
+
+		if err := listener.result(ctx); err != nil {
+			return err
+		}
+
+		return api.result(ctx)
+	}
+
+	liveness := func(ctx context.Context) error {
+		// Starting up and draining aren't failures
+		if s.getStatus() != StatusReady {
+			return nil
+		}
+
+		return listener.result(ctx)
+	}
+
+	return probeHandler("readiness", readiness), probeHandler("liveness", liveness)
+}
+
+// checkListener connects to each SSH listener and waits for the version banner
+// of the server, which is only sent once the connection is accepted
+func (s *Server) checkListener(ctx context.Context) error {
+	if s.getStatus() != StatusReady {
+		return errNotServing
+	}
+
+	for _, l := range s.listeners {
+		if err := checkSSHListener(ctx, l); err != nil {
+			return fmt.Errorf("SSH listener %s: %w", l.Addr(), err)
+		}
+	}
+
+	return nil
+}
+
+func checkSSHListener(ctx context.Context, l *sshListener) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", l.Addr().String())
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if l.proxyProtocol {
+		// The listener may require a header, which doesn't need to name the
+		// source for a health check
+		if _, err := conn.Write([]byte("PROXY UNKNOWN\r\n")); err != nil {
+			return err
+		}
+	}
+
+	banner, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(banner, "SSH-2.0-") {
+		return fmt.Errorf("unexpected banner %q", strings.TrimSpace(banner))
+	}
+
+	return nil
+}
+
+// checkAPI requests the health check endpoint of the internal API
+func (s *Server) checkAPI(ctx context.Context) error {
+	cfg, _ := s.currentConfig()
+
+	client, err := healthcheck.NewClient(cfg)
+	if err != nil {
+		return fmt.Errorf("internal API: %w", err)
+	}
+
+	if _, err := client.Check(ctx); err != nil {
+		return fmt.Errorf("internal API: %w", err)
+	}
+
+	return nil
+}
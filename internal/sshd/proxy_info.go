@@ -0,0 +1,92 @@
+package sshd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/ipfilter"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+// The global requests by which a gateway, such as a ProxyJump host, tells
+// about the connection it proxies. x/crypto/ssh doesn't expose the RFC 8308
+// extensions sent by clients, so the client address is a global request too.
+const (
+	// ProxyInfoMsg reports the address of the client a trusted gateway
+	// proxies, as a string such as "203.0.113.7:52144" or "2001:db8::7"
+	ProxyInfoMsg = "proxy-info@gitlab.com"
+	// NoMoreSessionsMsg asks the server to refuse any further session
+	// channel, as OpenSSH clients do once they opened the ones they need
+	NoMoreSessionsMsg = "no-more-sessions@openssh.com"
+)
+
+var (
+	errUntrustedGateway   = errors.New("the connection doesn't come from a trusted gateway")
+	errClientAddrReported = errors.New("the client address was already reported")
+)
+
+// isTrustedGateway reports whether the peer at remoteAddr is one of the
+// trusted gateways
+func (s *serverConfig) isTrustedGateway(remoteAddr string) bool {
+	addr, err := netip.ParseAddr(gitlabnet.ParseIP(remoteAddr))
+
+	return err == nil && ipfilter.Contains(s.trustedGateways, addr)
+}
+
+// parseProxyInfo returns the client address of a proxy-info request
+func parseProxyInfo(payload []byte) (string, error) {
+	strs, err := parseStrings(payload)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s request: %w", ProxyInfoMsg, err)
+	}
+	if len(strs) != 1 {
+		return "", fmt.Errorf("invalid %s request: expected a single address", ProxyInfoMsg)
+	}
+
+	addr := string(strs[0])
+	if addrPort, err := netip.ParseAddrPort(addr); err == nil {
+		return addrPort.String(), nil
+	}
+
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s request: invalid address %q", ProxyInfoMsg, addr)
+	}
+
+	return ip.String(), nil
+}
+
+// handleProxyInfo records the client address reported by a trusted gateway,
+// used in place of the address of the gateway by the sessions opened next
+func (c *connection) handleProxyInfo(ctx context.Context, payload []byte) error {
+	if !c.trustedGateway {
+		return errUntrustedGateway
+	}
+
+	addr, err := parseProxyInfo(payload)
+	if err != nil {
+		return err
+	}
+
+	if !c.clientAddr.CompareAndSwap(nil, &addr) {
+		return errClientAddrReported
+	}
+
+	log.WithContextFields(ctx, log.Fields{"remote_addr": c.remoteAddr, "client_addr": addr}).Info("connection: handleProxyInfo: client address reported by the gateway")
+
+	return nil
+}
+
+// clientRemoteAddr returns the address of the client: the one reported by
+// the gateway, if any, or the address of the peer
+func (c *connection) clientRemoteAddr() string {
+	if addr := c.clientAddr.Load(); addr != nil {
+		return *addr
+	}
+
+	return c.remoteAddr
+}
@@ -4,7 +4,10 @@ package sshd
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -13,9 +16,15 @@ import (
 
 	"golang.org/x/crypto/ssh"
 
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/motd"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/authorizedcerts"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/authorizedkeys"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/discover"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/ipfilter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/maintenance"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
 
 	"gitlab.com/gitlab-org/labkit/log"
 )
@@ -46,8 +55,19 @@ type serverConfig struct {
 	hostKeyToCertMap      map[string]*ssh.Certificate
 	authorizedKeysClient  *authorizedkeys.Client
 	authorizedCertsClient *authorizedcerts.Client
+	discoverClient        *discover.Client
+	trustedUserCAKeys     map[string]bool
+	ipFilter              *ipFilter
+	trustedGateways       []netip.Prefix
+	keysFallback          *authorizedKeysFallback
+	externalAuth          *externalAuthenticator
+	// maintenanceClient asks the internal API whether GitLab is in
+	// maintenance, when config.MaintenanceConfig.FromAPI is set
+	maintenanceClient *maintenance.Client
 }
 
+var errUnknownUser = errors.New("unknown user")
+
 func parseHostKeys(keyFiles []string) []ssh.Signer {
 	var hostKeys []ssh.Signer
 
@@ -126,25 +146,91 @@ func newServerConfig(cfg *config.Config) (*serverConfig, error) {
 		return nil, fmt.Errorf("failed to initialize authorized certs client: %w", err)
 	}
 
+	discoverClient, err := discover.NewClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize discover client: %w", err)
+	}
+
+	trustedUserCAKeys, err := parseTrustedUserCAKeys(cfg.Server.TrustedUserCAKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load trusted user CA keys: %w", err)
+	}
+
+	algorithms := algorithmsPolicy(cfg.Server)
+	if err := validateAlgorithms(algorithms); err != nil {
+		return nil, fmt.Errorf("invalid algorithms configuration: %w", err)
+	}
+
 	hostKeys := parseHostKeys(cfg.Server.HostKeyFiles)
+	if cfg.Server.HostKeyDir != "" {
+		if hostKeys, err = ensureHostKeys(hostKeys, cfg.Server.HostKeyDir); err != nil {
+			return nil, err
+		}
+	}
 	if len(hostKeys) == 0 {
 		return nil, fmt.Errorf("no host keys could be loaded, aborting")
 	}
 
+	ipFilter, err := newIPFilter(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP filter: %w", err)
+	}
+
+	trustedGateways, err := ipfilter.ParsePrefixes(cfg.Server.TrustedGateways)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted gateways: %w", err)
+	}
+
+	keysFallback, err := newAuthorizedKeysFallback(cfg.Server.AuthorizedKeysFallback)
+	if err != nil {
+		return nil, fmt.Errorf("invalid authorized keys fallback: %w", err)
+	}
+
+	var maintenanceClient *maintenance.Client
+	if cfg.Server.Maintenance.FromAPI {
+		if maintenanceClient, err = maintenance.NewClient(cfg); err != nil {
+			return nil, fmt.Errorf("invalid maintenance: %w", err)
+		}
+	}
+
 	hostKeyToCertMap := parseHostCerts(hostKeys, cfg.Server.HostCertFiles)
 
+	hostKeys = restrictHostKeys(hostKeys, algorithms.HostKeyAlgorithms)
+	if len(hostKeys) == 0 {
+		return nil, fmt.Errorf("no host keys allowed by the host key algorithms, aborting")
+	}
+
 	return &serverConfig{
 		cfg:                   cfg,
 		authorizedKeysClient:  authorizedKeysClient,
 		authorizedCertsClient: authorizedCertsClient,
+		discoverClient:        discoverClient,
+		trustedUserCAKeys:     trustedUserCAKeys,
 		hostKeys:              hostKeys,
 		hostKeyToCertMap:      hostKeyToCertMap,
+		ipFilter:              ipFilter,
+		trustedGateways:       trustedGateways,
+		keysFallback:          keysFallback,
+		externalAuth:          newExternalAuthenticator(cfg.Server.ExternalAuthenticator),
+		maintenanceClient:     maintenanceClient,
 	}, nil
 }
 
+// isSecurityKey reports whether key is backed by a FIDO2 security key. These
+// are verified like any other key: their signatures cover the application
+// the key was registered for, which is part of the encoded key GitLab stores.
+func isSecurityKey(key ssh.PublicKey) bool {
+	switch key.Type() {
+	case ssh.KeyAlgoSKED25519, ssh.KeyAlgoSKECDSA256:
+		return true
+	default:
+		return false
+	}
+}
+
 func (s *serverConfig) handleUserKey(ctx context.Context, user string, key ssh.PublicKey) (*ssh.Permissions, error) {
 	if user != s.cfg.User {
-		return nil, fmt.Errorf("unknown user")
+		return nil, errUnknownUser
 	}
 	if key.Type() == ssh.KeyAlgoDSA {
 		return nil, fmt.Errorf("DSA is prohibited")
@@ -152,7 +238,7 @@ func (s *serverConfig) handleUserKey(ctx context.Context, user string, key ssh.P
 
 	res, err := s.authorizedKeysClient.GetByKey(ctx, base64.RawStdEncoding.EncodeToString(key.Marshal()))
 	if err != nil {
-		return nil, err
+		return s.handleUserKeyFallback(ctx, key, err)
 	}
 
 	return &ssh.Permissions{
@@ -163,6 +249,41 @@ func (s *serverConfig) handleUserKey(ctx context.Context, user string, key ssh.P
 	}, nil
 }
 
+// handleUnknownUserKey asks the external authenticator about key when
+// handleUserKey failed with err because GitLab doesn't know the user or key
+func (s *serverConfig) handleUnknownUserKey(ctx context.Context, conn ssh.ConnMetadata, key ssh.PublicKey, err error) (*ssh.Permissions, error) {
+	var apiErr *client.APIError
+	unknown := errors.Is(err, errUnknownUser) || (errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound)
+	if s.externalAuth == nil || !unknown {
+		return nil, err
+	}
+
+	return s.externalAuth.authenticate(ctx, conn.User(), conn.RemoteAddr().String(), key)
+}
+
+// handleUserKeyFallback authenticates key with the authorized keys fallback
+// when the internal API failed with apiErr because it is unavailable
+func (s *serverConfig) handleUserKeyFallback(ctx context.Context, key ssh.PublicKey, apiErr error) (*ssh.Permissions, error) {
+	if s.keysFallback == nil || !client.IsUnavailable(apiErr) {
+		return nil, apiErr
+	}
+
+	id, ok := s.keysFallback.lookup(key)
+	if !ok {
+		return nil, apiErr
+	}
+
+	log.WithContextFields(ctx, log.Fields{"key_id": id}).WithError(apiErr).Warn("public key authenticated by the authorized keys fallback, the internal API is unavailable")
+	metrics.SshdAuthorizedKeysFallbackTotal.Inc()
+
+	return &ssh.Permissions{
+		Extensions: map[string]string{
+			"key-id":          strconv.FormatInt(id, 10),
+			fallbackExtension: "true",
+		},
+	}, nil
+}
+
 func (s *serverConfig) handleUserCertificate(ctx context.Context, user string, cert *ssh.Certificate) (*ssh.Permissions, error) {
 	if os.Getenv("FF_GITLAB_SHELL_SSH_CERTIFICATES") != "1" {
 		return nil, fmt.Errorf("handleUserCertificate: feature is disabled")
@@ -239,26 +360,60 @@ func (s *serverConfig) get(parentCtx context.Context) *ssh.ServerConfig {
 			ctx, cancel := context.WithTimeout(parentCtx, 10*time.Second)
 			defer cancel()
 
-			log.WithContextFields(ctx, log.Fields{"ssh_key_type": key.Type()}).Info("public key authentication")
+			log.WithContextFields(ctx, log.Fields{
+				"ssh_key_type":           key.Type(),
+				"public_key_fingerprint": ssh.FingerprintSHA256(key),
+				"security_key":           isSecurityKey(key),
+			}).Info("public key authentication")
+
+			if err := checkKeyRestrictions(s.cfg.Server.KeyRestrictions, key); err != nil {
+				log.WithContextFields(ctx, log.Fields{"ssh_key_type": key.Type()}).WithError(err).Info("public key authentication: key rejected")
+				return nil, err
+			}
 
 			cert, ok := key.(*ssh.Certificate)
+			if ok && s.isTrustedUserCA(cert.SignatureKey) {
+				return s.handleTrustedUserCertificate(ctx, conn.User(), conn.RemoteAddr(), cert)
+			}
 			if ok {
 				return s.handleUserCertificate(ctx, conn.User(), cert)
 			}
 
-			return s.handleUserKey(ctx, conn.User(), key)
+			permissions, err := s.handleUserKey(ctx, conn.User(), key)
+			if err != nil {
+				return s.handleUnknownUserKey(ctx, conn, key, err)
+			}
+
+			return permissions, nil
 		},
 		GSSAPIWithMICConfig: gssapiWithMICConfig,
 		ServerVersion:       "SSH-2.0-GitLab-SSHD",
 	}
 
-	s.configureMACs(sshCfg)
-	s.configureKeyExchanges(sshCfg)
-	s.configureCiphers(sshCfg)
+	if s.cfg.MOTD.Banner != "" || s.cfg.MOTD.FromAPI {
+		sshCfg.BannerCallback = func(ssh.ConnMetadata) string {
+			ctx, cancel := context.WithTimeout(parentCtx, 10*time.Second)
+			defer cancel()
+
+			return motd.Banner(ctx, s.cfg)
+		}
+	}
+
+	algorithms := algorithmsPolicy(s.cfg.Server)
+	configureMACs(sshCfg, algorithms)
+	configureKeyExchanges(sshCfg, algorithms)
+	configureCiphers(sshCfg, algorithms)
 	s.configurePublicKeyAlgorithms(sshCfg)
 
+	// A key replaces any previous one of its type, so only the first key of
+	// each type is added: the others are only announced to clients, ahead of
+	// a rotation
+	added := make(map[string]bool)
 	for _, key := range s.hostKeys {
-		sshCfg.AddHostKey(key)
+		if keyType := key.PublicKey().Type(); !added[keyType] {
+			added[keyType] = true
+			sshCfg.AddHostKey(key)
+		}
 	}
 
 	return sshCfg
@@ -270,23 +425,23 @@ func (s *serverConfig) configurePublicKeyAlgorithms(sshCfg *ssh.ServerConfig) {
 	}
 }
 
-func (s *serverConfig) configureCiphers(sshCfg *ssh.ServerConfig) {
-	if len(s.cfg.Server.Ciphers) > 0 {
-		sshCfg.Ciphers = s.cfg.Server.Ciphers
+func configureCiphers(sshCfg *ssh.ServerConfig, algorithms config.AlgorithmsConfig) {
+	if len(algorithms.Ciphers) > 0 {
+		sshCfg.Ciphers = algorithms.Ciphers
 	}
 }
 
-func (s *serverConfig) configureKeyExchanges(sshCfg *ssh.ServerConfig) {
-	if len(s.cfg.Server.KexAlgorithms) > 0 {
-		sshCfg.KeyExchanges = s.cfg.Server.KexAlgorithms
+func configureKeyExchanges(sshCfg *ssh.ServerConfig, algorithms config.AlgorithmsConfig) {
+	if len(algorithms.KexAlgorithms) > 0 {
+		sshCfg.KeyExchanges = algorithms.KexAlgorithms
 	} else {
 		sshCfg.KeyExchanges = supportedKeyExchanges
 	}
 }
 
-func (s *serverConfig) configureMACs(sshCfg *ssh.ServerConfig) {
-	if len(s.cfg.Server.MACs) > 0 {
-		sshCfg.MACs = s.cfg.Server.MACs
+func configureMACs(sshCfg *ssh.ServerConfig, algorithms config.AlgorithmsConfig) {
+	if len(algorithms.MACs) > 0 {
+		sshCfg.MACs = algorithms.MACs
 	} else {
 		sshCfg.MACs = supportedMACs
 	}
@@ -3,10 +3,15 @@ package sshd
 import (
 	"context"
 	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
+	"io"
 	"net/http"
 	"os"
 	"path"
@@ -30,6 +35,63 @@ func TestNewServerConfigWithoutHosts(t *testing.T) {
 	require.Equal(t, "no host keys could be loaded, aborting", err.Error())
 }
 
+func TestGeneratedHostKeys(t *testing.T) {
+	testRoot := testhelper.PrepareTestRootDir(t)
+	dir := path.Join(t.TempDir(), "ssh-hostkeys")
+
+	cfg := &config.Config{
+		GitlabUrl: "http://localhost",
+		Server: config.ServerConfig{
+			HostKeyFiles: []string{path.Join(testRoot, "certs/valid/server.key")},
+			HostKeyDir:   dir,
+		},
+	}
+
+	srvCfg, err := newServerConfig(cfg)
+	require.NoError(t, err)
+
+	// The RSA key is provided, the others are generated
+	require.Len(t, srvCfg.hostKeys, 3)
+	require.Equal(t, ssh.KeyAlgoRSA, srvCfg.hostKeys[0].PublicKey().Type())
+	require.Equal(t, ssh.KeyAlgoECDSA256, srvCfg.hostKeys[1].PublicKey().Type())
+	require.Equal(t, ssh.KeyAlgoED25519, srvCfg.hostKeys[2].PublicKey().Type())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+
+	for _, name := range []string{"ecdsa", "ed25519"} {
+		filename := path.Join(dir, "ssh_host_"+name+"_key")
+
+		info, err := os.Stat(filename)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+		info, err = os.Stat(filename + ".pub")
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+	}
+
+	// The generated keys are loaded again rather than replaced
+	srvCfg2, err := newServerConfig(cfg)
+	require.NoError(t, err)
+	for i := range srvCfg.hostKeys {
+		require.Equal(t, srvCfg.hostKeys[i].PublicKey(), srvCfg2.hostKeys[i].PublicKey())
+	}
+
+	// Without any host key file, every type is generated
+	cfg.Server.HostKeyFiles = nil
+	cfg.Server.HostKeyDir = t.TempDir()
+	srvCfg, err = newServerConfig(cfg)
+	require.NoError(t, err)
+	require.Len(t, srvCfg.hostKeys, 3)
+	require.Equal(t, ssh.KeyAlgoRSA, srvCfg.hostKeys[0].PublicKey().Type())
+}
+
 func TestHostKeyAndCerts(t *testing.T) {
 	testRoot := testhelper.PrepareTestRootDir(t)
 
@@ -80,15 +142,21 @@ func TestUserKeyHandling(t *testing.T) {
 	testRoot := testhelper.PrepareTestRootDir(t)
 
 	validRSAKey := rsaPublicKey(t)
+	validSKEd25519Key := newSKEd25519Signer(t).PublicKey()
+	validSKECDSAKey := skECDSAPublicKey(t)
 
 	requests := []testserver.TestRequestHandler{
 		{
 			Path: "/api/v4/internal/authorized_keys",
 			Handler: func(w http.ResponseWriter, r *http.Request) {
-				key := base64.RawStdEncoding.EncodeToString(validRSAKey.Marshal())
-				if key == r.URL.Query().Get("key") {
+				switch r.URL.Query().Get("key") {
+				case base64.RawStdEncoding.EncodeToString(validRSAKey.Marshal()):
 					w.Write([]byte(`{ "id": 1, "key": "key" }`))
-				} else {
+				case base64.RawStdEncoding.EncodeToString(validSKEd25519Key.Marshal()):
+					w.Write([]byte(`{ "id": 2, "key": "key" }`))
+				case base64.RawStdEncoding.EncodeToString(validSKECDSAKey.Marshal()):
+					w.Write([]byte(`{ "id": 3, "key": "key" }`))
+				default:
 					w.WriteHeader(http.StatusInternalServerError)
 				}
 			},
@@ -141,6 +209,20 @@ func TestUserKeyHandling(t *testing.T) {
 			expectedPermissions: &ssh.Permissions{
 				Extensions: map[string]string{"key-id": "1"},
 			},
+		}, {
+			desc: "successful request with an sk-ssh-ed25519 key",
+			user: "user",
+			key:  validSKEd25519Key,
+			expectedPermissions: &ssh.Permissions{
+				Extensions: map[string]string{"key-id": "2"},
+			},
+		}, {
+			desc: "successful request with an sk-ecdsa-sha2-nistp256 key",
+			user: "user",
+			key:  validSKECDSAKey,
+			expectedPermissions: &ssh.Permissions{
+				Extensions: map[string]string{"key-id": "3"},
+			},
 		},
 	}
 
@@ -356,6 +438,68 @@ func rsaPublicKey(t *testing.T) ssh.PublicKey {
 	return publicKey
 }
 
+// skEd25519Signer signs like a FIDO2 security key holding an
+// sk-ssh-ed25519@openssh.com key registered for application "ssh:"
+type skEd25519Signer struct {
+	publicKey  ssh.PublicKey
+	privateKey ed25519.PrivateKey
+}
+
+func newSKEd25519Signer(t *testing.T) *skEd25519Signer {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	key, err := ssh.ParsePublicKey(ssh.Marshal(struct {
+		Type        string
+		PublicKey   []byte
+		Application string
+	}{ssh.KeyAlgoSKED25519, publicKey, skApplication}))
+	require.NoError(t, err)
+
+	return &skEd25519Signer{publicKey: key, privateKey: privateKey}
+}
+
+const skApplication = "ssh:"
+
+func (s *skEd25519Signer) PublicKey() ssh.PublicKey {
+	return s.publicKey
+}
+
+func (s *skEd25519Signer) Sign(_ io.Reader, data []byte) (*ssh.Signature, error) {
+	applicationDigest := sha256.Sum256([]byte(skApplication))
+	dataDigest := sha256.Sum256(data)
+
+	// User presence is asserted and the signature counter is always 1
+	fields := struct {
+		Flags   byte
+		Counter uint32
+	}{0x01, 1}
+
+	signed := append(applicationDigest[:], ssh.Marshal(fields)...)
+	signed = append(signed, dataDigest[:]...)
+
+	return &ssh.Signature{
+		Format: ssh.KeyAlgoSKED25519,
+		Blob:   ed25519.Sign(s.privateKey, signed),
+		Rest:   ssh.Marshal(fields),
+	}, nil
+}
+
+func skECDSAPublicKey(t *testing.T) ssh.PublicKey {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	key, err := ssh.ParsePublicKey(ssh.Marshal(struct {
+		Type        string
+		Curve       string
+		PublicKey   []byte
+		Application string
+	}{ssh.KeyAlgoSKECDSA256, "nistp256", elliptic.Marshal(elliptic.P256(), privateKey.X, privateKey.Y), skApplication}))
+	require.NoError(t, err)
+
+	return key
+}
+
 func dsaPublicKey(t *testing.T) ssh.PublicKey {
 	privateKey := new(dsa.PrivateKey)
 	params := new(dsa.Parameters)
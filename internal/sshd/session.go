@@ -6,6 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"gitlab.com/gitlab-org/labkit/log"
@@ -14,13 +18,22 @@ import (
 	grpcstatus "google.golang.org/grpc/status"
 
 	shellCmd "gitlab.com/gitlab-org/gitlab-shell/v14/cmd/gitlab-shell/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/auditlog"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/auditpipe"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disallowedcommand"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/errormessage"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/language"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/console"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/i18n"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sessionrecord"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sftp"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/webhook"
 )
 
 type session struct {
@@ -31,18 +44,47 @@ type session struct {
 	gitlabKrb5Principal string
 	gitlabUsername      string
 	namespace           string
-	remoteAddr          string
+	// maintenance returns whether gitlab-sshd is in maintenance, which
+	// restricts the session to the commands allowedInMaintenance
+	maintenance func() maintenanceState
+	// readOnly restricts the session to the commands in readOnlyCommands
+	readOnly   bool
+	remoteAddr string
+	auditPipe  *auditpipe.Pipe
+	auditLog   *auditlog.Logger
+	webhooks   *webhook.Notifier
+	bandwidth  *bandwidthLimiter
 
 	// State managed by the session
 	execCmd            string
 	gitProtocolVersion string
-	started            time.Time
+	// clientTimeout is how long the client waits for the command, see
+	// commandDeadline
+	clientTimeout time.Duration
+	// locale holds the LC_ALL, LC_MESSAGES and LANG env requests, which
+	// select the language of the messages
+	locale  map[string]string
+	started time.Time
+	// stages times the stages of the session, from started
+	stages  *stageTimer
+	metered *meteredChannel
+	// packWriter writes the output of git-upload-pack
+	packWriter atomic.Pointer[packWriter]
+	// auditRecord is set once a command or subsystem is started
+	auditRecord *auditlog.Record
+
+	exitMu     sync.Mutex
+	exitStatus *uint32
 }
 
 type execRequest struct {
 	Command string
 }
 
+type subsystemRequest struct {
+	Name string
+}
+
 type envRequest struct {
 	Name  string
 	Value string
@@ -56,10 +98,25 @@ func (s *session) handle(ctx context.Context, requests <-chan *ssh.Request) (con
 	ctxWithLogData := ctx
 	ctxlog := log.ContextLogger(ctx)
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ctx, cancelOnClose := context.WithCancelCause(ctx)
+	requests = watchRequests(ctx, cancelOnClose, requests)
+
+	s.metered = newMeteredChannel(s.channel)
+	s.channel = s.bandwidth.throttle(ctx, s.metered)
+
+	if s.cfg.Server.SessionIdleTimeout > 0 || s.cfg.Server.MaxSessionDuration > 0 {
+		go s.enforceTimeouts(ctx, cancel, s.metered)
+	}
+
 	ctxlog.Debug("session: handle: entering request loop")
 
 	var err error
 	for req := range requests {
+		s.metered.touch()
+
 		sessionLog := ctxlog.WithFields(log.Fields{
 			"bytesize":   len(req.Payload),
 			"type":       req.Type,
@@ -83,6 +140,8 @@ func (s *session) handle(ctx context.Context, requests <-chan *ssh.Request) (con
 			var status uint32
 			ctxWithLogData, status, err = s.handleShell(ctx, req)
 			s.exit(ctx, status)
+		case "subsystem":
+			shouldContinue, err = s.handleSubsystem(ctx, req)
 		default:
 			// Ignore unknown requests but don't terminate the session
 			shouldContinue = true
@@ -96,6 +155,10 @@ func (s *session) handle(ctx context.Context, requests <-chan *ssh.Request) (con
 
 		sessionLog.WithField("should_continue", shouldContinue).Debug("session: handle: request processed")
 
+		if err != nil && errors.Is(context.Cause(ctx), errChannelClosed) {
+			sessionLog.Info("session: handle: channel closed by the client, request canceled")
+		}
+
 		if !shouldContinue {
 			_ = s.channel.Close()
 			break
@@ -104,6 +167,9 @@ func (s *session) handle(ctx context.Context, requests <-chan *ssh.Request) (con
 
 	ctxlog.Debug("session: handle: exiting request loop")
 
+	s.endStages()
+	s.writeAuditLog(ctxWithLogData)
+
 	return ctxWithLogData, err
 }
 
@@ -120,6 +186,15 @@ func (s *session) handleEnv(ctx context.Context, req *ssh.Request) (bool, error)
 	case sshenv.GitProtocolEnv:
 		s.gitProtocolVersion = envReq.Value
 		accepted = true
+	case sshenv.ClientTimeoutEnv:
+		s.clientTimeout = sshenv.ParseClientTimeout(envReq.Value)
+		accepted = s.clientTimeout > 0
+	case sshenv.LCAllEnv, sshenv.LCMessagesEnv, sshenv.LangEnv:
+		if s.locale == nil {
+			s.locale = make(map[string]string)
+		}
+		s.locale[envReq.Name] = envReq.Value
+		accepted = true
 	default:
 		// Client requested a forbidden envvar, nothing to do
 	}
@@ -152,6 +227,57 @@ func (s *session) handleExec(ctx context.Context, req *ssh.Request) (context.Con
 	return ctxWithLogData, err
 }
 
+func (s *session) handleSubsystem(ctx context.Context, req *ssh.Request) (bool, error) {
+	var subsystemReq subsystemRequest
+
+	if err := ssh.Unmarshal(req.Payload, &subsystemReq); err != nil {
+		return false, err
+	}
+
+	accepted := subsystemReq.Name == "sftp" && s.cfg.Server.SFTP.Enabled
+	if req.WantReply {
+		if err := req.Reply(accepted, []byte{}); err != nil {
+			log.ContextLogger(ctx).WithError(err).Debug("session: handleSubsystem: Failed to reply")
+		}
+	}
+
+	log.WithContextFields(
+		ctx, log.Fields{"accepted": accepted, "subsystem": subsystemReq.Name},
+	).Info("session: handleSubsystem: processed")
+
+	if !accepted {
+		return true, nil
+	}
+
+	env := sshenv.Env{
+		IsSSHConnection: true,
+		OriginalCommand: subsystemReq.Name,
+		RemoteAddr:      s.remoteAddr,
+		NamespacePath:   s.namespace,
+	}
+
+	s.auditPipe.Write(auditpipe.NewRecord(env))
+	s.startAuditRecord(env)
+	metrics.SshdSessionsTotal.WithLabelValues(subsystemReq.Name).Inc()
+	s.stages.end(stageCommandStart)
+
+	args := &commandargs.Shell{
+		GitlabKeyId:         s.gitlabKeyID,
+		GitlabUsername:      s.gitlabUsername,
+		GitlabKrb5Principal: s.gitlabKrb5Principal,
+		Env:                 env,
+	}
+
+	var status uint32
+	err := sftp.NewFilesystem(ctx, s.cfg, args).Serve(ctx, s.channel)
+	if err != nil {
+		status = 1
+	}
+	s.exit(ctx, status)
+
+	return false, err
+}
+
 func (s *session) handleShell(ctx context.Context, req *ssh.Request) (context.Context, uint32, error) {
 	ctxlog := log.ContextLogger(ctx)
 
@@ -165,10 +291,18 @@ func (s *session) handleShell(ctx context.Context, req *ssh.Request) (context.Co
 		IsSSHConnection:    true,
 		OriginalCommand:    s.execCmd,
 		GitProtocolVersion: s.gitProtocolVersion,
+		ProtocolVersion:    sshenv.ParseProtocolVersion(s.gitProtocolVersion),
 		RemoteAddr:         s.remoteAddr,
 		NamespacePath:      s.namespace,
+		ClientTimeout:      s.clientTimeout,
+		Language:           sshenv.ParseLanguage(func(name string) string { return s.locale[name] }),
 	}
 
+	s.auditPipe.Write(auditpipe.NewRecord(env))
+	s.startAuditRecord(env)
+
+	ctx = i18n.NewContext(ctx, language.Select(s.cfg, env, ""))
+
 	countingWriter := &readwriter.CountingWriter{W: s.channel}
 
 	rw := &readwriter.ReadWriter{
@@ -184,12 +318,57 @@ func (s *session) handleShell(ctx context.Context, req *ssh.Request) (context.Co
 	}
 
 	cmdName := reflect.TypeOf(cmd).String()
+	cmdType := commandType(env)
+
+	if cmdType == commandargs.UploadPack {
+		packWriter := newPackWriter(countingWriter.W)
+		countingWriter.W = packWriter
+		s.packWriter.Store(packWriter)
+	}
+
+	if s.readOnly && !slices.Contains(readOnlyCommands, cmdType) {
+		s.toStderr(ctx, "ERROR: %v\n", errReadOnlySession)
+		return ctx, 1, errReadOnlySession
+	}
+
+	if err := s.checkMaintenance(ctx, env); err != nil {
+		return ctx, 1, err
+	}
+
+	// Interactive access that can't be recorded is refused
+	recorder, err := sessionrecord.Start(ctx, s.cfg, cmd, rw)
+	if err != nil {
+		ctxlog.WithError(err).Error("session: handleShell: failed to start the session recording")
+		s.toStderr(ctx, "ERROR: %s\n", i18n.Text(i18n.FromContext(ctx), "Failed to record the session"))
+		return ctx, 1, err
+	}
+	defer func() {
+		if err := recorder.Close(); err != nil {
+			ctxlog.WithError(err).Error("session: handleShell: failed to record the session")
+		}
+	}()
 
 	establishSessionDuration := time.Since(s.started).Seconds()
 	ctxlog.WithFields(log.Fields{
 		"env": env, "command": cmdName, "established_session_duration_s": establishSessionDuration,
 	}).Info("session: handleShell: executing command")
 	metrics.SshdSessionEstablishedDuration.Observe(establishSessionDuration)
+	s.stages.end(stageCommandStart)
+	metrics.SshdSessionsTotal.WithLabelValues(commandLabel(cmdName)).Inc()
+
+	if interval := time.Duration(s.cfg.Server.PushHeartbeatInterval); interval > 0 && cmdType == commandargs.ReceivePack {
+		heartbeatCtx, stopHeartbeat := context.WithCancel(ctx)
+		defer stopHeartbeat()
+		go s.heartbeat(heartbeatCtx, interval)
+	}
+
+	// The RPCs to Gitaly are given the time left to the session, for them not
+	// to carry on once the client gave up
+	if deadline, ok := s.commandDeadline(cmdType); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
 
 	ctxWithLogData, err := cmd.Execute(ctx)
 
@@ -201,7 +380,7 @@ func (s *session) handleShell(ctx context.Context, req *ssh.Request) (context.Co
 	if err != nil {
 		grpcStatus := grpcstatus.Convert(err)
 		if grpcStatus.Code() != grpccodes.Internal {
-			s.toStderr(ctx, "ERROR: %v\n", grpcStatus.Message())
+			s.errorToStderr(ctx, err, grpcStatus.Message())
 		}
 
 		return ctx, 1, err
@@ -212,11 +391,44 @@ func (s *session) handleShell(ctx context.Context, req *ssh.Request) (context.Co
 	return ctxWithLogData, 0, nil
 }
 
+// endStages ends the stages of a session that ran a command, the first byte
+// being timed by when it was sent
+func (s *session) endStages() {
+	if !s.stages.ended(stageCommandStart) {
+		return
+	}
+
+	if sent, ok := s.metered.firstSent(); ok {
+		s.stages.endAt(stageFirstByte, sent)
+	}
+	s.stages.end(stageCompletion)
+}
+
+// commandLabel turns the type name of a command, e.g. "*uploadpack.Command",
+// into the name of its package
+func commandLabel(cmdName string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(cmdName, "*"), ".Command")
+}
+
+// commandType returns the type of the command requested in env. Unlike the
+// type of the command built for it, it isn't hidden by the wrapping command
+// policy.
+func commandType(env sshenv.Env) commandargs.CommandType {
+	args, err := shellCmd.Parse(nil, env)
+	if err != nil {
+		return ""
+	}
+
+	return args.CommandType
+}
+
 func (s *session) handleCommandError(ctx context.Context, err error) (context.Context, uint32, error) {
+	lang := i18n.FromContext(ctx)
+
 	if errors.Is(err, disallowedcommand.Error) {
-		s.toStderr(ctx, "ERROR: Unknown command: %v\n", s.execCmd)
+		s.toStderr(ctx, "ERROR: %s\n", i18n.Sprintf(lang, "Unknown command: %v", s.execCmd))
 	} else {
-		s.toStderr(ctx, "ERROR: Failed to parse command: %v\n", err.Error())
+		s.toStderr(ctx, "ERROR: %s\n", i18n.Sprintf(lang, "Failed to parse command: %v", err.Error()))
 	}
 
 	return ctx, 128, err
@@ -243,8 +455,70 @@ func (s *session) toStderr(ctx context.Context, format string, args ...interface
 	console.DisplayWarningMessage(out, s.channel.Stderr())
 }
 
+// errorToStderr shows the message of the error a command failed with, along
+// with the correlation ID of the connection
+func (s *session) errorToStderr(ctx context.Context, err error, message string) {
+	messages := errormessage.Messages(ctx, s.cfg, err, message)
+	messages[0] = "ERROR: " + messages[0]
+
+	log.WithContextFields(ctx, log.Fields{"stderr": messages}).Debug("session: errorToStderr: output")
+	console.DisplayWarningMessages(messages, s.channel.Stderr())
+}
+
+// startAuditRecord starts the audit record of the command or subsystem about
+// to run in env
+func (s *session) startAuditRecord(env sshenv.Env) {
+	record := auditlog.NewRecord(env)
+	record.KeyID = s.gitlabKeyID
+	record.Username = s.gitlabUsername
+	s.auditRecord = &record
+}
+
+// writeAuditLog completes the audit record of the session with its outcome
+// and writes it, and notifies the webhooks of it. Sessions that didn't run a
+// command have no record.
+func (s *session) writeAuditLog(ctx context.Context) {
+	if (s.auditLog == nil && s.webhooks == nil) || s.auditRecord == nil {
+		return
+	}
+
+	record := *s.auditRecord
+
+	logData := extractLogDataFromContext(ctx)
+	if logData.Username != "" {
+		record.Username = logData.Username
+	}
+	if logData.Meta.Project != "" {
+		record.Repository = logData.Meta.Project
+	}
+
+	record.BytesIn = s.metered.bytesIn.Load()
+	record.BytesOut = s.metered.bytesOut.Load()
+	record.DurationS = time.Since(record.Time).Seconds()
+
+	s.exitMu.Lock()
+	record.Result = auditlog.ResultFailure
+	if s.exitStatus != nil {
+		record.ExitStatus = *s.exitStatus
+		if *s.exitStatus == 0 {
+			record.Result = auditlog.ResultSuccess
+		}
+	}
+	s.exitMu.Unlock()
+
+	s.auditLog.Write(record)
+	s.webhooks.Notify(webhook.NewEvent(record))
+}
+
 func (s *session) exit(ctx context.Context, status uint32) {
 	log.WithContextFields(ctx, log.Fields{"exit_status": status}).Info("session: exit: exiting")
+
+	// The first exit status is the one the client sees
+	s.exitMu.Lock()
+	if s.exitStatus == nil {
+		s.exitStatus = &status
+	}
+	s.exitMu.Unlock()
 	req := exitStatusReq{ExitStatus: status}
 
 	_ = s.channel.CloseWrite()
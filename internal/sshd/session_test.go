@@ -3,17 +3,23 @@ package sshd
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/ssh"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/auditpipe"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/console"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
 )
 
 type fakeChannel struct {
@@ -65,6 +71,8 @@ func TestHandleEnv(t *testing.T) {
 		payload                 []byte
 		expectedErr             error
 		expectedProtocolVersion string
+		expectedClientTimeout   time.Duration
+		expectedLocale          map[string]string
 		expectedResult          bool
 	}{
 		{
@@ -85,6 +93,20 @@ func TestHandleEnv(t *testing.T) {
 			expectedErr:             nil,
 			expectedProtocolVersion: "1",
 			expectedResult:          true,
+		}, {
+			desc:                    "valid payload with client timeout",
+			payload:                 ssh.Marshal(envRequest{Name: "GL_CLIENT_TIMEOUT", Value: "90"}),
+			expectedErr:             nil,
+			expectedProtocolVersion: "1",
+			expectedClientTimeout:   90 * time.Second,
+			expectedResult:          true,
+		}, {
+			desc:                    "valid payload with locale",
+			payload:                 ssh.Marshal(envRequest{Name: "LANG", Value: "de_DE.UTF-8"}),
+			expectedErr:             nil,
+			expectedProtocolVersion: "1",
+			expectedLocale:          map[string]string{"LANG": "de_DE.UTF-8"},
+			expectedResult:          true,
 		},
 	}
 
@@ -98,6 +120,135 @@ func TestHandleEnv(t *testing.T) {
 			require.Equal(t, tc.expectedErr, err)
 			require.Equal(t, tc.expectedResult, shouldContinue)
 			require.Equal(t, tc.expectedProtocolVersion, s.gitProtocolVersion)
+			require.Equal(t, tc.expectedClientTimeout, s.clientTimeout)
+			require.Equal(t, tc.expectedLocale, s.locale)
+		})
+	}
+}
+
+func TestCommandDeadline(t *testing.T) {
+	started := time.Now()
+
+	testCases := []struct {
+		desc             string
+		server           config.ServerConfig
+		clientTimeout    time.Duration
+		cmdType          commandargs.CommandType
+		expectedDeadline time.Time
+	}{
+		{
+			desc:    "no limits",
+			cmdType: commandargs.UploadPack,
+		}, {
+			desc:             "max session duration",
+			server:           config.ServerConfig{MaxSessionDuration: config.YamlDuration(time.Hour), PackfileGracePeriod: config.YamlDuration(time.Minute)},
+			cmdType:          commandargs.ReceivePack,
+			expectedDeadline: started.Add(time.Hour),
+		}, {
+			desc:             "max session duration extended for upload-pack",
+			server:           config.ServerConfig{MaxSessionDuration: config.YamlDuration(time.Hour), PackfileGracePeriod: config.YamlDuration(time.Minute)},
+			cmdType:          commandargs.UploadPack,
+			expectedDeadline: started.Add(time.Hour + time.Minute),
+		}, {
+			desc:             "client timeout first",
+			server:           config.ServerConfig{MaxSessionDuration: config.YamlDuration(time.Hour)},
+			clientTimeout:    time.Minute,
+			cmdType:          commandargs.UploadPack,
+			expectedDeadline: started.Add(time.Minute),
+		}, {
+			desc:             "client timeout after the max session duration",
+			server:           config.ServerConfig{MaxSessionDuration: config.YamlDuration(time.Hour)},
+			clientTimeout:    2 * time.Hour,
+			cmdType:          commandargs.ReceivePack,
+			expectedDeadline: started.Add(time.Hour),
+		}, {
+			desc:             "client timeout alone",
+			clientTimeout:    time.Minute,
+			cmdType:          commandargs.ReceivePack,
+			expectedDeadline: started.Add(time.Minute),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := &session{cfg: &config.Config{Server: tc.server}, started: started, clientTimeout: tc.clientTimeout}
+
+			deadline, ok := s.commandDeadline(tc.cmdType)
+			require.Equal(t, !tc.expectedDeadline.IsZero(), ok)
+			require.Equal(t, tc.expectedDeadline, deadline)
+		})
+	}
+}
+
+func TestHandleShellUploadPackWithCommandPolicy(t *testing.T) {
+	url := testserver.StartHttpServer(t, []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/allowed",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				// Past the max session duration, within the grace period
+				time.Sleep(100 * time.Millisecond)
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"status": false, "message": "Access denied"}`))
+			},
+		},
+	})
+
+	stdErr := &bytes.Buffer{}
+	s := &session{
+		gitlabKeyID: "root",
+		execCmd:     "git-upload-pack group/repo",
+		channel:     &fakeChannel{stdErr: stdErr, stdOut: &bytes.Buffer{}},
+		started:     time.Now(),
+		cfg: &config.Config{
+			GitlabUrl: url,
+			Server: config.ServerConfig{
+				MaxSessionDuration:  config.YamlDuration(10 * time.Millisecond),
+				PackfileGracePeriod: config.YamlDuration(time.Hour),
+			},
+			CommandPolicy: config.CommandPolicyConfig{DenyTokenCommands: true},
+		},
+	}
+
+	_, exitCode, err := s.handleShell(context.Background(), &ssh.Request{})
+	require.Error(t, err)
+	require.Equal(t, uint32(1), exitCode)
+	require.Contains(t, stdErr.String(), "Access denied")
+	require.NotNil(t, s.packWriter.Load())
+}
+
+func TestHandleSubsystem(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		payload          []byte
+		sftpEnabled      bool
+		expectedErr      error
+		expectedContinue bool
+	}{
+		{
+			desc:        "invalid payload",
+			payload:     []byte("invalid"),
+			expectedErr: errors.New("ssh: unmarshal error for field Name of type subsystemRequest"),
+		}, {
+			desc:             "sftp when disabled",
+			payload:          ssh.Marshal(subsystemRequest{Name: "sftp"}),
+			expectedContinue: true,
+		}, {
+			desc:             "unknown subsystem",
+			payload:          ssh.Marshal(subsystemRequest{Name: "netconf"}),
+			sftpEnabled:      true,
+			expectedContinue: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cfg := &config.Config{Server: config.ServerConfig{SFTP: config.SFTPConfig{Enabled: tc.sftpEnabled}}}
+			s := &session{cfg: cfg, channel: &fakeChannel{stdErr: &bytes.Buffer{}, stdOut: &bytes.Buffer{}}}
+
+			shouldContinue, err := s.handleSubsystem(context.Background(), &ssh.Request{Payload: tc.payload})
+
+			require.Equal(t, tc.expectedErr, err)
+			require.Equal(t, tc.expectedContinue, shouldContinue)
 		})
 	}
 }
@@ -170,6 +321,7 @@ func TestHandleShell(t *testing.T) {
 		cmd                  string
 		errMsg               string
 		gitlabKeyID          string
+		locale               map[string]string
 		expectedOutString    string
 		expectedErrString    string
 		expectedExitCode     uint32
@@ -191,11 +343,20 @@ func TestHandleShell(t *testing.T) {
 			expectedErrString: "Disallowed command",
 			expectedExitCode:  128,
 		},
+		{
+			desc:              "specified command is unknown, in the language of the client",
+			cmd:               "unknown-command",
+			errMsg:            "ERROR: Unbekannter Befehl: unknown-command\n",
+			gitlabKeyID:       "root",
+			locale:            map[string]string{"LANG": "de_DE.UTF-8"},
+			expectedErrString: "Disallowed command",
+			expectedExitCode:  128,
+		},
 		{
 			desc:              "fails to parse command",
 			cmd:               "discover",
 			gitlabKeyID:       "",
-			errMsg:            "ERROR: Failed to get username: who='' is invalid\n",
+			errMsg:            "ERROR: Failed to get username: who='' is invalid",
 			expectedErrString: "Failed to get username: who='' is invalid",
 			expectedExitCode:  1,
 		},
@@ -220,6 +381,7 @@ func TestHandleShell(t *testing.T) {
 			s := &session{
 				gitlabKeyID: tc.gitlabKeyID,
 				execCmd:     tc.cmd,
+				locale:      tc.locale,
 				channel:     &fakeChannel{stdErr: stdErr, stdOut: stdOut},
 				cfg:         &config.Config{GitlabUrl: url},
 			}
@@ -250,3 +412,169 @@ func TestHandleShell(t *testing.T) {
 		})
 	}
 }
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestHandleShellWritesAuditRecord(t *testing.T) {
+	url := testserver.StartHttpServer(t, requests)
+
+	out := &bytes.Buffer{}
+	s := &session{
+		gitlabKeyID: "root",
+		execCmd:     "discover",
+		remoteAddr:  "192.0.2.1",
+		channel:     &fakeChannel{stdErr: &bytes.Buffer{}, stdOut: &bytes.Buffer{}},
+		cfg:         &config.Config{GitlabUrl: url},
+		auditPipe:   auditpipe.New(nopWriteCloser{out}),
+	}
+
+	initialDiscoverSessions := testutil.ToFloat64(metrics.SshdSessionsTotal.WithLabelValues("discover"))
+
+	_, exitCode, err := s.handleShell(context.Background(), &ssh.Request{})
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), exitCode)
+	require.NoError(t, s.auditPipe.Close())
+	require.InDelta(t, initialDiscoverSessions+1, testutil.ToFloat64(metrics.SshdSessionsTotal.WithLabelValues("discover")), 0.1)
+
+	var record auditpipe.Record
+	require.NoError(t, json.Unmarshal(out.Bytes(), &record))
+	require.Equal(t, "192.0.2.1", record.RemoteIP)
+	require.Equal(t, "discover", record.Command)
+	require.Empty(t, record.Repository)
+}
+
+func TestHandleShellReadOnly(t *testing.T) {
+	url := testserver.StartHttpServer(t, requests)
+
+	stdErr := &bytes.Buffer{}
+	s := &session{
+		gitlabKeyID: "root",
+		execCmd:     "discover",
+		readOnly:    true,
+		channel:     &fakeChannel{stdErr: stdErr, stdOut: &bytes.Buffer{}},
+		cfg:         &config.Config{GitlabUrl: url},
+	}
+
+	_, exitCode, err := s.handleShell(context.Background(), &ssh.Request{})
+	require.Equal(t, errReadOnlySession, err)
+	require.Equal(t, uint32(1), exitCode)
+	require.Contains(t, stdErr.String(), "ERROR: GitLab is unavailable, only fetches and archives are allowed\n")
+}
+
+func TestHandleShellReadOnlyWithCommandPolicy(t *testing.T) {
+	url := testserver.StartHttpServer(t, requests)
+
+	stdErr := &bytes.Buffer{}
+	s := &session{
+		gitlabKeyID: "root",
+		execCmd:     "git-upload-pack group/repo",
+		readOnly:    true,
+		channel:     &fakeChannel{stdErr: stdErr, stdOut: &bytes.Buffer{}},
+		cfg: &config.Config{
+			GitlabUrl:     url,
+			CommandPolicy: config.CommandPolicyConfig{DenyTokenCommands: true},
+		},
+	}
+
+	_, _, err := s.handleShell(context.Background(), &ssh.Request{})
+	require.NotEqual(t, errReadOnlySession, err)
+	require.NotContains(t, stdErr.String(), errReadOnlySession.Error())
+}
+
+func TestHandleCancelsRequestOnChannelClose(t *testing.T) {
+	canceled := make(chan struct{})
+	url := testserver.StartHttpServer(t, []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/discover",
+			Handler: func(_ http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+					close(canceled)
+				case <-time.After(10 * time.Second):
+				}
+			},
+		},
+	})
+
+	s := &session{
+		gitlabKeyID: "root",
+		channel:     &fakeChannel{stdErr: &bytes.Buffer{}, stdOut: &bytes.Buffer{}},
+		cfg:         &config.Config{GitlabUrl: url},
+	}
+
+	reqs := make(chan *ssh.Request, 1)
+	reqs <- &ssh.Request{Type: "exec", Payload: ssh.Marshal(execRequest{Command: "discover"})}
+
+	done := make(chan error)
+	go func() {
+		_, err := s.handle(context.Background(), reqs)
+		done <- err
+	}()
+
+	// The client goes away while the internal API is called
+	time.Sleep(50 * time.Millisecond)
+	close(reqs)
+
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the request to the internal API outlived the channel")
+	}
+
+	select {
+	case err := <-done:
+		require.Error(t, err)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the session outlived the channel")
+	}
+}
+
+func TestWatchRequests(t *testing.T) {
+	t.Run("requests are forwarded until the channel is closed", func(t *testing.T) {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		defer cancel(nil)
+
+		reqs := make(chan *ssh.Request)
+		forwarded := watchRequests(ctx, cancel, reqs)
+
+		req := &ssh.Request{Type: "env"}
+		reqs <- req
+		require.Same(t, req, <-forwarded)
+
+		close(reqs)
+		_, ok := <-forwarded
+		require.False(t, ok)
+		require.ErrorIs(t, context.Cause(ctx), errChannelClosed)
+	})
+
+	t.Run("the closing is noticed while requests are pending", func(t *testing.T) {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		defer cancel(nil)
+
+		reqs := make(chan *ssh.Request)
+		forwarded := watchRequests(ctx, cancel, reqs)
+
+		for i := 0; i < maxPendingRequests+1; i++ {
+			reqs <- &ssh.Request{Type: "window-change"}
+		}
+		close(reqs)
+
+		<-ctx.Done()
+		require.ErrorIs(t, context.Cause(ctx), errChannelClosed)
+		for range forwarded {
+		}
+	})
+
+	t.Run("forwarding stops once the session is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		forwarded := watchRequests(ctx, cancel, make(chan *ssh.Request))
+
+		cancel(nil)
+		_, ok := <-forwarded
+		require.False(t, ok)
+	})
+}
@@ -0,0 +1,139 @@
+package sshd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+const (
+	idleTimeoutReason        = "idle"
+	maxSessionDurationReason = "max_duration"
+
+	// timeoutExitStatus is the exit status of a session closed for exceeding
+	// a timeout, as OpenSSH reports for a session terminated by the server
+	timeoutExitStatus = 255
+)
+
+// enforceTimeouts closes the session once it has been idle for longer than
+// the session idle timeout, or open for longer than the max session duration.
+// It returns once ctx is done.
+func (s *session) enforceTimeouts(ctx context.Context, cancel context.CancelFunc, channel *meteredChannel) {
+	idleTimeout := time.Duration(s.cfg.Server.SessionIdleTimeout)
+	maxDuration := time.Duration(s.cfg.Server.MaxSessionDuration)
+
+	var idleTimer *time.Timer
+	var idleC, maxDurationC <-chan time.Time
+
+	if idleTimeout > 0 {
+		idleTimer = time.NewTimer(idleTimeout)
+		defer idleTimer.Stop()
+		idleC = idleTimer.C
+	}
+
+	var maxDurationTimer *time.Timer
+	if maxDuration > 0 {
+		maxDurationTimer = time.NewTimer(maxDuration - time.Since(s.started))
+		defer maxDurationTimer.Stop()
+		maxDurationC = maxDurationTimer.C
+	}
+	gracePeriod := time.Duration(s.cfg.Server.PackfileGracePeriod)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-maxDurationC:
+			if gracePeriod > 0 && s.sendingPack() {
+				log.WithContextFields(ctx, log.Fields{"grace_period": gracePeriod.String()}).Info("session: enforceTimeouts: packfile being sent, extending the session")
+
+				// The grace period is given once
+				maxDurationTimer.Reset(gracePeriod)
+				gracePeriod = 0
+				continue
+			}
+
+			s.terminate(ctx, cancel, maxSessionDurationReason, fmt.Sprintf("Maximum session duration of %v exceeded", maxDuration))
+			return
+		case <-idleC:
+			idle := channel.idleFor()
+			if idle >= idleTimeout {
+				s.terminate(ctx, cancel, idleTimeoutReason, fmt.Sprintf("Session idle for longer than %v", idleTimeout))
+				return
+			}
+
+			// Activity happened since the timer was set: wait for the rest
+			// of the timeout from the last activity
+			idleTimer.Reset(idleTimeout - idle)
+		}
+	}
+}
+
+// commandDeadline returns the time by which a command of type cmdType must
+// complete: the end of the max session duration, extended by the packfile
+// grace period for git-upload-pack, or the end of the timeout of the client if
+// it comes first. There's no deadline when neither is set.
+func (s *session) commandDeadline(cmdType commandargs.CommandType) (time.Time, bool) {
+	var deadline time.Time
+
+	if maxDuration := time.Duration(s.cfg.Server.MaxSessionDuration); maxDuration > 0 {
+		deadline = s.started.Add(maxDuration)
+		if cmdType == commandargs.UploadPack {
+			deadline = deadline.Add(time.Duration(s.cfg.Server.PackfileGracePeriod))
+		}
+	}
+
+	if s.clientTimeout > 0 {
+		if clientDeadline := s.started.Add(s.clientTimeout); deadline.IsZero() || clientDeadline.Before(deadline) {
+			deadline = clientDeadline
+		}
+	}
+
+	return deadline, !deadline.IsZero()
+}
+
+// terminate tells the client why the session is closed, closes it and
+// cancels ctx so that a running command is interrupted
+func (s *session) terminate(ctx context.Context, cancel context.CancelFunc, reason, message string) {
+	log.WithContextFields(ctx, log.Fields{"reason": reason}).Info("session: terminate: session timed out")
+	metrics.SshdSessionTimeoutsTotal.WithLabelValues(reason).Inc()
+
+	message += ", closing the session"
+	if packWriter := s.packWriter.Load(); packWriter != nil {
+		message += ". " + resumeHint
+		s.interruptUploadPack(ctx, packWriter, reason, message)
+	}
+
+	s.toStderr(ctx, "%s\n", message)
+	s.exit(ctx, timeoutExitStatus)
+	_ = s.channel.Close()
+
+	cancel()
+}
+
+// sendingPack reports whether the session runs git-upload-pack, which has
+// started sending the packfile
+func (s *session) sendingPack() bool {
+	packWriter := s.packWriter.Load()
+
+	return packWriter != nil && packWriter.sendingPack.Load()
+}
+
+// interruptUploadPack sends message to git as the error the clone or fetch
+// fails with, so that it's shown along with the hint to resume it
+func (s *session) interruptUploadPack(ctx context.Context, packWriter *packWriter, reason, message string) {
+	sent := packWriter.interrupt(message, interruptTimeout)
+
+	log.WithContextFields(ctx, log.Fields{
+		"reason":       reason,
+		"sending_pack": packWriter.sendingPack.Load(),
+		"bytes_out":    s.metered.bytesOut.Load(),
+		"duration_s":   time.Since(s.started).Seconds(),
+		"error_sent":   sent,
+	}).Warn("session: interruptUploadPack: upload-pack interrupted")
+}
@@ -2,27 +2,45 @@
 package sshd
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	proxyproto "github.com/pires/go-proxyproto"
 	"golang.org/x/crypto/ssh"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/auditlog"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/auditpipe"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/commandlimiter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/systemd"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/telemetry"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/webhook"
 
-	"gitlab.com/gitlab-org/labkit/correlation"
 	"gitlab.com/gitlab-org/labkit/log"
 )
 
+// SystemdSocketName is the FileDescriptorName= of the SSH socket when systemd
+// passes several sockets
+const SystemdSocketName = "ssh"
+
+// DrainProgressInterval is how often Drain logs the connections left to drain
+var DrainProgressInterval = 5 * time.Second
+
 type status int
 
 const (
@@ -41,17 +59,44 @@ const (
 
 // Server represents an SSH server instance
 type Server struct {
+	// Config is replaced by Reload and must only be read through
+	// currentConfig once the server is serving
 	Config *config.Config
 
 	status       status
 	statusMu     sync.RWMutex
 	wg           sync.WaitGroup
-	listener     net.Listener
+	listeners    []*sshListener
+	configMu     sync.RWMutex
 	serverConfig *serverConfig
+	tenants      map[string]*tenant
+	auditPipe    *auditpipe.Pipe
+	auditLog     *auditlog.Logger
+	webhooks     *webhook.Notifier
+	limiter      *connectionLimiter
+	tarpit       *tarpit
+	commands     *commandlimiter.Limiter
+	bandwidth    *bandwidthLimiter
+	connections  atomic.Int64
+	closed       chan struct{}
+	upgrading    atomic.Bool
+	maintenance  maintenanceMode
 }
 
 type logInfo struct{}
 
+// sshListener is an address the server accepts connections on, along with
+// the limits of the connections accepted on it
+type sshListener struct {
+	net.Listener
+	// address is the configured address, and socket the listener before
+	// the TCP keepalive and PROXY protocol handling, to hand over on Upgrade
+	address       string
+	socket        net.Listener
+	proxyProtocol bool
+	limiter       *connectionLimiter
+}
+
 // NewServer creates a new instance of Server
 func NewServer(cfg *config.Config) (*Server, error) {
 	serverConfig, err := newServerConfig(cfg)
@@ -59,7 +104,52 @@ func NewServer(cfg *config.Config) (*Server, error) {
 		return nil, err
 	}
 
-	return &Server{Config: cfg, serverConfig: serverConfig}, nil
+	commands, err := commandlimiter.New(cfg.Server.CommandLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	tenants, err := loadTenants(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &Server{
+		Config:       cfg,
+		serverConfig: serverConfig,
+		tenants:      tenants,
+		limiter:      newConnectionLimiter(cfg.Server.ConnectionLimits),
+		tarpit:       newTarpit(cfg.Server.Tarpit),
+		commands:     commands,
+		bandwidth:    newBandwidthLimiter(cfg.Server.BandwidthLimits),
+		closed:       make(chan struct{}),
+	}
+
+	if cfg.Server.AuditPipe != "" {
+		server.auditPipe, err = auditpipe.Open(cfg.Server.AuditPipe)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Server.AuditLog != "" {
+		server.auditLog, err = auditlog.Open(cfg.Server.AuditLog)
+		if err != nil {
+			_ = server.auditPipe.Close()
+			return nil, err
+		}
+	}
+
+	if len(cfg.Server.SessionWebhooks.URLs) > 0 {
+		server.webhooks, err = newWebhookNotifier(cfg.Server.SessionWebhooks)
+		if err != nil {
+			_ = server.auditPipe.Close()
+			_ = server.auditLog.Close()
+			return nil, err
+		}
+	}
+
+	return server, nil
 }
 
 // ListenAndServe starts listening for SSH connections and serves them
@@ -67,22 +157,146 @@ func (s *Server) ListenAndServe(ctx context.Context) error {
 	if err := s.listen(ctx); err != nil {
 		return err
 	}
-	defer func() { _ = s.listener.Close() }()
+	defer s.closeListeners()
+	defer func() { _ = s.auditPipe.Close() }()
+	defer func() { _ = s.auditLog.Close() }()
+	defer s.webhooks.Close()
 
 	s.serve(ctx)
 
+	// The sessions, and so the RPCs to Gitaly, are over
+	cfg, _ := s.currentConfig()
+	cfg.GitalyClient.Close()
+
+	s.configMu.RLock()
+	closeTenants(s.tenants)
+	s.configMu.RUnlock()
+
+	return nil
+}
+
+// Reload makes new connections use cfg. The host keys and certificates,
+// the internal API clients along with their TLS material, the IP filter, and
+// the authentication and protocol settings are rebuilt from it, along with the
+// configuration of the instances served on its listeners, and the current
+// configuration is kept if that fails. Established connections carry on with
+// the configuration they were accepted with. The listen addresses, PROXY
+// protocol, connection limits, audit pipe and audit log only change on
+// restart.
+func (s *Server) Reload(cfg *config.Config) error {
+	notifySystemd(context.Background(), systemd.Reloading)
+	// systemd waits for a reload to complete, whether or not it succeeds
+	defer notifySystemd(context.Background(), systemd.Ready)
+
+	serverConfig, err := newServerConfig(cfg)
+	if err != nil {
+		metrics.SshdConfigReloadsTotal.WithLabelValues("fail").Inc()
+
+		return fmt.Errorf("failed to reload configuration: %w", err)
+	}
+
+	tenants, err := loadTenants(cfg)
+	if err != nil {
+		metrics.SshdConfigReloadsTotal.WithLabelValues("fail").Inc()
+
+		return fmt.Errorf("failed to reload configuration: %w", err)
+	}
+
+	s.configMu.Lock()
+	s.Config = cfg
+	s.serverConfig = serverConfig
+	s.tenants = tenants
+	s.configMu.Unlock()
+
+	metrics.SshdConfigReloadsTotal.WithLabelValues("ok").Inc()
+
 	return nil
 }
 
+func (s *Server) currentConfig() (*config.Config, *serverConfig) {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+
+	return s.Config, s.serverConfig
+}
+
+// listenerConfig returns the configuration of the connections accepted on
+// address: that of the instance served on it, if any, or the current one
+func (s *Server) listenerConfig(address string) (*config.Config, *serverConfig) {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+
+	if t, ok := s.tenants[address]; ok {
+		return t.cfg, t.serverConfig
+	}
+
+	return s.Config, s.serverConfig
+}
+
 // Shutdown gracefully shuts down the SSH server
 func (s *Server) Shutdown() error {
-	if s.listener == nil {
+	if len(s.listeners) == 0 {
 		return nil
 	}
 
 	s.changeStatus(StatusOnShutdown)
+	notifySystemd(context.Background(), systemd.Stopping)
 
-	return s.listener.Close()
+	return s.closeListeners()
+}
+
+func (s *Server) closeListeners() error {
+	var errs []error
+	for _, l := range s.listeners {
+		if err := l.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Drain shuts the server down gracefully: it stops accepting new connections
+// and waits up to timeout for the in-flight ones to complete, logging
+// progress every DrainProgressInterval. It returns the number of connections
+// still open when it stopped waiting; these are closed by canceling the
+// context given to ListenAndServe.
+func (s *Server) Drain(ctx context.Context, timeout time.Duration) int64 {
+	metrics.SshdDraining.Set(1)
+	defer metrics.SshdDraining.Set(0)
+
+	if err := s.Shutdown(); err != nil {
+		log.ContextLogger(ctx).WithError(err).Warn("Failed to stop accepting connections")
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	progress := time.NewTicker(DrainProgressInterval)
+	defer progress.Stop()
+
+	started := time.Now()
+
+	for {
+		select {
+		case <-s.closed:
+			log.WithContextFields(ctx, log.Fields{"duration_s": time.Since(started).Seconds()}).Info("Drained all connections")
+
+			return 0
+		case <-progress.C:
+			log.WithContextFields(ctx, log.Fields{
+				"in_flight_connections": s.connections.Load(),
+				"remaining_s":           (timeout - time.Since(started)).Seconds(),
+			}).Info("Draining connections")
+		case <-deadline.C:
+			remaining := s.connections.Load()
+			metrics.SshdDrainTimedOutConnectionsTotal.Add(float64(remaining))
+
+			log.WithContextFields(ctx, log.Fields{"in_flight_connections": remaining}).Warn("Timed out draining connections")
+
+			return remaining
+		}
+	}
 }
 
 // MonitoringServeMux returns the ServeMux for monitoring endpoints
@@ -101,32 +315,125 @@ func (s *Server) MonitoringServeMux() *http.ServeMux {
 		w.WriteHeader(http.StatusOK)
 	})
 
+	readiness, liveness := s.healthCheckHandlers()
+	if path := s.Config.Server.HealthChecks.ReadinessPath; path != "" {
+		mux.HandleFunc(path, readiness)
+	}
+	if path := s.Config.Server.HealthChecks.LivenessPath; path != "" {
+		mux.HandleFunc(path, liveness)
+	}
+
+	if path := s.Config.AccessCache.InvalidationPath; path != "" {
+		mux.HandleFunc(path, s.accessCacheInvalidationHandler)
+	}
+
 	return mux
 }
 
 func (s *Server) listen(ctx context.Context) error {
-	sshListener, err := net.Listen("tcp", s.Config.Server.Listen)
+	inherited, err := inheritedListeners()
 	if err != nil {
-		return fmt.Errorf("failed to listen for connection: %w", err)
+		return err
+	}
+	if len(inherited) > 0 {
+		log.ContextLogger(ctx).Info("Using the sockets handed over by the previous process")
+	}
+	// The sockets handed over for addresses no longer configured
+	defer func() {
+		for address, listener := range inherited {
+			log.WithContextFields(ctx, log.Fields{"address": address}).Info("Closing a socket handed over for an address no longer listened on")
+			_ = listener.Close()
+		}
+	}()
+
+	listener, ok := inherited[s.Config.Server.Listen]
+	delete(inherited, s.Config.Server.Listen)
+	if !ok {
+		listener, err = s.systemdListener(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if listener == nil {
+		listener, err = net.Listen("tcp", s.Config.Server.Listen)
+		if err != nil {
+			return fmt.Errorf("failed to listen for connection: %w", err)
+		}
+	}
+
+	if err := s.addListener(ctx, listener, config.ListenerConfig{
+		Listen:        s.Config.Server.Listen,
+		ProxyProtocol: s.Config.Server.ProxyProtocol,
+		ProxyPolicy:   s.Config.Server.ProxyPolicy,
+		ProxyAllowed:  s.Config.Server.ProxyAllowed,
+	}); err != nil {
+		return err
 	}
 
-	if s.Config.Server.ProxyProtocol {
-		policy, err := s.proxyPolicy()
+	for _, cfg := range s.Config.Server.Listeners {
+		listener, ok := inherited[cfg.Listen]
+		delete(inherited, cfg.Listen)
+
+		err = nil
+		if cfg.Listen == "" {
+			err = errors.New("no address")
+		} else if !ok {
+			listener, err = net.Listen("tcp", cfg.Listen)
+		}
+		if err == nil {
+			err = s.addListener(ctx, listener, cfg)
+		}
+
 		if err != nil {
+			_ = s.closeListeners()
+			s.listeners = nil
+
+			return fmt.Errorf("failed to listen on %s: %w", cfg.Listen, err)
+		}
+	}
+
+	return nil
+}
+
+// addListener accepts the connections of listener with the TCP keepalive
+// settings of the server, and the PROXY protocol settings and connection
+// limits of cfg
+func (s *Server) addListener(ctx context.Context, listener net.Listener, cfg config.ListenerConfig) error {
+	socket := listener
+	if keepAlive := s.Config.Server.TCPKeepAlive; keepAlive != (config.TCPKeepAliveConfig{}) {
+		listener = &tcpKeepAliveListener{Listener: listener, cfg: keepAlive}
+	}
+
+	l := &sshListener{
+		Listener:      listener,
+		address:       cfg.Listen,
+		socket:        socket,
+		proxyProtocol: cfg.ProxyProtocol,
+		limiter:       s.limiter,
+	}
+	if cfg.ConnectionLimits != nil {
+		l.limiter = newConnectionLimiter(*cfg.ConnectionLimits)
+	}
+
+	if cfg.ProxyProtocol {
+		policy, err := proxyPolicy(cfg)
+		if err != nil {
+			_ = listener.Close()
 			return fmt.Errorf("invalid policy configuration: %w", err)
 		}
 
-		sshListener = &proxyproto.Listener{
-			Listener:          sshListener,
+		l.Listener = &proxyproto.Listener{
+			Listener:          listener,
 			Policy:            policy,
 			ReadHeaderTimeout: time.Duration(s.Config.Server.ProxyHeaderTimeout),
 		}
 
-		log.ContextLogger(ctx).Info("Proxy protocol is enabled")
+		log.WithContextFields(ctx, log.Fields{"tcp_address": listener.Addr().String()}).Info("Proxy protocol is enabled")
 	}
 
 	fields := log.Fields{
-		"tcp_address": sshListener.Addr().String(),
+		"tcp_address": listener.Addr().String(),
 	}
 
 	if len(s.serverConfig.cfg.Server.PublicKeyAlgorithms) > 0 {
@@ -135,32 +442,77 @@ func (s *Server) listen(ctx context.Context) error {
 
 	log.WithContextFields(ctx, fields).Info("Listening for SSH connections")
 
-	s.listener = sshListener
+	s.listeners = append(s.listeners, l)
 
 	return nil
 }
 
+// systemdListener returns the socket passed by systemd socket activation, if
+// any, in place of listening on the configured address: the socket named
+// SystemdSocketName, or the only one passed
+func (s *Server) systemdListener(ctx context.Context) (net.Listener, error) {
+	listeners, err := systemd.Listeners()
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := systemd.Select(listeners, SystemdSocketName)
+	if err != nil {
+		return nil, err
+	}
+
+	if listener != nil {
+		log.ContextLogger(ctx).Info("Using the socket passed by systemd")
+	}
+
+	return listener, nil
+}
+
 func (s *Server) serve(ctx context.Context) {
 	s.changeStatus(StatusReady)
+	notifySystemd(ctx, systemd.Ready)
+	notifyUpgraded(ctx)
+
+	var accepting sync.WaitGroup
+	for _, l := range s.listeners {
+		accepting.Add(1)
+		go func(l *sshListener) {
+			defer accepting.Done()
+			s.accept(ctx, l)
+		}(l)
+	}
+	accepting.Wait()
+
+	s.wg.Wait()
+
+	s.changeStatus(StatusClosed)
+	if s.closed != nil {
+		close(s.closed)
+	}
+}
 
+// accept handles the connections of l until the server shuts down
+func (s *Server) accept(ctx context.Context, l *sshListener) {
 	for {
-		nconn, err := s.listener.Accept()
+		nconn, err := l.Accept()
 		if err != nil {
 			if s.getStatus() == StatusOnShutdown {
-				break
+				return
 			}
 
-			log.ContextLogger(ctx).WithError(err).Warn("Failed to accept connection")
+			log.WithContextFields(ctx, log.Fields{"tcp_address": l.Addr().String()}).WithError(err).Warn("Failed to accept connection")
 			continue
 		}
 
 		s.wg.Add(1)
-		go s.handleConn(ctx, nconn)
+		go s.handleConn(ctx, nconn, l)
 	}
+}
 
-	s.wg.Wait()
-
-	s.changeStatus(StatusClosed)
+func notifySystemd(ctx context.Context, state string) {
+	if _, err := systemd.Notify(state); err != nil {
+		log.WithContextFields(ctx, log.Fields{"state": state}).WithError(err).Warn("Failed to notify systemd")
+	}
 }
 
 func (s *Server) changeStatus(st status) {
@@ -177,7 +529,7 @@ func (s *Server) getStatus() status {
 }
 
 func contextWithValues(parent context.Context, nconn net.Conn) context.Context {
-	ctx := correlation.ContextWithCorrelation(parent, correlation.SafeRandomID())
+	ctx := gitlabnet.ContextWithCorrelationID(parent)
 
 	// If we're dealing with a PROXY connection, register the original requester's IP
 	mconn, ok := nconn.(*proxyproto.Conn)
@@ -189,22 +541,55 @@ func contextWithValues(parent context.Context, nconn net.Conn) context.Context {
 	return ctx
 }
 
-func (s *Server) handleConn(ctx context.Context, nconn net.Conn) {
+func (s *Server) handleConn(ctx context.Context, nconn net.Conn, l *sshListener) {
 	defer s.wg.Done()
 
+	stages := newStageTimer(time.Now())
+
+	s.connections.Add(1)
+	defer s.connections.Add(-1)
+
 	metrics.SshdConnectionsInFlight.Inc()
 	defer metrics.SshdConnectionsInFlight.Dec()
 
-	ctx, cancel := context.WithCancel(contextWithValues(ctx, nconn))
+	ctx, cancel := context.WithCancel(commandlimiter.NewContext(contextWithValues(ctx, nconn), s.commands))
 	defer cancel()
-	go func() {
+	go func(ctx context.Context) {
 		<-ctx.Done()
 		_ = nconn.Close() // Close the connection when context is canceled
-	}()
+	}(ctx)
 
 	remoteAddr := nconn.RemoteAddr().String()
+
+	logger.AddContextFields(ctx, log.Fields{"remote_ip": gitlabnet.ParseIP(remoteAddr)})
+	defer logger.RemoveContextFields(ctx)
+
+	ctx, span := telemetry.StartConnectionSpan(ctx, remoteAddr)
+	defer span.End()
+
 	ctxlog := log.WithContextFields(ctx, log.Fields{"remote_addr": remoteAddr})
 
+	cfg, serverConfig := s.listenerConfig(l.address)
+	ctx = client.WithRetryBudget(ctx, cfg.NewRetryBudget())
+
+	if err := serverConfig.ipFilter.check(gitlabnet.ParseIP(remoteAddr)); err != nil {
+		ctxlog.WithError(err).Info("server: handleConn: connection refused")
+		return
+	}
+
+	// The tarpit holds connections whatever the limits, which it has its own
+	if ip := gitlabnet.ParseIP(remoteAddr); s.tarpit.trapped(ip) {
+		s.tarpit.hold(ctx, nconn, ip, func() bool { return s.getStatus() == StatusReady })
+		return
+	}
+
+	slot, err := l.limiter.admit(gitlabnet.ParseIP(remoteAddr))
+	if err != nil {
+		ctxlog.WithError(err).Info("server: handleConn: connection refused")
+		return
+	}
+	defer slot.release()
+
 	// Prevent a panic in a single connection from taking out the whole server
 	defer func() {
 		if err := recover(); err != nil {
@@ -215,45 +600,127 @@ func (s *Server) handleConn(ctx context.Context, nconn net.Conn) {
 	}()
 
 	started := time.Now()
-	conn := newConnection(s.Config, nconn)
-
-	var ctxWithLogData context.Context
+	conn := newConnection(cfg, nconn)
+	conn.slot = slot
+	conn.tarpit = s.tarpit
+	conn.hostKeys = serverConfig.hostKeys
+	conn.trustedGateway = serverConfig.isTrustedGateway(remoteAddr)
+	conn.stages = stages
+
+	var sessions sessionsLogData
+
+	conn.handle(ctx, serverConfig.get(ctx), func(ctx context.Context, sconn *ssh.ServerConn, channel ssh.Channel, requests <-chan *ssh.Request) error {
+		logger.AddContextFields(ctx, log.Fields{
+			"key_id":   sconn.Permissions.Extensions["key-id"],
+			"username": sconn.Permissions.Extensions["username"],
+		})
+
+		clientAddr := conn.clientRemoteAddr()
+		if clientAddr != remoteAddr {
+			logger.AddContextFields(ctx, log.Fields{
+				"remote_ip":  gitlabnet.ParseIP(clientAddr),
+				"gateway_ip": gitlabnet.ParseIP(remoteAddr),
+			})
+		}
 
-	conn.handle(ctx, s.serverConfig.get(ctx), func(ctx context.Context, sconn *ssh.ServerConn, channel ssh.Channel, requests <-chan *ssh.Request) error {
 		session := &session{
-			cfg:                 s.Config,
+			cfg:                 cfg,
 			channel:             channel,
 			gitlabKeyID:         sconn.Permissions.Extensions["key-id"],
 			gitlabKrb5Principal: sconn.Permissions.Extensions["krb5principal"],
 			gitlabUsername:      sconn.Permissions.Extensions["username"],
 			namespace:           sconn.Permissions.Extensions["namespace"],
-			remoteAddr:          remoteAddr,
+			readOnly:            sconn.Permissions.Extensions[fallbackExtension] != "",
+			maintenance:         s.maintenance.stateFunc(cfg, serverConfig.maintenanceClient),
+			remoteAddr:          clientAddr,
+			auditPipe:           s.auditPipe,
+			auditLog:            s.auditLog,
+			webhooks:            s.webhooks,
+			bandwidth:           s.bandwidth,
 			started:             time.Now(),
 		}
+		session.stages = newStageTimer(session.started)
+
+		ctxWithLogData, err := session.handle(ctx, requests)
+		logData := extractLogDataFromContext(ctxWithLogData)
+		sessions.add(logData)
 
-		var err error
-		ctxWithLogData, err = session.handle(ctx, requests)
+		log.WithContextFields(ctx, log.Fields{
+			"session":           sessionNumber(ctx),
+			"duration_s":        time.Since(session.started).Seconds(),
+			"written_bytes":     logData.WrittenBytes,
+			"meta":              logData.Meta,
+			"stage_durations_s": session.stages.logFields(),
+		}).Info("access: session finish")
 
 		return err
 	})
 
-	logData := extractLogDataFromContext(ctxWithLogData)
+	logData, count := sessions.total()
 
 	ctxlog.WithFields(log.Fields{
-		"duration_s":    time.Since(started).Seconds(),
-		"written_bytes": logData.WrittenBytes,
-		"meta":          logData.Meta,
+		"duration_s":        time.Since(started).Seconds(),
+		"written_bytes":     logData.WrittenBytes,
+		"meta":              logData.Meta,
+		"sessions":          count,
+		"stage_durations_s": stages.logFields(),
 	}).Info("access: finish")
 }
 
-func (s *Server) proxyPolicy() (proxyproto.PolicyFunc, error) {
-	if len(s.Config.Server.ProxyAllowed) > 0 {
-		return proxyproto.StrictWhiteListPolicy(s.Config.Server.ProxyAllowed)
+// sessionsLogData sums up the log data of the sessions of a connection,
+// which may run at once
+type sessionsLogData struct {
+	mu       sync.Mutex
+	logData  command.LogData
+	sessions int
+}
+
+func (d *sessionsLogData) add(logData command.LogData) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sessions++
+	d.logData.WrittenBytes += logData.WrittenBytes
+	// The metadata is that of the last session that accessed a project
+	if logData.Meta != (command.LogMetadata{}) {
+		d.logData.Meta = logData.Meta
+	}
+	if logData.Username != "" {
+		d.logData.Username = logData.Username
+	}
+}
+
+func (d *sessionsLogData) total() (command.LogData, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.logData, d.sessions
+}
+
+// newWebhookNotifier returns the notifier of the session webhooks of cfg
+func newWebhookNotifier(cfg config.SessionWebhooksConfig) (*webhook.Notifier, error) {
+	opts := webhook.Options{MaxAttempts: cfg.MaxAttempts, Timeout: time.Duration(cfg.Timeout)}
+
+	if cfg.SecretFile != "" {
+		secret, err := os.ReadFile(filepath.Clean(cfg.SecretFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the session webhooks secret: %w", err)
+		}
+
+		opts.Secret = bytes.TrimSpace(secret)
+	}
+
+	return webhook.New(cfg.URLs, opts), nil
+}
+
+func proxyPolicy(cfg config.ListenerConfig) (proxyproto.PolicyFunc, error) {
+	if len(cfg.ProxyAllowed) > 0 {
+		return proxyproto.StrictWhiteListPolicy(cfg.ProxyAllowed)
 	}
 
 	// Set the Policy value based on config
 	// Values are taken from https://github.com/pires/go-proxyproto/blob/195fedcfbfc1be163f3a0d507fac1709e9d81fed/policy.go#L20
-	switch strings.ToLower(s.Config.Server.ProxyPolicy) {
+	switch strings.ToLower(cfg.ProxyPolicy) {
 	case "require":
 		return staticProxyPolicy(proxyproto.REQUIRE), nil
 	case "ignore":
@@ -1,24 +1,38 @@
 package sshd
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/pires/go-proxyproto"
+	"github.com/pkg/sftp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/ssh"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/auditlog"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/systemd"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/testhelper"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/webhook"
 )
 
 const (
@@ -67,6 +81,8 @@ func TestListenAndServe_proxyProtocolEnabled(t *testing.T) {
 		},
 		DestinationAddr: target,
 	}
+	headerV1 := *header
+	headerV1.Version = 1
 	xForwardedFor = "127.0.0.1"
 	defer func() {
 		xForwardedFor = "" // Cleanup for other test cases
@@ -91,6 +107,12 @@ func TestListenAndServe_proxyProtocolEnabled(t *testing.T) {
 			header:      header,
 			isRejected:  false,
 		},
+		{
+			desc:        "USE (default) with a v1 header",
+			proxyPolicy: "",
+			header:      &headerV1,
+			isRejected:  false,
+		},
 		{
 			desc:        "REQUIRE without a header",
 			proxyPolicy: "require",
@@ -169,6 +191,18 @@ func TestListenAndServe_proxyProtocolEnabled(t *testing.T) {
 			header:       header,
 			isRejected:   true,
 		},
+		{
+			desc:         "Allow-listed range with a v1 header",
+			proxyAllowed: []string{"127.0.0.0/24"},
+			header:       &headerV1,
+			isRejected:   false,
+		},
+		{
+			desc:         "Not allow-listed range with a v1 header",
+			proxyAllowed: []string{"192.168.1.0/24"},
+			header:       &headerV1,
+			isRejected:   true,
+		},
 		{
 			desc:         "Not allow-listed range without a header",
 			proxyAllowed: []string{"192.168.1.0/24"},
@@ -191,7 +225,7 @@ func TestListenAndServe_proxyProtocolEnabled(t *testing.T) {
 			require.NoError(t, err)
 
 			if tc.header != nil {
-				_, writeToErr := header.WriteTo(conn)
+				_, writeToErr := tc.header.WriteTo(conn)
 				require.NoError(t, writeToErr)
 			}
 
@@ -214,6 +248,28 @@ func TestListenAndServe_proxyProtocolEnabled(t *testing.T) {
 	}
 }
 
+func TestHandshakeDurationMetric(t *testing.T) {
+	_, testRoot := setupServer(t)
+
+	initialCount := handshakeCount(t)
+
+	client, err := ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+	require.NoError(t, err)
+	defer client.Close()
+
+	// The server records the handshake once it has processed the client's authentication
+	require.Eventually(t, func() bool {
+		return handshakeCount(t) == initialCount+1
+	}, time.Second, time.Millisecond)
+}
+
+func handshakeCount(t *testing.T) uint64 {
+	var m dto.Metric
+	require.NoError(t, metrics.SshdHandshakeDuration.Write(&m))
+
+	return m.GetHistogram().GetSampleCount()
+}
+
 func TestCorrelationId(t *testing.T) {
 	_, testRoot := setupServer(t)
 
@@ -279,6 +335,265 @@ func TestLivenessProbe(t *testing.T) {
 	res.Body.Close()
 }
 
+func TestHealthChecks(t *testing.T) {
+	cfg := &config.Config{Server: config.DefaultServerConfig}
+	cfg.Server.HealthChecks.Interval = 0
+	s, _ := setupServerWithConfig(t, cfg)
+
+	probe := func(path string) (int, string) {
+		r := httptest.NewRecorder()
+		s.MonitoringServeMux().ServeHTTP(r, httptest.NewRequest("GET", path, nil))
+		res := r.Result()
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+
+		return res.StatusCode, strings.TrimSpace(string(body))
+	}
+
+	status, body := probe("/readiness")
+	require.Equal(t, http.StatusOK, status, body)
+	status, _ = probe("/liveness")
+	require.Equal(t, http.StatusOK, status)
+
+	// An API that can't be reached only makes the server unready
+	unreachable := &config.Config{GitlabUrl: "http+unix:///nonexistent", User: cfg.User, RootDir: cfg.RootDir, Server: cfg.Server}
+	require.NoError(t, s.Reload(unreachable))
+
+	status, body = probe("/readiness")
+	require.Equal(t, http.StatusServiceUnavailable, status)
+	require.Contains(t, body, "internal API")
+	status, _ = probe("/liveness")
+	require.Equal(t, http.StatusOK, status)
+
+	// Draining isn't a failure that should restart the server
+	require.NoError(t, s.Shutdown())
+	verifyStatus(t, s, StatusClosed)
+
+	status, body = probe("/readiness")
+	require.Equal(t, http.StatusServiceUnavailable, status)
+	require.Equal(t, errNotServing.Error(), body)
+	status, _ = probe("/liveness")
+	require.Equal(t, http.StatusOK, status)
+}
+
+func TestAccessCacheInvalidation(t *testing.T) {
+	s := &Server{Config: &config.Config{
+		Secret:          "secret\n",
+		PreviousSecrets: []string{"previous\n"},
+		Server:          config.DefaultServerConfig,
+		AccessCache:     config.AccessCacheConfig{TTL: config.YamlDuration(time.Minute), InvalidationPath: "/access_cache/invalidate"},
+	}}
+	mux := s.MonitoringServeMux()
+
+	sign := func(secret string, expires time.Time) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+			Issuer:    "gitlab",
+			ExpiresAt: jwt.NewNumericDate(expires),
+		}).SignedString([]byte(secret))
+		require.NoError(t, err)
+
+		return token
+	}
+
+	invalidate := func(method, token, body string) (int, string) {
+		req := httptest.NewRequest(method, "/access_cache/invalidate", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Gitlab-Shell-Api-Request", token)
+		}
+
+		r := httptest.NewRecorder()
+		mux.ServeHTTP(r, req)
+		res := r.Result()
+		defer res.Body.Close()
+
+		data, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+
+		return res.StatusCode, strings.TrimSpace(string(data))
+	}
+
+	valid := sign("secret", time.Now().Add(time.Minute))
+
+	testCases := []struct {
+		desc           string
+		method         string
+		token          string
+		body           string
+		expectedStatus int
+	}{
+		{desc: "valid", method: http.MethodPost, token: valid, body: `{"gl_id": "user-1"}`, expectedStatus: http.StatusOK},
+		{desc: "everything", method: http.MethodPost, token: valid, body: `{}`, expectedStatus: http.StatusOK},
+		{desc: "previous secret", method: http.MethodPost, token: sign("previous", time.Now().Add(time.Minute)), body: `{}`, expectedStatus: http.StatusOK},
+		{desc: "wrong method", method: http.MethodGet, token: valid, expectedStatus: http.StatusMethodNotAllowed},
+		{desc: "no token", method: http.MethodPost, body: `{}`, expectedStatus: http.StatusUnauthorized},
+		{desc: "wrong secret", method: http.MethodPost, token: sign("other", time.Now().Add(time.Minute)), body: `{}`, expectedStatus: http.StatusUnauthorized},
+		{desc: "expired token", method: http.MethodPost, token: sign("secret", time.Now().Add(-time.Minute)), body: `{}`, expectedStatus: http.StatusUnauthorized},
+		{desc: "invalid body", method: http.MethodPost, token: valid, body: `{"project_id": "one"}`, expectedStatus: http.StatusBadRequest},
+	}
+
+	before := testutil.ToFloat64(metrics.AccessCacheInvalidationsTotal)
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			status, body := invalidate(tc.method, tc.token, tc.body)
+			require.Equal(t, tc.expectedStatus, status, body)
+
+			if status == http.StatusOK {
+				require.Equal(t, `{"invalidated":0}`, body)
+			}
+		})
+	}
+
+	require.InDelta(t, before+3, testutil.ToFloat64(metrics.AccessCacheInvalidationsTotal), 0.1)
+}
+
+func TestDebugEndpoints(t *testing.T) {
+	s := &Server{Config: &config.Config{GitlabUrl: "http://localhost", Secret: "sssh, it's a secret"}}
+	mux := s.DebugServeMux()
+
+	get := func(path string) string {
+		r := httptest.NewRecorder()
+		mux.ServeHTTP(r, httptest.NewRequest("GET", path, nil))
+		require.Equal(t, http.StatusOK, r.Code)
+
+		return r.Body.String()
+	}
+
+	require.Contains(t, get("/debug/goroutines"), "goroutine ")
+	require.Contains(t, get("/debug/pprof/"), "goroutine")
+
+	dump := get("/debug/config")
+	require.Contains(t, dump, "gitlab_url: http://localhost")
+	require.NotContains(t, dump, "sssh")
+
+	r := httptest.NewRecorder()
+	mux.ServeHTTP(r, httptest.NewRequest("POST", "/debug/log_level?component=debug_test&level=error", nil))
+	require.Equal(t, http.StatusOK, r.Code)
+	require.Contains(t, r.Body.String(), `"debug_test":"error"`)
+	require.Contains(t, get("/debug/log_level"), `"debug_test":"error"`)
+
+	r = httptest.NewRecorder()
+	mux.ServeHTTP(r, httptest.NewRequest("POST", "/debug/log_level?level=loud", nil))
+	require.Equal(t, http.StatusBadRequest, r.Code)
+}
+
+func TestDebugListenMustBeLoopback(t *testing.T) {
+	s := &Server{Config: &config.Config{Server: config.ServerConfig{DebugListen: "0.0.0.0:0"}}}
+	require.ErrorIs(t, s.ServeDebug(context.Background()), ErrDebugListenNotLoopback)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Config.Server.DebugListen = "127.0.0.1:0"
+
+	done := make(chan error)
+	go func() { done <- s.ServeDebug(ctx) }()
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestSystemdNotifications(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	s, _ := setupServer(t)
+
+	next := func() string {
+		buf := make([]byte, 64)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+
+		return string(buf[:n])
+	}
+
+	require.Equal(t, systemd.Ready, next())
+
+	require.NoError(t, s.Reload(reloadedConfig(s, s.Config.Server.HostKeyFiles...)))
+	require.Equal(t, systemd.Reloading, next())
+	require.Equal(t, systemd.Ready, next())
+
+	require.NoError(t, s.Shutdown())
+	require.Equal(t, systemd.Stopping, next())
+}
+
+func TestLoginBanner(t *testing.T) {
+	cfg := &config.Config{MOTD: config.MOTDConfig{Banner: "{{.Instance}} is down for maintenance on Sunday", InstanceName: "GitLab Europe"}}
+	_, testRoot := setupServerWithConfig(t, cfg)
+
+	var banner string
+	clientCfg := clientConfig(t, testRoot)
+	clientCfg.BannerCallback = func(message string) error {
+		banner = message
+		return nil
+	}
+
+	client, err := ssh.Dial("tcp", serverURL, clientCfg)
+	require.NoError(t, err)
+	defer client.Close()
+
+	require.Equal(t, "GitLab Europe is down for maintenance on Sunday\n", banner)
+}
+
+func TestIPFilter(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		filter   config.IPFilterConfig
+		accepted bool
+	}{
+		{desc: "allowed", filter: config.IPFilterConfig{Allow: []string{"127.0.0.0/8"}}, accepted: true},
+		{desc: "denied", filter: config.IPFilterConfig{Deny: []string{"127.0.0.1"}}},
+		{desc: "not allowed", filter: config.IPFilterConfig{Allow: []string{"192.0.2.0/24"}}},
+		{desc: "denied despite being allowed", filter: config.IPFilterConfig{Allow: []string{"127.0.0.0/8"}, Deny: []string{"127.0.0.1"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cfg := &config.Config{Server: config.ServerConfig{IPFilter: tc.filter}}
+			_, testRoot := setupServerWithConfig(t, cfg)
+
+			client, err := ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+			if !tc.accepted {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			client.Close()
+		})
+	}
+}
+
+func TestInvalidIPFilter(t *testing.T) {
+	cfg := &config.Config{GitlabUrl: "http://localhost", Server: config.ServerConfig{IPFilter: config.IPFilterConfig{Deny: []string{"not an IP"}}}}
+	cfg.Server.HostKeyFiles = []string{path.Join(testhelper.PrepareTestRootDir(t), "certs/valid/server.key")}
+
+	_, err := NewServer(cfg)
+	require.ErrorContains(t, err, "invalid IP filter")
+}
+
+func TestCachedCheck(t *testing.T) {
+	calls := 0
+	check := &cachedCheck{
+		interval: time.Hour,
+		check: func(context.Context) error {
+			calls++
+			return fmt.Errorf("failure %d", calls)
+		},
+	}
+
+	require.EqualError(t, check.result(context.Background()), "failure 1")
+	require.EqualError(t, check.result(context.Background()), "failure 1")
+	require.Equal(t, 1, calls)
+
+	check.checkedAt = time.Now().Add(-time.Hour)
+	require.EqualError(t, check.result(context.Background()), "failure 2")
+}
+
 func TestInvalidClientConfig(t *testing.T) {
 	_, testRoot := setupServer(t)
 
@@ -359,6 +674,784 @@ func TestLoginGraceTime(t *testing.T) {
 	verifyStatus(t, s, StatusClosed)
 }
 
+func TestDrain(t *testing.T) {
+	s, testRoot := setupServer(t)
+
+	client, err := ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+	require.NoError(t, err)
+	defer client.Close()
+
+	remaining := make(chan int64)
+	go func() { remaining <- s.Drain(context.Background(), 10*time.Second) }()
+
+	verifyStatus(t, s, StatusOnShutdown)
+
+	// In-flight connections keep being served while new ones are refused
+	holdSession(t, client)
+
+	_, err = ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+	require.Error(t, err)
+
+	client.Close()
+
+	require.Equal(t, int64(0), <-remaining)
+	verifyStatus(t, s, StatusClosed)
+}
+
+func TestDrainTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s, testRoot := setupServerWithContext(ctx, t, nil)
+
+	client, err := ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+	require.NoError(t, err)
+	defer client.Close()
+
+	before := testutil.ToFloat64(metrics.SshdDrainTimedOutConnectionsTotal)
+
+	require.Equal(t, int64(1), s.Drain(ctx, 50*time.Millisecond))
+	require.InDelta(t, before+1, testutil.ToFloat64(metrics.SshdDrainTimedOutConnectionsTotal), 0.1)
+	require.Equal(t, StatusOnShutdown, s.getStatus())
+
+	cancel()
+	verifyStatus(t, s, StatusClosed)
+}
+
+func reloadedConfig(s *Server, hostKeyFiles ...string) *config.Config {
+	cfg := &config.Config{
+		GitlabUrl: s.Config.GitlabUrl,
+		RootDir:   s.Config.RootDir,
+		User:      s.Config.User,
+		Server:    s.Config.Server,
+	}
+	cfg.Server.HostKeyFiles = hostKeyFiles
+
+	return cfg
+}
+
+func TestReload(t *testing.T) {
+	s, testRoot := setupServer(t)
+
+	established, err := ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+	require.NoError(t, err)
+	defer established.Close()
+
+	require.NoError(t, s.Reload(reloadedConfig(s, path.Join(testRoot, "certs/valid/server2.key"))))
+
+	// Established connections aren't dropped
+	holdSession(t, established)
+
+	_, err = ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+	require.ErrorContains(t, err, "host key mismatch")
+
+	keyRaw, err := os.ReadFile(path.Join(testRoot, "certs/valid/server2.pub"))
+	require.NoError(t, err)
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey(keyRaw) //nolint:dogsled
+	require.NoError(t, err)
+
+	clientCfg := clientConfig(t, testRoot)
+	clientCfg.HostKeyCallback = ssh.FixedHostKey(hostKey)
+
+	client, err := ssh.Dial("tcp", serverURL, clientCfg)
+	require.NoError(t, err)
+	defer client.Close()
+
+	holdSession(t, client)
+}
+
+func TestHostKeyUpdate(t *testing.T) {
+	s, testRoot := setupServer(t)
+
+	// The first key of a type is used for the key exchange, the next one is
+	// only announced
+	require.NoError(t, s.Reload(reloadedConfig(s,
+		path.Join(testRoot, "certs/valid/server.key"),
+		path.Join(testRoot, "certs/valid/server2.key"),
+	)))
+
+	var hostKeys []ssh.PublicKey
+	for _, name := range []string{"server_authorized_key", "server2.pub"} {
+		keyRaw, err := os.ReadFile(path.Join(testRoot, "certs/valid", name))
+		require.NoError(t, err)
+		hostKey, _, _, _, err := ssh.ParseAuthorizedKey(keyRaw) //nolint:dogsled
+		require.NoError(t, err)
+		hostKeys = append(hostKeys, hostKey)
+	}
+
+	conn, err := net.Dial("tcp", serverURL)
+	require.NoError(t, err)
+
+	sshConn, _, reqs, err := ssh.NewClientConn(conn, serverURL, clientConfig(t, testRoot))
+	require.NoError(t, err)
+	defer sshConn.Close()
+
+	var announcement *ssh.Request
+	select {
+	case announcement = <-reqs:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no host keys announced")
+	}
+	require.Equal(t, HostKeysMsg, announcement.Type)
+
+	announced, err := parseStrings(announcement.Payload)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{hostKeys[0].Marshal(), hostKeys[1].Marshal()}, announced)
+
+	ok, proof, err := sshConn.SendRequest(HostKeysProveMsg, true, announcement.Payload)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	signatures, err := parseStrings(proof)
+	require.NoError(t, err)
+	require.Len(t, signatures, 2)
+
+	for i, hostKey := range hostKeys {
+		var data []byte
+		data = appendString(data, []byte(HostKeysProveMsg))
+		data = appendString(data, sshConn.SessionID())
+		data = appendString(data, hostKey.Marshal())
+
+		signature := &ssh.Signature{}
+		require.NoError(t, ssh.Unmarshal(signatures[i], signature))
+		require.Equal(t, ssh.KeyAlgoRSASHA512, signature.Format)
+		require.NoError(t, hostKey.Verify(data, signature))
+	}
+
+	// Keys the server doesn't hold can't be proven
+	clientKey, err := os.ReadFile(path.Join(testRoot, "certs/client/key.pem"))
+	require.NoError(t, err)
+	signer, err := ssh.ParsePrivateKey(clientKey)
+	require.NoError(t, err)
+
+	ok, _, err = sshConn.SendRequest(HostKeysProveMsg, true, appendString(nil, signer.PublicKey().Marshal()))
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ok, _, err = sshConn.SendRequest("unknown@example.com", true, nil)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestReloadKeepsConfigOnFailure(t *testing.T) {
+	s, testRoot := setupServer(t)
+
+	require.ErrorContains(t, s.Reload(reloadedConfig(s, "/does/not/exist")), "no host keys could be loaded")
+
+	client, err := ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+	require.NoError(t, err)
+	defer client.Close()
+
+	holdSession(t, client)
+}
+
+func TestConnectionRatePerIP(t *testing.T) {
+	cfg := &config.Config{
+		Server: config.ServerConfig{
+			ConnectionLimits: config.ConnectionLimitsConfig{RatePerIP: 0.001},
+		},
+	}
+	_, testRoot := setupServerWithConfig(t, cfg)
+
+	client, err := ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+	require.NoError(t, err)
+	client.Close()
+
+	_, err = ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+	require.Error(t, err, "the second connection exceeds the rate limit")
+}
+
+func TestTarpit(t *testing.T) {
+	cfg := &config.Config{
+		Server: config.ServerConfig{
+			Tarpit: config.TarpitConfig{
+				MaxFailures: 1,
+				Window:      config.YamlDuration(time.Minute),
+				Duration:    config.YamlDuration(time.Hour),
+				Interval:    config.YamlDuration(10 * time.Millisecond),
+				MaxHold:     config.YamlDuration(time.Minute),
+			},
+		},
+	}
+	s, testRoot := setupServerWithConfig(t, cfg)
+
+	// Clients that only read the version of the server aren't failures
+	conn, err := net.Dial("tcp", serverURL)
+	require.NoError(t, err)
+	banner, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(banner, "SSH-2.0-"))
+	conn.Close()
+	require.False(t, s.tarpit.trapped("127.0.0.1"))
+
+	guessing := clientConfig(t, testRoot)
+	guessing.Auth = []ssh.AuthMethod{ssh.Password("password")}
+	_, err = ssh.Dial("tcp", serverURL, guessing)
+	require.Error(t, err)
+
+	// The server learns of the failure after the client
+	require.Eventually(t, func() bool { return s.tarpit.trapped("127.0.0.1") }, 5*time.Second, time.Millisecond)
+
+	conn, err = net.Dial("tcp", serverURL)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	require.False(t, strings.HasPrefix(line, "SSH-"), "the connection is held in the tarpit")
+}
+
+func TestMaxAuthenticatedConnections(t *testing.T) {
+	cfg := &config.Config{
+		Server: config.ServerConfig{
+			ConnectionLimits: config.ConnectionLimitsConfig{MaxAuthenticated: 1},
+		},
+	}
+	_, testRoot := setupServerWithConfig(t, cfg)
+
+	client, err := ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+	require.NoError(t, err)
+	defer client.Close()
+
+	// The handshake completes before the limit is checked, so the refusal
+	// only surfaces once the connection is used
+	other, err := ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+	require.NoError(t, err)
+	defer other.Close()
+
+	_, err = other.NewSession()
+	require.Error(t, err)
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	session.Close()
+}
+
+func TestListeners(t *testing.T) {
+	const otherURL = "127.0.0.1:50001"
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{
+			Listeners: []config.ListenerConfig{{
+				Listen:           otherURL,
+				ProxyProtocol:    true,
+				ProxyPolicy:      "require",
+				ConnectionLimits: &config.ConnectionLimitsConfig{RatePerIP: 0.001},
+			}},
+		},
+	}
+	s, testRoot := setupServerWithConfig(t, cfg)
+	require.Len(t, s.listeners, 2)
+
+	client, err := ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+	require.NoError(t, err)
+	client.Close()
+
+	_, err = ssh.Dial("tcp", otherURL, clientConfig(t, testRoot))
+	require.Error(t, err, "the other listener requires a PROXY header")
+
+	xForwardedFor = "127.0.0.1"
+	defer func() {
+		xForwardedFor = ""
+	}()
+
+	dialProxied := func() (*ssh.Client, error) {
+		conn, err := net.Dial("tcp", otherURL)
+		require.NoError(t, err)
+
+		header := proxyproto.HeaderProxyFromAddrs(2, &net.TCPAddr{IP: net.ParseIP("10.1.1.1"), Port: 1000}, conn.RemoteAddr())
+		_, err = header.WriteTo(conn)
+		require.NoError(t, err)
+
+		sshConn, chans, reqs, err := ssh.NewClientConn(conn, otherURL, clientConfig(t, testRoot))
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return ssh.NewClient(sshConn, chans, reqs), nil
+	}
+
+	client, err = dialProxied()
+	require.NoError(t, err)
+	holdSession(t, client)
+	client.Close()
+
+	_, err = dialProxied()
+	require.Error(t, err, "the second connection exceeds the rate limit of the other listener")
+
+	// The limits of a listener don't apply to the others
+	xForwardedFor = ""
+	client, err = ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+	require.NoError(t, err)
+	client.Close()
+
+	require.NoError(t, s.Shutdown())
+	verifyStatus(t, s, StatusClosed)
+}
+
+func TestTenantListeners(t *testing.T) {
+	const tenantURL = "127.0.0.1:50001"
+
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/authorized_keys",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				fmt.Fprint(w, `{"id": 2000, "key": "key"}`)
+			},
+		}, {
+			Path: "/api/v4/internal/discover",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				fmt.Fprint(w, `{"id": 2000, "name": "Tenant User", "username": "tenant-user"}`)
+			},
+		},
+	}
+	tenantGitlabURL := testserver.StartSocketHttpServer(t, requests)
+
+	testRoot := testhelper.PrepareTestRootDir(t)
+	configDir := t.TempDir()
+	tenantConfig := fmt.Sprintf(`
+gitlab_url: %q
+secret: tenant-secret
+user: %s
+sshd:
+  host_key_files: [%q]
+motd:
+  banner: Welcome to the other instance
+`, tenantGitlabURL, user, path.Join(testRoot, "certs/valid/server2.key"))
+	require.NoError(t, os.WriteFile(path.Join(configDir, "config.yml"), []byte(tenantConfig), 0o600))
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{
+			Listeners: []config.ListenerConfig{{Listen: tenantURL, ConfigDir: configDir}},
+		},
+	}
+	s, _ := setupServerWithConfig(t, cfg)
+	require.Len(t, s.listeners, 2)
+
+	// The main listener serves the instance of the server
+	client, err := ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+	require.NoError(t, err)
+	holdSession(t, client)
+	client.Close()
+
+	_, err = ssh.Dial("tcp", tenantURL, clientConfig(t, testRoot))
+	require.ErrorContains(t, err, "host key mismatch")
+
+	keyRaw, err := os.ReadFile(path.Join(testRoot, "certs/valid/server2.pub"))
+	require.NoError(t, err)
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey(keyRaw) //nolint:dogsled
+	require.NoError(t, err)
+
+	var banner string
+	clientCfg := clientConfig(t, testRoot)
+	clientCfg.HostKeyCallback = ssh.FixedHostKey(hostKey)
+	clientCfg.BannerCallback = func(message string) error {
+		banner = message
+		return nil
+	}
+
+	client, err = ssh.Dial("tcp", tenantURL, clientCfg)
+	require.NoError(t, err)
+	defer client.Close()
+	require.Equal(t, "Welcome to the other instance\n", banner)
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+
+	output, err := session.Output("discover")
+	require.NoError(t, err)
+	require.Equal(t, "Welcome to GitLab, @tenant-user!\n", string(output))
+}
+
+func TestInvalidTenantConfig(t *testing.T) {
+	cfg := &config.Config{
+		GitlabUrl: "http://localhost",
+		User:      user,
+		RootDir:   "/tmp",
+		Server: config.ServerConfig{
+			Listen:       serverURL,
+			HostKeyFiles: []string{path.Join(testhelper.PrepareTestRootDir(t), "certs/valid/server.key")},
+			Listeners:    []config.ListenerConfig{{Listen: "127.0.0.1:50001", ConfigDir: t.TempDir()}},
+		},
+	}
+
+	_, err := NewServer(cfg)
+	require.ErrorContains(t, err, "failed to load the configuration of 127.0.0.1:50001")
+}
+
+func TestListenerAddressInUse(t *testing.T) {
+	cfg := &config.Config{
+		GitlabUrl: "http://localhost",
+		User:      user,
+		RootDir:   "/tmp",
+		Server: config.ServerConfig{
+			Listen:       serverURL,
+			HostKeyFiles: []string{path.Join(testhelper.PrepareTestRootDir(t), "certs/valid/server.key")},
+			Listeners:    []config.ListenerConfig{{Listen: serverURL}},
+		},
+	}
+
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+
+	err = s.ListenAndServe(context.Background())
+	require.ErrorContains(t, err, "failed to listen on "+serverURL)
+	require.Empty(t, s.listeners)
+
+	// The first listener was closed
+	l, err := net.Listen("tcp", serverURL)
+	require.NoError(t, err)
+	l.Close()
+}
+
+func TestSecurityKeyAuthentication(t *testing.T) {
+	_, testRoot := setupServer(t)
+
+	clientCfg := clientConfig(t, testRoot)
+	clientCfg.Auth = []ssh.AuthMethod{ssh.PublicKeys(newSKEd25519Signer(t))}
+
+	client, err := ssh.Dial("tcp", serverURL, clientCfg)
+	require.NoError(t, err)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	session.Close()
+}
+
+func TestAlgorithms(t *testing.T) {
+	cfg := &config.Config{
+		Server: config.ServerConfig{
+			Algorithms: config.AlgorithmsConfig{
+				KexAlgorithms:     []string{"curve25519-sha256"},
+				HostKeyAlgorithms: []string{"rsa-sha2-512"},
+			},
+		},
+	}
+	_, testRoot := setupServerWithConfig(t, cfg)
+
+	client, err := ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+	require.NoError(t, err)
+	client.Close()
+
+	clientCfg := clientConfig(t, testRoot)
+	clientCfg.KeyExchanges = []string{"ecdh-sha2-nistp256"}
+	_, err = ssh.Dial("tcp", serverURL, clientCfg)
+	require.ErrorContains(t, err, "no common algorithm for key exchange")
+
+	clientCfg = clientConfig(t, testRoot)
+	clientCfg.HostKeyAlgorithms = []string{"rsa-sha2-256"}
+	_, err = ssh.Dial("tcp", serverURL, clientCfg)
+	require.ErrorContains(t, err, "no common algorithm for host key")
+}
+
+func TestSFTPSubsystem(t *testing.T) {
+	cfg := &config.Config{Server: config.ServerConfig{SFTP: config.SFTPConfig{Enabled: true}}}
+	_, testRoot := setupServerWithConfig(t, cfg)
+
+	client, err := ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+	require.NoError(t, err)
+	defer client.Close()
+
+	sftpClient, err := sftp.NewClient(client)
+	require.NoError(t, err)
+	defer sftpClient.Close()
+
+	infos, err := sftpClient.ReadDir("/")
+	require.NoError(t, err)
+	require.Empty(t, infos)
+
+	_, err = sftpClient.Create("/group/project/-/refs/heads/main")
+	require.ErrorIs(t, err, os.ErrPermission)
+}
+
+func TestSessionTimeouts(t *testing.T) {
+	testCases := []struct {
+		desc            string
+		serverConfig    config.ServerConfig
+		keepActive      bool
+		expectedMessage string
+	}{
+		{
+			desc:            "an idle session",
+			serverConfig:    config.ServerConfig{SessionIdleTimeout: config.YamlDuration(100 * time.Millisecond)},
+			expectedMessage: "Session idle for longer than 100ms, closing the session",
+		},
+		{
+			desc:            "a session past its max duration",
+			serverConfig:    config.ServerConfig{MaxSessionDuration: config.YamlDuration(200 * time.Millisecond)},
+			expectedMessage: "Maximum session duration of 200ms exceeded, closing the session",
+		},
+		{
+			desc: "an active session past its max duration",
+			serverConfig: config.ServerConfig{
+				SessionIdleTimeout: config.YamlDuration(150 * time.Millisecond),
+				MaxSessionDuration: config.YamlDuration(400 * time.Millisecond),
+			},
+			keepActive:      true,
+			expectedMessage: "Maximum session duration of 400ms exceeded, closing the session",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, testRoot := setupServerWithConfig(t, &config.Config{Server: tc.serverConfig})
+
+			client, err := ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+			require.NoError(t, err)
+			defer client.Close()
+
+			session, err := client.NewSession()
+			require.NoError(t, err)
+			defer session.Close()
+
+			stdin, err := session.StdinPipe()
+			require.NoError(t, err)
+
+			stderr := &bytes.Buffer{}
+			session.Stderr = stderr
+
+			// The command waits for an answer on stdin
+			require.NoError(t, session.Start("2fa_recovery_codes"))
+
+			done := make(chan struct{})
+			defer close(done)
+			if tc.keepActive {
+				go func() {
+					ticker := time.NewTicker(30 * time.Millisecond)
+					defer ticker.Stop()
+
+					for {
+						select {
+						case <-done:
+							return
+						case <-ticker.C:
+							_, _ = stdin.Write([]byte(" "))
+						}
+					}
+				}()
+			}
+
+			var exitErr *ssh.ExitError
+			require.ErrorAs(t, session.Wait(), &exitErr)
+			require.Equal(t, timeoutExitStatus, exitErr.ExitStatus())
+			require.Contains(t, stderr.String(), tc.expectedMessage)
+		})
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	auditLogPath := filepath.Join(t.TempDir(), "audit.log")
+	cfg := &config.Config{Server: config.ServerConfig{AuditLog: "file:" + auditLogPath}}
+	_, testRoot := setupServerWithConfig(t, cfg)
+
+	client, err := ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+	require.NoError(t, err)
+	defer client.Close()
+
+	holdSession(t, client)
+
+	var record auditlog.Record
+	require.Eventually(t, func() bool {
+		content, err := os.ReadFile(auditLogPath)
+		return err == nil && json.Unmarshal(content, &record) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.Equal(t, uint64(1), record.Sequence)
+	require.Equal(t, "1000", record.KeyID)
+	require.Equal(t, "discover", record.Command)
+	require.Equal(t, "127.0.0.1", record.RemoteIP)
+	require.Equal(t, int64(len("Welcome to GitLab, @test-user!\n")), record.BytesOut)
+	require.Zero(t, record.BytesIn)
+	require.Equal(t, auditlog.ResultSuccess, record.Result)
+	require.Zero(t, record.ExitStatus)
+	require.NotEmpty(t, record.Hash)
+}
+
+func TestSessionWebhooks(t *testing.T) {
+	events := make(chan webhook.Event, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event webhook.Event
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		events <- event
+	}))
+	defer hook.Close()
+
+	cfg := &config.Config{Server: config.ServerConfig{SessionWebhooks: config.SessionWebhooksConfig{URLs: []string{hook.URL}}}}
+	_, testRoot := setupServerWithConfig(t, cfg)
+
+	client, err := ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+	require.NoError(t, err)
+	defer client.Close()
+
+	holdSession(t, client)
+
+	select {
+	case event := <-events:
+		require.Equal(t, "1000", event.KeyID)
+		require.Equal(t, "discover", event.Command)
+		require.Equal(t, "127.0.0.1", event.RemoteIP)
+		require.Equal(t, auditlog.ResultSuccess, event.Result)
+		require.Equal(t, int64(len("Welcome to GitLab, @test-user!\n")), event.BytesOut)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no session webhook")
+	}
+}
+
+func TestTrustedGateway(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		trustedGateways  []string
+		expectedAccepted bool
+		expectedRemoteIP string
+	}{
+		{
+			desc:             "a trusted gateway",
+			trustedGateways:  []string{"127.0.0.0/8"},
+			expectedAccepted: true,
+			expectedRemoteIP: "203.0.113.7",
+		},
+		{
+			desc:             "an untrusted gateway",
+			trustedGateways:  []string{"192.0.2.0/24"},
+			expectedRemoteIP: "127.0.0.1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			auditLogPath := filepath.Join(t.TempDir(), "audit.log")
+			cfg := &config.Config{Server: config.ServerConfig{
+				AuditLog:        "file:" + auditLogPath,
+				TrustedGateways: tc.trustedGateways,
+			}}
+			_, testRoot := setupServerWithConfig(t, cfg)
+
+			conn, err := net.Dial("tcp", serverURL)
+			require.NoError(t, err)
+
+			sshConn, chans, reqs, err := ssh.NewClientConn(conn, serverURL, clientConfig(t, testRoot))
+			require.NoError(t, err)
+			client := ssh.NewClient(sshConn, chans, reqs)
+			defer client.Close()
+
+			accepted, _, err := client.SendRequest(ProxyInfoMsg, true, appendString(nil, []byte("203.0.113.7:52144")))
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedAccepted, accepted)
+
+			holdSession(t, client)
+
+			var record auditlog.Record
+			require.Eventually(t, func() bool {
+				content, err := os.ReadFile(auditLogPath)
+				return err == nil && json.Unmarshal(content, &record) == nil
+			}, 5*time.Second, 10*time.Millisecond)
+
+			require.Equal(t, tc.expectedRemoteIP, record.RemoteIP)
+		})
+	}
+}
+
+func TestParseProxyInfo(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		payload       []byte
+		expectedAddr  string
+		expectedError string
+	}{
+		{desc: "an address with a port", payload: appendString(nil, []byte("203.0.113.7:52144")), expectedAddr: "203.0.113.7:52144"},
+		{desc: "an IPv6 address", payload: appendString(nil, []byte("2001:db8::7")), expectedAddr: "2001:db8::7"},
+		{desc: "a host name", payload: appendString(nil, []byte("example.com:22")), expectedError: `invalid proxy-info@gitlab.com request: invalid address "example.com:22"`},
+		{desc: "no address", payload: nil, expectedError: "invalid proxy-info@gitlab.com request: expected a single address"},
+		{desc: "a truncated payload", payload: []byte{0, 0, 0, 9, '1'}, expectedError: "invalid proxy-info@gitlab.com request: truncated string"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			addr, err := parseProxyInfo(tc.payload)
+			if tc.expectedError != "" {
+				require.EqualError(t, err, tc.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedAddr, addr)
+		})
+	}
+}
+
+func TestNoMoreSessions(t *testing.T) {
+	_, testRoot := setupServer(t)
+
+	client, err := ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, _, err = client.SendRequest(NoMoreSessionsMsg, true, nil)
+	require.NoError(t, err)
+
+	_, err = client.NewSession()
+	require.ErrorContains(t, err, "no more sessions")
+}
+
+func TestMultiplexedSessions(t *testing.T) {
+	s, testRoot := setupServer(t)
+
+	cfg := &config.Config{GitlabUrl: s.Config.GitlabUrl, User: s.Config.User, RootDir: s.Config.RootDir, Server: s.Config.Server}
+	cfg.Server.ConcurrentSessionsLimit = 3
+	cfg.Server.MaxSessionsPerConnection = 5
+	require.NoError(t, s.Reload(cfg))
+
+	var m dto.Metric
+	require.NoError(t, metrics.SshdConnectionSessions.Write(&m))
+	initialCount := m.GetHistogram().GetSampleCount()
+
+	client, err := ssh.Dial("tcp", serverURL, clientConfig(t, testRoot))
+	require.NoError(t, err)
+
+	// Sessions at once, as opened by clients sharing a connection
+	outputs := make(chan string, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			session, err := client.NewSession()
+			if err != nil {
+				outputs <- err.Error()
+				return
+			}
+			defer session.Close()
+
+			output, err := session.Output("discover")
+			if err != nil {
+				outputs <- err.Error()
+				return
+			}
+			outputs <- string(output)
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		require.Equal(t, "Welcome to GitLab, @test-user!\n", <-outputs)
+	}
+
+	// Then sessions one after the other
+	holdSession(t, client)
+	holdSession(t, client)
+
+	_, err = client.NewSession()
+	require.ErrorContains(t, err, "too many sessions on this connection")
+
+	require.NoError(t, client.Close())
+
+	require.Eventually(t, func() bool {
+		var m dto.Metric
+		require.NoError(t, metrics.SshdConnectionSessions.Write(&m))
+
+		return m.GetHistogram().GetSampleCount() == initialCount+1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
 func TestExtractMetaDataFromContext(t *testing.T) {
 	username := "alex-doe"
 	rootNameSpace := "flightjs"
@@ -422,6 +1515,11 @@ func setupServerWithContext(ctx context.Context, t *testing.T, cfg *config.Confi
 
 				fmt.Fprint(w, `{"id": 1000, "name": "Test User", "username": "test-user"}`)
 			},
+		}, {
+			Path: "/api/v4/internal/check",
+			Handler: func(w http.ResponseWriter, _ *http.Request) {
+				fmt.Fprint(w, `{"api_version": "v4", "redis": true}`)
+			},
 		},
 	}
 
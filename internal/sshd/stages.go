@@ -0,0 +1,124 @@
+package sshd
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+// Stages of connections, each timed from the end of the previous one, to
+// tell a slow network from a slow internal API
+const (
+	// stageAccept goes from accepting the connection to starting the SSH
+	// handshake, through the IP filter and the connection limits
+	stageAccept = "accept"
+	// stageBanner is the exchange of the version banners
+	stageBanner = "banner"
+	// stageKEX is the key exchange
+	stageKEX = "kex"
+	// stageAuth is the authentication, with its requests to the internal API
+	stageAuth = "auth"
+)
+
+// Stages of sessions, timed from the opening of the session
+const (
+	// stageCommandStart goes until the command starts running, after the env
+	// requests and the lookups of the command
+	stageCommandStart = "command_start"
+	// stageFirstByte goes until the first byte of the output of the command
+	// is sent, e.g. the ref advertisement of a clone
+	stageFirstByte = "first_byte"
+	// stageCompletion goes until the end of the session
+	stageCompletion = "completion"
+)
+
+// stageTimer times the stages of a connection or a session. A nil timer
+// times nothing.
+type stageTimer struct {
+	mu        sync.Mutex
+	last      time.Time
+	durations map[string]float64
+}
+
+func newStageTimer(started time.Time) *stageTimer {
+	return &stageTimer{last: started, durations: map[string]float64{}}
+}
+
+// end ends stage now, see endAt
+func (t *stageTimer) end(stage string) {
+	t.endAt(stage, time.Now())
+}
+
+// endAt ends stage at the given time, timing it from the end of the previous
+// stage. A stage already ended is left as it is.
+func (t *stageTimer) endAt(stage string, at time.Time) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	if _, ok := t.durations[stage]; ok {
+		t.mu.Unlock()
+		return
+	}
+
+	duration := max(at.Sub(t.last).Seconds(), 0)
+	t.durations[stage] = duration
+	t.last = at
+	t.mu.Unlock()
+
+	metrics.SshdConnectionStageDuration.WithLabelValues(stage).Observe(duration)
+}
+
+// ended tells whether stage has ended
+func (t *stageTimer) ended(stage string) bool {
+	if t == nil {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.durations[stage]
+
+	return ok
+}
+
+// logFields returns the durations of the stages ended, in seconds by stage
+func (t *stageTimer) logFields() map[string]float64 {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	durations := make(map[string]float64, len(t.durations))
+	for stage, duration := range t.durations {
+		durations[stage] = duration
+	}
+
+	return durations
+}
+
+// bannerConn ends the banner stage once the version banner of the client is
+// read, at its first line break
+type bannerConn struct {
+	net.Conn
+	stages *stageTimer
+	read   atomic.Bool
+}
+
+func (c *bannerConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if !c.read.Load() && bytes.IndexByte(p[:n], '\n') >= 0 {
+		c.read.Store(true)
+		c.stages.end(stageBanner)
+	}
+
+	return n, err
+}
@@ -0,0 +1,108 @@
+package sshd
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+func TestStageTimer(t *testing.T) {
+	started := time.Now()
+	stages := newStageTimer(started)
+
+	stages.endAt(stageCommandStart, started.Add(time.Second))
+	stages.endAt(stageCommandStart, started.Add(2*time.Second))
+	stages.endAt(stageFirstByte, started.Add(3*time.Second))
+
+	require.True(t, stages.ended(stageCommandStart))
+	require.False(t, stages.ended(stageCompletion))
+	require.Equal(t, map[string]float64{stageCommandStart: 1, stageFirstByte: 2}, stages.logFields())
+
+	var nilStages *stageTimer
+	nilStages.end(stageCompletion)
+	require.False(t, nilStages.ended(stageCompletion))
+	require.Nil(t, nilStages.logFields())
+}
+
+func TestHandshakeStages(t *testing.T) {
+	metrics.SshdConnectionStageDuration.Reset()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostKey, err := ssh.NewSignerFromKey(privateKey)
+	require.NoError(t, err)
+
+	srvCfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return &ssh.Permissions{}, nil
+		},
+	}
+	srvCfg.AddHostKey(hostKey)
+
+	// Both ends send their banner at once, which net.Pipe would block
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		clientConn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			return
+		}
+		defer clientConn.Close()
+
+		_, _, _, _ = ssh.NewClientConn(clientConn, "localhost", &ssh.ClientConfig{
+			User:            "git",
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(hostKey)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(), //nolint:gosec // test
+		})
+	}()
+
+	serverConn, err := listener.Accept()
+	require.NoError(t, err)
+
+	conn := newConnection(&config.Config{}, serverConn)
+	sconn, _, err := conn.initServerConn(context.Background(), srvCfg)
+	require.NoError(t, err)
+	defer sconn.Close()
+
+	require.ElementsMatch(t, []string{stageAccept, stageBanner, stageKEX, stageAuth}, mapKeys(conn.stages.logFields()))
+	require.Equal(t, 4, testutil.CollectAndCount(metrics.SshdConnectionStageDuration))
+
+	// The config given isn't changed by the timing
+	require.Nil(t, srvCfg.AuthLogCallback)
+}
+
+func TestSessionStages(t *testing.T) {
+	s := &session{stages: newStageTimer(time.Now()), metered: newMeteredChannel(&fakeChannel{stdOut: &bytes.Buffer{}})}
+
+	s.endStages()
+	require.Empty(t, s.stages.logFields())
+
+	s.stages.end(stageCommandStart)
+	_, err := s.metered.Write([]byte("0000"))
+	require.NoError(t, err)
+	s.endStages()
+
+	require.ElementsMatch(t, []string{stageCommandStart, stageFirstByte, stageCompletion}, mapKeys(s.stages.logFields()))
+}
+
+func mapKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
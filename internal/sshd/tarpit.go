@@ -0,0 +1,236 @@
+package sshd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sync/semaphore"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+// maxClientVersionLength bounds the version line read from held clients, as
+// RFC 4253 bounds it
+const maxClientVersionLength = 255
+
+// tarpitLineLength is the length of the lines sent to held connections
+const tarpitLineLength = 32
+
+// tarpit enforces config.TarpitConfig: it counts the failed authentications
+// of source IPs, and holds the connections of those that failed too often
+type tarpit struct {
+	cfg  config.TarpitConfig
+	now  func() time.Time
+	held *semaphore.Weighted
+
+	mu        sync.Mutex
+	sources   map[string]*tarpitSource
+	lastSweep time.Time
+}
+
+type tarpitSource struct {
+	windowStart  time.Time
+	failures     int
+	trappedUntil time.Time
+}
+
+func newTarpit(cfg config.TarpitConfig) *tarpit {
+	if cfg.MaxFailures <= 0 {
+		return nil
+	}
+
+	return &tarpit{
+		cfg:     cfg,
+		now:     time.Now,
+		held:    newLimitSemaphore(cfg.MaxConnections),
+		sources: make(map[string]*tarpitSource),
+	}
+}
+
+// isAuthFailure reports whether err, returned by the SSH handshake, is a
+// failure to authenticate rather than e.g. a client that only read the
+// version of the server, such as a health check
+func isAuthFailure(err error) bool {
+	var authErr *ssh.ServerAuthError
+
+	return errors.As(err, &authErr) || strings.Contains(err.Error(), "too many authentication failures")
+}
+
+// recordFailure counts a failed authentication of ip, and puts it in the
+// tarpit once it failed MaxFailures times within Window
+func (t *tarpit) recordFailure(ip string) {
+	if t == nil {
+		return
+	}
+
+	now := t.now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweep(now)
+
+	src, ok := t.sources[ip]
+	if !ok || now.Sub(src.windowStart) >= time.Duration(t.cfg.Window) {
+		if !ok {
+			src = &tarpitSource{}
+			t.sources[ip] = src
+		}
+		src.windowStart = now
+		src.failures = 0
+	}
+
+	src.failures++
+	if src.failures >= t.cfg.MaxFailures {
+		src.trappedUntil = now.Add(time.Duration(t.cfg.Duration))
+	}
+}
+
+// trapped reports whether the connections of ip are to be held
+func (t *tarpit) trapped(ip string) bool {
+	if t == nil {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	src, ok := t.sources[ip]
+
+	return ok && t.now().Before(src.trappedUntil)
+}
+
+// sweep forgets the source IPs out of the tarpit whose window is over
+func (t *tarpit) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < sourceSweepInterval {
+		return
+	}
+	t.lastSweep = now
+
+	for ip, src := range t.sources {
+		if !now.Before(src.trappedUntil) && now.Sub(src.windowStart) >= time.Duration(t.cfg.Window) {
+			delete(t.sources, ip)
+		}
+	}
+}
+
+// hold sends a line to nconn every Interval, up to MaxHold, until ctx is done,
+// the server stops serving or the client goes away, and then closes it. The
+// lines may precede the version of an SSH server, so that the client keeps
+// waiting for it. The version the client sent is logged, to tell the scanning
+// tools apart.
+func (t *tarpit) hold(ctx context.Context, nconn net.Conn, ip string, serving func() bool) {
+	defer func() { _ = nconn.Close() }()
+
+	ctxlog := log.WithContextFields(ctx, log.Fields{"remote_addr": nconn.RemoteAddr().String()})
+
+	if t.held != nil && !t.held.TryAcquire(1) {
+		ctxlog.Info("tarpit: too many connections held, closing the connection")
+		return
+	}
+	defer release(t.held)
+
+	metrics.SshdTarpitConnectionsTotal.Inc()
+	metrics.SshdTarpitConnections.Inc()
+	defer metrics.SshdTarpitConnections.Dec()
+
+	// Clients send their version without waiting for that of the server.
+	// Whatever follows is discarded until they go away.
+	clientVersion := make(chan string, 1)
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+
+		reader := bufio.NewReaderSize(nconn, maxClientVersionLength+2)
+		clientVersion <- readClientVersion(reader)
+		_, _ = io.Copy(io.Discard, reader)
+	}()
+
+	started := time.Now()
+	ticker := time.NewTicker(time.Duration(t.cfg.Interval))
+	defer ticker.Stop()
+
+	maxHold := time.NewTimer(time.Duration(t.cfg.MaxHold))
+	defer maxHold.Stop()
+
+	version := ""
+	sent := 0
+	reason := "max_hold"
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			reason = "shutdown"
+			break loop
+		case <-maxHold.C:
+			break loop
+		case version = <-clientVersion:
+			clientVersion = nil
+		case <-gone:
+			reason = "client_gone"
+			break loop
+		case <-ticker.C:
+			if !serving() {
+				reason = "shutdown"
+				break loop
+			}
+
+			if _, err := fmt.Fprintf(nconn, "%s\r\n", tarpitLine()); err != nil {
+				reason = "client_gone"
+				break loop
+			}
+			sent++
+		}
+	}
+
+	if version == "" && clientVersion != nil {
+		select {
+		case version = <-clientVersion:
+		default:
+		}
+	}
+
+	ctxlog.WithFields(log.Fields{
+		"remote_ip":      ip,
+		"client_version": version,
+		"held_s":         time.Since(started).Seconds(),
+		"lines_sent":     sent,
+		"reason":         reason,
+	}).Info("tarpit: released connection")
+}
+
+// readClientVersion returns the first line the client sent, its version
+func readClientVersion(reader *bufio.Reader) string {
+	line, _ := reader.ReadSlice('\n')
+	if len(line) > maxClientVersionLength {
+		line = line[:maxClientVersionLength]
+	}
+
+	return strings.TrimSpace(strings.ToValidUTF8(string(line), "?"))
+}
+
+// tarpitLine returns a random line, which never starts with "SSH-" as the
+// version of the server would
+func tarpitLine() string {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	line := make([]byte, tarpitLineLength)
+	for i := range line {
+		line[i] = letters[rand.Intn(len(letters))] // #nosec G404 -- the lines only need to vary
+	}
+
+	return string(line)
+}
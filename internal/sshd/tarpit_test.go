@@ -0,0 +1,138 @@
+package sshd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+func TestTarpitFailures(t *testing.T) {
+	now := time.Now()
+	tp := newTarpit(config.TarpitConfig{
+		MaxFailures: 2,
+		Window:      config.YamlDuration(time.Minute),
+		Duration:    config.YamlDuration(time.Hour),
+	})
+	tp.now = func() time.Time { return now }
+
+	tp.recordFailure("10.0.0.1")
+	require.False(t, tp.trapped("10.0.0.1"))
+
+	// Failures of another window don't add up
+	now = now.Add(time.Minute)
+	tp.recordFailure("10.0.0.1")
+	require.False(t, tp.trapped("10.0.0.1"))
+
+	now = now.Add(30 * time.Second)
+	tp.recordFailure("10.0.0.1")
+	require.True(t, tp.trapped("10.0.0.1"))
+	require.False(t, tp.trapped("10.0.0.2"), "other source IPs aren't trapped")
+
+	now = now.Add(time.Hour)
+	require.False(t, tp.trapped("10.0.0.1"), "source IPs are released after the duration")
+
+	now = now.Add(sourceSweepInterval)
+	tp.recordFailure("10.0.0.2")
+	require.NotContains(t, tp.sources, "10.0.0.1", "released source IPs are forgotten")
+}
+
+func TestTarpitDisabled(t *testing.T) {
+	tp := newTarpit(config.TarpitConfig{})
+	require.Nil(t, tp)
+
+	tp.recordFailure("10.0.0.1")
+	require.False(t, tp.trapped("10.0.0.1"))
+}
+
+func TestTarpitHold(t *testing.T) {
+	tp := newTarpit(config.TarpitConfig{
+		MaxFailures: 1,
+		Interval:    config.YamlDuration(10 * time.Millisecond),
+		MaxHold:     config.YamlDuration(time.Minute),
+	})
+
+	initial := testutil.ToFloat64(metrics.SshdTarpitConnectionsTotal)
+
+	server, client := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tp.hold(context.Background(), server, "10.0.0.1", func() bool { return true })
+	}()
+
+	_, err := client.Write([]byte("SSH-2.0-scanner_1.0\r\n"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(client)
+	for i := 0; i < 3; i++ {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		require.False(t, strings.HasPrefix(line, "SSH-"), "the lines mustn't be taken for the version of the server")
+		require.Len(t, line, tarpitLineLength+2)
+	}
+
+	require.NoError(t, client.Close())
+	<-done
+
+	require.InDelta(t, initial+1, testutil.ToFloat64(metrics.SshdTarpitConnectionsTotal), 0.1)
+	require.InDelta(t, 0, testutil.ToFloat64(metrics.SshdTarpitConnections), 0.1)
+}
+
+func TestTarpitHoldStops(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		cfg     config.TarpitConfig
+		serving bool
+	}{
+		{
+			desc:    "max hold",
+			cfg:     config.TarpitConfig{MaxFailures: 1, Interval: config.YamlDuration(time.Hour), MaxHold: config.YamlDuration(10 * time.Millisecond)},
+			serving: true,
+		},
+		{
+			desc: "server stopping",
+			cfg:  config.TarpitConfig{MaxFailures: 1, Interval: config.YamlDuration(10 * time.Millisecond), MaxHold: config.YamlDuration(time.Hour)},
+		},
+		{
+			desc:    "too many connections held",
+			cfg:     config.TarpitConfig{MaxFailures: 1, MaxConnections: 1, Interval: config.YamlDuration(time.Hour), MaxHold: config.YamlDuration(time.Hour)},
+			serving: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			tp := newTarpit(tc.cfg)
+			if tp.held != nil {
+				require.True(t, tp.held.TryAcquire(1))
+			}
+
+			server, client := net.Pipe()
+			defer client.Close()
+
+			go tp.hold(context.Background(), server, "10.0.0.1", func() bool { return tc.serving })
+
+			_, err := io.Copy(io.Discard, client)
+			require.NoError(t, err, "the connection is closed")
+		})
+	}
+}
+
+func TestIsAuthFailure(t *testing.T) {
+	require.True(t, isAuthFailure(&ssh.ServerAuthError{Errors: []error{ssh.ErrNoAuth}}))
+	require.True(t, isAuthFailure(errors.New("ssh: disconnect, reason 2: too many authentication failures")))
+	require.False(t, isAuthFailure(io.EOF))
+	require.False(t, isAuthFailure(errors.New("ssh: no common algorithm for host key")))
+}
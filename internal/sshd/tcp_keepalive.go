@@ -0,0 +1,55 @@
+package sshd
+
+import (
+	"net"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+// tcpKeepAliveListener sets the TCP keepalive probes of the connections it
+// accepts
+type tcpKeepAliveListener struct {
+	net.Listener
+	cfg config.TCPKeepAliveConfig
+}
+
+func (l *tcpKeepAliveListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if err := setTCPKeepAlive(tcpConn, l.cfg); err != nil {
+			log.WithFields(log.Fields{"remote_addr": conn.RemoteAddr().String()}).WithError(err).Warn("Failed to set TCP keepalive")
+		}
+	}
+
+	return conn, nil
+}
+
+func setTCPKeepAlive(conn *net.TCPConn, cfg config.TCPKeepAliveConfig) error {
+	if cfg.Disabled {
+		return conn.SetKeepAlive(false)
+	}
+
+	if err := conn.SetKeepAlive(true); err != nil {
+		return err
+	}
+
+	// Sets the interval between probes too, overridden below if configured
+	if cfg.Idle > 0 {
+		if err := conn.SetKeepAlivePeriod(time.Duration(cfg.Idle)); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Interval > 0 || cfg.Count > 0 {
+		return setTCPKeepAliveProbes(conn, time.Duration(cfg.Interval), cfg.Count)
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package sshd
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// setTCPKeepAliveProbes sets the interval between unanswered probes and their
+// number after which the connection is dropped, leaving those that are 0
+func setTCPKeepAliveProbes(conn *net.TCPConn, interval time.Duration, count int) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		if interval > 0 {
+			secs := max(int(interval/time.Second), 1)
+			if sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, secs); sockErr != nil {
+				return
+			}
+		}
+
+		if count > 0 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, count)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return sockErr
+}
@@ -0,0 +1,50 @@
+package sshd
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func TestTCPKeepAliveListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	l := &tcpKeepAliveListener{Listener: listener, cfg: config.TCPKeepAliveConfig{
+		Idle:     config.YamlDuration(30 * time.Second),
+		Interval: config.YamlDuration(10 * time.Second),
+		Count:    4,
+	}}
+	defer l.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	sockopt := func(opt int) int {
+		rawConn, err := conn.(*net.TCPConn).SyscallConn()
+		require.NoError(t, err)
+
+		var value int
+		var sockErr error
+		require.NoError(t, rawConn.Control(func(fd uintptr) {
+			value, sockErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, opt)
+		}))
+		require.NoError(t, sockErr)
+
+		return value
+	}
+
+	require.Equal(t, 30, sockopt(syscall.TCP_KEEPIDLE))
+	require.Equal(t, 10, sockopt(syscall.TCP_KEEPINTVL))
+	require.Equal(t, 4, sockopt(syscall.TCP_KEEPCNT))
+}
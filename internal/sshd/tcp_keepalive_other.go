@@ -0,0 +1,15 @@
+//go:build !linux
+
+package sshd
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// setTCPKeepAliveProbes can't set the interval between probes nor their
+// number outside of Linux
+func setTCPKeepAliveProbes(_ *net.TCPConn, _ time.Duration, _ int) error {
+	return errors.New("the interval and count of TCP keepalive probes are only supported on Linux")
+}
@@ -0,0 +1,57 @@
+package sshd
+
+import (
+	"context"
+	"fmt"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+// tenant is another GitLab instance served on a listener of the server, with
+// its own GitLab URL, secret, host keys and banner
+type tenant struct {
+	cfg          *config.Config
+	serverConfig *serverConfig
+}
+
+// loadTenants loads the configuration of the instances served on the
+// listeners of cfg with a config dir, by listen address. Only the settings of
+// the connections and sessions are taken from their configuration: the
+// listeners, limits, audit and webhooks are those of the server.
+func loadTenants(cfg *config.Config) (map[string]*tenant, error) {
+	tenants := make(map[string]*tenant)
+
+	for _, listener := range cfg.Server.Listeners {
+		if listener.ConfigDir == "" {
+			continue
+		}
+
+		tenantCfg, err := config.NewFromDir(listener.ConfigDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the configuration of %s: %w", listener.Listen, err)
+		}
+
+		if err := tenantCfg.IsSane(); err != nil {
+			return nil, fmt.Errorf("invalid configuration for %s: %w", listener.Listen, err)
+		}
+
+		serverConfig, err := newServerConfig(tenantCfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration for %s: %w", listener.Listen, err)
+		}
+
+		tenantCfg.GitalyClient.InitSidechannelRegistry(context.Background())
+
+		tenants[listener.Listen] = &tenant{cfg: tenantCfg, serverConfig: serverConfig}
+	}
+
+	return tenants, nil
+}
+
+// closeTenants closes the Gitaly connections of tenants, once their sessions
+// are over
+func closeTenants(tenants map[string]*tenant) {
+	for _, t := range tenants {
+		t.cfg.GitalyClient.Close()
+	}
+}
@@ -0,0 +1,147 @@
+package sshd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	osuser "os/user"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+// unixSocketPrefix marks the address of a Unix socket, e.g. for web_listen
+const unixSocketPrefix = "unix:"
+
+// unixSocketPath returns the path of the socket of addr, and whether addr is
+// a Unix socket
+func unixSocketPath(addr string) (string, bool) {
+	return strings.CutPrefix(addr, unixSocketPrefix)
+}
+
+// ListenMonitoring listens on the web_listen address of the monitoring
+// endpoints
+func (s *Server) ListenMonitoring() (net.Listener, error) {
+	if path, ok := unixSocketPath(s.Config.Server.WebListen); ok {
+		return listenUnix(path, s.Config.Server.WebListenSocket)
+	}
+
+	return net.Listen("tcp", s.Config.Server.WebListen)
+}
+
+// listenUnix listens on the Unix socket at path with the permissions of cfg,
+// replacing the socket a previous process left behind
+func listenUnix(path string, cfg config.UnixSocketConfig) (net.Listener, error) {
+	mode, uid, gid, err := socketPermissions(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid permissions of %s: %w", path, err)
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if uid != -1 || gid != -1 {
+		err = os.Chown(path, uid, gid)
+	}
+	if err == nil && mode != 0 {
+		err = os.Chmod(path, mode)
+	}
+	if err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to set the permissions of %s: %w", path, err)
+	}
+
+	return listener, nil
+}
+
+// removeStaleSocket removes the socket at path if no process accepts
+// connections on it. Files other than sockets are left alone.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and isn't a socket", path)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("failed to check %s: %w", path, err)
+	}
+
+	return os.Remove(path)
+}
+
+// socketPermissions parses cfg, returning -1 for the IDs to leave as they are
+func socketPermissions(cfg config.UnixSocketConfig) (os.FileMode, int, int, error) {
+	var mode os.FileMode
+	if cfg.Mode != "" {
+		value, err := strconv.ParseUint(cfg.Mode, 8, 32)
+		if err != nil || value > 0o777 {
+			return 0, 0, 0, fmt.Errorf("invalid mode %q", cfg.Mode)
+		}
+		mode = os.FileMode(value)
+	}
+
+	uid, err := lookupID(cfg.Owner, func(name string) (string, error) {
+		u, err := osuser.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid owner %q: %w", cfg.Owner, err)
+	}
+
+	gid, err := lookupID(cfg.Group, func(name string) (string, error) {
+		g, err := osuser.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	})
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid group %q: %w", cfg.Group, err)
+	}
+
+	return mode, uid, gid, nil
+}
+
+// lookupID returns the numeric ID of name, which may already be one, or -1
+// if name is empty
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if name == "" {
+		return -1, nil
+	}
+
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	id, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(id)
+}
@@ -0,0 +1,148 @@
+package sshd
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+// unixHTTPClient sends its requests to the Unix socket at path
+func unixHTTPClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+func TestListenMonitoringOnUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.socket")
+
+	s := &Server{Config: &config.Config{Server: config.ServerConfig{
+		WebListen: "unix:" + path,
+		WebListenSocket: config.UnixSocketConfig{
+			Mode:  "0640",
+			Owner: strconv.Itoa(os.Getuid()),
+			Group: strconv.Itoa(os.Getgid()),
+		},
+		ReadinessProbe: "/start",
+		LivenessProbe:  "/health",
+	}}}
+
+	listener, err := s.ListenMonitoring()
+	require.NoError(t, err)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.ModeSocket|0o640, info.Mode())
+
+	server := &http.Server{Handler: s.MonitoringServeMux()} //nolint:gosec // test server
+	go server.Serve(listener)
+
+	response, err := unixHTTPClient(path).Get("http://socket/health")
+	require.NoError(t, err)
+	response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	require.NoError(t, server.Close())
+	require.NoFileExists(t, path, "the socket is removed once closed")
+}
+
+func TestListenUnixReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.socket")
+
+	// A socket file left by a process that is gone
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	require.NoError(t, err)
+	stale.SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+	require.FileExists(t, path)
+
+	listener, err := listenUnix(path, config.UnixSocketConfig{})
+	require.NoError(t, err)
+
+	// The socket in use isn't replaced
+	_, err = listenUnix(path, config.UnixSocketConfig{})
+	require.ErrorContains(t, err, "is in use by another process")
+
+	require.NoError(t, listener.Close())
+}
+
+func TestListenUnixErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o600))
+
+	testCases := []struct {
+		desc          string
+		path          string
+		cfg           config.UnixSocketConfig
+		expectedError string
+	}{
+		{
+			desc:          "a file other than a socket",
+			path:          file,
+			expectedError: file + " exists and isn't a socket",
+		},
+		{
+			desc:          "an invalid mode",
+			path:          filepath.Join(dir, "socket"),
+			cfg:           config.UnixSocketConfig{Mode: "rw-rw----"},
+			expectedError: `invalid permissions of ` + filepath.Join(dir, "socket") + `: invalid mode "rw-rw----"`,
+		},
+		{
+			desc:          "an unknown owner",
+			path:          filepath.Join(dir, "socket"),
+			cfg:           config.UnixSocketConfig{Owner: "no-such-user-for-gitlab-sshd"},
+			expectedError: `invalid owner "no-such-user-for-gitlab-sshd"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := listenUnix(tc.path, tc.cfg)
+			require.ErrorContains(t, err, tc.expectedError)
+		})
+	}
+
+	require.FileExists(t, file, "files other than sockets are left alone")
+}
+
+func TestServeDebugOnUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "debug.socket")
+	s := &Server{Config: &config.Config{Server: config.ServerConfig{
+		DebugListen:       "unix:" + path,
+		DebugListenSocket: config.UnixSocketConfig{Mode: "0600"},
+	}}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.ServeDebug(ctx) }()
+
+	require.Eventually(t, func() bool {
+		response, err := unixHTTPClient(path).Get("http://socket/debug/goroutines")
+		if err != nil {
+			return false
+		}
+		response.Body.Close()
+
+		return response.StatusCode == http.StatusOK
+	}, time.Second, time.Millisecond)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.ModeSocket|0o600, info.Mode())
+
+	cancel()
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,203 @@
+package sshd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+// The environment variables an upgrading server passes its listening sockets
+// and the end of its readiness pipe in, as descriptor numbers. The sockets
+// are listed as address=fd, separated by commas, the address being the
+// configured one.
+const (
+	upgradeListenersEnv = "GITLAB_SSHD_UPGRADE_LISTENERS"
+	upgradeReadyEnv     = "GITLAB_SSHD_UPGRADE_READY_FD"
+)
+
+// UpgradeReadyTimeout is how long Upgrade waits for the new process to serve
+var UpgradeReadyTimeout = time.Minute
+
+var errUpgradeInProgress = errors.New("an upgrade is already in progress")
+
+// fileListener is a listener whose socket can be passed to another process
+type fileListener interface {
+	File() (*os.File, error)
+}
+
+// Upgrade starts the executable of the server again, e.g. a new version of
+// it, with the same arguments, handing over its listening sockets. It returns
+// once the new process accepts connections on them, so that the server can
+// be drained without refusing any connection. The server carries on if the
+// new process fails to start or to become ready within UpgradeReadyTimeout.
+func (s *Server) Upgrade(ctx context.Context) error {
+	if !s.upgrading.CompareAndSwap(false, true) {
+		return errUpgradeInProgress
+	}
+	defer s.upgrading.Store(false)
+
+	if s.getStatus() != StatusReady {
+		return fmt.Errorf("the server isn't serving")
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the executable: %w", err)
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, file := range files {
+			_ = file.Close()
+		}
+	}()
+
+	// The descriptors of ExtraFiles are numbered from 3
+	var addresses []string
+	for _, l := range s.listeners {
+		socket, ok := l.socket.(fileListener)
+		if !ok {
+			return fmt.Errorf("the socket of %s can't be handed over", l.address)
+		}
+
+		file, err := socket.File()
+		if err != nil {
+			return fmt.Errorf("failed to hand over the socket of %s: %w", l.address, err)
+		}
+
+		addresses = append(addresses, l.address+"="+strconv.Itoa(3+len(files)))
+		files = append(files, file)
+	}
+
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create the readiness pipe: %w", err)
+	}
+	defer func() { _ = ready.Close() }()
+
+	readyFD := 3 + len(files)
+	files = append(files, readyWriter)
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		upgradeListenersEnv+"="+strings.Join(addresses, ","),
+		upgradeReadyEnv+"="+strconv.Itoa(readyFD),
+	)
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", executable, err)
+	}
+
+	// The pipe is at EOF if the new process exits, as it holds the only
+	// other copy of its write end
+	_ = readyWriter.Close()
+	files = files[:len(files)-1]
+
+	ctxlog := log.WithContextFields(ctx, log.Fields{"pid": cmd.Process.Pid})
+	ctxlog.Info("Upgrade: waiting for the new process to be ready")
+
+	if err := waitForUpgrade(ready, UpgradeReadyTimeout); err != nil {
+		_ = cmd.Process.Kill()
+		go func() { _ = cmd.Wait() }()
+
+		return fmt.Errorf("the new process failed to become ready: %w", err)
+	}
+
+	// systemd follows the new process as the main one of the service
+	notifySystemd(ctx, "MAINPID="+strconv.Itoa(cmd.Process.Pid))
+	_ = cmd.Process.Release()
+
+	ctxlog.Info("Upgrade: the new process is ready")
+
+	return nil
+}
+
+// waitForUpgrade waits up to timeout for a byte on ready
+func waitForUpgrade(ready *os.File, timeout time.Duration) error {
+	if err := ready.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	if _, err := ready.Read(make([]byte, 1)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// inheritedListeners returns the sockets handed over by the process that
+// started this one with Upgrade, by configured address, and unsets the
+// environment variable that passes them so that they aren't inherited again
+func inheritedListeners() (map[string]net.Listener, error) {
+	value := os.Getenv(upgradeListenersEnv)
+	_ = os.Unsetenv(upgradeListenersEnv)
+	if value == "" {
+		return nil, nil
+	}
+
+	listeners := make(map[string]net.Listener)
+	for _, entry := range strings.Split(value, ",") {
+		address, fdValue, _ := strings.Cut(entry, "=")
+
+		listener, err := fdListener(fdValue, address)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+
+			return nil, fmt.Errorf("invalid socket %s handed over: %w", address, err)
+		}
+
+		listeners[address] = listener
+	}
+
+	return listeners, nil
+}
+
+func fdListener(fdValue, name string) (net.Listener, error) {
+	fd, err := strconv.Atoi(fdValue)
+	if err != nil {
+		return nil, err
+	}
+
+	file := os.NewFile(uintptr(fd), name)
+	listener, err := net.FileListener(file)
+	// The listener has its own copy of the file descriptor
+	_ = file.Close()
+
+	return listener, err
+}
+
+// notifyUpgraded tells the process that started this one with Upgrade, if
+// any, that this one accepts connections
+func notifyUpgraded(ctx context.Context) {
+	value := os.Getenv(upgradeReadyEnv)
+	_ = os.Unsetenv(upgradeReadyEnv)
+	if value == "" {
+		return
+	}
+
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		log.WithContextFields(ctx, log.Fields{"fd": value}).Warn("Upgrade: invalid readiness pipe")
+		return
+	}
+
+	pipe := os.NewFile(uintptr(fd), "upgrade-ready")
+	defer func() { _ = pipe.Close() }()
+
+	if _, err := pipe.Write([]byte{1}); err != nil {
+		log.ContextLogger(ctx).WithError(err).Warn("Upgrade: failed to notify the previous process")
+	}
+}
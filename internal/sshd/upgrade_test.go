@@ -0,0 +1,130 @@
+package sshd
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+// handOver returns a raw descriptor of a socket listening on address, the
+// way Upgrade passes it, along with the address actually listened on
+func handOver(t *testing.T, address string) (string, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", address)
+	require.NoError(t, err)
+
+	file, err := listener.(*net.TCPListener).File()
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	// A raw descriptor, which no *os.File closes, is handed over
+	fd, err := syscall.Dup(int(file.Fd()))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	return strconv.Itoa(fd), addr
+}
+
+// readinessPipe passes the write end of a pipe the way Upgrade does, and
+// returns its read end
+func readinessPipe(t *testing.T) *os.File {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	t.Cleanup(func() { r.Close() })
+
+	fd, err := syscall.Dup(int(w.Fd()))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	t.Setenv(upgradeReadyEnv, strconv.Itoa(fd))
+
+	return r
+}
+
+func TestInheritedListeners(t *testing.T) {
+	fd, addr := handOver(t, "127.0.0.1:0")
+	t.Setenv(upgradeListenersEnv, "[::]:22="+fd)
+
+	listeners, err := inheritedListeners()
+	require.NoError(t, err)
+	require.Len(t, listeners, 1)
+	require.Empty(t, os.Getenv(upgradeListenersEnv), "the sockets shouldn't be handed over again")
+
+	listener := listeners["[::]:22"]
+	require.Equal(t, addr, listener.Addr().String())
+
+	// The listener still accepts connections
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	require.NoError(t, listener.Close())
+}
+
+func TestInheritedListenersInvalid(t *testing.T) {
+	t.Setenv(upgradeListenersEnv, "[::]:22=ssh")
+
+	_, err := inheritedListeners()
+	require.ErrorContains(t, err, "invalid socket [::]:22 handed over")
+}
+
+func TestNotifyUpgraded(t *testing.T) {
+	ready := readinessPipe(t)
+
+	notifyUpgraded(context.Background())
+	require.Empty(t, os.Getenv(upgradeReadyEnv))
+	require.NoError(t, waitForUpgrade(ready, UpgradeReadyTimeout))
+}
+
+func TestListenOnInheritedListeners(t *testing.T) {
+	const otherURL = "127.0.0.1:50002"
+
+	// The sockets stay bound, so the server can't listen on their addresses
+	// again and must take them over
+	fd, _ := handOver(t, serverURL)
+	otherFD, _ := handOver(t, otherURL)
+	unusedFD, unusedAddr := handOver(t, "127.0.0.1:0")
+	t.Setenv(upgradeListenersEnv, serverURL+"="+fd+","+otherURL+"="+otherFD+",127.0.0.1:1="+unusedFD)
+
+	ready := readinessPipe(t)
+
+	s, testRoot := setupServerWithConfig(t, &config.Config{
+		Server: config.ServerConfig{
+			Listeners: []config.ListenerConfig{{Listen: otherURL}},
+		},
+	})
+	require.Len(t, s.listeners, 2)
+
+	// The previous process is told that the server serves
+	require.NoError(t, waitForUpgrade(ready, UpgradeReadyTimeout))
+
+	for _, address := range []string{serverURL, otherURL} {
+		client, err := ssh.Dial("tcp", address, clientConfig(t, testRoot))
+		require.NoError(t, err)
+		client.Close()
+	}
+
+	// The socket of an address that isn't configured is closed
+	_, err := net.Dial("tcp", unusedAddr)
+	require.Error(t, err)
+
+	require.NoError(t, s.Shutdown())
+	verifyStatus(t, s, StatusClosed)
+}
+
+func TestUpgradeWhenNotServing(t *testing.T) {
+	s := &Server{}
+
+	require.EqualError(t, s.Upgrade(context.Background()), "the server isn't serving")
+}
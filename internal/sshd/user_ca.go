@@ -0,0 +1,172 @@
+package sshd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+const sourceAddressOption = "source-address"
+
+// parseTrustedUserCAKeys reads the CA public keys in authorized_keys format
+// from filename, keyed by their wire encoding
+func parseTrustedUserCAKeys(filename string) (map[string]bool, error) {
+	if filename == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(filepath.Clean(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		keys[string(key.Marshal())] = true
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no CA keys found", filename)
+	}
+
+	return keys, nil
+}
+
+func (s *serverConfig) isTrustedUserCA(key ssh.PublicKey) bool {
+	return s.trustedUserCAKeys[string(key.Marshal())]
+}
+
+// handleTrustedUserCertificate authenticates a certificate signed by one of
+// the CAs in trusted_user_ca_keys. As with the principals written by
+// gitlab-shell-authorized-principals-check, the key ID of the certificate is
+// the GitLab username and the certificate must be issued for one of the
+// authorized principals.
+func (s *serverConfig) handleTrustedUserCertificate(ctx context.Context, user string, remoteAddr net.Addr, cert *ssh.Certificate) (*ssh.Permissions, error) {
+	if user != s.cfg.User {
+		return nil, fmt.Errorf("unknown user")
+	}
+
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("handleTrustedUserCertificate: cert has type %d", cert.CertType)
+	}
+
+	principal, ok := s.authorizedPrincipal(cert)
+	if !ok {
+		return nil, fmt.Errorf("handleTrustedUserCertificate: cert has no authorized principal")
+	}
+
+	certChecker := &ssh.CertChecker{}
+	if err := certChecker.CheckCert(principal, cert); err != nil {
+		return nil, err
+	}
+
+	if err := checkSourceAddress(remoteAddr, cert.CriticalOptions[sourceAddressOption]); err != nil {
+		return nil, err
+	}
+
+	if cert.KeyId == "" {
+		return nil, fmt.Errorf("handleTrustedUserCertificate: cert has no key ID")
+	}
+
+	logger := log.WithContextFields(ctx,
+		log.Fields{
+			"ssh_user":               user,
+			"public_key_fingerprint": ssh.FingerprintSHA256(cert),
+			"signing_ca_fingerprint": ssh.FingerprintSHA256(cert.SignatureKey),
+			"certificate_identity":   cert.KeyId,
+			"certificate_principal":  principal,
+		},
+	)
+
+	res, err := s.discoverClient.GetByCommandArgs(ctx, &commandargs.Shell{GitlabUsername: cert.KeyId})
+	if err != nil {
+		logger.WithError(err).Warn("failed to look up the user of a certificate signed by a trusted CA")
+
+		return nil, err
+	}
+
+	if res.IsAnonymous() {
+		logger.Warn("certificate signed by a trusted CA doesn't belong to a GitLab user")
+
+		return nil, fmt.Errorf("handleTrustedUserCertificate: unknown GitLab user")
+	}
+
+	logger.WithField("certificate_username", res.Username).Info("user certificate is signed by a trusted CA")
+
+	return &ssh.Permissions{
+		Extensions: map[string]string{
+			"username": res.Username,
+		},
+	}, nil
+}
+
+// authorizedPrincipal returns the first principal of cert that is authorized.
+// Without authorized_principals only the SSH user is, like with OpenSSH.
+func (s *serverConfig) authorizedPrincipal(cert *ssh.Certificate) (string, bool) {
+	authorized := s.cfg.Server.AuthorizedPrincipals
+	if len(authorized) == 0 {
+		authorized = []string{s.cfg.User}
+	}
+
+	for _, principal := range cert.ValidPrincipals {
+		if slices.Contains(authorized, principal) {
+			return principal, true
+		}
+	}
+
+	return "", false
+}
+
+// checkSourceAddress enforces the source-address critical option, which
+// ssh.CertChecker only does when authenticating the SSH user as a principal
+func checkSourceAddress(remoteAddr net.Addr, sourceAddress string) error {
+	if sourceAddress == "" {
+		return nil
+	}
+
+	tcpAddr, ok := remoteAddr.(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("ssh: remote address %v is not a TCP address", remoteAddr)
+	}
+
+	for _, allowed := range strings.Split(sourceAddress, ",") {
+		if allowedIP := net.ParseIP(allowed); allowedIP != nil {
+			if allowedIP.Equal(tcpAddr.IP) {
+				return nil
+			}
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(allowed)
+		if err != nil {
+			return fmt.Errorf("ssh: error parsing source-address restriction %q: %w", allowed, err)
+		}
+		if ipNet.Contains(tcpAddr.IP) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("ssh: remote address %v is not allowed because of source-address restriction", remoteAddr)
+}
@@ -0,0 +1,228 @@
+package sshd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/testhelper"
+)
+
+func newUserCA(t *testing.T) ssh.Signer {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	require.NoError(t, err)
+
+	return signer
+}
+
+func writeTrustedUserCAKeys(t *testing.T, contents string) string {
+	filename := filepath.Join(t.TempDir(), "trusted_user_ca_keys.pub")
+	require.NoError(t, os.WriteFile(filename, []byte(contents), 0o600))
+
+	return filename
+}
+
+type userCertOpts struct {
+	certType      uint32
+	keyID         string
+	principals    []string
+	validBefore   time.Time
+	sourceAddress string
+}
+
+func signedUserCert(t *testing.T, ca ssh.Signer, opts userCertOpts) *ssh.Certificate {
+	pubKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	key, err := ssh.NewPublicKey(pubKey)
+	require.NoError(t, err)
+
+	cert := &ssh.Certificate{
+		CertType:        opts.certType,
+		Key:             key,
+		KeyId:           opts.keyID,
+		ValidPrincipals: opts.principals,
+		ValidBefore:     uint64(opts.validBefore.Unix()),
+	}
+	if opts.sourceAddress != "" {
+		cert.CriticalOptions = map[string]string{sourceAddressOption: opts.sourceAddress}
+	}
+	require.NoError(t, cert.SignCert(rand.Reader, ca))
+
+	return cert
+}
+
+func TestTrustedUserCertificateHandling(t *testing.T) {
+	testRoot := testhelper.PrepareTestRootDir(t)
+
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/discover",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("username") == "root" {
+					w.Write([]byte(`{ "id": 1, "name": "Administrator", "username": "root" }`))
+				} else {
+					w.Write([]byte(`null`))
+				}
+			},
+		},
+	}
+
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	ca := newUserCA(t)
+	srvCfg := config.ServerConfig{
+		HostKeyFiles:         []string{path.Join(testRoot, "certs/valid/server.key")},
+		TrustedUserCAKeys:    writeTrustedUserCAKeys(t, string(ssh.MarshalAuthorizedKey(ca.PublicKey()))),
+		AuthorizedPrincipals: []string{"gitlab-users"},
+	}
+
+	cfg, err := newServerConfig(&config.Config{GitlabUrl: url, User: "git", Server: srvCfg})
+	require.NoError(t, err)
+
+	validOpts := userCertOpts{certType: ssh.UserCert, keyID: "root", principals: []string{"other", "gitlab-users"}, validBefore: time.Now().Add(time.Hour)}
+	remoteAddr := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 2222}
+
+	testCases := []struct {
+		desc                string
+		user                string
+		modify              func(*userCertOpts)
+		expectedErr         error
+		expectedPermissions *ssh.Permissions
+	}{
+		{
+			desc: "successful request",
+			user: "git",
+			expectedPermissions: &ssh.Permissions{
+				Extensions: map[string]string{"username": "root"},
+			},
+		}, {
+			desc:        "wrong user",
+			user:        "root",
+			expectedErr: errors.New("unknown user"),
+		}, {
+			desc:        "wrong cert type",
+			user:        "git",
+			modify:      func(o *userCertOpts) { o.certType = ssh.HostCert },
+			expectedErr: errors.New("handleTrustedUserCertificate: cert has type 2"),
+		}, {
+			desc:        "no authorized principal",
+			user:        "git",
+			modify:      func(o *userCertOpts) { o.principals = []string{"git", "other"} },
+			expectedErr: errors.New("handleTrustedUserCertificate: cert has no authorized principal"),
+		}, {
+			desc:        "expired cert",
+			user:        "git",
+			modify:      func(o *userCertOpts) { o.validBefore = time.Now().Add(-time.Hour) },
+			expectedErr: errors.New("ssh: cert has expired"),
+		}, {
+			desc:   "allowed source address",
+			user:   "git",
+			modify: func(o *userCertOpts) { o.sourceAddress = "198.51.100.1,192.0.2.0/24" },
+			expectedPermissions: &ssh.Permissions{
+				Extensions: map[string]string{"username": "root"},
+			},
+		}, {
+			desc:        "disallowed source address",
+			user:        "git",
+			modify:      func(o *userCertOpts) { o.sourceAddress = "198.51.100.0/24" },
+			expectedErr: errors.New("ssh: remote address 192.0.2.1:2222 is not allowed because of source-address restriction"),
+		}, {
+			desc:        "no key ID",
+			user:        "git",
+			modify:      func(o *userCertOpts) { o.keyID = "" },
+			expectedErr: errors.New("handleTrustedUserCertificate: cert has no key ID"),
+		}, {
+			desc:        "unknown GitLab user",
+			user:        "git",
+			modify:      func(o *userCertOpts) { o.keyID = "nobody" },
+			expectedErr: errors.New("handleTrustedUserCertificate: unknown GitLab user"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := validOpts
+			if tc.modify != nil {
+				tc.modify(&opts)
+			}
+			cert := signedUserCert(t, ca, opts)
+			require.True(t, cfg.isTrustedUserCA(cert.SignatureKey))
+
+			permissions, err := cfg.handleTrustedUserCertificate(context.Background(), tc.user, remoteAddr, cert)
+			require.Equal(t, tc.expectedErr, err)
+			require.Equal(t, tc.expectedPermissions, permissions)
+		})
+	}
+
+	t.Run("cert signed by another CA isn't trusted", func(t *testing.T) {
+		cert := signedUserCert(t, newUserCA(t), validOpts)
+		require.False(t, cfg.isTrustedUserCA(cert.SignatureKey))
+	})
+}
+
+func TestAuthorizedPrincipalDefaultsToSSHUser(t *testing.T) {
+	ca := newUserCA(t)
+	cfg := &serverConfig{cfg: &config.Config{User: "git"}}
+
+	principal, ok := cfg.authorizedPrincipal(signedUserCert(t, ca, userCertOpts{principals: []string{"other", "git"}}))
+	require.True(t, ok)
+	require.Equal(t, "git", principal)
+
+	_, ok = cfg.authorizedPrincipal(signedUserCert(t, ca, userCertOpts{principals: []string{"other"}}))
+	require.False(t, ok)
+}
+
+func TestParseTrustedUserCAKeys(t *testing.T) {
+	first, second := newUserCA(t), newUserCA(t)
+
+	t.Run("keys with comments and blank lines", func(t *testing.T) {
+		filename := writeTrustedUserCAKeys(t, "# user CAs\n\n"+
+			string(ssh.MarshalAuthorizedKey(first.PublicKey()))+
+			"  "+string(ssh.MarshalAuthorizedKey(second.PublicKey())))
+
+		keys, err := parseTrustedUserCAKeys(filename)
+		require.NoError(t, err)
+		require.Equal(t, map[string]bool{
+			string(first.PublicKey().Marshal()):  true,
+			string(second.PublicKey().Marshal()): true,
+		}, keys)
+	})
+
+	t.Run("not configured", func(t *testing.T) {
+		keys, err := parseTrustedUserCAKeys("")
+		require.NoError(t, err)
+		require.Nil(t, keys)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := parseTrustedUserCAKeys(filepath.Join(t.TempDir(), "missing.pub"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		_, err := parseTrustedUserCAKeys(writeTrustedUserCAKeys(t, "ssh-ed25519 invalid\n"))
+		require.Error(t, err)
+	})
+
+	t.Run("no keys", func(t *testing.T) {
+		_, err := parseTrustedUserCAKeys(writeTrustedUserCAKeys(t, "# nothing here\n"))
+		require.ErrorContains(t, err, "no CA keys found")
+	})
+}
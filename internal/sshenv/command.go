@@ -0,0 +1,184 @@
+package sshenv
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mattn/go-shellwords"
+)
+
+// Git commands that may be run over SSH
+const (
+	UploadPackCommand    = "git-upload-pack"
+	ReceivePackCommand   = "git-receive-pack"
+	UploadArchiveCommand = "git-upload-archive"
+)
+
+var (
+	// ErrMissingNamespaceData is returned when the namespace or the repository
+	// path needed for a comparison is unavailable
+	ErrMissingNamespaceData = errors.New("namespace or repository path is missing")
+	// ErrUnknownGitCommand is returned when OriginalCommand isn't a git command
+	ErrUnknownGitCommand = errors.New("unknown git command")
+	// ErrUnsupportedShellSyntax is returned when OriginalCommand contains an
+	// unquoted shell operator such as ";" or "|"
+	ErrUnsupportedShellSyntax = errors.New("unsupported shell syntax")
+)
+
+// CommandArgs tokenizes OriginalCommand using POSIX shell quoting rules
+// (single quotes, double quotes and backslash escapes), the way sshd hands
+// commands to an authorized_keys command. An unterminated quote or an
+// unquoted shell operator results in an error rather than a truncated result.
+// A trailing line ending, LF or CRLF as sent by Windows clients, is ignored;
+// other line breaks separate commands in a shell, and are rejected as well.
+func (e Env) CommandArgs() ([]string, error) {
+	return tokenize(e.OriginalCommand)
+}
+
+func tokenize(command string) ([]string, error) {
+	command = strings.TrimSuffix(strings.TrimSuffix(command, "\n"), "\r")
+	if i := strings.IndexAny(command, "\r\n"); i >= 0 {
+		return nil, fmt.Errorf("%w at position %d", ErrUnsupportedShellSyntax, i)
+	}
+
+	parser := shellwords.NewParser()
+
+	args, err := parser.Parse(command)
+	if err != nil {
+		return nil, err
+	}
+
+	// The parser stops at the first unquoted operator, ignoring the rest
+	if parser.Position >= 0 {
+		return nil, fmt.Errorf("%w at position %d", ErrUnsupportedShellSyntax, parser.Position)
+	}
+
+	return args, nil
+}
+
+// NamespaceMatchesCommand reports whether the repository path named in
+// OriginalCommand lies within NamespacePath. Disagreement between the two is
+// a red flag worth surfacing. ErrMissingNamespaceData is returned when either
+// value is unavailable.
+func (e Env) NamespaceMatchesCommand() (bool, error) {
+	namespace := strings.Trim(e.NamespacePath, "/")
+	if namespace == "" {
+		return false, ErrMissingNamespaceData
+	}
+
+	repoPath, err := repositoryPath(e.OriginalCommand)
+	if err != nil {
+		return false, err
+	}
+	if repoPath == "" {
+		return false, ErrMissingNamespaceData
+	}
+
+	// GitLab paths are case-insensitive
+	repoPath = strings.TrimSuffix(repoPath, ".git")
+	if strings.EqualFold(repoPath, namespace) {
+		return true, nil
+	}
+
+	prefix := namespace + "/"
+	matches := len(repoPath) > len(prefix) && strings.EqualFold(repoPath[:len(prefix)], prefix)
+
+	return matches, nil
+}
+
+// ParseCommand identifies the git command in OriginalCommand and, unless
+// NamespacePath is already known, fills it in with the repository path the
+// command operates on, e.g. "group/subgroup/project" for
+// git-upload-pack 'group/subgroup/project.git'. A missing command leaves
+// NamespacePath empty; a command that isn't a known git command is rejected
+// with ErrUnknownGitCommand.
+func (e *Env) ParseCommand() error {
+	verb, repoPath, err := splitGitCommand(e.OriginalCommand)
+	if err != nil {
+		return err
+	}
+
+	if verb == "" {
+		return nil
+	}
+
+	if !isGitCommand(verb) {
+		return fmt.Errorf("%w: %q", ErrUnknownGitCommand, verb)
+	}
+
+	if e.NamespacePath == "" {
+		e.NamespacePath = strings.TrimSuffix(repoPath, ".git")
+	}
+
+	return nil
+}
+
+// GitCommand is a git command from OriginalCommand, along with the protocol
+// version the client asked for
+type GitCommand struct {
+	Verb            string
+	RepoPath        string
+	ProtocolVersion int
+}
+
+// GitCommand returns the git command in OriginalCommand. Commands other than
+// the known git commands are rejected with ErrUnknownGitCommand.
+func (e Env) GitCommand() (GitCommand, error) {
+	verb, repoPath, err := splitGitCommand(e.OriginalCommand)
+	if err != nil {
+		return GitCommand{}, err
+	}
+
+	if !isGitCommand(verb) {
+		return GitCommand{}, fmt.Errorf("%w: %q", ErrUnknownGitCommand, verb)
+	}
+
+	return GitCommand{Verb: verb, RepoPath: repoPath, ProtocolVersion: e.ProtocolVersion}, nil
+}
+
+// IsProtocolV2Ready reports whether protocol v2 negotiation follows the
+// command. Only upload-pack speaks v2; git-receive-pack and
+// git-upload-archive always fall back to v0.
+func (gc GitCommand) IsProtocolV2Ready() bool {
+	return gc.Verb == UploadPackCommand && gc.ProtocolVersion >= 2
+}
+
+func isGitCommand(verb string) bool {
+	switch verb {
+	case UploadPackCommand, ReceivePackCommand, UploadArchiveCommand:
+		return true
+	default:
+		return false
+	}
+}
+
+// repositoryPath returns the repository path argument of a git command
+func repositoryPath(command string) (string, error) {
+	_, repoPath, err := splitGitCommand(command)
+
+	return repoPath, err
+}
+
+// splitGitCommand splits a command such as "git-upload-pack 'group/project.git'"
+// into its verb and repository path, without its leading slash
+func splitGitCommand(command string) (string, string, error) {
+	args, err := tokenize(command)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Git for Windows 2.14 sends "git upload-pack" instead of git-upload-pack
+	if len(args) > 1 && args[0] == "git" {
+		args = append([]string{args[0] + "-" + args[1]}, args[2:]...)
+	}
+
+	switch len(args) {
+	case 0:
+		return "", "", nil
+	case 1:
+		return args[0], "", nil
+	default:
+		return args[0], strings.TrimPrefix(args[1], "/"), nil
+	}
+}
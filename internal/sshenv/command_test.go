@@ -0,0 +1,232 @@
+package sshenv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespaceMatchesCommand(t *testing.T) {
+	tests := []struct {
+		desc      string
+		env       Env
+		want      bool
+		wantError error
+	}{
+		{
+			desc: "matching namespace",
+			env:  Env{NamespacePath: "flightjs", OriginalCommand: "git-upload-pack 'flightjs/Flight.git'"},
+			want: true,
+		},
+		{
+			desc: "matching nested namespace with a leading slash",
+			env:  Env{NamespacePath: "group/subgroup", OriginalCommand: "git-receive-pack '/group/subgroup/project.git'"},
+			want: true,
+		},
+		{
+			desc: "matching namespace with different case",
+			env:  Env{NamespacePath: "FlightJS", OriginalCommand: "git upload-pack flightjs/Flight.git"},
+			want: true,
+		},
+		{
+			desc: "namespace equal to the repository path",
+			env:  Env{NamespacePath: "flightjs/Flight", OriginalCommand: "git-upload-pack 'flightjs/Flight.git'"},
+			want: true,
+		},
+		{
+			desc: "mismatching namespace",
+			env:  Env{NamespacePath: "flightjs", OriginalCommand: "git-upload-pack 'other/Flight.git'"},
+			want: false,
+		},
+		{
+			desc: "namespace that is only a prefix of the top-level group",
+			env:  Env{NamespacePath: "flight", OriginalCommand: "git-upload-pack 'flightjs/Flight.git'"},
+			want: false,
+		},
+		{
+			desc:      "missing namespace",
+			env:       Env{OriginalCommand: "git-upload-pack 'flightjs/Flight.git'"},
+			wantError: ErrMissingNamespaceData,
+		},
+		{
+			desc:      "missing repository path",
+			env:       Env{NamespacePath: "flightjs", OriginalCommand: "git-upload-pack"},
+			wantError: ErrMissingNamespaceData,
+		},
+		{
+			desc:      "missing command",
+			env:       Env{NamespacePath: "flightjs"},
+			wantError: ErrMissingNamespaceData,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			matches, err := tc.env.NamespaceMatchesCommand()
+
+			require.ErrorIs(t, err, tc.wantError)
+			require.Equal(t, tc.want, matches)
+		})
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		desc          string
+		env           Env
+		wantNamespace string
+		wantError     error
+	}{
+		{
+			desc:          "single-quoted upload-pack",
+			env:           Env{OriginalCommand: "git-upload-pack 'group/subgroup/project.git'"},
+			wantNamespace: "group/subgroup/project",
+		},
+		{
+			desc:          "unquoted receive-pack with a leading slash",
+			env:           Env{OriginalCommand: "git-receive-pack /group/project.git"},
+			wantNamespace: "group/project",
+		},
+		{
+			desc:          "upload-archive in Git for Windows form",
+			env:           Env{OriginalCommand: "git upload-archive 'group/project'"},
+			wantNamespace: "group/project",
+		},
+		{
+			desc:          "namespace already known",
+			env:           Env{NamespacePath: "group", OriginalCommand: "git-upload-pack 'group/project.git'"},
+			wantNamespace: "group",
+		},
+		{
+			desc: "missing command",
+			env:  Env{},
+		},
+		{
+			desc:      "unknown verb",
+			env:       Env{OriginalCommand: "git-frobnicate 'group/project.git'"},
+			wantError: ErrUnknownGitCommand,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			env := tc.env
+			err := env.ParseCommand()
+
+			require.ErrorIs(t, err, tc.wantError)
+			require.Equal(t, tc.wantNamespace, env.NamespacePath)
+		})
+	}
+}
+
+func TestParseCommandUnterminatedQuote(t *testing.T) {
+	env := Env{OriginalCommand: "git-upload-pack 'group/project.git"}
+
+	require.Error(t, env.ParseCommand())
+	require.Empty(t, env.NamespacePath)
+}
+
+func TestCommandArgs(t *testing.T) {
+	tests := []struct {
+		desc    string
+		command string
+		want    []string
+	}{
+		{
+			desc:    "single-quoted path with a space",
+			command: "git-receive-pack 'my group/project.git'",
+			want:    []string{"git-receive-pack", "my group/project.git"},
+		},
+		{
+			desc:    "double-quoted path with an escaped quote",
+			command: `git-upload-pack "group/pro\"ject.git"`,
+			want:    []string{"git-upload-pack", `group/pro"ject.git`},
+		},
+		{
+			desc:    "backslash-escaped space",
+			command: `git-upload-pack my\ group/project.git`,
+			want:    []string{"git-upload-pack", "my group/project.git"},
+		},
+		{
+			desc:    "quoted operator",
+			command: "git-upload-pack 'group/a;b.git'",
+			want:    []string{"git-upload-pack", "group/a;b.git"},
+		},
+		{
+			desc:    "trailing CRLF",
+			command: "git-upload-pack 'group/project.git'\r\n",
+			want:    []string{"git-upload-pack", "group/project.git"},
+		},
+		{
+			desc:    "trailing LF",
+			command: "git-upload-pack group/project.git\n",
+			want:    []string{"git-upload-pack", "group/project.git"},
+		},
+		{
+			desc:    "empty",
+			command: "",
+			want:    []string{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			args, err := Env{OriginalCommand: tc.command}.CommandArgs()
+
+			require.NoError(t, err)
+			require.Equal(t, tc.want, args)
+		})
+	}
+}
+
+func TestCommandArgsErrors(t *testing.T) {
+	_, err := Env{OriginalCommand: "git-receive-pack 'my group/project.git"}.CommandArgs()
+	require.Error(t, err)
+
+	_, err = Env{OriginalCommand: "git-upload-pack group/project.git; rm -rf /"}.CommandArgs()
+	require.ErrorIs(t, err, ErrUnsupportedShellSyntax)
+
+	_, err = Env{OriginalCommand: "git-upload-pack group/project.git\r\nrm -rf /"}.CommandArgs()
+	require.ErrorIs(t, err, ErrUnsupportedShellSyntax)
+
+	_, err = Env{OriginalCommand: "git-upload-pack 'group/project.git\r'"}.CommandArgs()
+	require.ErrorIs(t, err, ErrUnsupportedShellSyntax)
+}
+
+func TestIsProtocolV2Ready(t *testing.T) {
+	tests := []struct {
+		desc   string
+		env    Env
+		wantV2 bool
+	}{
+		{
+			desc:   "v2 upload-pack",
+			env:    Env{OriginalCommand: "git-upload-pack 'group/project.git'", ProtocolVersion: 2},
+			wantV2: true,
+		},
+		{
+			desc: "v0 upload-pack",
+			env:  Env{OriginalCommand: "git-upload-pack 'group/project.git'"},
+		},
+		{
+			desc: "v2 receive-pack",
+			env:  Env{OriginalCommand: "git-receive-pack 'group/project.git'", ProtocolVersion: 2},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			gc, err := tc.env.GitCommand()
+			require.NoError(t, err)
+
+			require.Equal(t, tc.wantV2, gc.IsProtocolV2Ready())
+		})
+	}
+}
+
+func TestGitCommandUnknownVerb(t *testing.T) {
+	env := Env{OriginalCommand: "ls -la"}
+
+	_, err := env.GitCommand()
+	require.ErrorIs(t, err, ErrUnknownGitCommand)
+}
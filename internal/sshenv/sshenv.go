@@ -2,8 +2,18 @@
 package sshenv
 
 import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/netip"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/i18n"
+	pkgsshenv "gitlab.com/gitlab-org/gitlab-shell/v14/pkg/sshenv"
 )
 
 const (
@@ -13,38 +23,471 @@ const (
 	SSHConnectionEnv = "SSH_CONNECTION"
 	// SSHOriginalCommandEnv defines the ENV containing the original SSH command
 	SSHOriginalCommandEnv = "SSH_ORIGINAL_COMMAND"
+	// SSHOriginalCommandBase64Env defines the ENV containing the original SSH
+	// command base64-encoded, as sent by some web-SSH gateways
+	SSHOriginalCommandBase64Env = "GL_ORIGINAL_COMMAND_B64"
+	// GitlabUsernameEnv defines the ENV containing the username injected by the forced command
+	GitlabUsernameEnv = "GL_USERNAME"
+	// ClientTimeoutEnv defines the ENV in which clients tell how long they
+	// wait for a command before giving up, see ParseClientTimeout
+	ClientTimeoutEnv = "GL_CLIENT_TIMEOUT"
+	// LCAllEnv, LCMessagesEnv and LangEnv define the ENV holding the locale
+	// of the client, in order of precedence, see ParseLanguage
+	LCAllEnv      = "LC_ALL"
+	LCMessagesEnv = "LC_MESSAGES"
+	LangEnv       = "LANG"
+	// ProxyRemoteAddrEnv is the default ENV consulted for the real client
+	// address when NewFromEnv is given WithProxyRemoteAddr, and the one
+	// ToSlice passes it on in
+	ProxyRemoteAddrEnv = "GITLAB_SHELL_PROXY_REMOTE"
+
+	// RemoteAddrSourceSSHConnection indicates RemoteAddr was taken from SSH_CONNECTION
+	RemoteAddrSourceSSHConnection = "ssh_connection"
+	// RemoteAddrSourceProxy indicates RemoteAddr was taken from a proxy-provided ENV
+	RemoteAddrSourceProxy = "proxy"
+
+	maxUsernameLength = 255
+
+	redacted = "[REDACTED]"
 )
 
+// ErrProtocolVersionDisabled is returned by RestrictProtocolVersion when the
+// version the client asked for can't be served
+var ErrProtocolVersionDisabled = errors.New("git protocol version disabled")
+
+// usernameRegex matches the characters GitLab allows in a username
+var usernameRegex = regexp.MustCompile(`\A[a-zA-Z0-9_.][a-zA-Z0-9_.-]*\z`)
+
 // Env represents the SSH environment variables
 type Env struct {
+	// GitProtocolVersion is the raw value of GIT_PROTOCOL
 	GitProtocolVersion string
-	IsSSHConnection    bool
-	OriginalCommand    string
-	RemoteAddr         string
-	NamespacePath      string
+	// ProtocolVersion is the normalized git protocol version: 0, 1 or 2
+	ProtocolVersion  int
+	IsSSHConnection  bool
+	OriginalCommand  string
+	RemoteAddr       string
+	RemoteAddrSource string
+	// PeerAddr is the client address from SSH_CONNECTION, i.e. the host that
+	// connected to sshd. It differs from RemoteAddr when that is taken from a
+	// proxy, and is the one SSH_CONNECTION is rebuilt from, along with RemotePort.
+	PeerAddr       string
+	RemotePort     string
+	LocalAddr      string
+	LocalPort      string
+	NamespacePath  string
+	GitlabUsername string
+	// ClientTimeout is how long the client waits for the command, 0 when it
+	// didn't tell
+	ClientTimeout time.Duration
+	// Language is the language of the messages shown to the client, "" when
+	// its locale didn't select one with translations
+	Language string
+	// Terminal is the terminal OpenSSH allocated for the session, from
+	// SSH_TTY and TERM. gitlab-sshd doesn't allocate terminals.
+	Terminal pkgsshenv.Terminal
+
+	// rawSSHConnection is SSH_CONNECTION as found by NewFromEnv
+	rawSSHConnection string
+	// proxyRemoteAddrEnv is the ENV NewFromEnv looked the proxy address up
+	// in, see WithProxyRemoteAddr
+	proxyRemoteAddrEnv string
+	// rawGitProtocol is GIT_PROTOCOL as found by NewFromEnv, which
+	// RestrictProtocolVersion leaves alone
+	rawGitProtocol string
+}
+
+type envOpts struct {
+	proxyRemoteAddrEnv string
+}
+
+// Opt configures how NewFromEnv parses the environment
+type Opt func(*envOpts)
+
+// WithProxyRemoteAddr makes NewFromEnv prefer the IP address held in the
+// named ENV over SSH_CONNECTION. This is useful when connections arrive
+// through a TCP load balancer that reports the real client address. An empty
+// name selects ProxyRemoteAddrEnv.
+func WithProxyRemoteAddr(name string) Opt {
+	return func(o *envOpts) {
+		if name == "" {
+			name = ProxyRemoteAddrEnv
+		}
+		o.proxyRemoteAddrEnv = name
+	}
 }
 
 // NewFromEnv creates a new Env instance based on the current environment variables
-func NewFromEnv() Env {
-	isSSHConnection := false
-	if ok := os.Getenv(SSHConnectionEnv); ok != "" {
-		isSSHConnection = true
+func NewFromEnv(opts ...Opt) Env {
+	o := envOpts{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	remoteAddr := remoteAddrFromEnv()
+	conn := parseSSHConnection(os.Getenv(SSHConnectionEnv))
+
+	isSSHConnection := remoteAddr != ""
+	remoteAddrSource := ""
+	if isSSHConnection {
+		remoteAddrSource = RemoteAddrSourceSSHConnection
+	}
+
+	if proxyAddr := proxyRemoteAddrFromEnv(o.proxyRemoteAddrEnv); proxyAddr != "" {
+		remoteAddr = proxyAddr
+		remoteAddrSource = RemoteAddrSourceProxy
 	}
 
 	return Env{
 		GitProtocolVersion: os.Getenv(GitProtocolEnv),
+		ProtocolVersion:    ParseProtocolVersion(os.Getenv(GitProtocolEnv)),
 		IsSSHConnection:    isSSHConnection,
-		RemoteAddr:         remoteAddrFromEnv(),
-		OriginalCommand:    os.Getenv(SSHOriginalCommandEnv),
+		RemoteAddr:         remoteAddr,
+		RemoteAddrSource:   remoteAddrSource,
+		PeerAddr:           conn.remoteAddr,
+		RemotePort:         conn.remotePort,
+		LocalAddr:          conn.localAddr,
+		LocalPort:          conn.localPort,
+		OriginalCommand:    originalCommandFromEnv(),
+		GitlabUsername:     os.Getenv(GitlabUsernameEnv),
+		ClientTimeout:      ParseClientTimeout(os.Getenv(ClientTimeoutEnv)),
+		Language:           ParseLanguage(os.Getenv),
+		Terminal:           terminalFromEnv(),
+		rawSSHConnection:   os.Getenv(SSHConnectionEnv),
+		rawGitProtocol:     os.Getenv(GitProtocolEnv),
+		proxyRemoteAddrEnv: o.proxyRemoteAddrEnv,
+	}
+}
+
+// sshConnection holds the fields of SSH_CONNECTION, which has the form
+// "clientip clientport serverip serverport"
+type sshConnection struct {
+	remoteAddr, remotePort string
+	localAddr, localPort   string
+}
+
+// parseSSHConnection splits an SSH_CONNECTION value into its fields. Missing
+// fields are left empty rather than failing.
+func parseSSHConnection(value string) sshConnection {
+	var conn sshConnection
+
+	fields := strings.Fields(value)
+	targets := []*string{&conn.remoteAddr, &conn.remotePort, &conn.localAddr, &conn.localPort}
+	for i, field := range fields {
+		if i >= len(targets) {
+			break
+		}
+		*targets[i] = field
+	}
+
+	return conn
+}
+
+// ParseProtocolVersion extracts the git protocol version from a GIT_PROTOCOL
+// value. Both the "version=N" grammar git uses (possibly among other
+// colon-separated parameters) and a bare "N" are recognized. Unknown or
+// unsupported versions are clamped to 0.
+func ParseProtocolVersion(value string) int {
+	// The other parameters are ignored, as Git does
+	protocol, _ := pkgsshenv.ParseGitProtocol(value)
+
+	return protocol.Version
+}
+
+// ParseClientTimeout parses a GL_CLIENT_TIMEOUT value: a number of seconds,
+// or a duration such as "90s" or "5m". Invalid and negative values are 0, no
+// timeout.
+func ParseClientTimeout(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return 0
+		}
+
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	return max(timeout, 0)
+}
+
+// ParseLanguage returns the language of the locale getenv holds: that of
+// LC_ALL, LC_MESSAGES or LANG, whichever is set first, as i18n.Parse returns it
+func ParseLanguage(getenv func(string) string) string {
+	for _, name := range []string{LCAllEnv, LCMessagesEnv, LangEnv} {
+		if locale := getenv(name); locale != "" {
+			return i18n.Parse(locale)
+		}
+	}
+
+	return ""
+}
+
+// RestrictProtocolVersion returns e speaking the highest of the allowed
+// versions up to the one the client asked for, none allowing any version.
+// Clients can be downgraded, not upgraded, so ErrProtocolVersionDisabled is
+// returned when every allowed version is above the one asked for. Unlike the
+// raw value, GitProtocolVersion is then rebuilt from the version alone.
+func (e Env) RestrictProtocolVersion(allowed []int) (Env, error) {
+	version := e.ProtocolVersion
+	if len(allowed) > 0 {
+		version = -1
+		for _, v := range allowed {
+			if v <= e.ProtocolVersion {
+				version = max(version, v)
+			}
+		}
+
+		if version < 0 {
+			return e, fmt.Errorf("%w: version %d", ErrProtocolVersionDisabled, e.ProtocolVersion)
+		}
+	}
+
+	e.ProtocolVersion = version
+	e.GitProtocolVersion = ""
+	if version > 0 {
+		e.GitProtocolVersion = fmt.Sprintf("version=%d", version)
+	}
+
+	return e, nil
+}
+
+// Username returns the injected GitLab username and whether it is present and
+// valid. Invalid usernames are rejected to prevent injection downstream.
+func (e Env) Username() (string, bool) {
+	if !IsValidUsername(e.GitlabUsername) {
+		return "", false
+	}
+
+	return e.GitlabUsername, true
+}
+
+// ToSlice serializes e back into the ENV it is parsed from, in the
+// "KEY=VALUE" form expected by exec.Cmd.Env. Only non-empty variables are
+// emitted, so that parsing the result with NewFromEnv yields an equivalent Env.
+func (e Env) ToSlice() []string {
+	var environ []string
+
+	add := func(key, value string) {
+		if value != "" {
+			environ = append(environ, key+"="+value)
+		}
+	}
+
+	add(GitProtocolEnv, e.GitProtocolVersion)
+	add(SSHConnectionEnv, e.sshConnection())
+	if e.RemoteAddrSource == RemoteAddrSourceProxy {
+		name := e.proxyRemoteAddrEnv
+		if name == "" {
+			name = ProxyRemoteAddrEnv
+		}
+		add(name, e.RemoteAddr)
+	}
+	add(SSHOriginalCommandEnv, e.OriginalCommand)
+	add(GitlabUsernameEnv, e.GitlabUsername)
+	if e.ClientTimeout > 0 {
+		add(ClientTimeoutEnv, e.ClientTimeout.String())
+	}
+	add(LCMessagesEnv, e.Language)
+	add(pkgsshenv.TTYEnv, e.Terminal.TTY)
+	add(pkgsshenv.TermEnv, e.Terminal.Type)
+
+	return environ
+}
+
+// ChildEnviron returns a minimal ENV, in "KEY=VALUE" form, that is safe to
+// pass to child processes such as git. Unlike ToSlice it is rebuilt from
+// validated values only: GIT_PROTOCOL is normalized, SSH_CONNECTION is only
+// emitted when its addresses and ports parse, GL_USERNAME only when it is a
+// valid username, and the original command is never passed on.
+func (e Env) ChildEnviron() []string {
+	var environ []string
+
+	if e.ProtocolVersion > 0 {
+		environ = append(environ, fmt.Sprintf("%s=version=%d", GitProtocolEnv, e.ProtocolVersion))
+	}
+
+	if conn, ok := e.sanitizedSSHConnection(); ok {
+		environ = append(environ, SSHConnectionEnv+"="+conn)
+	}
+
+	if username, ok := e.Username(); ok {
+		environ = append(environ, GitlabUsernameEnv+"="+username)
+	}
+
+	return environ
+}
+
+func (e Env) sanitizedSSHConnection() (string, bool) {
+	conn, err := e.Connection()
+	if err != nil {
+		return "", false
+	}
+
+	return conn.String(), true
+}
+
+// Connection returns the addresses of SSH_CONNECTION, validated. An error
+// wrapping pkgsshenv.ErrMissing is returned when it wasn't set.
+func (e Env) Connection() (pkgsshenv.Connection, error) {
+	return pkgsshenv.ParseConnection(e.sshConnection())
+}
+
+// sshConnection rebuilds the SSH_CONNECTION value from the fields known so
+// far; a missing field truncates the value since the format is positional.
+// The address is the peer's, which RemotePort belongs to, not a proxied one.
+func (e Env) sshConnection() string {
+	var fields []string
+
+	for _, field := range []string{e.peerAddr(), e.RemotePort, e.LocalAddr, e.LocalPort} {
+		if field == "" {
+			break
+		}
+		fields = append(fields, field)
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// RemoteAddrPort combines the address of the peer and RemotePort into a
+// netip.AddrPort. Unlike naive concatenation its String method brackets IPv6
+// addresses the way net.JoinHostPort does, e.g. "[2001:db8::1]:54321".
+func (e Env) RemoteAddrPort() (netip.AddrPort, error) {
+	peer := e.peerAddr()
+	addr, err := netip.ParseAddr(peer)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid remote address %q: %w", peer, err)
+	}
+
+	port, err := strconv.ParseUint(e.RemotePort, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid remote port %q: %w", e.RemotePort, err)
+	}
+
+	return netip.AddrPortFrom(addr, uint16(port)), nil
+}
+
+// RawSnapshot returns the values the ENV held when e was parsed, keyed on
+// their names, to help reproduce parsing issues. The arguments of the
+// original command are redacted, keeping only the command itself. The
+// namespace is included under "namespace_path".
+func (e Env) RawSnapshot() map[string]string {
+	return map[string]string{
+		SSHConnectionEnv:      e.rawSSHConnection,
+		GitProtocolEnv:        e.rawGitProtocol,
+		SSHOriginalCommandEnv: redactCommand(e.OriginalCommand),
+		GitlabUsernameEnv:     e.GitlabUsername,
+		"namespace_path":      e.NamespacePath,
+	}
+}
+
+func redactCommand(command string) string {
+	if command == "" {
+		return ""
+	}
+
+	args, err := tokenize(command)
+	if err != nil || len(args) == 0 {
+		return redacted
 	}
+
+	if len(args) == 1 {
+		return args[0]
+	}
+
+	return args[0] + " " + redacted
+}
+
+// ViaTrustedJumpHost reports whether the connection arrived from a host
+// within the trusted ranges, such as a bastion. When RemoteAddr was forwarded
+// by a proxy the proxy itself, as recorded in PeerAddr, must be trusted.
+func (e Env) ViaTrustedJumpHost(trusted []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(e.peerAddr())
+	if err != nil {
+		return false
+	}
+	// IPv4 peers may be reported in their IPv4-mapped IPv6 form
+	addr = addr.Unmap()
+
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// peerAddr returns the address of the host that connected to sshd. Envs built
+// without PeerAddr, such as gitlab-sshd's, only know it as RemoteAddr unless
+// that was forwarded by a proxy.
+func (e Env) peerAddr() string {
+	if e.PeerAddr == "" && e.RemoteAddrSource != RemoteAddrSourceProxy {
+		return e.RemoteAddr
+	}
+
+	return e.PeerAddr
+}
+
+// IsValidUsername reports whether username matches GitLab's username rules
+func IsValidUsername(username string) bool {
+	if username == "" || len(username) > maxUsernameLength {
+		return false
+	}
+
+	return usernameRegex.MatchString(username)
+}
+
+// originalCommandFromEnv returns the original command, preferring the
+// base64-encoded ENV when it holds a valid encoding
+func originalCommandFromEnv() string {
+	if encoded := os.Getenv(SSHOriginalCommandBase64Env); encoded != "" {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err == nil && !strings.ContainsRune(string(decoded), 0) {
+			return string(decoded)
+		}
+	}
+
+	return os.Getenv(SSHOriginalCommandEnv)
+}
+
+// terminalFromEnv returns the terminal of the session, none when SSH_TTY or
+// TERM are invalid
+func terminalFromEnv() pkgsshenv.Terminal {
+	terminal, err := pkgsshenv.ParseTerminal(os.Getenv(pkgsshenv.TTYEnv), os.Getenv(pkgsshenv.TermEnv))
+	if err != nil {
+		return pkgsshenv.Terminal{}
+	}
+
+	return terminal
 }
 
 // remoteAddrFromEnv returns the connection address from ENV string
 func remoteAddrFromEnv() string {
-	address := os.Getenv(SSHConnectionEnv)
+	fields := strings.Fields(os.Getenv(SSHConnectionEnv))
 
-	if address != "" {
-		return strings.Fields(address)[0]
+	if len(fields) > 0 {
+		return fields[0]
 	}
 	return ""
 }
+
+// proxyRemoteAddrFromEnv returns the address held in the named ENV if it
+// parses as an IP address
+func proxyRemoteAddrFromEnv(name string) string {
+	if name == "" {
+		return ""
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSpace(os.Getenv(name)))
+	if err != nil {
+		return ""
+	}
+
+	return addr.String()
+}
@@ -1,10 +1,15 @@
 package sshenv
 
 import (
+	"encoding/base64"
+	"net/netip"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/testhelper"
+	pkgsshenv "gitlab.com/gitlab-org/gitlab-shell/v14/pkg/sshenv"
 )
 
 func TestNewFromEnv(t *testing.T) {
@@ -15,23 +20,58 @@ func TestNewFromEnv(t *testing.T) {
 	}{
 		{
 			desc:        "It parses GIT_PROTOCOL",
-			environment: map[string]string{GitProtocolEnv: "2"},
-			want:        Env{GitProtocolVersion: "2"},
+			environment: map[string]string{GitProtocolEnv: "version=2"},
+			want:        Env{GitProtocolVersion: "version=2", ProtocolVersion: 2, rawGitProtocol: "version=2"},
 		},
 		{
 			desc:        "It parses SSH_CONNECTION",
 			environment: map[string]string{SSHConnectionEnv: "127.0.0.1 0 127.0.0.2 65535"},
-			want:        Env{IsSSHConnection: true, RemoteAddr: "127.0.0.1"},
+			want: Env{
+				IsSSHConnection:  true,
+				RemoteAddr:       "127.0.0.1",
+				RemoteAddrSource: RemoteAddrSourceSSHConnection,
+				PeerAddr:         "127.0.0.1",
+				RemotePort:       "0",
+				LocalAddr:        "127.0.0.2",
+				LocalPort:        "65535",
+				rawSSHConnection: "127.0.0.1 0 127.0.0.2 65535",
+			},
+		},
+		{
+			desc:        "It ignores a whitespace-only SSH_CONNECTION",
+			environment: map[string]string{SSHConnectionEnv: "   "},
+			want:        Env{rawSSHConnection: "   "},
 		},
 		{
 			desc:        "It parses SSH_ORIGINAL_COMMAND",
 			environment: map[string]string{SSHOriginalCommandEnv: "git-receive-pack"},
 			want:        Env{OriginalCommand: "git-receive-pack"},
 		},
+		{
+			desc:        "It parses GL_USERNAME",
+			environment: map[string]string{GitlabUsernameEnv: "alex-doe"},
+			want:        Env{GitlabUsername: "alex-doe"},
+		},
+		{
+			desc:        "It parses the language of LC_MESSAGES over LANG",
+			environment: map[string]string{LCMessagesEnv: "fr_FR.UTF-8", LangEnv: "de_DE.UTF-8"},
+			want:        Env{Language: "fr"},
+		},
+		{
+			desc:        "It parses SSH_TTY and TERM",
+			environment: map[string]string{pkgsshenv.TTYEnv: "/dev/pts/0", pkgsshenv.TermEnv: "xterm-256color"},
+			want:        Env{Terminal: pkgsshenv.Terminal{TTY: "/dev/pts/0", Type: "xterm-256color"}},
+		},
+		{
+			desc:        "It ignores an invalid TERM",
+			environment: map[string]string{pkgsshenv.TTYEnv: "/dev/pts/0", pkgsshenv.TermEnv: "xterm; rm -rf /"},
+			want:        Env{},
+		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.desc, func(t *testing.T) {
+			clearClientEnv(t)
 			testhelper.TempEnv(t, tc.environment)
 
 			require.Equal(t, tc.want, NewFromEnv())
@@ -39,6 +79,426 @@ func TestNewFromEnv(t *testing.T) {
 	}
 }
 
+func TestParseSSHConnection(t *testing.T) {
+	tests := []struct {
+		desc  string
+		value string
+		want  sshConnection
+	}{
+		{
+			desc:  "well-formed IPv4",
+			value: "192.168.1.10 54321 10.0.0.1 22",
+			want:  sshConnection{remoteAddr: "192.168.1.10", remotePort: "54321", localAddr: "10.0.0.1", localPort: "22"},
+		},
+		{
+			desc:  "well-formed IPv6",
+			value: "2001:db8::1 54321 2001:db8::2 22",
+			want:  sshConnection{remoteAddr: "2001:db8::1", remotePort: "54321", localAddr: "2001:db8::2", localPort: "22"},
+		},
+		{
+			desc:  "truncated",
+			value: "192.168.1.10 54321",
+			want:  sshConnection{remoteAddr: "192.168.1.10", remotePort: "54321"},
+		},
+		{
+			desc:  "extra fields",
+			value: "192.168.1.10 54321 10.0.0.1 22 extra",
+			want:  sshConnection{remoteAddr: "192.168.1.10", remotePort: "54321", localAddr: "10.0.0.1", localPort: "22"},
+		},
+		{
+			desc:  "empty",
+			value: "",
+			want:  sshConnection{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			require.Equal(t, tc.want, parseSSHConnection(tc.value))
+		})
+	}
+}
+
+func TestNewFromEnvWithProxyRemoteAddr(t *testing.T) {
+	tests := []struct {
+		desc        string
+		envName     string
+		environment map[string]string
+		wantAddr    string
+		wantSource  string
+	}{
+		{
+			desc:        "proxy IPv4 address is preferred",
+			environment: map[string]string{ProxyRemoteAddrEnv: "203.0.113.7", SSHConnectionEnv: "10.0.0.1 1234 10.0.0.2 22"},
+			wantAddr:    "203.0.113.7",
+			wantSource:  RemoteAddrSourceProxy,
+		},
+		{
+			desc:        "proxy IPv6 address is preferred",
+			environment: map[string]string{ProxyRemoteAddrEnv: "2001:db8::7", SSHConnectionEnv: "10.0.0.1 1234 10.0.0.2 22"},
+			wantAddr:    "2001:db8::7",
+			wantSource:  RemoteAddrSourceProxy,
+		},
+		{
+			desc:        "custom ENV name",
+			envName:     "X_REAL_IP",
+			environment: map[string]string{"X_REAL_IP": "203.0.113.8", SSHConnectionEnv: "10.0.0.1 1234 10.0.0.2 22"},
+			wantAddr:    "203.0.113.8",
+			wantSource:  RemoteAddrSourceProxy,
+		},
+		{
+			desc:        "invalid proxy address falls back to SSH_CONNECTION",
+			environment: map[string]string{ProxyRemoteAddrEnv: "not-an-ip", SSHConnectionEnv: "10.0.0.1 1234 10.0.0.2 22"},
+			wantAddr:    "10.0.0.1",
+			wantSource:  RemoteAddrSourceSSHConnection,
+		},
+		{
+			desc:        "missing proxy address falls back to SSH_CONNECTION",
+			environment: map[string]string{SSHConnectionEnv: "10.0.0.1 1234 10.0.0.2 22"},
+			wantAddr:    "10.0.0.1",
+			wantSource:  RemoteAddrSourceSSHConnection,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			testhelper.TempEnv(t, tc.environment)
+
+			env := NewFromEnv(WithProxyRemoteAddr(tc.envName))
+
+			require.Equal(t, tc.wantAddr, env.RemoteAddr)
+			require.Equal(t, tc.wantSource, env.RemoteAddrSource)
+			require.True(t, env.IsSSHConnection)
+		})
+	}
+}
+
+func TestProxyRemoteAddrKeepsThePeerInSSHConnection(t *testing.T) {
+	clearClientEnv(t)
+	t.Setenv(ProxyRemoteAddrEnv, "203.0.113.7")
+	t.Setenv(SSHConnectionEnv, "10.0.0.1 1234 10.0.0.2 22")
+
+	env := NewFromEnv(WithProxyRemoteAddr(""))
+	require.Equal(t, "203.0.113.7", env.RemoteAddr)
+	require.Equal(t, "10.0.0.1", env.PeerAddr)
+
+	conn, err := env.Connection()
+	require.NoError(t, err)
+	require.Equal(t, netip.MustParseAddrPort("10.0.0.1:1234"), conn.Client)
+
+	addrPort, err := env.RemoteAddrPort()
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.1:1234", addrPort.String())
+
+	require.Equal(t, []string{"SSH_CONNECTION=10.0.0.1 1234 10.0.0.2 22"}, env.ChildEnviron())
+	require.Equal(t, []string{
+		"SSH_CONNECTION=10.0.0.1 1234 10.0.0.2 22",
+		"GITLAB_SHELL_PROXY_REMOTE=203.0.113.7",
+	}, env.ToSlice())
+
+	t.Setenv(ProxyRemoteAddrEnv, "")
+	t.Setenv(SSHConnectionEnv, "")
+	for _, kv := range env.ToSlice() {
+		key, value, _ := strings.Cut(kv, "=")
+		t.Setenv(key, value)
+	}
+	require.Equal(t, env, NewFromEnv(WithProxyRemoteAddr("")))
+}
+
+func TestToSliceKeepsTheProxyRemoteAddrEnvName(t *testing.T) {
+	clearClientEnv(t)
+	t.Setenv("X_REAL_IP", "203.0.113.8")
+	t.Setenv(SSHConnectionEnv, "10.0.0.1 1234 10.0.0.2 22")
+
+	env := NewFromEnv(WithProxyRemoteAddr("X_REAL_IP"))
+	require.Equal(t, []string{
+		"SSH_CONNECTION=10.0.0.1 1234 10.0.0.2 22",
+		"X_REAL_IP=203.0.113.8",
+	}, env.ToSlice())
+
+	t.Setenv("X_REAL_IP", "")
+	t.Setenv(SSHConnectionEnv, "")
+	for _, kv := range env.ToSlice() {
+		key, value, _ := strings.Cut(kv, "=")
+		t.Setenv(key, value)
+	}
+	require.Equal(t, env, NewFromEnv(WithProxyRemoteAddr("X_REAL_IP")))
+}
+
+func TestNewFromEnvIgnoresProxyRemoteAddrByDefault(t *testing.T) {
+	t.Setenv(ProxyRemoteAddrEnv, "203.0.113.7")
+	t.Setenv(SSHConnectionEnv, "10.0.0.1 1234 10.0.0.2 22")
+
+	require.Equal(t, "10.0.0.1", NewFromEnv().RemoteAddr)
+}
+
+func TestRemoteAddrPort(t *testing.T) {
+	tests := []struct {
+		desc       string
+		connection string
+		want       string
+	}{
+		{desc: "IPv4", connection: "192.168.1.10 54321 10.0.0.1 22", want: "192.168.1.10:54321"},
+		{desc: "IPv6", connection: "2001:db8::1 54321 2001:db8::2 22", want: "[2001:db8::1]:54321"},
+		{desc: "IPv6 with zone", connection: "fe80::1%eth0 54321 fe80::2%eth0 22", want: "[fe80::1%eth0]:54321"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Setenv(SSHConnectionEnv, tc.connection)
+
+			addrPort, err := NewFromEnv().RemoteAddrPort()
+			require.NoError(t, err)
+			require.Equal(t, tc.want, addrPort.String())
+		})
+	}
+}
+
+func TestRemoteAddrPortErrors(t *testing.T) {
+	tests := []struct {
+		desc      string
+		env       Env
+		wantError string
+	}{
+		{desc: "missing address", env: Env{}, wantError: `invalid remote address ""`},
+		{desc: "malformed address", env: Env{RemoteAddr: "2001:db8::1:54321::", RemotePort: "22"}, wantError: `invalid remote address "2001:db8::1:54321::"`},
+		{desc: "missing port", env: Env{RemoteAddr: "192.168.1.10"}, wantError: `invalid remote port ""`},
+		{desc: "out-of-range port", env: Env{RemoteAddr: "192.168.1.10", RemotePort: "70000"}, wantError: `invalid remote port "70000"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := tc.env.RemoteAddrPort()
+			require.ErrorContains(t, err, tc.wantError)
+		})
+	}
+}
+
+func TestConnection(t *testing.T) {
+	env := Env{RemoteAddr: "2001:db8::1", RemotePort: "54321", LocalAddr: "10.0.0.2", LocalPort: "22"}
+
+	conn, err := env.Connection()
+	require.NoError(t, err)
+	require.Equal(t, netip.MustParseAddrPort("[2001:db8::1]:54321"), conn.Client)
+	require.Equal(t, netip.MustParseAddrPort("10.0.0.2:22"), conn.Server)
+
+	_, err = Env{RemoteAddr: "10.0.0.1"}.Connection()
+	require.ErrorIs(t, err, pkgsshenv.ErrMalformed)
+
+	_, err = Env{}.Connection()
+	require.ErrorIs(t, err, pkgsshenv.ErrMissing)
+}
+
+func TestRawSnapshot(t *testing.T) {
+	testhelper.TempEnv(t, map[string]string{
+		SSHConnectionEnv:      "10.0.0.1  1234 10.0.0.2 22",
+		GitProtocolEnv:        "version=2:object-format=sha256",
+		SSHOriginalCommandEnv: "git-upload-pack 'secret-group/secret-project.git'",
+	})
+
+	env := NewFromEnv()
+	env.NamespacePath = "secret-group"
+
+	require.Equal(t, map[string]string{
+		SSHConnectionEnv:      "10.0.0.1  1234 10.0.0.2 22",
+		GitProtocolEnv:        "version=2:object-format=sha256",
+		SSHOriginalCommandEnv: "git-upload-pack [REDACTED]",
+		GitlabUsernameEnv:     "",
+		"namespace_path":      "secret-group",
+	}, env.RawSnapshot())
+
+	restricted, err := env.RestrictProtocolVersion([]int{1})
+	require.NoError(t, err)
+	require.Equal(t, "version=1", restricted.GitProtocolVersion)
+	require.Equal(t, "version=2:object-format=sha256", restricted.RawSnapshot()[GitProtocolEnv])
+}
+
+func TestRawSnapshotRedactsCommand(t *testing.T) {
+	tests := map[string]string{
+		"":                                "",
+		"2fa_verify":                      "2fa_verify",
+		"git-receive-pack 'group/p.git'":  "git-receive-pack [REDACTED]",
+		"git-upload-pack 'unterminated":   "[REDACTED]",
+		"git-upload-pack x; cat /secrets": "[REDACTED]",
+	}
+
+	for command, want := range tests {
+		require.Equal(t, want, Env{OriginalCommand: command}.RawSnapshot()[SSHOriginalCommandEnv], command)
+	}
+}
+
+func TestViaTrustedJumpHost(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24"), netip.MustParsePrefix("2001:db8::/32")}
+
+	tests := []struct {
+		desc string
+		env  Env
+		want bool
+	}{
+		{desc: "trusted IPv4 peer", env: Env{PeerAddr: "10.0.0.5", RemoteAddr: "10.0.0.5"}, want: true},
+		{desc: "trusted IPv6 peer", env: Env{PeerAddr: "2001:db8::5"}, want: true},
+		{desc: "IPv4-mapped peer", env: Env{PeerAddr: "::ffff:10.0.0.5"}, want: true},
+		{desc: "untrusted peer", env: Env{PeerAddr: "192.0.2.1", RemoteAddr: "192.0.2.1"}},
+		{desc: "trusted proxy forwarding a client", env: Env{PeerAddr: "10.0.0.5", RemoteAddr: "192.0.2.1", RemoteAddrSource: RemoteAddrSourceProxy}, want: true},
+		{desc: "forwarded client without a known proxy", env: Env{RemoteAddr: "10.0.0.5", RemoteAddrSource: RemoteAddrSourceProxy}},
+		{desc: "RemoteAddr without PeerAddr", env: Env{RemoteAddr: "10.0.0.5"}, want: true},
+		{desc: "no address", env: Env{}},
+		{desc: "invalid address", env: Env{PeerAddr: "gitlab.example.com"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			require.Equal(t, tc.want, tc.env.ViaTrustedJumpHost(trusted))
+		})
+	}
+}
+
+func TestOriginalCommandFromEnv(t *testing.T) {
+	tests := []struct {
+		desc        string
+		environment map[string]string
+		want        string
+	}{
+		{
+			desc: "valid base64",
+			environment: map[string]string{
+				SSHOriginalCommandBase64Env: base64.StdEncoding.EncodeToString([]byte("git-upload-pack 'group/project.git'")),
+				SSHOriginalCommandEnv:       "git-receive-pack 'other/project.git'",
+			},
+			want: "git-upload-pack 'group/project.git'",
+		},
+		{
+			desc: "invalid base64 falls back to the plain command",
+			environment: map[string]string{
+				SSHOriginalCommandBase64Env: "!!not base64!!",
+				SSHOriginalCommandEnv:       "git-receive-pack 'other/project.git'",
+			},
+			want: "git-receive-pack 'other/project.git'",
+		},
+		{
+			desc: "base64 containing a NUL byte falls back to the plain command",
+			environment: map[string]string{
+				SSHOriginalCommandBase64Env: base64.StdEncoding.EncodeToString([]byte("git-upload-pack\x00")),
+				SSHOriginalCommandEnv:       "git-receive-pack 'other/project.git'",
+			},
+			want: "git-receive-pack 'other/project.git'",
+		},
+		{
+			desc:        "plain command only",
+			environment: map[string]string{SSHOriginalCommandEnv: "git-receive-pack 'other/project.git'"},
+			want:        "git-receive-pack 'other/project.git'",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			testhelper.TempEnv(t, tc.environment)
+
+			require.Equal(t, tc.want, NewFromEnv().OriginalCommand)
+		})
+	}
+}
+
+func TestToSlice(t *testing.T) {
+	env := Env{
+		GitProtocolVersion: "version=2",
+		RemoteAddr:         "2001:db8::1",
+		RemotePort:         "54321",
+		LocalAddr:          "2001:db8::2",
+		LocalPort:          "22",
+		OriginalCommand:    "git-upload-pack 'group/project.git'",
+	}
+
+	require.Equal(t, []string{
+		"GIT_PROTOCOL=version=2",
+		"SSH_CONNECTION=2001:db8::1 54321 2001:db8::2 22",
+		"SSH_ORIGINAL_COMMAND=git-upload-pack 'group/project.git'",
+	}, env.ToSlice())
+
+	require.Empty(t, Env{}.ToSlice())
+}
+
+func TestToSliceRoundTrip(t *testing.T) {
+	clearClientEnv(t)
+	testhelper.TempEnv(t, map[string]string{
+		GitProtocolEnv:        "version=2",
+		SSHConnectionEnv:      "10.0.0.1 54321 10.0.0.2 22",
+		SSHOriginalCommandEnv: "git-receive-pack 'my group/project.git'",
+		GitlabUsernameEnv:     "alex-doe",
+		ClientTimeoutEnv:      "90",
+		LangEnv:               "de_DE.UTF-8",
+		pkgsshenv.TTYEnv:      "/dev/pts/1",
+		pkgsshenv.TermEnv:     "screen",
+	})
+	want := NewFromEnv()
+	require.Equal(t, 90*time.Second, want.ClientTimeout)
+	require.Equal(t, "de", want.Language)
+
+	for _, key := range []string{GitProtocolEnv, SSHConnectionEnv, SSHOriginalCommandEnv, GitlabUsernameEnv, ClientTimeoutEnv, LangEnv, pkgsshenv.TTYEnv, pkgsshenv.TermEnv} {
+		t.Setenv(key, "")
+	}
+	for _, kv := range want.ToSlice() {
+		key, value, _ := strings.Cut(kv, "=")
+		t.Setenv(key, value)
+	}
+
+	require.Equal(t, want, NewFromEnv())
+}
+
+func TestChildEnviron(t *testing.T) {
+	tests := []struct {
+		desc string
+		env  Env
+		want []string
+	}{
+		{
+			desc: "all values valid",
+			env: Env{
+				GitProtocolVersion: "version=2",
+				ProtocolVersion:    2,
+				RemoteAddr:         "2001:db8::1",
+				RemotePort:         "54321",
+				LocalAddr:          "2001:db8::2",
+				LocalPort:          "22",
+				OriginalCommand:    "git-upload-pack 'group/project.git'",
+				GitlabUsername:     "alex-doe",
+			},
+			want: []string{
+				"GIT_PROTOCOL=version=2",
+				"SSH_CONNECTION=2001:db8::1 54321 2001:db8::2 22",
+				"GL_USERNAME=alex-doe",
+			},
+		},
+		{
+			desc: "injected values are dropped",
+			env: Env{
+				GitProtocolVersion: "version=2\nLD_PRELOAD=/tmp/evil.so",
+				ProtocolVersion:    0,
+				RemoteAddr:         "10.0.0.1\nFOO=bar",
+				RemotePort:         "54321",
+				LocalAddr:          "10.0.0.2",
+				LocalPort:          "22",
+				GitlabUsername:     "alex\nLD_PRELOAD=/tmp/evil.so",
+			},
+		},
+		{
+			desc: "truncated SSH_CONNECTION is dropped",
+			env:  Env{GitProtocolVersion: "2", ProtocolVersion: 2, RemoteAddr: "10.0.0.1", RemotePort: "54321"},
+			want: []string{"GIT_PROTOCOL=version=2"},
+		},
+		{
+			desc: "empty",
+			env:  Env{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			require.Equal(t, tc.want, tc.env.ChildEnviron())
+		})
+	}
+}
+
 func TestRemoteAddrFromEnv(t *testing.T) {
 	t.Setenv(SSHConnectionEnv, "127.0.0.1 0")
 
@@ -48,3 +508,189 @@ func TestRemoteAddrFromEnv(t *testing.T) {
 func TestEmptyRemoteAddrFromEnv(t *testing.T) {
 	require.Equal(t, "", remoteAddrFromEnv())
 }
+
+func TestUsername(t *testing.T) {
+	tests := []struct {
+		desc     string
+		username string
+		want     string
+		valid    bool
+	}{
+		{desc: "alphanumeric", username: "alex123", want: "alex123", valid: true},
+		{desc: "with dash, underscore and dot", username: "alex.doe_jr-2", want: "alex.doe_jr-2", valid: true},
+		{desc: "leading underscore", username: "_alex", want: "_alex", valid: true},
+		{desc: "empty", username: ""},
+		{desc: "leading dash", username: "-alex"},
+		{desc: "with space", username: "alex doe"},
+		{desc: "with shell metacharacters", username: "alex;rm -rf /"},
+		{desc: "with newline", username: "alex\nroot"},
+		{desc: "with slash", username: "alex/doe"},
+		{desc: "too long", username: strings.Repeat("a", 256)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			username, ok := Env{GitlabUsername: tc.username}.Username()
+
+			require.Equal(t, tc.valid, ok)
+			require.Equal(t, tc.want, username)
+		})
+	}
+}
+
+func TestWhitespaceRemoteAddrFromEnv(t *testing.T) {
+	t.Setenv(SSHConnectionEnv, " \t ")
+
+	require.NotPanics(t, func() {
+		require.Equal(t, "", remoteAddrFromEnv())
+	})
+}
+
+func TestParseProtocolVersion(t *testing.T) {
+	tests := []struct {
+		desc  string
+		value string
+		want  int
+	}{
+		{desc: "version=2", value: "version=2", want: 2},
+		{desc: "version=1", value: "version=1", want: 1},
+		{desc: "bare version", value: "2", want: 2},
+		{desc: "among other parameters", value: "object-format=sha256:version=2", want: 2},
+		{desc: "empty", value: "", want: 0},
+		{desc: "unsupported version", value: "version=99", want: 0},
+		{desc: "negative version", value: "version=-1", want: 0},
+		{desc: "garbage", value: "yolo; rm -rf /", want: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			require.Equal(t, tc.want, ParseProtocolVersion(tc.value))
+		})
+	}
+}
+
+func TestParseClientTimeout(t *testing.T) {
+	tests := []struct {
+		desc  string
+		value string
+		want  time.Duration
+	}{
+		{desc: "seconds", value: "90", want: 90 * time.Second},
+		{desc: "duration", value: "5m", want: 5 * time.Minute},
+		{desc: "surrounding spaces", value: " 30s ", want: 30 * time.Second},
+		{desc: "empty", value: "", want: 0},
+		{desc: "negative", value: "-5s", want: 0},
+		{desc: "negative seconds", value: "-5", want: 0},
+		{desc: "garbage", value: "soon", want: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			require.Equal(t, tc.want, ParseClientTimeout(tc.value))
+		})
+	}
+}
+
+func TestParseLanguage(t *testing.T) {
+	tests := []struct {
+		desc        string
+		environment map[string]string
+		want        string
+	}{
+		{desc: "LANG", environment: map[string]string{LangEnv: "es_ES.UTF-8"}, want: "es"},
+		{desc: "LC_ALL first", environment: map[string]string{LCAllEnv: "C", LCMessagesEnv: "de_DE", LangEnv: "fr_FR"}, want: "en"},
+		{desc: "untranslated", environment: map[string]string{LCMessagesEnv: "xx_XX", LangEnv: "de_DE"}, want: ""},
+		{desc: "unset", environment: map[string]string{}, want: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			getenv := func(name string) string { return tc.environment[name] }
+
+			require.Equal(t, tc.want, ParseLanguage(getenv))
+		})
+	}
+}
+
+// clearClientEnv unsets the locale and the terminal of the tests for those of
+// the ENV they set
+func clearClientEnv(t *testing.T) {
+	for _, name := range []string{LCAllEnv, LCMessagesEnv, LangEnv, pkgsshenv.TTYEnv, pkgsshenv.TermEnv} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestNewFromEnvKeepsRawProtocol(t *testing.T) {
+	t.Setenv(GitProtocolEnv, "version=99")
+
+	env := NewFromEnv()
+
+	require.Equal(t, "version=99", env.GitProtocolVersion)
+	require.Equal(t, 0, env.ProtocolVersion)
+}
+
+func TestRestrictProtocolVersion(t *testing.T) {
+	tests := []struct {
+		desc        string
+		env         Env
+		allowed     []int
+		wantVersion int
+		wantValue   string
+		wantErr     string
+	}{
+		{
+			desc:        "any version allowed",
+			env:         Env{GitProtocolVersion: "version=2", ProtocolVersion: 2},
+			wantVersion: 2,
+			wantValue:   "version=2",
+		},
+		{
+			desc:        "the raw value is sanitized",
+			env:         Env{GitProtocolVersion: "2\nLD_PRELOAD=/tmp/evil.so", ProtocolVersion: 2},
+			wantVersion: 2,
+			wantValue:   "version=2",
+		},
+		{
+			desc:        "version 2 disabled",
+			env:         Env{GitProtocolVersion: "version=2", ProtocolVersion: 2},
+			allowed:     []int{0, 1},
+			wantVersion: 1,
+			wantValue:   "version=1",
+		},
+		{
+			desc:        "downgraded to version 0",
+			env:         Env{GitProtocolVersion: "version=2", ProtocolVersion: 2},
+			allowed:     []int{0},
+			wantVersion: 0,
+			wantValue:   "",
+		},
+		{
+			desc:        "version 2 forced",
+			env:         Env{GitProtocolVersion: "version=2", ProtocolVersion: 2},
+			allowed:     []int{2},
+			wantVersion: 2,
+			wantValue:   "version=2",
+		},
+		{
+			desc:    "version 2 forced for a version 0 client",
+			env:     Env{},
+			allowed: []int{2},
+			wantErr: "git protocol version disabled: version 0",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			env, err := tc.env.RestrictProtocolVersion(tc.allowed)
+			if tc.wantErr != "" {
+				require.ErrorIs(t, err, ErrProtocolVersionDisabled)
+				require.EqualError(t, err, tc.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.wantVersion, env.ProtocolVersion)
+			require.Equal(t, tc.wantValue, env.GitProtocolVersion)
+		})
+	}
+}
@@ -0,0 +1,137 @@
+// Package systemd implements the socket activation and readiness
+// notification protocols of systemd, so that systemd can hold the listening
+// socket of a service across its restarts:
+// https://www.freedesktop.org/software/systemd/man/latest/sd_listen_fds.html
+// https://www.freedesktop.org/software/systemd/man/latest/sd_notify.html
+package systemd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Notification states understood by systemd
+const (
+	Ready     = "READY=1"
+	Reloading = "RELOADING=1"
+	Stopping  = "STOPPING=1"
+)
+
+// firstListenFD is the first file descriptor passed by systemd
+var firstListenFD = 3
+
+// Listener is a socket passed by systemd
+type Listener struct {
+	net.Listener
+	// Name is set by FileDescriptorName= in the socket unit
+	Name string
+}
+
+// Listeners returns the sockets systemd passed to this process, if any, and
+// unsets the environment variables that pass them so that they aren't
+// inherited by child processes
+func Listeners() ([]Listener, error) {
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	// The sockets may have been passed to the parent of this process
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %q", os.Getenv("LISTEN_FDS"))
+	}
+
+	var names []string
+	if fdNames := os.Getenv("LISTEN_FDNAMES"); fdNames != "" {
+		names = strings.Split(fdNames, ":")
+	}
+
+	listeners := make([]Listener, 0, count)
+	for i := 0; i < count; i++ {
+		fd := firstListenFD + i
+
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) {
+			name = names[i]
+		}
+
+		file := os.NewFile(uintptr(fd), name)
+		listener, err := net.FileListener(file)
+		// The listener has its own copy of the file descriptor
+		_ = file.Close()
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+
+			return nil, fmt.Errorf("invalid socket %s passed by systemd: %w", name, err)
+		}
+
+		listeners = append(listeners, Listener{Listener: listener, Name: name})
+	}
+
+	return listeners, nil
+}
+
+// Notify sends state to systemd, such as Ready once the service is started.
+// It returns false without an error when the service isn't run by systemd
+// with Type=notify.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	// A leading @ is the abstract namespace
+	addr := &net.UnixAddr{Name: socket, Net: "unixgram"}
+	if strings.HasPrefix(socket, "@") {
+		addr.Name = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		return false, fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("failed to notify systemd: %w", err)
+	}
+
+	return true, nil
+}
+
+// ErrNoListener is returned by Select when systemd passed sockets, none of
+// which is the one sought
+var ErrNoListener = errors.New("no socket passed by systemd has the expected name")
+
+// Select returns the listener named name, or the only listener if there's
+// one, and closes the others. It returns nil without an error when listeners
+// is empty.
+func Select(listeners []Listener, name string) (net.Listener, error) {
+	var selected net.Listener
+	for _, l := range listeners {
+		if selected == nil && (l.Name == name || len(listeners) == 1) {
+			selected = l.Listener
+			continue
+		}
+
+		_ = l.Close()
+	}
+
+	if selected == nil && len(listeners) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoListener, name)
+	}
+
+	return selected, nil
+}
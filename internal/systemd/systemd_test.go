@@ -0,0 +1,116 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// passListener passes a socket the way systemd does, except that it's not
+// the descriptor 3
+func passListener(t *testing.T, name string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	file, err := listener.(*net.TCPListener).File()
+	require.NoError(t, err)
+	require.NoError(t, listener.Close())
+
+	// A raw descriptor, which no *os.File closes, is handed over to Listeners
+	fd, err := syscall.Dup(int(file.Fd()))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	previous := firstListenFD
+	firstListenFD = fd
+	t.Cleanup(func() { firstListenFD = previous })
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", name)
+}
+
+func TestListeners(t *testing.T) {
+	passListener(t, "ssh")
+
+	listeners, err := Listeners()
+	require.NoError(t, err)
+	require.Len(t, listeners, 1)
+	require.Equal(t, "ssh", listeners[0].Name)
+
+	require.Empty(t, os.Getenv("LISTEN_FDS"), "the sockets shouldn't be passed on")
+
+	listener, err := Select(listeners, "ssh")
+	require.NoError(t, err)
+	require.Equal(t, listeners[0].Listener, listener)
+
+	// The listener still accepts connections
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	require.NoError(t, listener.Close())
+}
+
+func TestListenersForAnotherProcess(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+
+	listeners, err := Listeners()
+	require.NoError(t, err)
+	require.Empty(t, listeners)
+}
+
+func TestSelect(t *testing.T) {
+	listener := func(name string) Listener {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		return Listener{Listener: l, Name: name}
+	}
+
+	selected, err := Select(nil, "ssh")
+	require.NoError(t, err)
+	require.Nil(t, selected)
+
+	only := listener("gitlab-sshd.socket")
+	selected, err = Select([]Listener{only}, "ssh")
+	require.NoError(t, err)
+	require.Equal(t, only.Listener, selected)
+	require.NoError(t, selected.Close())
+
+	_, err = Select([]Listener{listener("web"), listener("metrics")}, "ssh")
+	require.ErrorIs(t, err, ErrNoListener)
+}
+
+func TestNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	sent, err := Notify(Ready)
+	require.NoError(t, err)
+	require.True(t, sent)
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, Ready, string(buf[:n]))
+}
+
+func TestNotifyWithoutSystemd(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+
+	sent, err := Notify(Ready)
+	require.NoError(t, err)
+	require.False(t, sent)
+}
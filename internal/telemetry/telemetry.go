@@ -0,0 +1,59 @@
+// Package telemetry exports OpenTelemetry traces of SSH connections, which
+// are propagated to the internal API and Gitaly as W3C trace context
+package telemetry
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+const tracerName = "gitlab.com/gitlab-org/gitlab-shell/v14/internal/telemetry"
+
+// Initialize configures the global OpenTelemetry tracer provider to export
+// spans to the collector at cfg.Endpoint. Nothing is exported or propagated
+// when no endpoint is configured. The returned function flushes and stops
+// the exporter.
+func Initialize(ctx context.Context, serviceName string, cfg config.OpenTelemetryConfig) (func(context.Context) error, error) {
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SamplingRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider.Shutdown, nil
+}
+
+// StartConnectionSpan starts the span covering an SSH connection, from which
+// the spans of the requests made on its behalf derive
+func StartConnectionSpan(ctx context.Context, remoteAddr string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, "sshd.connection",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("net.sock.peer.addr", remoteAddr)),
+	)
+}
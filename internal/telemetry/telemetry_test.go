@@ -0,0 +1,96 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func resetGlobals(t *testing.T) {
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	})
+}
+
+func TestDisabledByDefault(t *testing.T) {
+	resetGlobals(t)
+
+	shutdown, err := Initialize(context.Background(), "gitlab-sshd", config.DefaultOpenTelemetryConfig)
+	require.NoError(t, err)
+	require.NoError(t, shutdown(context.Background()))
+
+	_, span := StartConnectionSpan(context.Background(), "127.0.0.1:2222")
+	defer span.End()
+
+	require.False(t, span.SpanContext().IsValid())
+}
+
+func TestTracePropagation(t *testing.T) {
+	resetGlobals(t)
+
+	var exported atomic.Int32
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/traces", r.URL.Path)
+		exported.Add(1)
+	}))
+	t.Cleanup(collector.Close)
+
+	var traceparent string
+	api := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	t.Cleanup(api.Close)
+
+	shutdown, err := Initialize(context.Background(), "gitlab-sshd", config.OpenTelemetryConfig{
+		Endpoint:      collector.Listener.Addr().String(),
+		Insecure:      true,
+		SamplingRatio: 1,
+	})
+	require.NoError(t, err)
+
+	ctx, span := StartConnectionSpan(context.Background(), "127.0.0.1:2222")
+	require.True(t, span.SpanContext().IsSampled())
+
+	httpClient, err := client.NewHTTPClientWithOpts(api.URL, "", "", "", 1, nil)
+	require.NoError(t, err)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, api.URL, nil)
+	require.NoError(t, err)
+	resp, err := httpClient.RetryableHTTP.HTTPClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	spanContext := span.SpanContext()
+	require.Equal(t, "00-"+spanContext.TraceID().String()+"-"+spanContext.SpanID().String()+"-01", traceparent)
+
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+	require.Equal(t, int32(1), exported.Load())
+}
+
+func TestSamplingRatio(t *testing.T) {
+	resetGlobals(t)
+
+	shutdown, err := Initialize(context.Background(), "gitlab-sshd", config.OpenTelemetryConfig{
+		Endpoint:      "localhost:4318",
+		SamplingRatio: 0,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = shutdown(context.Background()) })
+
+	_, span := StartConnectionSpan(context.Background(), "127.0.0.1:2222")
+	defer span.End()
+
+	require.True(t, span.SpanContext().IsValid())
+	require.False(t, span.SpanContext().IsSampled())
+}
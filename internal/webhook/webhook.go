@@ -0,0 +1,242 @@
+// Package webhook notifies external services, such as a SIEM, of the SSH
+// sessions that ended, by posting a JSON summary of each to webhook URLs.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/auditlog"
+)
+
+const (
+	// SignatureHeader carries the HMAC-SHA256 of the body, as
+	// "sha256=<hex>", when the notifier has a secret
+	SignatureHeader = "X-Gitlab-Shell-Signature"
+	// EventHeader names the event of the body
+	EventHeader = "X-Gitlab-Shell-Event"
+
+	sessionEvent = "session"
+
+	// bufferSize is the number of events queued for a URL while it's slow
+	// before further events are dropped
+	bufferSize = 1024
+	// closeTimeout bounds how long Close waits for queued events to be sent
+	closeTimeout = 5 * time.Second
+	// initialBackoff is the wait before the first retry, doubled for each
+	// next one
+	initialBackoff = time.Second
+)
+
+// Defaults of Options
+const (
+	DefaultMaxAttempts = 3
+	DefaultTimeout     = 10 * time.Second
+)
+
+// Event is the summary of a session posted to the webhooks
+type Event struct {
+	Time       time.Time `json:"time"`
+	Username   string    `json:"username,omitempty"`
+	KeyID      string    `json:"key_id,omitempty"`
+	RemoteIP   string    `json:"remote_ip"`
+	Command    string    `json:"command"`
+	Repository string    `json:"repository,omitempty"`
+	BytesIn    int64     `json:"bytes_in"`
+	BytesOut   int64     `json:"bytes_out"`
+	DurationS  float64   `json:"duration_s"`
+	Result     string    `json:"result"`
+	ExitStatus uint32    `json:"exit_status"`
+}
+
+// NewEvent summarizes the audit record of a session
+func NewEvent(record auditlog.Record) Event {
+	return Event{
+		Time:       record.Time,
+		Username:   record.Username,
+		KeyID:      record.KeyID,
+		RemoteIP:   record.RemoteIP,
+		Command:    record.Command,
+		Repository: record.Repository,
+		BytesIn:    record.BytesIn,
+		BytesOut:   record.BytesOut,
+		DurationS:  record.DurationS,
+		Result:     record.Result,
+		ExitStatus: record.ExitStatus,
+	}
+}
+
+// Notifier posts events to its URLs in the background, so that a slow or
+// failing webhook can't stall SSH sessions, nor the other webhooks. An event
+// is retried up to MaxAttempts times on network errors, 429 and 5xx
+// responses, and dropped after that or if it doesn't fit in the buffer.
+type Notifier struct {
+	hooks   []*hook
+	wg      sync.WaitGroup
+	failed  atomic.Int64
+	backoff time.Duration
+
+	closeOnce sync.Once
+}
+
+type hook struct {
+	url    string
+	events chan []byte
+}
+
+// Options configure a Notifier. Zero values take the defaults.
+type Options struct {
+	// Secret signs the bodies, see SignatureHeader
+	Secret      []byte
+	MaxAttempts int
+	Timeout     time.Duration
+}
+
+// New returns a Notifier posting to urls
+func New(urls []string, opts Options) *Notifier {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	n := &Notifier{backoff: initialBackoff}
+	client := &http.Client{Timeout: opts.Timeout}
+
+	for _, url := range urls {
+		h := &hook{url: url, events: make(chan []byte, bufferSize)}
+		n.hooks = append(n.hooks, h)
+
+		n.wg.Add(1)
+		go n.run(h, client, opts)
+	}
+
+	return n
+}
+
+func (n *Notifier) run(h *hook, client *http.Client, opts Options) {
+	defer n.wg.Done()
+
+	for body := range h.events {
+		if err := n.post(h.url, client, body, opts); err != nil {
+			n.failed.Add(1)
+			log.WithFields(log.Fields{"url": h.url}).WithError(err).Warn("webhook: failed to post session event")
+		}
+	}
+}
+
+// post sends body to url, retrying with an exponential backoff
+func (n *Notifier) post(url string, client *http.Client, body []byte, opts Options) error {
+	backoff := n.backoff
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retryable bool
+		if retryable, err = send(client, url, body, opts.Secret); err == nil || !retryable || attempt >= opts.MaxAttempts {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// send posts body once, and reports whether a failure may be retried
+func send(client *http.Client, url string, body []byte, secret []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, sessionEvent)
+	if len(secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return false, nil
+	}
+
+	retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+
+	return retryable, fmt.Errorf("webhook returned %s", resp.Status)
+}
+
+// Sign returns the signature of body with secret, as sent in SignatureHeader
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notify queues event for each URL without blocking. It is a no-op on a nil
+// Notifier, so callers needn't check whether webhooks are enabled.
+func (n *Notifier) Notify(event Event) {
+	if n == nil {
+		return
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		n.failed.Add(int64(len(n.hooks)))
+		return
+	}
+
+	for _, h := range n.hooks {
+		select {
+		case h.events <- body:
+		default:
+			n.failed.Add(1)
+		}
+	}
+}
+
+// Failed returns the number of events, by URL, that couldn't be posted
+func (n *Notifier) Failed() int64 {
+	return n.failed.Load()
+}
+
+// Close sends the queued events, waiting up to closeTimeout. Notify must not
+// be called after Close.
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+
+	n.closeOnce.Do(func() {
+		for _, h := range n.hooks {
+			close(h.events)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			n.wg.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(closeTimeout):
+		}
+	})
+}
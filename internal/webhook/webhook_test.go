@@ -0,0 +1,129 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/auditlog"
+)
+
+var testRecord = auditlog.Record{
+	Sequence:   7,
+	Time:       time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	Username:   "alex-doe",
+	KeyID:      "1000",
+	RemoteIP:   "203.0.113.7",
+	Command:    "git-receive-pack",
+	Repository: "group/project",
+	BytesIn:    2048,
+	BytesOut:   512,
+	DurationS:  1.5,
+	Result:     auditlog.ResultSuccess,
+	PrevHash:   "abc",
+	Hash:       "def",
+}
+
+func TestNotify(t *testing.T) {
+	secret := []byte("webhook-secret")
+	bodies := make(chan []byte, 2)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.Equal(t, "session", r.Header.Get(EventHeader))
+		require.Equal(t, Sign(secret, body), r.Header.Get(SignatureHeader))
+
+		bodies <- body
+	})
+
+	first, second := httptest.NewServer(handler), httptest.NewServer(handler)
+	defer first.Close()
+	defer second.Close()
+
+	n := New([]string{first.URL, second.URL}, Options{Secret: secret})
+	n.Notify(NewEvent(testRecord))
+	n.Close()
+
+	require.Len(t, bodies, 2)
+	for i := 0; i < 2; i++ {
+		var event map[string]any
+		require.NoError(t, json.Unmarshal(<-bodies, &event))
+
+		require.Equal(t, map[string]any{
+			"time":        "2024-05-01T12:00:00Z",
+			"username":    "alex-doe",
+			"key_id":      "1000",
+			"remote_ip":   "203.0.113.7",
+			"command":     "git-receive-pack",
+			"repository":  "group/project",
+			"bytes_in":    float64(2048),
+			"bytes_out":   float64(512),
+			"duration_s":  1.5,
+			"result":      "success",
+			"exit_status": float64(0),
+		}, event)
+	}
+	require.Zero(t, n.Failed())
+}
+
+func TestNotifyRetries(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		statuses         []int
+		expectedAttempts int32
+		expectedFailed   int64
+	}{
+		{
+			desc:             "a transient failure",
+			statuses:         []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK},
+			expectedAttempts: 3,
+		},
+		{
+			desc:             "too many failures",
+			statuses:         []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusOK},
+			expectedAttempts: 3,
+			expectedFailed:   1,
+		},
+		{
+			desc:             "a client error",
+			statuses:         []int{http.StatusBadRequest, http.StatusOK},
+			expectedAttempts: 1,
+			expectedFailed:   1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tc.statuses[attempts.Add(1)-1])
+			}))
+			defer server.Close()
+
+			n := New([]string{server.URL}, Options{})
+			n.backoff = time.Millisecond
+			n.Notify(NewEvent(testRecord))
+			n.Close()
+
+			require.Equal(t, tc.expectedAttempts, attempts.Load())
+			require.Equal(t, tc.expectedFailed, n.Failed())
+		})
+	}
+}
+
+func TestNilNotifier(t *testing.T) {
+	var n *Notifier
+
+	n.Notify(NewEvent(testRecord))
+	n.Close()
+}
@@ -0,0 +1,110 @@
+// Package gitlabshell runs the commands of gitlab-shell, such as discover or
+// git-upload-pack, within another program rather than by executing the
+// gitlab-shell binary
+package gitlabshell
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	shellCmd "gitlab.com/gitlab-org/gitlab-shell/v14/cmd/gitlab-shell/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disallowedcommand"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+)
+
+// ErrDisallowedCommand is returned for the commands that gitlab-shell doesn't
+// run, or that aren't allowed by its configuration
+var ErrDisallowedCommand = disallowedcommand.Error
+
+// ErrNoUser is returned for an Env naming neither a key nor a user
+var ErrNoUser = errors.New("gitlabshell: a KeyID or Username is required")
+
+// Config is the configuration of gitlab-shell, as read from its config.yml
+type Config struct {
+	cfg *config.Config
+}
+
+// LoadConfig reads the config.yml in dir, along with the secret it refers to
+func LoadConfig(dir string) (*Config, error) {
+	cfg, err := config.NewFromDirExternal(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Config{cfg: cfg}, nil
+}
+
+// Env describes a command and the client it is run for. Exactly one of KeyID
+// and Username identifies the user.
+type Env struct {
+	// Command is the command and its arguments, as found in
+	// SSH_ORIGINAL_COMMAND, e.g. "git-upload-pack 'group/project.git'"
+	Command string
+	// KeyID is the ID of the SSH key of the user in GitLab
+	KeyID string
+	// Username is the GitLab username of the user
+	Username string
+	// GitProtocol is the value of GIT_PROTOCOL, e.g. "version=2"
+	GitProtocol string
+	// RemoteAddr is the IP address of the client
+	RemoteAddr string
+}
+
+// Runner runs the commands of gitlab-shell with a Config. Its methods can be
+// called concurrently, the connections to Gitaly being shared by commands.
+type Runner struct {
+	cfg *config.Config
+}
+
+// NewRunner returns a Runner using cfg, whose Gitaly sidechannels are logged
+// with the logger of ctx
+func NewRunner(ctx context.Context, cfg *Config) *Runner {
+	cfg.cfg.GitalyClient.InitSidechannelRegistry(ctx)
+
+	return &Runner{cfg: cfg.cfg}
+}
+
+// Run runs the command of env until it completes or ctx is done. The command
+// reads the input of the client from stdin, and writes its output to stdout
+// and its messages to stderr. The error it fails with is returned rather than
+// shown to the client.
+func (r *Runner) Run(ctx context.Context, env Env, stdin io.Reader, stdout, stderr io.Writer) error {
+	cmd, err := r.command(env, &readwriter.ReadWriter{In: stdin, Out: stdout, ErrOut: stderr})
+	if err != nil {
+		return err
+	}
+
+	_, err = cmd.Execute(ctx)
+
+	return err
+}
+
+func (r *Runner) command(env Env, rw *readwriter.ReadWriter) (command.Command, error) {
+	sshEnv := sshenv.Env{
+		IsSSHConnection:    true,
+		OriginalCommand:    env.Command,
+		GitProtocolVersion: env.GitProtocol,
+		ProtocolVersion:    sshenv.ParseProtocolVersion(env.GitProtocol),
+		RemoteAddr:         env.RemoteAddr,
+	}
+
+	switch {
+	case env.KeyID != "" && env.Username != "":
+		return nil, errors.New("gitlabshell: KeyID and Username can't be set together")
+	case env.Username != "":
+		return shellCmd.NewWithUsername(env.Username, sshEnv, r.cfg, rw)
+	case env.KeyID != "":
+		return shellCmd.NewWithKey(env.KeyID, sshEnv, r.cfg, rw)
+	default:
+		return nil, ErrNoUser
+	}
+}
+
+// Close closes the connections to Gitaly, once the commands are done
+func (r *Runner) Close() {
+	r.cfg.GitalyClient.Close()
+}
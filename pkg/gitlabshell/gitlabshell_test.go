@@ -0,0 +1,98 @@
+package gitlabshell
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+)
+
+func setup(t *testing.T) *Runner {
+	t.Helper()
+
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/discover",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("key_id") == "1" || r.URL.Query().Get("username") == "alex-doe" {
+					json.NewEncoder(w).Encode(map[string]interface{}{"id": 2, "username": "alex-doe", "name": "Alex Doe"})
+					return
+				}
+
+				w.Write([]byte("null"))
+			},
+		},
+	}
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	dir := t.TempDir()
+	data := "gitlab_url: " + url + "\nsecret: \"0123456789abcdef\"\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yml"), []byte(data), 0o600))
+
+	cfg, err := LoadConfig(dir)
+	require.NoError(t, err)
+
+	runner := NewRunner(context.Background(), cfg)
+	t.Cleanup(runner.Close)
+
+	return runner
+}
+
+func TestRun(t *testing.T) {
+	runner := setup(t)
+
+	testCases := []struct {
+		desc     string
+		env      Env
+		expected string
+	}{
+		{
+			desc:     "with a key",
+			env:      Env{Command: "discover", KeyID: "1", RemoteAddr: "192.0.2.1"},
+			expected: "Welcome to GitLab, @alex-doe!\n",
+		},
+		{
+			desc:     "with a username",
+			env:      Env{Username: "alex-doe"},
+			expected: "Welcome to GitLab, @alex-doe!\n",
+		},
+		{
+			desc:     "with an unknown key",
+			env:      Env{KeyID: "2"},
+			expected: "Welcome to GitLab, Anonymous!\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+
+			require.NoError(t, runner.Run(context.Background(), tc.env, &bytes.Buffer{}, stdout, stderr))
+			require.Equal(t, tc.expected, stdout.String())
+			require.Empty(t, stderr.String())
+		})
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	runner := setup(t)
+	run := func(env Env) error {
+		return runner.Run(context.Background(), env, &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{})
+	}
+
+	require.ErrorIs(t, run(Env{Command: "git-unknown-command", KeyID: "1"}), ErrDisallowedCommand)
+	require.ErrorIs(t, run(Env{Command: "discover"}), ErrNoUser)
+	require.EqualError(t, run(Env{KeyID: "1", Username: "alex-doe"}), "gitlabshell: KeyID and Username can't be set together")
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	_, err := LoadConfig(t.TempDir())
+	require.Error(t, err)
+}
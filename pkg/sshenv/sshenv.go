@@ -0,0 +1,253 @@
+// Package sshenv parses the environment sshd sets for the commands it runs,
+// such as SSH_CONNECTION and GIT_PROTOCOL. Values are validated rather than
+// taken as they come, and those that don't parse are reported with an *Error.
+package sshenv
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Variables parsed by the package
+const (
+	// ConnectionEnv holds the addresses of the connection, as
+	// "clientip clientport serverip serverport"
+	ConnectionEnv = "SSH_CONNECTION"
+	// GitProtocolEnv holds the parameters of the Git protocol asked for by
+	// the client, e.g. "version=2"
+	GitProtocolEnv = "GIT_PROTOCOL"
+	// TTYEnv holds the path of the terminal allocated for the session
+	TTYEnv = "SSH_TTY"
+	// TermEnv holds the type of the terminal of the client
+	TermEnv = "TERM"
+	// OriginalCommandEnv holds the command the client asked for, when sshd
+	// runs a forced command instead
+	OriginalCommandEnv = "SSH_ORIGINAL_COMMAND"
+)
+
+// gitProtocolVersionParam is the only GIT_PROTOCOL parameter allowed, with
+// the versions up to maxGitProtocolVersion
+const (
+	gitProtocolVersionParam = "version"
+	maxGitProtocolVersion   = 2
+)
+
+var (
+	// ErrMissing is wrapped by the errors of the variables that aren't set
+	ErrMissing = errors.New("not set")
+	// ErrMalformed is wrapped by the errors of the values that don't parse
+	ErrMalformed = errors.New("malformed")
+	// ErrNotAllowed is wrapped by the errors of the values that parse, but
+	// aren't allowed, such as an unknown Git protocol version
+	ErrNotAllowed = errors.New("not allowed")
+)
+
+// termRegex matches the terminal types, e.g. xterm-256color
+var termRegex = regexp.MustCompile(`\A[a-zA-Z0-9][a-zA-Z0-9._+-]*\z`)
+
+// Error is returned for a variable whose value doesn't parse. Err wraps
+// ErrMissing, ErrMalformed or ErrNotAllowed.
+type Error struct {
+	Name  string
+	Value string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("sshenv: %s %q: %v", e.Name, e.Value, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Env is the environment of a command run by sshd
+type Env struct {
+	Connection      Connection
+	GitProtocol     GitProtocol
+	Terminal        Terminal
+	OriginalCommand string
+}
+
+// Parse parses the environment getenv returns, such as os.Getenv. Every
+// variable is parsed: the errors of those that don't parse are joined, and
+// their fields left zero, but for the valid version of GIT_PROTOCOL. A
+// missing SSH_CONNECTION, when the command wasn't run by sshd, is reported as
+// ErrMissing.
+func Parse(getenv func(string) string) (Env, error) {
+	var errs []error
+
+	conn, err := ParseConnection(getenv(ConnectionEnv))
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	// The version is kept when other parameters are invalid, as Git does
+	protocol, err := ParseGitProtocol(getenv(GitProtocolEnv))
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	terminal, err := ParseTerminal(getenv(TTYEnv), getenv(TermEnv))
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	env := Env{
+		Connection:      conn,
+		GitProtocol:     protocol,
+		Terminal:        terminal,
+		OriginalCommand: getenv(OriginalCommandEnv),
+	}
+
+	return env, errors.Join(errs...)
+}
+
+// Connection holds the addresses of an SSH connection
+type Connection struct {
+	Client netip.AddrPort
+	Server netip.AddrPort
+}
+
+// ParseConnection parses an SSH_CONNECTION value, which must have its four
+// fields. IPv6 addresses are written without brackets, as sshd does.
+func ParseConnection(value string) (Connection, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return Connection{}, &Error{Name: ConnectionEnv, Value: value, Err: ErrMissing}
+	}
+
+	if len(fields) != 4 {
+		err := fmt.Errorf("%w: %d fields instead of 4", ErrMalformed, len(fields))
+		return Connection{}, &Error{Name: ConnectionEnv, Value: value, Err: err}
+	}
+
+	client, err := ParseAddrPort(fields[0], fields[1])
+	if err != nil {
+		return Connection{}, &Error{Name: ConnectionEnv, Value: value, Err: fmt.Errorf("client: %w", err)}
+	}
+
+	server, err := ParseAddrPort(fields[2], fields[3])
+	if err != nil {
+		return Connection{}, &Error{Name: ConnectionEnv, Value: value, Err: fmt.Errorf("server: %w", err)}
+	}
+
+	return Connection{Client: client, Server: server}, nil
+}
+
+// String returns c as an SSH_CONNECTION value
+func (c Connection) String() string {
+	return fmt.Sprintf("%s %d %s %d", c.Client.Addr(), c.Client.Port(), c.Server.Addr(), c.Server.Port())
+}
+
+// ParseAddrPort combines an address and a port, as found separately in
+// SSH_CONNECTION, into a netip.AddrPort. The errors wrap ErrMalformed.
+func ParseAddrPort(addr, port string) (netip.AddrPort, error) {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("%w address %q: %w", ErrMalformed, addr, err)
+	}
+
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("%w port %q: %w", ErrMalformed, port, err)
+	}
+
+	return netip.AddrPortFrom(ip, uint16(n)), nil
+}
+
+// GitProtocol holds the parameters of GIT_PROTOCOL
+type GitProtocol struct {
+	// Version is the version of the Git protocol, 0, 1 or 2
+	Version int
+}
+
+// ParseGitProtocol parses a GIT_PROTOCOL value, colon-separated parameters
+// of which only "version=N" is allowed, N being 0, 1 or 2. A bare "N" is
+// taken as a version too. The highest version is used, as Git does.
+//
+// The other parameters are reported in the error, but don't prevent the
+// version from being parsed: it's returned along with the error, 0 when none
+// is valid, for the callers that ignore invalid parameters like Git.
+func ParseGitProtocol(value string) (GitProtocol, error) {
+	var protocol GitProtocol
+	var errs []error
+
+	for _, param := range strings.Split(value, ":") {
+		param = strings.TrimSpace(param)
+		if param == "" {
+			continue
+		}
+
+		name, version, ok := strings.Cut(param, "=")
+		if !ok {
+			name, version = gitProtocolVersionParam, param
+		}
+
+		if name != gitProtocolVersionParam {
+			errs = append(errs, fmt.Errorf("%w parameter %q", ErrNotAllowed, name))
+			continue
+		}
+
+		n, err := strconv.Atoi(version)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w version %q", ErrMalformed, version))
+			continue
+		}
+
+		if n < 0 || n > maxGitProtocolVersion {
+			errs = append(errs, fmt.Errorf("%w version %d", ErrNotAllowed, n))
+			continue
+		}
+
+		protocol.Version = max(protocol.Version, n)
+	}
+
+	if len(errs) > 0 {
+		return protocol, &Error{Name: GitProtocolEnv, Value: value, Err: errors.Join(errs...)}
+	}
+
+	return protocol, nil
+}
+
+// String returns p as a GIT_PROTOCOL value, empty for version 0
+func (p GitProtocol) String() string {
+	if p.Version == 0 {
+		return ""
+	}
+
+	return gitProtocolVersionParam + "=" + strconv.Itoa(p.Version)
+}
+
+// Terminal is the terminal sshd allocated for a session, when the client
+// asked for one
+type Terminal struct {
+	// TTY is the path of the terminal, e.g. /dev/pts/0
+	TTY string
+	// Type is the type of the terminal, e.g. xterm-256color
+	Type string
+}
+
+// ParseTerminal parses SSH_TTY and TERM values. The TTY must be an absolute
+// path, and the type a terminal name.
+func ParseTerminal(tty, term string) (Terminal, error) {
+	if tty != "" && !strings.HasPrefix(tty, "/") {
+		return Terminal{}, &Error{Name: TTYEnv, Value: tty, Err: fmt.Errorf("%w: not an absolute path", ErrMalformed)}
+	}
+
+	if term != "" && !termRegex.MatchString(term) {
+		return Terminal{}, &Error{Name: TermEnv, Value: term, Err: ErrMalformed}
+	}
+
+	return Terminal{TTY: tty, Type: term}, nil
+}
+
+// Interactive reports whether the session has a terminal, for commands to
+// prompt the user rather than expect their input to be piped
+func (t Terminal) Interactive() bool {
+	return t.TTY != ""
+}
@@ -0,0 +1,143 @@
+package sshenv
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConnection(t *testing.T) {
+	tests := []struct {
+		desc      string
+		value     string
+		want      Connection
+		wantError error
+	}{
+		{
+			desc:  "IPv4",
+			value: "192.168.1.10 54321 10.0.0.1 22",
+			want:  Connection{Client: netip.MustParseAddrPort("192.168.1.10:54321"), Server: netip.MustParseAddrPort("10.0.0.1:22")},
+		},
+		{
+			desc:  "IPv6 with extra whitespace",
+			value: " 2001:db8::1  54321 2001:db8::2 22\n",
+			want:  Connection{Client: netip.MustParseAddrPort("[2001:db8::1]:54321"), Server: netip.MustParseAddrPort("[2001:db8::2]:22")},
+		},
+		{desc: "empty", value: " ", wantError: ErrMissing},
+		{desc: "missing fields", value: "192.168.1.10 54321", wantError: ErrMalformed},
+		{desc: "extra fields", value: "192.168.1.10 54321 10.0.0.1 22 extra", wantError: ErrMalformed},
+		{desc: "invalid address", value: "gitlab.example.com 54321 10.0.0.1 22", wantError: ErrMalformed},
+		{desc: "out-of-range port", value: "192.168.1.10 54321 10.0.0.1 70000", wantError: ErrMalformed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			conn, err := ParseConnection(tc.value)
+
+			require.ErrorIs(t, err, tc.wantError)
+			require.Equal(t, tc.want, conn)
+
+			var envErr *Error
+			if tc.wantError != nil {
+				require.ErrorAs(t, err, &envErr)
+				require.Equal(t, ConnectionEnv, envErr.Name)
+				require.Equal(t, tc.value, envErr.Value)
+			}
+		})
+	}
+}
+
+func TestConnectionString(t *testing.T) {
+	conn, err := ParseConnection("2001:db8::1 54321 10.0.0.1 22")
+	require.NoError(t, err)
+	require.Equal(t, "2001:db8::1 54321 10.0.0.1 22", conn.String())
+}
+
+func TestParseGitProtocol(t *testing.T) {
+	tests := []struct {
+		desc      string
+		value     string
+		want      GitProtocol
+		wantError error
+	}{
+		{desc: "empty", value: ""},
+		{desc: "version=2", value: "version=2", want: GitProtocol{Version: 2}},
+		{desc: "bare version", value: "1", want: GitProtocol{Version: 1}},
+		{desc: "highest version", value: "version=2:version=1", want: GitProtocol{Version: 2}},
+		{desc: "unknown parameter", value: "object-format=sha256:version=2", want: GitProtocol{Version: 2}, wantError: ErrNotAllowed},
+		{desc: "unsupported version", value: "version=3", wantError: ErrNotAllowed},
+		{desc: "negative version", value: "version=-1", wantError: ErrNotAllowed},
+		{desc: "garbage", value: "yolo; rm -rf /", wantError: ErrMalformed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			protocol, err := ParseGitProtocol(tc.value)
+
+			require.ErrorIs(t, err, tc.wantError)
+			require.Equal(t, tc.want, protocol)
+		})
+	}
+}
+
+func TestGitProtocolString(t *testing.T) {
+	require.Equal(t, "", GitProtocol{}.String())
+	require.Equal(t, "version=2", GitProtocol{Version: 2}.String())
+}
+
+func TestParseTerminal(t *testing.T) {
+	terminal, err := ParseTerminal("/dev/pts/0", "xterm-256color")
+	require.NoError(t, err)
+	require.True(t, terminal.Interactive())
+
+	terminal, err = ParseTerminal("", "xterm-256color")
+	require.NoError(t, err)
+	require.False(t, terminal.Interactive())
+
+	_, err = ParseTerminal("pts/0", "")
+	require.ErrorIs(t, err, ErrMalformed)
+
+	_, err = ParseTerminal("/dev/pts/0", "xterm\x1b[31m")
+	require.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestParse(t *testing.T) {
+	environment := map[string]string{
+		ConnectionEnv:      "192.168.1.10 54321 10.0.0.1 22",
+		GitProtocolEnv:     "version=2",
+		TTYEnv:             "/dev/pts/0",
+		TermEnv:            "xterm",
+		OriginalCommandEnv: "git-upload-pack 'group/project.git'",
+	}
+	getenv := func(name string) string { return environment[name] }
+
+	env, err := Parse(getenv)
+	require.NoError(t, err)
+	require.Equal(t, Env{
+		Connection:      Connection{Client: netip.MustParseAddrPort("192.168.1.10:54321"), Server: netip.MustParseAddrPort("10.0.0.1:22")},
+		GitProtocol:     GitProtocol{Version: 2},
+		Terminal:        Terminal{TTY: "/dev/pts/0", Type: "xterm"},
+		OriginalCommand: "git-upload-pack 'group/project.git'",
+	}, env)
+
+	environment[GitProtocolEnv] = "version=2:unknown=1"
+	delete(environment, ConnectionEnv)
+
+	env, err = Parse(getenv)
+	require.ErrorIs(t, err, ErrMissing)
+	require.ErrorIs(t, err, ErrNotAllowed)
+	require.Equal(t, GitProtocol{Version: 2}, env.GitProtocol, "the valid version is kept")
+	require.Equal(t, Connection{}, env.Connection, "the fields of invalid variables are zero")
+	require.Equal(t, Terminal{TTY: "/dev/pts/0", Type: "xterm"}, env.Terminal)
+
+	environment[GitProtocolEnv] = "version=3"
+
+	env, err = Parse(getenv)
+	require.ErrorIs(t, err, ErrNotAllowed)
+	require.Equal(t, GitProtocol{}, env.GitProtocol, "no version is valid")
+
+	var envErr *Error
+	require.True(t, errors.As(err, &envErr))
+}
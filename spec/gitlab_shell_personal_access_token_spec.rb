@@ -30,6 +30,12 @@ describe 'bin/gitlab-shell personal_access_token' do
       end
     end
 
+    server.mount_proc('/api/v4/internal/personal_access_token/policy') do |req, res|
+      res.content_type = 'application/json'
+      res.status = 200
+      res.body = { success: true, scopes: %w[api read_api read_user], max_lifetime_days: 365 }.to_json
+    end
+
     server.mount_proc('/api/v4/internal/discover') do |req, res|
       res.status = 200
       res.content_type = 'application/json'
@@ -53,7 +59,7 @@ describe 'bin/gitlab-shell personal_access_token' do
         remote: 
         remote: ========================================================================
         remote: 
-        remote: Usage: personal_access_token <name> <scope1[,scope2,...]> [ttl_days]
+        remote: Usage: personal_access_token <name> <scope1[,scope2,...]> [ttl_days]. Available scopes: api,read_api,read_user
         remote: 
         remote: ========================================================================
         remote: 
@@ -100,6 +106,66 @@ describe 'bin/gitlab-shell personal_access_token' do
       end
     end
 
+    context 'with a terminal' do
+      let(:args) { 'newtoken api' }
+
+      let(:output) do
+        env = {
+          'SSH_CONNECTION'       => 'fake',
+          'SSH_TTY'              => '/dev/pts/0',
+          'SSH_ORIGINAL_COMMAND' => "personal_access_token #{args}"
+        }
+        Open3.popen2e(env, "#{gitlab_shell_path} #{key_id}") do |stdin, stdout|
+          stdin.puts('no')
+          stdout.read
+        end
+      end
+
+      it 'does not create a token when the user declines' do
+        expect(output).to eq(<<~OUTPUT)
+          Name:    newtoken
+          Scopes:  api
+          Expires: #{(Date.today + 30).iso8601}
+
+          Are you sure you want to create this personal access token? (yes/no)
+
+          A personal access token has *not* been created.
+        OUTPUT
+      end
+    end
+
+    context 'with an unknown scope' do
+      let(:args) { 'newtoken write_repository' }
+
+      it 'prints the available scopes' do
+        expect(output).to eq(<<~OUTPUT)
+          remote: 
+          remote: ========================================================================
+          remote: 
+          remote: Invalid scope: 'write_repository'. Available scopes: api,read_api,read_user
+          remote: 
+          remote: ========================================================================
+          remote: 
+        OUTPUT
+      end
+    end
+
+    context 'with a ttl above the maximum' do
+      let(:args) { 'newtoken api 400' }
+
+      it 'prints the maximum ttl' do
+        expect(output).to eq(<<~OUTPUT)
+          remote: 
+          remote: ========================================================================
+          remote: 
+          remote: Invalid value for days_ttl: '400'. The maximum is 365 days
+          remote: 
+          remote: ========================================================================
+          remote: 
+        OUTPUT
+      end
+    end
+
     context 'with an API error response' do
       let(:args) { 'newtoken api' }
       let(:key_id) { 'key-000' }